@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrAddressChangeLimited = errors.New("адрес посылки сейчас менять нельзя")
+
+// AddressChangePolicy ограничивает смену адреса посылки до отправки.
+// Нулевые поля не ограничивают. Смены учитываются, пока политика
+// действует: до её включения история смен не ведётся.
+type AddressChangePolicy struct {
+	// MaxChanges — сколько раз можно сменить адрес посылки
+	MaxChanges int
+	// Cooldown — сколько ждать после смены адреса до следующей
+	Cooldown time.Duration
+}
+
+// enabled сообщает, ограничивает ли политика смену адреса
+func (p AddressChangePolicy) enabled() bool {
+	return p.MaxChanges > 0 || p.Cooldown > 0
+}
+
+// AddressChangeError — смена адреса отклонена политикой AddressChangePolicy
+type AddressChangeError struct {
+	Number int
+	// Changes — сколько раз адрес уже меняли
+	Changes int
+	// RetryAt — когда адрес можно будет сменить; нулевое — лимит смен исчерпан
+	RetryAt time.Time
+}
+
+func (e *AddressChangeError) Error() string {
+	if e.RetryAt.IsZero() {
+		return fmt.Sprintf("посылка № %d: адрес уже меняли %d раз, больше нельзя", e.Number, e.Changes)
+	}
+
+	return fmt.Sprintf("посылка № %d: адрес можно сменить не раньше %s", e.Number, e.RetryAt.Format(time.RFC3339))
+}
+
+func (e *AddressChangeError) Unwrap() error {
+	return ErrAddressChangeLimited
+}
+
+// WithAddressChangePolicy ограничивает число и частоту смен адреса
+// посылки до отправки, по умолчанию смена адреса не ограничена.
+// Отклонённые попытки записываются в журнал изменений, см. WithAuditLog.
+func WithAddressChangePolicy(p AddressChangePolicy) StoreOption {
+	return func(s *ParcelStore) {
+		s.addressChanges = p
+	}
+}
+
+// setAddressLimited меняет адрес по политике s.addressChanges: в одной
+// транзакции проверяет историю смен, меняет адрес и учитывает смену
+func (s ParcelStore) setAddressLimited(ctx context.Context, number int, address string) (sql.Result, error) {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		changes int
+		last    time.Time
+	)
+	err = tx.QueryRow("SELECT COUNT(*), COALESCE(MAX(changed_at), '') FROM {address_change} WHERE parcel = :parcel",
+		sql.Named("parcel", number)).Scan(&changes, scanTime(&last))
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	policy := s.addressChanges
+	switch {
+	case policy.MaxChanges > 0 && changes >= policy.MaxChanges:
+		return nil, &AddressChangeError{Number: number, Changes: changes}
+	case policy.Cooldown > 0 && !last.IsZero() && now.Before(last.Add(policy.Cooldown)):
+		return nil, &AddressChangeError{Number: number, Changes: changes, RetryAt: last.Add(policy.Cooldown)}
+	}
+
+	res, err := tx.Exec("UPDATE {parcel} SET address = :address WHERE number = :number AND status = :status",
+		sql.Named("address", address),
+		sql.Named("number", number),
+		sql.Named("status", ParcelStatusRegistered))
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		// посылки нет или её уже отправили — смены не было
+		return res, nil
+	}
+
+	_, err = tx.Exec("INSERT INTO {address_change} (parcel, address, changed_at) VALUES (:parcel, :address, :changed_at)",
+		sql.Named("parcel", number),
+		sql.Named("address", address),
+		sql.Named("changed_at", formatTime(now)))
+	if err != nil {
+		return nil, err
+	}
+
+	return res, tx.Commit()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAddressChangePolicy проверяет лимит и частоту смены адреса
+func TestAddressChangePolicy(t *testing.T) {
+	// prepare
+	clock := NewManualClock(time.Now().UTC().Truncate(time.Millisecond))
+	store := NewParcelStore(openTestDB(t), WithClock(clock),
+		WithAddressChangePolicy(AddressChangePolicy{MaxChanges: 2, Cooldown: time.Hour}))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// check
+	require.NoError(t, store.SetAddress(number, "Тверь"))
+
+	err = store.SetAddress(number, "Псков")
+	var limited *AddressChangeError
+	require.ErrorAs(t, err, &limited)
+	require.ErrorIs(t, err, ErrAddressChangeLimited)
+	require.Equal(t, 1, limited.Changes)
+	require.True(t, clock.Now().Add(time.Hour).Equal(limited.RetryAt))
+
+	clock.Advance(time.Hour)
+	require.NoError(t, store.SetAddress(number, "Псков"))
+
+	clock.Advance(time.Hour)
+	err = store.SetAddressContext(context.Background(), number, "Тула")
+	require.ErrorAs(t, err, &limited)
+	require.Equal(t, 2, limited.Changes)
+	require.True(t, limited.RetryAt.IsZero())
+
+	p, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, "Псков", p.Address)
+
+	// смена адреса отправленной посылки не считается
+	other, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatus(other, ParcelStatusRegistered, ParcelStatusSent))
+	require.ErrorIs(t, store.SetAddressContext(context.Background(), other, "Тверь"), ErrNotRegistered)
+	count := 0
+	require.NoError(t, store.db.QueryRow("SELECT COUNT(*) FROM {address_change}").Scan(&count))
+	require.Equal(t, 2, count)
+}
+
+// TestAuditedAddressRejected проверяет журналирование отклонённой смены адреса
+func TestAuditedAddressRejected(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithAddressChangePolicy(AddressChangePolicy{MaxChanges: 1}))
+	audited := NewAuditedStorage(store, store, "ivanov")
+	number, err := audited.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, audited.SetAddress(number, "Тверь"))
+
+	// check
+	require.ErrorIs(t, audited.SetAddress(number, "Псков"), ErrAddressChangeLimited)
+	entries, err := store.ListAudit(AuditFilter{Parcel: number})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	rejected := entries[2]
+	require.Equal(t, AuditSetAddressRejected, rejected.Action)
+	require.Equal(t, "ivanov", rejected.Operator)
+	require.Len(t, rejected.Changes, 1)
+	require.JSONEq(t, `"Тверь"`, string(rejected.Changes[0].Before))
+	require.JSONEq(t, `"Псков"`, string(rejected.Changes[0].After))
+}
@@ -0,0 +1,1270 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultVolumeDays — за сколько дней по умолчанию возвращаются объёмы регистраций
+const defaultVolumeDays = 30
+
+// AdminHandler обслуживает служебные эндпоинты, чтобы эксплуатации
+// не требовался прямой доступ к БД. Почти все они только для чтения,
+// изменения ограничены разбором аномалий и недоставленных сообщений
+// и переключением флагов функциональности.
+type AdminHandler struct {
+	store  ParcelStore
+	errors *ErrorLog
+}
+
+// NewAdminHandler возвращает обработчик эндпоинтов /admin/*.
+// Ошибки обработки запросов записываются в журнал errors.
+func NewAdminHandler(store ParcelStore, errors *ErrorLog) http.Handler {
+	h := AdminHandler{store: store, errors: errors}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/stats", h.getOnly(h.stats))
+	mux.HandleFunc("/admin/storage", h.getOnly(h.lowPriority(h.storage)))
+	mux.HandleFunc("/admin/volumes", h.getOnly(h.lowPriority(h.volumes)))
+	mux.HandleFunc("/admin/daily-stats", h.getOnly(h.dailyStats))
+	mux.HandleFunc("/admin/daily-stats/rebuild", h.postOnly(h.idempotent(h.rebuildDailyStats)))
+	mux.HandleFunc("/admin/overdue", h.getOnly(h.lowPriority(h.overdue)))
+	mux.HandleFunc("/admin/errors", h.getOnly(h.recentErrors))
+	mux.HandleFunc("/admin/reports/sla", h.getOnly(h.lowPriority(h.slaReport)))
+	mux.HandleFunc("/admin/reports/operators", h.getOnly(h.lowPriority(h.operatorReport)))
+	mux.HandleFunc("/admin/reports/weight", h.getOnly(h.lowPriority(h.weightReport)))
+	mux.HandleFunc("/admin/anomalies", h.getOnly(h.anomalies))
+	mux.HandleFunc("/admin/anomalies/ack", h.postOnly(h.idempotent(h.acknowledgeAnomaly)))
+	mux.HandleFunc("/admin/dead-letters", h.getOnly(h.deadLetters))
+	mux.HandleFunc("/admin/audit", h.getOnly(h.lowPriority(h.auditLog)))
+	mux.HandleFunc("/admin/audit/diff", h.getOnly(h.lowPriority(h.auditDiff)))
+	mux.HandleFunc("/admin/flags", h.idempotent(h.featureFlags))
+	mux.HandleFunc("/admin/flags/delete", h.postOnly(h.idempotent(h.deleteFeatureFlag)))
+	mux.HandleFunc("/admin/dead-letters/requeue", h.postOnly(h.idempotent(h.deadLetterAction(h.store.RequeueDeadLetter))))
+	mux.HandleFunc("/admin/dead-letters/discard", h.postOnly(h.idempotent(h.deadLetterAction(h.store.DiscardDeadLetter))))
+	mux.HandleFunc("/admin/invoices", h.getOnly(h.invoices))
+	mux.HandleFunc("/admin/invoices/generate", h.postOnly(h.idempotent(h.generateInvoice)))
+	mux.HandleFunc("/admin/invoices/export", h.getOnly(h.lowPriority(h.exportInvoice)))
+	mux.HandleFunc("/admin/invoices/paid", h.postOnly(h.idempotent(h.markInvoicePaid)))
+	// ответ с ключом API не должен сохраняться в idempotency_key
+	mux.HandleFunc("/admin/partners", h.partners)
+	mux.HandleFunc("/admin/partners/quota", h.postOnly(h.idempotent(h.setPartnerQuota)))
+	mux.HandleFunc("/admin/partners/sandbox-key", h.postOnly(h.issueSandboxKey))
+	// ответ с секретом подписи тоже
+	mux.HandleFunc("/admin/webhooks", h.webhooks)
+	mux.HandleFunc("/admin/webhooks/delete", h.postOnly(h.idempotent(h.deleteWebhook)))
+	mux.HandleFunc("/admin/webhooks/limits", h.postOnly(h.idempotent(h.setWebhookLimits)))
+	mux.HandleFunc("/admin/webhooks/events", h.getOnly(h.webhookEvents))
+	// повтор не идемпотентен: каждый вызов снова ставит события получателю
+	mux.HandleFunc("/admin/replay", h.postOnly(h.replay))
+	mux.HandleFunc("/admin/sandbox/advance", h.postOnly(h.advanceSandbox))
+	mux.HandleFunc("/admin/numbers/reserve", h.postOnly(h.idempotent(h.reserveNumbers)))
+	mux.HandleFunc("/admin/operation-reviews", h.getOnly(h.operationReviews))
+	mux.HandleFunc("/admin/operation-reviews/resolve", h.postOnly(h.idempotent(h.resolveOperationReview)))
+	mux.HandleFunc("/admin/vehicles", h.idempotent(h.vehicles))
+	mux.HandleFunc("/admin/load-plans", h.idempotent(h.loadPlans))
+	mux.HandleFunc("/admin/shipments", h.postOnly(h.idempotent(h.createShipment)))
+	mux.HandleFunc("/admin/shipments/manifest", h.getOnly(h.lowPriority(h.exportManifest)))
+	mux.HandleFunc("/admin/temperature", h.idempotent(h.temperature))
+	mux.HandleFunc("/admin/weight", h.postOnly(h.idempotent(h.recordWeight)))
+	mux.HandleFunc("/admin/recipient-token", h.postOnly(h.issueRecipientToken))
+	mux.HandleFunc("/admin/debug/sql", h.idempotent(h.statementTracing))
+
+	return mux
+}
+
+// getOnly отклоняет запросы с методом, отличным от GET
+func (h AdminHandler) getOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// postOnly отклоняет запросы с методом, отличным от POST
+func (h AdminHandler) postOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (h AdminHandler) stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.store.Stats()
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	writeJSON(w, stats)
+}
+
+// storage отдаёт число строк таблиц и размеры файлов БД на момент запроса
+func (h AdminHandler) storage(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.store.StorageStats(r.Context())
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	writeJSON(w, stats)
+}
+
+func (h AdminHandler) volumes(w http.ResponseWriter, r *http.Request) {
+	days := defaultVolumeDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 366 {
+			http.Error(w, "days должен быть числом от 1 до 366", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	volumes, err := h.store.DailyVolumes(days)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if volumes == nil {
+		// пустой список кодируется как [], а не null
+		volumes = []DailyVolume{}
+	}
+
+	writeJSON(w, volumes)
+}
+
+// statsRange читает период сводки from–to в формате 2006-01-02;
+// ошибка — текст ответа 400
+func statsRange(r *http.Request) (time.Time, time.Time, error) {
+	from, err := time.Parse(dateLayout, r.FormValue("from"))
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("from должна быть в формате " + dateLayout)
+	}
+	to, err := time.Parse(dateLayout, r.FormValue("to"))
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("to должна быть в формате " + dateLayout)
+	}
+
+	return from, to, nil
+}
+
+// dailyStats отдаёт посчитанную сводку по суткам с from по to включительно
+func (h AdminHandler) dailyStats(w http.ResponseWriter, r *http.Request) {
+	from, to, err := statsRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := h.store.DailyStats(r.Context(), from, to)
+	switch {
+	case errors.Is(err, ErrInvalidStatsRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		if stats == nil {
+			stats = []DailyStat{}
+		}
+		writeJSON(w, stats)
+	}
+}
+
+// rebuildDailyStats пересчитывает сводку за сутки с from по to, например
+// после загрузки статусов задним числом
+func (h AdminHandler) rebuildDailyStats(w http.ResponseWriter, r *http.Request) {
+	from, to, err := statsRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.store.MaterializeDailyStats(r.Context(), from, to)
+	switch {
+	case errors.Is(err, ErrInvalidStatsRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h AdminHandler) overdue(w http.ResponseWriter, r *http.Request) {
+	parcels, err := h.store.ListOverdue()
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if parcels == nil {
+		parcels = []Parcel{}
+	}
+
+	writeJSON(w, parcels)
+}
+
+func (h AdminHandler) recentErrors(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.errors.Recent())
+}
+
+// slaReport отдаёт CSV-отчёт о сроках доставки за последнюю завершённую
+// неделю или месяц, period=week по умолчанию
+func (h AdminHandler) slaReport(w http.ResponseWriter, r *http.Request) {
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = ReportWeekly
+	}
+
+	report, err := h.store.SLAReport(period, h.store.now())
+	if errors.Is(err, ErrUnknownReportPeriod) {
+		http.Error(w, "period должен быть week или month", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"sla-%s-%s.csv\"", period, report.From.Format(dateLayout)))
+	if err := report.WriteCSV(w); err != nil {
+		h.errors.Record(err)
+	}
+}
+
+// operatorReport отдаёт CSV-отчёт о работе операторов по сменам длиной
+// shift (по умолчанию DefaultShiftLength) за период from–to в RFC 3339,
+// по умолчанию за прошлые сутки
+func (h AdminHandler) operatorReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f, err := auditFilterFromQuery(url.Values{"from": q["from"], "to": q["to"]})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to := LastDay(h.store.now())
+	if !f.From.IsZero() {
+		from = f.From
+	}
+	if !f.To.IsZero() {
+		to = f.To
+	}
+	shift := DefaultShiftLength
+	if v := q.Get("shift"); v != "" {
+		if shift, err = time.ParseDuration(v); err != nil {
+			http.Error(w, "shift должен быть длительностью, например 8h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := h.store.OperatorReport(from, to, shift)
+	if errors.Is(err, ErrInvalidShiftReport) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"operators-%s.csv\"", report.From.Format(dateLayout)))
+	if err := report.WriteCSV(w); err != nil {
+		h.errors.Record(err)
+	}
+}
+
+// weightReport отдаёт CSV-отчёт о расхождениях заявленного и измеренного
+// веса за период from–to в RFC 3339, по умолчанию за прошлые сутки
+func (h AdminHandler) weightReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f, err := auditFilterFromQuery(url.Values{"from": q["from"], "to": q["to"]})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to := LastDay(h.store.now())
+	if !f.From.IsZero() {
+		from = f.From
+	}
+	if !f.To.IsZero() {
+		to = f.To
+	}
+
+	report, err := h.store.WeightDiscrepancies(from, to)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"weight-%s.csv\"", report.From.Format(dateLayout)))
+	if err := report.WriteCSV(w); err != nil {
+		h.errors.Record(err)
+	}
+}
+
+// anomalies отдаёт открытые аномалии, с all=1 — и подтверждённые
+func (h AdminHandler) anomalies(w http.ResponseWriter, r *http.Request) {
+	anomalies, err := h.store.ListAnomalies(r.URL.Query().Get("all") == "1")
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if anomalies == nil {
+		anomalies = []Anomaly{}
+	}
+
+	writeJSON(w, anomalies)
+}
+
+// acknowledgeAnomaly подтверждает аномалию id от имени оператора operator
+func (h AdminHandler) acknowledgeAnomaly(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "id должен быть числом", http.StatusBadRequest)
+		return
+	}
+
+	err = h.store.AcknowledgeAnomaly(id, r.FormValue("operator"))
+	switch {
+	case errors.Is(err, ErrEmptyOperator):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrAnomalyNotOpen):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// auditLog отдаёт журнал изменений с фильтрами parcel, operator,
+// request_id и интервалом from–to в RFC 3339
+func (h AdminHandler) auditLog(w http.ResponseWriter, r *http.Request) {
+	f, err := auditFilterFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.store.ListAudit(f)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+
+	writeJSON(w, entries)
+}
+
+// auditDiff отдаёт итоговое изменение полей посылки parcel за период
+// from–to в формате RFC 3339
+func (h AdminHandler) auditDiff(w http.ResponseWriter, r *http.Request) {
+	f, err := auditFilterFromQuery(r.URL.Query())
+	if err == nil && f.Parcel == 0 {
+		err = errors.New("не указан parcel")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d, err := h.store.GetAuditDiff(f.Parcel, f.From, f.To)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	writeJSON(w, d)
+}
+
+// auditFilterFromQuery разбирает фильтр журнала из параметров parcel,
+// operator, request_id, from и to; ошибка — текст ответа 400
+func auditFilterFromQuery(q url.Values) (AuditFilter, error) {
+	f := AuditFilter{Operator: q.Get("operator"), RequestID: q.Get("request_id")}
+
+	if v := q.Get("parcel"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return AuditFilter{}, errors.New("parcel должен быть числом")
+		}
+		f.Parcel = n
+	}
+	for _, p := range []struct {
+		name string
+		dest *time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return AuditFilter{}, errors.New(p.name + " должен быть в формате RFC 3339")
+			}
+			*p.dest = t
+		}
+	}
+
+	return f, nil
+}
+
+// featureFlags по GET отдаёт все флаги функциональности, по POST
+// включает или выключает флаг name арендатора tenant (enabled=true|false)
+func (h AdminHandler) featureFlags(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		flags, err := h.store.ListFeatureFlags()
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		if flags == nil {
+			flags = []FeatureFlag{}
+		}
+		writeJSON(w, flags)
+	case http.MethodPost:
+		enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+		if err != nil {
+			http.Error(w, "enabled должен быть true или false", http.StatusBadRequest)
+			return
+		}
+		err = h.store.SetFeatureFlag(FeatureFlag{Name: r.FormValue("name"), Tenant: r.FormValue("tenant"), Enabled: enabled})
+		switch {
+		case errors.Is(err, ErrInvalidFlag):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// deleteFeatureFlag удаляет флаг name арендатора tenant
+func (h AdminHandler) deleteFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	if err := h.store.DeleteFeatureFlag(r.FormValue("name"), r.FormValue("tenant")); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deadLetters отдаёт недоставленные сообщения outbox
+func (h AdminHandler) deadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := h.store.ListDeadLetters(outboxBatch)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if letters == nil {
+		letters = []DeadLetter{}
+	}
+
+	writeJSON(w, letters)
+}
+
+// deadLetterAction возвращает обработчик, применяющий action
+// к недоставленному сообщению id
+func (h AdminHandler) deadLetterAction(action func(id int) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "id должен быть числом", http.StatusBadRequest)
+			return
+		}
+
+		err = action(id)
+		switch {
+		case errors.Is(err, ErrDeadLetterNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// operationReviews отдаёт неразобранные конфликтующие операции курьеров
+func (h AdminHandler) operationReviews(w http.ResponseWriter, r *http.Request) {
+	reviews, err := h.store.ListOperationReviews(outboxBatch)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if reviews == nil {
+		reviews = []OperationReview{}
+	}
+
+	writeJSON(w, reviews)
+}
+
+// resolveOperationReview разбирает отложенную операцию id решением
+// action: apply или discard
+func (h AdminHandler) resolveOperationReview(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "id должен быть числом", http.StatusBadRequest)
+		return
+	}
+
+	err = h.store.ForRequest(r.Context()).ResolveOperationReview(id, r.FormValue("action"))
+	switch {
+	case errors.Is(err, ErrInvalidResolution):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrReviewNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrReviewResolved), isDeliveryUnconfirmed(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// vehicles отдаёт типы транспортных средств и добавляет новый по POST
+// с name, max_weight в граммах и max_parcels
+func (h AdminHandler) vehicles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		vehicles, err := h.store.ListVehicles()
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		if vehicles == nil {
+			vehicles = []Vehicle{}
+		}
+		writeJSON(w, vehicles)
+	case http.MethodPost:
+		v := Vehicle{Name: r.FormValue("name")}
+		var err error
+		if s := r.FormValue("max_weight"); s != "" {
+			if v.MaxWeight, err = strconv.ParseInt(s, 10, 64); err != nil {
+				http.Error(w, "max_weight должен быть числом", http.StatusBadRequest)
+				return
+			}
+		}
+		if s := r.FormValue("max_parcels"); s != "" {
+			if v.MaxParcels, err = strconv.Atoi(s); err != nil {
+				http.Error(w, "max_parcels должно быть числом", http.StatusBadRequest)
+				return
+			}
+		}
+		v.ID, err = h.store.AddVehicle(v)
+		switch {
+		case errors.Is(err, ErrInvalidVehicle):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			w.WriteHeader(http.StatusCreated)
+			writeJSON(w, v)
+		}
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// loadPlans отдаёт план загрузки id, а по POST планирует рейсы курьера
+// courier на день date. Посылки parcels через запятую; без них берётся
+// маршрут курьера на этот день.
+func (h AdminHandler) loadPlans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "id должен быть числом", http.StatusBadRequest)
+			return
+		}
+		plan, err := h.store.GetLoadPlan(id)
+		switch {
+		case errors.Is(err, ErrLoadPlanNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			writeJSON(w, plan)
+		}
+	case http.MethodPost:
+		courier, err := strconv.Atoi(r.FormValue("courier"))
+		if err != nil {
+			http.Error(w, "courier должен быть числом", http.StatusBadRequest)
+			return
+		}
+		date, err := time.Parse(dateLayout, r.FormValue("date"))
+		if err != nil {
+			http.Error(w, "date должна быть в формате "+dateLayout, http.StatusBadRequest)
+			return
+		}
+		var parcels []int
+		if v := r.FormValue("parcels"); v != "" {
+			for _, part := range strings.Split(v, ",") {
+				number, err := strconv.Atoi(strings.TrimSpace(part))
+				if err != nil {
+					http.Error(w, "parcels должны быть номерами через запятую", http.StatusBadRequest)
+					return
+				}
+				parcels = append(parcels, number)
+			}
+		}
+
+		plan, err := h.store.PlanLoads(courier, date, parcels)
+		switch {
+		case errors.Is(err, ErrInvalidLoadRequest):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ErrNoVehicles):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			w.WriteHeader(http.StatusCreated)
+			writeJSON(w, plan)
+		}
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// createShipment собирает посылки parcels (номера через запятую) в
+// отправку перевозчику carrier
+func (h AdminHandler) createShipment(w http.ResponseWriter, r *http.Request) {
+	var parcels []int
+	for _, part := range strings.Split(r.FormValue("parcels"), ",") {
+		number, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			http.Error(w, "parcels должны быть номерами через запятую", http.StatusBadRequest)
+			return
+		}
+		parcels = append(parcels, number)
+	}
+
+	sh, err := h.store.CreateShipment(r.FormValue("carrier"), parcels)
+	switch {
+	case errors.Is(err, ErrInvalidShipment):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, sh)
+	}
+}
+
+// exportManifest отдаёт манифест отправки id в CSV, с format=pdf — в PDF,
+// с format=zip — архив этикеток посылок
+func (h AdminHandler) exportManifest(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "id должен быть числом", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "pdf" && format != "zip" {
+		http.Error(w, "format должен быть csv, pdf или zip", http.StatusBadRequest)
+		return
+	}
+
+	m, err := h.store.GenerateManifest(id)
+	if errors.Is(err, ErrShipmentNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	switch format {
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"manifest-%d.pdf\"", m.Shipment))
+		err = m.WritePDF(w)
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"labels-%d.zip\"", m.Shipment))
+		err = m.WriteLabels(w)
+	default:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"manifest-%d.csv\"", m.Shipment))
+		err = m.WriteCSV(w)
+	}
+	if err != nil {
+		h.errors.Record(err)
+	}
+}
+
+// temperature отдаёт показания температуры посылки parcel, а по POST
+// записывает показание celsius, снятое в at (RFC 3339, пусто — сейчас)
+func (h AdminHandler) temperature(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	number, err := strconv.Atoi(r.FormValue("parcel"))
+	if err != nil {
+		http.Error(w, "parcel должен быть числом", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		readings, err := h.store.ListTemperatureReadings(number)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		if readings == nil {
+			readings = []TemperatureReading{}
+		}
+		writeJSON(w, readings)
+		return
+	}
+
+	celsius, err := strconv.ParseFloat(r.FormValue("celsius"), 64)
+	if err != nil {
+		http.Error(w, "celsius должна быть числом", http.StatusBadRequest)
+		return
+	}
+	var at time.Time
+	if v := r.FormValue("at"); v != "" {
+		if at, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "at должно быть в формате RFC 3339", http.StatusBadRequest)
+			return
+		}
+	}
+
+	reading, err := h.store.AddTemperatureReading(number, celsius, at)
+	switch {
+	case errors.Is(err, ErrInvalidTemperature):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrNotRefrigerated):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, reading)
+	}
+}
+
+// invoices отдаёт счета клиента client без строк
+func (h AdminHandler) invoices(w http.ResponseWriter, r *http.Request) {
+	client, err := strconv.Atoi(r.URL.Query().Get("client"))
+	if err != nil {
+		http.Error(w, "client должен быть числом", http.StatusBadRequest)
+		return
+	}
+
+	invoices, err := h.store.ListInvoices(client)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if invoices == nil {
+		invoices = []Invoice{}
+	}
+
+	writeJSON(w, invoices)
+}
+
+// generateInvoice выставляет счёт клиенту client за месяц month в формате
+// 2006-01 в валюте currency, по умолчанию DefaultCurrency
+func (h AdminHandler) generateInvoice(w http.ResponseWriter, r *http.Request) {
+	client, err := strconv.Atoi(r.FormValue("client"))
+	if err != nil {
+		http.Error(w, "client должен быть числом", http.StatusBadRequest)
+		return
+	}
+	month, err := time.Parse("2006-01", r.FormValue("month"))
+	if err != nil {
+		http.Error(w, "month должен быть в формате 2006-01", http.StatusBadRequest)
+		return
+	}
+
+	inv, err := h.store.GenerateInvoice(client, month, r.FormValue("currency"))
+	switch {
+	case errors.Is(err, ErrNothingToInvoice):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrInvoiceExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, inv)
+	}
+}
+
+// exportInvoice отдаёт счёт id в CSV или, с format=pdf, в PDF
+func (h AdminHandler) exportInvoice(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "id должен быть числом", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "pdf" {
+		http.Error(w, "format должен быть csv или pdf", http.StatusBadRequest)
+		return
+	}
+
+	inv, err := h.store.GetInvoice(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "счёт не найден", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"invoice-%d.%s\"", inv.ID, format))
+	if format == "pdf" {
+		w.Header().Set("Content-Type", "application/pdf")
+		err = inv.WritePDF(w)
+	} else {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		err = inv.WriteCSV(w)
+	}
+	if err != nil {
+		h.errors.Record(err)
+	}
+}
+
+// markInvoicePaid отмечает оплату счёта id
+func (h AdminHandler) markInvoicePaid(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "id должен быть числом", http.StatusBadRequest)
+		return
+	}
+
+	err = h.store.MarkInvoicePaid(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "счёт не найден", http.StatusNotFound)
+	case errors.Is(err, ErrInvoicePaid):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// partners по GET отдаёт партнёров с регистрациями за текущий месяц,
+// по POST регистрирует партнёра id с названием name, адресом email
+// и месячной квотой quota и отдаёт его ключ API
+func (h AdminHandler) partners(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		partners, err := h.store.ListPartners(h.store.now())
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		if partners == nil {
+			partners = []Partner{}
+		}
+		writeJSON(w, partners)
+	case http.MethodPost:
+		quota := 0
+		if v := r.FormValue("quota"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "quota должна быть числом", http.StatusBadRequest)
+				return
+			}
+			quota = n
+		}
+		p := Partner{ID: r.FormValue("id"), Name: r.FormValue("name"), Email: r.FormValue("email"), MonthlyQuota: quota}
+		key, err := h.store.RegisterPartner(p)
+		switch {
+		case errors.Is(err, ErrInvalidPartner):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrPartnerExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			w.WriteHeader(http.StatusCreated)
+			writeJSON(w, map[string]string{"id": p.ID, "api_key": key})
+		}
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// setPartnerQuota меняет месячную квоту quota партнёра id
+func (h AdminHandler) setPartnerQuota(w http.ResponseWriter, r *http.Request) {
+	quota, err := strconv.Atoi(r.FormValue("quota"))
+	if err != nil {
+		http.Error(w, "quota должна быть числом", http.StatusBadRequest)
+		return
+	}
+
+	err = h.store.SetPartnerQuota(r.FormValue("id"), quota)
+	switch {
+	case errors.Is(err, ErrInvalidPartner):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "партнёр не найден", http.StatusNotFound)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// webhooks отдаёт адреса уведомлений партнёра partner (GET) или добавляет
+// партнёру адрес url со схемой version, по умолчанию последней (POST);
+// секрет подписи возвращается только при добавлении
+func (h AdminHandler) webhooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		endpoints, err := h.store.ListWebhookEndpoints(r.URL.Query().Get("partner"))
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		if endpoints == nil {
+			endpoints = []WebhookEndpoint{}
+		}
+		writeJSON(w, endpoints)
+	case http.MethodPost:
+		version := 0
+		if v := r.FormValue("version"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "version должна быть числом", http.StatusBadRequest)
+				return
+			}
+			version = n
+		}
+		e, err := h.store.AddWebhookEndpoint(r.FormValue("partner"), r.FormValue("url"), version)
+		switch {
+		case errors.Is(err, ErrInvalidWebhookEndpoint):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "партнёр не найден", http.StatusNotFound)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			w.WriteHeader(http.StatusCreated)
+			writeJSON(w, map[string]any{"id": e.ID, "url": e.URL, "version": e.Version, "secret": e.Secret})
+		}
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// deleteWebhook удаляет адрес уведомлений id
+func (h AdminHandler) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "id должен быть числом", http.StatusBadRequest)
+		return
+	}
+
+	err = h.store.DeleteWebhookEndpoint(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "адрес уведомлений не найден", http.StatusNotFound)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// setWebhookLimits задаёт адресу уведомлений id одновременные отправки
+// concurrency и ограничение rate уведомлений в секунду
+func (h AdminHandler) setWebhookLimits(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "id должен быть числом", http.StatusBadRequest)
+		return
+	}
+	concurrency, err := strconv.Atoi(r.FormValue("concurrency"))
+	if err != nil {
+		http.Error(w, "concurrency должно быть числом", http.StatusBadRequest)
+		return
+	}
+	rate, err := strconv.ParseFloat(r.FormValue("rate"), 64)
+	if err != nil {
+		http.Error(w, "rate должно быть числом", http.StatusBadRequest)
+		return
+	}
+
+	err = h.store.SetWebhookLimits(id, concurrency, rate)
+	switch {
+	case errors.Is(err, ErrInvalidWebhookEndpoint):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "адрес уведомлений не найден", http.StatusNotFound)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// webhookEvents отдаёт последние события адреса уведомлений endpoint
+func (h AdminHandler) webhookEvents(w http.ResponseWriter, r *http.Request) {
+	endpoint, err := strconv.Atoi(r.URL.Query().Get("endpoint"))
+	if err != nil {
+		http.Error(w, "endpoint должен быть числом", http.StatusBadRequest)
+		return
+	}
+	events, err := h.store.ListWebhookEvents(endpoint, webhookBatch)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if events == nil {
+		events = []WebhookEvent{}
+	}
+	writeJSON(w, events)
+}
+
+// replay повторяет события посылки parcel и/или периода from–to в адрес
+// уведомлений endpoint или в тему topic брокера, см. ReplayEvents
+func (h AdminHandler) replay(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	audit, err := auditFilterFromQuery(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f := ReplayFilter{Parcel: audit.Parcel, From: audit.From, To: audit.To}
+
+	var sink ReplaySink
+	switch endpoint, topic := r.FormValue("endpoint"), r.FormValue("topic"); {
+	case endpoint != "" && topic == "":
+		id, err := strconv.Atoi(endpoint)
+		if err != nil {
+			http.Error(w, "endpoint должен быть числом", http.StatusBadRequest)
+			return
+		}
+		sink = WebhookReplaySink{Store: h.store, Endpoint: id}
+	case topic != "" && endpoint == "":
+		if h.store.publisher == nil {
+			http.Error(w, ErrNoPublisher.Error(), http.StatusConflict)
+			return
+		}
+		sink = TopicReplaySink{Publisher: h.store.publisher, Topic: topic}
+	default:
+		http.Error(w, "нужен ровно один получатель: endpoint или topic", http.StatusBadRequest)
+		return
+	}
+
+	n, err := h.store.ReplayEvents(r.Context(), f, sink)
+	switch {
+	case errors.Is(err, ErrInvalidReplayFilter):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "адрес уведомлений не найден", http.StatusNotFound)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		writeJSON(w, map[string]int{"replayed": n})
+	}
+}
+
+// issueSandboxKey выдаёт партнёру id новый ключ API песочницы
+func (h AdminHandler) issueSandboxKey(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	key, err := h.store.IssueSandboxKey(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "партнёр не найден", http.StatusNotFound)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]string{"id": id, "sandbox_key": key})
+	}
+}
+
+// issueRecipientToken выдаёт токен получателя посылки parcel, чтобы
+// поддержка могла передать его получателю
+func (h AdminHandler) issueRecipientToken(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.FormValue("parcel"))
+	if err != nil {
+		http.Error(w, "parcel должен быть числом", http.StatusBadRequest)
+		return
+	}
+
+	p, err := h.store.Get(number)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "посылка не найдена", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	token, err := h.store.RecipientToken(p.UUID)
+	switch {
+	case errors.Is(err, ErrRecipientTokensDisabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]string{"code": p.UUID, "token": token})
+	}
+}
+
+// advanceSandbox продвигает посылку песочницы number в статус status,
+// без status — в следующий статус основного пути
+func (h AdminHandler) advanceSandbox(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.FormValue("number"))
+	if err != nil {
+		http.Error(w, "number должен быть числом", http.StatusBadRequest)
+		return
+	}
+
+	status, err := h.store.Sandbox().ForRequest(r.Context()).AdvanceSandbox(number, r.FormValue("status"))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "посылка не найдена", http.StatusNotFound)
+	case errors.Is(err, ErrNoSandboxTransition), errors.Is(err, ErrStatusChanged):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		writeJSON(w, map[string]any{"number": number, "status": status})
+	}
+}
+
+// reserveNumbers резервирует count номеров посылок для печати этикеток.
+// С ключом идемпотентности повтор запроса получает тот же блок номеров.
+func (h AdminHandler) reserveNumbers(w http.ResponseWriter, r *http.Request) {
+	count, err := strconv.Atoi(r.FormValue("count"))
+	if err != nil {
+		http.Error(w, "count должен быть числом", http.StatusBadRequest)
+		return
+	}
+
+	numbers, err := h.store.ReserveNumbers(count)
+	switch {
+	case errors.Is(err, ErrInvalidReservation):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrReservationUnsupported):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]any{"numbers": numbers})
+	}
+}
+
+// fail записывает ошибку в журнал и отвечает клиенту 500 без подробностей
+func (h AdminHandler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	h.errors.RecordContext(r.Context(), err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// writeJSON отправляет v клиенту в формате JSON
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// statementTracing отдаёт и переключает отладочную трассировку запросов
+// хранилища, см. StatementTracer
+func (h AdminHandler) statementTracing(w http.ResponseWriter, r *http.Request) {
+	if h.store.tracer == nil {
+		http.Error(w, ErrNoStatementTracer.Error(), http.StatusConflict)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+		if err != nil {
+			http.Error(w, "enabled должен быть true или false", http.StatusBadRequest)
+			return
+		}
+		h.store.tracer.SetEnabled(enabled)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, map[string]bool{"enabled": h.store.tracer.Enabled()})
+}
+
+// recordWeight записывает вес grams посылки parcel, измеренный оператором
+// operator, см. RecordMeasuredWeight
+func (h AdminHandler) recordWeight(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.FormValue("parcel"))
+	if err != nil {
+		http.Error(w, "parcel должен быть числом", http.StatusBadRequest)
+		return
+	}
+	grams, err := strconv.ParseInt(r.FormValue("grams"), 10, 64)
+	if err != nil {
+		http.Error(w, "grams должен быть числом", http.StatusBadRequest)
+		return
+	}
+
+	m, err := h.store.ForRequest(r.Context()).RecordMeasuredWeight(number, grams, r.FormValue("operator"))
+	switch {
+	case errors.Is(err, ErrInvalidMeasurement):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "посылка не найдена", http.StatusNotFound)
+	case err != nil:
+		h.fail(w, r, err)
+	default:
+		writeJSON(w, m)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAdminStats проверяет эндпоинт сводки по статусам
+func TestAdminStats(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	_, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	handler := NewAdminHandler(store, NewErrorLog(10))
+
+	// request
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+
+	// check
+	require.Equal(t, http.StatusOK, rec.Code)
+	var stats Stats
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	require.Equal(t, 1, stats.Total)
+	require.Equal(t, 1, stats.ByStatus[ParcelStatusRegistered])
+}
+
+// TestAdminEndpoints проверяет коды ответов служебных эндпоинтов
+func TestAdminEndpoints(t *testing.T) {
+	// prepare
+	errLog := NewErrorLog(10)
+	errLog.Record(errors.New("test"))
+	handler := NewAdminHandler(NewParcelStore(openTestDB(t)), errLog)
+
+	// check
+	for _, c := range []struct {
+		method string
+		target string
+		code   int
+		body   string
+	}{
+		{http.MethodGet, "/admin/volumes", http.StatusOK, "[]\n"},
+		{http.MethodGet, "/admin/volumes?days=0", http.StatusBadRequest, ""},
+		{http.MethodGet, "/admin/overdue", http.StatusOK, "[]\n"},
+		{http.MethodPost, "/admin/stats", http.StatusMethodNotAllowed, ""},
+		{http.MethodGet, "/admin/unknown", http.StatusNotFound, ""},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(c.method, c.target, nil))
+		require.Equal(t, c.code, rec.Code, c.target)
+		if c.body != "" {
+			require.Equal(t, c.body, rec.Body.String(), c.target)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/errors", nil))
+	var records []ErrorRecord
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &records))
+	require.Len(t, records, 1)
+	require.Equal(t, "test", records[0].Message)
+}
+
+// TestErrorLog проверяет вытеснение старых ошибок из журнала
+func TestErrorLog(t *testing.T) {
+	errLog := NewErrorLog(2)
+	errLog.Record(errors.New("first"))
+	errLog.Record(nil)
+	errLog.Record(errors.New("second"))
+	errLog.Record(errors.New("third"))
+
+	records := errLog.Recent()
+	require.Len(t, records, 2)
+	require.Equal(t, "third", records[0].Message)
+	require.Equal(t, "second", records[1].Message)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// consistencyInterval — как часто фоновая проверка ищет нарушения согласованности
+const consistencyInterval = time.Hour
+
+const (
+	// AnomalyDeliveredBeforeSent — доставка раньше отправки или без неё
+	AnomalyDeliveredBeforeSent = "delivered_before_sent"
+	// AnomalySentBeforeRegistered — отправка раньше регистрации
+	AnomalySentBeforeRegistered = "sent_before_registered"
+	// AnomalyMissingDeliveryTime — статус delivered без времени доставки
+	AnomalyMissingDeliveryTime = "missing_delivery_time"
+	// AnomalyWeightMismatch — вес описи вложения расходится с заявленным
+	// больше чем на ItemWeightTolerance, см. CheckItemWeight
+	AnomalyWeightMismatch = "weight_mismatch"
+)
+
+// itemsWeight — суммарный вес описи посылки из внешнего запроса по {parcel}
+const itemsWeight = "(SELECT SUM(i.quantity * i.unit_weight) FROM {parcel_item} i WHERE i.parcel = number)"
+
+var ErrAnomalyNotOpen = errors.New("аномалия не найдена или уже подтверждена")
+
+// Anomaly — нарушение согласованности данных посылки, найденное проверкой
+type Anomaly struct {
+	ID     int       `json:"id"`
+	Parcel int       `json:"parcel"`
+	Kind   string    `json:"kind"`
+	Detail string    `json:"detail"`
+	Found  time.Time `json:"found_at"`
+	// AcknowledgedBy и AcknowledgedAt заполняются, когда оператор
+	// разобрал аномалию; у открытой пустые
+	AcknowledgedBy string    `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt time.Time `json:"acknowledged_at"`
+}
+
+// anomalyRules — условия на строку parcel для каждого вида аномалии
+// и текст пояснения; пустое время хранится как ”
+var anomalyRules = []struct {
+	kind   string
+	where  string
+	detail string
+}{
+	{AnomalyDeliveredBeforeSent, "delivered_at != '' AND (sent_at = '' OR delivered_at < sent_at)",
+		"'доставлена ' || delivered_at || ', отправлена ' || sent_at"},
+	{AnomalySentBeforeRegistered, "sent_at != '' AND sent_at < created_at",
+		"'отправлена ' || sent_at || ', зарегистрирована ' || created_at"},
+	{AnomalyMissingDeliveryTime, "status = :delivered AND delivered_at = ''",
+		"'статус delivered без времени доставки'"},
+	{AnomalyWeightMismatch, "weight > 0 AND ABS(" + itemsWeight + " - weight) > weight * :weight_tolerance",
+		"'позиции ' || " + itemsWeight + " || ' г, заявлено ' || weight || ' г'"},
+}
+
+// CheckConsistency ищет посылки с невозможной последовательностью событий
+// и записывает находки в таблицу аномалий. Уже найденная аномалия того же
+// вида по посылке повторно не добавляется, даже если подтверждена.
+// Возвращает количество новых находок.
+func (s ParcelStore) CheckConsistency() (int, error) {
+	now := formatTime(s.now())
+
+	found := 0
+	for _, rule := range anomalyRules {
+		res, err := s.db.Exec("INSERT INTO {anomaly} (parcel, kind, detail, found_at) "+
+			"SELECT number, :kind, "+rule.detail+", :found_at FROM {parcel} WHERE "+rule.where+" "+
+			"ON CONFLICT (parcel, kind) DO NOTHING",
+			sql.Named("kind", rule.kind),
+			sql.Named("found_at", now),
+			sql.Named("delivered", ParcelStatusDelivered),
+			sql.Named("weight_tolerance", ItemWeightTolerance))
+		if err != nil {
+			return found, err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return found, err
+		}
+		found += int(affected)
+	}
+
+	return found, nil
+}
+
+// ListAnomalies возвращает аномалии в порядке обнаружения, подтверждённые —
+// только при all
+func (s ParcelStore) ListAnomalies(all bool) ([]Anomaly, error) {
+	rows, err := s.db.Query("SELECT id, parcel, kind, detail, found_at, acknowledged_by, acknowledged_at "+
+		"FROM {anomaly} WHERE :all OR acknowledged_at = '' ORDER BY id",
+		sql.Named("all", all))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Anomaly
+	for rows.Next() {
+		a := Anomaly{}
+		err := rows.Scan(&a.ID, &a.Parcel, &a.Kind, &a.Detail, scanTime(&a.Found), &a.AcknowledgedBy, scanTime(&a.AcknowledgedAt))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// AcknowledgeAnomaly отмечает аномалию разобранной оператором
+func (s ParcelStore) AcknowledgeAnomaly(id int, operator string) error {
+	if operator == "" {
+		return ErrEmptyOperator
+	}
+
+	res, err := s.db.Exec("UPDATE {anomaly} SET acknowledged_by = :operator, acknowledged_at = :now "+
+		"WHERE id = :id AND acknowledged_at = ''",
+		sql.Named("operator", operator),
+		sql.Named("now", formatTime(s.now())),
+		sql.Named("id", id))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAnomalyNotOpen
+	}
+
+	return nil
+}
+
+// RunConsistencyCheck каждые interval запускает CheckConsistency, пока
+// не отменён ctx. Ошибки записываются в errors.
+func (s ParcelStore) RunConsistencyCheck(ctx context.Context, interval time.Duration, errors *ErrorLog) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.CheckConsistency(); err != nil && ctx.Err() == nil {
+			errors.Record(err)
+		}
+	}
+}
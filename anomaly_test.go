@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestCheckConsistency проверяет поиск невозможных последовательностей событий
+func TestCheckConsistency(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	// время в будущем, чтобы отправка не оказалась раньше регистрации
+	now := time.Now().UTC().Add(time.Hour)
+
+	ok, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatus(ok, ParcelStatusRegistered, ParcelStatusSent))
+	require.NoError(t, store.TransitionStatus(ok, ParcelStatusSent, ParcelStatusDelivered))
+
+	early, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	_, err = store.db.Exec("UPDATE {parcel} SET status = :status, sent_at = :sent_at, delivered_at = :delivered_at WHERE number = :number",
+		sql.Named("status", ParcelStatusDelivered),
+		sql.Named("sent_at", formatTime(now)),
+		sql.Named("delivered_at", formatTime(now.Add(-time.Hour))),
+		sql.Named("number", early))
+	require.NoError(t, err)
+
+	untimed, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	_, err = store.db.Exec("UPDATE {parcel} SET status = :status WHERE number = :number",
+		sql.Named("status", ParcelStatusDelivered),
+		sql.Named("number", untimed))
+	require.NoError(t, err)
+
+	// check
+	found, err := store.CheckConsistency()
+	require.NoError(t, err)
+	require.Equal(t, 2, found)
+
+	// повторная проверка находки не дублирует
+	found, err = store.CheckConsistency()
+	require.NoError(t, err)
+	require.Zero(t, found)
+
+	anomalies, err := store.ListAnomalies(false)
+	require.NoError(t, err)
+	require.Len(t, anomalies, 2)
+	require.Equal(t, early, anomalies[0].Parcel)
+	require.Equal(t, AnomalyDeliveredBeforeSent, anomalies[0].Kind)
+	require.Equal(t, untimed, anomalies[1].Parcel)
+	require.Equal(t, AnomalyMissingDeliveryTime, anomalies[1].Kind)
+}
+
+// TestAcknowledgeAnomaly проверяет разбор аномалии через эндпоинты
+func TestAcknowledgeAnomaly(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	_, err = store.db.Exec("UPDATE {parcel} SET status = :status WHERE number = :number",
+		sql.Named("status", ParcelStatusDelivered),
+		sql.Named("number", number))
+	require.NoError(t, err)
+	_, err = store.CheckConsistency()
+	require.NoError(t, err)
+	handler := NewAdminHandler(store, NewErrorLog(10))
+
+	anomalies, err := store.ListAnomalies(false)
+	require.NoError(t, err)
+	require.Len(t, anomalies, 1)
+	id := strconv.Itoa(anomalies[0].ID)
+
+	// acknowledge
+	ack := func(form url.Values) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/anomalies/ack", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	require.Equal(t, http.StatusBadRequest, ack(url.Values{"id": {id}}))
+	require.Equal(t, http.StatusNoContent, ack(url.Values{"id": {id}, "operator": {"ivanov"}}))
+	require.Equal(t, http.StatusNotFound, ack(url.Values{"id": {id}, "operator": {"ivanov"}}))
+
+	// check
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/anomalies", nil))
+	require.Equal(t, "[]\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/anomalies?all=1", nil))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &anomalies))
+	require.Len(t, anomalies, 1)
+	require.Equal(t, "ivanov", anomalies[0].AcknowledgedBy)
+}
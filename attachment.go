@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxAttachmentSize — максимальный размер вложения в байтах
+const MaxAttachmentSize = 10 << 20
+
+var ErrInvalidAttachment = errors.New("некорректное вложение")
+
+// Attachment — файл, прикреплённый к посылке: фотография, скан, подпись.
+// Kind определяет назначение вложения.
+type Attachment struct {
+	ID          int
+	Parcel      int
+	Kind        string
+	Name        string
+	ContentType string
+	Data        []byte
+	CreatedAt   time.Time
+}
+
+// Validate проверяет заполненность полей и размер вложения
+func (a Attachment) Validate() error {
+	switch {
+	case a.Kind == "":
+		return fmt.Errorf("%w: не указано назначение", ErrInvalidAttachment)
+	case a.ContentType == "":
+		return fmt.Errorf("%w: не указан тип содержимого", ErrInvalidAttachment)
+	case len(a.Data) == 0:
+		return fmt.Errorf("%w: пустой файл", ErrInvalidAttachment)
+	case len(a.Data) > MaxAttachmentSize:
+		return fmt.Errorf("%w: размер %d байт больше допустимого", ErrInvalidAttachment, len(a.Data))
+	}
+
+	return nil
+}
+
+// execer — общая часть *sql.DB и *sql.Tx для выполнения изменяющих запросов
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// AddAttachment прикрепляет файл к посылке
+func (s ParcelStore) AddAttachment(a Attachment) (int, error) {
+	return s.insertAttachment(s.db, a)
+}
+
+// insertAttachment добавляет вложение в рамках переданного соединения или транзакции
+func (s ParcelStore) insertAttachment(db execer, a Attachment) (int, error) {
+	if err := a.Validate(); err != nil {
+		return 0, err
+	}
+
+	res, err := db.Exec("INSERT INTO {attachment} (parcel, kind, name, content_type, data, created_at) "+
+		"VALUES (:parcel, :kind, :name, :content_type, :data, :created_at)",
+		sql.Named("parcel", a.Parcel),
+		sql.Named("kind", a.Kind),
+		sql.Named("name", a.Name),
+		sql.Named("content_type", a.ContentType),
+		sql.Named("data", a.Data),
+		sql.Named("created_at", formatTime(s.now())))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetAttachment возвращает вложение вместе с содержимым
+func (s ParcelStore) GetAttachment(id int) (Attachment, error) {
+	row := s.db.QueryRow("SELECT id, parcel, kind, name, content_type, data, created_at "+
+		"FROM {attachment} WHERE id = :id",
+		sql.Named("id", id))
+
+	a := Attachment{}
+	err := row.Scan(&a.ID, &a.Parcel, &a.Kind, &a.Name, &a.ContentType, &a.Data, scanTime(&a.CreatedAt))
+	if err != nil {
+		return a, err
+	}
+
+	return a, nil
+}
+
+// ListAttachments возвращает вложения посылки заданного назначения без содержимого.
+// Пустой kind возвращает вложения любого назначения.
+func (s ParcelStore) ListAttachments(number int, kind string) ([]Attachment, error) {
+	rows, err := s.db.Query("SELECT id, parcel, kind, name, content_type, created_at "+
+		"FROM {attachment} WHERE parcel = :parcel AND (:kind = '' OR kind = :kind) ORDER BY id",
+		sql.Named("parcel", number),
+		sql.Named("kind", kind))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Attachment
+	for rows.Next() {
+		a := Attachment{}
+		err := rows.Scan(&a.ID, &a.Parcel, &a.Kind, &a.Name, &a.ContentType, scanTime(&a.CreatedAt))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
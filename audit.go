@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// auditCompactionInterval — как часто сжимается журнал изменений
+const auditCompactionInterval = 24 * time.Hour
+
+const (
+	AuditAdd        = "add"
+	AuditSetStatus  = "set_status"
+	AuditTransition = "transition_status"
+	AuditSetAddress = "set_address"
+	// AuditSetAddressRejected — смена адреса отклонена AddressChangePolicy;
+	// в Changes — отклонённый адрес
+	AuditSetAddressRejected = "set_address_rejected"
+	AuditDelete             = "delete"
+	AuditCODCollected       = "cod_collected"
+	AuditSetMetadata        = "set_metadata"
+	// AuditReprice — пересчёт стоимости после взвешивания, см. RecordMeasuredWeight
+	AuditReprice = "reprice"
+)
+
+// DefaultAuditOperator — оператор журнала изменений, сделанных вне
+// запроса с известным оператором: фоновыми задачами и самим трекером
+const DefaultAuditOperator = "system"
+
+// AuditEntry — запись журнала изменений. Before и After — посылка в JSON
+// до и после изменения, null — посылки нет.
+type AuditEntry struct {
+	ID       int             `json:"id"`
+	Parcel   int             `json:"parcel"`
+	Operator string          `json:"operator"`
+	Action   string          `json:"action"`
+	Before   json.RawMessage `json:"before"`
+	After    json.RawMessage `json:"after"`
+	At       time.Time       `json:"at"`
+	// RequestID — идентификатор HTTP-запроса, пустой для изменений вне запроса
+	RequestID string `json:"request_id,omitempty"`
+	// Changes — изменённые поля посылки, см. AuditChange
+	Changes []AuditChange `json:"changes"`
+}
+
+// AuditChange — изменение одного поля посылки. Field — имя поля в JSON
+// посылки, Before и After — значения в JSON, null — поля не было.
+type AuditChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// AuditDiff — итоговое изменение посылки за период по журналу: поля,
+// значения которых в конце периода отличаются от значений в начале
+type AuditDiff struct {
+	Parcel int       `json:"parcel"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	// Entries — сколько записей журнала пришлось на период
+	Entries int           `json:"entries"`
+	Changes []AuditChange `json:"changes"`
+}
+
+// auditIgnoredFields — поля, которые не попадают в изменения: updated_at
+// меняется при любом изменении посылки и ничего не добавляет
+var auditIgnoredFields = map[string]bool{"updated_at": true}
+
+// diffParcels сравнивает посылки в JSON по полям и возвращает изменённые
+// поля в порядке имён
+func diffParcels(before, after json.RawMessage) ([]AuditChange, error) {
+	var b, a map[string]json.RawMessage
+	if len(before) != 0 {
+		if err := json.Unmarshal(before, &b); err != nil {
+			return nil, err
+		}
+	}
+	if len(after) != 0 {
+		if err := json.Unmarshal(after, &a); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := make([]string, 0, len(a))
+	for field := range a {
+		fields = append(fields, field)
+	}
+	for field := range b {
+		if _, ok := a[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := []AuditChange{}
+	for _, field := range fields {
+		if auditIgnoredFields[field] {
+			continue
+		}
+		bv, av := auditValue(b[field]), auditValue(a[field])
+		if string(bv) != string(av) {
+			changes = append(changes, AuditChange{Field: field, Before: bv, After: av})
+		}
+	}
+
+	return changes, nil
+}
+
+// auditValue возвращает значение поля, null — поля нет
+func auditValue(v json.RawMessage) json.RawMessage {
+	if v == nil {
+		return json.RawMessage("null")
+	}
+
+	return v
+}
+
+// AuditFilter — условия выборки журнала, нулевые поля не ограничивают.
+// Интервал [From, To).
+type AuditFilter struct {
+	Parcel    int
+	Operator  string
+	RequestID string
+	From      time.Time
+	To        time.Time
+}
+
+// AuditRecorder сохраняет записи журнала изменений
+type AuditRecorder interface {
+	RecordAudit(e AuditEntry) error
+}
+
+// AuditableStorage — хранилище, изменения которого журналирует
+// AuditedStorage: обе версии интерфейса
+type AuditableStorage interface {
+	ParcelStorage
+	ParcelStorageV2
+}
+
+// AuditedStorage — декоратор хранилища, записывающий в журнал каждое
+// успешное изменение посылки от имени оператора через обе версии
+// интерфейса. Чтение не журналируется. Декоратор создаётся на оператора,
+// например на запрос.
+//
+// В отличие от ParcelStorage SetAddress и Delete возвращают
+// ErrNotRegistered, если посылка не в статусе registered: несостоявшееся
+// изменение в журнал не попадает. ParcelStore журналирует изменения сам,
+// см. WithAuditLog, оборачивать его нужно только без этой опции.
+type AuditedStorage struct {
+	ParcelStorage
+	ParcelStorageV2
+	audit     AuditRecorder
+	operator  string
+	requestID string
+}
+
+var (
+	_ ParcelStorage   = AuditedStorage{}
+	_ ParcelStorageV2 = AuditedStorage{}
+)
+
+// NewAuditedStorage оборачивает store журналированием изменений
+// от имени operator в audit
+func NewAuditedStorage(store AuditableStorage, audit AuditRecorder, operator string) AuditedStorage {
+	return AuditedStorage{ParcelStorage: store, ParcelStorageV2: store, audit: audit, operator: operator}
+}
+
+// ForRequest возвращает копию декоратора, помечающую записи журнала
+// идентификатором запроса из ctx, см. RequestIDMiddleware
+func (s AuditedStorage) ForRequest(ctx context.Context) AuditedStorage {
+	s.requestID = RequestIDFromContext(ctx)
+	return s
+}
+
+// auditor возвращает журналирование от имени оператора декоратора.
+// Идентификатор запроса берётся из ForRequest, а без него — из ctx.
+func (s AuditedStorage) auditor(ctx context.Context) auditor {
+	a := auditor{get: s.ParcelStorageV2.GetContext, audit: s.audit, operator: s.operator, requestID: s.requestID}
+	if a.requestID == "" {
+		a.requestID = RequestIDFromContext(ctx)
+	}
+
+	return a
+}
+
+func (s AuditedStorage) Add(p Parcel) (int, error) {
+	number, err := s.AddContext(context.Background(), p)
+
+	return number, v1Error(err)
+}
+
+func (s AuditedStorage) SetStatus(number int, status string) error {
+	return v1Error(s.SetStatusContext(context.Background(), number, status))
+}
+
+func (s AuditedStorage) TransitionStatus(number int, from string, to string) error {
+	return v1Error(s.TransitionStatusContext(context.Background(), number, from, to))
+}
+
+func (s AuditedStorage) SetAddress(number int, address string) error {
+	return v1Error(s.SetAddressContext(context.Background(), number, address))
+}
+
+func (s AuditedStorage) Delete(number int) error {
+	return v1Error(s.DeleteContext(context.Background(), number))
+}
+
+func (s AuditedStorage) AddContext(ctx context.Context, p Parcel, opts ...CallOption) (int, error) {
+	number, err := s.ParcelStorageV2.AddContext(ctx, p, opts...)
+	if err != nil {
+		return number, err
+	}
+
+	return number, s.auditor(ctx).record(ctx, number, AuditAdd, nil)
+}
+
+func (s AuditedStorage) SetStatusContext(ctx context.Context, number int, status string, opts ...CallOption) error {
+	return s.auditor(ctx).change(ctx, number, AuditSetStatus, func() error {
+		return s.ParcelStorageV2.SetStatusContext(ctx, number, status, opts...)
+	})
+}
+
+func (s AuditedStorage) TransitionStatusContext(ctx context.Context, number int, from string, to string, opts ...CallOption) error {
+	return s.auditor(ctx).change(ctx, number, AuditTransition, func() error {
+		return s.ParcelStorageV2.TransitionStatusContext(ctx, number, from, to, opts...)
+	})
+}
+
+// SetAddressContext меняет адрес и журналирует смену. Попытка, отклонённая
+// AddressChangePolicy, тоже журналируется, чтобы были видны попытки
+// обойти ограничение.
+func (s AuditedStorage) SetAddressContext(ctx context.Context, number int, address string, opts ...CallOption) error {
+	a := s.auditor(ctx)
+	err := a.change(ctx, number, AuditSetAddress, func() error {
+		return s.ParcelStorageV2.SetAddressContext(ctx, number, address, opts...)
+	})
+	if !errors.Is(err, ErrAddressChangeLimited) {
+		return err
+	}
+
+	if rerr := a.recordRejectedAddress(ctx, number, address); rerr != nil {
+		return errors.Join(err, rerr)
+	}
+
+	return err
+}
+
+func (s AuditedStorage) DeleteContext(ctx context.Context, number int, opts ...CallOption) error {
+	return s.auditor(ctx).change(ctx, number, AuditDelete, func() error {
+		return s.ParcelStorageV2.DeleteContext(ctx, number, opts...)
+	})
+}
+
+// WithAuditLog включает журнал изменений посылок: каждое успешное
+// изменение записывается в audit_log от имени оператора и с
+// идентификатором запроса из контекста вызова или ForRequest, а если
+// оператор неизвестен — от имени operator
+func WithAuditLog(operator string) StoreOption {
+	return func(s *ParcelStore) {
+		s.auditOperator = operator
+	}
+}
+
+// auditActor — оператор и запрос, от имени которых журналируются изменения
+type auditActor struct {
+	operator  string
+	requestID string
+}
+
+// ForRequest возвращает копию хранилища, которая журналирует изменения
+// от имени оператора и с идентификатором запроса из ctx, см.
+// OperatorMiddleware и RequestIDMiddleware. Нужна для методов без
+// контекста; методы с контекстом берут их из своего ctx.
+func (s ParcelStore) ForRequest(ctx context.Context) ParcelStore {
+	s.actor = auditActor{operator: OperatorFromContext(ctx), requestID: RequestIDFromContext(ctx)}
+	return s
+}
+
+// auditor возвращает журналирование от имени оператора и запроса из ctx,
+// а если их там нет — из ForRequest и WithAuditLog
+func (s ParcelStore) auditor(ctx context.Context) auditor {
+	a := auditor{get: s.GetContext, audit: s, operator: s.actor.operator, requestID: s.actor.requestID}
+	if operator := OperatorFromContext(ctx); operator != "" {
+		a.operator = operator
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		a.requestID = id
+	}
+	if a.operator == "" {
+		a.operator = s.auditOperator
+	}
+
+	return a
+}
+
+// audited выполняет изменение посылки number и журналирует его,
+// если журнал включён WithAuditLog
+func (s ParcelStore) audited(ctx context.Context, number int, action string, fn func() error) error {
+	if s.auditOperator == "" {
+		return fn()
+	}
+
+	return s.auditor(ctx).change(ctx, number, action, fn)
+}
+
+// recordAudit журналирует уже применённое изменение посылки number
+// с состоянием до него before, если журнал включён WithAuditLog
+func (s ParcelStore) recordAudit(ctx context.Context, number int, action string, before *Parcel) error {
+	if s.auditOperator == "" {
+		return nil
+	}
+
+	return s.auditor(ctx).record(ctx, number, action, before)
+}
+
+// auditor записывает в audit изменения посылок, прочитанных через get,
+// от имени оператора operator
+type auditor struct {
+	get       func(ctx context.Context, number int, opts ...CallOption) (Parcel, error)
+	audit     AuditRecorder
+	operator  string
+	requestID string
+}
+
+// change читает посылку, выполняет изменение fn и журналирует его.
+// Если посылки нет, изменять и журналировать нечего: ошибку или её
+// отсутствие определяет само изменение.
+func (a auditor) change(ctx context.Context, number int, action string, fn func() error) error {
+	before, err := a.get(ctx, number)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fn()
+	}
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+
+	return a.record(ctx, number, action, &before)
+}
+
+// recordRejectedAddress записывает отклонённую смену адреса на address:
+// Before и After совпадают, отклонённый адрес — в Changes
+func (a auditor) recordRejectedAddress(ctx context.Context, number int, address string) error {
+	p, err := a.get(ctx, number)
+	if err != nil {
+		return fmt.Errorf("журнал изменений: %w", err)
+	}
+
+	e := AuditEntry{Parcel: number, Operator: a.operator, Action: AuditSetAddressRejected, RequestID: a.requestID}
+	if e.Before, err = json.Marshal(p); err != nil {
+		return err
+	}
+	e.After = e.Before
+	current, err := json.Marshal(p.Address)
+	if err != nil {
+		return err
+	}
+	rejected, err := json.Marshal(address)
+	if err != nil {
+		return err
+	}
+	e.Changes = []AuditChange{{Field: "address", Before: current, After: rejected}}
+
+	if err := a.audit.RecordAudit(e); err != nil {
+		return fmt.Errorf("журнал изменений: %w", err)
+	}
+
+	return nil
+}
+
+// record записывает изменение с текущим состоянием посылки как After.
+// Изменение, не затронувшее ни одного поля, журналируется только как
+// явная установка статуса оператором. Ошибку журнала возвращает, хотя
+// изменение уже применено: пропуск записи для журнала аудита хуже
+// повторной попытки.
+func (a auditor) record(ctx context.Context, number int, action string, before *Parcel) error {
+	var after *Parcel
+	p, err := a.get(ctx, number)
+	switch {
+	case err == nil:
+		after = &p
+	case !errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("журнал изменений: %w", err)
+	}
+
+	e := AuditEntry{Parcel: number, Operator: a.operator, Action: action, RequestID: a.requestID}
+	if e.Before, err = json.Marshal(before); err != nil {
+		return err
+	}
+	if e.After, err = json.Marshal(after); err != nil {
+		return err
+	}
+	if e.Changes, err = diffParcels(e.Before, e.After); err != nil {
+		return err
+	}
+	if len(e.Changes) == 0 && action != AuditSetStatus {
+		return nil
+	}
+
+	if err := a.audit.RecordAudit(e); err != nil {
+		return fmt.Errorf("журнал изменений: %w", err)
+	}
+
+	return nil
+}
+
+// RecordAudit сохраняет запись журнала изменений. Если изменения полей
+// не заданы, они вычисляются из Before и After; нулевое время записи —
+// текущее по часам хранилища.
+func (s ParcelStore) RecordAudit(e AuditEntry) error {
+	if e.At.IsZero() {
+		e.At = s.now()
+	}
+	if e.Changes == nil {
+		var err error
+		if e.Changes, err = diffParcels(e.Before, e.After); err != nil {
+			return fmt.Errorf("изменения полей: %w", err)
+		}
+	}
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("INSERT INTO {audit_log} (parcel, operator, action, before, after, at, request_id, changes) "+
+		"VALUES (:parcel, :operator, :action, :before, :after, :at, :request_id, :changes)",
+		sql.Named("changes", string(changes)),
+		sql.Named("parcel", e.Parcel),
+		sql.Named("operator", e.Operator),
+		sql.Named("action", e.Action),
+		sql.Named("before", string(e.Before)),
+		sql.Named("after", string(e.After)),
+		sql.Named("at", formatTime(e.At)),
+		sql.Named("request_id", e.RequestID))
+
+	return err
+}
+
+// ListAudit возвращает записи журнала изменений по фильтру в хронологическом порядке
+func (s ParcelStore) ListAudit(f AuditFilter) ([]AuditEntry, error) {
+	rows, err := s.db.Query("SELECT id, parcel, operator, action, before, after, at, request_id, changes FROM {audit_log} "+
+		"WHERE (:parcel = 0 OR parcel = :parcel) AND (:operator = '' OR operator = :operator) "+
+		"AND (:request_id = '' OR request_id = :request_id) "+
+		"AND (:from = '' OR at >= :from) AND (:to = '' OR at < :to) ORDER BY at, id",
+		sql.Named("parcel", f.Parcel),
+		sql.Named("operator", f.Operator),
+		sql.Named("request_id", f.RequestID),
+		sql.Named("from", formatTime(f.From)),
+		sql.Named("to", formatTime(f.To)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []AuditEntry
+	for rows.Next() {
+		e := AuditEntry{}
+		var before, after, changes string
+		err := rows.Scan(&e.ID, &e.Parcel, &e.Operator, &e.Action, &before, &after, scanTime(&e.At), &e.RequestID, &changes)
+		if err != nil {
+			return nil, err
+		}
+		e.Before, e.After = json.RawMessage(before), json.RawMessage(after)
+		// у записей до миграции 44 изменения полей не сохранены
+		if changes == "" {
+			e.Changes, err = diffParcels(e.Before, e.After)
+		} else {
+			err = json.Unmarshal([]byte(changes), &e.Changes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("изменения полей записи %d: %w", e.ID, err)
+		}
+		res = append(res, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetAuditDiff возвращает итоговое изменение посылки parcel за период
+// [from, to) по журналу: состояние до первой записи периода сравнивается
+// с состоянием после последней. Поле, изменённое и возвращённое обратно,
+// в итог не попадает. Нулевые from и to не ограничивают период.
+func (s ParcelStore) GetAuditDiff(parcel int, from, to time.Time) (AuditDiff, error) {
+	entries, err := s.ListAudit(AuditFilter{Parcel: parcel, From: from, To: to})
+	if err != nil {
+		return AuditDiff{}, err
+	}
+
+	d := AuditDiff{Parcel: parcel, From: from, To: to, Entries: len(entries), Changes: []AuditChange{}}
+	if len(entries) == 0 {
+		return d, nil
+	}
+	if d.Changes, err = diffParcels(entries[0].Before, entries[len(entries)-1].After); err != nil {
+		return AuditDiff{}, err
+	}
+
+	return d, nil
+}
+
+// CompactAudit сжимает журнал изменений старше retention: удаляет только
+// смены статуса, которые не изменили ни одного поля посылки, например
+// повторную установку того же статуса. Добавления, смены адреса, удаления
+// и остальные записи журнала не удаляются никогда. Возвращает число
+// удалённых записей.
+func (s ParcelStore) CompactAudit(retention time.Duration) (int, error) {
+	res, err := s.db.Exec("DELETE FROM {audit_log} WHERE at < :cutoff "+
+		"AND action IN (:set_status, :transition) AND changes = '[]'",
+		sql.Named("cutoff", formatTime(s.now().Add(-retention))),
+		sql.Named("set_status", AuditSetStatus),
+		sql.Named("transition", AuditTransition))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return int(n), err
+}
+
+// RunAuditCompaction каждые interval сжимает журнал изменений старше
+// retention, пока не отменён ctx. Ошибки записываются в errors.
+func (s ParcelStore) RunAuditCompaction(ctx context.Context, retention time.Duration, interval time.Duration, errors *ErrorLog) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.CompactAudit(retention); err != nil {
+			errors.Record(err)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAuditedStorage проверяет запись изменений с состоянием до и после
+func TestAuditedStorage(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	ivanov := NewAuditedStorage(store, store, "ivanov")
+	petrov := NewAuditedStorage(store, store, "petrov")
+	start := time.Now().Add(-time.Second)
+
+	// change
+	number, err := ivanov.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, ivanov.SetAddress(number, "Тверь"))
+	require.NoError(t, petrov.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+	// неудачное изменение не журналируется
+	require.Error(t, petrov.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+	other, err := petrov.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, petrov.Delete(other))
+
+	// check
+	entries, err := store.ListAudit(AuditFilter{Parcel: number})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, AuditAdd, entries[0].Action)
+	require.JSONEq(t, "null", string(entries[0].Before))
+
+	var before, after Parcel
+	require.NoError(t, json.Unmarshal(entries[1].Before, &before))
+	require.NoError(t, json.Unmarshal(entries[1].After, &after))
+	require.Equal(t, "ivanov", entries[1].Operator)
+	require.Equal(t, "test", before.Address)
+	require.Equal(t, "Тверь", after.Address)
+	require.Equal(t, AuditTransition, entries[2].Action)
+
+	entries, err = store.ListAudit(AuditFilter{Operator: "petrov", From: start, To: time.Now().Add(time.Second)})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, AuditDelete, entries[2].Action)
+	require.JSONEq(t, "null", string(entries[2].After))
+
+	entries, err = store.ListAudit(AuditFilter{To: start})
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+// TestAuditedStorageNotRegistered проверяет, что несостоявшиеся удаление
+// и смена адреса возвращают ошибку и не попадают в журнал, а изменения
+// через вторую версию интерфейса помечаются запросом из контекста
+func TestAuditedStorageNotRegistered(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	audited := NewAuditedStorage(store, store, "ivanov")
+	number, err := audited.Add(getTestParcel())
+	require.NoError(t, err)
+	ctx := WithRequestID(context.Background(), "req-1")
+	require.NoError(t, audited.TransitionStatusContext(ctx, number, ParcelStatusRegistered, ParcelStatusSent))
+
+	// check
+	require.ErrorIs(t, audited.Delete(number), ErrNotRegistered)
+	require.ErrorIs(t, audited.SetAddress(number, "Тверь"), ErrNotRegistered)
+	err = audited.DeleteContext(ctx, number)
+	require.ErrorIs(t, err, ErrNotRegistered)
+	var se *StoreError
+	require.ErrorAs(t, err, &se)
+	require.Equal(t, OpDelete, se.Op)
+
+	entries, err := store.ListAudit(AuditFilter{Parcel: number})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, AuditTransition, entries[1].Action)
+	require.Equal(t, "req-1", entries[1].RequestID)
+
+	from, to := LastDay(time.Now().AddDate(0, 0, 1))
+	report, err := store.OperatorReport(from, to, 24*time.Hour)
+	require.NoError(t, err)
+	require.Len(t, report.Rows, 1)
+	require.Zero(t, report.Rows[0].Deletions)
+}
+
+// TestStoreAuditLog проверяет журналирование изменений самим хранилищем:
+// оператор и запрос берутся из контекста, ForRequest или WithAuditLog,
+// несостоявшиеся изменения в журнал не попадают
+func TestStoreAuditLog(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithAuditLog(DefaultAuditOperator))
+	p := getTestParcel()
+	p.CODAmount = 500
+	number, err := store.Add(p)
+	require.NoError(t, err)
+	ctx := WithRequestID(WithOperator(context.Background(), "ivanov"), "req-1")
+	require.NoError(t, store.SetAddressContext(ctx, number, "Тверь"))
+	require.NoError(t, store.ForRequest(ctx).SetStatus(number, ParcelStatusSent))
+	// посылка уже отправлена: ни удаления, ни смены адреса нет
+	require.NoError(t, store.Delete(number))
+	require.NoError(t, store.SetAddress(number, "Псков"))
+	require.NoError(t, store.SetStatus(number, ParcelStatusDelivered))
+	require.NoError(t, store.MarkCODCollected(number, 500, "courier"))
+
+	// check
+	entries, err := store.ListAudit(AuditFilter{Parcel: number})
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+	require.Equal(t, AuditAdd, entries[0].Action)
+	require.Equal(t, DefaultAuditOperator, entries[0].Operator)
+	require.Equal(t, AuditSetAddress, entries[1].Action)
+	require.Equal(t, "ivanov", entries[1].Operator)
+	require.Equal(t, "req-1", entries[1].RequestID)
+	require.Equal(t, AuditSetStatus, entries[2].Action)
+	require.Equal(t, "ivanov", entries[2].Operator)
+	require.Equal(t, "req-1", entries[2].RequestID)
+	require.Equal(t, DefaultAuditOperator, entries[3].Operator)
+	require.Equal(t, AuditCODCollected, entries[4].Action)
+
+	other := NewParcelStore(openTestDB(t))
+	_, err = other.Add(getTestParcel())
+	require.NoError(t, err)
+	entries, err = other.ListAudit(AuditFilter{})
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+// TestHTTPHandlerAudit проверяет, что изменения через служебные эндпоинты
+// журналируются от имени оператора из заголовка
+func TestHTTPHandlerAudit(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithAuditLog(DefaultAuditOperator), WithReweighThreshold(0))
+	p := getTestParcel()
+	p.Weight = 500
+	p.Price = 100
+	number, err := store.Add(p)
+	require.NoError(t, err)
+	handler := NewHTTPHandler(store, NewErrorLog(10))
+
+	post := func(operator string, grams string) int {
+		form := url.Values{"parcel": {strconv.Itoa(number)}, "grams": {grams}, "operator": {"scales"}}
+		req := httptest.NewRequest(http.MethodPost, "/admin/weight", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(OperatorHeader, operator)
+		req.Header.Set(RequestIDHeader, "req-"+operator)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// check
+	require.Equal(t, http.StatusOK, post("petrov", "600"))
+	entries, err := store.ListAudit(AuditFilter{Parcel: number, Operator: "petrov"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, AuditReprice, entries[0].Action)
+	require.Equal(t, "req-petrov", entries[0].RequestID)
+
+	// недопустимое имя заменяется оператором хранилища
+	require.Equal(t, http.StatusOK, post("drop table", "700"))
+	entries, err = store.ListAudit(AuditFilter{Parcel: number})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, AuditReprice, entries[2].Action)
+	require.Equal(t, DefaultAuditOperator, entries[2].Operator)
+}
+
+// TestAdminAudit проверяет выборку журнала через эндпоинт
+func TestAdminAudit(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := NewAuditedStorage(store, store, "ivanov").Add(getTestParcel())
+	require.NoError(t, err)
+	handler := NewAdminHandler(store, NewErrorLog(10))
+
+	// check
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/audit?operator=ivanov&parcel="+strconv.Itoa(number), nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var entries []AuditEntry
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	require.Len(t, entries, 1)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/audit?from=yesterday", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// TestCompactAudit проверяет удаление старых смен статуса, не изменивших
+// посылку, и сохранение остальных записей журнала
+func TestCompactAudit(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	old := time.Now().Add(-10 * 24 * time.Hour)
+	for i, c := range []struct {
+		action string
+		before string
+		after  string
+	}{
+		{AuditAdd, ``, ParcelStatusRegistered},
+		{AuditSetAddress, ParcelStatusRegistered, ParcelStatusRegistered},
+		{AuditSetStatus, ParcelStatusRegistered, ParcelStatusRegistered},
+		{AuditTransition, ParcelStatusRegistered, ParcelStatusSent},
+		{AuditSetStatus, ParcelStatusSent, ParcelStatusSent},
+		{AuditDelete, ParcelStatusSent, ParcelStatusSent},
+	} {
+		before := json.RawMessage(`null`)
+		if c.before != "" {
+			before = json.RawMessage(`{"status":"` + c.before + `"}`)
+		}
+		require.NoError(t, store.RecordAudit(AuditEntry{
+			Parcel: number, Operator: "op", Action: c.action,
+			Before: before, After: json.RawMessage(`{"status":"` + c.after + `"}`),
+			At: old.Add(time.Duration(i) * time.Minute),
+		}))
+	}
+	// свежие записи не сжимаются
+	require.NoError(t, store.RecordAudit(AuditEntry{
+		Parcel: number, Operator: "op", Action: AuditSetStatus,
+		Before: json.RawMessage(`{"status":"sent"}`), After: json.RawMessage(`{"status":"sent"}`), At: time.Now(),
+	}))
+
+	// compact
+	deleted, err := store.CompactAudit(24 * time.Hour)
+	require.NoError(t, err)
+
+	// check
+	require.Equal(t, 2, deleted)
+	entries, err := store.ListAudit(AuditFilter{Parcel: number})
+	require.NoError(t, err)
+	var ids []int
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	require.Equal(t, []int{1, 2, 4, 6, 7}, ids)
+}
+
+// TestCompactAuditStatusReentry проверяет, что сжатие сохраняет каждую
+// смену статуса, даже когда посылка возвращается в прежний статус,
+// и отклонённые смены адреса
+func TestCompactAuditStatusReentry(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	old := time.Now().Add(-10 * 24 * time.Hour)
+	for i, c := range []struct {
+		action string
+		before string
+		after  string
+	}{
+		{AuditAdd, ``, ParcelStatusRegistered},
+		{AuditTransition, ParcelStatusRegistered, ParcelStatusLost},
+		{AuditTransition, ParcelStatusLost, ParcelStatusSent},
+		{AuditTransition, ParcelStatusSent, ParcelStatusLost},
+		{AuditSetStatus, ParcelStatusLost, ParcelStatusLost},
+		{AuditSetAddressRejected, ParcelStatusLost, ParcelStatusLost},
+		{AuditSetAddress, ParcelStatusLost, ParcelStatusLost},
+	} {
+		before := json.RawMessage(`null`)
+		if c.before != "" {
+			before = json.RawMessage(`{"status":"` + c.before + `"}`)
+		}
+		require.NoError(t, store.RecordAudit(AuditEntry{
+			Parcel: number, Operator: "op", Action: c.action,
+			Before: before, After: json.RawMessage(`{"status":"` + c.after + `"}`),
+			At: old.Add(time.Duration(i) * time.Minute),
+		}))
+	}
+
+	// compact
+	deleted, err := store.CompactAudit(24 * time.Hour)
+	require.NoError(t, err)
+
+	// check
+	require.Equal(t, 1, deleted)
+	entries, err := store.ListAudit(AuditFilter{Parcel: number})
+	require.NoError(t, err)
+	var ids []int
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	require.Equal(t, []int{1, 2, 3, 4, 6, 7}, ids)
+}
+
+// TestAuditChanges проверяет изменения полей в записях журнала
+// и итоговое изменение посылки за период
+func TestAuditChanges(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	audited := NewAuditedStorage(store, store, "ivanov")
+	number, err := audited.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, audited.SetAddress(number, "Тверь"))
+	// время в журнале хранится с точностью до миллисекунды
+	time.Sleep(2 * time.Millisecond)
+	mid := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, audited.SetAddress(number, "Псков"))
+	require.NoError(t, audited.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+	require.NoError(t, audited.SetStatus(number, ParcelStatusRegistered))
+
+	// check
+	entries, err := store.ListAudit(AuditFilter{Parcel: number})
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+	require.Equal(t, []AuditChange{
+		{Field: "address", Before: json.RawMessage(`"test"`), After: json.RawMessage(`"Тверь"`)},
+	}, entries[1].Changes)
+	var fields []string
+	for _, c := range entries[3].Changes {
+		fields = append(fields, c.Field)
+	}
+	require.Equal(t, []string{"sent_at", "status"}, fields)
+
+	// статус вернули обратно, в итоге остаются адрес и время отправки
+	d, err := store.GetAuditDiff(number, mid, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, 3, d.Entries)
+	fields = nil
+	for _, c := range d.Changes {
+		fields = append(fields, c.Field)
+	}
+	require.Equal(t, []string{"address", "sent_at"}, fields)
+	require.JSONEq(t, `"Тверь"`, string(d.Changes[0].Before))
+	require.JSONEq(t, `"Псков"`, string(d.Changes[0].After))
+
+	d, err = store.GetAuditDiff(number, time.Time{}, mid)
+	require.NoError(t, err)
+	require.Equal(t, 2, d.Entries)
+	// посылки до первой записи не было — изменены все поля
+	require.Greater(t, len(d.Changes), 10)
+
+	handler := NewAdminHandler(store, NewErrorLog(10))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/audit/diff?parcel="+strconv.Itoa(number)+
+		"&from="+mid.UTC().Format(time.RFC3339Nano), nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"field":"address"`)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/audit/diff", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
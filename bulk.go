@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// DefaultDeleteChunk — число посылок, удаляемых в одной транзакции
+const DefaultDeleteChunk = 500
+
+var ErrEmptyFilter = errors.New("пустой фильтр посылок")
+
+// ParcelFilter отбирает посылки по набору условий, объединённых через AND.
+// Нулевые поля не ограничивают выборку.
+type ParcelFilter struct {
+	Client int
+	Status string
+	Tenant string
+	// CreatedBefore и CreatedAfter ограничивают время регистрации, границы не включаются
+	CreatedBefore time.Time
+	CreatedAfter  time.Time
+	// MetadataKey и MetadataValue отбирают посылки по ключу верхнего уровня
+	// метаданных. Значения сравниваются как текст: строка как есть, число
+	// в десятичной записи, true как 1.
+	MetadataKey   string
+	MetadataValue string
+}
+
+// IsEmpty сообщает, что фильтр не задаёт ни одного условия
+func (f ParcelFilter) IsEmpty() bool {
+	return f == ParcelFilter{}
+}
+
+// where возвращает условие WHERE с именованными параметрами фильтра
+// или пустую строку для пустого фильтра
+func (f ParcelFilter) where() (string, []any) {
+	var conds []string
+	var args []any
+
+	if f.Client != 0 {
+		conds = append(conds, "client = :f_client")
+		args = append(args, sql.Named("f_client", f.Client))
+	}
+	if f.Status != "" {
+		conds = append(conds, "status = :f_status")
+		args = append(args, sql.Named("f_status", f.Status))
+	}
+	if f.Tenant != "" {
+		conds = append(conds, "tenant = :f_tenant")
+		args = append(args, sql.Named("f_tenant", f.Tenant))
+	}
+	if !f.CreatedBefore.IsZero() {
+		conds = append(conds, "created_at < :f_created_before")
+		args = append(args, sql.Named("f_created_before", formatTime(f.CreatedBefore)))
+	}
+	if !f.CreatedAfter.IsZero() {
+		conds = append(conds, "created_at > :f_created_after")
+		args = append(args, sql.Named("f_created_after", formatTime(f.CreatedAfter)))
+	}
+	if f.MetadataKey != "" {
+		conds = append(conds, "CAST(json_extract(metadata, :f_metadata_path) AS TEXT) = :f_metadata_value")
+		args = append(args,
+			sql.Named("f_metadata_path", "$."+f.MetadataKey),
+			sql.Named("f_metadata_value", f.MetadataValue))
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// DeleteWhere удаляет посылки, подходящие под фильтр, порциями по chunk штук,
+// каждую порцию в отдельной транзакции. После каждой порции вызывается progress
+// (если задан) с общим числом удалённых посылок. Пустой фильтр отклоняется,
+// чтобы случайно не очистить таблицу. При ошибке или отмене ctx уже
+// удалённые порции остаются удалёнными, возвращается их количество.
+func (s ParcelStore) DeleteWhere(ctx context.Context, f ParcelFilter, chunk int, progress func(deleted int)) (int, error) {
+	if f.IsEmpty() {
+		return 0, ErrEmptyFilter
+	}
+	if chunk <= 0 {
+		chunk = DefaultDeleteChunk
+	}
+
+	deleted := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+
+		n, err := s.deleteChunk(f, chunk)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+		if n > 0 && progress != nil {
+			progress(deleted)
+		}
+		if n < chunk {
+			return deleted, nil
+		}
+	}
+}
+
+// deleteChunk удаляет в одной транзакции до chunk посылок, подходящих под фильтр
+func (s ParcelStore) deleteChunk(f ParcelFilter, chunk int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	where, args := f.where()
+	rows, err := tx.Query("SELECT number FROM {parcel}"+where+" ORDER BY number LIMIT :chunk",
+		append(args, sql.Named("chunk", chunk))...)
+	if err != nil {
+		return 0, err
+	}
+
+	var numbers []int
+	for rows.Next() {
+		var number int
+		if err := rows.Scan(&number); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		numbers = append(numbers, number)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// состояние до удаления нужно журналу изменений
+	befores := make([]*Parcel, len(numbers))
+	for i, number := range numbers {
+		if s.auditOperator == "" {
+			break
+		}
+		p, err := scanParcel(tx.QueryRow(parcelSelect+"WHERE number = :number", sql.Named("number", number)))
+		if err != nil {
+			return 0, err
+		}
+		befores[i] = &p
+	}
+
+	// связанные строки удаляются каскадно
+	for _, number := range numbers {
+		if _, err := tx.Exec("DELETE FROM {parcel} WHERE number = :number", sql.Named("number", number)); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for i, number := range numbers {
+		if err := s.recordAudit(context.Background(), number, AuditDelete, befores[i]); err != nil {
+			return len(numbers), err
+		}
+	}
+
+	return len(numbers), nil
+}
+
+// PurgeCancelled удаляет отменённые посылки, зарегистрированные раньше,
+// чем olderThan назад, и возвращает их количество
+func (s ParcelStore) PurgeCancelled(ctx context.Context, olderThan time.Duration, progress func(deleted int)) (int, error) {
+	return s.DeleteWhere(ctx, ParcelFilter{
+		Status:        ParcelStatusCancelled,
+		CreatedBefore: s.now().Add(-olderThan),
+	}, DefaultDeleteChunk, progress)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestDeleteWhere проверяет порционное удаление посылок по фильтру
+func TestDeleteWhere(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+
+	var cancelled []int
+	for i := 0; i < 5; i++ {
+		number, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		if i < 3 {
+			require.NoError(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusCancelled))
+			cancelled = append(cancelled, number)
+		}
+	}
+	other := getTestParcel()
+	other.Client = 2000
+	otherNumber, err := store.Add(other)
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatus(otherNumber, ParcelStatusRegistered, ParcelStatusCancelled))
+
+	// пустой фильтр отклоняется
+	_, err = store.DeleteWhere(context.Background(), ParcelFilter{}, 2, nil)
+	require.ErrorIs(t, err, ErrEmptyFilter)
+
+	// delete
+	var progress []int
+	deleted, err := store.DeleteWhere(context.Background(),
+		ParcelFilter{Client: 1000, Status: ParcelStatusCancelled}, 2,
+		func(n int) { progress = append(progress, n) })
+	require.NoError(t, err)
+
+	// check
+	require.Equal(t, 3, deleted)
+	require.Equal(t, []int{2, 3}, progress)
+	for _, number := range cancelled {
+		_, err := store.Get(number)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	}
+	rest, err := store.GetByClient(1000)
+	require.NoError(t, err)
+	require.Len(t, rest, 2)
+	_, err = store.Get(otherNumber)
+	require.NoError(t, err)
+}
+
+// TestPurgeCancelled проверяет удаление только старых отменённых посылок
+func TestPurgeCancelled(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+
+	old := getTestParcel()
+	old.CreatedAt = old.CreatedAt.Add(-48 * time.Hour)
+	oldNumber, err := store.Add(old)
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatus(oldNumber, ParcelStatusRegistered, ParcelStatusCancelled))
+
+	fresh, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatus(fresh, ParcelStatusRegistered, ParcelStatusCancelled))
+
+	// старая, но не отменённая посылка не удаляется
+	active := getTestParcel()
+	active.CreatedAt = active.CreatedAt.Add(-48 * time.Hour)
+	activeNumber, err := store.Add(active)
+	require.NoError(t, err)
+
+	// purge
+	deleted, err := store.PurgeCancelled(context.Background(), 24*time.Hour, nil)
+	require.NoError(t, err)
+
+	// check
+	require.Equal(t, 1, deleted)
+	_, err = store.Get(oldNumber)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = store.Get(fresh)
+	require.NoError(t, err)
+	_, err = store.Get(activeNumber)
+	require.NoError(t, err)
+}
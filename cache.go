@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultParcelCacheSize — сколько посылок по умолчанию хранит ParcelCache
+	DefaultParcelCacheSize = 10000
+	// warmBatch — по сколько посылок читается при прогреве кэша
+	warmBatch = 500
+)
+
+// ParcelCache хранит посылки в памяти по номеру и коду отслеживания не
+// дольше ttl. Смены статуса через хранилище с WithParcelCache сбрасывают
+// посылку сразу, остальные изменения, в том числе из других процессов,
+// видны не позже чем через ttl. Безопасен для нескольких горутин.
+type ParcelCache struct {
+	ttl  time.Duration
+	size int
+
+	mu       sync.Mutex
+	byNumber map[int]cacheEntry
+	byUUID   map[string]int
+}
+
+// cacheEntry — посылка в кэше и срок, до которого она годна
+type cacheEntry struct {
+	parcel  Parcel
+	expires time.Time
+}
+
+// NewParcelCache создаёт кэш не больше чем на size посылок,
+// при size <= 0 — на DefaultParcelCacheSize
+func NewParcelCache(ttl time.Duration, size int) *ParcelCache {
+	if size <= 0 {
+		size = DefaultParcelCacheSize
+	}
+
+	return &ParcelCache{
+		ttl:      ttl,
+		size:     size,
+		byNumber: make(map[int]cacheEntry),
+		byUUID:   make(map[string]int),
+	}
+}
+
+// WithParcelCache подключает кэш посылок к публичному отслеживанию и
+// сбрасывает посылку из кэша при смене её статуса через это хранилище
+func WithParcelCache(c *ParcelCache) StoreOption {
+	return func(s *ParcelStore) {
+		s.cache = c
+		s.hooks = append(s.hooks, Hooks{OnAfterStatusChange: func(sc StatusChange) {
+			c.Invalidate(sc.Number)
+		}})
+	}
+}
+
+// get возвращает посылку number, если она есть в кэше и не устарела
+func (c *ParcelCache) get(number int) (Parcel, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.byNumber[number]
+	if !ok || !time.Now().Before(e.expires) {
+		return Parcel{}, false
+	}
+
+	return e.parcel, true
+}
+
+// getByUUID возвращает посылку по коду отслеживания, если она есть в кэше
+func (c *ParcelCache) getByUUID(id string) (Parcel, bool) {
+	c.mu.Lock()
+	number, ok := c.byUUID[id]
+	c.mu.Unlock()
+	if !ok {
+		return Parcel{}, false
+	}
+
+	return c.get(number)
+}
+
+// put кладёт посылку в кэш. Если кэш полон, сначала выбрасываются
+// устаревшие посылки, а если таких нет — любая.
+func (c *ParcelCache) put(p Parcel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := c.byNumber[p.Number]; !ok && len(c.byNumber) >= c.size {
+		for number, e := range c.byNumber {
+			if !now.Before(e.expires) {
+				c.remove(number)
+			}
+		}
+		for number := range c.byNumber {
+			if len(c.byNumber) < c.size {
+				break
+			}
+			c.remove(number)
+		}
+	}
+
+	c.byNumber[p.Number] = cacheEntry{parcel: p, expires: now.Add(c.ttl)}
+	c.byUUID[p.UUID] = p.Number
+}
+
+// remove выбрасывает посылку number; вызывается под c.mu
+func (c *ParcelCache) remove(number int) {
+	if e, ok := c.byNumber[number]; ok {
+		delete(c.byUUID, e.parcel.UUID)
+		delete(c.byNumber, number)
+	}
+}
+
+// Invalidate выбрасывает посылку number из кэша
+func (c *ParcelCache) Invalidate(number int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(number)
+}
+
+// Len возвращает число посылок в кэше, включая устаревшие
+func (c *ParcelCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.byNumber)
+}
+
+// Warm загружает в кэш до n последних изменённых посылок, чтобы первые
+// запросы отслеживания после развёртывания не ушли в БД все разом.
+// Посылки читаются порциями через ListUpdatedSince начиная с позиции
+// самой старой из n последних изменений. Возвращает число загруженных посылок.
+func (c *ParcelCache) Warm(ctx context.Context, store ParcelStore, n int) (int, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+
+	since, after, err := store.updatedPosition(ctx, n)
+	if err != nil {
+		return 0, err
+	}
+
+	loaded := 0
+	for loaded < n {
+		if err := ctx.Err(); err != nil {
+			return loaded, err
+		}
+
+		limit := min(warmBatch, n-loaded)
+		parcels, err := store.ListUpdatedSince(since, after, limit)
+		if err != nil {
+			return loaded, err
+		}
+		for _, p := range parcels {
+			c.put(p)
+		}
+		loaded += len(parcels)
+		if len(parcels) < limit {
+			return loaded, nil
+		}
+
+		last := parcels[len(parcels)-1]
+		since, after = last.UpdatedAt, last.Number
+	}
+
+	return loaded, nil
+}
+
+// CachedStorage — декоратор ParcelStorageV2, читающий посылки через
+// ParcelCache. Одинаковые параллельные промахи кэша объединяются в одно
+// чтение из БД, см. WithCoalesce. Изменения через декоратор сбрасывают
+// посылку из кэша.
+type CachedStorage struct {
+	ParcelStorageV2
+	cache *ParcelCache
+	// coalesce — операции, одинаковые вызовы которых объединяются
+	coalesce map[string]bool
+	flights  *flightGroup
+}
+
+var _ ParcelStorageV2 = CachedStorage{}
+
+// CacheOption настраивает CachedStorage
+type CacheOption func(*CachedStorage)
+
+// WithCoalesce задаёт операции, одинаковые параллельные вызовы которых
+// объединяются: OpGet, OpGetByUUID, OpGetByClient. По умолчанию
+// объединяются OpGet и OpGetByUUID; WithCoalesce() без операций
+// выключает объединение.
+func WithCoalesce(ops ...string) CacheOption {
+	return func(s *CachedStorage) {
+		s.coalesce = make(map[string]bool, len(ops))
+		for _, op := range ops {
+			s.coalesce[op] = true
+		}
+	}
+}
+
+// NewCachedStorage оборачивает store чтением через cache
+func NewCachedStorage(store ParcelStorageV2, cache *ParcelCache, opts ...CacheOption) CachedStorage {
+	s := CachedStorage{
+		ParcelStorageV2: store,
+		cache:           cache,
+		coalesce:        map[string]bool{OpGet: true, OpGetByUUID: true},
+		flights:         &flightGroup{},
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return s
+}
+
+// read выполняет чтение fn операции op, объединяя одинаковые вызовы
+// с ключом key, если это включено для op
+func (s CachedStorage) read(ctx context.Context, op string, key string, fn func(ctx context.Context) (any, error)) (any, error) {
+	if !s.coalesce[op] {
+		return fn(ctx)
+	}
+
+	v, _, err := s.flights.do(ctx, op+":"+key, fn)
+
+	return v, err
+}
+
+func (s CachedStorage) GetContext(ctx context.Context, number int, opts ...CallOption) (Parcel, error) {
+	if p, ok := s.cache.get(number); ok {
+		return p, nil
+	}
+
+	v, err := s.read(ctx, OpGet, strconv.Itoa(number), func(ctx context.Context) (any, error) {
+		p, err := s.ParcelStorageV2.GetContext(ctx, number, opts...)
+		if err != nil {
+			return nil, err
+		}
+		s.cache.put(p)
+		return p, nil
+	})
+	if err != nil {
+		return Parcel{}, err
+	}
+
+	return v.(Parcel), nil
+}
+
+func (s CachedStorage) GetByUUIDContext(ctx context.Context, id string, opts ...CallOption) (Parcel, error) {
+	if p, ok := s.cache.getByUUID(id); ok {
+		return p, nil
+	}
+
+	v, err := s.read(ctx, OpGetByUUID, id, func(ctx context.Context) (any, error) {
+		p, err := s.ParcelStorageV2.GetByUUIDContext(ctx, id, opts...)
+		if err != nil {
+			return nil, err
+		}
+		s.cache.put(p)
+		return p, nil
+	})
+	if err != nil {
+		return Parcel{}, err
+	}
+
+	return v.(Parcel), nil
+}
+
+// GetByClientContext не кэшируется: набор посылок клиента меняется при
+// каждом добавлении, — но одинаковые параллельные вызовы можно объединить.
+// Объединённые вызовы получают общий срез, менять его нельзя.
+func (s CachedStorage) GetByClientContext(ctx context.Context, client int, opts ...CallOption) ([]Parcel, error) {
+	v, err := s.read(ctx, OpGetByClient, strconv.Itoa(client), func(ctx context.Context) (any, error) {
+		return s.ParcelStorageV2.GetByClientContext(ctx, client, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.([]Parcel), nil
+}
+
+func (s CachedStorage) SetStatusContext(ctx context.Context, number int, status string, opts ...CallOption) error {
+	defer s.cache.Invalidate(number)
+
+	return s.ParcelStorageV2.SetStatusContext(ctx, number, status, opts...)
+}
+
+func (s CachedStorage) TransitionStatusContext(ctx context.Context, number int, from string, to string, opts ...CallOption) error {
+	defer s.cache.Invalidate(number)
+
+	return s.ParcelStorageV2.TransitionStatusContext(ctx, number, from, to, opts...)
+}
+
+func (s CachedStorage) SetAddressContext(ctx context.Context, number int, address string, opts ...CallOption) error {
+	defer s.cache.Invalidate(number)
+
+	return s.ParcelStorageV2.SetAddressContext(ctx, number, address, opts...)
+}
+
+func (s CachedStorage) DeleteContext(ctx context.Context, number int, opts ...CallOption) error {
+	defer s.cache.Invalidate(number)
+
+	return s.ParcelStorageV2.DeleteContext(ctx, number, opts...)
+}
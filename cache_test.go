@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestParcelCacheWarm проверяет прогрев кэша последними изменёнными посылками
+func TestParcelCacheWarm(t *testing.T) {
+	// prepare
+	cache := NewParcelCache(time.Minute, 0)
+	store := NewParcelStore(openTestDB(t), WithParcelCache(cache))
+	var numbers []int
+	for i := 0; i < 4; i++ {
+		number, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		numbers = append(numbers, number)
+	}
+	// первая посылка становится последней изменённой
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, store.SetAddress(numbers[0], "new"))
+
+	// check
+	loaded, err := cache.Warm(context.Background(), store, 2)
+	require.NoError(t, err)
+	require.Equal(t, 2, loaded)
+	_, ok := cache.get(numbers[0])
+	require.True(t, ok)
+	_, ok = cache.get(numbers[3])
+	require.True(t, ok)
+	_, ok = cache.get(numbers[1])
+	require.False(t, ok)
+
+	loaded, err = NewParcelCache(time.Minute, 0).Warm(context.Background(), store, 10)
+	require.NoError(t, err)
+	require.Equal(t, 4, loaded)
+}
+
+// TestCachedStorage проверяет чтение через кэш и его сброс при изменениях
+func TestCachedStorage(t *testing.T) {
+	// prepare
+	cache := NewParcelCache(time.Minute, 0)
+	store := NewParcelStore(openTestDB(t), WithParcelCache(cache))
+	cached := NewCachedStorage(store, cache)
+	ctx := context.Background()
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	p, err := cached.GetContext(ctx, number)
+	require.NoError(t, err)
+
+	// check
+	// посылка из кэша не перечитывается, даже если её изменили мимо хранилища
+	_, err = store.db.Exec("UPDATE {parcel} SET address = 'direct' WHERE number = :number", sql.Named("number", number))
+	require.NoError(t, err)
+	got, err := cached.GetByUUIDContext(ctx, p.UUID)
+	require.NoError(t, err)
+	require.Equal(t, p.Address, got.Address)
+
+	// смена статуса через хранилище сбрасывает кэш хуком
+	require.NoError(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+	got, err = cached.GetContext(ctx, number)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, got.Status)
+	require.Equal(t, "direct", got.Address)
+
+	_, err = cached.GetContext(ctx, number+1)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+}
+
+// TestParcelCacheEviction проверяет ограничение размера и срок хранения
+func TestParcelCacheEviction(t *testing.T) {
+	// prepare
+	cache := NewParcelCache(time.Minute, 2)
+	for i := 1; i <= 3; i++ {
+		p := getTestParcel()
+		p.Number = i
+		cache.put(p)
+	}
+
+	// check
+	require.Equal(t, 2, cache.Len())
+
+	expired := NewParcelCache(0, 0)
+	p := getTestParcel()
+	p.Number = 1
+	expired.put(p)
+	_, ok := expired.getByUUID(p.UUID)
+	require.False(t, ok)
+}
+
+// countingStorage считает чтения посылки и задерживает их до закрытия release
+type countingStorage struct {
+	ParcelStorageV2
+	release chan struct{}
+	reads   atomic.Int32
+}
+
+func (s *countingStorage) GetContext(ctx context.Context, number int, opts ...CallOption) (Parcel, error) {
+	s.reads.Add(1)
+	<-s.release
+
+	return s.ParcelStorageV2.GetContext(ctx, number, opts...)
+}
+
+// TestCachedStorageCoalesce проверяет объединение параллельных промахов кэша
+func TestCachedStorageCoalesce(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// readAll читает посылку из workers горутин, пока want чтений из БД задержаны
+	readAll := func(want int32, opts ...CacheOption) int32 {
+		counting := &countingStorage{ParcelStorageV2: store, release: make(chan struct{})}
+		cached := NewCachedStorage(counting, NewParcelCache(time.Minute, 0), opts...)
+
+		const workers = 10
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := cached.GetContext(context.Background(), number)
+				errs <- err
+			}()
+		}
+		// остальные горутины успевают дойти до чтения; опоздавшие получат
+		// посылку из кэша, который заполняется до завершения общего чтения
+		require.Eventually(t, func() bool { return counting.reads.Load() >= want }, time.Second, time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		close(counting.release)
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		return counting.reads.Load()
+	}
+
+	// check
+	require.Equal(t, int32(1), readAll(1))
+	require.Equal(t, int32(10), readAll(10, WithCoalesce()))
+}
+
+// TestCachedStorageCoalesceCancel проверяет, что отмена ожидающего вызова
+// не отменяет общее чтение
+func TestCachedStorageCoalesceCancel(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	counting := &countingStorage{ParcelStorageV2: store, release: make(chan struct{})}
+	cached := NewCachedStorage(counting, NewParcelCache(time.Minute, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := cached.GetContext(ctx, number)
+		done <- err
+	}()
+	require.Eventually(t, func() bool { return counting.reads.Load() == 1 }, time.Second, time.Millisecond)
+
+	// check
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+
+	close(counting.release)
+	p, err := cached.GetContext(context.Background(), number)
+	require.NoError(t, err)
+	require.Equal(t, number, p.Number)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/carriers"
+)
+
+// carrierSyncInterval — как часто статусы запрашиваются у перевозчиков
+const carrierSyncInterval = 15 * time.Minute
+
+var (
+	ErrHandedToCarrier = errors.New("посылка уже передана перевозчику")
+	ErrUnknownCarrier  = errors.New("перевозчик не настроен")
+)
+
+// CarrierShipment — посылка, переданная внешнему перевозчику
+type CarrierShipment struct {
+	Parcel         int
+	Carrier        string
+	TrackingNumber string
+	CreatedAt      time.Time
+	// SyncedAt — время последней успешной синхронизации, нулевое до неё
+	SyncedAt time.Time
+}
+
+// HandToCarrier регистрирует посылку у перевозчика c и запоминает номер
+// отслеживания, по которому её статус будет синхронизироваться
+func (s ParcelStore) HandToCarrier(ctx context.Context, number int, c carriers.Carrier) (string, error) {
+	p, err := s.Get(number)
+	if err != nil {
+		return "", err
+	}
+
+	// проверка до обращения к перевозчику, чтобы не создать у него дубль
+	var exists bool
+	err = s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM {carrier_shipment} WHERE parcel = :parcel)",
+		sql.Named("parcel", number)).Scan(&exists)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", ErrHandedToCarrier
+	}
+
+	tracking, err := c.CreateShipment(ctx, carriers.Shipment{
+		Reference:  p.UUID,
+		Address:    p.Address,
+		Country:    p.Country,
+		PostalCode: p.PostalCode,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.db.Exec("INSERT INTO {carrier_shipment} (parcel, carrier, tracking_number, created_at) "+
+		"VALUES (:parcel, :carrier, :tracking_number, :created_at)",
+		sql.Named("parcel", number),
+		sql.Named("carrier", c.Name()),
+		sql.Named("tracking_number", tracking),
+		sql.Named("created_at", formatTime(s.now())))
+	if isUniqueViolation(err) {
+		return "", ErrHandedToCarrier
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return tracking, nil
+}
+
+// activeShipments возвращает отправления посылок, ещё не доставленных
+// и не выбывших, начиная с давно не синхронизированных
+func (s ParcelStore) activeShipments() ([]CarrierShipment, error) {
+	rows, err := s.db.Query("SELECT c.parcel, c.carrier, c.tracking_number, c.created_at, c.synced_at "+
+		"FROM {carrier_shipment} c JOIN {parcel} p ON p.number = c.parcel "+
+		"WHERE p.status NOT IN (:delivered, :lost, :damaged, :returned, :cancelled) ORDER BY c.synced_at, c.parcel",
+		sql.Named("delivered", ParcelStatusDelivered),
+		sql.Named("lost", ParcelStatusLost),
+		sql.Named("damaged", ParcelStatusDamaged),
+		sql.Named("returned", ParcelStatusReturned),
+		sql.Named("cancelled", ParcelStatusCancelled))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []CarrierShipment
+	for rows.Next() {
+		c := CarrierShipment{}
+		err := rows.Scan(&c.Parcel, &c.Carrier, &c.TrackingNumber, scanTime(&c.CreatedAt), scanTime(&c.SyncedAt))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// SyncCarrierStatuses запрашивает события незавершённых отправлений у их
+// перевозчиков и применяет новые статусы. Перевозчик каждый раз отдаёт всю
+// историю, поэтому события, которые граф переходов из текущего статуса
+// не допускает, считаются уже учтёнными и пропускаются. Ошибка одного
+// отправления не мешает остальным, все ошибки возвращаются вместе.
+func (s ParcelStore) SyncCarrierStatuses(ctx context.Context, list []carriers.Carrier) (int, error) {
+	byName := make(map[string]carriers.Carrier, len(list))
+	for _, c := range list {
+		byName[c.Name()] = c
+	}
+
+	shipments, err := s.activeShipments()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	var errs []error
+	for _, sh := range shipments {
+		if ctx.Err() != nil {
+			break
+		}
+
+		n, err := s.syncShipment(ctx, sh, byName[sh.Carrier])
+		applied += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("посылка № %d у %s: %w", sh.Parcel, sh.Carrier, err))
+		}
+	}
+
+	return applied, errors.Join(errs...)
+}
+
+// syncShipment применяет события одного отправления и возвращает число
+// изменений статуса
+func (s ParcelStore) syncShipment(ctx context.Context, sh CarrierShipment, c carriers.Carrier) (int, error) {
+	if c == nil {
+		return 0, ErrUnknownCarrier
+	}
+
+	events, err := c.FetchTracking(ctx, sh.TrackingNumber)
+	if err != nil {
+		return 0, err
+	}
+
+	p, err := s.Get(sh.Parcel)
+	if err != nil {
+		return 0, err
+	}
+	graph, err := s.StatusGraph(p.Tenant)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, e := range events {
+		if e.Status == "" || e.Status == p.Status || !graph.Allows(p.Status, e.Status) {
+			continue
+		}
+		if err := s.transitionStatusAt(p.Number, p.Status, e.Status, e.Time); err != nil {
+			return applied, err
+		}
+		p.Status = e.Status
+		applied++
+	}
+
+	_, err = s.db.Exec("UPDATE {carrier_shipment} SET synced_at = :now WHERE parcel = :parcel",
+		sql.Named("now", formatTime(s.now())),
+		sql.Named("parcel", sh.Parcel))
+
+	return applied, err
+}
+
+// RunCarrierSync каждые interval синхронизирует статусы с перевозчиками,
+// пока не отменён ctx. Ошибки записываются в errors.
+func (s ParcelStore) RunCarrierSync(ctx context.Context, list []carriers.Carrier, interval time.Duration, errors *ErrorLog) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.SyncCarrierStatuses(ctx, list); err != nil && ctx.Err() == nil {
+			errors.Record(err)
+		}
+	}
+}
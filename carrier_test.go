@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/carriers"
+)
+
+// testCarrier — перевозчик в памяти: номера отслеживания по порядку
+// и события, заданные тестом
+type testCarrier struct {
+	created []carriers.Shipment
+	events  map[string][]carriers.Event
+}
+
+func (c *testCarrier) Name() string {
+	return "test"
+}
+
+func (c *testCarrier) CreateShipment(ctx context.Context, s carriers.Shipment) (string, error) {
+	c.created = append(c.created, s)
+
+	return "TN" + s.Reference, nil
+}
+
+func (c *testCarrier) FetchTracking(ctx context.Context, trackingNumber string) ([]carriers.Event, error) {
+	events, ok := c.events[trackingNumber]
+	if !ok {
+		return nil, carriers.ErrUnknownShipment
+	}
+
+	return events, nil
+}
+
+// TestSyncCarrierStatuses проверяет передачу посылки перевозчику
+// и синхронизацию её статуса по его событиям
+func TestSyncCarrierStatuses(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	carrier := &testCarrier{events: map[string][]carriers.Event{}}
+	ctx := context.Background()
+
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	p, err := store.Get(number)
+	require.NoError(t, err)
+
+	// hand over
+	tracking, err := store.HandToCarrier(ctx, number, carrier)
+	require.NoError(t, err)
+	require.Equal(t, "TN"+p.UUID, tracking)
+	_, err = store.HandToCarrier(ctx, number, carrier)
+	require.ErrorIs(t, err, ErrHandedToCarrier)
+	require.Len(t, carrier.created, 1)
+
+	// sync
+	sentAt := time.Date(2026, 10, 13, 9, 0, 0, 0, time.UTC)
+	carrier.events[tracking] = []carriers.Event{
+		{Code: "ACC", Status: carriers.StatusSent, Time: sentAt},
+		{Code: "HUB", Time: sentAt.Add(time.Hour)},
+	}
+	applied, err := store.SyncCarrierStatuses(ctx, []carriers.Carrier{carrier})
+	require.NoError(t, err)
+	require.Equal(t, 1, applied)
+
+	// повторная синхронизация той же истории ничего не меняет
+	carrier.events[tracking] = append(carrier.events[tracking],
+		carriers.Event{Code: "DLV", Status: carriers.StatusDelivered, Time: sentAt.Add(5 * time.Hour)})
+	applied, err = store.SyncCarrierStatuses(ctx, []carriers.Carrier{carrier})
+	require.NoError(t, err)
+	require.Equal(t, 1, applied)
+
+	// check
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDelivered, stored.Status)
+	require.Equal(t, sentAt, stored.SentAt)
+	require.Equal(t, sentAt.Add(5*time.Hour), stored.DeliveredAt)
+
+	// доставленные посылки больше не синхронизируются
+	applied, err = store.SyncCarrierStatuses(ctx, nil)
+	require.NoError(t, err)
+	require.Zero(t, applied)
+}
+
+// TestSyncUnknownCarrier проверяет ошибку для отправления ненастроенного перевозчика
+func TestSyncUnknownCarrier(t *testing.T) {
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	_, err = store.HandToCarrier(context.Background(), number, &testCarrier{})
+	require.NoError(t, err)
+
+	_, err = store.SyncCarrierStatuses(context.Background(), nil)
+	require.ErrorIs(t, err, ErrUnknownCarrier)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	CarrierFormatCSV   = "csv"
+	CarrierFormatFixed = "fixed"
+)
+
+var (
+	ErrInvalidCarrierLayout = errors.New("некорректный формат файла перевозчика")
+	ErrUnknownCarrierCode   = errors.New("неизвестный код события перевозчика")
+)
+
+// CarrierField — положение поля в строке файла перевозчика: номер столбца
+// CSV с нуля или позиция и ширина в символах для фиксированной ширины
+type CarrierField struct {
+	Column int `json:"column"`
+	Start  int `json:"start"`
+	Width  int `json:"width"`
+}
+
+// CarrierLayout описывает файл статусов перевозчика. Раскладки хранятся
+// в конфигурации в JSON, поэтому у полей есть теги.
+type CarrierLayout struct {
+	Carrier string `json:"carrier"`
+	// Format — csv или fixed
+	Format string `json:"format"`
+	// Delimiter — разделитель CSV, по умолчанию запятая
+	Delimiter string `json:"delimiter"`
+	// SkipHeader — первая строка файла заголовок
+	SkipHeader bool `json:"skip_header"`
+	// Tracking — поле с публичным кодом посылки (UUID)
+	Tracking CarrierField `json:"tracking"`
+	Code     CarrierField `json:"code"`
+	Time     CarrierField `json:"time"`
+	// TimeLayout — формат времени события в нотации time.Parse,
+	// время без зоны считается UTC
+	TimeLayout string `json:"time_layout"`
+	// Codes сопоставляет коды событий перевозчика нашим статусам
+	Codes map[string]string `json:"codes"`
+}
+
+// CarrierEvent — событие из строки файла перевозчика
+type CarrierEvent struct {
+	// Line — номер строки файла с единицы
+	Line     int
+	Tracking string
+	Code     string
+	Time     time.Time
+}
+
+// CarrierLineError — ошибка обработки строки файла, Line с единицы
+type CarrierLineError struct {
+	Line int
+	Err  error
+}
+
+func (e CarrierLineError) Error() string {
+	return fmt.Sprintf("строка %d: %v", e.Line, e.Err)
+}
+
+func (e CarrierLineError) Unwrap() error {
+	return e.Err
+}
+
+// CarrierImportResult — итог импорта: сколько строк применено, сколько
+// пропущено, потому что посылка уже в этом статусе, и ошибки по строкам
+type CarrierImportResult struct {
+	Applied int
+	Skipped int
+	Errors  []CarrierLineError
+}
+
+// Validate проверяет раскладку перед разбором файла
+func (l CarrierLayout) Validate() error {
+	switch {
+	case l.Format != CarrierFormatCSV && l.Format != CarrierFormatFixed:
+		return fmt.Errorf("%w: формат %q", ErrInvalidCarrierLayout, l.Format)
+	case utf8.RuneCountInString(l.Delimiter) > 1:
+		return fmt.Errorf("%w: разделитель %q", ErrInvalidCarrierLayout, l.Delimiter)
+	case l.TimeLayout == "":
+		return fmt.Errorf("%w: не указан формат времени", ErrInvalidCarrierLayout)
+	case len(l.Codes) == 0:
+		return fmt.Errorf("%w: не указаны коды событий", ErrInvalidCarrierLayout)
+	}
+
+	for code, status := range l.Codes {
+		if !parcelStatuses[status] {
+			return fmt.Errorf("%w: код %q сопоставлен неизвестному статусу %q", ErrInvalidCarrierLayout, code, status)
+		}
+	}
+
+	if l.Format == CarrierFormatFixed {
+		for _, f := range []CarrierField{l.Tracking, l.Code, l.Time} {
+			if f.Start < 0 || f.Width <= 0 {
+				return fmt.Errorf("%w: поле фиксированной ширины %+v", ErrInvalidCarrierLayout, f)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ParseCarrierFile разбирает файл перевозчика. Ошибочные строки не прерывают
+// разбор и возвращаются отдельно; последняя ошибка — раскладки или чтения.
+func ParseCarrierFile(r io.Reader, l CarrierLayout) ([]CarrierEvent, []CarrierLineError, error) {
+	if err := l.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	var events []CarrierEvent
+	var errs []CarrierLineError
+	add := func(line int, fields func(CarrierField) (string, error)) {
+		e, err := l.event(fields)
+		if err != nil {
+			errs = append(errs, CarrierLineError{Line: line, Err: err})
+			return
+		}
+		e.Line = line
+		events = append(events, e)
+	}
+
+	if l.Format == CarrierFormatCSV {
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		if l.Delimiter != "" {
+			cr.Comma, _ = utf8.DecodeRuneInString(l.Delimiter)
+		}
+		for line := 1; ; line++ {
+			record, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				errs = append(errs, CarrierLineError{Line: line, Err: parseErr.Err})
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if line == 1 && l.SkipHeader {
+				continue
+			}
+			add(line, func(f CarrierField) (string, error) {
+				if f.Column < 0 || f.Column >= len(record) {
+					return "", fmt.Errorf("нет столбца %d", f.Column)
+				}
+				return record[f.Column], nil
+			})
+		}
+
+		return events, errs, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := []rune(scanner.Text())
+		if (line == 1 && l.SkipHeader) || strings.TrimSpace(string(text)) == "" {
+			continue
+		}
+		add(line, func(f CarrierField) (string, error) {
+			if f.Start+f.Width > len(text) {
+				return "", fmt.Errorf("строка короче поля в позиции %d", f.Start)
+			}
+			return string(text[f.Start : f.Start+f.Width]), nil
+		})
+	}
+
+	return events, errs, scanner.Err()
+}
+
+// event собирает событие из полей строки
+func (l CarrierLayout) event(field func(CarrierField) (string, error)) (CarrierEvent, error) {
+	var e CarrierEvent
+	var raw [3]string
+	for i, f := range []CarrierField{l.Tracking, l.Code, l.Time} {
+		v, err := field(f)
+		if err != nil {
+			return e, err
+		}
+		raw[i] = strings.TrimSpace(v)
+	}
+
+	e.Tracking, e.Code = raw[0], raw[1]
+	if _, ok := l.Codes[e.Code]; !ok {
+		return e, fmt.Errorf("%w: %q", ErrUnknownCarrierCode, e.Code)
+	}
+
+	t, err := time.Parse(l.TimeLayout, raw[2])
+	if err != nil {
+		return e, err
+	}
+	e.Time = t.UTC()
+
+	return e, nil
+}
+
+// ImportCarrierFile разбирает файл перевозчика и применяет события по
+// порядку строк через граф переходов арендатора посылки; время события
+// записывается как время отправки или доставки. Событие о статусе, в котором
+// посылка уже находится, пропускается, поэтому повторная загрузка того же
+// файла безопасна. Ошибки строк копятся в результате и не прерывают импорт.
+func (s ParcelStore) ImportCarrierFile(r io.Reader, l CarrierLayout) (CarrierImportResult, error) {
+	events, errs, err := ParseCarrierFile(r, l)
+	if err != nil {
+		return CarrierImportResult{}, err
+	}
+
+	res := CarrierImportResult{Errors: errs}
+	for _, e := range events {
+		applied, err := s.applyCarrierEvent(e, l.Codes[e.Code])
+		switch {
+		case err != nil:
+			res.Errors = append(res.Errors, CarrierLineError{Line: e.Line, Err: err})
+		case applied:
+			res.Applied++
+		default:
+			res.Skipped++
+		}
+	}
+
+	// ошибки разбора и применения идут вперемешку, упорядочиваем по строкам
+	sort.SliceStable(res.Errors, func(i, j int) bool { return res.Errors[i].Line < res.Errors[j].Line })
+
+	return res, nil
+}
+
+// applyCarrierEvent переводит посылку в статус события и сообщает,
+// изменился ли статус
+func (s ParcelStore) applyCarrierEvent(e CarrierEvent, status string) (bool, error) {
+	p, err := s.GetByUUID(e.Tracking)
+	if err != nil {
+		return false, fmt.Errorf("посылка %q: %w", e.Tracking, err)
+	}
+	if p.Status == status {
+		return false, nil
+	}
+
+	if err := s.transitionStatusAt(p.Number, p.Status, status, e.Time); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCarrierCodes — коды событий тестового перевозчика
+var testCarrierCodes = map[string]string{
+	"PU": ParcelStatusSent,
+	"DL": ParcelStatusDelivered,
+	"LS": ParcelStatusLost,
+}
+
+// TestImportCarrierCSV проверяет применение CSV-файла перевозчика
+// и ошибки отдельных строк
+func TestImportCarrierCSV(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	first, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	second, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	p1, err := store.Get(first)
+	require.NoError(t, err)
+	p2, err := store.Get(second)
+	require.NoError(t, err)
+
+	file := "tracking;event;time\n" +
+		p1.UUID + ";PU;2026-10-13 09:00\n" +
+		p1.UUID + ";DL;2026-10-13 18:30\n" +
+		p2.UUID + ";XX;2026-10-13 10:00\n" +
+		p2.UUID + ";DL;2026-10-13 11:00\n" +
+		"00000000-0000-0000-0000-000000000000;PU;2026-10-13 12:00\n" +
+		p1.UUID + ";DL;2026-10-13 18:30\n"
+	layout := CarrierLayout{
+		Carrier:    "test",
+		Format:     CarrierFormatCSV,
+		Delimiter:  ";",
+		SkipHeader: true,
+		Tracking:   CarrierField{Column: 0},
+		Code:       CarrierField{Column: 1},
+		Time:       CarrierField{Column: 2},
+		TimeLayout: "2006-01-02 15:04",
+		Codes:      testCarrierCodes,
+	}
+
+	// import
+	res, err := store.ImportCarrierFile(strings.NewReader(file), layout)
+	require.NoError(t, err)
+
+	// check
+	require.Equal(t, 2, res.Applied)
+	require.Equal(t, 1, res.Skipped)
+	require.Len(t, res.Errors, 3)
+	require.Equal(t, 4, res.Errors[0].Line)
+	require.ErrorIs(t, res.Errors[0], ErrUnknownCarrierCode)
+	require.Equal(t, 5, res.Errors[1].Line)
+	require.ErrorIs(t, res.Errors[1], ErrInvalidTransition)
+	require.Equal(t, 6, res.Errors[2].Line)
+
+	stored, err := store.Get(first)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDelivered, stored.Status)
+	require.Equal(t, time.Date(2026, 10, 13, 9, 0, 0, 0, time.UTC), stored.SentAt)
+	require.Equal(t, time.Date(2026, 10, 13, 18, 30, 0, 0, time.UTC), stored.DeliveredAt)
+}
+
+// TestParseCarrierFixedWidth проверяет разбор файла с полями фиксированной ширины
+func TestParseCarrierFixedWidth(t *testing.T) {
+	layout := CarrierLayout{
+		Format:     CarrierFormatFixed,
+		Tracking:   CarrierField{Start: 0, Width: 8},
+		Code:       CarrierField{Start: 8, Width: 2},
+		Time:       CarrierField{Start: 10, Width: 12},
+		TimeLayout: "200601021504",
+		Codes:      testCarrierCodes,
+	}
+
+	events, errs, err := ParseCarrierFile(strings.NewReader("ABC12345PU202610130900\n\nABC12345DL2026\n"), layout)
+	require.NoError(t, err)
+	require.Equal(t, []CarrierEvent{
+		{Line: 1, Tracking: "ABC12345", Code: "PU", Time: time.Date(2026, 10, 13, 9, 0, 0, 0, time.UTC)},
+	}, events)
+	require.Len(t, errs, 1)
+	require.Equal(t, 3, errs[0].Line)
+
+	layout.Codes = map[string]string{"PU": "picked_up"}
+	_, _, err = ParseCarrierFile(strings.NewReader(""), layout)
+	require.ErrorIs(t, err, ErrInvalidCarrierLayout)
+}
+
+// FuzzParseCarrierFile проверяет, что разбор произвольного файла
+// перевозчика не паникует и возвращает только события с известными кодами
+func FuzzParseCarrierFile(f *testing.F) {
+	f.Add("ABC12345,PU,2026-10-13T09:00:00Z\nABC12345,XX,2026\n", false)
+	f.Add("\"ABC,PU\n\"\"", false)
+	f.Add("ABC12345PU202610130900\n\nABC12345DL2026\n", true)
+	f.Add("Ёжик▲▲PU2026101309", true)
+
+	csvLayout := CarrierLayout{
+		Format:     CarrierFormatCSV,
+		Tracking:   CarrierField{Column: 0},
+		Code:       CarrierField{Column: 1},
+		Time:       CarrierField{Column: 2},
+		TimeLayout: time.RFC3339,
+		Codes:      testCarrierCodes,
+	}
+	fixedLayout := CarrierLayout{
+		Format:     CarrierFormatFixed,
+		Tracking:   CarrierField{Start: 0, Width: 8},
+		Code:       CarrierField{Start: 8, Width: 2},
+		Time:       CarrierField{Start: 10, Width: 12},
+		TimeLayout: "200601021504",
+		Codes:      testCarrierCodes,
+	}
+
+	f.Fuzz(func(t *testing.T, data string, fixed bool) {
+		layout := csvLayout
+		if fixed {
+			layout = fixedLayout
+		}
+
+		events, errs, err := ParseCarrierFile(strings.NewReader(data), layout)
+		require.NoError(t, err)
+		for _, e := range events {
+			require.Positive(t, e.Line)
+			require.Contains(t, testCarrierCodes, e.Code)
+			require.Equal(t, strings.TrimSpace(e.Tracking), e.Tracking)
+		}
+		for _, e := range errs {
+			require.Positive(t, e.Line)
+		}
+	})
+}
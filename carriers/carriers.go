@@ -0,0 +1,52 @@
+// Package carriers описывает интеграции с внешними перевозчиками, которым
+// трекер передаёт посылки: создание отправления у перевозчика и получение
+// его событий отслеживания. Статусы событий приводятся к статусам трекера.
+package carriers
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Статусы событий перевозчика; совпадают со встроенными статусами трекера
+const (
+	StatusSent      = "sent"
+	StatusDelivered = "delivered"
+	StatusLost      = "lost"
+	StatusDamaged   = "damaged"
+	StatusReturned  = "returned"
+)
+
+// ErrUnknownShipment — перевозчик не знает отправления с таким номером
+var ErrUnknownShipment = errors.New("отправление у перевозчика не найдено")
+
+// Shipment — данные посылки, передаваемые перевозчику
+type Shipment struct {
+	// Reference — публичный код посылки в трекере
+	Reference  string
+	Address    string
+	Country    string
+	PostalCode string
+}
+
+// Event — событие отслеживания у перевозчика
+type Event struct {
+	// Code — код события в системе перевозчика
+	Code string
+	// Status — статус трекера, пустой для событий, не меняющих статус
+	Status string
+	Time   time.Time
+}
+
+// Carrier — интеграция с перевозчиком. Реализации должны быть безопасны
+// для использования из нескольких горутин.
+type Carrier interface {
+	// Name возвращает имя перевозчика, под которым хранятся его отправления
+	Name() string
+	// CreateShipment регистрирует отправление у перевозчика и возвращает
+	// его номер отслеживания
+	CreateShipment(ctx context.Context, s Shipment) (string, error)
+	// FetchTracking возвращает события отправления в хронологическом порядке
+	FetchTracking(ctx context.Context, trackingNumber string) ([]Event, error)
+}
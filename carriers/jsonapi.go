@@ -0,0 +1,115 @@
+package carriers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// JSONAPI — перевозчик с REST API в формате JSON:
+//
+//	POST {BaseURL}/shipments              — создание, ответ {"tracking_number": "..."}
+//	GET  {BaseURL}/shipments/{n}/events   — события [{"code": "...", "time": "RFC 3339"}]
+//
+// Такой API у большинства региональных партнёров; различаются коды
+// событий, они задаются в Codes.
+type JSONAPI struct {
+	CarrierName string
+	BaseURL     string
+	// Token передаётся в заголовке Authorization: Bearer, пустой — без него
+	Token string
+	// Codes сопоставляет коды событий перевозчика статусам трекера,
+	// коды вне списка статус не меняют
+	Codes map[string]string
+	// Client — HTTP-клиент, nil — http.DefaultClient
+	Client *http.Client
+}
+
+func (c JSONAPI) Name() string {
+	return c.CarrierName
+}
+
+func (c JSONAPI) CreateShipment(ctx context.Context, s Shipment) (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"reference":   s.Reference,
+		"address":     s.Address,
+		"country":     s.Country,
+		"postal_code": s.PostalCode,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var res struct {
+		TrackingNumber string `json:"tracking_number"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/shipments", body, &res); err != nil {
+		return "", err
+	}
+	if res.TrackingNumber == "" {
+		return "", fmt.Errorf("%s: пустой номер отслеживания в ответе", c.CarrierName)
+	}
+
+	return res.TrackingNumber, nil
+}
+
+func (c JSONAPI) FetchTracking(ctx context.Context, trackingNumber string) ([]Event, error) {
+	var raw []struct {
+		Code string    `json:"code"`
+		Time time.Time `json:"time"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/shipments/"+url.PathEscape(trackingNumber)+"/events", nil, &raw); err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, len(raw))
+	for i, e := range raw {
+		events[i] = Event{Code: e.Code, Status: c.Codes[e.Code], Time: e.Time.UTC()}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+
+	return events, nil
+}
+
+// do выполняет запрос к API и разбирает JSON-ответ в out
+func (c JSONAPI) do(ctx context.Context, method string, path string, body []byte, out any) error {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrUnknownShipment
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s: %s %s: %s", c.CarrierName, method, path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
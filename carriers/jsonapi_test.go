@@ -0,0 +1,62 @@
+package carriers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAPI возвращает перевозчика поверх тестового сервера
+func newTestAPI(t *testing.T) JSONAPI {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shipments", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		require.Equal(t, "ref-1", body["reference"])
+		w.Write([]byte(`{"tracking_number": "TN1"}`))
+	})
+	mux.HandleFunc("/shipments/TN1/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"code": "DLV", "time": "2026-10-13T18:00:00+03:00"},
+			{"code": "ACC", "time": "2026-10-13T09:00:00Z"},
+			{"code": "HUB", "time": "2026-10-13T12:00:00Z"}]`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return JSONAPI{
+		CarrierName: "partner",
+		BaseURL:     srv.URL,
+		Token:       "secret",
+		Codes:       map[string]string{"ACC": StatusSent, "DLV": StatusDelivered},
+		Client:      srv.Client(),
+	}
+}
+
+// TestJSONAPI проверяет создание отправления и разбор событий
+func TestJSONAPI(t *testing.T) {
+	api := newTestAPI(t)
+	ctx := context.Background()
+
+	number, err := api.CreateShipment(ctx, Shipment{Reference: "ref-1", Address: "Псков"})
+	require.NoError(t, err)
+	require.Equal(t, "TN1", number)
+
+	events, err := api.FetchTracking(ctx, number)
+	require.NoError(t, err)
+	require.Equal(t, []Event{
+		{Code: "ACC", Status: StatusSent, Time: time.Date(2026, 10, 13, 9, 0, 0, 0, time.UTC)},
+		{Code: "HUB", Time: time.Date(2026, 10, 13, 12, 0, 0, 0, time.UTC)},
+		{Code: "DLV", Status: StatusDelivered, Time: time.Date(2026, 10, 13, 15, 0, 0, 0, time.UTC)},
+	}, events)
+
+	_, err = api.FetchTracking(ctx, "TN2")
+	require.ErrorIs(t, err, ErrUnknownShipment)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock — источник текущего времени хранилища: время регистрации и смены
+// статусов, сроки SLA и хранения, сроки давности фоновых задач. Замеры
+// задержек и сроки кэшей в памяти идут по системным часам.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock — системные часы, используются по умолчанию
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock — часы, которые идут только по команде, для тестов
+// и воспроизведения событий. Безопасны для параллельного использования.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock возвращает часы, остановленные на now
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Set переводит часы на now
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
+
+// Advance переводит часы вперёд на d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// WithClock задаёт часы хранилища, по умолчанию SystemClock
+func WithClock(c Clock) StoreOption {
+	return func(s *ParcelStore) {
+		s.clock = c
+	}
+}
+
+// now возвращает текущее время по часам хранилища
+func (s ParcelStore) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+
+	return s.clock.Now()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestManualClockSLA проверяет регистрацию и нарушение SLA по часам хранилища
+func TestManualClockSLA(t *testing.T) {
+	// prepare
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	store := NewParcelStore(openTestDB(t), WithClock(clock))
+	service := NewParcelService(store)
+	p, err := service.Register(1000, "test")
+	require.NoError(t, err)
+	require.True(t, start.Equal(p.CreatedAt))
+	sla, err := SLA(ServiceLevelStandard)
+	require.NoError(t, err)
+
+	// check
+	require.NoError(t, service.CheckSLA(p.Number))
+	breached, err := store.ListBreached(ServiceLevelStandard)
+	require.NoError(t, err)
+	require.Empty(t, breached)
+
+	clock.Advance(sla + time.Minute)
+	require.ErrorIs(t, service.CheckSLA(p.Number), ErrSLABreached)
+	breached, err = store.ListBreached(ServiceLevelStandard)
+	require.NoError(t, err)
+	require.Len(t, breached, 1)
+
+	require.NoError(t, store.TransitionStatus(p.Number, ParcelStatusRegistered, ParcelStatusSent))
+	got, err := store.Get(p.Number)
+	require.NoError(t, err)
+	require.True(t, clock.Now().Equal(got.SentAt))
+}
+
+// TestManualClockRetention проверяет сжатие журнала по сроку хранения
+// без ожидания и подделки времени записей
+func TestManualClockRetention(t *testing.T) {
+	// prepare
+	clock := NewManualClock(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC))
+	store := NewParcelStore(openTestDB(t), WithClock(clock))
+	audited := NewAuditedStorage(store, store, "ivanov")
+	p := getTestParcel()
+	p.CreatedAt = time.Time{}
+	number, err := audited.Add(p)
+	require.NoError(t, err)
+	for _, address := range []string{"Тверь", "Псков", "Тула"} {
+		clock.Advance(time.Hour)
+		require.NoError(t, audited.SetAddress(number, address))
+		require.NoError(t, audited.SetStatus(number, ParcelStatusRegistered))
+	}
+
+	// check
+	entries, err := store.ListAudit(AuditFilter{Parcel: number})
+	require.NoError(t, err)
+	require.Len(t, entries, 7)
+	require.True(t, clock.Now().Equal(entries[6].At))
+
+	deleted, err := store.CompactAudit(24 * time.Hour)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+
+	clock.Advance(25 * time.Hour)
+	deleted, err = store.CompactAudit(24 * time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 3, deleted)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidCODAmount    = errors.New("некорректная сумма наложенного платежа")
+	ErrNoCOD               = errors.New("посылка без наложенного платежа")
+	ErrCODAlreadyCollected = errors.New("наложенный платёж уже принят")
+	ErrEmptyOperator       = errors.New("не указан оператор")
+)
+
+// CODReconciliation — строка ежедневной сверки наложенных платежей по оператору
+// и валюте, суммы в младших единицах валюты
+type CODReconciliation struct {
+	Operator string
+	Currency string
+	Parcels  int
+	// Expected — сумма, которую следовало принять
+	Expected int64
+	// Collected — фактически принятая сумма
+	Collected int64
+}
+
+// Difference возвращает расхождение между принятой и ожидаемой суммой
+func (r CODReconciliation) Difference() int64 {
+	return r.Collected - r.Expected
+}
+
+// MarkCODCollected отмечает приём наложенного платежа по посылке.
+// Принять платёж можно только по доставленной посылке и только один раз.
+func (s ParcelStore) MarkCODCollected(number int, amount int64, operator string) error {
+	if amount <= 0 {
+		return ErrInvalidCODAmount
+	}
+	if operator == "" {
+		return ErrEmptyOperator
+	}
+
+	return s.audited(context.Background(), number, AuditCODCollected, func() error {
+		return s.collectCOD(number, amount, operator)
+	})
+}
+
+// collectCOD отмечает приём платежа, а если ни одна строка не обновилась,
+// выясняет причину
+func (s ParcelStore) collectCOD(number int, amount int64, operator string) error {
+	res, err := s.db.Exec("UPDATE {parcel} SET cod_collected = 1, cod_collected_amount = :amount, "+
+		"cod_collected_by = :operator, cod_collected_at = :collected_at "+
+		"WHERE number = :number AND status = :status AND cod_amount > 0 AND cod_collected = 0",
+		sql.Named("amount", amount),
+		sql.Named("operator", operator),
+		sql.Named("collected_at", formatTime(s.now())),
+		sql.Named("number", number),
+		sql.Named("status", ParcelStatusDelivered))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	// ни одна строка не обновилась, выясняем причину
+	p, err := s.Get(number)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case p.CODAmount == 0:
+		return ErrNoCOD
+	case p.CODCollected:
+		return ErrCODAlreadyCollected
+	default:
+		return ErrNotDelivered
+	}
+}
+
+// CODReport возвращает сверку наложенных платежей, принятых за сутки day (UTC),
+// сгруппированную по операторам и валютам
+func (s ParcelStore) CODReport(day time.Time) ([]CODReconciliation, error) {
+	from := day.UTC().Truncate(24 * time.Hour)
+	to := from.Add(24 * time.Hour)
+
+	rows, err := s.db.Query("SELECT cod_collected_by, currency, COUNT(*), SUM(cod_amount), SUM(cod_collected_amount) "+
+		"FROM {parcel} WHERE cod_collected = 1 AND cod_collected_at >= :from AND cod_collected_at < :to "+
+		"GROUP BY cod_collected_by, currency ORDER BY cod_collected_by, currency",
+		sql.Named("from", formatTime(from)),
+		sql.Named("to", formatTime(to)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []CODReconciliation
+	for rows.Next() {
+		r := CODReconciliation{}
+		err := rows.Scan(&r.Operator, &r.Currency, &r.Parcels, &r.Expected, &r.Collected)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestMarkCODCollected проверяет приём наложенного платежа
+func TestMarkCODCollected(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	parcel.CODAmount = 150000
+
+	// add
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	// до доставки платёж принять нельзя
+	err = store.MarkCODCollected(id, 150000, "operator")
+	require.ErrorIs(t, err, ErrNotDelivered)
+
+	// collect
+	require.NoError(t, store.SetStatus(id, ParcelStatusDelivered))
+	err = store.MarkCODCollected(id, 150000, "operator")
+	require.NoError(t, err)
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.True(t, stored.CODCollected)
+
+	// повторно платёж принять нельзя
+	err = store.MarkCODCollected(id, 150000, "operator")
+	require.ErrorIs(t, err, ErrCODAlreadyCollected)
+
+	// у посылки без наложенного платежа принимать нечего
+	id, err = store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(id, ParcelStatusDelivered))
+	err = store.MarkCODCollected(id, 100, "operator")
+	require.ErrorIs(t, err, ErrNoCOD)
+}
+
+// TestCODReport проверяет ежедневную сверку наложенных платежей
+func TestCODReport(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	collected := map[string][]int64{
+		"alice": {100000, 50000},
+		"bob":   {20000},
+	}
+
+	// add
+	for operator, amounts := range collected {
+		for _, amount := range amounts {
+			parcel := getTestParcel()
+			parcel.CODAmount = 100000
+			id, err := store.Add(parcel)
+			require.NoError(t, err)
+			require.NoError(t, store.SetStatus(id, ParcelStatusDelivered))
+			require.NoError(t, store.MarkCODCollected(id, amount, operator))
+		}
+	}
+
+	// check
+	report, err := store.CODReport(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []CODReconciliation{
+		{Operator: "alice", Currency: DefaultCurrency, Parcels: 2, Expected: 200000, Collected: 150000},
+		{Operator: "bob", Currency: DefaultCurrency, Parcels: 1, Expected: 100000, Collected: 20000},
+	}, report)
+	require.Equal(t, int64(-50000), report[0].Difference())
+
+	report, err = store.CODReport(time.Now().Add(-48 * time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, report)
+}
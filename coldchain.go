@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// AnomalyTemperatureBreach — показание температуры посылки с холодовой
+	// цепью вышло за допустимый диапазон, см. AddTemperatureReading
+	AnomalyTemperatureBreach = "temperature_breach"
+	// DefaultMinCelsius и DefaultMaxCelsius — допустимый диапазон
+	// температуры охлаждаемых посылок по умолчанию
+	DefaultMinCelsius = 2.0
+	DefaultMaxCelsius = 8.0
+)
+
+var (
+	ErrNotRefrigerated       = errors.New("посылка не требует охлаждения")
+	ErrInvalidTemperature    = errors.New("некорректное показание температуры")
+	ErrInvalidColdChainRange = errors.New("некорректный диапазон температуры")
+)
+
+// ColdChainPolicy — допустимый диапазон температуры охлаждаемых посылок
+// и адрес для оповещений о его нарушении
+type ColdChainPolicy struct {
+	MinCelsius float64
+	MaxCelsius float64
+	// AlertTo — адрес email дежурного, пустой — нарушение только отмечается
+	// аномалией посылки
+	AlertTo string
+}
+
+// breached сообщает, что температура celsius вне допустимого диапазона
+func (p ColdChainPolicy) breached(celsius float64) bool {
+	return celsius < p.MinCelsius || celsius > p.MaxCelsius
+}
+
+// WithColdChainPolicy задаёт диапазон температуры охлаждаемых посылок,
+// по умолчанию от DefaultMinCelsius до DefaultMaxCelsius без оповещений.
+// Паникует, если нижняя граница выше верхней.
+func WithColdChainPolicy(p ColdChainPolicy) StoreOption {
+	if p.MinCelsius > p.MaxCelsius {
+		panic(fmt.Sprintf("%v: %v..%v", ErrInvalidColdChainRange, p.MinCelsius, p.MaxCelsius))
+	}
+
+	return func(s *ParcelStore) {
+		s.coldChain = p
+	}
+}
+
+// TemperatureReading — показание датчика температуры посылки
+type TemperatureReading struct {
+	ID         int       `json:"id"`
+	Parcel     int       `json:"parcel"`
+	Celsius    float64   `json:"celsius"`
+	RecordedAt time.Time `json:"recorded_at"`
+	// Breach — показание вне допустимого диапазона
+	Breach bool `json:"breach"`
+}
+
+// AddTemperatureReading записывает показание температуры посылки
+// с холодовой цепью; нулевое at — текущее время. Показание вне диапазона
+// ColdChainPolicy отмечает посылку аномалией AnomalyTemperatureBreach,
+// а при первом нарушении по посылке ещё и оповещает дежурного через
+// outbox. Повторные нарушения только записываются, пока аномалия есть.
+func (s ParcelStore) AddTemperatureReading(number int, celsius float64, at time.Time) (TemperatureReading, error) {
+	if celsius < -100 || celsius > 100 {
+		return TemperatureReading{}, fmt.Errorf("%w: %v °C", ErrInvalidTemperature, celsius)
+	}
+	if at.IsZero() {
+		at = s.now()
+	}
+
+	p, err := s.Get(number)
+	if err != nil {
+		return TemperatureReading{}, err
+	}
+	if !p.RequiresRefrigeration {
+		return TemperatureReading{}, ErrNotRefrigerated
+	}
+
+	r := TemperatureReading{Parcel: number, Celsius: celsius, RecordedAt: at.UTC(), Breach: s.coldChain.breached(celsius)}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return TemperatureReading{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO {temperature_reading} (parcel, celsius, recorded_at, breach) "+
+		"VALUES (:parcel, :celsius, :recorded_at, :breach)",
+		sql.Named("parcel", number),
+		sql.Named("celsius", celsius),
+		sql.Named("recorded_at", formatTime(r.RecordedAt)),
+		sql.Named("breach", r.Breach))
+	if err != nil {
+		return TemperatureReading{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return TemperatureReading{}, err
+	}
+	r.ID = int(id)
+
+	if r.Breach {
+		detail := fmt.Sprintf("%.1f °C при допустимых %.1f..%.1f °C", celsius, s.coldChain.MinCelsius, s.coldChain.MaxCelsius)
+		res, err := tx.Exec("INSERT INTO {anomaly} (parcel, kind, detail, found_at) VALUES (:parcel, :kind, :detail, :found_at) "+
+			"ON CONFLICT (parcel, kind) DO NOTHING",
+			sql.Named("parcel", number),
+			sql.Named("kind", AnomalyTemperatureBreach),
+			sql.Named("detail", detail),
+			sql.Named("found_at", formatTime(s.now())))
+		if err != nil {
+			return TemperatureReading{}, err
+		}
+		flagged, err := res.RowsAffected()
+		if err != nil {
+			return TemperatureReading{}, err
+		}
+
+		if flagged > 0 && s.coldChain.AlertTo != "" {
+			n := Notification{
+				Channel: ChannelEmail,
+				To:      s.coldChain.AlertTo,
+				Subject: fmt.Sprintf("Нарушение температурного режима посылки %s", p.UUID),
+				Body:    fmt.Sprintf("Посылка %s: %s, показание от %s.", p.UUID, detail, r.RecordedAt.Format(time.RFC3339)),
+			}
+			if err := s.enqueueOutbox(tx, TopicNotification, n); err != nil {
+				return TemperatureReading{}, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return TemperatureReading{}, err
+	}
+
+	return r, nil
+}
+
+// ListTemperatureReadings возвращает показания температуры посылки
+// в порядке их снятия
+func (s ParcelStore) ListTemperatureReadings(number int) ([]TemperatureReading, error) {
+	rows, err := s.db.Query("SELECT id, parcel, celsius, recorded_at, breach FROM {temperature_reading} "+
+		"WHERE parcel = :parcel ORDER BY recorded_at, id",
+		sql.Named("parcel", number))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []TemperatureReading
+	for rows.Next() {
+		r := TemperatureReading{}
+		if err := rows.Scan(&r.ID, &r.Parcel, &r.Celsius, scanTime(&r.RecordedAt), &r.Breach); err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
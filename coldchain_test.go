@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestTemperatureReadings проверяет запись показаний температуры,
+// отметку нарушения аномалией и однократное оповещение дежурного
+func TestTemperatureReadings(t *testing.T) {
+	// prepare
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	store := NewParcelStore(openTestDB(t), WithClock(clock),
+		WithColdChainPolicy(ColdChainPolicy{MinCelsius: 2, MaxCelsius: 8, AlertTo: "duty@example.com"}))
+	p := getTestParcel()
+	p.CreatedAt = time.Time{}
+	p.RequiresRefrigeration = true
+	number, err := store.Add(p)
+	require.NoError(t, err)
+	got, err := store.Get(number)
+	require.NoError(t, err)
+	require.True(t, got.RequiresRefrigeration)
+
+	other := getTestParcel()
+	other.CreatedAt = time.Time{}
+	plain, err := store.Add(other)
+	require.NoError(t, err)
+
+	// check
+	_, err = store.AddTemperatureReading(plain, 5, time.Time{})
+	require.ErrorIs(t, err, ErrNotRefrigerated)
+	_, err = store.AddTemperatureReading(number, 1000, time.Time{})
+	require.ErrorIs(t, err, ErrInvalidTemperature)
+
+	r, err := store.AddTemperatureReading(number, 4.5, time.Time{})
+	require.NoError(t, err)
+	require.False(t, r.Breach)
+	require.True(t, start.Equal(r.RecordedAt))
+	anomalies, err := store.ListAnomalies(true)
+	require.NoError(t, err)
+	require.Empty(t, anomalies)
+
+	for _, celsius := range []float64{9.5, 12} {
+		clock.Advance(time.Minute)
+		r, err := store.AddTemperatureReading(number, celsius, time.Time{})
+		require.NoError(t, err)
+		require.True(t, r.Breach)
+	}
+
+	readings, err := store.ListTemperatureReadings(number)
+	require.NoError(t, err)
+	require.Len(t, readings, 3)
+	require.Equal(t, []bool{false, true, true}, []bool{readings[0].Breach, readings[1].Breach, readings[2].Breach})
+	require.Equal(t, 12.0, readings[2].Celsius)
+
+	anomalies, err = store.ListAnomalies(false)
+	require.NoError(t, err)
+	require.Len(t, anomalies, 1)
+	require.Equal(t, AnomalyTemperatureBreach, anomalies[0].Kind)
+	require.Equal(t, number, anomalies[0].Parcel)
+	require.Contains(t, anomalies[0].Detail, "9.5")
+
+	pending, err := store.PendingOutbox(10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	var n Notification
+	require.NoError(t, json.Unmarshal(pending[0].Payload, &n))
+	require.Equal(t, "duty@example.com", n.To)
+	require.Contains(t, n.Body, p.UUID)
+
+	require.Panics(t, func() { WithColdChainPolicy(ColdChainPolicy{MinCelsius: 8, MaxCelsius: 2}) })
+}
+
+// TestTemperatureHandler проверяет показания температуры через админку
+func TestTemperatureHandler(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	p := getTestParcel()
+	p.RequiresRefrigeration = true
+	number, err := store.Add(p)
+	require.NoError(t, err)
+	handler := NewAdminHandler(store, NewErrorLog(10))
+
+	post := func(form url.Values) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/temperature", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	parcel := strconv.Itoa(number)
+
+	// check
+	require.Equal(t, http.StatusBadRequest, post(url.Values{"parcel": {parcel}, "celsius": {"тепло"}}))
+	require.Equal(t, http.StatusBadRequest, post(url.Values{"parcel": {parcel}, "celsius": {"5"}, "at": {"вчера"}}))
+	require.Equal(t, http.StatusNotFound, post(url.Values{"parcel": {"100000"}, "celsius": {"5"}}))
+	require.Equal(t, http.StatusCreated, post(url.Values{"parcel": {parcel}, "celsius": {"10.5"}, "at": {"2024-03-01T09:00:00Z"}}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/temperature?parcel="+parcel, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"celsius":10.5`)
+	require.Contains(t, rec.Body.String(), `"breach":true`)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// concurrencyWorkers — число горутин в тестах конкурентного доступа,
+// тесты имеет смысл запускать с флагом -race
+const concurrencyWorkers = 16
+
+// TestConcurrentAddSetStatusDelete проверяет, что общий ParcelStore выдерживает
+// одновременные добавления, смену статуса и удаления из многих горутин
+func TestConcurrentAddSetStatusDelete(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	client := randRange.Intn(10_000_000)
+	const perWorker = 20
+
+	// run
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	kept := 0
+	for w := 0; w < concurrencyWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				parcel := getTestParcel()
+				parcel.Client = client
+				id, err := store.Add(parcel)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				// каждая вторая посылка отправляется и поэтому не удаляется
+				if i%2 == 0 {
+					if err := store.SetStatus(id, ParcelStatusSent); err != nil {
+						t.Error(err)
+						return
+					}
+					mu.Lock()
+					kept++
+					mu.Unlock()
+				}
+				if err := store.Delete(id); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// check
+	parcels, err := store.GetByClient(client)
+	require.NoError(t, err)
+	require.Len(t, parcels, kept)
+	for _, p := range parcels {
+		require.Equal(t, ParcelStatusSent, p.Status)
+	}
+}
+
+// TestConcurrentTransitionStatus проверяет, что из параллельных переходов
+// из одного статуса выполняется ровно один
+func TestConcurrentTransitionStatus(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// run
+	var wg sync.WaitGroup
+	results := make(chan error, concurrencyWorkers)
+	for w := 0; w < concurrencyWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- store.TransitionStatus(id, ParcelStatusRegistered, ParcelStatusSent)
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	// check
+	succeeded := 0
+	for err := range results {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		require.ErrorIs(t, err, ErrStatusChanged)
+	}
+	require.Equal(t, 1, succeeded)
+}
+
+// TestConcurrentFileClaim проверяет, что параллельно можно открыть только одно страховое требование
+func TestConcurrentFileClaim(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	id, err := store.Add(getTestInsuredParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(id, ParcelStatusLost))
+
+	// run
+	var wg sync.WaitGroup
+	results := make(chan error, concurrencyWorkers)
+	for w := 0; w < concurrencyWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.FileClaim(id, 1000, "не пришла")
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	// check
+	succeeded := 0
+	for err := range results {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		require.ErrorIs(t, err, ErrClaimExists)
+	}
+	require.Equal(t, 1, succeeded)
+}
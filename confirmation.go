@@ -0,0 +1,223 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AttachmentKindSignature — подпись получателя при доставке
+const AttachmentKindSignature = "signature"
+
+var (
+	ErrInvalidConfirmation  = errors.New("некорректное подтверждение доставки")
+	ErrDeliveryConfirmed    = errors.New("доставка посылки уже подтверждена")
+	ErrDeliveryNotConfirmed = errors.New("доставку нужно подтвердить подписью получателя, см. ConfirmDelivery")
+	ErrIDNotChecked         = errors.New("посылку с проверкой возраста можно вручить только после проверки документа получателя")
+)
+
+// DeliveryConfirmation — подтверждение вручения посылки получателю
+type DeliveryConfirmation struct {
+	Parcel     int    `json:"-"`
+	SignerName string `json:"signer_name"`
+	// Signature — изображение подписи; в GetDeliveryConfirmation без содержимого
+	Signature Attachment `json:"signature"`
+	// IDChecked — курьер проверил документ получателя, обязательно для
+	// посылок с Parcel.AgeVerification
+	IDChecked   bool      `json:"id_checked"`
+	ConfirmedAt time.Time `json:"confirmed_at"`
+}
+
+// Validate проверяет подтверждение перед сохранением
+func (c DeliveryConfirmation) Validate() error {
+	if strings.TrimSpace(c.SignerName) == "" {
+		return fmt.Errorf("%w: не указано имя получателя", ErrInvalidConfirmation)
+	}
+	if !strings.HasPrefix(c.Signature.ContentType, "image/") {
+		return fmt.Errorf("%w: подпись должна быть изображением", ErrInvalidConfirmation)
+	}
+
+	return nil
+}
+
+// WithSignatureRequired запрещает переводить посылку в delivered без
+// подтверждения ConfirmDelivery. Не касается выдачи в пункте выдачи по
+// коду, доставки перевозчиком и исправления статуса через SetStatus.
+func WithSignatureRequired() StoreOption {
+	return func(s *ParcelStore) {
+		s.requireSignature = true
+	}
+}
+
+// ConfirmDelivery сохраняет подпись и имя получателя отправленной посылки
+// и переводит её в delivered. Подпись хранится вложением
+// AttachmentKindSignature. Если перевод не удался, подтверждение удаляется.
+// Посылку с проверкой возраста без отметки IDChecked не подтверждает,
+// возвращает ErrIDNotChecked.
+func (s ParcelStore) ConfirmDelivery(number int, c DeliveryConfirmation) (DeliveryConfirmation, error) {
+	c.Parcel = number
+	c.Signature.Parcel = number
+	c.Signature.Kind = AttachmentKindSignature
+	if err := c.Validate(); err != nil {
+		return DeliveryConfirmation{}, err
+	}
+	if err := c.Signature.Validate(); err != nil {
+		return DeliveryConfirmation{}, err
+	}
+
+	p, err := s.Get(number)
+	if err != nil {
+		return DeliveryConfirmation{}, err
+	}
+	if p.AgeVerification && !c.IDChecked {
+		return DeliveryConfirmation{}, ErrIDNotChecked
+	}
+
+	now := s.now()
+	c.ConfirmedAt = now.UTC()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return DeliveryConfirmation{}, err
+	}
+	defer tx.Rollback()
+
+	if c.Signature.ID, err = s.insertAttachment(tx, c.Signature); err != nil {
+		return DeliveryConfirmation{}, err
+	}
+	_, err = tx.Exec("INSERT INTO {delivery_confirmation} (parcel, signer_name, signature, id_checked, confirmed_at) "+
+		"VALUES (:parcel, :signer_name, :signature, :id_checked, :confirmed_at)",
+		sql.Named("parcel", number),
+		sql.Named("signer_name", c.SignerName),
+		sql.Named("signature", c.Signature.ID),
+		sql.Named("id_checked", c.IDChecked),
+		sql.Named("confirmed_at", formatTime(now)))
+	if isUniqueViolation(err) {
+		return DeliveryConfirmation{}, ErrDeliveryConfirmed
+	}
+	if err != nil {
+		return DeliveryConfirmation{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return DeliveryConfirmation{}, err
+	}
+
+	if err := s.transitionStatusAt(number, ParcelStatusSent, ParcelStatusDelivered, now); err != nil {
+		_, derr := s.db.Exec("DELETE FROM {attachment} WHERE id = :id", sql.Named("id", c.Signature.ID))
+		return DeliveryConfirmation{}, errors.Join(err, derr)
+	}
+
+	return c, nil
+}
+
+// GetDeliveryConfirmation возвращает подтверждение доставки посылки,
+// подпись — без содержимого, см. GetAttachment
+func (s ParcelStore) GetDeliveryConfirmation(number int) (DeliveryConfirmation, error) {
+	c := DeliveryConfirmation{Parcel: number}
+	err := s.db.QueryRow("SELECT c.signer_name, c.id_checked, c.confirmed_at, a.id, a.parcel, a.kind, a.name, a.content_type, a.created_at "+
+		"FROM {delivery_confirmation} c JOIN {attachment} a ON a.id = c.signature WHERE c.parcel = :parcel",
+		sql.Named("parcel", number)).
+		Scan(&c.SignerName, &c.IDChecked, scanTime(&c.ConfirmedAt), &c.Signature.ID, &c.Signature.Parcel, &c.Signature.Kind,
+			&c.Signature.Name, &c.Signature.ContentType, scanTime(&c.Signature.CreatedAt))
+	if err != nil {
+		return DeliveryConfirmation{}, err
+	}
+
+	return c, nil
+}
+
+// checkDeliveryConfirmed возвращает ErrDeliveryNotConfirmed, если
+// хранилище требует подпись, а доставку посылки p не подтвердили.
+// Посылке с проверкой возраста, в том числе в пункте выдачи, нужно
+// подтверждение с проверенным документом независимо от настроек
+// хранилища, иначе — ErrIDNotChecked. Документ при доставке перевозчиком
+// проверяет перевозчик.
+func (s ParcelStore) checkDeliveryConfirmed(p Parcel) error {
+	if !p.AgeVerification && (!s.requireSignature || p.PickupPoint != 0) {
+		return nil
+	}
+
+	var ok bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM {delivery_confirmation} WHERE parcel = :parcel AND (id_checked OR NOT :age)) "+
+		"OR EXISTS (SELECT 1 FROM {carrier_shipment} WHERE parcel = :parcel)",
+		sql.Named("parcel", p.Number),
+		sql.Named("age", p.AgeVerification)).Scan(&ok)
+	if err != nil {
+		return err
+	}
+	switch {
+	case ok:
+	case p.AgeVerification:
+		return ErrIDNotChecked
+	default:
+		return ErrDeliveryNotConfirmed
+	}
+
+	return nil
+}
+
+// isDeliveryUnconfirmed сообщает, что err — отказ checkDeliveryConfirmed
+func isDeliveryUnconfirmed(err error) bool {
+	return errors.Is(err, ErrDeliveryNotConfirmed) || errors.Is(err, ErrIDNotChecked)
+}
+
+// maxConfirmationBody — наибольший размер тела подтверждения доставки
+// с подписью в base64
+const maxConfirmationBody = 2 * MaxAttachmentSize
+
+// NewDeliveryConfirmationHandler возвращает обработчик POST
+// /courier/deliveries для приложения курьера: JSON {"code", "signer_name",
+// "id_checked", "signature": {"content_type", "data"}}, где code — UUID
+// посылки, а data — изображение подписи в base64
+func NewDeliveryConfirmationHandler(store ParcelStore, errLog *ErrorLog) http.Handler {
+	h := AdminHandler{store: store, errors: errLog}
+
+	return h.postOnly(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Code       string `json:"code"`
+			SignerName string `json:"signer_name"`
+			IDChecked  bool   `json:"id_checked"`
+			Signature  struct {
+				Name        string `json:"name"`
+				ContentType string `json:"content_type"`
+				Data        []byte `json:"data"`
+			} `json:"signature"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxConfirmationBody)).Decode(&req); err != nil {
+			http.Error(w, "некорректный JSON", http.StatusBadRequest)
+			return
+		}
+
+		p, err := store.GetByUUID(strings.ToLower(req.Code))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "посылка не найдена", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		c, err := store.ForRequest(r.Context()).ConfirmDelivery(p.Number, DeliveryConfirmation{
+			SignerName: req.SignerName,
+			IDChecked:  req.IDChecked,
+			Signature:  Attachment{Name: req.Signature.Name, ContentType: req.Signature.ContentType, Data: req.Signature.Data},
+		})
+		switch {
+		case errors.Is(err, ErrInvalidConfirmation), errors.Is(err, ErrInvalidAttachment), errors.Is(err, ErrIDNotChecked):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrDeliveryConfirmed), errors.Is(err, ErrStatusChanged), errors.Is(err, ErrInvalidTransition):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			c.Signature.Data = nil
+			w.WriteHeader(http.StatusCreated)
+			writeJSON(w, c)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// getTestSignature возвращает тестовое изображение подписи
+func getTestSignature() Attachment {
+	return Attachment{
+		Name:        "signature.png",
+		ContentType: "image/png",
+		Data:        []byte{0x89, 0x50, 0x4e, 0x47},
+	}
+}
+
+// TestConfirmDelivery проверяет подтверждение доставки подписью получателя
+func TestConfirmDelivery(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithSignatureRequired())
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+
+	// без подписи доставить нельзя
+	err = store.TransitionStatus(number, ParcelStatusSent, ParcelStatusDelivered)
+	require.ErrorIs(t, err, ErrDeliveryNotConfirmed)
+
+	// некорректное подтверждение не сохраняется
+	_, err = store.ConfirmDelivery(number, DeliveryConfirmation{SignerName: " ", Signature: getTestSignature()})
+	require.ErrorIs(t, err, ErrInvalidConfirmation)
+	pdf := getTestSignature()
+	pdf.ContentType = "application/pdf"
+	_, err = store.ConfirmDelivery(number, DeliveryConfirmation{SignerName: "Иванов", Signature: pdf})
+	require.ErrorIs(t, err, ErrInvalidConfirmation)
+
+	// confirm
+	c, err := store.ConfirmDelivery(number, DeliveryConfirmation{SignerName: "Иванов", Signature: getTestSignature()})
+	require.NoError(t, err)
+	require.NotZero(t, c.Signature.ID)
+
+	// check
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDelivered, stored.Status)
+
+	got, err := store.GetDeliveryConfirmation(number)
+	require.NoError(t, err)
+	require.Equal(t, "Иванов", got.SignerName)
+	require.Equal(t, AttachmentKindSignature, got.Signature.Kind)
+	require.Nil(t, got.Signature.Data)
+
+	signature, err := store.GetAttachment(got.Signature.ID)
+	require.NoError(t, err)
+	require.Equal(t, getTestSignature().Data, signature.Data)
+
+	// повторно подтвердить нельзя
+	_, err = store.ConfirmDelivery(number, DeliveryConfirmation{SignerName: "Петров", Signature: getTestSignature()})
+	require.ErrorIs(t, err, ErrDeliveryConfirmed)
+}
+
+// TestConfirmDeliveryNotSent проверяет, что подтверждение не отправленной
+// посылки откатывается вместе с подписью
+func TestConfirmDeliveryNotSent(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// confirm
+	_, err = store.ConfirmDelivery(number, DeliveryConfirmation{SignerName: "Иванов", Signature: getTestSignature()})
+	require.ErrorIs(t, err, ErrStatusChanged)
+
+	// check
+	_, err = store.GetDeliveryConfirmation(number)
+	require.Error(t, err)
+	attachments, err := store.ListAttachments(number, AttachmentKindSignature)
+	require.NoError(t, err)
+	require.Empty(t, attachments)
+}
+
+// TestDeliveryWithoutSignatureRequirement проверяет, что без
+// WithSignatureRequired посылку можно доставить без подписи
+func TestDeliveryWithoutSignatureRequirement(t *testing.T) {
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+	require.NoError(t, store.TransitionStatus(number, ParcelStatusSent, ParcelStatusDelivered))
+}
+
+// TestDeliveryConfirmationHandler проверяет подтверждение доставки через
+// API приложения курьера
+func TestDeliveryConfirmationHandler(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithSignatureRequired())
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+	p, err := store.Get(number)
+	require.NoError(t, err)
+	handler := NewDeliveryConfirmationHandler(store, NewErrorLog(10))
+
+	post := func(body any) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/courier/deliveries", strings.NewReader(string(data))))
+		return rec
+	}
+	signature := map[string]any{"content_type": "image/png", "data": getTestSignature().Data}
+
+	// check
+	require.Equal(t, http.StatusNotFound, post(map[string]any{"code": "нет", "signer_name": "Иванов", "signature": signature}).Code)
+	require.Equal(t, http.StatusBadRequest, post(map[string]any{"code": p.UUID, "signature": signature}).Code)
+
+	rec := post(map[string]any{"code": strings.ToUpper(p.UUID), "signer_name": "Иванов", "signature": signature})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Contains(t, rec.Body.String(), `"signer_name":"Иванов"`)
+
+	require.Equal(t, http.StatusConflict, post(map[string]any{"code": p.UUID, "signer_name": "Иванов", "signature": signature}).Code)
+}
+
+// TestConfirmDeliveryAgeVerification проверяет, что посылку с проверкой
+// возраста вручают только после проверки документа
+func TestConfirmDeliveryAgeVerification(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	p := getTestParcel()
+	p.AgeVerification = true
+	number, err := store.Add(p)
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.True(t, stored.AgeVerification)
+
+	// без подтверждения и без проверки документа доставить нельзя
+	err = store.TransitionStatus(number, ParcelStatusSent, ParcelStatusDelivered)
+	require.ErrorIs(t, err, ErrIDNotChecked)
+	_, err = store.ConfirmDelivery(number, DeliveryConfirmation{SignerName: "Иванов", Signature: getTestSignature()})
+	require.ErrorIs(t, err, ErrIDNotChecked)
+
+	// confirm
+	_, err = store.ConfirmDelivery(number, DeliveryConfirmation{SignerName: "Иванов", Signature: getTestSignature(), IDChecked: true})
+	require.NoError(t, err)
+
+	// check
+	stored, err = store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDelivered, stored.Status)
+
+	c, err := store.GetDeliveryConfirmation(number)
+	require.NoError(t, err)
+	require.True(t, c.IDChecked)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Категории вложения, требующие особого обращения
+const (
+	ContentFragile    = "fragile"
+	ContentLiquid     = "liquid"
+	ContentBattery    = "battery"
+	ContentRestricted = "restricted"
+)
+
+// contentCategories — известные категории вложения
+var contentCategories = map[string]bool{
+	ContentFragile:    true,
+	ContentLiquid:     true,
+	ContentBattery:    true,
+	ContentRestricted: true,
+}
+
+var (
+	ErrInvalidContents    = errors.New("некорректная категория вложения")
+	ErrContentsRestricted = errors.New("вложение запрещено к пересылке в зону доставки")
+	ErrInvalidContentRule = errors.New("некорректное правило пересылки вложений")
+)
+
+// Contents — категории вложения посылки. Хранятся в столбце contents
+// через запятую, пустой список хранится как пустая строка и читается как nil.
+type Contents []string
+
+// Validate проверяет, что все категории известны и не повторяются
+func (c Contents) Validate() error {
+	seen := make(map[string]bool, len(c))
+	for _, category := range c {
+		if !contentCategories[category] {
+			return fmt.Errorf("%w: %q", ErrInvalidContents, category)
+		}
+		if seen[category] {
+			return fmt.Errorf("%w: %q указана дважды", ErrInvalidContents, category)
+		}
+		seen[category] = true
+	}
+
+	return nil
+}
+
+// Has сообщает, что вложение относится к категории category
+func (c Contents) Has(category string) bool {
+	for _, v := range c {
+		if v == category {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Value записывает категории вложения в БД через запятую
+func (c Contents) Value() (driver.Value, error) {
+	return strings.Join(c, ","), nil
+}
+
+// Scan читает категории вложения из столбца contents
+func (c *Contents) Scan(src any) error {
+	var data string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		return fmt.Errorf("категории вложения: неподдерживаемый тип %T", src)
+	}
+
+	*c = nil
+	if data != "" {
+		*c = strings.Split(data, ",")
+	}
+
+	return nil
+}
+
+// ContentRule запрещает пересылку вложений категории Category в зону
+// доставки Zone. Пустая зона распространяет запрет на все зоны.
+type ContentRule struct {
+	ID       int    `json:"id"`
+	Zone     string `json:"zone"`
+	Category string `json:"category"`
+}
+
+// AddContentRule добавляет запрет пересылки вложений
+func (s ParcelStore) AddContentRule(r ContentRule) (int, error) {
+	if !contentCategories[r.Category] {
+		return 0, fmt.Errorf("%w: неизвестная категория %q", ErrInvalidContentRule, r.Category)
+	}
+
+	res, err := s.db.Exec("INSERT INTO {content_rule} (zone, category) VALUES (:zone, :category)",
+		sql.Named("zone", r.Zone),
+		sql.Named("category", r.Category))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// ListContentRules возвращает все запреты пересылки вложений
+func (s ParcelStore) ListContentRules() ([]ContentRule, error) {
+	rows, err := s.db.Query("SELECT id, zone, category FROM {content_rule} ORDER BY zone, category")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []ContentRule
+	for rows.Next() {
+		r := ContentRule{}
+		if err := rows.Scan(&r.ID, &r.Zone, &r.Category); err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// DeleteContentRule удаляет запрет пересылки вложений.
+// Уже добавленные посылки при этом не проверяются заново.
+func (s ParcelStore) DeleteContentRule(id int) error {
+	_, err := s.db.Exec("DELETE FROM {content_rule} WHERE id = :id", sql.Named("id", id))
+
+	return err
+}
+
+// checkContents проверяет вложение посылки по запретам для её зоны доставки
+// и возвращает ErrContentsRestricted для первой запрещённой категории
+func (s ParcelStore) checkContents(p Parcel) error {
+	if len(p.Contents) == 0 {
+		return nil
+	}
+
+	rows, err := s.db.Query("SELECT category FROM {content_rule} WHERE zone = '' OR zone = :zone ORDER BY category",
+		sql.Named("zone", p.Zone))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			return err
+		}
+		if p.Contents.Has(category) {
+			return fmt.Errorf("%w: %s в зону %q", ErrContentsRestricted, category, p.Zone)
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestContentRules проверяет отклонение посылок с вложением,
+// запрещённым к пересылке в зону доставки
+func TestContentRules(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	addTestZones(t, store)
+	for _, r := range []ContentRule{
+		{Zone: "cis", Category: ContentBattery},
+		{Zone: "", Category: ContentRestricted},
+	} {
+		_, err := store.AddContentRule(r)
+		require.NoError(t, err)
+	}
+
+	parcel := func(country string, contents ...string) Parcel {
+		p := getTestParcel()
+		p.Country = country
+		p.PostalCode = "050000"
+		p.Contents = contents
+		return p
+	}
+
+	// check
+	number, err := store.Add(parcel("RU", ContentFragile, ContentBattery))
+	require.NoError(t, err)
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, Contents{ContentFragile, ContentBattery}, stored.Contents)
+
+	_, err = store.Add(parcel("KZ", ContentFragile, ContentBattery))
+	require.ErrorIs(t, err, ErrContentsRestricted)
+
+	_, err = store.Add(parcel("RU", ContentRestricted))
+	require.ErrorIs(t, err, ErrContentsRestricted)
+
+	_, err = store.Add(parcel("KZ", ContentLiquid))
+	require.NoError(t, err)
+
+	_, err = store.Add(parcel("RU", "explosive"))
+	require.ErrorIs(t, err, ErrInvalidContents)
+
+	_, err = store.AddContentRule(ContentRule{Zone: "cis", Category: "explosive"})
+	require.ErrorIs(t, err, ErrInvalidContentRule)
+
+	// без правила батареи в зону cis принимаются
+	rules, err := store.ListContentRules()
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, "cis", rules[1].Zone)
+	require.NoError(t, store.DeleteContentRule(rules[1].ID))
+	_, err = store.Add(parcel("KZ", ContentBattery))
+	require.NoError(t, err)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	CourierPickupRequested = "requested"
+	CourierPickupConfirmed = "confirmed"
+	// CourierPickupCompleted — курьер забрал все посылки вызова
+	CourierPickupCompleted = "completed"
+)
+
+// MaxPickupWindow — наибольшая длительность окна забора посылок
+const MaxPickupWindow = 12 * time.Hour
+
+var (
+	ErrInvalidPickupWindow = errors.New("некорректное окно забора посылок")
+	ErrPickupParcel        = errors.New("посылку нельзя включить в вызов курьера")
+	ErrPickupExists        = errors.New("посылка уже включена в вызов курьера")
+	ErrPickupNotRequested  = errors.New("вызов курьера уже подтверждён или выполнен")
+	ErrPickupNotConfirmed  = errors.New("вызов курьера не подтверждён")
+	ErrPickupCollected     = errors.New("посылка уже забрана курьером")
+)
+
+// CourierPickup — вызов курьера за зарегистрированными посылками клиента
+type CourierPickup struct {
+	ID          int
+	Client      int
+	Address     string
+	WindowStart time.Time
+	WindowEnd   time.Time
+	Status      string
+	Parcels     []int
+	RequestedAt time.Time
+	ConfirmedBy string
+	// ConfirmedAt — время подтверждения диспетчером, нулевое у неподтверждённого вызова
+	ConfirmedAt time.Time
+}
+
+// validatePickupWindow проверяет, что окно [start, end) ещё не началось
+// и не длиннее MaxPickupWindow
+func validatePickupWindow(start time.Time, end time.Time, now time.Time) error {
+	switch {
+	case !end.After(start):
+		return fmt.Errorf("%w: конец окна не позже начала", ErrInvalidPickupWindow)
+	case end.Sub(start) > MaxPickupWindow:
+		return fmt.Errorf("%w: окно длиннее %s", ErrInvalidPickupWindow, MaxPickupWindow)
+	case start.Before(now):
+		return fmt.Errorf("%w: окно в прошлом", ErrInvalidPickupWindow)
+	}
+
+	return nil
+}
+
+// RequestPickup регистрирует вызов курьера по адресу address в окно
+// [start, end) за посылками клиента client. Посылки должны принадлежать
+// клиенту и быть в статусе registered; посылка входит не больше чем
+// в один вызов.
+func (s ParcelStore) RequestPickup(client int, address string, start time.Time, end time.Time, parcels []int) (int, error) {
+	if strings.TrimSpace(address) == "" {
+		return 0, fmt.Errorf("%w: пустой адрес", ErrInvalidParcel)
+	}
+	if len(parcels) == 0 {
+		return 0, fmt.Errorf("%w: нет посылок", ErrPickupParcel)
+	}
+	if err := validatePickupWindow(start, end, s.now()); err != nil {
+		return 0, err
+	}
+
+	for _, number := range parcels {
+		p, err := s.Get(number)
+		if err != nil {
+			return 0, err
+		}
+		if p.Client != client || p.Status != ParcelStatusRegistered {
+			return 0, fmt.Errorf("%w: посылка № %d", ErrPickupParcel, number)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO {courier_pickup} (client, address, window_start, window_end, status, requested_at) "+
+		"VALUES (:client, :address, :window_start, :window_end, :status, :requested_at)",
+		sql.Named("client", client),
+		sql.Named("address", address),
+		sql.Named("window_start", formatTime(start)),
+		sql.Named("window_end", formatTime(end)),
+		sql.Named("status", CourierPickupRequested),
+		sql.Named("requested_at", formatTime(s.now())))
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, number := range parcels {
+		_, err := tx.Exec("INSERT INTO {courier_pickup_parcel} (parcel, pickup) VALUES (:parcel, :pickup)",
+			sql.Named("parcel", number),
+			sql.Named("pickup", id))
+		if isUniqueViolation(err) {
+			return 0, fmt.Errorf("%w: посылка № %d", ErrPickupExists, number)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// ConfirmPickup подтверждает вызов курьера диспетчером dispatcher
+func (s ParcelStore) ConfirmPickup(id int, dispatcher string) error {
+	if dispatcher == "" {
+		return ErrEmptyOperator
+	}
+
+	res, err := s.db.Exec("UPDATE {courier_pickup} SET status = :confirmed, confirmed_by = :dispatcher, confirmed_at = :now "+
+		"WHERE id = :id AND status = :requested",
+		sql.Named("confirmed", CourierPickupConfirmed),
+		sql.Named("dispatcher", dispatcher),
+		sql.Named("now", formatTime(s.now())),
+		sql.Named("id", id),
+		sql.Named("requested", CourierPickupRequested))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		if _, err := s.GetPickup(id); err != nil {
+			return err
+		}
+		return ErrPickupNotRequested
+	}
+
+	return nil
+}
+
+// ScanPickup отмечает, что курьер забрал посылку по подтверждённому вызову,
+// и переводит её в статус sent. Когда забраны все посылки, вызов выполнен.
+func (s ParcelStore) ScanPickup(id int, number int) error {
+	var status, collected string
+	err := s.db.QueryRow("SELECT c.status, cp.collected_at FROM {courier_pickup} c "+
+		"JOIN {courier_pickup_parcel} cp ON cp.pickup = c.id WHERE c.id = :id AND cp.parcel = :parcel",
+		sql.Named("id", id),
+		sql.Named("parcel", number)).Scan(&status, &collected)
+	if err != nil {
+		return err
+	}
+	switch {
+	case collected != "":
+		return ErrPickupCollected
+	case status != CourierPickupConfirmed:
+		return ErrPickupNotConfirmed
+	}
+
+	now := s.now()
+	if err := s.transitionStatusAt(number, ParcelStatusRegistered, ParcelStatusSent, now); err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("UPDATE {courier_pickup_parcel} SET collected_at = :now WHERE parcel = :parcel",
+		sql.Named("now", formatTime(now)),
+		sql.Named("parcel", number))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("UPDATE {courier_pickup} SET status = :completed WHERE id = :id "+
+		"AND NOT EXISTS (SELECT 1 FROM {courier_pickup_parcel} WHERE pickup = :id AND collected_at = '')",
+		sql.Named("completed", CourierPickupCompleted),
+		sql.Named("id", id))
+
+	return err
+}
+
+// GetPickup возвращает вызов курьера с номерами посылок
+func (s ParcelStore) GetPickup(id int) (CourierPickup, error) {
+	rows, err := s.db.Query(courierPickupSelect+"WHERE id = :id", sql.Named("id", id))
+	if err != nil {
+		return CourierPickup{}, err
+	}
+
+	pickups, err := s.scanPickups(rows)
+	if err != nil {
+		return CourierPickup{}, err
+	}
+	if len(pickups) == 0 {
+		return CourierPickup{}, sql.ErrNoRows
+	}
+
+	return pickups[0], nil
+}
+
+// ListPickupsForDate возвращает вызовы курьера, окно которых начинается
+// в день date (UTC), в порядке начала окна
+func (s ParcelStore) ListPickupsForDate(date time.Time) ([]CourierPickup, error) {
+	day := date.UTC().Truncate(24 * time.Hour)
+	rows, err := s.db.Query(courierPickupSelect+"WHERE window_start >= :from AND window_start < :to ORDER BY window_start, id",
+		sql.Named("from", formatTime(day)),
+		sql.Named("to", formatTime(day.AddDate(0, 0, 1))))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.scanPickups(rows)
+}
+
+// courierPickupSelect — начало запроса вызовов курьера для scanPickups
+const courierPickupSelect = "SELECT id, client, address, window_start, window_end, status, requested_at, " +
+	"confirmed_by, confirmed_at FROM {courier_pickup} "
+
+// scanPickups читает вызовы курьера и дополняет их номерами посылок
+func (s ParcelStore) scanPickups(rows *sql.Rows) ([]CourierPickup, error) {
+	var res []CourierPickup
+	for rows.Next() {
+		c := CourierPickup{}
+		err := rows.Scan(&c.ID, &c.Client, &c.Address, scanTime(&c.WindowStart), scanTime(&c.WindowEnd), &c.Status,
+			scanTime(&c.RequestedAt), &c.ConfirmedBy, scanTime(&c.ConfirmedAt))
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		res = append(res, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range res {
+		parcels, err := s.pickupParcels(res[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		res[i].Parcels = parcels
+	}
+
+	return res, nil
+}
+
+// pickupParcels возвращает номера посылок вызова курьера по возрастанию
+func (s ParcelStore) pickupParcels(id int) ([]int, error) {
+	rows, err := s.db.Query("SELECT parcel FROM {courier_pickup_parcel} WHERE pickup = :pickup ORDER BY parcel",
+		sql.Named("pickup", id))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []int
+	for rows.Next() {
+		var number int
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		res = append(res, number)
+	}
+
+	return res, rows.Err()
+}
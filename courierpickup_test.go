@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestCourierPickup проверяет вызов курьера от запроса до забора посылок
+func TestCourierPickup(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	var numbers []int
+	for i := 0; i < 2; i++ {
+		number, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		numbers = append(numbers, number)
+	}
+	start := time.Now().UTC().Add(48 * time.Hour).Truncate(24 * time.Hour).Add(10 * time.Hour)
+	end := start.Add(3 * time.Hour)
+
+	// request
+	id, err := store.RequestPickup(1000, "Псков, ул. Колотушкина, д. 5", start, end, numbers)
+	require.NoError(t, err)
+
+	// посылка не входит в два вызова
+	_, err = store.RequestPickup(1000, "Псков", start, end, numbers[:1])
+	require.ErrorIs(t, err, ErrPickupExists)
+
+	// до подтверждения курьер посылку не забирает
+	require.ErrorIs(t, store.ScanPickup(id, numbers[0]), ErrPickupNotConfirmed)
+
+	// confirm
+	require.NoError(t, store.ConfirmPickup(id, "dispatcher"))
+	require.ErrorIs(t, store.ConfirmPickup(id, "dispatcher"), ErrPickupNotRequested)
+
+	// scan
+	require.NoError(t, store.ScanPickup(id, numbers[0]))
+	require.ErrorIs(t, store.ScanPickup(id, numbers[0]), ErrPickupCollected)
+
+	// check
+	p, err := store.Get(numbers[0])
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, p.Status)
+
+	pickup, err := store.GetPickup(id)
+	require.NoError(t, err)
+	require.Equal(t, CourierPickupConfirmed, pickup.Status)
+	require.Equal(t, numbers, pickup.Parcels)
+	require.Equal(t, "dispatcher", pickup.ConfirmedBy)
+	require.True(t, start.Equal(pickup.WindowStart))
+
+	require.NoError(t, store.ScanPickup(id, numbers[1]))
+	pickup, err = store.GetPickup(id)
+	require.NoError(t, err)
+	require.Equal(t, CourierPickupCompleted, pickup.Status)
+
+	pickups, err := store.ListPickupsForDate(start)
+	require.NoError(t, err)
+	require.Len(t, pickups, 1)
+	require.Equal(t, id, pickups[0].ID)
+	pickups, err = store.ListPickupsForDate(start.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Empty(t, pickups)
+}
+
+// TestRequestPickupInvalid проверяет отказ в вызове курьера
+func TestRequestPickupInvalid(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	start := time.Now().Add(time.Hour)
+
+	// check
+	_, err = store.RequestPickup(1000, "Псков", start, start, []int{number})
+	require.ErrorIs(t, err, ErrInvalidPickupWindow)
+	_, err = store.RequestPickup(1000, "Псков", start, start.Add(MaxPickupWindow+time.Hour), []int{number})
+	require.ErrorIs(t, err, ErrInvalidPickupWindow)
+	_, err = store.RequestPickup(1000, "Псков", start.Add(-2*time.Hour), start, []int{number})
+	require.ErrorIs(t, err, ErrInvalidPickupWindow)
+
+	// чужая и уже отправленная посылки в вызов не входят
+	_, err = store.RequestPickup(1001, "Псков", start, start.Add(time.Hour), []int{number})
+	require.ErrorIs(t, err, ErrPickupParcel)
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+	_, err = store.RequestPickup(1000, "Псков", start, start.Add(time.Hour), []int{number})
+	require.ErrorIs(t, err, ErrPickupParcel)
+}
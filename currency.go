@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency — валюта сумм посылки, если она не указана
+const DefaultCurrency = "RUB"
+
+// RateScale — множитель курса: курс 92.5 передаётся как 92_500_000.
+// Курс в целых числах не накапливает ошибку округления, как float64.
+const RateScale = 1_000_000
+
+var (
+	ErrUnknownCurrency = errors.New("неизвестная валюта")
+	ErrNoRate          = errors.New("нет курса обмена")
+)
+
+// currencyExponents — число знаков дробной части у поддерживаемых валют
+// по ISO 4217: суммы хранятся в целых младших единицах, копейках для RUB
+var currencyExponents = map[string]int{
+	"RUB": 2,
+	"BYN": 2,
+	"KZT": 2,
+	"USD": 2,
+	"EUR": 2,
+	"CNY": 2,
+	"JPY": 0,
+}
+
+// IsSupportedCurrency сообщает, что суммы в валюте code можно хранить
+func IsSupportedCurrency(code string) bool {
+	_, ok := currencyExponents[code]
+
+	return ok
+}
+
+// FormatAmount возвращает сумму в младших единицах валюты в основных
+// единицах, например 35000 RUB как 350.00
+func FormatAmount(amount int64, currency string) string {
+	exp, ok := currencyExponents[currency]
+	if !ok {
+		exp = 2
+	}
+
+	s := strconv.FormatInt(amount, 10)
+	sign := ""
+	if amount < 0 {
+		sign, s = "-", s[1:]
+	}
+	if exp == 0 {
+		return sign + s
+	}
+	if len(s) <= exp {
+		s = strings.Repeat("0", exp-len(s)+1) + s
+	}
+
+	return sign + s[:len(s)-exp] + "." + s[len(s)-exp:]
+}
+
+// RateProvider возвращает курс обмена: за одну основную единицу валюты from
+// дают rate/RateScale основных единиц валюты to. Реализации подключаются
+// встраивающим приложением, например курсы ЦБ на дату.
+type RateProvider interface {
+	Rate(from string, to string) (int64, error)
+}
+
+// StaticRates — фиксированные курсы к базовой валюте Base: Rates[c] —
+// стоимость основной единицы c в Base, умноженная на RateScale
+type StaticRates struct {
+	Base  string
+	Rates map[string]int64
+}
+
+// Rate возвращает курс обмена через базовую валюту
+func (r StaticRates) Rate(from string, to string) (int64, error) {
+	toBase := func(c string) (int64, error) {
+		if c == r.Base {
+			return RateScale, nil
+		}
+		rate, ok := r.Rates[c]
+		if !ok || rate <= 0 {
+			return 0, fmt.Errorf("%w: %s/%s", ErrNoRate, c, r.Base)
+		}
+		return rate, nil
+	}
+
+	fromRate, err := toBase(from)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := toBase(to)
+	if err != nil {
+		return 0, err
+	}
+
+	rate := new(big.Int).Mul(big.NewInt(fromRate), big.NewInt(RateScale))
+
+	return roundDiv(rate, big.NewInt(toRate)).Int64(), nil
+}
+
+// Convert переводит сумму в младших единицах валюты from в младшие единицы
+// валюты to по курсу rates с округлением половины от нуля
+func Convert(amount int64, from string, to string, rates RateProvider) (int64, error) {
+	fromExp, ok := currencyExponents[from]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownCurrency, from)
+	}
+	toExp, ok := currencyExponents[to]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownCurrency, to)
+	}
+	if from == to {
+		return amount, nil
+	}
+
+	rate, err := rates.Rate(from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	num := new(big.Int).Mul(big.NewInt(amount), big.NewInt(rate))
+	den := big.NewInt(RateScale)
+	ten := big.NewInt(10)
+	if toExp > fromExp {
+		num.Mul(num, new(big.Int).Exp(ten, big.NewInt(int64(toExp-fromExp)), nil))
+	} else {
+		den.Mul(den, new(big.Int).Exp(ten, big.NewInt(int64(fromExp-toExp)), nil))
+	}
+
+	res := roundDiv(num, den)
+	if !res.IsInt64() {
+		return 0, fmt.Errorf("сумма %d %s в %s не помещается в int64", amount, from, to)
+	}
+
+	return res.Int64(), nil
+}
+
+// roundDiv делит num на положительный den с округлением половины от нуля
+func roundDiv(num *big.Int, den *big.Int) *big.Int {
+	half := new(big.Int).Rsh(den, 1)
+	n := new(big.Int).Abs(num)
+	n.Add(n, half).Quo(n, den)
+	if num.Sign() < 0 {
+		n.Neg(n)
+	}
+
+	return n
+}
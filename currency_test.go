@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestFormatAmount проверяет вывод сумм в основных единицах валюты
+func TestFormatAmount(t *testing.T) {
+	for _, c := range []struct {
+		amount   int64
+		currency string
+		want     string
+	}{
+		{35000, "RUB", "350.00"},
+		{5, "USD", "0.05"},
+		{-1250, "EUR", "-12.50"},
+		{1500, "JPY", "1500"},
+	} {
+		require.Equal(t, c.want, FormatAmount(c.amount, c.currency))
+	}
+}
+
+// TestConvert проверяет перевод сумм между валютами по целочисленным курсам
+func TestConvert(t *testing.T) {
+	rates := StaticRates{Base: "RUB", Rates: map[string]int64{
+		"USD": 92_500_000,
+		"JPY": 620_000,
+	}}
+
+	for _, c := range []struct {
+		amount int64
+		from   string
+		to     string
+		want   int64
+	}{
+		{1000, "USD", "RUB", 92500},
+		{92500, "RUB", "USD", 1000},
+		// 1 копейка ≈ 0.0108 цента округляется до нуля
+		{1, "RUB", "USD", 0},
+		{100, "JPY", "RUB", 6200},
+		{1000, "USD", "JPY", 1492},
+		{-1000, "USD", "RUB", -92500},
+		{777, "RUB", "RUB", 777},
+	} {
+		got, err := Convert(c.amount, c.from, c.to, rates)
+		require.NoError(t, err)
+		require.Equal(t, c.want, got, "%d %s → %s", c.amount, c.from, c.to)
+	}
+
+	_, err := Convert(100, "EUR", "RUB", rates)
+	require.ErrorIs(t, err, ErrNoRate)
+	_, err = Convert(100, "XXX", "RUB", rates)
+	require.ErrorIs(t, err, ErrUnknownCurrency)
+}
+
+// TestCurrencyAggregates проверяет, что суммы разных валют не складываются
+func TestCurrencyAggregates(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	for _, currency := range []string{"RUB", "USD", "USD"} {
+		p := getTestParcel()
+		p.Currency = currency
+		p.Price = 1000
+		_, err := store.Add(p)
+		require.NoError(t, err)
+	}
+	bad := getTestParcel()
+	bad.Currency = "XXX"
+	_, err := store.Add(bad)
+	require.ErrorIs(t, err, ErrUnknownCurrency)
+
+	// check
+	rub, err := store.GenerateInvoice(1000, bad.CreatedAt, "")
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), rub.Total)
+
+	usd, err := store.GenerateInvoice(1000, bad.CreatedAt, "USD")
+	require.NoError(t, err)
+	require.Equal(t, "USD", usd.Currency)
+	require.Equal(t, int64(2000), usd.Total)
+
+	_, err = store.GenerateInvoice(1000, bad.CreatedAt, "USD")
+	require.ErrorIs(t, err, ErrInvoiceExists)
+
+	// фиксированный промокод в рублях не действует для посылки в долларах
+	require.NoError(t, store.AddPromoCode(PromoCode{Code: "FIXED", Amount: 100}))
+	p := getTestParcel()
+	p.Currency = "USD"
+	p.Price = 1000
+	p.PromoCode = "FIXED"
+	_, err = store.Add(p)
+	require.ErrorIs(t, err, ErrPromoCodeCurrency)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	CustomsFormCN22 = "CN22"
+	CustomsFormCN23 = "CN23"
+)
+
+// CN22ValueLimit — предельная объявленная ценность отправления для упрощённой
+// декларации CN22 в копейках (около 300 СПЗ), свыше оформляется CN23
+const CN22ValueLimit int64 = 3_000_000
+
+var (
+	ErrInvalidCustomsItem = errors.New("некорректная позиция таможенной декларации")
+	ErrNoCustomsItems     = errors.New("у посылки нет позиций таможенной декларации")
+)
+
+// CustomsItem — позиция таможенной декларации посылки
+type CustomsItem struct {
+	ID          int
+	Parcel      int
+	Description string
+	Quantity    int
+	// HSCode — код товара по Гармонизированной системе, 6–10 цифр
+	HSCode string
+	// Value — ценность позиции в копейках
+	Value int64
+}
+
+// Validate проверяет заполненность полей позиции
+func (i CustomsItem) Validate() error {
+	switch {
+	case i.Description == "":
+		return fmt.Errorf("%w: пустое описание", ErrInvalidCustomsItem)
+	case i.Quantity <= 0:
+		return fmt.Errorf("%w: количество должно быть положительным", ErrInvalidCustomsItem)
+	case i.Value < 0:
+		return fmt.Errorf("%w: отрицательная ценность", ErrInvalidCustomsItem)
+	case !isHSCode(i.HSCode):
+		return fmt.Errorf("%w: код ТН ВЭД %q", ErrInvalidCustomsItem, i.HSCode)
+	}
+
+	return nil
+}
+
+// isHSCode проверяет, что код состоит из 6–10 цифр
+func isHSCode(code string) bool {
+	if len(code) < 6 || len(code) > 10 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CustomsDeclaration — данные для печати декларации CN22/CN23
+type CustomsDeclaration struct {
+	Form          string
+	Parcel        int
+	Client        int
+	Address       string
+	Items         []CustomsItem
+	TotalQuantity int
+	TotalValue    int64
+}
+
+// AddCustomsItem добавляет позицию декларации к посылке.
+// Менять декларацию можно только если значение статуса registered.
+func (s ParcelStore) AddCustomsItem(item CustomsItem) (int, error) {
+	if err := item.Validate(); err != nil {
+		return 0, err
+	}
+
+	res, err := s.db.Exec("INSERT INTO {customs_item} (parcel, description, quantity, hs_code, value) "+
+		"SELECT number, :description, :quantity, :hs_code, :value FROM {parcel} "+
+		"WHERE number = :parcel AND status = :status",
+		sql.Named("description", item.Description),
+		sql.Named("quantity", item.Quantity),
+		sql.Named("hs_code", item.HSCode),
+		sql.Named("value", item.Value),
+		sql.Named("parcel", item.Parcel),
+		sql.Named("status", ParcelStatusRegistered))
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.checkRegisteredAffected(item.Parcel, res); err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetCustomsItems возвращает позиции декларации посылки
+func (s ParcelStore) GetCustomsItems(number int) ([]CustomsItem, error) {
+	rows, err := s.db.Query("SELECT id, parcel, description, quantity, hs_code, value "+
+		"FROM {customs_item} WHERE parcel = :parcel ORDER BY id",
+		sql.Named("parcel", number))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []CustomsItem
+	for rows.Next() {
+		i := CustomsItem{}
+		err := rows.Scan(&i.ID, &i.Parcel, &i.Description, &i.Quantity, &i.HSCode, &i.Value)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, i)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// UpdateCustomsItem обновляет позицию декларации посылки
+func (s ParcelStore) UpdateCustomsItem(item CustomsItem) error {
+	if err := item.Validate(); err != nil {
+		return err
+	}
+
+	res, err := s.db.Exec("UPDATE {customs_item} SET description = :description, quantity = :quantity, "+
+		"hs_code = :hs_code, value = :value "+
+		"WHERE id = :id AND parcel IN (SELECT number FROM {parcel} WHERE number = :parcel AND status = :status)",
+		sql.Named("description", item.Description),
+		sql.Named("quantity", item.Quantity),
+		sql.Named("hs_code", item.HSCode),
+		sql.Named("value", item.Value),
+		sql.Named("id", item.ID),
+		sql.Named("parcel", item.Parcel),
+		sql.Named("status", ParcelStatusRegistered))
+	if err != nil {
+		return err
+	}
+
+	return s.checkRegisteredAffected(item.Parcel, res)
+}
+
+// DeleteCustomsItem удаляет позицию декларации посылки
+func (s ParcelStore) DeleteCustomsItem(number int, id int) error {
+	res, err := s.db.Exec("DELETE FROM {customs_item} "+
+		"WHERE id = :id AND parcel IN (SELECT number FROM {parcel} WHERE number = :parcel AND status = :status)",
+		sql.Named("id", id),
+		sql.Named("parcel", number),
+		sql.Named("status", ParcelStatusRegistered))
+	if err != nil {
+		return err
+	}
+
+	return s.checkRegisteredAffected(number, res)
+}
+
+// ExportCustomsDeclaration формирует данные декларации для международного
+// отправления. Форма выбирается по суммарной ценности вложений.
+func (s ParcelStore) ExportCustomsDeclaration(number int) (CustomsDeclaration, error) {
+	p, err := s.Get(number)
+	if err != nil {
+		return CustomsDeclaration{}, err
+	}
+
+	items, err := s.GetCustomsItems(number)
+	if err != nil {
+		return CustomsDeclaration{}, err
+	}
+	if len(items) == 0 {
+		return CustomsDeclaration{}, ErrNoCustomsItems
+	}
+
+	d := CustomsDeclaration{
+		Form:    CustomsFormCN22,
+		Parcel:  p.Number,
+		Client:  p.Client,
+		Address: p.Address,
+		Items:   items,
+	}
+	for _, i := range items {
+		d.TotalQuantity += i.Quantity
+		d.TotalValue += i.Value
+	}
+	if d.TotalValue > CN22ValueLimit {
+		d.Form = CustomsFormCN23
+	}
+
+	return d, nil
+}
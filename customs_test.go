@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// getTestCustomsItem возвращает тестовую позицию декларации
+func getTestCustomsItem(parcel int) CustomsItem {
+	return CustomsItem{
+		Parcel:      parcel,
+		Description: "книга",
+		Quantity:    2,
+		HSCode:      "490199",
+		Value:       150000,
+	}
+}
+
+// TestCustomsItemCRUD проверяет добавление, изменение и удаление позиций декларации
+func TestCustomsItemCRUD(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// add
+	item := getTestCustomsItem(number)
+	item.ID, err = store.AddCustomsItem(item)
+	require.NoError(t, err)
+	require.NotZero(t, item.ID)
+
+	items, err := store.GetCustomsItems(number)
+	require.NoError(t, err)
+	require.Equal(t, []CustomsItem{item}, items)
+
+	// update
+	item.Quantity = 3
+	require.NoError(t, store.UpdateCustomsItem(item))
+	items, err = store.GetCustomsItems(number)
+	require.NoError(t, err)
+	require.Equal(t, 3, items[0].Quantity)
+
+	// invalid
+	bad := getTestCustomsItem(number)
+	bad.HSCode = "49-01"
+	_, err = store.AddCustomsItem(bad)
+	require.ErrorIs(t, err, ErrInvalidCustomsItem)
+
+	// delete
+	require.NoError(t, store.DeleteCustomsItem(number, item.ID))
+	items, err = store.GetCustomsItems(number)
+	require.NoError(t, err)
+	require.Empty(t, items)
+	require.ErrorIs(t, store.DeleteCustomsItem(number, item.ID), sql.ErrNoRows)
+}
+
+// TestCustomsItemNotRegistered проверяет, что декларацию отправленной посылки менять нельзя
+func TestCustomsItemNotRegistered(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	id, err := store.AddCustomsItem(getTestCustomsItem(number))
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+
+	// check
+	_, err = store.AddCustomsItem(getTestCustomsItem(number))
+	require.ErrorIs(t, err, ErrNotRegistered)
+	require.ErrorIs(t, store.DeleteCustomsItem(number, id), ErrNotRegistered)
+
+	_, err = store.AddCustomsItem(getTestCustomsItem(number + 1))
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+// TestExportCustomsDeclaration проверяет выбор формы и итоги декларации
+func TestExportCustomsDeclaration(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	_, err = store.ExportCustomsDeclaration(number)
+	require.ErrorIs(t, err, ErrNoCustomsItems)
+
+	// add
+	_, err = store.AddCustomsItem(getTestCustomsItem(number))
+	require.NoError(t, err)
+
+	// check
+	d, err := store.ExportCustomsDeclaration(number)
+	require.NoError(t, err)
+	require.Equal(t, CustomsFormCN22, d.Form)
+	require.Equal(t, 2, d.TotalQuantity)
+	require.Equal(t, int64(150000), d.TotalValue)
+
+	expensive := getTestCustomsItem(number)
+	expensive.Value = CN22ValueLimit
+	_, err = store.AddCustomsItem(expensive)
+	require.NoError(t, err)
+
+	d, err = store.ExportCustomsDeclaration(number)
+	require.NoError(t, err)
+	require.Equal(t, CustomsFormCN23, d.Form)
+	require.Len(t, d.Items, 2)
+}
+
+// TestDeleteParcelCascadesCustomsItems проверяет удаление декларации вместе с посылкой
+func TestDeleteParcelCascadesCustomsItems(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	_, err = store.AddCustomsItem(getTestCustomsItem(number))
+	require.NoError(t, err)
+
+	// delete
+	require.NoError(t, store.Delete(number))
+
+	// check
+	items, err := store.GetCustomsItems(number)
+	require.NoError(t, err)
+	require.Empty(t, items)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	// dailyStatsInterval — как часто пересчитывается сводка за последние сутки
+	dailyStatsInterval = time.Hour
+	// MaxDailyStatsDays — наибольший период запроса сводки по суткам
+	MaxDailyStatsDays = 366
+)
+
+var ErrInvalidStatsRange = errors.New("некорректный период сводки")
+
+// DailyStat — сводка за сутки (UTC): сколько посылок зарегистрировано,
+// отправлено и доставлено в эти сутки и сколько в среднем шли доставленные
+type DailyStat struct {
+	Day        string `json:"day"`
+	Registered int    `json:"registered"`
+	Sent       int    `json:"sent"`
+	Delivered  int    `json:"delivered"`
+	// AvgDeliverySeconds — среднее время от регистрации до доставки
+	// посылок, доставленных в эти сутки; 0 — доставок не было
+	AvgDeliverySeconds float64 `json:"avg_delivery_seconds"`
+}
+
+// MaterializeDailyStats пересчитывает сводку {daily_stats} за сутки с from
+// по to включительно. Сутки считаются по времени событий, поэтому меняются
+// только текущие сутки, если только прошлые даты не внесены задним числом,
+// например из файла перевозчика; тогда период пересчитывается явно.
+func (s ParcelStore) MaterializeDailyStats(ctx context.Context, from time.Time, to time.Time) error {
+	from, to = from.UTC().Truncate(24*time.Hour), to.UTC().Truncate(24*time.Hour)
+	if to.Before(from) {
+		return ErrInvalidStatsRange
+	}
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := formatTime(s.now())
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		_, err := tx.Exec("INSERT INTO {daily_stats} (day, registered, sent, delivered, delivery_seconds, computed_at) "+
+			"SELECT :day, "+
+			"(SELECT COUNT(*) FROM {parcel} WHERE created_at >= :from AND created_at < :to), "+
+			"(SELECT COUNT(*) FROM {parcel} WHERE sent_at >= :from AND sent_at < :to), "+
+			"(SELECT COUNT(*) FROM {parcel} WHERE delivered_at >= :from AND delivered_at < :to), "+
+			"(SELECT COALESCE(SUM(ROUND((julianday(delivered_at) - julianday(created_at)) * 86400, 3)), 0) FROM {parcel} "+
+			"WHERE delivered_at >= :from AND delivered_at < :to), :now "+
+			"ON CONFLICT (day) DO UPDATE SET registered = excluded.registered, sent = excluded.sent, "+
+			"delivered = excluded.delivered, delivery_seconds = excluded.delivery_seconds, computed_at = excluded.computed_at",
+			sql.Named("day", day.Format(dateLayout)),
+			sql.Named("from", formatTime(day)),
+			sql.Named("to", formatTime(day.AddDate(0, 0, 1))),
+			sql.Named("now", now))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RefreshDailyStats досчитывает сводку по суткам: от последних посчитанных
+// суток, которые могли быть неполными, или от первой посылки до текущих
+func (s ParcelStore) RefreshDailyStats(ctx context.Context) error {
+	var from string
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE((SELECT MAX(day) FROM {daily_stats}), "+
+		"(SELECT substr(MIN(created_at), 1, 10) FROM {parcel}), '')").Scan(&from)
+	if err != nil {
+		return err
+	}
+	if from == "" {
+		return nil
+	}
+	start, err := time.Parse(dateLayout, from)
+	if err != nil {
+		return err
+	}
+
+	return s.MaterializeDailyStats(ctx, start, s.now())
+}
+
+// DailyStats возвращает посчитанную сводку за сутки с from по to
+// включительно; непосчитанные сутки не возвращаются
+func (s ParcelStore) DailyStats(ctx context.Context, from time.Time, to time.Time) ([]DailyStat, error) {
+	from, to = from.UTC().Truncate(24*time.Hour), to.UTC().Truncate(24*time.Hour)
+	if to.Before(from) || to.Sub(from) >= MaxDailyStatsDays*24*time.Hour {
+		return nil, ErrInvalidStatsRange
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT day, registered, sent, delivered, delivery_seconds FROM {daily_stats} "+
+		"WHERE day >= :from AND day <= :to ORDER BY day",
+		sql.Named("from", from.Format(dateLayout)),
+		sql.Named("to", to.Format(dateLayout)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []DailyStat
+	for rows.Next() {
+		d := DailyStat{}
+		var seconds float64
+		if err := rows.Scan(&d.Day, &d.Registered, &d.Sent, &d.Delivered, &seconds); err != nil {
+			return nil, err
+		}
+		if d.Delivered > 0 {
+			d.AvgDeliverySeconds = seconds / float64(d.Delivered)
+		}
+		res = append(res, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// RunDailyStats досчитывает сводку по суткам сразу и затем каждые
+// interval, пока не отменён ctx. Ошибки записываются в errors.
+func (s ParcelStore) RunDailyStats(ctx context.Context, interval time.Duration, errors *ErrorLog) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.RefreshDailyStats(ctx); err != nil && ctx.Err() == nil {
+			errors.Record(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestDailyStats проверяет сводку по суткам
+func TestDailyStats(t *testing.T) {
+	// prepare
+	day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	clock := NewManualClock(day)
+	store := NewParcelStore(openTestDB(t), WithClock(clock))
+	for i := 0; i < 3; i++ {
+		p := getTestParcel()
+		p.CreatedAt = day
+		_, err := store.Add(p)
+		require.NoError(t, err)
+	}
+	p := getTestParcel()
+	p.CreatedAt = day
+	number, err := store.Add(p)
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+
+	// на следующие сутки посылка доставлена
+	clock.Advance(26 * time.Hour)
+	require.NoError(t, store.TransitionStatus(number, ParcelStatusSent, ParcelStatusDelivered))
+
+	// refresh
+	require.NoError(t, store.RefreshDailyStats(context.Background()))
+
+	// check
+	stats, err := store.DailyStats(context.Background(), day, day.AddDate(0, 0, 5))
+	require.NoError(t, err)
+	require.Equal(t, []DailyStat{
+		{Day: "2024-05-01", Registered: 4, Sent: 1},
+		{Day: "2024-05-02", Delivered: 1, AvgDeliverySeconds: (26 * time.Hour).Seconds()},
+	}, stats)
+
+	_, err = store.DailyStats(context.Background(), day, day.AddDate(-2, 0, 0))
+	require.ErrorIs(t, err, ErrInvalidStatsRange)
+	_, err = store.DailyStats(context.Background(), day, day.AddDate(2, 0, 0))
+	require.ErrorIs(t, err, ErrInvalidStatsRange)
+}
+
+// TestDailyStatsHandler проверяет запрос сводки по суткам
+func TestDailyStatsHandler(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	_, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.RefreshDailyStats(context.Background()))
+	handler := NewAdminHandler(store, NewErrorLog(10))
+	today := store.now().UTC().Format(dateLayout)
+
+	// request
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/daily-stats?from="+today+"&to="+today, nil))
+
+	// check
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"registered":1`)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/daily-stats?from=вчера&to="+today, nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// AttachmentKindDamagePhoto — фотография повреждения посылки
+const AttachmentKindDamagePhoto = "damage_photo"
+
+var (
+	ErrNotDamageable          = errors.New("повреждение можно зафиксировать только у отправленной или доставленной посылки")
+	ErrEmptyDamageDescription = errors.New("не указано описание повреждения")
+)
+
+// DamageReport — акт о повреждении посылки
+type DamageReport struct {
+	Parcel      int
+	Description string
+	ReportedAt  time.Time
+	// Photos — фотографии повреждения без содержимого, см. GetAttachment
+	Photos []Attachment
+}
+
+// ReportDamage переводит отправленную или доставленную посылку в статус damaged,
+// сохраняет акт о повреждении и прикрепляет к нему фотографии
+func (s ParcelStore) ReportDamage(number int, description string, photos []Attachment) error {
+	if description == "" {
+		return ErrEmptyDamageDescription
+	}
+	attachments := make([]Attachment, 0, len(photos))
+	for _, photo := range photos {
+		photo.Parcel = number
+		photo.Kind = AttachmentKindDamagePhoto
+		if err := photo.Validate(); err != nil {
+			return err
+		}
+		attachments = append(attachments, photo)
+	}
+
+	// повреждение — смена статуса, в журнале это переход в damaged
+	return s.audited(context.Background(), number, AuditTransition, func() error {
+		return s.reportDamage(number, description, attachments)
+	})
+}
+
+// reportDamage в одной транзакции меняет статус, сохраняет акт и вложения
+func (s ParcelStore) reportDamage(number int, description string, attachments []Attachment) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// прежний статус нужен только хукам, отсутствие посылки выясняется ниже
+	var from string
+	if s.hasStatusHooks() {
+		err := tx.QueryRow("SELECT status FROM {parcel} WHERE number = :number", sql.Named("number", number)).Scan(&from)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	now := s.now()
+	res, err := tx.Exec("UPDATE {parcel} SET status = :damaged WHERE number = :number AND status IN (:sent, :delivered)",
+		sql.Named("damaged", ParcelStatusDamaged),
+		sql.Named("number", number),
+		sql.Named("sent", ParcelStatusSent),
+		sql.Named("delivered", ParcelStatusDelivered))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		if _, err := s.Get(number); err != nil {
+			return err
+		}
+		return ErrNotDamageable
+	}
+
+	_, err = tx.Exec("INSERT INTO {damage_report} (parcel, description, reported_at) "+
+		"VALUES (:parcel, :description, :reported_at)",
+		sql.Named("parcel", number),
+		sql.Named("description", description),
+		sql.Named("reported_at", formatTime(now)))
+	if err != nil {
+		return err
+	}
+
+	for _, a := range attachments {
+		if _, err := s.insertAttachment(tx, a); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.afterStatusChange(StatusChange{Number: number, From: from, To: ParcelStatusDamaged, At: now})
+
+	return nil
+}
+
+// ListDamaged возвращает акты о повреждении в порядке их составления
+func (s ParcelStore) ListDamaged() ([]DamageReport, error) {
+	rows, err := s.db.Query("SELECT parcel, description, reported_at FROM {damage_report} ORDER BY reported_at, parcel")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []DamageReport
+	for rows.Next() {
+		r := DamageReport{}
+		err := rows.Scan(&r.Parcel, &r.Description, scanTime(&r.ReportedAt))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range res {
+		res[i].Photos, err = s.ListAttachments(res[i].Parcel, AttachmentKindDamagePhoto)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
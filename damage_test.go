@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// getTestPhoto возвращает тестовую фотографию
+func getTestPhoto() Attachment {
+	return Attachment{
+		Name:        "photo.jpg",
+		ContentType: "image/jpeg",
+		Data:        []byte{0xff, 0xd8, 0xff, 0xe0},
+	}
+}
+
+// TestReportDamage проверяет составление акта о повреждении
+func TestReportDamage(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// зарегистрированную посылку повредить нельзя
+	err = store.ReportDamage(number, "вмятина", nil)
+	require.ErrorIs(t, err, ErrNotDamageable)
+
+	// report
+	require.NoError(t, store.SetStatus(number, ParcelStatusDelivered))
+	err = store.ReportDamage(number, "вмятина", []Attachment{getTestPhoto(), getTestPhoto()})
+	require.NoError(t, err)
+
+	// check
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDamaged, stored.Status)
+
+	reports, err := store.ListDamaged()
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	require.Equal(t, number, reports[0].Parcel)
+	require.Equal(t, "вмятина", reports[0].Description)
+	require.Len(t, reports[0].Photos, 2)
+
+	photo, err := store.GetAttachment(reports[0].Photos[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, getTestPhoto().Data, photo.Data)
+	require.Equal(t, AttachmentKindDamagePhoto, photo.Kind)
+
+	// повторно повреждение не фиксируется
+	err = store.ReportDamage(number, "ещё вмятина", nil)
+	require.ErrorIs(t, err, ErrNotDamageable)
+}
+
+// TestReportDamageInvalidPhoto проверяет, что акт с некорректной фотографией не сохраняется
+func TestReportDamageInvalidPhoto(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+
+	photo := getTestPhoto()
+	photo.Data = nil
+
+	// report
+	err = store.ReportDamage(number, "вмятина", []Attachment{photo})
+	require.ErrorIs(t, err, ErrInvalidAttachment)
+
+	// check
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, stored.Status)
+
+	reports, err := store.ListDamaged()
+	require.NoError(t, err)
+	require.Empty(t, reports)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Ошибки нарушения ограничений БД, общие для всех движков. Хранилище
+// заворачивает в них ошибки драйвера, поэтому errors.Is(err, ErrDuplicate)
+// работает одинаково для SQLite и MySQL, а errors.As по-прежнему
+// находит исходную ошибку драйвера. ErrDuplicate и ErrForeignKeyViolation
+// — частные случаи ErrConstraint.
+var (
+	ErrConstraint          = errors.New("нарушено ограничение БД")
+	ErrDuplicate           = errors.New("запись уже существует")
+	ErrForeignKeyViolation = errors.New("ссылка на несуществующую запись")
+)
+
+// constraintError — ошибка драйвера, отнесённая к виду kind
+type constraintError struct {
+	kind error
+	err  error
+}
+
+func (e constraintError) Error() string {
+	return e.err.Error()
+}
+
+func (e constraintError) Unwrap() error {
+	return e.err
+}
+
+func (e constraintError) Is(target error) bool {
+	return target == e.kind || target == ErrConstraint
+}
+
+// classifyDBError заворачивает нарушение ограничения БД в ошибку общего
+// вида, остальные ошибки возвращает как есть
+func classifyDBError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if kind := constraintKind(err); kind != nil {
+		return constraintError{kind: kind, err: err}
+	}
+
+	return err
+}
+
+// constraintKind определяет вид нарушения ограничения по коду ошибки
+// драйвера или возвращает nil, если ошибка не о нарушении ограничения
+func constraintKind(err error) error {
+	var se *sqlite.Error
+	if errors.As(err, &se) {
+		switch code := se.Code(); {
+		case code == sqlite3.SQLITE_CONSTRAINT_UNIQUE, code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return ErrDuplicate
+		case code == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+			return ErrForeignKeyViolation
+		case code&0xff == sqlite3.SQLITE_CONSTRAINT:
+			return ErrConstraint
+		}
+		return nil
+	}
+
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		switch me.Number {
+		// ER_DUP_ENTRY
+		case 1062:
+			return ErrDuplicate
+		// ER_ROW_IS_REFERENCED_2, ER_NO_REFERENCED_ROW_2
+		case 1451, 1452:
+			return ErrForeignKeyViolation
+		// ER_BAD_NULL_ERROR, ER_CHECK_CONSTRAINT_VIOLATED
+		case 1048, 3819:
+			return ErrConstraint
+		}
+	}
+
+	return nil
+}
+
+// isTransientDBError проверяет, что запрос не выполнен из-за конкурирующих
+// транзакций: БД занята дольше busy_timeout или транзакция выбрана жертвой
+// взаимной блокировки. Транзакция при этом откатывается, и её можно повторить.
+func isTransientDBError(err error) bool {
+	var se *sqlite.Error
+	if errors.As(err, &se) {
+		code := se.Code() & 0xff
+		return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+	}
+
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		// ER_LOCK_WAIT_TIMEOUT, ER_LOCK_DEADLOCK
+		return me.Number == 1205 || me.Number == 1213
+	}
+
+	return false
+}
+
+// isUniqueViolation проверяет, что запрос нарушил ограничение уникальности
+func isUniqueViolation(err error) bool {
+	return errors.Is(classifyDBError(err), ErrDuplicate)
+}
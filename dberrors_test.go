@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+	"modernc.org/sqlite"
+)
+
+// TestConstraintErrors проверяет приведение нарушений ограничений SQLite
+// к общим ошибкам с сохранением исходной ошибки драйвера
+func TestConstraintErrors(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	// check
+	_, err = store.Add(parcel)
+	require.ErrorIs(t, err, ErrDuplicate)
+	require.ErrorIs(t, err, ErrConstraint)
+	require.NotErrorIs(t, err, ErrForeignKeyViolation)
+	var se *sqlite.Error
+	require.True(t, errors.As(err, &se))
+
+	_, err = store.AddNote(Note{Parcel: number + 1, Author: "support", Text: "звонок", Visibility: NoteInternal})
+	require.ErrorIs(t, err, ErrForeignKeyViolation)
+	require.ErrorIs(t, err, ErrConstraint)
+	require.NotErrorIs(t, err, ErrDuplicate)
+}
+
+// TestClassifyMySQLError проверяет приведение кодов ошибок MySQL
+func TestClassifyMySQLError(t *testing.T) {
+	require.ErrorIs(t, classifyDBError(&mysql.MySQLError{Number: 1062}), ErrDuplicate)
+	require.ErrorIs(t, classifyDBError(&mysql.MySQLError{Number: 1452}), ErrForeignKeyViolation)
+	require.ErrorIs(t, classifyDBError(&mysql.MySQLError{Number: 3819}), ErrConstraint)
+	require.NotErrorIs(t, classifyDBError(&mysql.MySQLError{Number: 1064}), ErrConstraint)
+	require.NoError(t, classifyDBError(nil))
+}
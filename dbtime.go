@@ -0,0 +1,64 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// timeLayout — формат хранения времени в БД: UTC с миллисекундами
+// фиксированной ширины, чтобы при сравнении и сортировке строк
+// соблюдался хронологический порядок
+const timeLayout = "2006-01-02T15:04:05.000Z"
+
+// formatTime переводит время в формат хранения, нулевое время хранится пустой строкой
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(timeLayout)
+}
+
+// timeScanner читает колонку времени в *time.Time
+type timeScanner struct {
+	t *time.Time
+}
+
+// scanTime возвращает приёмник для Scan, который разбирает время
+// в формате хранения и возвращает его в UTC
+func scanTime(t *time.Time) sql.Scanner {
+	return timeScanner{t: t}
+}
+
+func (s timeScanner) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*s.t = time.Time{}
+	case time.Time:
+		*s.t = v.UTC()
+	case string:
+		return s.parse(v)
+	case []byte:
+		return s.parse(string(v))
+	default:
+		return fmt.Errorf("время в БД имеет неожиданный тип %T", src)
+	}
+
+	return nil
+}
+
+func (s timeScanner) parse(v string) error {
+	if v == "" {
+		*s.t = time.Time{}
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return err
+	}
+	*s.t = t.UTC()
+
+	return nil
+}
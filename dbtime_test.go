@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestScanTime проверяет разбор времени из БД, включая записанное до перехода на timeLayout
+func TestScanTime(t *testing.T) {
+	want := time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC)
+
+	for _, src := range []any{
+		"2024-03-01T10:20:30.000Z",
+		"2024-03-01T10:20:30Z",
+		"2024-03-01T13:20:30+03:00",
+		[]byte("2024-03-01T10:20:30Z"),
+		want.In(time.FixedZone("MSK", 3*60*60)),
+	} {
+		var got time.Time
+		require.NoError(t, scanTime(&got).Scan(src))
+		require.True(t, want.Equal(got), src)
+		require.Equal(t, time.UTC, got.Location())
+	}
+
+	var got time.Time
+	require.NoError(t, scanTime(&got).Scan(""))
+	require.True(t, got.IsZero())
+	require.Error(t, scanTime(&got).Scan("вчера"))
+}
+
+// TestFormatTimeOrder проверяет, что строки формата хранения сортируются хронологически
+func TestFormatTimeOrder(t *testing.T) {
+	base := time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC)
+	times := []time.Time{
+		base.Add(500 * time.Millisecond),
+		base,
+		base.Add(time.Second),
+		base.Add(-time.Millisecond).In(time.FixedZone("MSK", 3*60*60)),
+	}
+
+	formatted := make([]string, len(times))
+	for i, tm := range times {
+		formatted[i] = formatTime(tm)
+	}
+	sort.Strings(formatted)
+
+	require.Equal(t, []string{
+		"2024-03-01T10:20:29.999Z",
+		"2024-03-01T10:20:30.000Z",
+		"2024-03-01T10:20:30.500Z",
+		"2024-03-01T10:20:31.000Z",
+	}, formatted)
+	require.Empty(t, formatTime(time.Time{}))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrDeadLetterNotFound = errors.New("недоставленное сообщение не найдено")
+
+// DeadLetter — сообщение outbox, которое не удалось доставить
+// за MaxOutboxAttempts попыток или разобрать
+type DeadLetter struct {
+	ID      int    `json:"id"`
+	Topic   string `json:"topic"`
+	Payload string `json:"payload"`
+	// Reason — текст последней ошибки
+	Reason    string    `json:"reason"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// failOutbox учитывает неудачную попытку отправить сообщение и при
+// исчерпании попыток переносит его в недоставленные
+func (s ParcelStore) failOutbox(m OutboxMessage, cause error) error {
+	if m.Attempts+1 >= MaxOutboxAttempts {
+		m.Attempts++
+		return s.moveToDeadLetters(m, cause)
+	}
+
+	_, err := s.db.Exec("UPDATE {outbox} SET attempts = attempts + 1, last_error = :error WHERE id = :id",
+		sql.Named("error", cause.Error()),
+		sql.Named("id", m.ID))
+
+	return err
+}
+
+// moveToDeadLetters в одной транзакции переносит сообщение из outbox
+// в недоставленные с причиной cause
+func (s ParcelStore) moveToDeadLetters(m OutboxMessage, cause error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO {dead_letter} (topic, payload, reason, attempts, created_at, failed_at, dedup_key) "+
+		"VALUES (:topic, :payload, :reason, :attempts, :created_at, :failed_at, :dedup_key)",
+		sql.Named("dedup_key", m.DedupKey),
+		sql.Named("topic", m.Topic),
+		sql.Named("payload", string(m.Payload)),
+		sql.Named("reason", cause.Error()),
+		sql.Named("attempts", m.Attempts),
+		sql.Named("created_at", formatTime(m.CreatedAt)),
+		sql.Named("failed_at", formatTime(s.now())))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM {outbox} WHERE id = :id", sql.Named("id", m.ID)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListDeadLetters возвращает до limit недоставленных сообщений в порядке переноса
+func (s ParcelStore) ListDeadLetters(limit int) ([]DeadLetter, error) {
+	rows, err := s.db.Query("SELECT id, topic, payload, reason, attempts, created_at, failed_at "+
+		"FROM {dead_letter} ORDER BY id LIMIT :limit",
+		sql.Named("limit", limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []DeadLetter
+	for rows.Next() {
+		d := DeadLetter{}
+		err := rows.Scan(&d.ID, &d.Topic, &d.Payload, &d.Reason, &d.Attempts, scanTime(&d.CreatedAt), scanTime(&d.FailedAt))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// RequeueDeadLetter возвращает недоставленное сообщение в конец outbox
+// с обнулённым счётчиком попыток
+func (s ParcelStore) RequeueDeadLetter(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// ключ сохраняется: первая попытка могла дойти до получателя
+	res, err := tx.Exec("INSERT INTO {outbox} (topic, payload, created_at, dedup_key) "+
+		"SELECT topic, payload, :created_at, dedup_key FROM {dead_letter} WHERE id = :id",
+		sql.Named("created_at", formatTime(s.now())),
+		sql.Named("id", id))
+	if err != nil {
+		return err
+	}
+	if err := checkDeadLetterAffected(res); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM {dead_letter} WHERE id = :id", sql.Named("id", id)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DiscardDeadLetter окончательно удаляет недоставленное сообщение
+func (s ParcelStore) DiscardDeadLetter(id int) error {
+	res, err := s.db.Exec("DELETE FROM {dead_letter} WHERE id = :id", sql.Named("id", id))
+	if err != nil {
+		return err
+	}
+
+	return checkDeadLetterAffected(res)
+}
+
+// checkDeadLetterAffected возвращает ErrDeadLetterNotFound, если запрос
+// не затронул ни одного сообщения
+func checkDeadLetterAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrDeadLetterNotFound
+	}
+
+	return nil
+}
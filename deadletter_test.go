@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// addTestReceipt добавляет посылку, квитанция о которой попадает в outbox
+func addTestReceipt(t *testing.T, store ParcelStore) {
+	t.Helper()
+
+	parcel := getTestParcel()
+	parcel.SenderEmail = "sender@example.com"
+	_, err := store.Add(parcel)
+	require.NoError(t, err)
+}
+
+// TestDeadLetters проверяет перенос сообщения в недоставленные и возврат в outbox
+func TestDeadLetters(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	addTestReceipt(t, store)
+	failing := &testNotifier{err: errors.New("mailbox unavailable")}
+
+	// relay
+	for i := 0; i < MaxOutboxAttempts; i++ {
+		_, err := store.RelayOutbox(context.Background(), failing, 10)
+		require.Error(t, err)
+	}
+
+	// check
+	messages, err := store.PendingOutbox(10)
+	require.NoError(t, err)
+	require.Empty(t, messages)
+
+	letters, err := store.ListDeadLetters(10)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	require.Equal(t, TopicNotification, letters[0].Topic)
+	require.Equal(t, "mailbox unavailable", letters[0].Reason)
+	require.Equal(t, MaxOutboxAttempts, letters[0].Attempts)
+
+	// requeue
+	require.NoError(t, store.RequeueDeadLetter(letters[0].ID))
+	require.ErrorIs(t, store.RequeueDeadLetter(letters[0].ID), ErrDeadLetterNotFound)
+
+	notifier := &testNotifier{}
+	sent, err := store.RelayOutbox(context.Background(), notifier, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, sent)
+	require.Equal(t, "sender@example.com", notifier.sent[0].To)
+}
+
+// TestDeadLetterUndecodable проверяет, что неразборчивое сообщение
+// сразу уходит в недоставленные и не задерживает очередь
+func TestDeadLetterUndecodable(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	_, err := store.db.Exec("INSERT INTO {outbox} (topic, payload, created_at, dedup_key) VALUES ('notification', 'not json', '', 'broken')")
+	require.NoError(t, err)
+	addTestReceipt(t, store)
+
+	// relay
+	notifier := &testNotifier{}
+	sent, err := store.RelayOutbox(context.Background(), notifier, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, sent)
+
+	// check
+	letters, err := store.ListDeadLetters(10)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	require.Equal(t, "not json", letters[0].Payload)
+}
+
+// TestAdminDeadLetters проверяет просмотр и удаление недоставленных сообщений
+func TestAdminDeadLetters(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	_, err := store.db.Exec("INSERT INTO {outbox} (topic, payload, created_at, dedup_key) VALUES ('notification', 'not json', '', 'broken')")
+	require.NoError(t, err)
+	_, err = store.RelayOutbox(context.Background(), &testNotifier{}, 10)
+	require.NoError(t, err)
+	handler := NewAdminHandler(store, NewErrorLog(10))
+
+	// list
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/dead-letters", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var letters []DeadLetter
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &letters))
+	require.Len(t, letters, 1)
+
+	// discard
+	discard := func(id string) int {
+		body := strings.NewReader(url.Values{"id": {id}}.Encode())
+		req := httptest.NewRequest(http.MethodPost, "/admin/dead-letters/discard", body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	id := strconv.Itoa(letters[0].ID)
+	require.Equal(t, http.StatusNoContent, discard(id))
+	require.Equal(t, http.StatusNotFound, discard(id))
+	require.Equal(t, http.StatusBadRequest, discard("x"))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// DeliverySlots — окна доставки, которые может выбрать получатель,
+// в порядке дня. Курьер объезжает точки окно за окном, см. ListDeliveryRoute.
+var DeliverySlots = []string{"09-12", "12-15", "15-18", "18-21"}
+
+var (
+	ErrInvalidDeliveryWindow = errors.New("неизвестное окно доставки")
+	ErrDeliveryWindowClosed  = errors.New("окно доставки нельзя выбрать для завершённой посылки")
+)
+
+// deliverySlotIndex возвращает место окна в DeliverySlots или -1
+func deliverySlotIndex(slot string) int {
+	for i, s := range DeliverySlots {
+		if s == slot {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// SetDeliveryWindow записывает окно доставки, удобное получателю посылки.
+// Пустое окно снимает пожелание. Для доставленной и выбывшей посылки
+// окно не выбирается.
+func (s ParcelStore) SetDeliveryWindow(number int, slot string) error {
+	if slot != "" && deliverySlotIndex(slot) < 0 {
+		return fmt.Errorf("%w: %q", ErrInvalidDeliveryWindow, slot)
+	}
+
+	p, err := s.Get(number)
+	if err != nil {
+		return err
+	}
+	if p.Status == ParcelStatusDelivered || offPathStatuses[p.Status] {
+		return ErrDeliveryWindowClosed
+	}
+
+	if slot == "" {
+		_, err := s.db.Exec("DELETE FROM {delivery_window} WHERE parcel = :parcel", sql.Named("parcel", number))
+		return err
+	}
+
+	_, err = s.db.Exec("INSERT INTO {delivery_window} (parcel, slot, set_at) VALUES (:parcel, :slot, :set_at) "+
+		"ON CONFLICT (parcel) DO UPDATE SET slot = excluded.slot, set_at = excluded.set_at",
+		sql.Named("parcel", number),
+		sql.Named("slot", slot),
+		sql.Named("set_at", formatTime(s.now())))
+
+	return err
+}
+
+// GetDeliveryWindow возвращает окно доставки посылки, пустое — пожелания нет
+func (s ParcelStore) GetDeliveryWindow(number int) (string, error) {
+	var slot string
+	err := s.db.QueryRow("SELECT slot FROM {delivery_window} WHERE parcel = :parcel",
+		sql.Named("parcel", number)).Scan(&slot)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return slot, err
+}
+
+// NewDeliveryWindowHandler возвращает публичный обработчик POST
+// /track/{code}/window: JSON {"token", "slot"}, где token — токен
+// получателя, см. RecipientToken. Пустой slot снимает пожелание.
+func NewDeliveryWindowHandler(store ParcelStore, errLog *ErrorLog) http.Handler {
+	h := AdminHandler{store: store, errors: errLog}
+
+	return recipientAction(store, errLog, "window", func(w http.ResponseWriter, r *http.Request, p Parcel, body []byte) {
+		var req struct {
+			Slot string `json:"slot"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "некорректный JSON", http.StatusBadRequest)
+			return
+		}
+
+		err := store.SetDeliveryWindow(p.Number, req.Slot)
+		switch {
+		case errors.Is(err, ErrInvalidDeliveryWindow):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrDeliveryWindowClosed):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
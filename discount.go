@@ -0,0 +1,225 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidPromoCode      = errors.New("некорректный промокод")
+	ErrUnknownPromoCode      = errors.New("промокод не найден")
+	ErrPromoCodeExpired      = errors.New("срок действия промокода истёк")
+	ErrPromoCodeExhausted    = errors.New("промокод больше не действует: исчерпан лимит применений")
+	ErrPromoCodeCurrency     = errors.New("промокод действует для посылок в другой валюте")
+	ErrInvalidVolumeDiscount = errors.New("некорректная скидка за объём")
+)
+
+// PromoCode — скидка по коду: Percent процентов от стоимости доставки или
+// фиксированная сумма Amount в младших единицах Currency, такой код
+// действует только для посылок в этой валюте. MaxUses ограничивает число
+// применений, 0 — без ограничения; нулевой ValidUntil — бессрочный код.
+type PromoCode struct {
+	Code       string    `json:"code"`
+	Percent    int       `json:"percent,omitempty"`
+	Amount     int64     `json:"amount,omitempty"`
+	Currency   string    `json:"currency,omitempty"`
+	ValidUntil time.Time `json:"valid_until"`
+	MaxUses    int       `json:"max_uses"`
+	Uses       int       `json:"uses"`
+}
+
+// Validate проверяет, что код задаёт ровно один вид скидки
+func (c PromoCode) Validate() error {
+	switch {
+	case c.Code == "" || len(c.Code) > 32:
+		return fmt.Errorf("%w: код должен быть от 1 до 32 символов", ErrInvalidPromoCode)
+	case (c.Percent == 0) == (c.Amount == 0):
+		return fmt.Errorf("%w: нужен либо процент, либо сумма скидки", ErrInvalidPromoCode)
+	case c.Percent < 0 || c.Percent > 100:
+		return fmt.Errorf("%w: процент должен быть от 1 до 100", ErrInvalidPromoCode)
+	case c.Amount < 0:
+		return fmt.Errorf("%w: отрицательная сумма", ErrInvalidPromoCode)
+	case !IsSupportedCurrency(c.Currency):
+		return fmt.Errorf("%w: %w %q", ErrInvalidPromoCode, ErrUnknownCurrency, c.Currency)
+	case c.MaxUses < 0:
+		return fmt.Errorf("%w: отрицательный лимит применений", ErrInvalidPromoCode)
+	}
+
+	return nil
+}
+
+// discount возвращает скидку по коду на стоимость доставки price
+func (c PromoCode) discount(price int64) int64 {
+	if c.Percent > 0 {
+		return price * int64(c.Percent) / 100
+	}
+
+	return min(c.Amount, price)
+}
+
+// VolumeDiscount — скидка Percent процентов клиенту, начиная с MinParcels-й
+// посылки, зарегистрированной за календарный месяц
+type VolumeDiscount struct {
+	ID         int `json:"id"`
+	MinParcels int `json:"min_parcels"`
+	Percent    int `json:"percent"`
+}
+
+// AddPromoCode добавляет промокод. Коды не зависят от регистра.
+func (s ParcelStore) AddPromoCode(c PromoCode) error {
+	c.Code = strings.ToUpper(c.Code)
+	if c.Currency == "" {
+		c.Currency = DefaultCurrency
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec("INSERT INTO {promo_code} (code, percent, amount, currency, valid_until, max_uses) "+
+		"VALUES (:code, :percent, :amount, :currency, :valid_until, :max_uses)",
+		sql.Named("code", c.Code),
+		sql.Named("percent", c.Percent),
+		sql.Named("amount", c.Amount),
+		sql.Named("currency", c.Currency),
+		sql.Named("valid_until", formatTime(c.ValidUntil)),
+		sql.Named("max_uses", c.MaxUses))
+
+	return err
+}
+
+// GetPromoCode возвращает промокод с числом применений
+func (s ParcelStore) GetPromoCode(code string) (PromoCode, error) {
+	c := PromoCode{}
+	err := s.db.QueryRow("SELECT code, percent, amount, currency, valid_until, max_uses, uses FROM {promo_code} WHERE code = :code",
+		sql.Named("code", strings.ToUpper(code))).
+		Scan(&c.Code, &c.Percent, &c.Amount, &c.Currency, scanTime(&c.ValidUntil), &c.MaxUses, &c.Uses)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PromoCode{}, ErrUnknownPromoCode
+	}
+
+	return c, err
+}
+
+// AddVolumeDiscount добавляет порог скидки за объём
+func (s ParcelStore) AddVolumeDiscount(d VolumeDiscount) (int, error) {
+	if d.MinParcels <= 0 || d.Percent <= 0 || d.Percent > 100 {
+		return 0, ErrInvalidVolumeDiscount
+	}
+
+	res, err := s.db.Exec("INSERT INTO {volume_discount} (min_parcels, percent) VALUES (:min_parcels, :percent)",
+		sql.Named("min_parcels", d.MinParcels),
+		sql.Named("percent", d.Percent))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// ListVolumeDiscounts возвращает пороги скидки за объём по возрастанию
+func (s ParcelStore) ListVolumeDiscounts() ([]VolumeDiscount, error) {
+	rows, err := s.db.Query("SELECT id, min_parcels, percent FROM {volume_discount} ORDER BY min_parcels")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []VolumeDiscount
+	for rows.Next() {
+		d := VolumeDiscount{}
+		if err := rows.Scan(&d.ID, &d.MinParcels, &d.Percent); err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// DeleteVolumeDiscount удаляет порог скидки за объём.
+// Скидки уже добавленных посылок при этом не меняются.
+func (s ParcelStore) DeleteVolumeDiscount(id int) error {
+	_, err := s.db.Exec("DELETE FROM {volume_discount} WHERE id = :id", sql.Named("id", id))
+
+	return err
+}
+
+// applyDiscount рассчитывает скидку на стоимость доставки посылки. Скидки
+// не суммируются: применяется большая из скидки по промокоду и скидки
+// за объём. Неприменённый промокод у посылки не сохраняется и не
+// расходуется. Лимит применений проверяется в consumePromoCode.
+func (s ParcelStore) applyDiscount(p *Parcel) error {
+	code := strings.ToUpper(p.PromoCode)
+	p.PromoCode, p.Discount = "", 0
+	if p.Price == 0 {
+		return nil
+	}
+
+	var percent int
+	month := time.Date(p.CreatedAt.Year(), p.CreatedAt.Month(), 1, 0, 0, 0, 0, time.UTC)
+	err := s.db.QueryRow("SELECT COALESCE(MAX(percent), 0) FROM {volume_discount} WHERE min_parcels <= "+
+		"(SELECT COUNT(*) + 1 FROM {parcel} WHERE client = :client AND created_at >= :from AND created_at < :to AND status <> :cancelled)",
+		sql.Named("client", p.Client),
+		sql.Named("from", formatTime(month)),
+		sql.Named("to", formatTime(month.AddDate(0, 1, 0))),
+		sql.Named("cancelled", ParcelStatusCancelled)).Scan(&percent)
+	if err != nil {
+		return err
+	}
+	p.Discount = p.Price * int64(percent) / 100
+
+	if code == "" {
+		return nil
+	}
+	c, err := s.GetPromoCode(code)
+	if err != nil {
+		return err
+	}
+	if !c.ValidUntil.IsZero() && !p.CreatedAt.Before(c.ValidUntil) {
+		return ErrPromoCodeExpired
+	}
+	if c.MaxUses > 0 && c.Uses >= c.MaxUses {
+		return ErrPromoCodeExhausted
+	}
+	if c.Amount > 0 && c.Currency != p.Currency {
+		return ErrPromoCodeCurrency
+	}
+	if d := c.discount(p.Price); d > p.Discount {
+		p.PromoCode, p.Discount = c.Code, d
+	}
+
+	return nil
+}
+
+// consumePromoCode учитывает применение промокода в транзакции добавления
+// посылки. Условие на лимит в UPDATE не даёт превысить его при
+// одновременной регистрации.
+func consumePromoCode(tx execer, code string) error {
+	res, err := tx.Exec("UPDATE {promo_code} SET uses = uses + 1 WHERE code = :code AND (max_uses = 0 OR uses < max_uses)",
+		sql.Named("code", code))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrPromoCodeExhausted
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestPromoCode проверяет применение промокода при добавлении посылки
+func TestPromoCode(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	require.NoError(t, store.AddPromoCode(PromoCode{Code: "spring", Percent: 10, MaxUses: 1}))
+	require.NoError(t, store.AddPromoCode(PromoCode{Code: "OLD", Amount: 5000,
+		ValidUntil: time.Now().Add(-time.Hour)}))
+	require.ErrorIs(t, store.AddPromoCode(PromoCode{Code: "BAD", Percent: 10, Amount: 100}), ErrInvalidPromoCode)
+
+	parcel := func(code string) Parcel {
+		p := getTestParcel()
+		p.Price = 30000
+		p.PromoCode = code
+		return p
+	}
+
+	// check
+	number, err := store.Add(parcel("SPRING"))
+	require.NoError(t, err)
+	p, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, "SPRING", p.PromoCode)
+	require.Equal(t, int64(3000), p.Discount)
+
+	c, err := store.GetPromoCode("spring")
+	require.NoError(t, err)
+	require.Equal(t, 1, c.Uses)
+
+	_, err = store.Add(parcel("SPRING"))
+	require.ErrorIs(t, err, ErrPromoCodeExhausted)
+	_, err = store.Add(parcel("OLD"))
+	require.ErrorIs(t, err, ErrPromoCodeExpired)
+	_, err = store.Add(parcel("NONE"))
+	require.ErrorIs(t, err, ErrUnknownPromoCode)
+}
+
+// TestVolumeDiscount проверяет скидку за объём и выбор большей из скидок
+func TestVolumeDiscount(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	for _, d := range []VolumeDiscount{{MinParcels: 2, Percent: 5}, {MinParcels: 3, Percent: 20}} {
+		_, err := store.AddVolumeDiscount(d)
+		require.NoError(t, err)
+	}
+	require.NoError(t, store.AddPromoCode(PromoCode{Code: "FIXED", Amount: 4000}))
+
+	// check
+	var discounts []int64
+	for _, code := range []string{"", "", "", "FIXED"} {
+		p := getTestParcel()
+		p.Price = 30000
+		p.PromoCode = code
+		number, err := store.Add(p)
+		require.NoError(t, err)
+		stored, err := store.Get(number)
+		require.NoError(t, err)
+		require.Empty(t, stored.PromoCode)
+		discounts = append(discounts, stored.Discount)
+	}
+	// скидка за объём 20% больше промокода, промокод не расходуется
+	require.Equal(t, []int64{0, 1500, 6000, 6000}, discounts)
+
+	c, err := store.GetPromoCode("FIXED")
+	require.NoError(t, err)
+	require.Zero(t, c.Uses)
+
+	inv, err := store.GenerateInvoice(1000, time.Now(), "")
+	require.NoError(t, err)
+	require.Equal(t, int64(4*30000-1500-6000-6000), inv.Total)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ErrorRecord — ошибка, зафиксированная в журнале
+type ErrorRecord struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	// RequestID — идентификатор HTTP-запроса, в котором произошла ошибка
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ErrorLog хранит последние ошибки в памяти для просмотра без доступа к логам.
+// Безопасен для использования из нескольких горутин.
+type ErrorLog struct {
+	mu      sync.Mutex
+	size    int
+	records []ErrorRecord
+}
+
+// NewErrorLog создаёт журнал, хранящий не более size последних ошибок
+func NewErrorLog(size int) *ErrorLog {
+	return &ErrorLog{size: size}
+}
+
+// Record добавляет ошибку в журнал, вытесняя самую старую при переполнении
+func (l *ErrorLog) Record(err error) {
+	l.RecordContext(context.Background(), err)
+}
+
+// RecordContext — Record с идентификатором запроса из ctx
+func (l *ErrorLog) RecordContext(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records = append(l.records, ErrorRecord{
+		Time:      time.Now().UTC(),
+		Message:   err.Error(),
+		RequestID: RequestIDFromContext(ctx),
+	})
+	if len(l.records) > l.size {
+		l.records = l.records[len(l.records)-l.size:]
+	}
+}
+
+// Recent возвращает ошибки журнала, начиная с самой новой
+func (l *ErrorLog) Recent() []ErrorRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	res := make([]ErrorRecord, len(l.records))
+	for i, r := range l.records {
+		res[len(res)-1-i] = r
+	}
+
+	return res
+}
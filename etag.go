@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// weakETag возвращает слабый ETag тела ответа
+func weakETag(body []byte) string {
+	sum := sha256.Sum256(body)
+
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches сообщает, что If-None-Match содержит etag. Сравнение слабое:
+// префикс W/ не учитывается.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeJSONConditional отправляет v в формате JSON со слабым ETag по
+// содержимому ответа. Если клиент прислал совпадающий If-None-Match,
+// отвечает 304 без тела. ETag считается по готовому ответу, а не по
+// версии посылки: в ответ попадают и заметки, и названия статусов на языке
+// клиента, которые от версии посылки не зависят.
+func writeJSONConditional(w http.ResponseWriter, r *http.Request, v any) {
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(v)
+
+	etag := weakETag(buf.Bytes())
+	w.Header().Set("ETag", etag)
+	// ответ можно хранить, но перед использованием нужно перепроверить
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
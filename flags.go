@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultFlagCacheTTL — время жизни кэша флагов функциональности по умолчанию
+const defaultFlagCacheTTL = 30 * time.Second
+
+var ErrInvalidFlag = errors.New("некорректный флаг функциональности")
+
+// FeatureFlag включает или выключает рискованное поведение. Флаг с пустым
+// Tenant действует на всех арендаторов, флаг арендатора его переопределяет.
+// Не заданный флаг выключен.
+type FeatureFlag struct {
+	Name      string    `json:"name"`
+	Tenant    string    `json:"tenant"`
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type flagKey struct {
+	name   string
+	tenant string
+}
+
+// flagCache хранит все флаги в памяти и перечитывает их целиком,
+// когда они старше ttl, чтобы проверка флага не ходила в БД
+type flagCache struct {
+	ttl time.Duration
+
+	mu     sync.Mutex
+	flags  map[flagKey]bool
+	loaded time.Time
+}
+
+// FeatureEnabled проверяет, включён ли флаг name для арендатора tenant
+func (s ParcelStore) FeatureEnabled(tenant string, name string) (bool, error) {
+	c := s.flags
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.flags == nil || time.Since(c.loaded) > c.ttl {
+		flags, err := s.ListFeatureFlags()
+		if err != nil {
+			return false, err
+		}
+		c.flags = make(map[flagKey]bool, len(flags))
+		for _, f := range flags {
+			c.flags[flagKey{name: f.Name, tenant: f.Tenant}] = f.Enabled
+		}
+		c.loaded = time.Now()
+	}
+
+	if enabled, ok := c.flags[flagKey{name: name, tenant: tenant}]; ok {
+		return enabled, nil
+	}
+
+	return c.flags[flagKey{name: name}], nil
+}
+
+// SetFeatureFlag включает или выключает флаг и сбрасывает кэш
+func (s ParcelStore) SetFeatureFlag(f FeatureFlag) error {
+	if !statusCodeRe.MatchString(f.Name) {
+		return fmt.Errorf("%w: имя %q", ErrInvalidFlag, f.Name)
+	}
+
+	_, err := s.db.Exec("INSERT INTO {feature_flag} (name, tenant, enabled, updated_at) "+
+		"VALUES (:name, :tenant, :enabled, :updated_at) "+
+		"ON CONFLICT (name, tenant) DO UPDATE SET enabled = excluded.enabled, updated_at = excluded.updated_at",
+		sql.Named("name", f.Name),
+		sql.Named("tenant", f.Tenant),
+		sql.Named("enabled", f.Enabled),
+		sql.Named("updated_at", formatTime(s.now())))
+	if err != nil {
+		return err
+	}
+
+	s.flags.invalidate()
+
+	return nil
+}
+
+// DeleteFeatureFlag удаляет флаг: флаг арендатора перестаёт переопределять
+// общий, общий флаг выключается
+func (s ParcelStore) DeleteFeatureFlag(name string, tenant string) error {
+	_, err := s.db.Exec("DELETE FROM {feature_flag} WHERE name = :name AND tenant = :tenant",
+		sql.Named("name", name),
+		sql.Named("tenant", tenant))
+	if err != nil {
+		return err
+	}
+
+	s.flags.invalidate()
+
+	return nil
+}
+
+// ListFeatureFlags возвращает все флаги из БД
+func (s ParcelStore) ListFeatureFlags() ([]FeatureFlag, error) {
+	rows, err := s.db.Query("SELECT name, tenant, enabled, updated_at FROM {feature_flag} ORDER BY name, tenant")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []FeatureFlag
+	for rows.Next() {
+		f := FeatureFlag{}
+		err := rows.Scan(&f.Name, &f.Tenant, &f.Enabled, scanTime(&f.UpdatedAt))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// invalidate сбрасывает кэш, следующая проверка перечитает флаги
+func (c *flagCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.flags = nil
+}
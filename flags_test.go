@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestFeatureFlags проверяет общий флаг, переопределение арендатором и удаление
+func TestFeatureFlags(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+
+	enabled, err := store.FeatureEnabled("acme", "webhooks")
+	require.NoError(t, err)
+	require.False(t, enabled)
+
+	// set
+	require.NoError(t, store.SetFeatureFlag(FeatureFlag{Name: "webhooks", Enabled: true}))
+	require.NoError(t, store.SetFeatureFlag(FeatureFlag{Name: "webhooks", Tenant: "acme", Enabled: false}))
+	require.ErrorIs(t, store.SetFeatureFlag(FeatureFlag{Name: "Bad Name"}), ErrInvalidFlag)
+
+	// check
+	enabled, err = store.FeatureEnabled("acme", "webhooks")
+	require.NoError(t, err)
+	require.False(t, enabled)
+	enabled, err = store.FeatureEnabled("globex", "webhooks")
+	require.NoError(t, err)
+	require.True(t, enabled)
+
+	require.NoError(t, store.DeleteFeatureFlag("webhooks", "acme"))
+	enabled, err = store.FeatureEnabled("acme", "webhooks")
+	require.NoError(t, err)
+	require.True(t, enabled)
+}
+
+// TestFeatureFlagCache проверяет, что изменение в обход кэша видно после ttl
+func TestFeatureFlagCache(t *testing.T) {
+	// prepare
+	db := openTestDB(t)
+	store := NewParcelStore(db, WithFlagCacheTTL(50*time.Millisecond))
+	enabled, err := store.FeatureEnabled("", "caching")
+	require.NoError(t, err)
+	require.False(t, enabled)
+
+	// другой процесс включает флаг напрямую в БД
+	_, err = store.db.Exec("INSERT INTO {feature_flag} (name, tenant, enabled, updated_at) VALUES ('caching', '', 1, :now)",
+		sql.Named("now", formatTime(time.Now())))
+	require.NoError(t, err)
+
+	// check
+	enabled, err = store.FeatureEnabled("", "caching")
+	require.NoError(t, err)
+	require.False(t, enabled)
+
+	require.Eventually(t, func() bool {
+		enabled, err := store.FeatureEnabled("", "caching")
+		return err == nil && enabled
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestAdminFeatureFlags проверяет переключение флага через эндпоинт
+func TestAdminFeatureFlags(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	handler := NewAdminHandler(store, NewErrorLog(10))
+	post := func(form url.Values) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/flags", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// set
+	require.Equal(t, http.StatusNoContent, post(url.Values{"name": {"webhooks"}, "tenant": {"acme"}, "enabled": {"true"}}))
+	require.Equal(t, http.StatusBadRequest, post(url.Values{"name": {"webhooks"}, "enabled": {"maybe"}}))
+
+	// check
+	enabled, err := store.FeatureEnabled("acme", "webhooks")
+	require.NoError(t, err)
+	require.True(t, enabled)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/flags", nil))
+	var flags []FeatureFlag
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &flags))
+	require.Len(t, flags, 1)
+	require.Equal(t, "acme", flags[0].Tenant)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// readyTimeout ограничивает время проверки готовности, чтобы зависшая БД
+// не задерживала ответ дольше таймаута проб Kubernetes
+const readyTimeout = 2 * time.Second
+
+var ErrSchemaOutdated = errors.New("схема БД не актуальна")
+
+// Health проверяет, что БД доступна и к ней применены все миграции
+func (s ParcelStore) Health(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return err
+	}
+
+	version, err := s.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version < len(migrations) {
+		return fmt.Errorf("%w: применено миграций %d из %d", ErrSchemaOutdated, version, len(migrations))
+	}
+
+	return nil
+}
+
+// NewHealthHandler возвращает обработчик проб /healthz и /readyz.
+// /healthz отвечает, пока процесс жив, /readyz — пока доступна БД.
+func NewHealthHandler(store ParcelStore) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		if err := store.Health(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+
+	return mux
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestHealth проверяет готовность БД с актуальной и устаревшей схемой
+func TestHealth(t *testing.T) {
+	// prepare
+	db := openTestDB(t)
+	store := NewParcelStore(db)
+
+	// check
+	require.NoError(t, store.Health(context.Background()))
+
+	_, err := db.Exec("UPDATE schema_version SET version = 1")
+	require.NoError(t, err)
+	require.ErrorIs(t, store.Health(context.Background()), ErrSchemaOutdated)
+
+	require.NoError(t, db.Close())
+	require.Error(t, store.Health(context.Background()))
+}
+
+// TestHealthEndpoints проверяет пробы /healthz и /readyz
+func TestHealthEndpoints(t *testing.T) {
+	// prepare
+	db := openTestDB(t)
+	handler := NewHTTPHandler(NewParcelStore(db), NewErrorLog(10))
+
+	// check
+	for _, target := range []string{"/healthz", "/readyz"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, http.StatusOK, rec.Code, target)
+	}
+
+	require.NoError(t, db.Close())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,67 @@
+package main
+
+import "time"
+
+// StatusChange — смена статуса посылки, о которой сообщают хуки
+type StatusChange struct {
+	Number int
+	From   string
+	To     string
+	// At — время события, для синхронизации с перевозчиком — время из его данных
+	At time.Time
+}
+
+// Hooks — точки расширения хранилища для встраивающих его приложений,
+// например проверки на мошенничество или отправки событий во внешние
+// системы. Нулевые поля не вызываются.
+type Hooks struct {
+	// OnBeforeAdd вызывается в Add для проверенной посылки перед записью.
+	// Ошибка отменяет добавление и возвращается из Add как есть.
+	OnBeforeAdd func(p Parcel) error
+	// OnAfterStatusChange вызывается после записи нового статуса в SetStatus,
+	// TransitionStatus, синхронизации с перевозчиком и ReportDamage.
+	// Массовый возврат ReturnExpiredPickups хук не вызывает.
+	OnAfterStatusChange func(c StatusChange)
+}
+
+// WithHooks добавляет хуки хранилища. При нескольких WithHooks хуки
+// вызываются в порядке добавления, OnBeforeAdd — до первой ошибки.
+func WithHooks(h Hooks) StoreOption {
+	return func(s *ParcelStore) {
+		s.hooks = append(s.hooks, h)
+	}
+}
+
+// beforeAdd вызывает хуки OnBeforeAdd
+func (s ParcelStore) beforeAdd(p Parcel) error {
+	for _, h := range s.hooks {
+		if h.OnBeforeAdd == nil {
+			continue
+		}
+		if err := h.OnBeforeAdd(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// afterStatusChange вызывает хуки OnAfterStatusChange
+func (s ParcelStore) afterStatusChange(c StatusChange) {
+	for _, h := range s.hooks {
+		if h.OnAfterStatusChange != nil {
+			h.OnAfterStatusChange(c)
+		}
+	}
+}
+
+// hasStatusHooks сообщает, что для хуков нужен прежний статус посылки
+func (s ParcelStore) hasStatusHooks() bool {
+	for _, h := range s.hooks {
+		if h.OnAfterStatusChange != nil {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestHooks проверяет вызов хуков при добавлении посылки и смене статуса
+func TestHooks(t *testing.T) {
+	// prepare
+	errFraud := errors.New("подозрение на мошенничество")
+	var changes []StatusChange
+	store := NewParcelStore(openTestDB(t), WithHooks(Hooks{
+		OnBeforeAdd: func(p Parcel) error {
+			if p.Client == 666 {
+				return errFraud
+			}
+			return nil
+		},
+		OnAfterStatusChange: func(c StatusChange) {
+			changes = append(changes, c)
+		},
+	}))
+
+	// отклонённая хуком посылка не записывается
+	parcel := getTestParcel()
+	parcel.Client = 666
+	_, err := store.Add(parcel)
+	require.ErrorIs(t, err, errFraud)
+	parcels, err := store.GetByClient(666)
+	require.NoError(t, err)
+	require.Empty(t, parcels)
+
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// check
+	require.NoError(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+	require.Error(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+	require.NoError(t, store.SetStatus(number, ParcelStatusDelivered))
+	require.NoError(t, store.ReportDamage(number, "вмятина", nil))
+
+	require.Len(t, changes, 3)
+	for i, want := range [][2]string{
+		{ParcelStatusRegistered, ParcelStatusSent},
+		{ParcelStatusSent, ParcelStatusDelivered},
+		{ParcelStatusDelivered, ParcelStatusDamaged},
+	} {
+		require.Equal(t, number, changes[i].Number)
+		require.Equal(t, want[0], changes[i].From)
+		require.Equal(t, want[1], changes[i].To)
+		require.False(t, changes[i].At.IsZero())
+	}
+
+	// повреждение несуществующей посылки хук не вызывает
+	require.ErrorIs(t, store.ReportDamage(number+1, "вмятина", nil), sql.ErrNoRows)
+	require.Len(t, changes, 3)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	LocaleRU = "ru"
+	LocaleEN = "en"
+	// DefaultLocale — язык, если клиент не указал поддерживаемый
+	DefaultLocale = LocaleRU
+)
+
+// statusNames — отображаемые названия статусов посылки по языкам
+var statusNames = map[string]map[string]string{
+	LocaleRU: {
+		ParcelStatusRegistered: "Зарегистрирована",
+		ParcelStatusSent:       "Отправлена",
+		ParcelStatusDelivered:  "Доставлена",
+		ParcelStatusLost:       "Утеряна",
+		ParcelStatusDamaged:    "Повреждена",
+		ParcelStatusReturned:   "Возвращена отправителю",
+		ParcelStatusCancelled:  "Отменена",
+	},
+	LocaleEN: {
+		ParcelStatusRegistered: "Registered",
+		ParcelStatusSent:       "Sent",
+		ParcelStatusDelivered:  "Delivered",
+		ParcelStatusLost:       "Lost",
+		ParcelStatusDamaged:    "Damaged",
+		ParcelStatusReturned:   "Returned to sender",
+		ParcelStatusCancelled:  "Cancelled",
+	},
+}
+
+// IsSupportedLocale проверяет, что для языка есть переводы
+func IsSupportedLocale(locale string) bool {
+	_, ok := statusNames[locale]
+	return ok
+}
+
+// StatusName возвращает название статуса на языке locale. Для неизвестного
+// языка используется DefaultLocale, для статуса без перевода — его код.
+func StatusName(locale string, status string) string {
+	names, ok := statusNames[locale]
+	if !ok {
+		names = statusNames[DefaultLocale]
+	}
+	if name, ok := names[status]; ok {
+		return name
+	}
+
+	return status
+}
+
+// NegotiateLocale выбирает поддерживаемый язык по заголовку Accept-Language
+// с учётом весов q; регион не учитывается, en-GB означает en
+func NegotiateLocale(header string) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if !IsSupportedLocale(lang) {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{locale: lang, q: q})
+		}
+	}
+	if len(candidates) == 0 {
+		return DefaultLocale
+	}
+
+	// при равных весах побеждает язык, указанный раньше
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	return candidates[0].locale
+}
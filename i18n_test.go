@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStatusName проверяет перевод статусов и запасные варианты
+func TestStatusName(t *testing.T) {
+	require.Equal(t, "Отправлена", StatusName(LocaleRU, ParcelStatusSent))
+	require.Equal(t, "Sent", StatusName(LocaleEN, ParcelStatusSent))
+	require.Equal(t, "Отправлена", StatusName("de", ParcelStatusSent))
+	require.Equal(t, "at_customs", StatusName(LocaleEN, "at_customs"))
+
+	// у каждого статуса есть перевод на каждый язык
+	for locale, names := range statusNames {
+		for status := range parcelStatuses {
+			require.NotEmpty(t, names[status], locale+": "+status)
+		}
+	}
+}
+
+// TestNegotiateLocale проверяет выбор языка по Accept-Language
+func TestNegotiateLocale(t *testing.T) {
+	for header, locale := range map[string]string{
+		"":                             DefaultLocale,
+		"en":                           LocaleEN,
+		"en-GB,en;q=0.9":               LocaleEN,
+		"de-DE,de;q=0.9,en;q=0.8":      LocaleEN,
+		"en;q=0.5, ru-RU":              LocaleRU,
+		"fr, de":                       DefaultLocale,
+		"en;q=0, ru;q=0.1":             LocaleRU,
+		"en;q=bad":                     DefaultLocale,
+		"ru;q=0.8, en;q=0.8, de;q=1.0": LocaleRU,
+	} {
+		require.Equal(t, locale, NegotiateLocale(header), header)
+	}
+}
+
+// FuzzNegotiateLocale проверяет, что по любому Accept-Language выбирается
+// поддерживаемый язык
+func FuzzNegotiateLocale(f *testing.F) {
+	f.Add("en-GB,en;q=0.9,ru;q=0.8")
+	f.Add("ru;q=abc, en;q=NaN")
+	f.Add(";;,q=,")
+
+	f.Fuzz(func(t *testing.T, header string) {
+		require.True(t, IsSupportedLocale(NegotiateLocale(header)))
+	})
+}
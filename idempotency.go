@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// IdempotencyKeyTTL — сколько хранится ответ на запрос с Idempotency-Key
+	IdempotencyKeyTTL = 24 * time.Hour
+	// MaxIdempotencyKeyLength — максимальная длина Idempotency-Key
+	MaxIdempotencyKeyLength = 255
+	// maxIdempotentBody — максимальный размер тела запроса, который хэшируется
+	maxIdempotentBody = 1 << 20
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("ключ идемпотентности уже использован для другого запроса")
+	ErrIdempotencyKeyInProgress = errors.New("запрос с этим ключом идемпотентности ещё выполняется")
+)
+
+// IdempotentResponse — сохранённый ответ на запрос с Idempotency-Key.
+// Нулевой Status — запрос ещё выполняется.
+type IdempotentResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// ReserveIdempotencyKey занимает ключ за запросом с хэшем requestHash.
+// Если ключ уже занят тем же запросом и ответ сохранён, возвращает этот
+// ответ и reserved = false. Ключи старше IdempotencyKeyTTL удаляются.
+func (s ParcelStore) ReserveIdempotencyKey(key string, requestHash string) (IdempotentResponse, bool, error) {
+	now := s.now()
+	_, err := s.db.Exec("DELETE FROM {idempotency_key} WHERE created_at < :expired",
+		sql.Named("expired", formatTime(now.Add(-IdempotencyKeyTTL))))
+	if err != nil {
+		return IdempotentResponse{}, false, err
+	}
+
+	res, err := s.db.Exec("INSERT INTO {idempotency_key} (key, request_hash, created_at) "+
+		"VALUES (:key, :request_hash, :created_at) ON CONFLICT (key) DO NOTHING",
+		sql.Named("key", key),
+		sql.Named("request_hash", requestHash),
+		sql.Named("created_at", formatTime(now)))
+	if err != nil {
+		return IdempotentResponse{}, false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return IdempotentResponse{}, false, err
+	}
+	if affected > 0 {
+		return IdempotentResponse{}, true, nil
+	}
+
+	var hash string
+	resp := IdempotentResponse{}
+	err = s.db.QueryRow("SELECT request_hash, status, content_type, body FROM {idempotency_key} WHERE key = :key",
+		sql.Named("key", key)).Scan(&hash, &resp.Status, &resp.ContentType, &resp.Body)
+	if err != nil {
+		return IdempotentResponse{}, false, err
+	}
+
+	switch {
+	case hash != requestHash:
+		return IdempotentResponse{}, false, ErrIdempotencyKeyReused
+	case resp.Status == 0:
+		return IdempotentResponse{}, false, ErrIdempotencyKeyInProgress
+	}
+
+	return resp, false, nil
+}
+
+// CompleteIdempotencyKey сохраняет ответ на запрос, занявший ключ
+func (s ParcelStore) CompleteIdempotencyKey(key string, resp IdempotentResponse) error {
+	// nil записался бы как NULL
+	if resp.Body == nil {
+		resp.Body = []byte{}
+	}
+
+	_, err := s.db.Exec("UPDATE {idempotency_key} SET status = :status, content_type = :content_type, body = :body "+
+		"WHERE key = :key",
+		sql.Named("status", resp.Status),
+		sql.Named("content_type", resp.ContentType),
+		sql.Named("body", resp.Body),
+		sql.Named("key", key))
+
+	return err
+}
+
+// ReleaseIdempotencyKey освобождает ключ, чтобы запрос можно было повторить
+func (s ParcelStore) ReleaseIdempotencyKey(key string) error {
+	_, err := s.db.Exec("DELETE FROM {idempotency_key} WHERE key = :key", sql.Named("key", key))
+
+	return err
+}
+
+// responseRecorder запоминает статус и тело ответа, передавая их клиенту
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotent обрабатывает заголовок Idempotency-Key у изменяющих запросов.
+// Повтор с тем же ключом и тем же запросом получает сохранённый ответ без
+// повторного выполнения, тот же ключ с другим запросом — 422, повтор во
+// время выполнения первого запроса — 409. Ответы 5xx и паника обработчика
+// освобождают ключ, такой запрос можно повторить с тем же ключом. Запросы
+// без ключа и GET выполняются как обычно.
+func (h AdminHandler) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		if len(key) > MaxIdempotencyKeyLength {
+			http.Error(w, "слишком длинный Idempotency-Key", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// форма и параметры запроса входят в хэш, чтобы тот же ключ
+		// с другими данными не получил чужой ответ
+		sum := sha256.New()
+		io.WriteString(sum, r.Method+" "+r.URL.RequestURI()+"\n")
+		sum.Write(body)
+		hash := hex.EncodeToString(sum.Sum(nil))
+
+		stored, reserved, err := h.store.ReserveIdempotencyKey(key, hash)
+		switch {
+		case errors.Is(err, ErrIdempotencyKeyReused):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, ErrIdempotencyKeyInProgress):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			h.fail(w, r, err)
+			return
+		}
+
+		if !reserved {
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		// паника обработчика не должна оставить ключ занятым до истечения
+		// IdempotencyKeyTTL; саму панику обрабатывает http.Server
+		defer func() {
+			if v := recover(); v != nil {
+				h.errors.RecordContext(r.Context(), h.store.ReleaseIdempotencyKey(key))
+				panic(v)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		if rec.status >= http.StatusInternalServerError {
+			err = h.store.ReleaseIdempotencyKey(key)
+		} else {
+			err = h.store.CompleteIdempotencyKey(key, IdempotentResponse{
+				Status:      rec.status,
+				ContentType: rec.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			})
+		}
+		if err != nil {
+			// ответ уже отправлен, остаётся только записать ошибку
+			h.errors.RecordContext(r.Context(), err)
+		}
+	}
+}
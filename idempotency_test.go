@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestIdempotentHandler проверяет повтор запроса с тем же Idempotency-Key
+func TestIdempotentHandler(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	h := AdminHandler{store: store, errors: NewErrorLog(10)}
+
+	calls := 0
+	fail, crash := false, false
+	handler := h.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if crash {
+			panic("сбой")
+		}
+		if fail {
+			http.Error(w, "сбой", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+	post := func(key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/flags", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// request
+	rec := post("k1", "name=a")
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	// повтор получает сохранённый ответ без повторного выполнения
+	rec = post("k1", "name=a")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Equal(t, "created", rec.Body.String())
+	require.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+	require.Equal(t, "true", rec.Header().Get("Idempotent-Replayed"))
+	require.Equal(t, 1, calls)
+
+	// тот же ключ с другим телом отклоняется
+	rec = post("k1", "name=b")
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	require.Equal(t, 1, calls)
+
+	// ответ 5xx не сохраняется, запрос можно повторить
+	fail = true
+	require.Equal(t, http.StatusInternalServerError, post("k2", "name=a").Code)
+	fail = false
+	require.Equal(t, http.StatusCreated, post("k2", "name=a").Code)
+	require.Equal(t, 3, calls)
+
+	// паника обработчика тоже освобождает ключ
+	crash = true
+	require.Panics(t, func() { post("k3", "name=a") })
+	crash = false
+	require.Equal(t, http.StatusCreated, post("k3", "name=a").Code)
+	require.Equal(t, 5, calls)
+
+	// без ключа запрос выполняется каждый раз
+	post("", "name=a")
+	post("", "name=a")
+	require.Equal(t, 7, calls)
+}
+
+// TestReserveIdempotencyKeyInProgress проверяет повтор во время выполнения запроса
+func TestReserveIdempotencyKeyInProgress(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+
+	_, reserved, err := store.ReserveIdempotencyKey("k", "hash")
+	require.NoError(t, err)
+	require.True(t, reserved)
+
+	// check
+	_, _, err = store.ReserveIdempotencyKey("k", "hash")
+	require.ErrorIs(t, err, ErrIdempotencyKeyInProgress)
+
+	require.NoError(t, store.CompleteIdempotencyKey("k", IdempotentResponse{Status: http.StatusNoContent}))
+	resp, reserved, err := store.ReserveIdempotencyKey("k", "hash")
+	require.NoError(t, err)
+	require.False(t, reserved)
+	require.Equal(t, http.StatusNoContent, resp.Status)
+}
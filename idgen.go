@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// IDGenerator выдаёт номера новых посылок. Нулевой номер означает,
+// что номер назначает БД при вставке.
+type IDGenerator interface {
+	NextID() (int, error)
+}
+
+// AutoIncrement оставляет назначение номеров автоинкременту БД
+type AutoIncrement struct{}
+
+func (AutoIncrement) NextID() (int, error) {
+	return 0, nil
+}
+
+const (
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	snowflakeMaxNode  = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSeq   = 1<<snowflakeSeqBits - 1
+)
+
+// snowflakeEpoch — точка отсчёта времени в номерах Snowflake
+var snowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var ErrInvalidNode = errors.New("номер узла Snowflake должен быть от 0 до 1023")
+
+// Snowflake выдаёт возрастающие номера без обращения к БД, уникальные
+// между узлами с разными node: 41 бит миллисекунд от snowflakeEpoch,
+// 10 бит номера узла и 12 бит счётчика в пределах миллисекунды.
+// Безопасен для использования из нескольких горутин.
+type Snowflake struct {
+	mu     sync.Mutex
+	node   int64
+	lastMs int64
+	seq    int64
+	now    func() time.Time
+}
+
+// NewSnowflake создаёт генератор для узла node
+func NewSnowflake(node int) (*Snowflake, error) {
+	if node < 0 || node > snowflakeMaxNode {
+		return nil, ErrInvalidNode
+	}
+
+	return &Snowflake{node: int64(node), now: time.Now}, nil
+}
+
+func (g *Snowflake) NextID() (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := g.now().Sub(snowflakeEpoch).Milliseconds()
+	if ms < g.lastMs {
+		// часы перевели назад: продолжаем от последней выданной миллисекунды,
+		// чтобы номера не повторялись
+		ms = g.lastMs
+	}
+
+	if ms == g.lastMs {
+		g.seq = (g.seq + 1) & snowflakeMaxSeq
+		if g.seq == 0 {
+			// счётчик миллисекунды исчерпан, ждём следующую
+			for ms <= g.lastMs {
+				time.Sleep(time.Millisecond)
+				ms = g.now().Sub(snowflakeEpoch).Milliseconds()
+			}
+		}
+	} else {
+		g.seq = 0
+	}
+	g.lastMs = ms
+
+	return int(ms<<(snowflakeNodeBits+snowflakeSeqBits) | g.node<<snowflakeSeqBits | g.seq), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestSnowflakeUnique проверяет уникальность номеров при выдаче из нескольких горутин
+func TestSnowflakeUnique(t *testing.T) {
+	// prepare
+	g, err := NewSnowflake(7)
+	require.NoError(t, err)
+
+	const workers, perWorker = 8, 2000
+	ids := make(chan int, workers*perWorker)
+
+	// generate
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id, err := g.NextID()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ids <- id
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	// check
+	seen := map[int]bool{}
+	for id := range ids {
+		require.False(t, seen[id], id)
+		seen[id] = true
+		require.Equal(t, 7, id>>snowflakeSeqBits&snowflakeMaxNode)
+	}
+	require.Len(t, seen, workers*perWorker)
+}
+
+// TestSnowflakeClockBackwards проверяет, что перевод часов назад не ломает возрастание номеров
+func TestSnowflakeClockBackwards(t *testing.T) {
+	// prepare
+	now := time.Now()
+	g, err := NewSnowflake(1)
+	require.NoError(t, err)
+	g.now = func() time.Time { return now }
+
+	// check
+	first, err := g.NextID()
+	require.NoError(t, err)
+
+	now = now.Add(-time.Second)
+	second, err := g.NextID()
+	require.NoError(t, err)
+	require.Greater(t, second, first)
+
+	_, err = NewSnowflake(snowflakeMaxNode + 1)
+	require.ErrorIs(t, err, ErrInvalidNode)
+}
+
+// TestAddWithIDGenerator проверяет сохранение посылки под номером генератора
+func TestAddWithIDGenerator(t *testing.T) {
+	// prepare
+	g, err := NewSnowflake(3)
+	require.NoError(t, err)
+	store := NewParcelStore(openTestDB(t), WithIDGenerator(g))
+
+	// add
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.Greater(t, id, 1<<(snowflakeNodeBits+snowflakeSeqBits))
+
+	// check
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, id, stored.Number)
+}
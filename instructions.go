@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// InstructionNeighbor — оставить соседу, Note — у кого
+	InstructionNeighbor = "neighbor"
+	// InstructionSafePlace — оставить в безопасном месте, Note — где
+	InstructionSafePlace = "safe_place"
+)
+
+// maxInstructionNote — наибольшая длина пояснения к указанию в символах
+const maxInstructionNote = 256
+
+var (
+	ErrInvalidDeliveryInstructions = errors.New("некорректные указания по доставке")
+	ErrDeliveryInstructionsClosed  = errors.New("указания по доставке нельзя изменить для завершённой посылки")
+)
+
+// DeliveryInstructions — согласие получателя на доставку без вручения лично
+type DeliveryInstructions struct {
+	Kind  string    `json:"kind"`
+	Note  string    `json:"note"`
+	SetAt time.Time `json:"set_at"`
+}
+
+// Validate проверяет указания перед сохранением
+func (i DeliveryInstructions) Validate() error {
+	switch {
+	case i.Kind != InstructionNeighbor && i.Kind != InstructionSafePlace:
+		return fmt.Errorf("%w: неизвестный вид %q", ErrInvalidDeliveryInstructions, i.Kind)
+	case strings.TrimSpace(i.Note) == "":
+		return fmt.Errorf("%w: не указано, кому или где оставить посылку", ErrInvalidDeliveryInstructions)
+	case utf8.RuneCountInString(i.Note) > maxInstructionNote:
+		return fmt.Errorf("%w: пояснение длиннее %d символов", ErrInvalidDeliveryInstructions, maxInstructionNote)
+	}
+
+	return nil
+}
+
+// SetDeliveryInstructions записывает указания получателя по доставке
+// посылки. Пустой вид снимает указания. Для доставленной и выбывшей
+// посылки указания не меняются.
+func (s ParcelStore) SetDeliveryInstructions(number int, i DeliveryInstructions) error {
+	if i.Kind != "" {
+		if err := i.Validate(); err != nil {
+			return err
+		}
+	}
+
+	p, err := s.Get(number)
+	if err != nil {
+		return err
+	}
+	if p.Status == ParcelStatusDelivered || offPathStatuses[p.Status] {
+		return ErrDeliveryInstructionsClosed
+	}
+
+	if i.Kind == "" {
+		_, err := s.db.Exec("DELETE FROM {delivery_instructions} WHERE parcel = :parcel", sql.Named("parcel", number))
+		return err
+	}
+
+	_, err = s.db.Exec("INSERT INTO {delivery_instructions} (parcel, kind, note, set_at) VALUES (:parcel, :kind, :note, :set_at) "+
+		"ON CONFLICT (parcel) DO UPDATE SET kind = excluded.kind, note = excluded.note, set_at = excluded.set_at",
+		sql.Named("parcel", number),
+		sql.Named("kind", i.Kind),
+		sql.Named("note", strings.TrimSpace(i.Note)),
+		sql.Named("set_at", formatTime(s.now())))
+
+	return err
+}
+
+// GetDeliveryInstructions возвращает указания по доставке посылки,
+// nil — указаний нет
+func (s ParcelStore) GetDeliveryInstructions(number int) (*DeliveryInstructions, error) {
+	var i DeliveryInstructions
+	err := s.db.QueryRow("SELECT kind, note, set_at FROM {delivery_instructions} WHERE parcel = :parcel",
+		sql.Named("parcel", number)).Scan(&i.Kind, &i.Note, scanTime(&i.SetAt))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+// NewDeliveryInstructionsHandler возвращает публичный обработчик POST
+// /track/{code}/instructions: JSON {"token", "kind", "note"}, где token —
+// токен получателя, см. RecipientToken. Пустой kind снимает указания.
+func NewDeliveryInstructionsHandler(store ParcelStore, errLog *ErrorLog) http.Handler {
+	h := AdminHandler{store: store, errors: errLog}
+
+	return recipientAction(store, errLog, "instructions", func(w http.ResponseWriter, r *http.Request, p Parcel, body []byte) {
+		var req struct {
+			Kind string `json:"kind"`
+			Note string `json:"note"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "некорректный JSON", http.StatusBadRequest)
+			return
+		}
+
+		err := store.SetDeliveryInstructions(p.Number, DeliveryInstructions{Kind: req.Kind, Note: req.Note})
+		switch {
+		case errors.Is(err, ErrInvalidDeliveryInstructions):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrDeliveryInstructionsClosed):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestDeliveryInstructions проверяет указания получателя по доставке
+func TestDeliveryInstructions(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	day := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	numbers := addTestRoute(t, store, day)
+
+	// некорректные указания не сохраняются
+	err := store.SetDeliveryInstructions(numbers[0], DeliveryInstructions{Kind: "roof", Note: "крыша"})
+	require.ErrorIs(t, err, ErrInvalidDeliveryInstructions)
+	err = store.SetDeliveryInstructions(numbers[0], DeliveryInstructions{Kind: InstructionNeighbor, Note: " "})
+	require.ErrorIs(t, err, ErrInvalidDeliveryInstructions)
+
+	// set
+	err = store.SetDeliveryInstructions(numbers[0], DeliveryInstructions{Kind: InstructionNeighbor, Note: "кв. 12, Петрова"})
+	require.NoError(t, err)
+	err = store.SetDeliveryInstructions(numbers[1], DeliveryInstructions{Kind: InstructionSafePlace, Note: "крыльцо"})
+	require.NoError(t, err)
+	require.NoError(t, store.SetDeliveryInstructions(numbers[1], DeliveryInstructions{}))
+
+	// check
+	i, err := store.GetDeliveryInstructions(numbers[0])
+	require.NoError(t, err)
+	require.Equal(t, InstructionNeighbor, i.Kind)
+	require.Equal(t, "кв. 12, Петрова", i.Note)
+	i, err = store.GetDeliveryInstructions(numbers[1])
+	require.NoError(t, err)
+	require.Nil(t, i)
+
+	route, err := store.ListDeliveryRoute(7, day)
+	require.NoError(t, err)
+	for _, st := range route.Stops {
+		if st.Number == numbers[0] {
+			require.NotNil(t, st.Instructions)
+			require.Equal(t, "кв. 12, Петрова", st.Instructions.Note)
+			require.False(t, st.Instructions.SetAt.IsZero())
+		} else {
+			require.Nil(t, st.Instructions)
+		}
+	}
+
+	// для доставленной посылки указания не меняются
+	require.NoError(t, store.SetStatus(numbers[2], ParcelStatusDelivered))
+	err = store.SetDeliveryInstructions(numbers[2], DeliveryInstructions{Kind: InstructionSafePlace, Note: "крыльцо"})
+	require.ErrorIs(t, err, ErrDeliveryInstructionsClosed)
+}
+
+// TestDeliveryInstructionsEndpoint проверяет указания по доставке через
+// публичное отслеживание с токеном получателя
+func TestDeliveryInstructionsEndpoint(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithRecipientTokenKey([]byte("секрет"), 0))
+	parcel := getTestParcel()
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+	token, err := store.RecipientToken(parcel.UUID)
+	require.NoError(t, err)
+	handler := NewHTTPHandler(store, NewErrorLog(10))
+
+	post := func(body string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/track/"+parcel.UUID+"/instructions", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	// check
+	require.Equal(t, http.StatusForbidden, post(`{"token": "1.abc", "kind": "safe_place", "note": "крыльцо"}`))
+	require.Equal(t, http.StatusBadRequest, post(`{"token": "`+token+`", "kind": "roof", "note": "крыша"}`))
+	require.Equal(t, http.StatusNoContent, post(`{"token": "`+token+`", "kind": "safe_place", "note": "крыльцо"}`))
+
+	i, err := store.GetDeliveryInstructions(number)
+	require.NoError(t, err)
+	require.Equal(t, "крыльцо", i.Note)
+
+	// отслеживание по-прежнему принимает только GET
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/track/"+parcel.UUID, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ClaimStatusOpen     = "open"
+	ClaimStatusApproved = "approved"
+	ClaimStatusRejected = "rejected"
+)
+
+const (
+	// InsuranceRateBP — ставка страховой премии в базисных пунктах от объявленной ценности
+	InsuranceRateBP = 100
+	// MinInsurancePremium — минимальная страховая премия в копейках
+	MinInsurancePremium int64 = 3000
+)
+
+var (
+	ErrInvalidDeclaredValue = errors.New("некорректная объявленная ценность")
+	ErrNotInsured           = errors.New("посылка не застрахована")
+	ErrNotClaimable         = errors.New("страховой случай возможен только для утерянной или повреждённой посылки")
+	ErrInvalidClaimAmount   = errors.New("некорректная сумма страхового требования")
+	ErrClaimExists          = errors.New("по посылке уже есть открытое страховое требование")
+	ErrClaimResolved        = errors.New("страховое требование уже рассмотрено")
+)
+
+// Claim — страховое требование по посылке, суммы в копейках
+type Claim struct {
+	ID          int
+	Parcel      int
+	Reason      string
+	Amount      int64
+	Description string
+	Status      string
+	Payout      int64
+	CreatedAt   time.Time
+	// ResolvedAt — время рассмотрения, нулевое у открытого требования
+	ResolvedAt time.Time
+}
+
+// InsurancePremium рассчитывает страховую премию по объявленной ценности
+// с округлением вверх до копейки
+func InsurancePremium(declaredValue int64) (int64, error) {
+	if declaredValue <= 0 {
+		return 0, ErrInvalidDeclaredValue
+	}
+
+	premium := (declaredValue*InsuranceRateBP + 9999) / 10000
+	if premium < MinInsurancePremium {
+		premium = MinInsurancePremium
+	}
+
+	return premium, nil
+}
+
+// FileClaim регистрирует страховое требование по застрахованной посылке,
+// которая утеряна или повреждена. Сумма не может превышать объявленную ценность.
+func (s ParcelStore) FileClaim(number int, amount int64, description string) (int, error) {
+	p, err := s.Get(number)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case !p.Insured:
+		return 0, ErrNotInsured
+	case p.Status != ParcelStatusLost && p.Status != ParcelStatusDamaged:
+		return 0, ErrNotClaimable
+	case amount <= 0 || amount > p.DeclaredValue:
+		return 0, fmt.Errorf("%w: %d при объявленной ценности %d", ErrInvalidClaimAmount, amount, p.DeclaredValue)
+	}
+
+	res, err := s.db.Exec("INSERT INTO {insurance_claim} (parcel, reason, amount, description, status, created_at) "+
+		"VALUES (:parcel, :reason, :amount, :description, :status, :created_at)",
+		sql.Named("parcel", number),
+		sql.Named("reason", p.Status),
+		sql.Named("amount", amount),
+		sql.Named("description", description),
+		sql.Named("status", ClaimStatusOpen),
+		sql.Named("created_at", formatTime(s.now())))
+	if isUniqueViolation(err) {
+		// открытое требование может быть только одно, см. insurance_claim_open_uq
+		return 0, ErrClaimExists
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// ResolveClaim закрывает открытое страховое требование. При одобрении
+// выплата не может превышать заявленную сумму, при отказе выплата нулевая.
+func (s ParcelStore) ResolveClaim(id int, approved bool, payout int64) error {
+	c, err := s.GetClaim(id)
+	if err != nil {
+		return err
+	}
+	if c.Status != ClaimStatusOpen {
+		return ErrClaimResolved
+	}
+
+	status := ClaimStatusRejected
+	if approved {
+		status = ClaimStatusApproved
+		if payout <= 0 || payout > c.Amount {
+			return fmt.Errorf("%w: выплата %d при требовании %d", ErrInvalidClaimAmount, payout, c.Amount)
+		}
+	} else {
+		payout = 0
+	}
+
+	res, err := s.db.Exec("UPDATE {insurance_claim} SET status = :status, payout = :payout, resolved_at = :resolved_at "+
+		"WHERE id = :id AND status = :open",
+		sql.Named("status", status),
+		sql.Named("payout", payout),
+		sql.Named("resolved_at", formatTime(s.now())),
+		sql.Named("id", id),
+		sql.Named("open", ClaimStatusOpen))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		// требование рассмотрели параллельно
+		return ErrClaimResolved
+	}
+
+	return nil
+}
+
+// GetClaim возвращает страховое требование по идентификатору
+func (s ParcelStore) GetClaim(id int) (Claim, error) {
+	row := s.db.QueryRow("SELECT id, parcel, reason, amount, description, status, payout, created_at, resolved_at "+
+		"FROM {insurance_claim} WHERE id = :id",
+		sql.Named("id", id))
+
+	c := Claim{}
+	err := row.Scan(&c.ID, &c.Parcel, &c.Reason, &c.Amount, &c.Description, &c.Status, &c.Payout,
+		scanTime(&c.CreatedAt), scanTime(&c.ResolvedAt))
+	if err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+// GetClaims возвращает страховые требования по посылке
+func (s ParcelStore) GetClaims(number int) ([]Claim, error) {
+	rows, err := s.db.Query("SELECT id, parcel, reason, amount, description, status, payout, created_at, resolved_at "+
+		"FROM {insurance_claim} WHERE parcel = :parcel ORDER BY id",
+		sql.Named("parcel", number))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Claim
+	for rows.Next() {
+		c := Claim{}
+		err := rows.Scan(&c.ID, &c.Parcel, &c.Reason, &c.Amount, &c.Description, &c.Status, &c.Payout,
+			scanTime(&c.CreatedAt), scanTime(&c.ResolvedAt))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
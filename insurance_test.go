@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// getTestInsuredParcel возвращает тестовую застрахованную посылку
+func getTestInsuredParcel() Parcel {
+	parcel := getTestParcel()
+	parcel.Insured = true
+	parcel.DeclaredValue = 1_000_000
+
+	return parcel
+}
+
+// TestInsurancePremium проверяет расчёт страховой премии
+func TestInsurancePremium(t *testing.T) {
+	premium, err := InsurancePremium(1_000_000)
+	require.NoError(t, err)
+	require.Equal(t, int64(10_000), premium)
+
+	premium, err = InsurancePremium(1_000_001)
+	require.NoError(t, err)
+	require.Equal(t, int64(10_001), premium)
+
+	premium, err = InsurancePremium(100)
+	require.NoError(t, err)
+	require.Equal(t, MinInsurancePremium, premium)
+
+	_, err = InsurancePremium(0)
+	require.ErrorIs(t, err, ErrInvalidDeclaredValue)
+}
+
+// TestAddInsured проверяет сохранение премии застрахованной посылки
+func TestAddInsured(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+
+	// add
+	id, err := store.Add(getTestInsuredParcel())
+	require.NoError(t, err)
+
+	// check
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.True(t, stored.Insured)
+	require.Equal(t, int64(10_000), stored.InsurancePremium)
+
+	parcel := getTestParcel()
+	parcel.Insured = true
+	_, err = store.Add(parcel)
+	require.ErrorIs(t, err, ErrInvalidDeclaredValue)
+}
+
+// TestFileAndResolveClaim проверяет подачу и рассмотрение страхового требования
+func TestFileAndResolveClaim(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestInsuredParcel())
+	require.NoError(t, err)
+
+	// требование по доставляемой посылке не принимается
+	_, err = store.FileClaim(number, 500_000, "не пришла")
+	require.ErrorIs(t, err, ErrNotClaimable)
+
+	// file
+	require.NoError(t, store.SetStatus(number, ParcelStatusLost))
+	_, err = store.FileClaim(number, 2_000_000, "не пришла")
+	require.ErrorIs(t, err, ErrInvalidClaimAmount)
+
+	id, err := store.FileClaim(number, 500_000, "не пришла")
+	require.NoError(t, err)
+
+	_, err = store.FileClaim(number, 500_000, "не пришла")
+	require.ErrorIs(t, err, ErrClaimExists)
+
+	// resolve
+	require.ErrorIs(t, store.ResolveClaim(id, true, 600_000), ErrInvalidClaimAmount)
+	require.NoError(t, store.ResolveClaim(id, true, 400_000))
+	require.ErrorIs(t, store.ResolveClaim(id, false, 0), ErrClaimResolved)
+
+	// check
+	claims, err := store.GetClaims(number)
+	require.NoError(t, err)
+	require.Len(t, claims, 1)
+	require.Equal(t, ParcelStatusLost, claims[0].Reason)
+	require.Equal(t, ClaimStatusApproved, claims[0].Status)
+	require.Equal(t, int64(400_000), claims[0].Payout)
+	require.NotEmpty(t, claims[0].ResolvedAt)
+}
+
+// TestFileClaimNotInsured проверяет, что по незастрахованной посылке требование не принимается
+func TestFileClaimNotInsured(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(number, ParcelStatusDamaged))
+
+	// check
+	_, err = store.FileClaim(number, 100, "разбита")
+	require.ErrorIs(t, err, ErrNotInsured)
+}
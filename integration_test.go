@@ -0,0 +1,28 @@
+//go:build integration
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	mysqlRequired = true
+}
+
+// TestIntegrationMySQLMigrate проверяет, что повторный запуск миграций
+// MySQL ничего не меняет и версия схемы равна числу миграций
+func TestIntegrationMySQLMigrate(t *testing.T) {
+	// prepare
+	store := openTestMySQL(t).(MySQLParcelStore)
+
+	// migrate
+	require.NoError(t, store.Migrate())
+
+	// check
+	var version int
+	require.NoError(t, store.db.QueryRow("SELECT version FROM {schema_version}").Scan(&version))
+	require.Equal(t, len(mysqlMigrations), version)
+}
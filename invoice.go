@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Виды строк счёта
+const (
+	InvoiceLineShipping  = "shipping"
+	InvoiceLineInsurance = "insurance"
+	InvoiceLineCODFee    = "cod_fee"
+	// InvoiceLineDiscount — скидка на доставку, сумма отрицательная
+	InvoiceLineDiscount = "discount"
+)
+
+// CODFeeRateBP — комиссия за наложенный платёж в базисных пунктах от суммы платежа
+const CODFeeRateBP = 200
+
+var (
+	ErrInvoiceExists    = errors.New("счёт клиенту за период уже выставлен")
+	ErrNothingToInvoice = errors.New("за период нет посылок для счёта")
+	ErrInvoicePaid      = errors.New("счёт уже оплачен")
+)
+
+// InvoiceLine — строка счёта по одной посылке, сумма в младших единицах валюты счёта
+type InvoiceLine struct {
+	Parcel int    `json:"parcel"`
+	Kind   string `json:"kind"`
+	Amount int64  `json:"amount"`
+}
+
+// Invoice — счёт клиенту за посылки в валюте Currency, зарегистрированные
+// в [From, To). Total — сумма строк в младших единицах валюты.
+type Invoice struct {
+	ID        int       `json:"id"`
+	Client    int       `json:"client"`
+	Currency  string    `json:"currency"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Total     int64     `json:"total"`
+	CreatedAt time.Time `json:"created_at"`
+	// PaidAt — время оплаты, нулевое у неоплаченного счёта
+	PaidAt time.Time     `json:"paid_at"`
+	Lines  []InvoiceLine `json:"lines,omitempty"`
+}
+
+// CODFee рассчитывает комиссию за наложенный платёж с округлением вверх
+// до младшей единицы валюты
+func CODFee(amount int64) int64 {
+	return (amount*CODFeeRateBP + 9999) / 10000
+}
+
+// invoiceLines возвращает строки счёта по посылке: доставка, скидка,
+// страховая премия и комиссия за наложенный платёж, нулевые суммы пропускаются
+func invoiceLines(p Parcel) []InvoiceLine {
+	var res []InvoiceLine
+	for _, l := range []InvoiceLine{
+		{Parcel: p.Number, Kind: InvoiceLineShipping, Amount: p.Price},
+		{Parcel: p.Number, Kind: InvoiceLineDiscount, Amount: -p.Discount},
+		{Parcel: p.Number, Kind: InvoiceLineInsurance, Amount: p.InsurancePremium},
+		{Parcel: p.Number, Kind: InvoiceLineCODFee, Amount: CODFee(p.CODAmount)},
+	} {
+		if l.Amount != 0 {
+			res = append(res, l)
+		}
+	}
+
+	return res
+}
+
+// GenerateInvoice выставляет клиенту счёт в валюте currency за календарный
+// месяц в UTC, которому принадлежит month; пустая валюта — DefaultCurrency.
+// В счёт попадают все посылки в этой валюте, зарегистрированные за месяц,
+// кроме отменённых: суммы разных валют не складываются, на каждую валюту
+// выставляется свой счёт. Второй счёт за тот же месяц и валюту не выставляется.
+func (s ParcelStore) GenerateInvoice(client int, month time.Time, currency string) (Invoice, error) {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	month = month.UTC()
+	inv := Invoice{
+		Client:    client,
+		Currency:  currency,
+		From:      time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC),
+		CreatedAt: s.now().UTC(),
+	}
+	inv.To = inv.From.AddDate(0, 1, 0)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Invoice{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(parcelSelect+"WHERE client = :client AND currency = :currency "+
+		"AND created_at >= :from AND created_at < :to AND status <> :cancelled ORDER BY number",
+		sql.Named("client", client),
+		sql.Named("currency", currency),
+		sql.Named("from", formatTime(inv.From)),
+		sql.Named("to", formatTime(inv.To)),
+		sql.Named("cancelled", ParcelStatusCancelled))
+	if err != nil {
+		return Invoice{}, err
+	}
+	parcels, err := scanParcels(rows)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	for _, p := range parcels {
+		for _, l := range invoiceLines(p) {
+			inv.Lines = append(inv.Lines, l)
+			inv.Total += l.Amount
+		}
+	}
+	if len(inv.Lines) == 0 {
+		return Invoice{}, ErrNothingToInvoice
+	}
+
+	res, err := tx.Exec("INSERT INTO {invoice} (client, currency, period_from, period_to, total, created_at) "+
+		"VALUES (:client, :currency, :from, :to, :total, :created_at)",
+		sql.Named("client", inv.Client),
+		sql.Named("currency", inv.Currency),
+		sql.Named("from", formatTime(inv.From)),
+		sql.Named("to", formatTime(inv.To)),
+		sql.Named("total", inv.Total),
+		sql.Named("created_at", formatTime(inv.CreatedAt)))
+	if errors.Is(err, ErrDuplicate) {
+		return Invoice{}, ErrInvoiceExists
+	}
+	if err != nil {
+		return Invoice{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Invoice{}, err
+	}
+	inv.ID = int(id)
+
+	for _, l := range inv.Lines {
+		_, err := tx.Exec("INSERT INTO {invoice_line} (invoice, parcel, kind, amount) VALUES (:invoice, :parcel, :kind, :amount)",
+			sql.Named("invoice", inv.ID),
+			sql.Named("parcel", l.Parcel),
+			sql.Named("kind", l.Kind),
+			sql.Named("amount", l.Amount))
+		if err != nil {
+			return Invoice{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Invoice{}, err
+	}
+
+	return inv, nil
+}
+
+// invoiceSelect — выборка счёта без строк
+const invoiceSelect = "SELECT id, client, currency, period_from, period_to, total, created_at, paid_at FROM {invoice} "
+
+// scanInvoice читает счёт без строк
+func scanInvoice(row interface{ Scan(...any) error }) (Invoice, error) {
+	inv := Invoice{}
+	err := row.Scan(&inv.ID, &inv.Client, &inv.Currency, scanTime(&inv.From), scanTime(&inv.To), &inv.Total,
+		scanTime(&inv.CreatedAt), scanTime(&inv.PaidAt))
+
+	return inv, err
+}
+
+// GetInvoice возвращает счёт со строками
+func (s ParcelStore) GetInvoice(id int) (Invoice, error) {
+	inv, err := scanInvoice(s.db.QueryRow(invoiceSelect+"WHERE id = :id", sql.Named("id", id)))
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	rows, err := s.db.Query("SELECT parcel, kind, amount FROM {invoice_line} WHERE invoice = :invoice ORDER BY id",
+		sql.Named("invoice", id))
+	if err != nil {
+		return Invoice{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		l := InvoiceLine{}
+		if err := rows.Scan(&l.Parcel, &l.Kind, &l.Amount); err != nil {
+			return Invoice{}, err
+		}
+		inv.Lines = append(inv.Lines, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return Invoice{}, err
+	}
+
+	return inv, nil
+}
+
+// ListInvoices возвращает счета клиента без строк, новые периоды первыми
+func (s ParcelStore) ListInvoices(client int) ([]Invoice, error) {
+	rows, err := s.db.Query(invoiceSelect+"WHERE client = :client ORDER BY period_from DESC",
+		sql.Named("client", client))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Invoice
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, inv)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// MarkInvoicePaid отмечает оплату счёта. Оплатить счёт можно только один раз.
+func (s ParcelStore) MarkInvoicePaid(id int) error {
+	res, err := s.db.Exec("UPDATE {invoice} SET paid_at = :paid_at WHERE id = :id AND paid_at = ''",
+		sql.Named("paid_at", formatTime(s.now())),
+		sql.Named("id", id))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	if _, err := scanInvoice(s.db.QueryRow(invoiceSelect+"WHERE id = :id", sql.Named("id", id))); err != nil {
+		return err
+	}
+
+	return ErrInvoicePaid
+}
+
+// WriteCSV записывает счёт в CSV: строка на строку счёта и итоговая
+// строка total, суммы в основных единицах валюты счёта
+func (inv Invoice) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"invoice", "client", "from", "to", "parcel", "kind", "amount", "currency"}); err != nil {
+		return err
+	}
+
+	id, client := strconv.Itoa(inv.ID), strconv.Itoa(inv.Client)
+	from, to := inv.From.Format(dateLayout), inv.To.Format(dateLayout)
+	for _, l := range inv.Lines {
+		if err := cw.Write([]string{id, client, from, to, strconv.Itoa(l.Parcel), l.Kind,
+			FormatAmount(l.Amount, inv.Currency), inv.Currency}); err != nil {
+			return err
+		}
+	}
+	if err := cw.Write([]string{id, client, from, to, "", "total", FormatAmount(inv.Total, inv.Currency), inv.Currency}); err != nil {
+		return err
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// invoicePDFLines — сколько строк текста помещается на страницу PDF
+const invoicePDFLines = 60
+
+// WritePDF записывает счёт в PDF из страниц A4 с моноширинным текстом.
+// Стандартные шрифты PDF не содержат кириллицы, поэтому подписи в счёте
+// латиницей.
+func (inv Invoice) WritePDF(w io.Writer) error {
+	text := []string{
+		fmt.Sprintf("Invoice No %d", inv.ID),
+		fmt.Sprintf("Client: %d", inv.Client),
+		fmt.Sprintf("Period: %s - %s", inv.From.Format(dateLayout), inv.To.AddDate(0, 0, -1).Format(dateLayout)),
+		"",
+		fmt.Sprintf("%-10s %-12s %14s", "Parcel", "Item", "Amount, "+inv.Currency),
+	}
+	for _, l := range inv.Lines {
+		text = append(text, fmt.Sprintf("%-10d %-12s %14s", l.Parcel, l.Kind, FormatAmount(l.Amount, inv.Currency)))
+	}
+	text = append(text, "", fmt.Sprintf("%-23s %14s", "Total", FormatAmount(inv.Total, inv.Currency)))
+	if !inv.PaidAt.IsZero() {
+		text = append(text, "Paid: "+inv.PaidAt.Format(dateLayout))
+	}
+
+	var pages [][]string
+	for len(text) > invoicePDFLines {
+		pages = append(pages, text[:invoicePDFLines])
+		text = text[invoicePDFLines:]
+	}
+	pages = append(pages, text)
+
+	return writeTextPDF(w, pages)
+}
+
+// writeTextPDF записывает документ PDF со страницами pages, каждая страница —
+// строки текста шрифтом Courier. Объекты: 1 — каталог, 2 — дерево страниц,
+// 3 — шрифт, далее по паре объектов на страницу и её содержимое.
+func writeTextPDF(w io.Writer, pages [][]string) error {
+	var objects []string
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>")
+
+	escape := strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`)
+	for i, lines := range pages {
+		var content bytes.Buffer
+		content.WriteString("BT /F1 10 Tf 12 TL 56 786 Td\n")
+		for _, line := range lines {
+			fmt.Fprintf(&content, "(%s) '\n", escape.Replace(line))
+		}
+		content.WriteString("ET")
+
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] "+
+				"/Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 5+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestGenerateInvoice проверяет выставление счёта за месяц и его оплату
+func TestGenerateInvoice(t *testing.T) {
+	// prepare
+	// посылки за прошлые годы, поэтому время регистрации не ограничено
+	store := NewParcelStore(openTestDB(t), WithCreatedAtWindow(0, 0))
+	month := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	add := func(createdAt time.Time, price int64, codAmount int64) int {
+		p := getTestParcel()
+		p.CreatedAt = createdAt
+		p.Price = price
+		p.CODAmount = codAmount
+		number, err := store.Add(p)
+		require.NoError(t, err)
+		return number
+	}
+	plain := add(month, 30000, 0)
+	cod := add(month.AddDate(0, 0, 30), 25000, 100000)
+	add(month.AddDate(0, 1, 0), 30000, 0)
+	cancelled := add(month.AddDate(0, 0, 1), 30000, 0)
+	require.NoError(t, store.TransitionStatus(cancelled, ParcelStatusRegistered, ParcelStatusCancelled))
+
+	// check
+	inv, err := store.GenerateInvoice(1000, month.AddDate(0, 0, 14), "")
+	require.NoError(t, err)
+	require.Equal(t, month, inv.From)
+	require.Equal(t, month.AddDate(0, 1, 0), inv.To)
+	require.Equal(t, []InvoiceLine{
+		{Parcel: plain, Kind: InvoiceLineShipping, Amount: 30000},
+		{Parcel: cod, Kind: InvoiceLineShipping, Amount: 25000},
+		{Parcel: cod, Kind: InvoiceLineCODFee, Amount: 2000},
+	}, inv.Lines)
+	require.Equal(t, int64(57000), inv.Total)
+
+	stored, err := store.GetInvoice(inv.ID)
+	require.NoError(t, err)
+	inv.CreatedAt = stored.CreatedAt
+	require.Equal(t, inv, stored)
+
+	_, err = store.GenerateInvoice(1000, month, "")
+	require.ErrorIs(t, err, ErrInvoiceExists)
+	_, err = store.GenerateInvoice(1001, month, "")
+	require.ErrorIs(t, err, ErrNothingToInvoice)
+
+	require.NoError(t, store.MarkInvoicePaid(inv.ID))
+	require.ErrorIs(t, store.MarkInvoicePaid(inv.ID), ErrInvoicePaid)
+	require.ErrorIs(t, store.MarkInvoicePaid(inv.ID+1), sql.ErrNoRows)
+
+	invoices, err := store.ListInvoices(1000)
+	require.NoError(t, err)
+	require.Len(t, invoices, 1)
+	require.False(t, invoices[0].PaidAt.IsZero())
+	require.Empty(t, invoices[0].Lines)
+}
+
+// TestInvoiceExport проверяет выгрузку счёта в CSV и PDF
+func TestInvoiceExport(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	p := getTestParcel()
+	p.Price = 30050
+	number, err := store.Add(p)
+	require.NoError(t, err)
+	inv, err := store.GenerateInvoice(p.Client, p.CreatedAt, "")
+	require.NoError(t, err)
+	handler := NewAdminHandler(store, NewErrorLog(10))
+
+	// csv
+	var buf bytes.Buffer
+	require.NoError(t, inv.WriteCSV(&buf))
+	from, to := inv.From.Format(dateLayout), inv.To.Format(dateLayout)
+	require.Equal(t, "invoice,client,from,to,parcel,kind,amount,currency\n"+
+		"1,1000,"+from+","+to+","+strconv.Itoa(number)+",shipping,300.50,RUB\n"+
+		"1,1000,"+from+","+to+",,total,300.50,RUB\n", buf.String())
+
+	// pdf
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/invoices/export?id=1&format=pdf", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/pdf", rec.Header().Get("Content-Type"))
+	require.True(t, bytes.HasPrefix(rec.Body.Bytes(), []byte("%PDF-1.4\n")))
+	require.True(t, bytes.HasSuffix(rec.Body.Bytes(), []byte("%%EOF\n")))
+	require.Contains(t, rec.Body.String(), "(Total                           300.50) '")
+
+	for _, c := range []struct {
+		target string
+		code   int
+	}{
+		{"/admin/invoices/export?id=1", http.StatusOK},
+		{"/admin/invoices/export?id=1&format=xls", http.StatusBadRequest},
+		{"/admin/invoices/export?id=2", http.StatusNotFound},
+		{"/admin/invoices?client=1000", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.target, nil))
+		require.Equal(t, c.code, rec.Code, c.target)
+	}
+}
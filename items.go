@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ItemWeightTolerance — допустимое отклонение суммарного веса позиций
+// вложения от заявленного веса посылки, доля заявленного веса
+const ItemWeightTolerance = 0.1
+
+var (
+	ErrInvalidItem    = errors.New("некорректная позиция вложения")
+	ErrWeightMismatch = errors.New("вес позиций вложения не совпадает с заявленным весом посылки")
+)
+
+// ParcelItem — позиция описи вложения посылки
+type ParcelItem struct {
+	ID       int    `json:"id"`
+	Parcel   int    `json:"parcel"`
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+	// UnitWeight — вес одной единицы в граммах
+	UnitWeight int64 `json:"unit_weight"`
+}
+
+// Validate проверяет заполненность полей позиции
+func (i ParcelItem) Validate() error {
+	switch {
+	case i.Name == "":
+		return fmt.Errorf("%w: пустое название", ErrInvalidItem)
+	case i.Quantity <= 0:
+		return fmt.Errorf("%w: количество должно быть положительным", ErrInvalidItem)
+	case i.UnitWeight <= 0:
+		return fmt.Errorf("%w: вес должен быть положительным", ErrInvalidItem)
+	}
+
+	return nil
+}
+
+// AddItem добавляет позицию в опись вложения посылки.
+// Менять опись можно только если значение статуса registered.
+func (s ParcelStore) AddItem(item ParcelItem) (int, error) {
+	if err := item.Validate(); err != nil {
+		return 0, err
+	}
+
+	res, err := s.db.Exec("INSERT INTO {parcel_item} (parcel, name, quantity, unit_weight) "+
+		"SELECT number, :name, :quantity, :unit_weight FROM {parcel} "+
+		"WHERE number = :parcel AND status = :status",
+		sql.Named("name", item.Name),
+		sql.Named("quantity", item.Quantity),
+		sql.Named("unit_weight", item.UnitWeight),
+		sql.Named("parcel", item.Parcel),
+		sql.Named("status", ParcelStatusRegistered))
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.checkRegisteredAffected(item.Parcel, res); err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetItems возвращает опись вложения посылки
+func (s ParcelStore) GetItems(number int) ([]ParcelItem, error) {
+	rows, err := s.db.Query("SELECT id, parcel, name, quantity, unit_weight "+
+		"FROM {parcel_item} WHERE parcel = :parcel ORDER BY id",
+		sql.Named("parcel", number))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []ParcelItem
+	for rows.Next() {
+		i := ParcelItem{}
+		if err := rows.Scan(&i.ID, &i.Parcel, &i.Name, &i.Quantity, &i.UnitWeight); err != nil {
+			return nil, err
+		}
+		res = append(res, i)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// DeleteItem удаляет позицию описи вложения посылки
+func (s ParcelStore) DeleteItem(number int, id int) error {
+	res, err := s.db.Exec("DELETE FROM {parcel_item} "+
+		"WHERE id = :id AND parcel IN (SELECT number FROM {parcel} WHERE number = :parcel AND status = :status)",
+		sql.Named("id", id),
+		sql.Named("parcel", number),
+		sql.Named("status", ParcelStatusRegistered))
+	if err != nil {
+		return err
+	}
+
+	return s.checkRegisteredAffected(number, res)
+}
+
+// CheckItemWeight сверяет суммарный вес описи с заявленным весом посылки
+// и возвращает ErrWeightMismatch, если отклонение больше
+// ItemWeightTolerance. Без описи или без заявленного веса сверять нечего.
+// Фоновая проверка согласованности находит такие посылки сама,
+// см. AnomalyWeightMismatch.
+func (s ParcelStore) CheckItemWeight(number int) error {
+	p, err := s.Get(number)
+	if err != nil {
+		return err
+	}
+
+	items, err := s.GetItems(number)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 || p.Weight == 0 {
+		return nil
+	}
+
+	var total int64
+	for _, i := range items {
+		total += int64(i.Quantity) * i.UnitWeight
+	}
+	if math.Abs(float64(total-p.Weight)) > float64(p.Weight)*ItemWeightTolerance {
+		return fmt.Errorf("%w: позиции %d г, заявлено %d г", ErrWeightMismatch, total, p.Weight)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestParcelItemCRUD проверяет добавление и удаление позиций описи вложения
+func TestParcelItemCRUD(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// add
+	item := ParcelItem{Parcel: number, Name: "книга", Quantity: 2, UnitWeight: 400}
+	item.ID, err = store.AddItem(item)
+	require.NoError(t, err)
+	require.NotZero(t, item.ID)
+
+	items, err := store.GetItems(number)
+	require.NoError(t, err)
+	require.Equal(t, []ParcelItem{item}, items)
+
+	// invalid
+	_, err = store.AddItem(ParcelItem{Parcel: number, Name: "книга", Quantity: 1})
+	require.ErrorIs(t, err, ErrInvalidItem)
+
+	// delete
+	require.NoError(t, store.DeleteItem(number, item.ID))
+	items, err = store.GetItems(number)
+	require.NoError(t, err)
+	require.Empty(t, items)
+	require.ErrorIs(t, store.DeleteItem(number, item.ID), sql.ErrNoRows)
+
+	// после отправки опись не меняется
+	require.NoError(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+	_, err = store.AddItem(item)
+	require.ErrorIs(t, err, ErrNotRegistered)
+}
+
+// TestCheckItemWeight проверяет сверку веса описи с заявленным весом
+// и пометку расхождения проверкой согласованности
+func TestCheckItemWeight(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+
+	declared := func() Parcel {
+		p := getTestParcel()
+		p.Weight = 1000
+		return p
+	}
+	match, err := store.Add(declared())
+	require.NoError(t, err)
+	mismatch, err := store.Add(declared())
+	require.NoError(t, err)
+	undeclared, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// 950 г укладываются в допуск, 1200 г — нет
+	_, err = store.AddItem(ParcelItem{Parcel: match, Name: "книга", Quantity: 1, UnitWeight: 950})
+	require.NoError(t, err)
+	_, err = store.AddItem(ParcelItem{Parcel: mismatch, Name: "книга", Quantity: 3, UnitWeight: 400})
+	require.NoError(t, err)
+	_, err = store.AddItem(ParcelItem{Parcel: undeclared, Name: "книга", Quantity: 3, UnitWeight: 400})
+	require.NoError(t, err)
+
+	// check
+	require.NoError(t, store.CheckItemWeight(match))
+	require.ErrorIs(t, store.CheckItemWeight(mismatch), ErrWeightMismatch)
+	require.NoError(t, store.CheckItemWeight(undeclared))
+
+	found, err := store.CheckConsistency()
+	require.NoError(t, err)
+	require.Equal(t, 1, found)
+
+	anomalies, err := store.ListAnomalies(false)
+	require.NoError(t, err)
+	require.Len(t, anomalies, 1)
+	require.Equal(t, mismatch, anomalies[0].Parcel)
+	require.Equal(t, AnomalyWeightMismatch, anomalies[0].Kind)
+	require.Equal(t, "позиции 1200 г, заявлено 1000 г", anomalies[0].Detail)
+
+	negative := getTestParcel()
+	negative.Weight = -1
+	_, err = store.Add(negative)
+	require.ErrorIs(t, err, ErrInvalidParcel)
+}
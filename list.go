@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSort = errors.New("некорректная сортировка")
+
+// sortableColumns — столбцы посылки, по которым разрешена сортировка.
+// В ORDER BY попадают только имена из этого списка, поэтому параметр
+// сортировки из запроса не может внедрить SQL.
+var sortableColumns = map[string]bool{
+	"number":        true,
+	"client":        true,
+	"status":        true,
+	"created_at":    true,
+	"sent_at":       true,
+	"delivered_at":  true,
+	"service_level": true,
+	"zone":          true,
+	"tenant":        true,
+	"price":         true,
+}
+
+// SortField — столбец сортировки выборки
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// ParseSort разбирает сортировку вида "status,-created_at": столбцы через
+// запятую, минус перед именем — по убыванию
+func ParseSort(s string) ([]SortField, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var res []SortField
+	for _, part := range strings.Split(s, ",") {
+		f := SortField{Column: strings.TrimSpace(part)}
+		if strings.HasPrefix(f.Column, "-") {
+			f.Column = f.Column[1:]
+			f.Desc = true
+		}
+		if !sortableColumns[f.Column] {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSort, part)
+		}
+		res = append(res, f)
+	}
+
+	return res, nil
+}
+
+// orderBy строит ORDER BY по полям сортировки. Номер посылки дописывается
+// последним, чтобы порядок строк с равными значениями был стабильным
+// между страницами.
+func orderBy(sort []SortField) (string, error) {
+	var terms []string
+	tiebreak := true
+	for _, f := range sort {
+		if !sortableColumns[f.Column] {
+			return "", fmt.Errorf("%w: %q", ErrInvalidSort, f.Column)
+		}
+		term := f.Column
+		if f.Desc {
+			term += " DESC"
+		}
+		terms = append(terms, term)
+		if f.Column == "number" {
+			tiebreak = false
+		}
+	}
+	if tiebreak {
+		terms = append(terms, "number")
+	}
+
+	return " ORDER BY " + strings.Join(terms, ", "), nil
+}
+
+// ListOptions задаёт страницу выборки посылок
+type ListOptions struct {
+	// Limit — размер страницы, 0 — без ограничения
+	Limit int
+	// Offset учитывается только вместе с Limit
+	Offset int
+	// Sort — порядок выборки, по умолчанию по номеру
+	Sort []SortField
+	// WithTotal — посчитать общее число подходящих посылок для пагинации
+	WithTotal bool
+}
+
+// ParcelPage — страница выборки посылок
+type ParcelPage struct {
+	Parcels []Parcel
+	// Total — число посылок под фильтром без учёта страницы,
+	// заполняется только при ListOptions.WithTotal
+	Total int
+}
+
+// Pages возвращает число страниц размера limit для Total посылок
+func (p ParcelPage) Pages(limit int) int {
+	if limit <= 0 {
+		if p.Total > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	return (p.Total + limit - 1) / limit
+}
+
+// List возвращает страницу посылок под фильтром в порядке opts.Sort.
+// Пустой фильтр выбирает все посылки. С WithTotal общее число считается
+// отдельным запросом COUNT по тому же фильтру.
+func (s ParcelStore) List(f ParcelFilter, opts ListOptions) (ParcelPage, error) {
+	order, err := orderBy(opts.Sort)
+	if err != nil {
+		return ParcelPage{}, err
+	}
+	where, args := f.where()
+
+	query := parcelSelect + where + order
+	pageArgs := append([]any{}, args...)
+	if opts.Limit > 0 {
+		query += " LIMIT :limit OFFSET :offset"
+		pageArgs = append(pageArgs,
+			sql.Named("limit", opts.Limit),
+			sql.Named("offset", opts.Offset))
+	}
+
+	rows, err := s.db.Query(query, pageArgs...)
+	if err != nil {
+		return ParcelPage{}, err
+	}
+
+	parcels, err := scanParcels(rows)
+	if err != nil {
+		return ParcelPage{}, err
+	}
+
+	page := ParcelPage{Parcels: parcels}
+	if opts.WithTotal {
+		row := s.db.QueryRow("SELECT COUNT(*) FROM {parcel}"+where, args...)
+		if err := row.Scan(&page.Total); err != nil {
+			return ParcelPage{}, err
+		}
+	}
+
+	return page, nil
+}
+
+// GetByClientPage — GetByClient со страницей и общим числом посылок клиента
+func (s ParcelStore) GetByClientPage(client int, opts ListOptions) (ParcelPage, error) {
+	return s.List(ParcelFilter{Client: client}, opts)
+}
+
+// ListUpdatedSince возвращает до limit посылок, изменённых после позиции
+// (since, after), в порядке изменения. Позиция — время изменения и номер
+// последней полученной посылки: посылки, изменённые в ту же миллисекунду,
+// не теряются и не повторяются. Первый запрос передаёт нулевые since и after.
+func (s ParcelStore) ListUpdatedSince(since time.Time, after int, limit int) ([]Parcel, error) {
+	rows, err := s.db.Query(parcelSelect+
+		"WHERE updated_at > :since OR (updated_at = :since AND number > :after) "+
+		"ORDER BY updated_at, number LIMIT :limit",
+		sql.Named("since", formatTime(since)),
+		sql.Named("after", after),
+		sql.Named("limit", limit))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// updatedPosition возвращает позицию ListUpdatedSince, после которой
+// идут n последних изменённых посылок, или нулевую, если посылок не больше n
+func (s ParcelStore) updatedPosition(ctx context.Context, n int) (time.Time, int, error) {
+	var since time.Time
+	var number int
+	err := s.db.QueryRowContext(ctx, "SELECT updated_at, number FROM {parcel} "+
+		"ORDER BY updated_at DESC, number DESC LIMIT 1 OFFSET :offset",
+		sql.Named("offset", n-1)).Scan(scanTime(&since), &number)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, 0, nil
+	}
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	// позиция исключает саму посылку, поэтому отступаем на номер назад
+	return since, number - 1, nil
+}
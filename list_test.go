@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestListWithTotal проверяет страницу выборки и общее число посылок
+func TestListWithTotal(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+
+	var numbers []int
+	for i := 0; i < 5; i++ {
+		number, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		numbers = append(numbers, number)
+	}
+	other := getTestParcel()
+	other.Client = 2000
+	_, err := store.Add(other)
+	require.NoError(t, err)
+
+	// list
+	page, err := store.GetByClientPage(1000, ListOptions{Limit: 2, Offset: 2, WithTotal: true})
+	require.NoError(t, err)
+
+	// check
+	require.Len(t, page.Parcels, 2)
+	require.Equal(t, numbers[2], page.Parcels[0].Number)
+	require.Equal(t, numbers[3], page.Parcels[1].Number)
+	require.Equal(t, 5, page.Total)
+	require.Equal(t, 3, page.Pages(2))
+
+	// без WithTotal общее число не считается
+	page, err = store.List(ParcelFilter{}, ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Parcels, 6)
+	require.Zero(t, page.Total)
+}
+
+// TestListSort проверяет сортировку по нескольким столбцам с номером в конце
+func TestListSort(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+
+	base := getTestParcel().CreatedAt
+	var numbers []int
+	for i, status := range []string{ParcelStatusSent, ParcelStatusRegistered, ParcelStatusSent, ParcelStatusRegistered} {
+		parcel := getTestParcel()
+		// у первых двух посылок одинаковое время регистрации
+		parcel.CreatedAt = base.Add(time.Duration(i/2) * time.Minute)
+		number, err := store.Add(parcel)
+		require.NoError(t, err)
+		require.NoError(t, store.SetStatus(number, status))
+		numbers = append(numbers, number)
+	}
+
+	// list
+	sort, err := ParseSort("status, -created_at")
+	require.NoError(t, err)
+	page, err := store.List(ParcelFilter{}, ListOptions{Sort: sort})
+	require.NoError(t, err)
+
+	// check
+	var got []int
+	for _, p := range page.Parcels {
+		got = append(got, p.Number)
+	}
+	require.Equal(t, []int{numbers[3], numbers[1], numbers[2], numbers[0]}, got)
+
+	page, err = store.List(ParcelFilter{}, ListOptions{Sort: []SortField{{Column: "created_at"}}})
+	require.NoError(t, err)
+	require.Equal(t, numbers[0], page.Parcels[0].Number)
+	require.Equal(t, numbers[1], page.Parcels[1].Number)
+
+	// столбцы вне списка разрешённых отклоняются
+	_, err = ParseSort("status;DROP TABLE parcel")
+	require.ErrorIs(t, err, ErrInvalidSort)
+	_, err = store.List(ParcelFilter{}, ListOptions{Sort: []SortField{{Column: "address"}}})
+	require.ErrorIs(t, err, ErrInvalidSort)
+}
+
+// TestListUpdatedSince проверяет выборку изменённых посылок по позиции
+func TestListUpdatedSince(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+
+	var numbers []int
+	for i := 0; i < 3; i++ {
+		number, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		numbers = append(numbers, number)
+	}
+
+	// первый проход порциями по две посылки
+	parcels, err := store.ListUpdatedSince(time.Time{}, 0, 2)
+	require.NoError(t, err)
+	require.Len(t, parcels, 2)
+	last := parcels[1]
+	parcels, err = store.ListUpdatedSince(last.UpdatedAt, last.Number, 2)
+	require.NoError(t, err)
+	require.Len(t, parcels, 1)
+	last = parcels[0]
+
+	// время хранится с точностью до миллисекунды
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, store.SetAddress(numbers[0], "new"))
+
+	// check
+	parcels, err = store.ListUpdatedSince(last.UpdatedAt, last.Number, 10)
+	require.NoError(t, err)
+	require.Len(t, parcels, 1)
+	require.Equal(t, numbers[0], parcels[0].Number)
+	require.True(t, parcels[0].UpdatedAt.After(last.UpdatedAt))
+}
+
+// FuzzParseSort проверяет, что в ORDER BY попадают только столбцы из
+// sortableColumns, что бы ни пришло в параметре сортировки
+func FuzzParseSort(f *testing.F) {
+	f.Add("status,-created_at")
+	f.Add("-number")
+	f.Add("created_at; DROP TABLE parcel")
+	f.Add("--status,,")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		sort, err := ParseSort(s)
+		if err != nil {
+			require.ErrorIs(t, err, ErrInvalidSort)
+			return
+		}
+
+		for _, field := range sort {
+			require.True(t, sortableColumns[field.Column], field.Column)
+		}
+		_, err = orderBy(sort)
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	ErrInvalidVehicle     = errors.New("некорректное транспортное средство")
+	ErrNoVehicles         = errors.New("не задано ни одного транспортного средства")
+	ErrLoadPlanNotFound   = errors.New("план загрузки не найден")
+	ErrInvalidLoadRequest = errors.New("некорректный запрос плана загрузки")
+)
+
+// Vehicle — тип транспортного средства доставки с вместимостью.
+// Нулевая граница вместимости не ограничивает.
+type Vehicle struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// MaxWeight — допустимый вес груза в граммах
+	MaxWeight int64 `json:"max_weight"`
+	// MaxParcels — сколько посылок помещается за рейс
+	MaxParcels int `json:"max_parcels"`
+}
+
+// Validate проверяет транспортное средство перед сохранением
+func (v Vehicle) Validate() error {
+	switch {
+	case v.Name == "":
+		return fmt.Errorf("%w: не указано название", ErrInvalidVehicle)
+	case v.MaxWeight < 0 || v.MaxParcels < 0:
+		return fmt.Errorf("%w: отрицательная вместимость", ErrInvalidVehicle)
+	case v.MaxWeight == 0 && v.MaxParcels == 0:
+		return fmt.Errorf("%w: не указана вместимость", ErrInvalidVehicle)
+	}
+
+	return nil
+}
+
+// fits сообщает, помещается ли груз весом weight из parcels посылок
+func (v Vehicle) fits(weight int64, parcels int) bool {
+	return (v.MaxWeight == 0 || weight <= v.MaxWeight) && (v.MaxParcels == 0 || parcels <= v.MaxParcels)
+}
+
+// Load — один рейс транспортного средства
+type Load struct {
+	Vehicle int `json:"vehicle"`
+	// Weight — суммарный заявленный вес посылок в граммах
+	Weight  int64 `json:"weight"`
+	Parcels []int `json:"parcels"`
+}
+
+// LoadPlan — разбиение посылок маршрута на рейсы
+type LoadPlan struct {
+	ID      int    `json:"id"`
+	Courier int    `json:"courier"`
+	Date    string `json:"date"`
+	Loads   []Load `json:"loads"`
+	// Unplanned — посылки, которые не помещаются ни в одно транспортное средство
+	Unplanned []int     `json:"unplanned"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AddVehicle добавляет тип транспортного средства и возвращает его номер
+func (s ParcelStore) AddVehicle(v Vehicle) (int, error) {
+	if err := v.Validate(); err != nil {
+		return 0, err
+	}
+
+	res, err := s.db.Exec("INSERT INTO {vehicle} (name, max_weight, max_parcels) VALUES (:name, :max_weight, :max_parcels)",
+		sql.Named("name", v.Name),
+		sql.Named("max_weight", v.MaxWeight),
+		sql.Named("max_parcels", v.MaxParcels))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+
+	return int(id), err
+}
+
+// ListVehicles возвращает типы транспортных средств в порядке добавления
+func (s ParcelStore) ListVehicles() ([]Vehicle, error) {
+	rows, err := s.db.Query("SELECT id, name, max_weight, max_parcels FROM {vehicle} ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Vehicle
+	for rows.Next() {
+		v := Vehicle{}
+		if err := rows.Scan(&v.ID, &v.Name, &v.MaxWeight, &v.MaxParcels); err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// PlanLoads разбивает посылки маршрута курьера courier на день date на
+// рейсы по вместимости транспортных средств и сохраняет план. Если
+// parcels пуст, берутся посылки маршрута ListDeliveryRoute. Посылки без
+// заявленного веса учитываются только по числу.
+func (s ParcelStore) PlanLoads(courier int, date time.Time, parcels []int) (LoadPlan, error) {
+	if courier <= 0 || date.IsZero() {
+		return LoadPlan{}, ErrInvalidLoadRequest
+	}
+	vehicles, err := s.ListVehicles()
+	if err != nil {
+		return LoadPlan{}, err
+	}
+	if len(vehicles) == 0 {
+		return LoadPlan{}, ErrNoVehicles
+	}
+
+	if len(parcels) == 0 {
+		route, err := s.ListDeliveryRoute(courier, date)
+		if err != nil {
+			return LoadPlan{}, err
+		}
+		for _, st := range route.Stops {
+			parcels = append(parcels, st.Number)
+		}
+	}
+
+	weights := make(map[int]int64, len(parcels))
+	for _, number := range parcels {
+		p, err := s.Get(number)
+		if err != nil {
+			return LoadPlan{}, fmt.Errorf("посылка № %d: %w", number, err)
+		}
+		weights[number] = p.Weight
+	}
+
+	plan := planLoads(parcels, weights, vehicles)
+	plan.Courier = courier
+	plan.Date = date.UTC().Format(dateLayout)
+	plan.CreatedAt = s.now().UTC()
+	if err := s.saveLoadPlan(&plan); err != nil {
+		return LoadPlan{}, err
+	}
+
+	return plan, nil
+}
+
+// planLoads раскладывает посылки по рейсам методом «первый подходящий по
+// убыванию веса»: самая тяжёлая из оставшихся посылок кладётся в первый
+// рейс, где для неё есть место, иначе открывает новый рейс самого
+// вместительного транспорта. Затем каждому рейсу подбирается наименее
+// вместительное транспортное средство, в которое он помещается.
+func planLoads(parcels []int, weights map[int]int64, vehicles []Vehicle) LoadPlan {
+	order := append([]int(nil), parcels...)
+	sort.SliceStable(order, func(i, j int) bool { return weights[order[i]] > weights[order[j]] })
+
+	// от большей вместимости к меньшей; без ограничения — больше любой
+	byCapacity := append([]Vehicle(nil), vehicles...)
+	capacity := func(v Vehicle) (int64, int) {
+		w, n := v.MaxWeight, v.MaxParcels
+		if w == 0 {
+			w = 1<<63 - 1
+		}
+		if n == 0 {
+			n = 1<<31 - 1
+		}
+		return w, n
+	}
+	sort.SliceStable(byCapacity, func(i, j int) bool {
+		wi, ni := capacity(byCapacity[i])
+		wj, nj := capacity(byCapacity[j])
+		if wi != wj {
+			return wi > wj
+		}
+		return ni > nj
+	})
+
+	plan := LoadPlan{Loads: []Load{}, Unplanned: []int{}}
+	for _, number := range order {
+		w := weights[number]
+		placed := false
+		for i := range plan.Loads {
+			l := &plan.Loads[i]
+			if vehicleByID(byCapacity, l.Vehicle).fits(l.Weight+w, len(l.Parcels)+1) {
+				l.Weight += w
+				l.Parcels = append(l.Parcels, number)
+				placed = true
+				break
+			}
+		}
+		if placed {
+			continue
+		}
+
+		opened := false
+		for _, v := range byCapacity {
+			if v.fits(w, 1) {
+				plan.Loads = append(plan.Loads, Load{Vehicle: v.ID, Weight: w, Parcels: []int{number}})
+				opened = true
+				break
+			}
+		}
+		if !opened {
+			plan.Unplanned = append(plan.Unplanned, number)
+		}
+	}
+
+	for i := range plan.Loads {
+		l := &plan.Loads[i]
+		for j := len(byCapacity) - 1; j >= 0; j-- {
+			if byCapacity[j].fits(l.Weight, len(l.Parcels)) {
+				l.Vehicle = byCapacity[j].ID
+				break
+			}
+		}
+	}
+
+	return plan
+}
+
+// vehicleByID возвращает транспортное средство id из vehicles
+func vehicleByID(vehicles []Vehicle, id int) Vehicle {
+	for _, v := range vehicles {
+		if v.ID == id {
+			return v
+		}
+	}
+
+	return Vehicle{}
+}
+
+// saveLoadPlan сохраняет план в одной транзакции и проставляет его номер
+func (s ParcelStore) saveLoadPlan(plan *LoadPlan) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO {load_plan} (courier, day, created_at) VALUES (:courier, :day, :created_at)",
+		sql.Named("courier", plan.Courier),
+		sql.Named("day", plan.Date),
+		sql.Named("created_at", formatTime(plan.CreatedAt)))
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	plan.ID = int(id)
+
+	insert := func(load int, vehicle int, parcel int) error {
+		_, err := tx.Exec("INSERT INTO {load_plan_parcel} (plan, load_no, vehicle, parcel) VALUES (:plan, :load_no, :vehicle, :parcel)",
+			sql.Named("plan", plan.ID),
+			sql.Named("load_no", load),
+			sql.Named("vehicle", vehicle),
+			sql.Named("parcel", parcel))
+		return err
+	}
+	for i, l := range plan.Loads {
+		for _, number := range l.Parcels {
+			if err := insert(i+1, l.Vehicle, number); err != nil {
+				return err
+			}
+		}
+	}
+	// нераспределённые посылки хранятся с нулевым рейсом
+	for _, number := range plan.Unplanned {
+		if err := insert(0, 0, number); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetLoadPlan возвращает сохранённый план загрузки id. Посылки, удалённые
+// после планирования, из плана пропадают.
+func (s ParcelStore) GetLoadPlan(id int) (LoadPlan, error) {
+	plan := LoadPlan{ID: id, Loads: []Load{}, Unplanned: []int{}}
+	err := s.db.QueryRow("SELECT courier, day, created_at FROM {load_plan} WHERE id = :id", sql.Named("id", id)).
+		Scan(&plan.Courier, &plan.Date, scanTime(&plan.CreatedAt))
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoadPlan{}, ErrLoadPlanNotFound
+	}
+	if err != nil {
+		return LoadPlan{}, err
+	}
+
+	rows, err := s.db.Query("SELECT i.load_no, i.vehicle, i.parcel, p.weight FROM {load_plan_parcel} i "+
+		"JOIN {parcel} p ON p.number = i.parcel WHERE i.plan = :plan ORDER BY i.load_no, i.rowid",
+		sql.Named("plan", id))
+	if err != nil {
+		return LoadPlan{}, err
+	}
+	defer rows.Close()
+
+	last := 0
+	for rows.Next() {
+		var load, vehicle, parcel int
+		var weight int64
+		if err := rows.Scan(&load, &vehicle, &parcel, &weight); err != nil {
+			return LoadPlan{}, err
+		}
+		if load == 0 {
+			plan.Unplanned = append(plan.Unplanned, parcel)
+			continue
+		}
+		if load != last {
+			plan.Loads = append(plan.Loads, Load{Vehicle: vehicle})
+			last = load
+		}
+		l := &plan.Loads[len(plan.Loads)-1]
+		l.Weight += weight
+		l.Parcels = append(l.Parcels, parcel)
+	}
+
+	if err := rows.Err(); err != nil {
+		return LoadPlan{}, err
+	}
+
+	return plan, nil
+}
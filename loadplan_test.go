@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestPlanLoadsSplit проверяет разбиение посылок на рейсы по весу и числу
+// посылок и подбор наименее вместительного транспорта для рейса
+func TestPlanLoadsSplit(t *testing.T) {
+	vehicles := []Vehicle{
+		{ID: 1, Name: "фургон", MaxWeight: 10000, MaxParcels: 3},
+		{ID: 2, Name: "велосипед", MaxWeight: 3000},
+	}
+	weights := map[int]int64{1: 6000, 2: 5000, 3: 4000, 4: 1000, 5: 20000, 6: 500}
+
+	plan := planLoads([]int{1, 2, 3, 4, 5, 6}, weights, vehicles)
+	require.Equal(t, []int{5}, plan.Unplanned)
+	require.Equal(t, []Load{
+		{Vehicle: 1, Weight: 10000, Parcels: []int{1, 3}},
+		{Vehicle: 1, Weight: 6500, Parcels: []int{2, 4, 6}},
+	}, plan.Loads)
+
+	plan = planLoads([]int{4, 6}, weights, vehicles)
+	require.Empty(t, plan.Unplanned)
+	require.Equal(t, []Load{{Vehicle: 2, Weight: 1500, Parcels: []int{4, 6}}}, plan.Loads)
+}
+
+// TestPlanLoads проверяет планирование рейсов по маршруту курьера
+// и чтение сохранённого плана
+func TestPlanLoads(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	_, err := store.PlanLoads(7, day, nil)
+	require.ErrorIs(t, err, ErrNoVehicles)
+	_, err = store.AddVehicle(Vehicle{Name: "фургон"})
+	require.ErrorIs(t, err, ErrInvalidVehicle)
+	van, err := store.AddVehicle(Vehicle{Name: "фургон", MaxWeight: 5000})
+	require.NoError(t, err)
+
+	var numbers []int
+	for _, weight := range []int64{3000, 2500, 9000} {
+		p := getTestParcel()
+		p.Weight = weight
+		number, err := store.Add(p)
+		require.NoError(t, err)
+		require.NoError(t, store.AssignCourier(Assignment{Parcel: number, Courier: 7, Date: day}))
+		numbers = append(numbers, number)
+	}
+
+	// check
+	plan, err := store.PlanLoads(7, day, nil)
+	require.NoError(t, err)
+	require.Equal(t, "2024-03-01", plan.Date)
+	require.Equal(t, []int{numbers[2]}, plan.Unplanned)
+	require.Equal(t, []Load{
+		{Vehicle: van, Weight: 3000, Parcels: []int{numbers[0]}},
+		{Vehicle: van, Weight: 2500, Parcels: []int{numbers[1]}},
+	}, plan.Loads)
+
+	got, err := store.GetLoadPlan(plan.ID)
+	require.NoError(t, err)
+	require.Equal(t, plan.Loads, got.Loads)
+	require.Equal(t, plan.Unplanned, got.Unplanned)
+	require.Equal(t, 7, got.Courier)
+	require.True(t, plan.CreatedAt.Truncate(time.Millisecond).Equal(got.CreatedAt))
+
+	require.NoError(t, store.Delete(numbers[0]))
+	got, err = store.GetLoadPlan(plan.ID)
+	require.NoError(t, err)
+	require.Equal(t, []Load{{Vehicle: van, Weight: 2500, Parcels: []int{numbers[1]}}}, got.Loads)
+
+	_, err = store.GetLoadPlan(plan.ID + 1)
+	require.ErrorIs(t, err, ErrLoadPlanNotFound)
+}
+
+// TestLoadPlansHandler проверяет транспорт и планы загрузки через админку
+func TestLoadPlansHandler(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	handler := NewAdminHandler(store, NewErrorLog(10))
+	p := getTestParcel()
+	p.Weight = 1200
+	number, err := store.Add(p)
+	require.NoError(t, err)
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	plan := url.Values{"courier": {"3"}, "date": {"2024-03-01"}, "parcels": {strconv.Itoa(number)}}
+
+	// check
+	require.Equal(t, http.StatusConflict, post("/admin/load-plans", plan).Code)
+	require.Equal(t, http.StatusBadRequest, post("/admin/vehicles", url.Values{"name": {"фургон"}, "max_weight": {"-1"}}).Code)
+	require.Equal(t, http.StatusCreated, post("/admin/vehicles", url.Values{"name": {"фургон"}, "max_parcels": {"10"}}).Code)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/vehicles", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"max_parcels":10`)
+
+	require.Equal(t, http.StatusBadRequest, post("/admin/load-plans", url.Values{"courier": {"3"}, "date": {"1 марта"}}).Code)
+	require.Equal(t, http.StatusNotFound, post("/admin/load-plans", url.Values{"courier": {"3"}, "date": {"2024-03-01"}, "parcels": {"100000"}}).Code)
+	rec = post("/admin/load-plans", plan)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created LoadPlan
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.Len(t, created.Loads, 1)
+	require.Equal(t, int64(1200), created.Loads[0].Weight)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/load-plans?id="+strconv.Itoa(created.ID), nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/load-plans?id=100000", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
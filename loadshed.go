@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// shedWindow — по скольким последним запросам к БД оценивается нагрузка
+	shedWindow = 200
+	// shedMinSamples — сколько запросов нужно для решения, чтобы единичный
+	// медленный запрос после запуска не включал сброс нагрузки
+	shedMinSamples = 20
+	// DefaultShedCooldown — сколько длится сброс нагрузки после превышения порогов
+	DefaultShedCooldown = 30 * time.Second
+)
+
+// LoadShedder следит за задержкой и долей ошибок запросов к БД. Когда
+// средняя задержка или доля ошибок за последние shedWindow запросов
+// превышает порог, он на Cooldown включает сброс нагрузки: второстепенные
+// операции вроде выгрузок и списков отклоняются, а получение посылки и
+// смена статуса продолжают работать. Безопасен для нескольких горутин.
+type LoadShedder struct {
+	// MaxLatency — порог средней задержки, 0 — задержка не учитывается
+	MaxLatency time.Duration
+	// MaxErrorRate — порог доли ошибок от 0 до 1, 0 — ошибки не учитываются
+	MaxErrorRate float64
+	Cooldown     time.Duration
+
+	mu      sync.Mutex
+	samples [shedWindow]shedSample
+	count   int
+	next    int
+	total   time.Duration
+	failed  int
+	until   time.Time
+}
+
+// shedSample — один запрос к БД
+type shedSample struct {
+	latency time.Duration
+	failed  bool
+}
+
+// NewLoadShedder возвращает LoadShedder с порогами и DefaultShedCooldown
+func NewLoadShedder(maxLatency time.Duration, maxErrorRate float64) *LoadShedder {
+	return &LoadShedder{MaxLatency: maxLatency, MaxErrorRate: maxErrorRate, Cooldown: DefaultShedCooldown}
+}
+
+// Observe учитывает запрос к БД. Отмена запроса клиентом ошибкой БД не считается.
+func (l *LoadShedder) Observe(latency time.Duration, err error) {
+	failed := err != nil && !errors.Is(err, context.Canceled)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.count == shedWindow {
+		old := l.samples[l.next]
+		l.total -= old.latency
+		if old.failed {
+			l.failed--
+		}
+	} else {
+		l.count++
+	}
+	l.samples[l.next] = shedSample{latency: latency, failed: failed}
+	l.next = (l.next + 1) % shedWindow
+	l.total += latency
+	if failed {
+		l.failed++
+	}
+
+	if l.count >= shedMinSamples && l.overloaded() {
+		l.until = time.Now().Add(l.Cooldown)
+	}
+}
+
+// overloaded сообщает о превышении порогов; вызывается под l.mu
+func (l *LoadShedder) overloaded() bool {
+	if l.MaxLatency > 0 && l.total/time.Duration(l.count) > l.MaxLatency {
+		return true
+	}
+
+	return l.MaxErrorRate > 0 && float64(l.failed)/float64(l.count) > l.MaxErrorRate
+}
+
+// Shedding сообщает, что второстепенные операции сейчас нужно отклонять
+func (l *LoadShedder) Shedding() bool {
+	if l == nil {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return time.Now().Before(l.until)
+}
+
+// retryAfter возвращает, через сколько секунд сброс нагрузки закончится
+func (l *LoadShedder) retryAfter() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(time.Until(l.until)/time.Second) + 1
+}
+
+// observe учитывает запрос к БД, начатый в start, если задан LoadShedder
+func (d storeDB) observe(start time.Time, err error) {
+	if d.shedder != nil {
+		d.shedder.Observe(time.Since(start), err)
+	}
+}
+
+// lowPriority отклоняет запрос с 503 на время сброса нагрузки.
+// Им оборачиваются выгрузки и списки, без которых отслеживание работает.
+func (h AdminHandler) lowPriority(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s := h.store.shedder; s.Shedding() {
+			w.Header().Set("Retry-After", strconv.Itoa(s.retryAfter()))
+			http.Error(w, "сервис перегружен, повторите позже", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadShedder проверяет включение сброса нагрузки по порогам
+func TestLoadShedder(t *testing.T) {
+	l := NewLoadShedder(100*time.Millisecond, 0.5)
+
+	// до shedMinSamples запросов решение не принимается
+	for i := 0; i < shedMinSamples-1; i++ {
+		l.Observe(time.Second, nil)
+	}
+	require.False(t, l.Shedding())
+	l.Observe(time.Second, nil)
+	require.True(t, l.Shedding())
+
+	// доля ошибок
+	l = NewLoadShedder(0, 0.5)
+	for i := 0; i < shedMinSamples; i++ {
+		l.Observe(time.Millisecond, context.Canceled)
+	}
+	require.False(t, l.Shedding())
+	for i := 0; i < shedMinSamples*2; i++ {
+		l.Observe(time.Millisecond, errors.New("database is locked"))
+	}
+	require.True(t, l.Shedding())
+
+	// без порогов сброса нет, как и без LoadShedder
+	l = NewLoadShedder(0, 0)
+	for i := 0; i < shedWindow*2; i++ {
+		l.Observe(time.Hour, errors.New("database is locked"))
+	}
+	require.False(t, l.Shedding())
+	require.False(t, (*LoadShedder)(nil).Shedding())
+}
+
+// TestLoadShedderCooldown проверяет окончание сброса нагрузки
+func TestLoadShedderCooldown(t *testing.T) {
+	l := NewLoadShedder(100*time.Millisecond, 0)
+	l.Cooldown = 10 * time.Millisecond
+	for i := 0; i < shedMinSamples; i++ {
+		l.Observe(time.Second, nil)
+	}
+	require.True(t, l.Shedding())
+
+	// быстрые запросы вытесняют медленные из окна
+	for i := 0; i < shedWindow; i++ {
+		l.Observe(time.Millisecond, nil)
+	}
+	time.Sleep(20 * time.Millisecond)
+	require.False(t, l.Shedding())
+}
+
+// TestLowPriorityShedding проверяет, что при перегрузке отклоняются
+// только второстепенные эндпоинты
+func TestLowPriorityShedding(t *testing.T) {
+	// prepare
+	shedder := NewLoadShedder(time.Nanosecond, 0)
+	store := NewParcelStore(openTestDB(t), WithLoadShedder(shedder))
+	parcel := getTestParcel()
+	_, err := store.Add(parcel)
+	require.NoError(t, err)
+	handler := NewHTTPHandler(store, NewErrorLog(10))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// запросы к БД медленнее порога
+	for i := 0; i < shedMinSamples; i++ {
+		_, err := store.Get(1)
+		require.NoError(t, err)
+	}
+	require.True(t, shedder.Shedding())
+
+	// check
+	rec := get("/admin/overdue")
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.NotEmpty(t, rec.Header().Get("Retry-After"))
+	require.Equal(t, http.StatusServiceUnavailable, get("/admin/audit").Code)
+	require.Equal(t, http.StatusOK, get("/track/"+parcel.UUID).Code)
+	require.Equal(t, http.StatusOK, get("/admin/stats").Code)
+}
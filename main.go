@@ -1,25 +1,100 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/carriers"
+	"github.com/Yandex-Practicum/go-db-sql-final/serverapp"
 )
 
 const (
 	ParcelStatusRegistered = "registered"
 	ParcelStatusSent       = "sent"
 	ParcelStatusDelivered  = "delivered"
+	ParcelStatusLost       = "lost"
+	ParcelStatusDamaged    = "damaged"
+	// ParcelStatusReturned — посылку не забрали из пункта выдачи в срок
+	ParcelStatusReturned = "returned"
+	// ParcelStatusCancelled — отправитель отменил посылку до отправки
+	ParcelStatusCancelled = "cancelled"
 )
 
 type Parcel struct {
-	Number    int
-	Client    int
-	Status    string
-	Address   string
-	CreatedAt string
+	// Number — внутренний номер посылки, наружу не передаётся,
+	// чтобы по нему нельзя было угадать соседние посылки
+	Number int `json:"-"`
+	// UUID — публичный идентификатор посылки, назначается при добавлении
+	UUID         string    `json:"uuid"`
+	Client       int       `json:"client"`
+	Status       string    `json:"status"`
+	Address      string    `json:"address"`
+	CreatedAt    time.Time `json:"created_at"`
+	ServiceLevel string    `json:"service_level"`
+	// CODAmount — сумма наложенного платежа в копейках, 0 — без наложенного платежа
+	CODAmount    int64 `json:"cod_amount"`
+	CODCollected bool  `json:"cod_collected"`
+	// Country — код страны назначения по ISO 3166-1 alpha-2
+	Country    string `json:"country"`
+	PostalCode string `json:"postal_code"`
+	// Zone — зона доставки, определяется по стране и индексу при добавлении
+	Zone string `json:"zone"`
+	// Insured — посылка застрахована на объявленную ценность DeclaredValue,
+	// премия InsurancePremium рассчитывается при добавлении; суммы в копейках
+	Insured          bool  `json:"insured"`
+	DeclaredValue    int64 `json:"declared_value"`
+	InsurancePremium int64 `json:"insurance_premium"`
+	// SentAt и DeliveredAt проставляются при переходе в статусы sent и delivered,
+	// до этого нулевые
+	SentAt      time.Time `json:"sent_at"`
+	DeliveredAt time.Time `json:"delivered_at"`
+	// SenderEmail — адрес отправителя для квитанции о регистрации, пустой — без квитанции
+	SenderEmail string `json:"sender_email"`
+	// Tenant — арендатор, от имени которого зарегистрирована посылка
+	Tenant string `json:"tenant"`
+	// Price — стоимость доставки без страховой премии и скидки
+	Price int64 `json:"price"`
+	// Currency — валюта всех сумм посылки по ISO 4217, суммы хранятся
+	// в её младших единицах; пустая при добавлении — DefaultCurrency
+	Currency string `json:"currency"`
+	// Locale — язык уведомлений клиента, пустой — DefaultLocale
+	Locale string `json:"locale"`
+	// OrderID — заказ, в который входит посылка, 0 — вне заказа
+	OrderID int `json:"order_id,omitempty"`
+	// PickupPoint — пункт выдачи или постамат назначения, 0 — доставка по адресу
+	PickupPoint int `json:"pickup_point,omitempty"`
+	// UpdatedAt — время последнего изменения, его ведёт БД; при добавлении не учитывается
+	UpdatedAt time.Time `json:"updated_at"`
+	// Metadata — поля интегратора без изменения схемы, см. SetMetadata
+	Metadata Metadata `json:"metadata,omitempty"`
+	// RecipientPhone — телефон получателя в формате E.164 для кодов
+	// подтверждения, пустой — подтверждение по телефону недоступно
+	RecipientPhone string `json:"recipient_phone,omitempty"`
+	// Weight — заявленный отправителем вес в граммах, 0 — не заявлен
+	Weight int64 `json:"weight,omitempty"`
+	// Contents — категории вложения, проверяются по запретам зоны доставки
+	Contents Contents `json:"contents,omitempty"`
+	// PromoCode — промокод клиента; после добавления остаётся, только если
+	// скидка по нему применена. Discount — скидка на стоимость доставки
+	// в копейках, рассчитывается при добавлении.
+	PromoCode string `json:"promo_code,omitempty"`
+	Discount  int64  `json:"discount,omitempty"`
+	// RequiresRefrigeration — посылку везут с холодовой цепью, см.
+	// AddTemperatureReading
+	RequiresRefrigeration bool `json:"requires_refrigeration,omitempty"`
+	// AgeVerification — вручить посылку можно только после проверки
+	// документа получателя, например с алкоголем, см. ConfirmDelivery
+	AgeVerification bool `json:"age_verification,omitempty"`
 }
 
 type ParcelService struct {
@@ -31,12 +106,22 @@ func NewParcelService(store ParcelStore) ParcelService {
 }
 
 func (s ParcelService) Register(client int, address string) (Parcel, error) {
-	parcel := Parcel{
-		Client:    client,
-		Status:    ParcelStatusRegistered,
-		Address:   address,
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
-	}
+	return s.RegisterWithLevel(client, address, ServiceLevelStandard)
+}
+
+func (s ParcelService) RegisterWithLevel(client int, address string, level string) (Parcel, error) {
+	return s.RegisterParcel(Parcel{
+		Client:       client,
+		Address:      address,
+		ServiceLevel: level,
+	})
+}
+
+// RegisterParcel регистрирует посылку с заполненными вызывающим полями,
+// статус и время регистрации проставляются здесь
+func (s ParcelService) RegisterParcel(parcel Parcel) (Parcel, error) {
+	parcel.Status = ParcelStatusRegistered
+	parcel.CreatedAt = s.store.now().UTC()
 
 	id, err := s.store.Add(parcel)
 	if err != nil {
@@ -46,7 +131,7 @@ func (s ParcelService) Register(client int, address string) (Parcel, error) {
 	parcel.Number = id
 
 	fmt.Printf("Новая посылка № %d на адрес %s от клиента с идентификатором %d зарегистрирована %s\n",
-		parcel.Number, parcel.Address, parcel.Client, parcel.CreatedAt)
+		parcel.Number, parcel.Address, parcel.Client, parcel.CreatedAt.Format(time.RFC3339))
 
 	return parcel, nil
 }
@@ -60,7 +145,7 @@ func (s ParcelService) PrintClientParcels(client int) error {
 	fmt.Printf("Посылки клиента %d:\n", client)
 	for _, parcel := range parcels {
 		fmt.Printf("Посылка № %d на адрес %s от клиента с идентификатором %d зарегистрирована %s, статус %s\n",
-			parcel.Number, parcel.Address, parcel.Client, parcel.CreatedAt, parcel.Status)
+			parcel.Number, parcel.Address, parcel.Client, parcel.CreatedAt.Format(time.RFC3339), parcel.Status)
 	}
 	fmt.Println()
 
@@ -73,19 +158,24 @@ func (s ParcelService) NextStatus(number int) error {
 		return err
 	}
 
-	var nextStatus string
-	switch parcel.Status {
-	case ParcelStatusRegistered:
-		nextStatus = ParcelStatusSent
-	case ParcelStatusSent:
-		nextStatus = ParcelStatusDelivered
-	case ParcelStatusDelivered:
+	graph, err := s.store.StatusGraph(parcel.Tenant)
+	if err != nil {
+		return err
+	}
+	nextStatus, ok := graph.Next(parcel.Status)
+	if !ok {
+		// конечный статус
 		return nil
 	}
 
+	err = s.store.TransitionStatus(number, parcel.Status, nextStatus)
+	if err != nil {
+		return err
+	}
+
 	fmt.Printf("У посылки № %d новый статус: %s\n", number, nextStatus)
 
-	return s.store.SetStatus(number, nextStatus)
+	return nil
 }
 
 func (s ParcelService) ChangeAddress(number int, address string) error {
@@ -96,10 +186,211 @@ func (s ParcelService) Delete(number int) error {
 	return s.store.Delete(number)
 }
 
+func (s ParcelService) MarkCODCollected(number int, amount int64, operator string) error {
+	err := s.store.MarkCODCollected(number, amount, operator)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("По посылке № %d принят наложенный платёж %d коп., оператор %s\n", number, amount, operator)
+
+	return nil
+}
+
+// MarkLost отмечает отправленную посылку как утерянную
+func (s ParcelService) MarkLost(number int) error {
+	err := s.store.TransitionStatus(number, ParcelStatusSent, ParcelStatusLost)
+	if errors.Is(err, ErrStatusChanged) {
+		return fmt.Errorf("посылка № %d не в статусе sent и не может быть утеряна", number)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Посылка № %d утеряна\n", number)
+
+	return nil
+}
+
+func (s ParcelService) ReportDamage(number int, description string, photos []Attachment) error {
+	err := s.store.ReportDamage(number, description, photos)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Посылка № %d повреждена: %s, приложено фотографий: %d\n", number, description, len(photos))
+
+	return nil
+}
+
+func (s ParcelService) CheckSLA(number int) error {
+	parcel, err := s.store.Get(number)
+	if err != nil {
+		return err
+	}
+
+	return parcel.CheckSLA(s.store.now().UTC())
+}
+
 func main() {
-	// настройте подключение к БД
+	httpAddr := flag.String("http", "", "адрес HTTP-сервера, например :8080; пусто — не запускать")
+	smtpAddr := flag.String("smtp", "", "адрес SMTP-сервера для квитанций, например localhost:25; пусто — не отправлять")
+	smtpFrom := flag.String("smtp-from", "tracker@localhost", "адрес отправителя квитанций")
+	report := flag.String("report", "", "вывести CSV-отчёт о сроках доставки за прошлую неделю (week) или месяц (month) либо о работе операторов по сменам за прошлые сутки (operators) и завершиться")
+	shiftLength := flag.Duration("shift", DefaultShiftLength, "длина смены в отчёте -report operators")
+	carrierFile := flag.String("carrier-file", "", "применить файл статусов перевозчика и завершиться")
+	carrierLayout := flag.String("carrier-layout", "", "JSON-файл с раскладкой файла перевозчика, см. CarrierLayout")
+	carrierReconcile := flag.String("carrier-reconcile", "", "сверить статусы с полной выгрузкой -carrier-file перевозчика с этим именем и завершиться")
+	carrierURL := flag.String("carrier-url", "", "адрес JSON API перевозчика-партнёра; пусто — не синхронизировать статусы")
+	carrierToken := flag.String("carrier-token", "", "токен JSON API перевозчика-партнёра")
+	carrierCodes := flag.String("carrier-codes", "", "коды событий перевозчика-партнёра, например ACC=sent,DLV=delivered")
+	shedLatency := flag.Duration("shed-latency", 0, "средняя задержка запросов к БД, выше которой отклоняются выгрузки и списки; 0 — не учитывать")
+	shedErrorRate := flag.Float64("shed-error-rate", 0, "доля ошибок запросов к БД от 0 до 1, выше которой отклоняются выгрузки и списки; 0 — не учитывать")
+	auditOperator := flag.String("audit-operator", DefaultAuditOperator, "оператор журнала изменений для изменений вне HTTP-запросов и запросов без заголовка X-Operator")
+	auditRetention := flag.Duration("audit-retention", 0, "журнал изменений старше этого срока сжимается: удаляются смены статуса, не изменившие посылку; 0 — не сжимать")
+	pickupDays := flag.Int("pickup-days", DefaultPickupStorageDays, "сколько дней посылка ждёт получателя в пункте выдачи")
+	sandbox := flag.Bool("sandbox", false, "создать и обновлять таблицы песочницы партнёров")
+	simulate := flag.Int("simulate", 0, "зарегистрировать столько демонстрационных посылок и продвигать их статусы в ускоренном времени вместе с HTTP-сервером")
+	simSpeedup := flag.Int("simulate-speedup", DefaultSimSpeedup, "во сколько раз ускорено время симуляции")
+	cacheTTL := flag.Duration("cache-ttl", 0, "сколько публичное отслеживание хранит посылку в памяти; 0 — без кэша")
+	cacheWarm := flag.Int("cache-warm", 0, "при запуске HTTP-сервера загрузить в кэш столько последних изменённых посылок")
+	addressMaxChanges := flag.Int("address-max-changes", 0, "сколько раз можно сменить адрес посылки до отправки; 0 — без ограничения")
+	addressCooldown := flag.Duration("address-cooldown", 0, "сколько ждать после смены адреса посылки до следующей; 0 — без ограничения")
+	coldMin := flag.Float64("cold-min", DefaultMinCelsius, "нижняя граница допустимой температуры охлаждаемых посылок, °C")
+	coldMax := flag.Float64("cold-max", DefaultMaxCelsius, "верхняя граница допустимой температуры охлаждаемых посылок, °C")
+	coldAlert := flag.String("cold-alert", "", "адрес email для оповещений о нарушении температурного режима; пусто — не оповещать")
+	recipientTokenKey := flag.String("recipient-token-key", "", "ключ подписи токенов получателя для действий в публичном API; пусто — токены не выдаются")
+	publicURL := flag.String("public-url", "", "адрес публичной страницы отслеживания для ссылок в уведомлениях, например https://track.example.com")
+	recipientTokenTTL := flag.Duration("recipient-token-ttl", DefaultRecipientTokenTTL, "срок действия токена получателя")
+	requireSignature := flag.Bool("require-signature", false, "переводить посылку в delivered только после подтверждения подписью получателя")
+	conflictPolicy := flag.String("conflict-policy", ConflictStatusPrecedence, "политика конфликтов операций приложения курьера: status_precedence, last_write_wins или manual_review")
+	maintenanceWindow := flag.String("maintenance-window", "", "тихие часы UTC для обслуживания БД (VACUUM, ANALYZE, перенос WAL), например 2-5; пусто — не обслуживать")
+	trackingView := flag.Bool("tracking-view", false, "отдавать публичное отслеживание из проекции tracking_view, которую обновляет HTTP-сервер")
+	replayEndpoint := flag.Int("replay-endpoint", 0, "повторить события в адрес уведомлений с этим ID и завершиться, см. -replay-parcel, -replay-from и -replay-to")
+	replayParcel := flag.Int("replay-parcel", 0, "повторить события только этой посылки")
+	replayFrom := flag.String("replay-from", "", "повторить события не раньше этого времени, RFC 3339")
+	replayTo := flag.String("replay-to", "", "повторить события раньше этого времени, RFC 3339")
+	sqlTrace := flag.Bool("sql-trace", false, "писать в журнал каждый запрос к БД с планом и временем; переключается и через /admin/debug/sql")
+	dbMaxOpen := flag.Int("db-max-open", DefaultSQLitePool.MaxOpen, "наибольшее число соединений с БД; 0 — без ограничения")
+	dbMaxIdle := flag.Int("db-max-idle", DefaultSQLitePool.MaxIdle, "сколько простаивающих соединений с БД держать; меньше 0 — не держать")
+	dbConnLifetime := flag.Duration("db-conn-lifetime", DefaultSQLitePool.MaxLifetime, "через сколько пересоздавать соединение с БД; 0 — не пересоздавать")
+	dbConnIdleTime := flag.Duration("db-conn-idle-time", DefaultSQLitePool.MaxIdleTime, "через сколько простоя закрывать соединение с БД; 0 — не закрывать")
+	flag.Parse()
+
+	db, err := OpenDB("tracker.db")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer db.Close()
+	PoolConfig{MaxOpen: *dbMaxOpen, MaxIdle: *dbMaxIdle, MaxLifetime: *dbConnLifetime, MaxIdleTime: *dbConnIdleTime}.Apply(db)
+
+	err = Migrate(db)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	policy, err := ParseConflictPolicy(*conflictPolicy)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var window MaintenanceWindow
+	if *maintenanceWindow != "" {
+		if window, err = ParseMaintenanceWindow(*maintenanceWindow); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	if *coldMin > *coldMax {
+		fmt.Println(ErrInvalidColdChainRange)
+		return
+	}
+	tracer := NewStatementTracer(log.Default())
+	tracer.SetEnabled(*sqlTrace)
+	storeOpts := []StoreOption{
+		WithStatementTracer(tracer),
+		WithAuditLog(*auditOperator),
+		WithConflictPolicy(policy),
+		WithAddressChangePolicy(AddressChangePolicy{MaxChanges: *addressMaxChanges, Cooldown: *addressCooldown}),
+		WithColdChainPolicy(ColdChainPolicy{MinCelsius: *coldMin, MaxCelsius: *coldMax, AlertTo: *coldAlert}),
+	}
+	if *recipientTokenKey != "" {
+		storeOpts = append(storeOpts, WithRecipientTokenKey([]byte(*recipientTokenKey), *recipientTokenTTL))
+	}
+	if *publicURL != "" {
+		storeOpts = append(storeOpts, WithPublicBaseURL(*publicURL))
+	}
+	if *requireSignature {
+		storeOpts = append(storeOpts, WithSignatureRequired())
+	}
+	if *trackingView {
+		storeOpts = append(storeOpts, WithTrackingView())
+	}
+	if *shedLatency > 0 || *shedErrorRate > 0 {
+		storeOpts = append(storeOpts, WithLoadShedder(NewLoadShedder(*shedLatency, *shedErrorRate)))
+	}
+	var cache *ParcelCache
+	if *cacheTTL > 0 {
+		cache = NewParcelCache(*cacheTTL, max(*cacheWarm, DefaultParcelCacheSize))
+		storeOpts = append(storeOpts, WithParcelCache(cache))
+	}
+	store := NewParcelStore(db, storeOpts...)
+	if err := store.VerifySchema(context.Background()); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if *sandbox {
+		if err := store.Sandbox().Migrate(); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	if *report == ReportOperators {
+		from, to := LastDay(store.now())
+		r, err := store.OperatorReport(from, to, *shiftLength)
+		if err == nil {
+			err = r.WriteCSV(os.Stdout)
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+	if *report != "" {
+		r, err := store.SLAReport(*report, store.now())
+		if err == nil {
+			err = r.WriteCSV(os.Stdout)
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if *replayEndpoint != 0 {
+		if err := replayToEndpoint(store, *replayEndpoint, *replayParcel, *replayFrom, *replayTo); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if *carrierFile != "" && *carrierReconcile != "" {
+		if err := reconcileCarrierFile(store, *carrierReconcile, *carrierFile, *carrierLayout); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if *carrierFile != "" {
+		if err := importCarrierFile(store, *carrierFile, *carrierLayout); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
 
-	store := // создайте объект ParcelStore функцией NewParcelStore
 	service := NewParcelService(store)
 
 	// регистрация посылки
@@ -169,4 +460,237 @@ func main() {
 		fmt.Println(err)
 		return
 	}
+
+	if *httpAddr != "" {
+		errorLog := NewErrorLog(100)
+		app := serverapp.New()
+		metrics := NewStorageMetrics(store)
+		dispatcher := NewWebhookDispatcher(store, &http.Client{})
+		mux := http.NewServeMux()
+		mux.Handle("/", NewHTTPHandler(store, errorLog))
+		mux.Handle("/metrics", metrics)
+		mux.Handle("/metrics/webhooks", dispatcher)
+		mux.Handle("/metrics/pool", NewPoolMetrics("sqlite", db))
+		app.AddHTTPServer(&http.Server{Addr: *httpAddr, Handler: mux}, nil)
+		if cache != nil && *cacheWarm > 0 {
+			// без прогрева сервер всё равно работает, только первые запросы идут в БД
+			if _, err := cache.Warm(context.Background(), store, *cacheWarm); err != nil {
+				errorLog.Record(err)
+			}
+		}
+		startJob(app, "storage-metrics", func(ctx context.Context) {
+			metrics.Run(ctx, storageMetricsInterval, errorLog)
+		})
+		if *maintenanceWindow != "" {
+			startJob(app, "maintenance", func(ctx context.Context) {
+				store.RunMaintenance(ctx, window, maintenanceInterval, metrics, errorLog)
+			})
+		}
+
+		if *smtpAddr != "" {
+			// отправителя SMS в поставке нет, коды подтверждения уходят
+			// только у приложений, встроивших хранилище со своим шлюзом
+			notifier := ChannelNotifier{ChannelEmail: SMTPNotifier{Addr: *smtpAddr, From: *smtpFrom}}
+			startJob(app, "outbox", func(ctx context.Context) {
+				store.RunOutboxRelay(ctx, notifier, outboxInterval, outboxBatch, errorLog)
+			})
+			// то, что накопилось с последнего прохода, отправляется при остановке
+			app.OnFlush("outbox", func(ctx context.Context) error {
+				_, err := store.RelayOutbox(ctx, notifier, outboxBatch)
+				return err
+			})
+		}
+
+		startJob(app, "webhooks", func(ctx context.Context) {
+			dispatcher.Run(ctx, webhookInterval, errorLog)
+		})
+		if *trackingView {
+			startJob(app, "tracking-view", func(ctx context.Context) {
+				store.RunTrackingProjection(ctx, trackingViewInterval, errorLog)
+			})
+		}
+		startJob(app, "daily-stats", func(ctx context.Context) {
+			store.RunDailyStats(ctx, dailyStatsInterval, errorLog)
+		})
+		startJob(app, "subscriptions", func(ctx context.Context) {
+			store.RunSubscriptionNotifier(ctx, subscriptionInterval, errorLog)
+		})
+		startJob(app, "pickup-expiry", func(ctx context.Context) {
+			store.RunPickupExpiry(ctx, *pickupDays, pickupInterval, errorLog)
+		})
+		if *carrierURL != "" {
+			partner := carriers.JSONAPI{CarrierName: "partner", BaseURL: *carrierURL, Token: *carrierToken, Codes: parseCarrierCodes(*carrierCodes)}
+			startJob(app, "carrier-sync", func(ctx context.Context) {
+				store.RunCarrierSync(ctx, []carriers.Carrier{partner}, carrierSyncInterval, errorLog)
+			})
+		}
+		if *auditRetention > 0 {
+			startJob(app, "audit-compaction", func(ctx context.Context) {
+				store.RunAuditCompaction(ctx, *auditRetention, auditCompactionInterval, errorLog)
+			})
+		}
+		startJob(app, "consistency", func(ctx context.Context) {
+			store.RunConsistencyCheck(ctx, consistencyInterval, errorLog)
+		})
+		if *simulate > 0 {
+			sim := NewSimulator(store, nil, *simSpeedup, time.Now().UnixNano())
+			if *smtpAddr != "" {
+				sim.SenderEmail = *smtpFrom
+			}
+			if _, err := sim.Seed(*simulate, store.now()); err != nil {
+				fmt.Println(err)
+				return
+			}
+			startJob(app, "simulator", func(ctx context.Context) {
+				sim.Run(ctx, simInterval, errorLog)
+			})
+		}
+
+		app.OnClose("db", db)
+
+		err = app.Run(context.Background())
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+}
+
+// replayToEndpoint повторяет в адрес уведомлений endpoint события посылки
+// parcel и/или периода from–to в формате RFC 3339 и печатает их число
+func replayToEndpoint(store ParcelStore, endpoint int, parcel int, from string, to string) error {
+	f := ReplayFilter{Parcel: parcel}
+	for _, p := range []struct {
+		value string
+		dest  *time.Time
+	}{{from, &f.From}, {to, &f.To}} {
+		if p.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, p.value)
+		if err != nil {
+			return err
+		}
+		*p.dest = t
+	}
+
+	n, err := store.ReplayEvents(context.Background(), f, WebhookReplaySink{Store: store, Endpoint: endpoint})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("В адрес уведомлений %d поставлено событий: %d\n", endpoint, n)
+
+	return nil
+}
+
+// importCarrierFile применяет файл статусов перевозчика path с раскладкой
+// из JSON-файла layoutPath и печатает итог и ошибки по строкам
+func importCarrierFile(store ParcelStore, path string, layoutPath string) error {
+	layout, err := readCarrierLayout(layoutPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	res, err := store.ImportCarrierFile(f, layout)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Файл %s: применено %d, пропущено %d, ошибок %d\n", path, res.Applied, res.Skipped, len(res.Errors))
+	for _, e := range res.Errors {
+		fmt.Println(e)
+	}
+
+	return nil
+}
+
+// parseCarrierCodes разбирает список вида КОД=статус через запятую
+func parseCarrierCodes(v string) map[string]string {
+	codes := map[string]string{}
+	for _, pair := range strings.Split(v, ",") {
+		code, status, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok {
+			codes[code] = status
+		}
+	}
+
+	return codes
+}
+
+// startJob запускает фоновое задание run и регистрирует его остановку:
+// при завершении приложения контекст задания отменяется, и остановка
+// ждёт, пока run вернётся
+func startJob(app *serverapp.App, name string, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+
+	app.OnStopJobs(name, func(ctx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// readCarrierLayout читает раскладку файла перевозчика из JSON-файла
+func readCarrierLayout(path string) (CarrierLayout, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return CarrierLayout{}, err
+	}
+	var layout CarrierLayout
+	if err := json.Unmarshal(data, &layout); err != nil {
+		return CarrierLayout{}, fmt.Errorf("раскладка %s: %w", path, err)
+	}
+
+	return layout, nil
+}
+
+// reconcileCarrierFile сверяет статусы отправлений перевозчика carrier
+// с его полной выгрузкой из файла path; запускается ночью по расписанию
+func reconcileCarrierFile(store ParcelStore, carrier string, path string, layoutPath string) error {
+	layout, err := readCarrierLayout(layoutPath)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	events, errs, err := ParseCarrierFile(f, layout)
+	if err != nil {
+		return err
+	}
+	for _, e := range errs {
+		fmt.Println(e)
+	}
+
+	res, err := store.Reconcile(carrier, CarrierSnapshot(events, layout))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Сверка с %s: совпало %d, применено переходов %d, неизвестных %d, конфликтов %d\n",
+		carrier, res.Matched, res.Applied, len(res.Unknown), len(res.Conflicts))
+	for _, c := range res.Conflicts {
+		fmt.Printf("посылка № %d (%s): у нас %q, у перевозчика %q, %s\n", c.Parcel, c.TrackingNumber, c.Ours, c.Theirs, c.Kind)
+	}
+
+	return nil
 }
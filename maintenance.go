@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maintenanceInterval — как часто проверяется, не пора ли обслужить БД
+	maintenanceInterval = 10 * time.Minute
+	// maintenancePeriod — обслуживание выполняется не чаще раза за этот срок
+	maintenancePeriod = 20 * time.Hour
+)
+
+// autoVacuumIncremental — значение PRAGMA auto_vacuum, при котором
+// освобождённые страницы возвращаются incremental_vacuum
+const autoVacuumIncremental = 2
+
+var ErrInvalidMaintenanceWindow = errors.New("некорректное окно обслуживания, ожидается часы UTC вида 2-5")
+
+// MaintenanceWindow — тихие часы UTC [From, To), в которые выполняется
+// обслуживание БД. Окно может переходить через полночь, например 22-4.
+type MaintenanceWindow struct {
+	From int
+	To   int
+}
+
+// ParseMaintenanceWindow разбирает окно вида "2-5"
+func ParseMaintenanceWindow(s string) (MaintenanceWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return MaintenanceWindow{}, ErrInvalidMaintenanceWindow
+	}
+	w := MaintenanceWindow{}
+	var err1, err2 error
+	w.From, err1 = strconv.Atoi(strings.TrimSpace(from))
+	w.To, err2 = strconv.Atoi(strings.TrimSpace(to))
+	if err1 != nil || err2 != nil || w.From < 0 || w.From > 23 || w.To < 0 || w.To > 23 || w.From == w.To {
+		return MaintenanceWindow{}, ErrInvalidMaintenanceWindow
+	}
+
+	return w, nil
+}
+
+// Contains сообщает, попадает ли t в окно
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	h := t.UTC().Hour()
+	if w.From < w.To {
+		return h >= w.From && h < w.To
+	}
+
+	return h >= w.From || h < w.To
+}
+
+// MaintenanceResult — итог обслуживания БД
+type MaintenanceResult struct {
+	// ReclaimedBytes — на сколько уменьшился файл БД
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+	// CheckpointedPages — сколько страниц журнала WAL перенесено в файл БД
+	CheckpointedPages int64         `json:"checkpointed_pages"`
+	Duration          time.Duration `json:"duration"`
+	RanAt             time.Time     `json:"ran_at"`
+}
+
+// Maintain обслуживает БД SQLite: возвращает свободные страницы файлу
+// через incremental_vacuum, обновляет статистику планировщика ANALYZE и
+// переносит журнал WAL в файл БД с усечением журнала. Без этого файл БД
+// только растёт. Если БД создана без auto_vacuum=INCREMENTAL, первый вызов
+// включает его полным VACUUM, который перезаписывает файл и на время
+// блокирует запись, поэтому Maintain вызывается в тихие часы.
+func (s ParcelStore) Maintain(ctx context.Context) (MaintenanceResult, error) {
+	start := time.Now()
+	res := MaintenanceResult{RanAt: s.now().UTC()}
+
+	pagesBefore, pageSize, err := s.pageCount(ctx)
+	if err != nil {
+		return MaintenanceResult{}, err
+	}
+
+	var autoVacuum int
+	if err := s.db.QueryRowContext(ctx, "PRAGMA {schema}auto_vacuum").Scan(&autoVacuum); err != nil {
+		return MaintenanceResult{}, err
+	}
+	if autoVacuum != autoVacuumIncremental {
+		if err := s.enableIncrementalVacuum(ctx); err != nil {
+			return MaintenanceResult{}, err
+		}
+	} else if _, err := s.db.ExecContext(ctx, "PRAGMA {schema}incremental_vacuum"); err != nil {
+		return MaintenanceResult{}, err
+	}
+
+	if _, err := s.db.ExecContext(ctx, "ANALYZE "+s.naming.schemaName()); err != nil {
+		return MaintenanceResult{}, err
+	}
+
+	var busy, logPages int64
+	err = s.db.QueryRowContext(ctx, "PRAGMA {schema}wal_checkpoint(TRUNCATE)").Scan(&busy, &logPages, &res.CheckpointedPages)
+	if err != nil {
+		return MaintenanceResult{}, err
+	}
+	// у БД без WAL журнала нет, SQLite возвращает -1
+	res.CheckpointedPages = max(res.CheckpointedPages, 0)
+
+	pagesAfter, _, err := s.pageCount(ctx)
+	if err != nil {
+		return MaintenanceResult{}, err
+	}
+	res.ReclaimedBytes = max(pagesBefore-pagesAfter, 0) * pageSize
+	res.Duration = time.Since(start)
+
+	return res, nil
+}
+
+// enableIncrementalVacuum включает auto_vacuum=INCREMENTAL. Режим меняется
+// только вместе с полной перезаписью файла, и оба запроса должны идти
+// через одно соединение.
+func (s ParcelStore) enableIncrementalVacuum(ctx context.Context) error {
+	conn, err := s.db.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	query := fmt.Sprintf("PRAGMA {schema}auto_vacuum = %d", autoVacuumIncremental)
+	if _, err := conn.ExecContext(ctx, s.db.names.Replace(query)); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "VACUUM "+s.naming.schemaName())
+
+	return err
+}
+
+// pageCount возвращает число страниц файла БД и размер страницы
+func (s ParcelStore) pageCount(ctx context.Context) (int64, int64, error) {
+	var pages, pageSize int64
+	if err := s.db.QueryRowContext(ctx, "PRAGMA {schema}page_count").Scan(&pages); err != nil {
+		return 0, 0, err
+	}
+	if err := s.db.QueryRowContext(ctx, "PRAGMA {schema}page_size").Scan(&pageSize); err != nil {
+		return 0, 0, err
+	}
+
+	return pages, pageSize, nil
+}
+
+// RunMaintenance каждые interval проверяет, идут ли тихие часы window, и
+// обслуживает БД не чаще раза в maintenancePeriod, пока не отменён ctx.
+// Итоги передаются в metrics, ошибки записываются в errors.
+func (s ParcelStore) RunMaintenance(ctx context.Context, window MaintenanceWindow, interval time.Duration,
+	metrics *StorageMetrics, errors *ErrorLog) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := s.now()
+		if !window.Contains(now) || (!last.IsZero() && now.Sub(last) < maintenancePeriod) {
+			continue
+		}
+		res, err := s.Maintain(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				errors.Record(err)
+			}
+			continue
+		}
+		last = now
+		metrics.RecordMaintenance(res)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestMaintenanceWindow проверяет разбор окна обслуживания
+func TestMaintenanceWindow(t *testing.T) {
+	w, err := ParseMaintenanceWindow("22-4")
+	require.NoError(t, err)
+	require.True(t, w.Contains(time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)))
+	require.True(t, w.Contains(time.Date(2024, 5, 1, 3, 59, 0, 0, time.UTC)))
+	require.False(t, w.Contains(time.Date(2024, 5, 1, 4, 0, 0, 0, time.UTC)))
+
+	for _, s := range []string{"", "2", "5-5", "2-24", "a-b"} {
+		_, err := ParseMaintenanceWindow(s)
+		require.ErrorIs(t, err, ErrInvalidMaintenanceWindow, s)
+	}
+}
+
+// TestMaintain проверяет, что обслуживание возвращает место удалённых
+// посылок и попадает в метрики
+func TestMaintain(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	addAndDelete := func() {
+		var numbers []int
+		for i := 0; i < 200; i++ {
+			p := getTestParcel()
+			p.Address = strings.Repeat("адрес ", 100)
+			number, err := store.Add(p)
+			require.NoError(t, err)
+			numbers = append(numbers, number)
+		}
+		for _, number := range numbers {
+			require.NoError(t, store.Delete(number))
+		}
+	}
+	addAndDelete()
+
+	// первое обслуживание включает incremental_vacuum полным VACUUM
+	res, err := store.Maintain(context.Background())
+	require.NoError(t, err)
+	require.Positive(t, res.ReclaimedBytes)
+
+	// check
+	addAndDelete()
+	res, err = store.Maintain(context.Background())
+	require.NoError(t, err)
+	require.Positive(t, res.ReclaimedBytes)
+
+	metrics := NewStorageMetrics(store)
+	metrics.RecordMaintenance(res)
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Contains(t, rec.Body.String(), "tracker_maintenance_reclaimed_bytes_total ")
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrShipmentNotFound = errors.New("отправка не найдена")
+	ErrInvalidShipment  = errors.New("некорректная отправка")
+)
+
+// Shipment — партия посылок, передаваемая перевозчику по одному манифесту
+type Shipment struct {
+	ID        int       `json:"id"`
+	Carrier   string    `json:"carrier"`
+	Parcels   []int     `json:"parcels"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateShipment собирает посылки parcels в отправку перевозчику carrier.
+// В отправку попадают только не доставленные посылки: registered или sent.
+func (s ParcelStore) CreateShipment(carrier string, parcels []int) (Shipment, error) {
+	switch {
+	case strings.TrimSpace(carrier) == "":
+		return Shipment{}, fmt.Errorf("%w: не указан перевозчик", ErrInvalidShipment)
+	case len(parcels) == 0:
+		return Shipment{}, fmt.Errorf("%w: нет посылок", ErrInvalidShipment)
+	}
+
+	seen := make(map[int]bool, len(parcels))
+	for _, number := range parcels {
+		if seen[number] {
+			return Shipment{}, fmt.Errorf("%w: посылка № %d указана дважды", ErrInvalidShipment, number)
+		}
+		seen[number] = true
+
+		p, err := s.Get(number)
+		if err != nil {
+			return Shipment{}, fmt.Errorf("посылка № %d: %w", number, err)
+		}
+		if p.Status != ParcelStatusRegistered && p.Status != ParcelStatusSent {
+			return Shipment{}, fmt.Errorf("%w: посылка № %d в статусе %s", ErrInvalidShipment, number, p.Status)
+		}
+	}
+
+	sh := Shipment{Carrier: carrier, Parcels: parcels, CreatedAt: s.now().UTC()}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Shipment{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO {shipment} (carrier, created_at) VALUES (:carrier, :created_at)",
+		sql.Named("carrier", sh.Carrier),
+		sql.Named("created_at", formatTime(sh.CreatedAt)))
+	if err != nil {
+		return Shipment{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Shipment{}, err
+	}
+	sh.ID = int(id)
+
+	for _, number := range parcels {
+		_, err := tx.Exec("INSERT INTO {shipment_parcel} (shipment, parcel) VALUES (:shipment, :parcel)",
+			sql.Named("shipment", sh.ID),
+			sql.Named("parcel", number))
+		if err != nil {
+			return Shipment{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Shipment{}, err
+	}
+
+	return sh, nil
+}
+
+// ManifestEntry — посылка в манифесте отправки
+type ManifestEntry struct {
+	Parcel int    `json:"parcel"`
+	UUID   string `json:"uuid"`
+	// TrackingNumber — номер отслеживания у перевозчика отправки, пустой,
+	// если посылка ещё не зарегистрирована у него, см. HandToCarrier
+	TrackingNumber string `json:"tracking_number"`
+	Address        string `json:"address"`
+	Country        string `json:"country"`
+	PostalCode     string `json:"postal_code"`
+	// Weight — заявленный вес в граммах, 0 — не заявлен
+	Weight int64 `json:"weight"`
+}
+
+// ManifestTotal — итог манифеста по стране назначения
+type ManifestTotal struct {
+	Country string `json:"country"`
+	Parcels int    `json:"parcels"`
+	Weight  int64  `json:"weight"`
+}
+
+// Manifest — манифест для передачи отправки перевозчику: список посылок
+// с весом и адресом назначения и итоги
+type Manifest struct {
+	Shipment  int             `json:"shipment"`
+	Carrier   string          `json:"carrier"`
+	CreatedAt time.Time       `json:"created_at"`
+	Entries   []ManifestEntry `json:"entries"`
+	// ByCountry — итоги по странам назначения в порядке кодов стран
+	ByCountry    []ManifestTotal `json:"by_country"`
+	TotalParcels int             `json:"total_parcels"`
+	TotalWeight  int64           `json:"total_weight"`
+}
+
+// GenerateManifest строит манифест отправки shipmentID. Посылки в нём
+// упорядочены по номеру.
+func (s ParcelStore) GenerateManifest(shipmentID int) (Manifest, error) {
+	m := Manifest{Shipment: shipmentID, Entries: []ManifestEntry{}, ByCountry: []ManifestTotal{}}
+	err := s.db.QueryRow("SELECT carrier, created_at FROM {shipment} WHERE id = :id", sql.Named("id", shipmentID)).
+		Scan(&m.Carrier, scanTime(&m.CreatedAt))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Manifest{}, ErrShipmentNotFound
+	}
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	rows, err := s.db.Query("SELECT p.number, p.uuid, COALESCE(c.tracking_number, ''), p.address, p.country, "+
+		"p.postal_code, p.weight FROM {shipment_parcel} i JOIN {parcel} p ON p.number = i.parcel "+
+		"LEFT JOIN {carrier_shipment} c ON c.parcel = p.number AND c.carrier = :carrier "+
+		"WHERE i.shipment = :shipment ORDER BY p.number",
+		sql.Named("carrier", m.Carrier),
+		sql.Named("shipment", shipmentID))
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer rows.Close()
+
+	byCountry := map[string]*ManifestTotal{}
+	for rows.Next() {
+		e := ManifestEntry{}
+		err := rows.Scan(&e.Parcel, &e.UUID, &e.TrackingNumber, &e.Address, &e.Country, &e.PostalCode, &e.Weight)
+		if err != nil {
+			return Manifest{}, err
+		}
+		m.Entries = append(m.Entries, e)
+		m.TotalParcels++
+		m.TotalWeight += e.Weight
+
+		t, ok := byCountry[e.Country]
+		if !ok {
+			t = &ManifestTotal{Country: e.Country}
+			byCountry[e.Country] = t
+		}
+		t.Parcels++
+		t.Weight += e.Weight
+	}
+
+	if err := rows.Err(); err != nil {
+		return Manifest{}, err
+	}
+
+	for _, t := range byCountry {
+		m.ByCountry = append(m.ByCountry, *t)
+	}
+	sort.Slice(m.ByCountry, func(i, j int) bool { return m.ByCountry[i].Country < m.ByCountry[j].Country })
+
+	return m, nil
+}
+
+// WriteCSV записывает манифест в CSV: строка на посылку, затем итоги по
+// странам и общий итог со словом total в колонке parcel. Колонка pieces —
+// число посылок в строке.
+func (m Manifest) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"shipment", "carrier", "parcel", "uuid", "tracking_number", "country",
+		"postal_code", "address", "pieces", "weight_grams"}); err != nil {
+		return err
+	}
+
+	id := strconv.Itoa(m.Shipment)
+	for _, e := range m.Entries {
+		err := cw.Write([]string{id, m.Carrier, strconv.Itoa(e.Parcel), e.UUID, e.TrackingNumber, e.Country,
+			e.PostalCode, e.Address, "1", strconv.FormatInt(e.Weight, 10)})
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range m.ByCountry {
+		err := cw.Write([]string{id, m.Carrier, "total", "", "", t.Country, "", "", strconv.Itoa(t.Parcels),
+			strconv.FormatInt(t.Weight, 10)})
+		if err != nil {
+			return err
+		}
+	}
+	err := cw.Write([]string{id, m.Carrier, "total", "", "", "", "", "", strconv.Itoa(m.TotalParcels),
+		strconv.FormatInt(m.TotalWeight, 10)})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// manifestAddressWidth — сколько символов адреса помещается в строку
+// манифеста в PDF, остальное обрезается
+const manifestAddressWidth = 32
+
+// WritePDF записывает манифест в PDF для печати, с местом для подписей
+// сторон. Шрифт PDF без кириллицы, поэтому адреса транслитерированы.
+func (m Manifest) WritePDF(w io.Writer) error {
+	text := []string{
+		fmt.Sprintf("Carrier manifest No %d", m.Shipment),
+		"Carrier: " + latin(m.Carrier),
+		"Created: " + m.CreatedAt.Format("2006-01-02 15:04 UTC"),
+		"",
+		fmt.Sprintf("%-8s %-18s %-2s %-8s %8s  %s", "Parcel", "Tracking", "CC", "Postcode", "Weight,g", "Address"),
+	}
+	for _, e := range m.Entries {
+		address := []rune(latin(e.Address))
+		if len(address) > manifestAddressWidth {
+			address = address[:manifestAddressWidth]
+		}
+		text = append(text, fmt.Sprintf("%-8d %-18s %-2s %-8s %8d  %s", e.Parcel, latin(e.TrackingNumber),
+			e.Country, latin(e.PostalCode), e.Weight, string(address)))
+	}
+	text = append(text, "")
+	for _, t := range m.ByCountry {
+		text = append(text, fmt.Sprintf("Total %-2s: %d parcels, %d g", t.Country, t.Parcels, t.Weight))
+	}
+	text = append(text,
+		fmt.Sprintf("Total: %d parcels, %d g", m.TotalParcels, m.TotalWeight),
+		"",
+		"Handed over (warehouse): ____________    Accepted (carrier): ____________")
+
+	var pages [][]string
+	for len(text) > invoicePDFLines {
+		pages = append(pages, text[:invoicePDFLines])
+		text = text[invoicePDFLines:]
+	}
+	pages = append(pages, text)
+
+	return writeTextPDF(w, pages)
+}
+
+// WriteLabels записывает ZIP с этикетками посылок манифеста: по файлу
+// label-<номер>.pdf на посылку
+func (m Manifest) WriteLabels(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for _, e := range m.Entries {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     fmt.Sprintf("label-%d.pdf", e.Parcel),
+			Method:   zip.Deflate,
+			Modified: m.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		label := []string{
+			fmt.Sprintf("Parcel No %d", e.Parcel),
+			e.UUID,
+			"",
+			"Carrier: " + latin(m.Carrier),
+			"Tracking: " + latin(e.TrackingNumber),
+			fmt.Sprintf("Shipment: %d", m.Shipment),
+			"",
+			"To: " + latin(e.Address),
+			strings.TrimSpace(e.Country + " " + latin(e.PostalCode)),
+			"",
+			fmt.Sprintf("Weight: %d g", e.Weight),
+		}
+		if err := writeTextPDF(f, [][]string{label}); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// latinRunes — транслитерация кириллицы для стандартных шрифтов PDF
+var latinRunes = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// latin возвращает s латиницей: кириллица транслитерируется, прочие
+// символы вне ASCII заменяются на «?»
+func latin(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			b.WriteRune(r)
+			continue
+		}
+		lower := unicode.ToLower(r)
+		l, ok := latinRunes[lower]
+		if !ok {
+			b.WriteByte('?')
+			continue
+		}
+		if lower != r && l != "" {
+			l = strings.ToUpper(l[:1]) + l[1:]
+		}
+		b.WriteString(l)
+	}
+
+	return b.String()
+}
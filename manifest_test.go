@@ -0,0 +1,155 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestGenerateManifest проверяет манифест отправки, его CSV и PDF
+// и архив этикеток
+func TestGenerateManifest(t *testing.T) {
+	// prepare
+	clock := NewManualClock(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC))
+	store := NewParcelStore(openTestDB(t), WithClock(clock), WithCreatedAtWindow(0, 0))
+	add := func(country string, weight int64, address string) int {
+		p := getTestParcel()
+		p.CreatedAt = time.Time{}
+		p.Country = country
+		p.PostalCode = "101000"
+		p.Weight = weight
+		p.Address = address
+		number, err := store.Add(p)
+		require.NoError(t, err)
+		return number
+	}
+	first, second, third := add("RU", 1200, "Москва, ул. Щорса"), add("KZ", 800, "Алматы"), add("RU", 0, "Тверь")
+	carrier := &testCarrier{}
+	tracking, err := store.HandToCarrier(context.Background(), first, carrier)
+	require.NoError(t, err)
+
+	// check
+	_, err = store.CreateShipment("", []int{first})
+	require.ErrorIs(t, err, ErrInvalidShipment)
+	_, err = store.CreateShipment("test", nil)
+	require.ErrorIs(t, err, ErrInvalidShipment)
+	_, err = store.CreateShipment("test", []int{first, first})
+	require.ErrorIs(t, err, ErrInvalidShipment)
+	_, err = store.CreateShipment("test", []int{first, 100000})
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	delivered := add("RU", 100, "test")
+	require.NoError(t, store.SetStatus(delivered, ParcelStatusDelivered))
+	_, err = store.CreateShipment("test", []int{delivered})
+	require.ErrorIs(t, err, ErrInvalidShipment)
+
+	sh, err := store.CreateShipment("test", []int{third, second, first})
+	require.NoError(t, err)
+
+	_, err = store.GenerateManifest(sh.ID + 1)
+	require.ErrorIs(t, err, ErrShipmentNotFound)
+
+	m, err := store.GenerateManifest(sh.ID)
+	require.NoError(t, err)
+	require.Equal(t, "test", m.Carrier)
+	require.Len(t, m.Entries, 3)
+	require.Equal(t, first, m.Entries[0].Parcel)
+	require.Equal(t, tracking, m.Entries[0].TrackingNumber)
+	require.Empty(t, m.Entries[1].TrackingNumber)
+	require.Equal(t, 3, m.TotalParcels)
+	require.Equal(t, int64(2000), m.TotalWeight)
+	require.Equal(t, []ManifestTotal{{Country: "KZ", Parcels: 1, Weight: 800}, {Country: "RU", Parcels: 2, Weight: 1200}}, m.ByCountry)
+
+	var buf bytes.Buffer
+	require.NoError(t, m.WriteCSV(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 7)
+	require.Equal(t, "shipment,carrier,parcel,uuid,tracking_number,country,postal_code,address,pieces,weight_grams", lines[0])
+	id := strconv.Itoa(sh.ID)
+	require.Equal(t, id+",test,"+strconv.Itoa(second)+","+m.Entries[1].UUID+",,KZ,101000,Алматы,1,800", lines[2])
+	require.Equal(t, id+",test,total,,,RU,,,2,1200", lines[5])
+	require.Equal(t, id+",test,total,,,,,,3,2000", lines[6])
+
+	buf.Reset()
+	require.NoError(t, m.WritePDF(&buf))
+	require.True(t, bytes.HasPrefix(buf.Bytes(), []byte("%PDF-1.4\n")))
+	require.Contains(t, buf.String(), "Moskva, ul. Shchorsa")
+	require.Contains(t, buf.String(), "Total: 3 parcels, 2000 g")
+
+	buf.Reset()
+	require.NoError(t, m.WriteLabels(&buf))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 3)
+	require.Equal(t, "label-"+strconv.Itoa(first)+".pdf", zr.File[0].Name)
+	f, err := zr.File[0].Open()
+	require.NoError(t, err)
+	label, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Contains(t, string(label), "Tracking: "+tracking)
+	require.Contains(t, string(label), "Weight: 1200 g")
+}
+
+func TestLatin(t *testing.T) {
+	require.Equal(t, "Shchukino, ul. Yunosti 5", latin("Щукино, ул. Юности 5"))
+	require.Equal(t, "Podezd ?", latin("Подъезд №"))
+}
+
+func TestShipmentHandlers(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	handler := NewAdminHandler(store, NewErrorLog(10))
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/shipments", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/shipments/manifest?"+query, nil))
+		return rec
+	}
+	parcel := strconv.Itoa(number)
+
+	// check
+	require.Equal(t, http.StatusBadRequest, post(url.Values{"carrier": {"test"}, "parcels": {"первая"}}).Code)
+	require.Equal(t, http.StatusBadRequest, post(url.Values{"parcels": {parcel}}).Code)
+	require.Equal(t, http.StatusNotFound, post(url.Values{"carrier": {"test"}, "parcels": {"100000"}}).Code)
+	rec := post(url.Values{"carrier": {"test"}, "parcels": {parcel}})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Contains(t, rec.Body.String(), `"parcels":[`+parcel+`]`)
+
+	require.Equal(t, http.StatusBadRequest, get("id=1&format=xml").Code)
+	require.Equal(t, http.StatusNotFound, get("id=2").Code)
+
+	rec = get("id=1")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	require.Contains(t, rec.Body.String(), "1,test,total,,,,,,1,0")
+
+	rec = get("id=1&format=pdf")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/pdf", rec.Header().Get("Content-Type"))
+
+	rec = get("id=1&format=zip")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/zip", rec.Header().Get("Content-Type"))
+	require.Equal(t, `attachment; filename="labels-1.zip"`, rec.Header().Get("Content-Disposition"))
+}
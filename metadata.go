@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// MaxMetadataSize — максимальный размер метаданных посылки в JSON, байт
+const MaxMetadataSize = 16 << 10
+
+var ErrInvalidMetadata = errors.New("некорректные метаданные посылки")
+
+// metadataKeyRe — допустимый ключ верхнего уровня метаданных. Ключ входит
+// в путь json_extract, поэтому ограничен символами, не требующими
+// экранирования в пути.
+var metadataKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// Metadata — произвольные поля интегратора, например идентификаторы
+// маркетплейса. Хранятся в столбце metadata как объект JSON, пустые
+// метаданные хранятся как {} и читаются как nil.
+type Metadata map[string]any
+
+// Validate проверяет ключи и размер метаданных
+func (m Metadata) Validate() error {
+	for key := range m {
+		if !metadataKeyRe.MatchString(key) {
+			return fmt.Errorf("%w: недопустимый ключ %q", ErrInvalidMetadata, key)
+		}
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	if len(data) > MaxMetadataSize {
+		return fmt.Errorf("%w: больше %d байт", ErrInvalidMetadata, MaxMetadataSize)
+	}
+
+	return nil
+}
+
+// Value записывает метаданные в БД как объект JSON
+func (m Metadata) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return "{}", nil
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// Scan читает метаданные из столбца JSON
+func (m *Metadata) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("метаданные: неподдерживаемый тип %T", src)
+	}
+
+	*m = nil
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return err
+	}
+	if len(*m) == 0 {
+		*m = nil
+	}
+
+	return nil
+}
+
+// SetMetadata заменяет метаданные посылки целиком
+func (s ParcelStore) SetMetadata(number int, m Metadata) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	return s.audited(context.Background(), number, AuditSetMetadata, func() error {
+		res, err := s.db.Exec("UPDATE {parcel} SET metadata = :metadata WHERE number = :number",
+			sql.Named("metadata", m),
+			sql.Named("number", number))
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return sql.ErrNoRows
+		}
+
+		return nil
+	})
+}
+
+// GetMetadata возвращает метаданные посылки, nil — метаданных нет
+func (s ParcelStore) GetMetadata(number int) (Metadata, error) {
+	var m Metadata
+	err := s.db.QueryRow("SELECT metadata FROM {parcel} WHERE number = :number",
+		sql.Named("number", number)).Scan(&m)
+
+	return m, err
+}
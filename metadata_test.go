@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestMetadata проверяет сохранение и замену метаданных посылки
+func TestMetadata(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	parcel.Metadata = Metadata{"marketplace": "ozon", "order": "A-1"}
+
+	// add
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	// check
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, parcel.Metadata, stored.Metadata)
+
+	require.NoError(t, store.SetMetadata(number, Metadata{"seller_id": 42.0}))
+	m, err := store.GetMetadata(number)
+	require.NoError(t, err)
+	require.Equal(t, Metadata{"seller_id": 42.0}, m)
+
+	// пустые метаданные читаются как nil
+	require.NoError(t, store.SetMetadata(number, nil))
+	m, err = store.GetMetadata(number)
+	require.NoError(t, err)
+	require.Nil(t, m)
+
+	require.ErrorIs(t, store.SetMetadata(number, Metadata{"bad key": 1}), ErrInvalidMetadata)
+	require.ErrorIs(t, store.SetMetadata(number, Metadata{"big": strings.Repeat("x", MaxMetadataSize)}), ErrInvalidMetadata)
+	require.ErrorIs(t, store.SetMetadata(number+1, Metadata{"a": 1}), sql.ErrNoRows)
+}
+
+// TestListByMetadata проверяет отбор посылок по ключу метаданных
+func TestListByMetadata(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	var numbers []int
+	for _, m := range []Metadata{
+		{"marketplace": "ozon", "seller_id": 42},
+		{"marketplace": "wb", "seller_id": 7},
+		nil,
+	} {
+		parcel := getTestParcel()
+		parcel.Metadata = m
+		number, err := store.Add(parcel)
+		require.NoError(t, err)
+		numbers = append(numbers, number)
+	}
+
+	// check
+	page, err := store.List(ParcelFilter{MetadataKey: "marketplace", MetadataValue: "wb"}, ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Parcels, 1)
+	require.Equal(t, numbers[1], page.Parcels[0].Number)
+
+	// числа сравниваются в десятичной записи
+	page, err = store.List(ParcelFilter{MetadataKey: "seller_id", MetadataValue: "42"}, ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Parcels, 1)
+	require.Equal(t, numbers[0], page.Parcels[0].Number)
+}
+
+// FuzzMetadataScan проверяет, что чтение произвольного JSON метаданных
+// не паникует, а корректные метаданные переживают запись и чтение
+func FuzzMetadataScan(f *testing.F) {
+	f.Add(`{"marketplace":"ozon","seller_id":42}`)
+	f.Add(`{}`)
+	f.Add(`{"a":{"b":[1,2,null]}}`)
+	f.Add(`{"bad key":"\u0000"}`)
+	f.Add(`[1,2]`)
+
+	f.Fuzz(func(t *testing.T, data string) {
+		var m Metadata
+		if err := m.Scan(data); err != nil || m.Validate() != nil {
+			return
+		}
+
+		v, err := m.Value()
+		require.NoError(t, err)
+		var again Metadata
+		require.NoError(t, again.Scan(v))
+		require.Equal(t, m, again)
+	})
+}
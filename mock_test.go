@@ -0,0 +1,1071 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+var errMock = errors.New("mock error")
+
+const (
+	mockSelect = "SELECT number, uuid, client, status, address, created_at, service_level, " +
+		"cod_amount, cod_collected, country, postal_code, zone, insured, declared_value, insurance_premium, " +
+		"sent_at, delivered_at, sender_email, tenant, price, locale, order_id, pickup_point, updated_at, metadata, recipient_phone, weight, contents, promo_code, discount, currency, requires_refrigeration, age_verification FROM parcel "
+	mockInsert = "INSERT INTO parcel (number, uuid, client, status, address, created_at, service_level, cod_amount, " +
+		"country, postal_code, zone, insured, declared_value, insurance_premium, sender_email, tenant, price, locale, order_id, pickup_point, metadata, recipient_phone, weight, contents, promo_code, discount, currency, requires_refrigeration, age_verification) " +
+		"VALUES (NULLIF(:number, 0), :uuid, :client, :status, :address, :created_at, :service_level, :cod_amount, " +
+		":country, :postal_code, :zone, :insured, :declared_value, :insurance_premium, :sender_email, :tenant, :price, :locale, NULLIF(:order_id, 0), NULLIF(:pickup_point, 0), :metadata, :recipient_phone, :weight, :contents, :promo_code, :discount, :currency, :requires_refrigeration, :age_verification) RETURNING number"
+	mockStatus = "UPDATE parcel SET status = :status, " +
+		"sent_at = CASE WHEN :status = :sent THEN :now ELSE sent_at END, " +
+		"delivered_at = CASE WHEN :status = :delivered THEN :now ELSE delivered_at END WHERE number = :number"
+)
+
+// mockNow — время часов хранилища в тестах с WithClock
+var mockNow = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+// newMockStore возвращает хранилище с опциями opts поверх sqlmock, который
+// сверяет запросы посимвольно, отслеживает Ping и в конце теста проверяет,
+// что все ожидания выполнены
+func newMockStore(t *testing.T, opts ...StoreOption) (ParcelStore, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual), sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, mock.ExpectationsWereMet())
+		db.Close()
+	})
+
+	return NewParcelStore(db, opts...), mock
+}
+
+// expectMockGet ожидает чтение посылки number, parcels — найденные строки
+func expectMockGet(mock sqlmock.Sqlmock, number int, parcels ...Parcel) {
+	mock.ExpectQuery(mockSelect + "WHERE number = :number").
+		WithArgs(sql.Named("number", number)).
+		WillReturnRows(mockParcelRows(parcels...))
+}
+
+// mockParcelRows возвращает строки выборки посылок
+func mockParcelRows(parcels ...Parcel) *sqlmock.Rows {
+	columns := make([]string, len(parcelFields))
+	for i, f := range parcelFields {
+		columns[i] = f.column
+	}
+
+	rows := sqlmock.NewRows(columns)
+	for _, p := range parcels {
+		rows.AddRow(p.Number, p.UUID, p.Client, p.Status, p.Address, formatTime(p.CreatedAt), p.ServiceLevel,
+			p.CODAmount, p.CODCollected, p.Country, p.PostalCode, p.Zone, p.Insured, p.DeclaredValue, p.InsurancePremium,
+			formatTime(p.SentAt), formatTime(p.DeliveredAt), p.SenderEmail, p.Tenant, p.Price, p.Locale, p.OrderID, p.PickupPoint, formatTime(p.UpdatedAt), "{}", p.RecipientPhone, p.Weight, "", p.PromoCode, p.Discount, p.Currency, p.RequiresRefrigeration, p.AgeVerification)
+	}
+
+	return rows
+}
+
+// mockNumberRows возвращает номер, добавленный INSERT ... RETURNING
+func mockNumberRows(number int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"number"}).AddRow(number)
+}
+
+// mockInsertArgs возвращает ожидаемые параметры INSERT посылки
+func mockInsertArgs(p Parcel) []driver.Value {
+	return []driver.Value{
+		sql.Named("number", 0),
+		sql.Named("uuid", p.UUID),
+		sql.Named("client", p.Client),
+		sql.Named("status", p.Status),
+		sql.Named("address", p.Address),
+		sql.Named("created_at", formatTime(p.CreatedAt)),
+		sql.Named("service_level", p.ServiceLevel),
+		sql.Named("cod_amount", p.CODAmount),
+		sql.Named("country", p.Country),
+		sql.Named("postal_code", p.PostalCode),
+		sql.Named("zone", p.Zone),
+		sql.Named("insured", p.Insured),
+		sql.Named("declared_value", p.DeclaredValue),
+		sql.Named("insurance_premium", p.InsurancePremium),
+		sql.Named("sender_email", p.SenderEmail),
+		sql.Named("tenant", p.Tenant),
+		sql.Named("price", p.Price),
+		sql.Named("locale", p.Locale),
+		sql.Named("order_id", p.OrderID),
+		sql.Named("pickup_point", p.PickupPoint),
+		// метаданные передаются через driver.Valuer
+		sql.Named("metadata", "{}"),
+		sql.Named("recipient_phone", p.RecipientPhone),
+		sql.Named("weight", p.Weight),
+		sql.Named("contents", ""),
+		sql.Named("promo_code", p.PromoCode),
+		sql.Named("discount", p.Discount),
+		sql.Named("currency", p.Currency),
+		sql.Named("requires_refrigeration", p.RequiresRefrigeration),
+		sql.Named("age_verification", p.AgeVerification),
+	}
+}
+
+// TestMockAdd проверяет запрос добавления посылки и его ошибки
+func TestMockAdd(t *testing.T) {
+	parcel := getTestParcel()
+
+	t.Run("ok", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(mockInsert).WithArgs(mockInsertArgs(parcel)...).WillReturnRows(mockNumberRows(7))
+		mock.ExpectCommit()
+
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+		require.Equal(t, 7, id)
+	})
+
+	t.Run("insert error", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(mockInsert).WithArgs(mockInsertArgs(parcel)...).WillReturnError(errMock)
+		mock.ExpectRollback()
+
+		_, err := store.Add(parcel)
+		require.ErrorIs(t, err, errMock)
+	})
+
+	t.Run("returning error", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(mockInsert).WithArgs(mockInsertArgs(parcel)...).WillReturnRows(mockNumberRows(7).RowError(0, errMock))
+		mock.ExpectRollback()
+
+		_, err := store.Add(parcel)
+		require.ErrorIs(t, err, errMock)
+	})
+
+	t.Run("commit error", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(mockInsert).WithArgs(mockInsertArgs(parcel)...).WillReturnRows(mockNumberRows(7))
+		mock.ExpectCommit().WillReturnError(errMock)
+
+		_, err := store.Add(parcel)
+		require.ErrorIs(t, err, errMock)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		// некорректная посылка не доходит до БД
+		store, _ := newMockStore(t)
+		parcel := parcel
+		parcel.Client = 0
+
+		_, err := store.Add(parcel)
+		require.ErrorIs(t, err, ErrInvalidParcel)
+	})
+}
+
+// TestMockGet проверяет запросы получения посылки по номеру и UUID
+func TestMockGet(t *testing.T) {
+	parcel := getTestParcel()
+	parcel.Number = 7
+
+	t.Run("by number", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectQuery(mockSelect + "WHERE number = :number").
+			WithArgs(sql.Named("number", 7)).
+			WillReturnRows(mockParcelRows(parcel))
+
+		stored, err := store.Get(7)
+		require.NoError(t, err)
+		require.Equal(t, parcel, stored)
+	})
+
+	t.Run("by uuid", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectQuery(mockSelect + "WHERE uuid = :uuid").
+			WithArgs(sql.Named("uuid", parcel.UUID)).
+			WillReturnRows(mockParcelRows(parcel))
+
+		stored, err := store.GetByUUID(parcel.UUID)
+		require.NoError(t, err)
+		require.Equal(t, parcel, stored)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectQuery(mockSelect + "WHERE number = :number").
+			WithArgs(sql.Named("number", 7)).
+			WillReturnRows(mockParcelRows())
+
+		_, err := store.Get(7)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectQuery(mockSelect + "WHERE number = :number").
+			WithArgs(sql.Named("number", 7)).
+			WillReturnError(errMock)
+
+		_, err := store.Get(7)
+		require.ErrorIs(t, err, errMock)
+	})
+}
+
+// TestMockGetByClient проверяет запрос посылок клиента и ошибки чтения строк
+func TestMockGetByClient(t *testing.T) {
+	parcel := getTestParcel()
+	query := mockSelect + "WHERE client = :client"
+
+	t.Run("ok", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		first, second := parcel, parcel
+		first.Number, second.Number = 1, 2
+		mock.ExpectQuery(query).
+			WithArgs(sql.Named("client", parcel.Client)).
+			WillReturnRows(mockParcelRows(first, second))
+
+		parcels, err := store.GetByClient(parcel.Client)
+		require.NoError(t, err)
+		require.Equal(t, []Parcel{first, second}, parcels)
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectQuery(query).WithArgs(sql.Named("client", parcel.Client)).WillReturnError(errMock)
+
+		_, err := store.GetByClient(parcel.Client)
+		require.ErrorIs(t, err, errMock)
+	})
+
+	t.Run("scan error", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		rows := mockParcelRows(parcel)
+		// номер не число, остальные столбцы NULL
+		values := make([]driver.Value, len(parcelFields))
+		values[0] = "x"
+		rows.AddRow(values...)
+		mock.ExpectQuery(query).WithArgs(sql.Named("client", parcel.Client)).WillReturnRows(rows)
+
+		_, err := store.GetByClient(parcel.Client)
+		require.Error(t, err)
+	})
+
+	t.Run("rows error", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectQuery(query).
+			WithArgs(sql.Named("client", parcel.Client)).
+			WillReturnRows(mockParcelRows(parcel, parcel).RowError(1, errMock))
+
+		_, err := store.GetByClient(parcel.Client)
+		require.ErrorIs(t, err, errMock)
+	})
+}
+
+// TestMockSetStatus проверяет запрос смены статуса
+func TestMockSetStatus(t *testing.T) {
+	store, mock := newMockStore(t)
+	parcel := getTestParcel()
+	parcel.Number = 7
+	mock.ExpectExec(mockStatus).
+		WithArgs(sql.Named("status", ParcelStatusSent), sql.Named("number", 7),
+			sql.Named("sent", ParcelStatusSent), sql.Named("delivered", ParcelStatusDelivered), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// неизвестный статус проверяется по арендатору посылки; у посылки без
+	// арендатора собственных статусов нет
+	mock.ExpectQuery(mockSelect + "WHERE number = :number").
+		WithArgs(sql.Named("number", 7)).
+		WillReturnRows(mockParcelRows(parcel))
+
+	require.NoError(t, store.SetStatus(7, ParcelStatusSent))
+	require.ErrorIs(t, store.SetStatus(7, "unknown"), ErrInvalidParcel)
+}
+
+// TestMockTransitionStatus проверяет условную смену статуса и разбор результата
+func TestMockTransitionStatus(t *testing.T) {
+	query := mockStatus + " AND status = :from"
+	args := []driver.Value{sql.Named("status", ParcelStatusSent), sql.Named("number", 7), sql.Named("from", ParcelStatusRegistered),
+		sql.Named("sent", ParcelStatusSent), sql.Named("delivered", ParcelStatusDelivered), sqlmock.AnyArg()}
+	parcel := getTestParcel()
+	parcel.Number = 7
+
+	// expectGet ожидает чтение посылки перед обновлением
+	expectGet := func(mock sqlmock.Sqlmock, parcels ...Parcel) {
+		mock.ExpectQuery(mockSelect + "WHERE number = :number").
+			WithArgs(sql.Named("number", 7)).
+			WillReturnRows(mockParcelRows(parcels...))
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		expectGet(mock, parcel)
+		mock.ExpectExec(query).WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		require.NoError(t, store.TransitionStatus(7, ParcelStatusRegistered, ParcelStatusSent))
+	})
+
+	t.Run("status changed", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		sent := parcel
+		sent.Status = ParcelStatusSent
+		expectGet(mock, sent)
+
+		require.ErrorIs(t, store.TransitionStatus(7, ParcelStatusRegistered, ParcelStatusSent), ErrStatusChanged)
+	})
+
+	t.Run("changed concurrently", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		expectGet(mock, parcel)
+		mock.ExpectExec(query).WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		require.ErrorIs(t, store.TransitionStatus(7, ParcelStatusRegistered, ParcelStatusSent), ErrStatusChanged)
+	})
+
+	t.Run("invalid transition", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		expectGet(mock, parcel)
+
+		require.ErrorIs(t, store.TransitionStatus(7, ParcelStatusRegistered, ParcelStatusDelivered), ErrInvalidTransition)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		expectGet(mock)
+
+		require.ErrorIs(t, store.TransitionStatus(7, ParcelStatusRegistered, ParcelStatusSent), sql.ErrNoRows)
+	})
+
+	t.Run("rows affected error", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		expectGet(mock, parcel)
+		mock.ExpectExec(query).WithArgs(args...).WillReturnResult(sqlmock.NewErrorResult(errMock))
+
+		require.ErrorIs(t, store.TransitionStatus(7, ParcelStatusRegistered, ParcelStatusSent), errMock)
+	})
+
+	t.Run("exec error", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		expectGet(mock, parcel)
+		mock.ExpectExec(query).WithArgs(args...).WillReturnError(errMock)
+
+		require.ErrorIs(t, store.TransitionStatus(7, ParcelStatusRegistered, ParcelStatusSent), errMock)
+	})
+}
+
+// TestMockSetAddressDelete проверяет запросы смены адреса и удаления
+func TestMockSetAddressDelete(t *testing.T) {
+	store, mock := newMockStore(t)
+	mock.ExpectExec("UPDATE parcel SET address = :address WHERE number = :number AND status = :status").
+		WithArgs(sql.Named("address", "new"), sql.Named("number", 7), sql.Named("status", ParcelStatusRegistered)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM parcel WHERE number = :number AND status = :status").
+		WithArgs(sql.Named("number", 7), sql.Named("status", ParcelStatusRegistered)).
+		WillReturnError(errMock)
+
+	require.NoError(t, store.SetAddress(7, "new"))
+	require.ErrorIs(t, store.Delete(7), errMock)
+
+	// пустой адрес не доходит до БД
+	require.ErrorIs(t, store.SetAddress(7, " "), ErrInvalidParcel)
+}
+
+// TestMockCustomsItems проверяет запросы позиций таможенной декларации
+// и разбор посылки, когда ни одна строка не изменилась
+func TestMockCustomsItems(t *testing.T) {
+	item := CustomsItem{ID: 3, Parcel: 7, Description: "книга", Quantity: 2, HSCode: "490199", Value: 50000}
+	insert := "INSERT INTO customs_item (parcel, description, quantity, hs_code, value) " +
+		"SELECT number, :description, :quantity, :hs_code, :value FROM parcel " +
+		"WHERE number = :parcel AND status = :status"
+	insertArgs := []driver.Value{sql.Named("description", item.Description), sql.Named("quantity", item.Quantity),
+		sql.Named("hs_code", item.HSCode), sql.Named("value", item.Value), sql.Named("parcel", 7),
+		sql.Named("status", ParcelStatusRegistered)}
+	update := "UPDATE customs_item SET description = :description, quantity = :quantity, hs_code = :hs_code, value = :value " +
+		"WHERE id = :id AND parcel IN (SELECT number FROM parcel WHERE number = :parcel AND status = :status)"
+	updateArgs := []driver.Value{sql.Named("description", item.Description), sql.Named("quantity", item.Quantity),
+		sql.Named("hs_code", item.HSCode), sql.Named("value", item.Value), sql.Named("id", 3), sql.Named("parcel", 7),
+		sql.Named("status", ParcelStatusRegistered)}
+	remove := "DELETE FROM customs_item " +
+		"WHERE id = :id AND parcel IN (SELECT number FROM parcel WHERE number = :parcel AND status = :status)"
+	removeArgs := []driver.Value{sql.Named("id", 3), sql.Named("parcel", 7), sql.Named("status", ParcelStatusRegistered)}
+	selectItems := "SELECT id, parcel, description, quantity, hs_code, value FROM customs_item WHERE parcel = :parcel ORDER BY id"
+	itemRows := func(items ...CustomsItem) *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"id", "parcel", "description", "quantity", "hs_code", "value"})
+		for _, i := range items {
+			rows.AddRow(i.ID, i.Parcel, i.Description, i.Quantity, i.HSCode, i.Value)
+		}
+		return rows
+	}
+	parcel := getTestParcel()
+	parcel.Number = 7
+	sent := parcel
+	sent.Status = ParcelStatusSent
+
+	t.Run("add", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectExec(insert).WithArgs(insertArgs...).WillReturnResult(sqlmock.NewResult(3, 1))
+
+		id, err := store.AddCustomsItem(item)
+		require.NoError(t, err)
+		require.Equal(t, 3, id)
+	})
+
+	t.Run("add not registered", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectExec(insert).WithArgs(insertArgs...).WillReturnResult(sqlmock.NewResult(0, 0))
+		expectMockGet(mock, 7, sent)
+
+		_, err := store.AddCustomsItem(item)
+		require.ErrorIs(t, err, ErrNotRegistered)
+	})
+
+	t.Run("add parcel not found", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectExec(insert).WithArgs(insertArgs...).WillReturnResult(sqlmock.NewResult(0, 0))
+		expectMockGet(mock, 7)
+
+		_, err := store.AddCustomsItem(item)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("add errors", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectExec(insert).WithArgs(insertArgs...).WillReturnError(errMock)
+		mock.ExpectExec(insert).WithArgs(insertArgs...).WillReturnResult(sqlmock.NewErrorResult(errMock))
+
+		_, err := store.AddCustomsItem(item)
+		require.ErrorIs(t, err, errMock)
+		_, err = store.AddCustomsItem(item)
+		require.ErrorIs(t, err, errMock)
+
+		// некорректная позиция не доходит до БД
+		invalid := item
+		invalid.HSCode = "49"
+		_, err = store.AddCustomsItem(invalid)
+		require.ErrorIs(t, err, ErrInvalidCustomsItem)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectQuery(selectItems).WithArgs(sql.Named("parcel", 7)).WillReturnRows(itemRows(item))
+		mock.ExpectQuery(selectItems).WithArgs(sql.Named("parcel", 7)).WillReturnError(errMock)
+		mock.ExpectQuery(selectItems).WithArgs(sql.Named("parcel", 7)).WillReturnRows(itemRows(item, item).RowError(1, errMock))
+
+		items, err := store.GetCustomsItems(7)
+		require.NoError(t, err)
+		require.Equal(t, []CustomsItem{item}, items)
+		_, err = store.GetCustomsItems(7)
+		require.ErrorIs(t, err, errMock)
+		_, err = store.GetCustomsItems(7)
+		require.ErrorIs(t, err, errMock)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectExec(update).WithArgs(updateArgs...).WillReturnResult(sqlmock.NewResult(0, 1))
+		// посылка в статусе registered, но позиции нет
+		mock.ExpectExec(update).WithArgs(updateArgs...).WillReturnResult(sqlmock.NewResult(0, 0))
+		expectMockGet(mock, 7, parcel)
+		mock.ExpectExec(update).WithArgs(updateArgs...).WillReturnError(errMock)
+
+		require.NoError(t, store.UpdateCustomsItem(item))
+		require.ErrorIs(t, store.UpdateCustomsItem(item), sql.ErrNoRows)
+		require.ErrorIs(t, store.UpdateCustomsItem(item), errMock)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectExec(remove).WithArgs(removeArgs...).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(remove).WithArgs(removeArgs...).WillReturnResult(sqlmock.NewResult(0, 0))
+		expectMockGet(mock, 7, sent)
+		mock.ExpectExec(remove).WithArgs(removeArgs...).WillReturnError(errMock)
+
+		require.NoError(t, store.DeleteCustomsItem(7, 3))
+		require.ErrorIs(t, store.DeleteCustomsItem(7, 3), ErrNotRegistered)
+		require.ErrorIs(t, store.DeleteCustomsItem(7, 3), errMock)
+	})
+
+	t.Run("export", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		expensive := item
+		expensive.ID = 4
+		expensive.Value = CN22ValueLimit
+		expectMockGet(mock, 7, parcel)
+		mock.ExpectQuery(selectItems).WithArgs(sql.Named("parcel", 7)).WillReturnRows(itemRows(item, expensive))
+		expectMockGet(mock, 7, parcel)
+		mock.ExpectQuery(selectItems).WithArgs(sql.Named("parcel", 7)).WillReturnRows(itemRows())
+		expectMockGet(mock, 7)
+
+		d, err := store.ExportCustomsDeclaration(7)
+		require.NoError(t, err)
+		require.Equal(t, CustomsFormCN23, d.Form)
+		require.Equal(t, 4, d.TotalQuantity)
+		require.Equal(t, CN22ValueLimit+item.Value, d.TotalValue)
+
+		_, err = store.ExportCustomsDeclaration(7)
+		require.ErrorIs(t, err, ErrNoCustomsItems)
+		_, err = store.ExportCustomsDeclaration(7)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+// TestMockZones проверяет запросы зон доставки
+func TestMockZones(t *testing.T) {
+	selectZones := "SELECT id, country, postal_prefix, name FROM zone ORDER BY country, postal_prefix"
+	resolve := "SELECT name FROM zone WHERE country = :country AND substr(:postal_code, 1, length(postal_prefix)) = postal_prefix " +
+		"ORDER BY length(postal_prefix) DESC LIMIT 1"
+	resolveArgs := []driver.Value{sql.Named("country", "RU"), sql.Named("postal_code", "101000")}
+	group := "SELECT zone, COUNT(*), SUM(CASE WHEN status = :registered THEN 1 ELSE 0 END), " +
+		"SUM(CASE WHEN status = :sent THEN 1 ELSE 0 END), SUM(CASE WHEN status = :delivered THEN 1 ELSE 0 END) " +
+		"FROM parcel GROUP BY zone ORDER BY zone"
+	groupArgs := []driver.Value{sql.Named("registered", ParcelStatusRegistered), sql.Named("sent", ParcelStatusSent),
+		sql.Named("delivered", ParcelStatusDelivered)}
+
+	t.Run("add", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		query := "INSERT INTO zone (country, postal_prefix, name) VALUES (:country, :postal_prefix, :name)"
+		args := []driver.Value{sql.Named("country", "RU"), sql.Named("postal_prefix", "10"), sql.Named("name", "Москва")}
+		mock.ExpectExec(query).WithArgs(args...).WillReturnResult(sqlmock.NewResult(5, 1))
+		mock.ExpectExec(query).WithArgs(args...).WillReturnError(errMock)
+		mock.ExpectExec(query).WithArgs(args...).WillReturnResult(sqlmock.NewErrorResult(errMock))
+
+		// код страны приводится к верхнему регистру до запроса
+		id, err := store.AddZone(Zone{Country: "ru", PostalPrefix: "10", Name: "Москва"})
+		require.NoError(t, err)
+		require.Equal(t, 5, id)
+		_, err = store.AddZone(Zone{Country: "RU", PostalPrefix: "10", Name: "Москва"})
+		require.ErrorIs(t, err, errMock)
+		_, err = store.AddZone(Zone{Country: "RU", PostalPrefix: "10", Name: "Москва"})
+		require.ErrorIs(t, err, errMock)
+
+		_, err = store.AddZone(Zone{Country: "RUS", Name: "Москва"})
+		require.ErrorIs(t, err, ErrInvalidZone)
+	})
+
+	t.Run("list and delete", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectQuery(selectZones).WillReturnRows(sqlmock.NewRows([]string{"id", "country", "postal_prefix", "name"}).
+			AddRow(5, "RU", "10", "Москва"))
+		mock.ExpectQuery(selectZones).WillReturnRows(sqlmock.NewRows([]string{"id", "country", "postal_prefix", "name"}).
+			AddRow("x", "RU", "10", "Москва"))
+		mock.ExpectExec("DELETE FROM zone WHERE id = :id").WithArgs(sql.Named("id", 5)).WillReturnError(errMock)
+
+		zones, err := store.ListZones()
+		require.NoError(t, err)
+		require.Equal(t, []Zone{{ID: 5, Country: "RU", PostalPrefix: "10", Name: "Москва"}}, zones)
+		_, err = store.ListZones()
+		require.Error(t, err)
+		require.ErrorIs(t, store.DeleteZone(5), errMock)
+	})
+
+	t.Run("resolve", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectQuery(resolve).WithArgs(resolveArgs...).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Москва"))
+		mock.ExpectQuery(resolve).WithArgs(resolveArgs...).WillReturnRows(sqlmock.NewRows([]string{"name"}))
+		mock.ExpectQuery(resolve).WithArgs(resolveArgs...).WillReturnError(errMock)
+
+		zone, err := store.ResolveZone("ru", "101000")
+		require.NoError(t, err)
+		require.Equal(t, "Москва", zone)
+		_, err = store.ResolveZone("RU", "101000")
+		require.ErrorIs(t, err, ErrZoneNotFound)
+		_, err = store.ResolveZone("RU", "101000")
+		require.ErrorIs(t, err, errMock)
+	})
+
+	t.Run("group", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		columns := []string{"zone", "total", "registered", "sent", "delivered"}
+		mock.ExpectQuery(group).WithArgs(groupArgs...).WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("", 1, 1, 0, 0).AddRow("Москва", 5, 1, 2, 2))
+		mock.ExpectQuery(group).WithArgs(groupArgs...).WillReturnError(errMock)
+		mock.ExpectQuery(group).WithArgs(groupArgs...).WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("", 1, 1, 0, 0).RowError(0, errMock))
+
+		stats, err := store.GroupByZone()
+		require.NoError(t, err)
+		require.Equal(t, []ZoneStats{{Zone: "", Total: 1, Registered: 1}, {Zone: "Москва", Total: 5, Registered: 1, Sent: 2, Delivered: 2}}, stats)
+		_, err = store.GroupByZone()
+		require.ErrorIs(t, err, errMock)
+		_, err = store.GroupByZone()
+		require.ErrorIs(t, err, errMock)
+	})
+}
+
+// TestMockClaims проверяет запросы страховых требований
+func TestMockClaims(t *testing.T) {
+	insert := "INSERT INTO insurance_claim (parcel, reason, amount, description, status, created_at) " +
+		"VALUES (:parcel, :reason, :amount, :description, :status, :created_at)"
+	insertArgs := []driver.Value{sql.Named("parcel", 7), sql.Named("reason", ParcelStatusLost), sql.Named("amount", int64(5000)),
+		sql.Named("description", "не дошла"), sql.Named("status", ClaimStatusOpen), sql.Named("created_at", formatTime(mockNow))}
+	columns := []string{"id", "parcel", "reason", "amount", "description", "status", "payout", "created_at", "resolved_at"}
+	selectClaim := "SELECT id, parcel, reason, amount, description, status, payout, created_at, resolved_at " +
+		"FROM insurance_claim WHERE id = :id"
+	selectClaims := "SELECT id, parcel, reason, amount, description, status, payout, created_at, resolved_at " +
+		"FROM insurance_claim WHERE parcel = :parcel ORDER BY id"
+	resolve := "UPDATE insurance_claim SET status = :status, payout = :payout, resolved_at = :resolved_at " +
+		"WHERE id = :id AND status = :open"
+	claim := Claim{ID: 2, Parcel: 7, Reason: ParcelStatusLost, Amount: 5000, Description: "не дошла",
+		Status: ClaimStatusOpen, CreatedAt: mockNow}
+	claimRows := func(claims ...Claim) *sqlmock.Rows {
+		rows := sqlmock.NewRows(columns)
+		for _, c := range claims {
+			rows.AddRow(c.ID, c.Parcel, c.Reason, c.Amount, c.Description, c.Status, c.Payout,
+				formatTime(c.CreatedAt), formatTime(c.ResolvedAt))
+		}
+		return rows
+	}
+	lost := getTestParcel()
+	lost.Number = 7
+	lost.Status = ParcelStatusLost
+	lost.Insured = true
+	lost.DeclaredValue = 10000
+
+	t.Run("file", func(t *testing.T) {
+		store, mock := newMockStore(t, WithClock(NewManualClock(mockNow)))
+		expectMockGet(mock, 7, lost)
+		mock.ExpectExec(insert).WithArgs(insertArgs...).WillReturnResult(sqlmock.NewResult(2, 1))
+		// открытое требование уже есть
+		expectMockGet(mock, 7, lost)
+		mock.ExpectExec(insert).WithArgs(insertArgs...).WillReturnError(&mysql.MySQLError{Number: 1062})
+		expectMockGet(mock, 7, lost)
+		mock.ExpectExec(insert).WithArgs(insertArgs...).WillReturnError(errMock)
+
+		id, err := store.FileClaim(7, 5000, "не дошла")
+		require.NoError(t, err)
+		require.Equal(t, 2, id)
+		_, err = store.FileClaim(7, 5000, "не дошла")
+		require.ErrorIs(t, err, ErrClaimExists)
+		_, err = store.FileClaim(7, 5000, "не дошла")
+		require.ErrorIs(t, err, errMock)
+	})
+
+	t.Run("file rejected", func(t *testing.T) {
+		store, mock := newMockStore(t, WithClock(NewManualClock(mockNow)))
+		uninsured := lost
+		uninsured.Insured = false
+		sent := lost
+		sent.Status = ParcelStatusSent
+		expectMockGet(mock, 7, uninsured)
+		expectMockGet(mock, 7, sent)
+		expectMockGet(mock, 7, lost)
+		expectMockGet(mock, 7)
+
+		// до INSERT дело не доходит
+		_, err := store.FileClaim(7, 5000, "не дошла")
+		require.ErrorIs(t, err, ErrNotInsured)
+		_, err = store.FileClaim(7, 5000, "не дошла")
+		require.ErrorIs(t, err, ErrNotClaimable)
+		_, err = store.FileClaim(7, lost.DeclaredValue+1, "не дошла")
+		require.ErrorIs(t, err, ErrInvalidClaimAmount)
+		_, err = store.FileClaim(7, 5000, "не дошла")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("resolve", func(t *testing.T) {
+		store, mock := newMockStore(t, WithClock(NewManualClock(mockNow)))
+		resolved := claim
+		resolved.Status = ClaimStatusRejected
+		mock.ExpectQuery(selectClaim).WithArgs(sql.Named("id", 2)).WillReturnRows(claimRows(claim))
+		mock.ExpectExec(resolve).WithArgs(sql.Named("status", ClaimStatusApproved), sql.Named("payout", int64(4000)),
+			sql.Named("resolved_at", formatTime(mockNow)), sql.Named("id", 2), sql.Named("open", ClaimStatusOpen)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		// при отказе выплата обнуляется, требование рассмотрели параллельно
+		mock.ExpectQuery(selectClaim).WithArgs(sql.Named("id", 2)).WillReturnRows(claimRows(claim))
+		mock.ExpectExec(resolve).WithArgs(sql.Named("status", ClaimStatusRejected), sql.Named("payout", int64(0)),
+			sql.Named("resolved_at", formatTime(mockNow)), sql.Named("id", 2), sql.Named("open", ClaimStatusOpen)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(selectClaim).WithArgs(sql.Named("id", 2)).WillReturnRows(claimRows(resolved))
+		mock.ExpectQuery(selectClaim).WithArgs(sql.Named("id", 2)).WillReturnRows(claimRows(claim))
+		mock.ExpectQuery(selectClaim).WithArgs(sql.Named("id", 3)).WillReturnRows(claimRows())
+
+		require.NoError(t, store.ResolveClaim(2, true, 4000))
+		require.ErrorIs(t, store.ResolveClaim(2, false, 4000), ErrClaimResolved)
+		require.ErrorIs(t, store.ResolveClaim(2, true, 4000), ErrClaimResolved)
+		require.ErrorIs(t, store.ResolveClaim(2, true, claim.Amount+1), ErrInvalidClaimAmount)
+		require.ErrorIs(t, store.ResolveClaim(3, true, 4000), sql.ErrNoRows)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectQuery(selectClaim).WithArgs(sql.Named("id", 2)).WillReturnRows(claimRows(claim))
+		mock.ExpectQuery(selectClaims).WithArgs(sql.Named("parcel", 7)).WillReturnRows(claimRows(claim))
+		mock.ExpectQuery(selectClaims).WithArgs(sql.Named("parcel", 7)).WillReturnError(errMock)
+		mock.ExpectQuery(selectClaims).WithArgs(sql.Named("parcel", 7)).WillReturnRows(claimRows(claim).RowError(0, errMock))
+
+		got, err := store.GetClaim(2)
+		require.NoError(t, err)
+		require.Equal(t, claim, got)
+		claims, err := store.GetClaims(7)
+		require.NoError(t, err)
+		require.Equal(t, []Claim{claim}, claims)
+		_, err = store.GetClaims(7)
+		require.ErrorIs(t, err, errMock)
+		_, err = store.GetClaims(7)
+		require.ErrorIs(t, err, errMock)
+	})
+}
+
+// TestMockCOD проверяет приём наложенного платежа и сверку за сутки
+func TestMockCOD(t *testing.T) {
+	collect := "UPDATE parcel SET cod_collected = 1, cod_collected_amount = :amount, " +
+		"cod_collected_by = :operator, cod_collected_at = :collected_at " +
+		"WHERE number = :number AND status = :status AND cod_amount > 0 AND cod_collected = 0"
+	collectArgs := []driver.Value{sql.Named("amount", int64(1500)), sql.Named("operator", "ivanov"),
+		sql.Named("collected_at", formatTime(mockNow)), sql.Named("number", 7), sql.Named("status", ParcelStatusDelivered)}
+	report := "SELECT cod_collected_by, currency, COUNT(*), SUM(cod_amount), SUM(cod_collected_amount) " +
+		"FROM parcel WHERE cod_collected = 1 AND cod_collected_at >= :from AND cod_collected_at < :to " +
+		"GROUP BY cod_collected_by, currency ORDER BY cod_collected_by, currency"
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	reportArgs := []driver.Value{sql.Named("from", formatTime(day)), sql.Named("to", formatTime(day.AddDate(0, 0, 1)))}
+	p := getTestParcel()
+	p.Number = 7
+	p.Status = ParcelStatusDelivered
+	p.CODAmount = 1500
+
+	t.Run("collect", func(t *testing.T) {
+		store, mock := newMockStore(t, WithClock(NewManualClock(mockNow)))
+		withoutCOD := p
+		withoutCOD.CODAmount = 0
+		collected := p
+		collected.CODCollected = true
+		sent := p
+		sent.Status = ParcelStatusSent
+		mock.ExpectExec(collect).WithArgs(collectArgs...).WillReturnResult(sqlmock.NewResult(0, 1))
+		// ни одна строка не обновилась: причину выясняет чтение посылки
+		for _, got := range []Parcel{withoutCOD, collected, sent} {
+			mock.ExpectExec(collect).WithArgs(collectArgs...).WillReturnResult(sqlmock.NewResult(0, 0))
+			expectMockGet(mock, 7, got)
+		}
+		mock.ExpectExec(collect).WithArgs(collectArgs...).WillReturnResult(sqlmock.NewResult(0, 0))
+		expectMockGet(mock, 7)
+		mock.ExpectExec(collect).WithArgs(collectArgs...).WillReturnError(errMock)
+
+		require.NoError(t, store.MarkCODCollected(7, 1500, "ivanov"))
+		require.ErrorIs(t, store.MarkCODCollected(7, 1500, "ivanov"), ErrNoCOD)
+		require.ErrorIs(t, store.MarkCODCollected(7, 1500, "ivanov"), ErrCODAlreadyCollected)
+		require.ErrorIs(t, store.MarkCODCollected(7, 1500, "ivanov"), ErrNotDelivered)
+		require.ErrorIs(t, store.MarkCODCollected(7, 1500, "ivanov"), sql.ErrNoRows)
+		require.ErrorIs(t, store.MarkCODCollected(7, 1500, "ivanov"), errMock)
+
+		// некорректные параметры не доходят до БД
+		require.ErrorIs(t, store.MarkCODCollected(7, 0, "ivanov"), ErrInvalidCODAmount)
+		require.ErrorIs(t, store.MarkCODCollected(7, 1500, ""), ErrEmptyOperator)
+	})
+
+	t.Run("report", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		columns := []string{"cod_collected_by", "currency", "count", "expected", "collected"}
+		mock.ExpectQuery(report).WithArgs(reportArgs...).WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("ivanov", "RUB", 2, 3000, 2900))
+		mock.ExpectQuery(report).WithArgs(reportArgs...).WillReturnError(errMock)
+		mock.ExpectQuery(report).WithArgs(reportArgs...).WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("ivanov", "RUB", "две", 3000, 2900))
+
+		// сутки берутся по UTC независимо от времени внутри них
+		res, err := store.CODReport(mockNow)
+		require.NoError(t, err)
+		require.Equal(t, []CODReconciliation{{Operator: "ivanov", Currency: "RUB", Parcels: 2, Expected: 3000, Collected: 2900}}, res)
+		_, err = store.CODReport(day)
+		require.ErrorIs(t, err, errMock)
+		_, err = store.CODReport(day)
+		require.Error(t, err)
+	})
+}
+
+// TestMockDamage проверяет транзакцию акта о повреждении и список актов
+func TestMockDamage(t *testing.T) {
+	damage := "UPDATE parcel SET status = :damaged WHERE number = :number AND status IN (:sent, :delivered)"
+	damageArgs := []driver.Value{sql.Named("damaged", ParcelStatusDamaged), sql.Named("number", 7),
+		sql.Named("sent", ParcelStatusSent), sql.Named("delivered", ParcelStatusDelivered)}
+	insertReport := "INSERT INTO damage_report (parcel, description, reported_at) VALUES (:parcel, :description, :reported_at)"
+	insertAttachment := "INSERT INTO attachment (parcel, kind, name, content_type, data, created_at) " +
+		"VALUES (:parcel, :kind, :name, :content_type, :data, :created_at)"
+	selectAttachments := "SELECT id, parcel, kind, name, content_type, created_at " +
+		"FROM attachment WHERE parcel = :parcel AND (:kind = '' OR kind = :kind) ORDER BY id"
+	photo := Attachment{Name: "box.jpg", ContentType: "image/jpeg", Data: []byte{1, 2, 3}}
+
+	t.Run("report", func(t *testing.T) {
+		store, mock := newMockStore(t, WithClock(NewManualClock(mockNow)))
+		mock.ExpectBegin()
+		mock.ExpectExec(damage).WithArgs(damageArgs...).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(insertReport).WithArgs(sql.Named("parcel", 7), sql.Named("description", "вмятина"),
+			sql.Named("reported_at", formatTime(mockNow))).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(insertAttachment).WithArgs(sql.Named("parcel", 7), sql.Named("kind", AttachmentKindDamagePhoto),
+			sql.Named("name", photo.Name), sql.Named("content_type", photo.ContentType), sql.Named("data", photo.Data),
+			sql.Named("created_at", formatTime(mockNow))).WillReturnResult(sqlmock.NewResult(4, 1))
+		mock.ExpectCommit()
+
+		require.NoError(t, store.ReportDamage(7, "вмятина", []Attachment{photo}))
+	})
+
+	t.Run("report rollback", func(t *testing.T) {
+		store, mock := newMockStore(t, WithClock(NewManualClock(mockNow)))
+		registered := getTestParcel()
+		registered.Number = 7
+		mock.ExpectBegin()
+		mock.ExpectExec(damage).WithArgs(damageArgs...).WillReturnResult(sqlmock.NewResult(0, 0))
+		expectMockGet(mock, 7, registered)
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec(damage).WithArgs(damageArgs...).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(insertReport).WithArgs(sql.Named("parcel", 7), sql.Named("description", "вмятина"),
+			sql.Named("reported_at", formatTime(mockNow))).WillReturnError(errMock)
+		mock.ExpectRollback()
+
+		require.ErrorIs(t, store.ReportDamage(7, "вмятина", nil), ErrNotDamageable)
+		require.ErrorIs(t, store.ReportDamage(7, "вмятина", nil), errMock)
+
+		// некорректный акт не открывает транзакцию
+		require.ErrorIs(t, store.ReportDamage(7, "", nil), ErrEmptyDamageDescription)
+		require.ErrorIs(t, store.ReportDamage(7, "вмятина", []Attachment{{ContentType: "image/jpeg"}}), ErrInvalidAttachment)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		query := "SELECT parcel, description, reported_at FROM damage_report ORDER BY reported_at, parcel"
+		mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"parcel", "description", "reported_at"}).
+			AddRow(7, "вмятина", formatTime(mockNow)))
+		mock.ExpectQuery(selectAttachments).WithArgs(sql.Named("parcel", 7), sql.Named("kind", AttachmentKindDamagePhoto)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "parcel", "kind", "name", "content_type", "created_at"}).
+				AddRow(4, 7, AttachmentKindDamagePhoto, photo.Name, photo.ContentType, formatTime(mockNow)))
+		mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"parcel", "description", "reported_at"}).
+			AddRow(7, "вмятина", formatTime(mockNow)))
+		mock.ExpectQuery(selectAttachments).WithArgs(sql.Named("parcel", 7), sql.Named("kind", AttachmentKindDamagePhoto)).
+			WillReturnError(errMock)
+
+		reports, err := store.ListDamaged()
+		require.NoError(t, err)
+		require.Equal(t, []DamageReport{{Parcel: 7, Description: "вмятина", ReportedAt: mockNow, Photos: []Attachment{{
+			ID: 4, Parcel: 7, Kind: AttachmentKindDamagePhoto, Name: photo.Name, ContentType: photo.ContentType, CreatedAt: mockNow,
+		}}}}, reports)
+		_, err = store.ListDamaged()
+		require.ErrorIs(t, err, errMock)
+	})
+}
+
+// TestMockAttachments проверяет запросы вложений
+func TestMockAttachments(t *testing.T) {
+	a := Attachment{ID: 4, Parcel: 7, Kind: "scan", Name: "invoice.pdf", ContentType: "application/pdf",
+		Data: []byte("%PDF"), CreatedAt: mockNow}
+	insert := "INSERT INTO attachment (parcel, kind, name, content_type, data, created_at) " +
+		"VALUES (:parcel, :kind, :name, :content_type, :data, :created_at)"
+	insertArgs := []driver.Value{sql.Named("parcel", a.Parcel), sql.Named("kind", a.Kind), sql.Named("name", a.Name),
+		sql.Named("content_type", a.ContentType), sql.Named("data", a.Data), sql.Named("created_at", formatTime(mockNow))}
+	selectOne := "SELECT id, parcel, kind, name, content_type, data, created_at FROM attachment WHERE id = :id"
+	selectList := "SELECT id, parcel, kind, name, content_type, created_at " +
+		"FROM attachment WHERE parcel = :parcel AND (:kind = '' OR kind = :kind) ORDER BY id"
+
+	t.Run("add", func(t *testing.T) {
+		store, mock := newMockStore(t, WithClock(NewManualClock(mockNow)))
+		mock.ExpectExec(insert).WithArgs(insertArgs...).WillReturnResult(sqlmock.NewResult(4, 1))
+		mock.ExpectExec(insert).WithArgs(insertArgs...).WillReturnError(errMock)
+
+		id, err := store.AddAttachment(a)
+		require.NoError(t, err)
+		require.Equal(t, 4, id)
+		_, err = store.AddAttachment(a)
+		require.ErrorIs(t, err, errMock)
+
+		empty := a
+		empty.Data = nil
+		_, err = store.AddAttachment(empty)
+		require.ErrorIs(t, err, ErrInvalidAttachment)
+	})
+
+	t.Run("get and list", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		mock.ExpectQuery(selectOne).WithArgs(sql.Named("id", 4)).WillReturnRows(
+			sqlmock.NewRows([]string{"id", "parcel", "kind", "name", "content_type", "data", "created_at"}).
+				AddRow(a.ID, a.Parcel, a.Kind, a.Name, a.ContentType, a.Data, formatTime(a.CreatedAt)))
+		mock.ExpectQuery(selectOne).WithArgs(sql.Named("id", 5)).WillReturnRows(
+			sqlmock.NewRows([]string{"id", "parcel", "kind", "name", "content_type", "data", "created_at"}))
+		mock.ExpectQuery(selectList).WithArgs(sql.Named("parcel", 7), sql.Named("kind", "")).WillReturnRows(
+			sqlmock.NewRows([]string{"id", "parcel", "kind", "name", "content_type", "created_at"}).
+				AddRow(a.ID, a.Parcel, a.Kind, a.Name, a.ContentType, formatTime(a.CreatedAt)))
+		mock.ExpectQuery(selectList).WithArgs(sql.Named("parcel", 7), sql.Named("kind", "scan")).WillReturnError(errMock)
+
+		got, err := store.GetAttachment(4)
+		require.NoError(t, err)
+		require.Equal(t, a, got)
+		_, err = store.GetAttachment(5)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		// в списке вложения без содержимого
+		list, err := store.ListAttachments(7, "")
+		require.NoError(t, err)
+		withoutData := a
+		withoutData.Data = nil
+		require.Equal(t, []Attachment{withoutData}, list)
+		_, err = store.ListAttachments(7, "scan")
+		require.ErrorIs(t, err, errMock)
+	})
+}
+
+// TestMockStats проверяет запросы сводок и просроченных посылок
+func TestMockStats(t *testing.T) {
+	breached := func(mock sqlmock.Sqlmock, level string) *sqlmock.ExpectedQuery {
+		sla, err := SLA(level)
+		require.NoError(t, err)
+		deadline := mockNow.Add(-sla)
+		registeredDeadline := deadline
+		if level == ServiceLevelExpress {
+			registeredDeadline = mockNow.Add(-ExpressRegisteredLimit)
+		}
+		return mock.ExpectQuery(mockSelect+"WHERE service_level = :level AND status != :delivered "+
+			"AND (created_at < :deadline OR (status = :registered AND created_at < :registered_deadline))").
+			WithArgs(sql.Named("level", level), sql.Named("delivered", ParcelStatusDelivered),
+				sql.Named("registered", ParcelStatusRegistered), sql.Named("deadline", formatTime(deadline)),
+				sql.Named("registered_deadline", formatTime(registeredDeadline)))
+	}
+	parcel := func(number int, level string) Parcel {
+		p := getTestParcel()
+		p.Number = number
+		p.ServiceLevel = level
+		p.CreatedAt = mockNow.AddDate(0, 0, -10)
+		return p
+	}
+
+	t.Run("stats", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		query := "SELECT status, COUNT(*) FROM parcel GROUP BY status"
+		mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).
+			AddRow(ParcelStatusRegistered, 2).AddRow(ParcelStatusSent, 3))
+		mock.ExpectQuery(query).WillReturnError(errMock)
+
+		stats, err := store.Stats()
+		require.NoError(t, err)
+		require.Equal(t, Stats{Total: 5, ByStatus: map[string]int{ParcelStatusRegistered: 2, ParcelStatusSent: 3}}, stats)
+		_, err = store.Stats()
+		require.ErrorIs(t, err, errMock)
+	})
+
+	t.Run("daily volumes", func(t *testing.T) {
+		store, mock := newMockStore(t, WithClock(NewManualClock(mockNow)))
+		query := "SELECT substr(created_at, 1, 10) AS day, COUNT(*) FROM parcel WHERE created_at >= :from GROUP BY day ORDER BY day"
+		from := sql.Named("from", formatTime(time.Date(2024, 2, 24, 0, 0, 0, 0, time.UTC)))
+		mock.ExpectQuery(query).WithArgs(from).WillReturnRows(sqlmock.NewRows([]string{"day", "count"}).
+			AddRow("2024-02-25", 1).AddRow("2024-03-01", 4))
+		mock.ExpectQuery(query).WithArgs(from).WillReturnError(errMock)
+
+		volumes, err := store.DailyVolumes(7)
+		require.NoError(t, err)
+		require.Equal(t, []DailyVolume{{Day: "2024-02-25", Registered: 1}, {Day: "2024-03-01", Registered: 4}}, volumes)
+		_, err = store.DailyVolumes(7)
+		require.ErrorIs(t, err, errMock)
+	})
+
+	t.Run("breached", func(t *testing.T) {
+		store, mock := newMockStore(t, WithClock(NewManualClock(mockNow)))
+		breached(mock, ServiceLevelExpress).WillReturnRows(mockParcelRows(parcel(3, ServiceLevelExpress)))
+		breached(mock, ServiceLevelStandard).WillReturnError(errMock)
+
+		res, err := store.ListBreached(ServiceLevelExpress)
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+		require.Equal(t, 3, res[0].Number)
+		_, err = store.ListBreached(ServiceLevelStandard)
+		require.ErrorIs(t, err, errMock)
+
+		_, err = store.ListBreached("почтой")
+		require.ErrorIs(t, err, ErrUnknownServiceLevel)
+	})
+
+	t.Run("overdue", func(t *testing.T) {
+		store, mock := newMockStore(t, WithClock(NewManualClock(mockNow)))
+		// уровни обслуживания перебираются в произвольном порядке
+		mock.MatchExpectationsInOrder(false)
+		breached(mock, ServiceLevelStandard).WillReturnRows(mockParcelRows(parcel(5, ServiceLevelStandard)))
+		breached(mock, ServiceLevelExpress).WillReturnRows(mockParcelRows(parcel(2, ServiceLevelExpress)))
+		breached(mock, ServiceLevelOvernight).WillReturnRows(mockParcelRows())
+
+		res, err := store.ListOverdue()
+		require.NoError(t, err)
+		require.Len(t, res, 2)
+		require.Equal(t, 2, res[0].Number)
+		require.Equal(t, 5, res[1].Number)
+	})
+
+	t.Run("health", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		query := "SELECT version FROM schema_version"
+		mock.ExpectPing()
+		mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(len(migrations)))
+		mock.ExpectPing()
+		mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(len(migrations) - 1))
+		mock.ExpectPing()
+		mock.ExpectQuery(query).WillReturnError(errMock)
+		mock.ExpectPing().WillReturnError(errMock)
+
+		ctx := context.Background()
+		require.NoError(t, store.Health(ctx))
+		require.ErrorIs(t, store.Health(ctx), ErrSchemaOutdated)
+		require.ErrorIs(t, store.Health(ctx), errMock)
+		require.ErrorIs(t, store.Health(ctx), errMock)
+	})
+}
+
+// TestMockMigrate проверяет, что Migrate применяет только недостающие
+// миграции и откатывает транзакцию неудавшейся
+func TestMockMigrate(t *testing.T) {
+	expectVersion := func(mock sqlmock.Sqlmock, version int) {
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_version (version integer not null)").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version FROM schema_version").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+		mock.ExpectCommit()
+	}
+	last := len(migrations)
+	query := naming{}.replacer().Replace(migrations[last-1])
+
+	t.Run("up to date", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		expectVersion(mock, last)
+
+		require.NoError(t, store.Migrate())
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		expectVersion(mock, last-1)
+		mock.ExpectBegin()
+		mock.ExpectExec(query).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE schema_version SET version = :version").WithArgs(sql.Named("version", last)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		require.NoError(t, store.Migrate())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		expectVersion(mock, last-1)
+		mock.ExpectBegin()
+		mock.ExpectExec(query).WillReturnError(errMock)
+		mock.ExpectRollback()
+
+		err := store.Migrate()
+		require.ErrorIs(t, err, errMock)
+		require.ErrorContains(t, err, "миграция "+strconv.Itoa(last))
+	})
+
+	t.Run("legacy version", func(t *testing.T) {
+		store, mock := newMockStore(t)
+		// версия ещё в PRAGMA user_version: переносится в schema_version
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_version (version integer not null)").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version FROM schema_version").WillReturnRows(sqlmock.NewRows([]string{"version"}))
+		mock.ExpectQuery("PRAGMA user_version").WillReturnRows(sqlmock.NewRows([]string{"user_version"}).AddRow(last))
+		mock.ExpectExec("INSERT INTO schema_version (version) VALUES (:version)").WithArgs(sql.Named("version", last)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		require.NoError(t, store.Migrate())
+	})
+}
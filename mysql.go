@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+)
+
+// mysqlMigrations — схема для MySQL/MariaDB. История миграций SQLite не
+// повторяется: первая миграция создаёт таблицу в том виде, какой она имела
+// к появлению MySQL, следующие добавляются в конец списка. Время
+// хранится в том же текстовом формате, что и в SQLite, см. timeLayout.
+// DDL в MySQL не транзакционен, поэтому миграция — одна инструкция.
+// Квитанции о регистрации через outbox пока есть только в SQLite.
+var mysqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS {parcel} (
+        number BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+        uuid VARCHAR(36) NOT NULL,
+        client BIGINT NOT NULL,
+        status VARCHAR(128) NOT NULL,
+        address TEXT NOT NULL,
+        created_at VARCHAR(24) NOT NULL,
+        service_level VARCHAR(32) NOT NULL DEFAULT 'standard',
+        cod_amount BIGINT NOT NULL DEFAULT 0,
+        cod_collected BOOLEAN NOT NULL DEFAULT FALSE,
+        cod_collected_amount BIGINT NOT NULL DEFAULT 0,
+        cod_collected_by VARCHAR(128) NOT NULL DEFAULT '',
+        cod_collected_at VARCHAR(24) NOT NULL DEFAULT '',
+        country VARCHAR(2) NOT NULL DEFAULT '',
+        postal_code VARCHAR(16) NOT NULL DEFAULT '',
+        zone VARCHAR(64) NOT NULL DEFAULT '',
+        insured BOOLEAN NOT NULL DEFAULT FALSE,
+        declared_value BIGINT NOT NULL DEFAULT 0,
+        insurance_premium BIGINT NOT NULL DEFAULT 0,
+        sent_at VARCHAR(24) NOT NULL DEFAULT '',
+        delivered_at VARCHAR(24) NOT NULL DEFAULT '',
+        UNIQUE KEY parcel_uuid_uq (uuid),
+        KEY parcel_zone_idx (zone)
+    ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	`ALTER TABLE {parcel}
+        ADD COLUMN sender_email VARCHAR(254) NOT NULL DEFAULT '',
+        ADD COLUMN tenant VARCHAR(64) NOT NULL DEFAULT '',
+        ADD COLUMN price BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE {parcel} ADD COLUMN locale VARCHAR(8) NOT NULL DEFAULT ''`,
+	// заказов в MySQL пока нет, столбец нужен для общего списка столбцов
+	`ALTER TABLE {parcel} ADD COLUMN order_id BIGINT NULL`,
+	// пункты выдачи тоже только в SQLite
+	`ALTER TABLE {parcel} ADD COLUMN pickup_point BIGINT NULL`,
+	`ALTER TABLE {parcel} ADD COLUMN updated_at VARCHAR(24) NOT NULL DEFAULT '', ADD KEY parcel_updated_idx (updated_at, number)`,
+	// время в формате timeLayout: DATE_FORMAT даёт микросекунды, лишние цифры отрезаются
+	`CREATE TRIGGER {parcel}_insert_touch BEFORE INSERT ON {parcel} FOR EACH ROW
+        SET NEW.updated_at = CONCAT(LEFT(DATE_FORMAT(UTC_TIMESTAMP(3), '%Y-%m-%dT%H:%i:%s.%f'), 23), 'Z')`,
+	`CREATE TRIGGER {parcel}_update_touch BEFORE UPDATE ON {parcel} FOR EACH ROW
+        SET NEW.updated_at = CONCAT(LEFT(DATE_FORMAT(UTC_TIMESTAMP(3), '%Y-%m-%dT%H:%i:%s.%f'), 23), 'Z')`,
+	// у TEXT в MySQL нет значения по умолчанию, метаданные всегда передаются при добавлении
+	`ALTER TABLE {parcel} ADD COLUMN metadata TEXT NULL`,
+	`ALTER TABLE {parcel} ADD COLUMN recipient_phone VARCHAR(16) NOT NULL DEFAULT ''`,
+	`ALTER TABLE {parcel} ADD COLUMN weight BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE {parcel} ADD COLUMN contents VARCHAR(64) NOT NULL DEFAULT ''`,
+	`ALTER TABLE {parcel} ADD COLUMN promo_code VARCHAR(32) NOT NULL DEFAULT '', ADD COLUMN discount BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE {parcel} ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'RUB'`,
+	`ALTER TABLE {parcel} ADD COLUMN requires_refrigeration BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE {parcel} ADD COLUMN age_verification BOOLEAN NOT NULL DEFAULT FALSE`,
+}
+
+// OpenMySQL открывает БД MySQL/MariaDB по DSN драйвера go-sql-driver/mysql.
+// RowsAffected считает найденные, а не изменённые строки, как в SQLite,
+// иначе обновление тем же значением выглядело бы как отсутствие посылки.
+// Пул настраивается по DefaultMySQLPool.
+func OpenMySQL(dsn string) (*sql.DB, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientFoundRows = true
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db := sql.OpenDB(connector)
+	DefaultMySQLPool.Apply(db)
+
+	return db, nil
+}
+
+// MySQLParcelStore реализует ParcelStorage поверх MySQL/MariaDB.
+// Драйвер не поддерживает именованные параметры, поэтому запросы
+// используют позиционные ?.
+type MySQLParcelStore struct {
+	db        storeDB
+	ids       IDGenerator
+	clock     Clock
+	createdAt createdAtWindow
+}
+
+// NewMySQLParcelStore создаёт хранилище MySQL. Опции те же, что у
+// NewParcelStore; префикс и схема (база данных MySQL) тоже поддерживаются.
+func NewMySQLParcelStore(db *sql.DB, opts ...StoreOption) MySQLParcelStore {
+	s := NewParcelStore(db, opts...)
+
+	return MySQLParcelStore{db: s.db, ids: s.ids, clock: s.clock, createdAt: s.createdAt}
+}
+
+// now возвращает текущее время по часам хранилища, см. WithClock
+func (s MySQLParcelStore) now() time.Time {
+	return ParcelStore{clock: s.clock}.now()
+}
+
+// Migrate применяет к БД MySQL все ещё не применённые миграции
+func (s MySQLParcelStore) Migrate() error {
+	if _, err := s.db.Exec("CREATE TABLE IF NOT EXISTS {schema_version} (version INT NOT NULL) ENGINE=InnoDB"); err != nil {
+		return err
+	}
+
+	var version int
+	err := s.db.QueryRow("SELECT version FROM {schema_version}").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = s.db.Exec("INSERT INTO {schema_version} (version) VALUES (0)")
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(mysqlMigrations); i++ {
+		if _, err := s.db.Exec(mysqlMigrations[i]); err != nil {
+			return fmt.Errorf("миграция %d: %w", i+1, err)
+		}
+		if _, err := s.db.Exec("UPDATE {schema_version} SET version = ?", i+1); err != nil {
+			return fmt.Errorf("миграция %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// Add добавляет посылку и возвращает её номер.
+//
+// Deprecated: используйте AddContext.
+func (s MySQLParcelStore) Add(p Parcel) (int, error) {
+	number, err := s.AddContext(context.Background(), p)
+
+	return number, v1Error(err)
+}
+
+// AddContext добавляет посылку и возвращает её номер
+func (s MySQLParcelStore) AddContext(ctx context.Context, p Parcel, opts ...CallOption) (int, error) {
+	ctx, _, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	number, err := s.add(ctx, p)
+	if err != nil {
+		return 0, &StoreError{Op: OpAdd, Err: err}
+	}
+
+	return number, nil
+}
+
+func (s MySQLParcelStore) add(ctx context.Context, p Parcel) (int, error) {
+	if err := s.createdAt.apply(&p, s.now()); err != nil {
+		return 0, err
+	}
+	p.applyDefaults()
+	if err := p.Validate(); err != nil {
+		return 0, err
+	}
+
+	p.InsurancePremium = 0
+	if p.Insured {
+		premium, err := InsurancePremium(p.DeclaredValue)
+		if err != nil {
+			return 0, err
+		}
+		p.InsurancePremium = premium
+	}
+
+	p.Country = strings.ToUpper(p.Country)
+	if p.UUID == "" {
+		p.UUID = uuid.NewString()
+	}
+
+	number, err := s.ids.NextID()
+	if err != nil {
+		return 0, err
+	}
+
+	// при нулевом номере его назначает AUTO_INCREMENT
+	p.Number = number
+
+	return mysqlDialect.insertParcel(ctx, s.db, p)
+}
+
+// Get возвращает посылку по номеру.
+//
+// Deprecated: используйте GetContext.
+func (s MySQLParcelStore) Get(number int) (Parcel, error) {
+	p, err := s.GetContext(context.Background(), number)
+
+	return p, v1Error(err)
+}
+
+// GetContext возвращает посылку по номеру
+func (s MySQLParcelStore) GetContext(ctx context.Context, number int, opts ...CallOption) (Parcel, error) {
+	ctx, _, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	row := s.db.QueryRowContext(ctx, parcelSelect+"WHERE number = ?", number)
+	p, err := scanParcel(row)
+
+	return p, storeError(OpGet, number, err)
+}
+
+// GetByUUID возвращает посылку по публичному идентификатору.
+//
+// Deprecated: используйте GetByUUIDContext.
+func (s MySQLParcelStore) GetByUUID(id string) (Parcel, error) {
+	p, err := s.GetByUUIDContext(context.Background(), id)
+
+	return p, v1Error(err)
+}
+
+// GetByUUIDContext возвращает посылку по публичному идентификатору
+func (s MySQLParcelStore) GetByUUIDContext(ctx context.Context, id string, opts ...CallOption) (Parcel, error) {
+	ctx, _, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	row := s.db.QueryRowContext(ctx, parcelSelect+"WHERE uuid = ?", id)
+	p, err := scanParcel(row)
+
+	return p, storeError(OpGetByUUID, 0, err)
+}
+
+// GetByClient возвращает посылки клиента.
+//
+// Deprecated: используйте GetByClientContext.
+func (s MySQLParcelStore) GetByClient(client int) ([]Parcel, error) {
+	parcels, err := s.GetByClientContext(context.Background(), client)
+
+	return parcels, v1Error(err)
+}
+
+// GetByClientContext возвращает посылки клиента
+func (s MySQLParcelStore) GetByClientContext(ctx context.Context, client int, opts ...CallOption) ([]Parcel, error) {
+	ctx, _, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, parcelSelect+"WHERE client = ? ORDER BY number", client)
+	if err != nil {
+		return nil, storeError(OpGetByClient, 0, err)
+	}
+
+	parcels, err := scanParcels(rows)
+	if err != nil {
+		return nil, storeError(OpGetByClient, 0, err)
+	}
+
+	return parcels, nil
+}
+
+// SetStatus устанавливает статус посылки без проверки графа переходов.
+//
+// Deprecated: используйте SetStatusContext.
+func (s MySQLParcelStore) SetStatus(number int, status string) error {
+	return v1Error(s.SetStatusContext(context.Background(), number, status))
+}
+
+// SetStatusContext устанавливает статус посылки без проверки графа переходов
+func (s MySQLParcelStore) SetStatusContext(ctx context.Context, number int, status string, opts ...CallOption) error {
+	ctx, o, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	if !parcelStatuses[status] {
+		return storeError(OpSetStatus, number, fmt.Errorf("%w: неизвестный статус %q", ErrInvalidParcel, status))
+	}
+
+	set, args := mysqlStatusSet(status, o.at)
+	_, err := s.db.ExecContext(ctx, "UPDATE {parcel} SET "+set+" WHERE number = ?", append(args, number)...)
+
+	return storeError(OpSetStatus, number, err)
+}
+
+// TransitionStatus меняет статус посылки с from на to по графу переходов.
+//
+// Deprecated: используйте TransitionStatusContext.
+func (s MySQLParcelStore) TransitionStatus(number int, from string, to string) error {
+	return v1Error(s.TransitionStatusContext(context.Background(), number, from, to))
+}
+
+// TransitionStatusContext меняет статус посылки с from на to по графу
+// переходов по умолчанию; собственных статусов арендаторов в MySQL пока
+// нет. Если статус посылки уже не from, возвращает ErrStatusChanged.
+func (s MySQLParcelStore) TransitionStatusContext(ctx context.Context, number int, from string, to string, opts ...CallOption) error {
+	ctx, o, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	return storeError(OpTransitionStatus, number, s.transitionStatus(ctx, number, from, to, o.at))
+}
+
+func (s MySQLParcelStore) transitionStatus(ctx context.Context, number int, from string, to string, at time.Time) error {
+	if !defaultStatusGraph.Allows(from, to) {
+		return fmt.Errorf("%w: %s → %s", ErrInvalidTransition, from, to)
+	}
+
+	set, args := mysqlStatusSet(to, at)
+	res, err := s.db.ExecContext(ctx, "UPDATE {parcel} SET "+set+" WHERE number = ? AND status = ?", append(args, number, from)...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		if _, err := s.GetContext(ctx, number); err != nil {
+			return err
+		}
+		return ErrStatusChanged
+	}
+
+	return nil
+}
+
+// SetAddress меняет адрес посылки в статусе registered. Посылки в другом
+// статусе и отсутствие посылки не считаются ошибкой.
+//
+// Deprecated: используйте SetAddressContext.
+func (s MySQLParcelStore) SetAddress(number int, address string) error {
+	_, err := s.setAddress(context.Background(), number, address)
+
+	return err
+}
+
+// SetAddressContext меняет адрес посылки в статусе registered
+func (s MySQLParcelStore) SetAddressContext(ctx context.Context, number int, address string, opts ...CallOption) error {
+	ctx, _, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	res, err := s.setAddress(ctx, number, address)
+	if err == nil {
+		err = s.checkRegisteredAffected(ctx, number, res)
+	}
+
+	return storeError(OpSetAddress, number, err)
+}
+
+func (s MySQLParcelStore) setAddress(ctx context.Context, number int, address string) (sql.Result, error) {
+	if strings.TrimSpace(address) == "" {
+		return nil, fmt.Errorf("%w: пустой адрес", ErrInvalidParcel)
+	}
+
+	// менять адрес можно только если значение статуса registered
+	return s.db.ExecContext(ctx, "UPDATE {parcel} SET address = ? WHERE number = ? AND status = ?",
+		address, number, ParcelStatusRegistered)
+}
+
+// Delete удаляет посылку в статусе registered. Посылки в другом статусе
+// и отсутствие посылки не считаются ошибкой.
+//
+// Deprecated: используйте DeleteContext.
+func (s MySQLParcelStore) Delete(number int) error {
+	_, err := s.delete(context.Background(), number)
+
+	return err
+}
+
+// DeleteContext удаляет посылку в статусе registered
+func (s MySQLParcelStore) DeleteContext(ctx context.Context, number int, opts ...CallOption) error {
+	ctx, _, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	res, err := s.delete(ctx, number)
+	if err == nil {
+		err = s.checkRegisteredAffected(ctx, number, res)
+	}
+
+	return storeError(OpDelete, number, err)
+}
+
+func (s MySQLParcelStore) delete(ctx context.Context, number int) (sql.Result, error) {
+	// удалять строку можно только если значение статуса registered
+	return s.db.ExecContext(ctx, "DELETE FROM {parcel} WHERE number = ? AND status = ?", number, ParcelStatusRegistered)
+}
+
+// checkRegisteredAffected — ParcelStore.checkRegisteredAffected для MySQL
+func (s MySQLParcelStore) checkRegisteredAffected(ctx context.Context, number int, res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	p, err := s.GetContext(ctx, number)
+	if err != nil {
+		return err
+	}
+	if p.Status != ParcelStatusRegistered {
+		return ErrNotRegistered
+	}
+
+	return sql.ErrNoRows
+}
+
+// mysqlStatusSet возвращает часть UPDATE, меняющую статус и проставляющую
+// время отправки или доставки, вместе с её параметрами. В отличие от
+// statusTimesSet с позиционными параметрами проще выбрать столбец в Go.
+func mysqlStatusSet(status string, at time.Time) (string, []any) {
+	now := formatTime(at)
+	switch status {
+	case ParcelStatusSent:
+		return "status = ?, sent_at = ?", []any{status, now}
+	case ParcelStatusDelivered:
+		return "status = ?, delivered_at = ?", []any{status, now}
+	default:
+		return "status = ?", []any{status}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// identifierRe — допустимые схема и префикс имён таблиц. Идентификаторы
+// подставляются в текст запроса, поэтому параметрами их не передать,
+// и всё, что не проходит проверку, отклоняется.
+var identifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// storeTables — таблицы хранилища. В тексте запросов на таблицу ссылаются
+// шаблоном {имя}, вместо которого подставляется имя со схемой и префиксом.
+var storeTables = []string{
+	"parcel",
+	"customs_item",
+	"zone",
+	"insurance_claim",
+	"attachment",
+	"damage_report",
+	"outbox",
+	"tenant_status",
+	"status_transition",
+	"note",
+	"customer_order",
+	"delivery_assignment",
+	"pickup_point",
+	"pickup_arrival",
+	"anomaly",
+	"dead_letter",
+	"carrier_shipment",
+	"address_redirect",
+	"audit_log",
+	"feature_flag",
+	"idempotency_key",
+	"courier_pickup",
+	"courier_pickup_parcel",
+	"delivery_window",
+	"parcel_item",
+	"content_rule",
+	"invoice",
+	"invoice_line",
+	"promo_code",
+	"volume_discount",
+	"partner",
+	"partner_usage",
+	"number_reservation",
+	"parcel_change",
+	"operation_review",
+	"address_change",
+	"vehicle",
+	"load_plan",
+	"load_plan_parcel",
+	"temperature_reading",
+	"delivery_confirmation",
+	"delivery_instructions",
+	"tracking_subscription",
+	"webhook_endpoint",
+	"webhook_event",
+	"tracking_view",
+	"projection_cursor",
+	"daily_stats",
+	"weight_measurement",
+	"shipment",
+	"shipment_parcel",
+	"schema_version",
+}
+
+// naming — размещение таблиц хранилища в БД. Schema в SQLite — имя
+// присоединённой через ATTACH базы, которая должна быть присоединена
+// на каждом соединении пула.
+type naming struct {
+	schema string
+	prefix string
+}
+
+// replacer возвращает подстановку шаблонов в запросах. Кроме имён таблиц
+// доступны {schema} и {prefix}: SQLite не допускает схему в ON индекса
+// и в REFERENCES, поэтому миграции собирают такие имена сами.
+func (n naming) replacer() *strings.Replacer {
+	schema := ""
+	if n.schema != "" {
+		schema = n.schema + "."
+	}
+
+	pairs := []string{"{schema}", schema, "{prefix}", n.prefix}
+	for _, table := range storeTables {
+		pairs = append(pairs, "{"+table+"}", schema+n.prefix+table)
+	}
+
+	return strings.NewReplacer(pairs...)
+}
+
+// schemaName возвращает имя схемы для функций PRAGMA, по умолчанию main
+func (n naming) schemaName() string {
+	if n.schema == "" {
+		return "main"
+	}
+
+	return n.schema
+}
+
+// mustIdentifier паникует на недопустимом идентификаторе: схема и префикс
+// задаются конфигурацией при запуске, а не данными запроса. Пустое имя
+// означает размещение по умолчанию.
+func mustIdentifier(kind string, name string) {
+	if name != "" && !identifierRe.MatchString(name) {
+		panic(fmt.Sprintf("недопустимое имя %s: %q", kind, name))
+	}
+}
+
+// storeDB выполняет запросы хранилища, подставляя имена таблиц
+// и передавая задержку и ошибки запросов в shedder, если он задан.
+// Ошибка QueryRow видна только при Scan, поэтому для него учитывается
+// лишь задержка. Нарушения ограничений в Exec приводятся к ErrConstraint
+// и его частным случаям, см. classifyDBError. Включённый tracer пишет
+// каждый запрос в журнал, см. StatementTracer.
+type storeDB struct {
+	db      *sql.DB
+	names   *strings.Replacer
+	shedder *LoadShedder
+	tracer  *StatementTracer
+}
+
+func (d storeDB) Exec(query string, args ...any) (sql.Result, error) {
+	return d.ExecContext(context.Background(), query, args...)
+}
+
+func (d storeDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query = d.names.Replace(query)
+	trace := d.tracer.begin(ctx, d.db.Query, query, args)
+	start := time.Now()
+	res, err := d.db.ExecContext(ctx, query, args...)
+	d.observe(start, err)
+	trace.finish(err)
+
+	return res, classifyDBError(err)
+}
+
+func (d storeDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return d.QueryContext(context.Background(), query, args...)
+}
+
+func (d storeDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query = d.names.Replace(query)
+	trace := d.tracer.begin(ctx, d.db.Query, query, args)
+	start := time.Now()
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	d.observe(start, err)
+	trace.finish(err)
+
+	return rows, err
+}
+
+func (d storeDB) QueryRow(query string, args ...any) *sql.Row {
+	return d.QueryRowContext(context.Background(), query, args...)
+}
+
+func (d storeDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query = d.names.Replace(query)
+	trace := d.tracer.begin(ctx, d.db.Query, query, args)
+	start := time.Now()
+	row := d.db.QueryRowContext(ctx, query, args...)
+	d.observe(start, nil)
+	trace.finish(nil)
+
+	return row
+}
+
+func (d storeDB) PingContext(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+func (d storeDB) Begin() (storeTx, error) {
+	return d.BeginTx(context.Background())
+}
+
+// BeginTx начинает транзакцию, запросы которой отменяются вместе с ctx
+func (d storeDB) BeginTx(ctx context.Context) (storeTx, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storeTx{}, err
+	}
+
+	return storeTx{tx: tx, ctx: ctx, names: d.names, tracer: d.tracer}, nil
+}
+
+// storeTx — транзакция хранилища с той же подстановкой имён таблиц.
+// План запроса для трассировки запрашивается внутри транзакции: вне её
+// не видны созданные в ней таблицы, а запись может быть заблокирована.
+type storeTx struct {
+	tx     *sql.Tx
+	ctx    context.Context
+	names  *strings.Replacer
+	tracer *StatementTracer
+}
+
+func (t storeTx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.ExecContext(t.ctx, query, args...)
+}
+
+func (t storeTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query = t.names.Replace(query)
+	trace := t.tracer.begin(ctx, t.tx.Query, query, args)
+	res, err := t.tx.ExecContext(ctx, query, args...)
+	trace.finish(err)
+
+	return res, classifyDBError(err)
+}
+
+func (t storeTx) Query(query string, args ...any) (*sql.Rows, error) {
+	query = t.names.Replace(query)
+	trace := t.tracer.begin(t.ctx, t.tx.Query, query, args)
+	rows, err := t.tx.Query(query, args...)
+	trace.finish(err)
+
+	return rows, err
+}
+
+func (t storeTx) QueryRow(query string, args ...any) *sql.Row {
+	return t.QueryRowContext(t.ctx, query, args...)
+}
+
+func (t storeTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query = t.names.Replace(query)
+	trace := t.tracer.begin(ctx, t.tx.Query, query, args)
+	row := t.tx.QueryRowContext(ctx, query, args...)
+	trace.finish(nil)
+
+	return row
+}
+
+func (t storeTx) Commit() error {
+	return classifyDBError(t.tx.Commit())
+}
+
+func (t storeTx) Rollback() error {
+	return t.tx.Rollback()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestTablePrefix проверяет, что хранилища с разными префиксами в одной БД
+// не видят данные друг друга
+func TestTablePrefix(t *testing.T) {
+	// prepare
+	db := openTestDB(t)
+	staging := NewParcelStore(db, WithTablePrefix("staging_"))
+	require.NoError(t, staging.Migrate())
+	require.NoError(t, staging.Migrate())
+
+	production := NewParcelStore(db)
+
+	// add
+	id, err := staging.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// check
+	_, err = staging.Get(id)
+	require.NoError(t, err)
+
+	_, err = production.Get(id)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM staging_parcel").Scan(&count))
+	require.Equal(t, 1, count)
+}
+
+// TestSchema проверяет размещение таблиц в присоединённой БД
+func TestSchema(t *testing.T) {
+	// prepare
+	db := openTestDB(t)
+	// ATTACH действует на одно соединение
+	db.SetMaxOpenConns(1)
+	_, err := db.Exec("ATTACH DATABASE :path AS tracker",
+		sql.Named("path", filepath.Join(t.TempDir(), "schema.db")))
+	require.NoError(t, err)
+
+	store := NewParcelStore(db, WithSchema("tracker"))
+	require.NoError(t, store.Migrate())
+
+	// add
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// check
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, id, stored.Number)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM tracker.parcel").Scan(&count))
+	require.Equal(t, 1, count)
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM main.parcel").Scan(&count))
+	require.Equal(t, 0, count)
+}
+
+// TestInvalidIdentifier проверяет отказ от небезопасных имён
+func TestInvalidIdentifier(t *testing.T) {
+	require.Panics(t, func() { WithTablePrefix("x; DROP TABLE parcel; --") })
+	require.Panics(t, func() { WithSchema("1tracker") })
+	require.NotPanics(t, func() { WithSchema("") })
+}
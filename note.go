@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// NoteInternal — заметка видна только сотрудникам
+	NoteInternal = "internal"
+	// NoteCustomer — заметка видна и клиенту в публичном отслеживании
+	NoteCustomer = "customer"
+)
+
+var ErrInvalidNote = errors.New("некорректная заметка")
+
+// Note — заметка поддержки к посылке, например запись о звонке клиента
+type Note struct {
+	ID         int
+	Parcel     int
+	Author     string
+	Text       string
+	Visibility string
+	CreatedAt  time.Time
+}
+
+// Validate проверяет заметку перед сохранением
+func (n Note) Validate() error {
+	switch {
+	case n.Author == "":
+		return fmt.Errorf("%w: не указан автор", ErrInvalidNote)
+	case strings.TrimSpace(n.Text) == "":
+		return fmt.Errorf("%w: пустой текст", ErrInvalidNote)
+	case n.Visibility != NoteInternal && n.Visibility != NoteCustomer:
+		return fmt.Errorf("%w: неизвестная видимость %q", ErrInvalidNote, n.Visibility)
+	}
+
+	return nil
+}
+
+// AddNote добавляет заметку к посылке, время проставляется здесь
+func (s ParcelStore) AddNote(n Note) (int, error) {
+	if err := n.Validate(); err != nil {
+		return 0, err
+	}
+
+	res, err := s.db.Exec("INSERT INTO {note} (parcel, author, text, visibility, created_at) "+
+		"VALUES (:parcel, :author, :text, :visibility, :created_at)",
+		sql.Named("parcel", n.Parcel),
+		sql.Named("author", n.Author),
+		sql.Named("text", n.Text),
+		sql.Named("visibility", n.Visibility),
+		sql.Named("created_at", formatTime(s.now())))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// ListNotes возвращает заметки посылки в порядке добавления. Пустая
+// видимость возвращает все заметки, иначе только с указанной видимостью.
+func (s ParcelStore) ListNotes(number int, visibility string) ([]Note, error) {
+	rows, err := s.db.Query("SELECT id, parcel, author, text, visibility, created_at "+
+		"FROM {note} WHERE parcel = :parcel AND (:visibility = '' OR visibility = :visibility) ORDER BY id",
+		sql.Named("parcel", number),
+		sql.Named("visibility", visibility))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Note
+	for rows.Next() {
+		n := Note{}
+		err := rows.Scan(&n.ID, &n.Parcel, &n.Author, &n.Text, &n.Visibility, scanTime(&n.CreatedAt))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAddListNotes проверяет добавление заметок и отбор по видимости
+func TestAddListNotes(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// add
+	_, err = store.AddNote(Note{Parcel: number, Author: "support", Text: "клиент просил перезвонить", Visibility: NoteInternal})
+	require.NoError(t, err)
+	_, err = store.AddNote(Note{Parcel: number, Author: "support", Text: "доставка перенесена на завтра", Visibility: NoteCustomer})
+	require.NoError(t, err)
+
+	// check
+	notes, err := store.ListNotes(number, "")
+	require.NoError(t, err)
+	require.Len(t, notes, 2)
+	require.Equal(t, "клиент просил перезвонить", notes[0].Text)
+	require.False(t, notes[0].CreatedAt.IsZero())
+
+	notes, err = store.ListNotes(number, NoteCustomer)
+	require.NoError(t, err)
+	require.Len(t, notes, 1)
+	require.Equal(t, NoteCustomer, notes[0].Visibility)
+
+	// заметки удаляются вместе с посылкой
+	require.NoError(t, store.Delete(number))
+	notes, err = store.ListNotes(number, "")
+	require.NoError(t, err)
+	require.Empty(t, notes)
+}
+
+// TestAddNoteInvalid проверяет отказ от некорректных заметок
+func TestAddNoteInvalid(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// check
+	for _, n := range []Note{
+		{Parcel: number, Text: "x", Visibility: NoteInternal},
+		{Parcel: number, Author: "support", Text: " ", Visibility: NoteInternal},
+		{Parcel: number, Author: "support", Text: "x", Visibility: "public"},
+	} {
+		_, err := store.AddNote(n)
+		require.ErrorIs(t, err, ErrInvalidNote)
+	}
+
+	// заметка к несуществующей посылке нарушает внешний ключ
+	_, err = store.AddNote(Note{Parcel: number + 1, Author: "support", Text: "x", Visibility: NoteInternal})
+	require.Error(t, err)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// Notification — уведомление, готовое к отправке
+type Notification struct {
+	// Channel — канал доставки, например email
+	Channel string `json:"channel"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	// DedupKey — ключ сообщения outbox, одинаковый при всех повторах
+	// отправки; проставляется при передаче и в сообщении не хранится
+	DedupKey string `json:"-"`
+}
+
+// Notifier отправляет уведомления. Отправка должна быть безопасна
+// к повтору: после сбоя outbox передаст то же уведомление ещё раз
+// с тем же DedupKey, и его нужно передать получателю для дедупликации.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// ChannelNotifier передаёт уведомление отправителю его канала, например
+// письма — SMTPNotifier, а SMS — шлюзу встраивающего приложения
+type ChannelNotifier map[string]Notifier
+
+func (c ChannelNotifier) Notify(ctx context.Context, msg Notification) error {
+	n, ok := c[msg.Channel]
+	if !ok {
+		return fmt.Errorf("нет отправителя для канала %q", msg.Channel)
+	}
+
+	return n.Notify(ctx, msg)
+}
+
+// SMTPNotifier отправляет уведомления канала email через SMTP-сервер
+type SMTPNotifier struct {
+	// Addr — адрес SMTP-сервера вида host:port
+	Addr string
+	From string
+	// Auth — аутентификация на сервере, nil — без аутентификации
+	Auth smtp.Auth
+}
+
+func (n SMTPNotifier) Notify(ctx context.Context, msg Notification) error {
+	if msg.Channel != ChannelEmail {
+		return fmt.Errorf("канал %q не поддерживается SMTP", msg.Channel)
+	}
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("недопустимый перевод строки в заголовке письма для %q", msg.To)
+	}
+
+	body := "From: " + n.From + "\r\n" +
+		"To: " + msg.To + "\r\n" +
+		"Subject: " + msg.Subject + "\r\n" +
+		messageID(msg.DedupKey) +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + msg.Body
+
+	// net/smtp не принимает контекст, таймаут задаётся сервером
+	return smtp.SendMail(n.Addr, n.Auth, n.From, []string{msg.To}, []byte(body))
+}
+
+// messageID возвращает заголовок Message-ID по ключу дедупликации:
+// почтовые серверы и клиенты отбрасывают письма с уже виденным
+// Message-ID. Без ключа заголовок назначит сервер.
+func messageID(dedupKey string) string {
+	if dedupKey == "" || strings.ContainsAny(dedupKey, "\r\n<>@") {
+		return ""
+	}
+
+	return "Message-ID: <" + dedupKey + "@tracker>\r\n"
+}
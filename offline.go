@@ -0,0 +1,251 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Виды операций приложения курьера
+const (
+	// OperationScan — курьер отсканировал посылку и забрал её в доставку
+	OperationScan = "scan"
+	// OperationDeliver — курьер подтвердил вручение посылки
+	OperationDeliver = "deliver"
+)
+
+// Итоги операций ApplyOperations
+const (
+	// OutcomeApplied — операция применена
+	OutcomeApplied = "applied"
+	// OutcomeSkipped — посылка уже в том статусе или дальше, например
+	// операцию прислали повторно
+	OutcomeSkipped = "skipped"
+	// OutcomeConflict — операция противоречит состоянию посылки
+	OutcomeConflict = "conflict"
+	// OutcomeRejected — операция некорректна или посылки нет
+	OutcomeRejected = "rejected"
+	// OutcomeParked — конфликт отложен на ручной разбор, см. ConflictManualReview
+	OutcomeParked = "parked"
+)
+
+// MaxOperationsBatch — сколько операций принимается за один вызов
+const MaxOperationsBatch = 1000
+
+var ErrTooManyOperations = errors.New("слишком много операций за раз")
+
+// operationStatus — статус, в который операция переводит посылку
+var operationStatus = map[string]string{
+	OperationScan:    ParcelStatusSent,
+	OperationDeliver: ParcelStatusDelivered,
+}
+
+// Operation — действие курьера, записанное приложением без связи
+type Operation struct {
+	// ID — идентификатор операции в приложении, возвращается в итоге
+	ID      string    `json:"id"`
+	Kind    string    `json:"kind"`
+	Code    string    `json:"code"`
+	Courier int       `json:"courier"`
+	At      time.Time `json:"at"`
+}
+
+// OperationOutcome — итог одной операции
+type OperationOutcome struct {
+	ID     string `json:"id"`
+	Result string `json:"result"`
+	// Status — статус посылки после операции
+	Status string `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ApplyOperations применяет операции, записанные приложением курьера без
+// связи, в порядке их времени, при равном времени — в порядке списка.
+// Статус посылки доводится до статуса операции по графу переходов
+// арендатора, время переходов — время операции. Операции над посылкой,
+// назначенной другому курьеру, и вручение без подтверждения доставки,
+// см. checkDeliveryConfirmed, — всегда конфликт. Остальные конфликты
+// решаются по политике хранилища, см. WithConflictPolicy; по умолчанию
+// действует ConflictStatusPrecedence:
+//   - посылка уже в статусе операции или дальше по графу — операция
+//     пропускается, так повторная выгрузка очереди ничего не меняет;
+//   - статус операции из статуса посылки недостижим, например посылку
+//     отменили или потеряли, — конфликт;
+//   - операция раньше последней смены статуса посылки — конфликт:
+//     статус уже изменили по более свежим данным.
+//
+// Итоги возвращаются в порядке исходного списка. Ошибка возвращается
+// только при сбое БД; уже применённые операции остаются применёнными.
+func (s ParcelStore) ApplyOperations(ops []Operation) ([]OperationOutcome, error) {
+	if len(ops) > MaxOperationsBatch {
+		return nil, fmt.Errorf("%w: больше %d", ErrTooManyOperations, MaxOperationsBatch)
+	}
+
+	order := make([]int, len(ops))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return ops[order[a]].At.Before(ops[order[b]].At) })
+
+	res := make([]OperationOutcome, len(ops))
+	for _, i := range order {
+		out, err := s.applyOperation(ops[i])
+		if err != nil {
+			return nil, fmt.Errorf("операция %q: %w", ops[i].ID, err)
+		}
+		out.ID = ops[i].ID
+		res[i] = out
+	}
+
+	return res, nil
+}
+
+// applyOperation применяет одну операцию
+func (s ParcelStore) applyOperation(op Operation) (OperationOutcome, error) {
+	target, ok := operationStatus[op.Kind]
+	if !ok {
+		return OperationOutcome{Result: OutcomeRejected, Reason: fmt.Sprintf("неизвестная операция %q", op.Kind)}, nil
+	}
+	if op.At.IsZero() || op.Courier <= 0 {
+		return OperationOutcome{Result: OutcomeRejected, Reason: "не указаны время или курьер"}, nil
+	}
+
+	p, err := s.GetByUUID(strings.ToLower(op.Code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return OperationOutcome{Result: OutcomeRejected, Reason: "посылка не найдена"}, nil
+	}
+	if err != nil {
+		return OperationOutcome{}, err
+	}
+
+	courier, err := s.assignedCourier(p.Number)
+	if err != nil {
+		return OperationOutcome{}, err
+	}
+	if courier != 0 && courier != op.Courier {
+		return s.conflict(op, p, "посылка назначена другому курьеру")
+	}
+	if p.Status == target {
+		return OperationOutcome{Result: OutcomeSkipped, Status: p.Status}, nil
+	}
+
+	graph, err := s.StatusGraph(p.Tenant)
+	if err != nil {
+		return OperationOutcome{}, err
+	}
+	path := graph.Path(p.Status, target)
+
+	force := false
+	if s.conflictPolicy == ConflictLastWriteWins {
+		if op.At.Before(lastStatusTime(p)) {
+			return OperationOutcome{Result: OutcomeSkipped, Status: p.Status, Reason: "статус изменён позже операции"}, nil
+		}
+		// последняя запись побеждает и против графа переходов
+		force = path == nil
+	} else {
+		if graph.Path(target, p.Status) != nil {
+			return OperationOutcome{Result: OutcomeSkipped, Status: p.Status}, nil
+		}
+		if path == nil {
+			return s.conflict(op, p, fmt.Sprintf("из статуса %s нет пути в %s", p.Status, target))
+		}
+		if op.At.Before(lastStatusTime(p)) {
+			return s.conflict(op, p, "статус изменён позже операции")
+		}
+	}
+
+	// вручение без подписи или проверки документа — конфликт, а не сбой:
+	// проверка до первого перехода, чтобы не применить операцию частично
+	if target == ParcelStatusDelivered {
+		err := s.checkDeliveryConfirmed(p)
+		if isDeliveryUnconfirmed(err) {
+			return s.conflict(op, p, err.Error())
+		}
+		if err != nil {
+			return OperationOutcome{}, err
+		}
+	}
+
+	if force {
+		if err := s.forceOperationStatus(p.Number, target, op.At); err != nil {
+			return OperationOutcome{}, err
+		}
+		return OperationOutcome{Result: OutcomeApplied, Status: target}, nil
+	}
+
+	from := p.Status
+	for _, to := range path {
+		err := s.transitionStatusAt(p.Number, from, to, op.At)
+		if errors.Is(err, ErrStatusChanged) {
+			p.Status = from
+			return s.conflict(op, p, err.Error())
+		}
+		if err != nil {
+			return OperationOutcome{}, err
+		}
+		from = to
+	}
+
+	return OperationOutcome{Result: OutcomeApplied, Status: target}, nil
+}
+
+// lastStatusTime возвращает время последней известной смены статуса посылки
+func lastStatusTime(p Parcel) time.Time {
+	last := p.CreatedAt
+	for _, t := range []time.Time{p.SentAt, p.DeliveredAt} {
+		if t.After(last) {
+			last = t
+		}
+	}
+
+	return last
+}
+
+// assignedCourier возвращает курьера, которому назначена посылка, 0 — не назначена
+func (s ParcelStore) assignedCourier(number int) (int, error) {
+	var courier int
+	err := s.db.QueryRow("SELECT courier FROM {delivery_assignment} WHERE parcel = :parcel",
+		sql.Named("parcel", number)).Scan(&courier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+
+	return courier, err
+}
+
+// maxOperationsBody — наибольший размер тела запроса с операциями
+const maxOperationsBody = 1 << 20
+
+// NewOperationsHandler возвращает обработчик POST /courier/operations,
+// принимающий очередь операций приложения курьера в JSON
+// {"operations": [...]} и отвечающий итогами {"outcomes": [...]}
+func NewOperationsHandler(store ParcelStore, errLog *ErrorLog) http.Handler {
+	h := AdminHandler{store: store, errors: errLog}
+
+	return h.postOnly(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Operations []Operation `json:"operations"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxOperationsBody)).Decode(&req); err != nil {
+			http.Error(w, "некорректный JSON", http.StatusBadRequest)
+			return
+		}
+
+		outcomes, err := store.ForRequest(r.Context()).ApplyOperations(req.Operations)
+		if errors.Is(err, ErrTooManyOperations) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		writeJSON(w, map[string]any{"outcomes": outcomes})
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// outcomeResults возвращает результаты итогов по порядку
+func outcomeResults(outcomes []OperationOutcome) []string {
+	var res []string
+	for _, o := range outcomes {
+		res = append(res, o.ID+" "+o.Result)
+	}
+
+	return res
+}
+
+// TestApplyOperations проверяет порядок применения и правила конфликтов
+func TestApplyOperations(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	add := func() Parcel {
+		p := getTestParcel()
+		number, err := store.Add(p)
+		require.NoError(t, err)
+		p.Number = number
+		return p
+	}
+	delivered, cancelled, other, skipped := add(), add(), add(), add()
+	require.NoError(t, store.TransitionStatus(cancelled.Number, ParcelStatusRegistered, ParcelStatusCancelled))
+	require.NoError(t, store.AssignCourier(Assignment{Parcel: other.Number, Courier: 2, Date: time.Now()}))
+	require.NoError(t, store.TransitionStatus(skipped.Number, ParcelStatusRegistered, ParcelStatusSent))
+
+	at := time.Now().Add(time.Minute).UTC().Truncate(time.Millisecond)
+	ops := []Operation{
+		// вручение записано после сканирования, но пришло в очереди раньше
+		{ID: "deliver", Kind: OperationDeliver, Code: delivered.UUID, Courier: 1, At: at.Add(time.Hour)},
+		{ID: "scan", Kind: OperationScan, Code: delivered.UUID, Courier: 1, At: at},
+		{ID: "cancelled", Kind: OperationDeliver, Code: cancelled.UUID, Courier: 1, At: at},
+		{ID: "other", Kind: OperationScan, Code: other.UUID, Courier: 1, At: at},
+		{ID: "skipped", Kind: OperationScan, Code: skipped.UUID, Courier: 1, At: at},
+		{ID: "unknown", Kind: OperationScan, Code: "no-such-code", Courier: 1, At: at},
+		{ID: "bad", Kind: "teleport", Code: delivered.UUID, Courier: 1, At: at},
+	}
+
+	// check
+	outcomes, err := store.ApplyOperations(ops)
+	require.NoError(t, err)
+	require.Equal(t, []string{"deliver applied", "scan applied", "cancelled conflict", "other conflict",
+		"skipped skipped", "unknown rejected", "bad rejected"}, outcomeResults(outcomes))
+
+	p, err := store.Get(delivered.Number)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDelivered, p.Status)
+	require.True(t, at.Equal(p.SentAt))
+	require.True(t, at.Add(time.Hour).Equal(p.DeliveredAt))
+
+	// повторная выгрузка той же очереди ничего не меняет
+	outcomes, err = store.ApplyOperations(ops[:2])
+	require.NoError(t, err)
+	require.Equal(t, []string{"deliver skipped", "scan skipped"}, outcomeResults(outcomes))
+
+	// операция раньше последней смены статуса проигрывает
+	late := add()
+	require.NoError(t, store.TransitionStatus(late.Number, ParcelStatusRegistered, ParcelStatusSent))
+	outcomes, err = store.ApplyOperations([]Operation{
+		{ID: "late", Kind: OperationDeliver, Code: late.UUID, Courier: 1, At: time.Now().Add(-time.Hour)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"late conflict"}, outcomeResults(outcomes))
+
+	_, err = store.ApplyOperations(make([]Operation, MaxOperationsBatch+1))
+	require.ErrorIs(t, err, ErrTooManyOperations)
+}
+
+// TestOperationsHandler проверяет приём очереди операций по HTTP
+func TestOperationsHandler(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	p := getTestParcel()
+	_, err := store.Add(p)
+	require.NoError(t, err)
+	h := NewOperationsHandler(store, NewErrorLog(10))
+
+	// check
+	body := `{"operations":[{"id":"1","kind":"scan","code":"` + p.UUID + `","courier":1,"at":"` +
+		time.Now().Add(time.Minute).UTC().Format(time.RFC3339) + `"}]}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/courier/operations", strings.NewReader(body)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"result":"applied"`)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/courier/operations", strings.NewReader("{")))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
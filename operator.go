@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+)
+
+// OperatorHeader — заголовок с именем оператора, от которого идёт запрос
+// к служебным эндпоинтам. Заголовку доверяют: эти эндпоинты доступны
+// только из внутренней сети, см. NewHTTPHandler.
+const OperatorHeader = "X-Operator"
+
+// OperatorRecipient — оператор изменений, которые получатель делает через
+// публичное отслеживание
+const OperatorRecipient = "recipient"
+
+// operatorRe — допустимое имя оператора. Оно попадает в журнал изменений,
+// поэтому длина и символы ограничены.
+var operatorRe = regexp.MustCompile(`^[\p{L}\p{N}._@:-]{1,64}$`)
+
+// operatorKey — ключ оператора в контексте
+type operatorKey struct{}
+
+// WithOperator возвращает контекст с оператором operator
+func WithOperator(ctx context.Context, operator string) context.Context {
+	return context.WithValue(ctx, operatorKey{}, operator)
+}
+
+// OperatorFromContext возвращает оператора из контекста, пустой —
+// оператор неизвестен, например в фоновой задаче
+func OperatorFromContext(ctx context.Context) string {
+	operator, _ := ctx.Value(operatorKey{}).(string)
+	return operator
+}
+
+// OperatorMiddleware передаёт обработчикам через контекст оператора из
+// OperatorHeader. Пустое или недопустимое имя не передаётся: изменения
+// журналируются от имени оператора хранилища, см. WithAuditLog.
+func OperatorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if operator := r.Header.Get(OperatorHeader); operatorRe.MatchString(operator) {
+			r = r.WithContext(WithOperator(r.Context(), operator))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recipientOperator помечает изменения публичных эндпоинтов оператором
+// OperatorRecipient независимо от заголовков запроса
+func recipientOperator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithOperator(r.Context(), OperatorRecipient)))
+	})
+}
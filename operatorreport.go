@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	// ReportOperators — отчёт о работе операторов по сменам, см. OperatorReport
+	ReportOperators = "operators"
+	// DefaultShiftLength — длина смены операторов по умолчанию
+	DefaultShiftLength = 8 * time.Hour
+)
+
+var ErrInvalidShiftReport = errors.New("некорректный период или длина смены")
+
+// OperatorShift — действия оператора за смену по журналу изменений
+type OperatorShift struct {
+	Operator   string    `json:"operator"`
+	ShiftStart time.Time `json:"shift_start"`
+	ShiftEnd   time.Time `json:"shift_end"`
+	// Registrations — зарегистрированные посылки
+	Registrations int `json:"registrations"`
+	// StatusChanges — смены статуса, и прямые, и переходы
+	StatusChanges int `json:"status_changes"`
+	Deletions     int `json:"deletions"`
+}
+
+// OperatorShiftReport — отчёт о работе операторов за [From, To),
+// разбитый на смены длиной Shift от From
+type OperatorShiftReport struct {
+	From  time.Time       `json:"from"`
+	To    time.Time       `json:"to"`
+	Shift time.Duration   `json:"shift"`
+	Rows  []OperatorShift `json:"rows"`
+}
+
+// OperatorReport считает по журналу изменений регистрации, смены статуса
+// и удаления каждого оператора за смены длиной shift с начала from до to.
+// Смены без действий оператора в отчёт не попадают. Изменения хранилища
+// без WithAuditLog и мимо AuditedStorage в журнале нет, поэтому и в отчёте
+// тоже.
+func (s ParcelStore) OperatorReport(from time.Time, to time.Time, shift time.Duration) (OperatorShiftReport, error) {
+	if shift < time.Second || !from.Before(to) {
+		return OperatorShiftReport{}, ErrInvalidShiftReport
+	}
+	from, to = from.UTC(), to.UTC()
+
+	// номер смены — целое число смен от from до записи
+	rows, err := s.db.Query("SELECT (CAST(strftime('%s', at) AS INTEGER) - :from_unix) / :shift AS n, operator, "+
+		"SUM(action = :add), SUM(action IN (:set_status, :transition)), SUM(action = :delete) "+
+		"FROM {audit_log} WHERE at >= :from AND at < :to AND action IN (:add, :set_status, :transition, :delete) "+
+		"GROUP BY n, operator ORDER BY n, operator",
+		sql.Named("from_unix", from.Unix()),
+		sql.Named("shift", int64(shift/time.Second)),
+		sql.Named("add", AuditAdd),
+		sql.Named("set_status", AuditSetStatus),
+		sql.Named("transition", AuditTransition),
+		sql.Named("delete", AuditDelete),
+		sql.Named("from", formatTime(from)),
+		sql.Named("to", formatTime(to)))
+	if err != nil {
+		return OperatorShiftReport{}, err
+	}
+	defer rows.Close()
+
+	report := OperatorShiftReport{From: from, To: to, Shift: shift, Rows: []OperatorShift{}}
+	for rows.Next() {
+		var (
+			n int
+			o OperatorShift
+		)
+		if err := rows.Scan(&n, &o.Operator, &o.Registrations, &o.StatusChanges, &o.Deletions); err != nil {
+			return OperatorShiftReport{}, err
+		}
+		o.ShiftStart = from.Add(time.Duration(n) * shift)
+		o.ShiftEnd = o.ShiftStart.Add(shift)
+		if o.ShiftEnd.After(to) {
+			o.ShiftEnd = to
+		}
+		report.Rows = append(report.Rows, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return OperatorShiftReport{}, err
+	}
+
+	return report, nil
+}
+
+// LastDay возвращает последние завершённые до now сутки в UTC — период
+// отчёта об операторах по умолчанию
+func LastDay(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	return to.AddDate(0, 0, -1), to
+}
+
+// WriteCSV записывает отчёт в CSV: строка на оператора и смену
+func (r OperatorShiftReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"shift_start", "shift_end", "operator", "registrations", "status_changes", "deletions"}); err != nil {
+		return err
+	}
+
+	for _, o := range r.Rows {
+		err := cw.Write([]string{o.ShiftStart.Format(time.RFC3339), o.ShiftEnd.Format(time.RFC3339), o.Operator,
+			strconv.Itoa(o.Registrations), strconv.Itoa(o.StatusChanges), strconv.Itoa(o.Deletions)})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
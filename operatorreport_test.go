@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestOperatorReport проверяет подсчёт действий операторов по сменам
+func TestOperatorReport(t *testing.T) {
+	// prepare
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(day.Add(time.Hour))
+	store := NewParcelStore(openTestDB(t), WithClock(clock), WithCreatedAtWindow(0, 0))
+	ivanov := NewAuditedStorage(store, store, "ivanov")
+	petrov := NewAuditedStorage(store, store, "petrov")
+	add := func(s AuditedStorage) int {
+		p := getTestParcel()
+		p.CreatedAt = time.Time{}
+		number, err := s.Add(p)
+		require.NoError(t, err)
+		return number
+	}
+
+	first, second := add(ivanov), add(ivanov)
+	clock.Set(day.Add(9*time.Hour + 30*time.Minute))
+	require.NoError(t, petrov.TransitionStatus(first, ParcelStatusRegistered, ParcelStatusSent))
+	require.NoError(t, petrov.SetStatus(first, ParcelStatusDelivered))
+	require.NoError(t, ivanov.Delete(second))
+	require.NoError(t, ivanov.SetAddress(add(ivanov), "Тверь"))
+	// следующие сутки в отчёт не входят
+	clock.Set(day.Add(25 * time.Hour))
+	add(petrov)
+
+	// check
+	report, err := store.OperatorReport(day, day.AddDate(0, 0, 1), DefaultShiftLength)
+	require.NoError(t, err)
+	require.Equal(t, []OperatorShift{
+		{Operator: "ivanov", ShiftStart: day, ShiftEnd: day.Add(8 * time.Hour), Registrations: 2},
+		{Operator: "ivanov", ShiftStart: day.Add(8 * time.Hour), ShiftEnd: day.Add(16 * time.Hour), Registrations: 1, Deletions: 1},
+		{Operator: "petrov", ShiftStart: day.Add(8 * time.Hour), ShiftEnd: day.Add(16 * time.Hour), StatusChanges: 2},
+	}, report.Rows)
+
+	var csv strings.Builder
+	require.NoError(t, report.WriteCSV(&csv))
+	require.Contains(t, csv.String(), "2024-03-01T08:00:00Z,2024-03-01T16:00:00Z,petrov,0,2,0\n")
+
+	_, err = store.OperatorReport(day, day, time.Hour)
+	require.ErrorIs(t, err, ErrInvalidShiftReport)
+
+	// по умолчанию — прошлые сутки
+	handler := NewAdminHandler(store, NewErrorLog(10))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/reports/operators?shift=24h", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "ivanov,3,0,1")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/reports/operators?shift=0s", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
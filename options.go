@@ -0,0 +1,77 @@
+package main
+
+import (
+	"text/template"
+	"time"
+)
+
+// StoreOption настраивает ParcelStore при создании
+type StoreOption func(*ParcelStore)
+
+// WithIDGenerator задаёт стратегию назначения номеров новых посылок,
+// по умолчанию номера назначает автоинкремент БД
+func WithIDGenerator(g IDGenerator) StoreOption {
+	return func(s *ParcelStore) {
+		s.ids = g
+	}
+}
+
+// WithTablePrefix добавляет префикс к именам таблиц хранилища, например
+// staging_ для staging_parcel. Так несколько окружений делят одну БД.
+// Префикс должен быть пустым или допустимым идентификатором SQL, иначе паника.
+func WithTablePrefix(prefix string) StoreOption {
+	mustIdentifier("префикса таблиц", prefix)
+
+	return func(s *ParcelStore) {
+		s.naming.prefix = prefix
+	}
+}
+
+// WithSchema размещает таблицы хранилища в схеме schema (tracker.parcel).
+// В SQLite схема — имя БД, присоединённой через ATTACH на каждом соединении.
+// Имя схемы должно быть пустым или допустимым идентификатором SQL, иначе паника.
+func WithSchema(schema string) StoreOption {
+	mustIdentifier("схемы", schema)
+
+	return func(s *ParcelStore) {
+		s.naming.schema = schema
+	}
+}
+
+// WithFlagCacheTTL задаёт, сколько флаги функциональности хранятся в памяти
+// до перечитывания из БД. Изменение через этот же экземпляр хранилища видно
+// сразу, через другие процессы — не позже чем через ttl.
+func WithFlagCacheTTL(ttl time.Duration) StoreOption {
+	return func(s *ParcelStore) {
+		s.flags.ttl = ttl
+	}
+}
+
+// WithLoadShedder включает учёт задержки и ошибок запросов к БД
+// и сброс второстепенной нагрузки по порогам l
+func WithLoadShedder(l *LoadShedder) StoreOption {
+	return func(s *ParcelStore) {
+		s.shedder = l
+	}
+}
+
+// WithCreatedAtWindow задаёт, насколько время регистрации добавляемой
+// посылки может отставать от текущего и опережать его, по умолчанию
+// DefaultMaxCreatedAtAge и DefaultMaxCreatedAtAhead. Нулевая граница не
+// ограничивает, например при загрузке архива посылок.
+func WithCreatedAtWindow(maxAge time.Duration, maxAhead time.Duration) StoreOption {
+	return func(s *ParcelStore) {
+		s.createdAt = createdAtWindow{maxAge: maxAge, maxAhead: maxAhead}
+	}
+}
+
+// WithReceiptTemplate задаёт шаблон квитанции о регистрации для арендатора
+// tenant. Шаблон должен определять блоки subject и body, данные — Receipt.
+func WithReceiptTemplate(tenant string, tpl *template.Template) StoreOption {
+	return func(s *ParcelStore) {
+		if s.receipts == nil {
+			s.receipts = map[string]*template.Template{}
+		}
+		s.receipts[tenant] = tpl
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	// OrderStatusPending — ни одна посылка заказа ещё не отправлена
+	OrderStatusPending = "pending"
+	// OrderStatusInProgress — часть посылок в пути или уже доставлена
+	OrderStatusInProgress = "in_progress"
+	// OrderStatusDelivered — доставлены все посылки заказа
+	OrderStatusDelivered = "delivered"
+)
+
+// Order — заказ клиента, объединяющий несколько посылок
+type Order struct {
+	ID        int
+	Client    int
+	CreatedAt time.Time
+}
+
+// OrderSummary — сводный статус заказа по его посылкам
+type OrderSummary struct {
+	Order     int    `json:"order"`
+	Parcels   int    `json:"parcels"`
+	Delivered int    `json:"delivered"`
+	Status    string `json:"status"`
+}
+
+// CreateOrder создаёт заказ клиента. Посылки привязываются к заказу
+// при добавлении через Parcel.OrderID.
+func (s ParcelStore) CreateOrder(client int) (int, error) {
+	res, err := s.db.Exec("INSERT INTO {customer_order} (client, created_at) VALUES (:client, :created_at)",
+		sql.Named("client", client),
+		sql.Named("created_at", formatTime(s.now())))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetOrder возвращает заказ по идентификатору
+func (s ParcelStore) GetOrder(id int) (Order, error) {
+	row := s.db.QueryRow("SELECT id, client, created_at FROM {customer_order} WHERE id = :id", sql.Named("id", id))
+
+	o := Order{}
+	err := row.Scan(&o.ID, &o.Client, scanTime(&o.CreatedAt))
+
+	return o, err
+}
+
+// GetByOrder возвращает посылки заказа
+func (s ParcelStore) GetByOrder(order int) ([]Parcel, error) {
+	rows, err := s.db.Query(parcelSelect+"WHERE order_id = :order ORDER BY number", sql.Named("order", order))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// OrderStatus рассчитывает сводный статус заказа одним запросом: заказ
+// доставлен, когда доставлены все его посылки. Для несуществующего заказа
+// возвращает sql.ErrNoRows.
+func (s ParcelStore) OrderStatus(order int) (OrderSummary, error) {
+	row := s.db.QueryRow("SELECT o.id, COUNT(p.number), "+
+		"COALESCE(SUM(p.status = :delivered), 0) AS delivered, "+
+		"CASE "+
+		"WHEN COUNT(p.number) > 0 AND SUM(p.status = :delivered) = COUNT(p.number) THEN :order_delivered "+
+		"WHEN COALESCE(SUM(p.status != :registered), 0) = 0 THEN :order_pending "+
+		"ELSE :order_in_progress END "+
+		"FROM {customer_order} o LEFT JOIN {parcel} p ON p.order_id = o.id "+
+		"WHERE o.id = :order GROUP BY o.id",
+		sql.Named("order", order),
+		sql.Named("delivered", ParcelStatusDelivered),
+		sql.Named("registered", ParcelStatusRegistered),
+		sql.Named("order_delivered", OrderStatusDelivered),
+		sql.Named("order_pending", OrderStatusPending),
+		sql.Named("order_in_progress", OrderStatusInProgress))
+
+	summary := OrderSummary{}
+	err := row.Scan(&summary.Order, &summary.Parcels, &summary.Delivered, &summary.Status)
+
+	return summary, err
+}
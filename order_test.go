@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestOrderStatus проверяет сводный статус заказа по мере доставки посылок
+func TestOrderStatus(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	order, err := store.CreateOrder(getTestParcel().Client)
+	require.NoError(t, err)
+
+	summary, err := store.OrderStatus(order)
+	require.NoError(t, err)
+	require.Equal(t, OrderSummary{Order: order, Status: OrderStatusPending}, summary)
+
+	// add
+	var numbers []int
+	for i := 0; i < 2; i++ {
+		parcel := getTestParcel()
+		parcel.OrderID = order
+		number, err := store.Add(parcel)
+		require.NoError(t, err)
+		numbers = append(numbers, number)
+	}
+	_, err = store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// check
+	parcels, err := store.GetByOrder(order)
+	require.NoError(t, err)
+	require.Len(t, parcels, 2)
+	require.Equal(t, order, parcels[0].OrderID)
+
+	summary, err = store.OrderStatus(order)
+	require.NoError(t, err)
+	require.Equal(t, OrderStatusPending, summary.Status)
+	require.Equal(t, 2, summary.Parcels)
+
+	require.NoError(t, store.SetStatus(numbers[0], ParcelStatusDelivered))
+	summary, err = store.OrderStatus(order)
+	require.NoError(t, err)
+	require.Equal(t, OrderSummary{Order: order, Parcels: 2, Delivered: 1, Status: OrderStatusInProgress}, summary)
+
+	require.NoError(t, store.SetStatus(numbers[1], ParcelStatusDelivered))
+	summary, err = store.OrderStatus(order)
+	require.NoError(t, err)
+	require.Equal(t, OrderStatusDelivered, summary.Status)
+}
+
+// TestOrderNotFound проверяет заказ, которого нет
+func TestOrderNotFound(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+
+	// check
+	_, err := store.OrderStatus(1)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// посылку нельзя привязать к несуществующему заказу
+	parcel := getTestParcel()
+	parcel.OrderID = 1
+	_, err = store.Add(parcel)
+	require.Error(t, err)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// outboxInterval — период передачи накопленных уведомлений
+	outboxInterval = 10 * time.Second
+	// outboxBatch — сколько уведомлений передаётся за один проход
+	outboxBatch = 100
+	// MaxOutboxAttempts — после стольких неудачных попыток сообщение
+	// переносится из outbox в недоставленные
+	MaxOutboxAttempts = 5
+)
+
+// OutboxMessage — сообщение outbox, записанное в одной транзакции
+// с изменением, о котором оно сообщает
+type OutboxMessage struct {
+	ID        int
+	Topic     string
+	Payload   []byte
+	CreatedAt time.Time
+	// Attempts — число неудачных попыток отправки
+	Attempts int
+	// DedupKey — постоянный ключ сообщения, по которому получатель
+	// отбрасывает повторы; назначается при записи и не меняется
+	// при повторной отправке и возврате из недоставленных
+	DedupKey string
+}
+
+// enqueueOutbox записывает сообщение в outbox в рамках переданного
+// соединения или транзакции
+func (s ParcelStore) enqueueOutbox(db execer, topic string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO {outbox} (topic, payload, created_at, dedup_key) "+
+		"VALUES (:topic, :payload, :created_at, :dedup_key)",
+		sql.Named("dedup_key", uuid.NewString()),
+		sql.Named("topic", topic),
+		sql.Named("payload", string(data)),
+		sql.Named("created_at", formatTime(s.now())))
+
+	return err
+}
+
+// PendingOutbox возвращает до limit неотправленных сообщений в порядке записи
+func (s ParcelStore) PendingOutbox(limit int) ([]OutboxMessage, error) {
+	rows, err := s.db.Query("SELECT id, topic, payload, created_at, attempts, dedup_key FROM {outbox} "+
+		"WHERE sent_at = '' ORDER BY id LIMIT :limit",
+		sql.Named("limit", limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []OutboxMessage
+	for rows.Next() {
+		m := OutboxMessage{}
+		err := rows.Scan(&m.ID, &m.Topic, &m.Payload, scanTime(&m.CreatedAt), &m.Attempts, &m.DedupKey)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// MarkOutboxSent отмечает сообщение outbox отправленным
+func (s ParcelStore) MarkOutboxSent(id int) error {
+	_, err := s.db.Exec("UPDATE {outbox} SET sent_at = :sent_at WHERE id = :id",
+		sql.Named("sent_at", formatTime(s.now())),
+		sql.Named("id", id))
+
+	return err
+}
+
+// RelayOutbox передаёт в n до limit неотправленных уведомлений и возвращает
+// число отправленных. На первой ошибке отправка прекращается, чтобы
+// уведомления уходили в порядке записи; оставшиеся уйдут при следующем вызове.
+// Неудачная попытка учитывается в сообщении, после MaxOutboxAttempts оно
+// переносится в недоставленные и больше не задерживает очередь. Сообщение,
+// которое не удалось разобрать, переносится туда сразу.
+//
+// Отметка об отправке сохраняется сразу после подтверждения получателем,
+// но сбой между ними приведёт к повтору. Поэтому каждое уведомление
+// несёт DedupKey сообщения: получатель, запомнивший ключ, повтор отбросит.
+func (s ParcelStore) RelayOutbox(ctx context.Context, n Notifier, limit int) (int, error) {
+	messages, err := s.PendingOutbox(limit)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, m := range messages {
+		var msg Notification
+		if err := json.Unmarshal(m.Payload, &msg); err != nil {
+			if err := s.moveToDeadLetters(m, err); err != nil {
+				return sent, err
+			}
+			continue
+		}
+		msg.DedupKey = m.DedupKey
+		if err := n.Notify(ctx, msg); err != nil {
+			if ctx.Err() == nil {
+				if ferr := s.failOutbox(m, err); ferr != nil {
+					return sent, errors.Join(err, ferr)
+				}
+			}
+			return sent, err
+		}
+		if err := s.MarkOutboxSent(m.ID); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+// RunOutboxRelay каждые interval передаёт в n накопленные уведомления
+// пачками по batch, пока не отменён ctx. Ошибки отправки записываются
+// в errors, сообщения остаются в outbox до следующей попытки.
+func (s ParcelStore) RunOutboxRelay(ctx context.Context, n Notifier, interval time.Duration, batch int, errors *ErrorLog) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.RelayOutbox(ctx, n, batch); err != nil && ctx.Err() == nil {
+			errors.Record(err)
+		}
+	}
+}
@@ -1,60 +1,653 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotRegistered = errors.New("посылка уже не в статусе registered")
+	ErrNotDelivered  = errors.New("посылка ещё не доставлена")
+	ErrStatusChanged = errors.New("статус посылки изменился")
+	ErrInvalidParcel = errors.New("некорректная посылка")
 )
 
+// statusTimesSet — часть UPDATE, проставляющая время отправки и доставки
+// при переходе в соответствующий статус; ожидает параметры :status, :sent,
+// :delivered и :now
+const statusTimesSet = "sent_at = CASE WHEN :status = :sent THEN :now ELSE sent_at END, " +
+	"delivered_at = CASE WHEN :status = :delivered THEN :now ELSE delivered_at END"
+
+// phoneRe — телефон в формате E.164: плюс, код страны и номер, до 15 цифр
+var phoneRe = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// parcelStatuses — допустимые статусы посылки
+var parcelStatuses = map[string]bool{
+	ParcelStatusRegistered: true,
+	ParcelStatusSent:       true,
+	ParcelStatusDelivered:  true,
+	ParcelStatusLost:       true,
+	ParcelStatusDamaged:    true,
+	ParcelStatusReturned:   true,
+	ParcelStatusCancelled:  true,
+}
+
+// applyDefaults заполняет необязательные поля посылки значениями по
+// умолчанию перед сохранением, одинаково для всех движков
+func (p *Parcel) applyDefaults() {
+	if p.ServiceLevel == "" {
+		p.ServiceLevel = ServiceLevelStandard
+	}
+	if p.Currency == "" {
+		p.Currency = DefaultCurrency
+	}
+}
+
+// Validate проверяет поля посылки перед сохранением
+func (p Parcel) Validate() error {
+	switch {
+	case p.Client <= 0:
+		return fmt.Errorf("%w: не указан клиент", ErrInvalidParcel)
+	case p.PickupPoint < 0:
+		return fmt.Errorf("%w: некорректный пункт выдачи", ErrInvalidParcel)
+	case strings.TrimSpace(p.Address) == "" && p.PickupPoint == 0:
+		// при доставке в пункт выдачи адрес берётся из пункта
+		return fmt.Errorf("%w: пустой адрес", ErrInvalidParcel)
+	case !parcelStatuses[p.Status]:
+		return fmt.Errorf("%w: неизвестный статус %q", ErrInvalidParcel, p.Status)
+	case p.CODAmount < 0:
+		return ErrInvalidCODAmount
+	case p.DeclaredValue < 0:
+		return ErrInvalidDeclaredValue
+	case p.CreatedAt.IsZero():
+		return fmt.Errorf("%w: не указано время регистрации", ErrInvalidParcel)
+	case p.Price < 0:
+		return fmt.Errorf("%w: отрицательная стоимость", ErrInvalidParcel)
+	case p.Locale != "" && !IsSupportedLocale(p.Locale):
+		return fmt.Errorf("%w: неподдерживаемый язык %q", ErrInvalidParcel, p.Locale)
+	case p.Currency != "" && !IsSupportedCurrency(p.Currency):
+		return fmt.Errorf("%w: %q", ErrUnknownCurrency, p.Currency)
+	case p.Weight < 0:
+		return fmt.Errorf("%w: отрицательный вес", ErrInvalidParcel)
+	case p.RecipientPhone != "" && !phoneRe.MatchString(p.RecipientPhone):
+		return fmt.Errorf("%w: телефон получателя %q не в формате E.164", ErrInvalidParcel, p.RecipientPhone)
+	}
+
+	if err := p.Contents.Validate(); err != nil {
+		return err
+	}
+
+	if err := p.Metadata.Validate(); err != nil {
+		return err
+	}
+
+	if p.SenderEmail != "" {
+		if _, err := mail.ParseAddress(p.SenderEmail); err != nil {
+			return fmt.Errorf("%w: адрес отправителя: %v", ErrInvalidParcel, err)
+		}
+	}
+
+	if _, err := SLA(p.ServiceLevel); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ParcelStore безопасен для использования из нескольких горутин: состояние
+// хранится в *sql.DB и генераторе номеров, который обязан быть потокобезопасным.
+// Имена таблиц в запросах задаются шаблонами {parcel}, см. naming.
+// Изменения, зависящие от текущего статуса, проверяют его в том же запросе.
 type ParcelStore struct {
-	db *sql.DB
+	db     storeDB
+	ids    IDGenerator
+	naming naming
+	// receipts — шаблоны квитанций о регистрации по арендаторам
+	receipts map[string]*template.Template
+	// flags — кэш флагов функциональности, общий для копий хранилища
+	flags *flagCache
+	// shedder — сброс нагрузки при перегрузке БД, nil — выключен
+	shedder *LoadShedder
+	// hooks — хуки встраивающего приложения, см. WithHooks
+	hooks []Hooks
+	// sandbox — хранилище песочницы партнёров, см. Sandbox
+	sandbox bool
+	// cache — кэш публичного отслеживания, см. WithParcelCache
+	cache *ParcelCache
+	// conflictPolicy — политика конфликтов операций курьера, см. WithConflictPolicy
+	conflictPolicy string
+	// clock — часы хранилища, nil — системные, см. WithClock
+	clock Clock
+	// createdAt — допустимое время регистрации, см. WithCreatedAtWindow
+	createdAt createdAtWindow
+	// addressChanges — ограничения смены адреса, см. WithAddressChangePolicy
+	addressChanges AddressChangePolicy
+	// coldChain — допустимая температура охлаждаемых посылок, см. WithColdChainPolicy
+	coldChain ColdChainPolicy
+	// requireSignature — доставка только с подписью получателя, см. WithSignatureRequired
+	requireSignature bool
+	// recipientTokens — токены получателя, см. WithRecipientTokenKey
+	recipientTokens recipientTokens
+	// publisher — брокер для повтора событий в тему, см. WithPublisher
+	publisher Publisher
+	// trackingView — отслеживание читается из проекции, см. WithTrackingView
+	trackingView bool
+	// tracer — отладочная трассировка запросов, см. WithStatementTracer
+	tracer *StatementTracer
+	// reweighThreshold — превышение заявленного веса, после которого
+	// пересчитывается стоимость, см. WithReweighThreshold
+	reweighThreshold float64
+	// auditOperator — оператор журнала изменений по умолчанию, пустой —
+	// журнал не ведётся, см. WithAuditLog
+	auditOperator string
+	// actor — оператор и запрос, от имени которых журналируются
+	// изменения, см. ForRequest
+	actor auditActor
+}
+
+func NewParcelStore(db *sql.DB, opts ...StoreOption) ParcelStore {
+	s := ParcelStore{
+		ids:              AutoIncrement{},
+		flags:            &flagCache{ttl: defaultFlagCacheTTL},
+		createdAt:        createdAtWindow{maxAge: DefaultMaxCreatedAtAge, maxAhead: DefaultMaxCreatedAtAhead},
+		coldChain:        ColdChainPolicy{MinCelsius: DefaultMinCelsius, MaxCelsius: DefaultMaxCelsius},
+		reweighThreshold: DefaultReweighThreshold,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	s.db = storeDB{db: db, names: s.naming.replacer(), shedder: s.shedder, tracer: s.tracer}
+
+	return s
+}
+
+const (
+	// DefaultMaxCreatedAtAge — насколько раньше текущего времени может
+	// быть время регистрации добавляемой посылки
+	DefaultMaxCreatedAtAge = 90 * 24 * time.Hour
+	// DefaultMaxCreatedAtAhead — насколько время регистрации может опережать
+	// текущее: на столько расходятся часы клиента и сервера
+	DefaultMaxCreatedAtAhead = 5 * time.Minute
+)
+
+// createdAtWindow — допустимое время регистрации относительно текущего,
+// нулевая граница не ограничивает
+type createdAtWindow struct {
+	maxAge   time.Duration
+	maxAhead time.Duration
 }
 
-func NewParcelStore(db *sql.DB) ParcelStore {
-	return ParcelStore{db: db}
+// apply проставляет посылке без времени регистрации текущее время now
+// и проверяет, что заданное клиентом время попадает в окно
+func (w createdAtWindow) apply(p *Parcel, now time.Time) error {
+	if p.CreatedAt.IsZero() {
+		// в БД время хранится с точностью до миллисекунды
+		p.CreatedAt = now.UTC().Truncate(time.Millisecond)
+		return nil
+	}
+
+	switch {
+	case w.maxAhead > 0 && p.CreatedAt.After(now.Add(w.maxAhead)):
+		return fmt.Errorf("%w: время регистрации %s в будущем", ErrInvalidParcel, p.CreatedAt.Format(time.RFC3339))
+	case w.maxAge > 0 && p.CreatedAt.Before(now.Add(-w.maxAge)):
+		return fmt.Errorf("%w: время регистрации %s раньше допустимого", ErrInvalidParcel, p.CreatedAt.Format(time.RFC3339))
+	}
+
+	return nil
 }
 
+// Add добавляет посылку и возвращает её номер.
+//
+// Deprecated: используйте AddContext.
 func (s ParcelStore) Add(p Parcel) (int, error) {
-	// реализуйте добавление строки в таблицу parcel, используйте данные из переменной p
+	number, err := s.AddContext(context.Background(), p)
+
+	return number, v1Error(err)
+}
+
+// AddContext добавляет посылку и возвращает её номер. Транзакция
+// добавления отменяется вместе с ctx.
+func (s ParcelStore) AddContext(ctx context.Context, p Parcel, opts ...CallOption) (int, error) {
+	ctx, _, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	number, err := s.add(ctx, p, 0)
+	if err != nil {
+		return 0, &StoreError{Op: OpAdd, Err: err}
+	}
+
+	return number, nil
+}
+
+// add добавляет посылку под зарезервированным номером reserved или,
+// если он нулевой, под номером генератора
+func (s ParcelStore) add(ctx context.Context, p Parcel, reserved int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := s.createdAt.apply(&p, s.now()); err != nil {
+		return 0, err
+	}
+	p.applyDefaults()
+	if err := p.Validate(); err != nil {
+		return 0, err
+	}
+
+	p.InsurancePremium = 0
+	if p.Insured {
+		premium, err := InsurancePremium(p.DeclaredValue)
+		if err != nil {
+			return 0, err
+		}
+		p.InsurancePremium = premium
+	}
+
+	if err := s.applyDiscount(&p); err != nil {
+		return 0, err
+	}
+
+	p.Country = strings.ToUpper(p.Country)
+	if p.Zone == "" && p.Country != "" {
+		zone, err := s.ResolveZone(p.Country, p.PostalCode)
+		if err != nil && !errors.Is(err, ErrZoneNotFound) {
+			return 0, err
+		}
+		p.Zone = zone
+	}
+	if err := s.checkContents(p); err != nil {
+		return 0, err
+	}
+
+	if p.PickupPoint != 0 {
+		point, err := s.GetPickupPoint(p.PickupPoint)
+		if err != nil {
+			return 0, err
+		}
+		if strings.TrimSpace(p.Address) == "" {
+			p.Address = point.Address
+		}
+	}
+
+	if p.UUID == "" {
+		p.UUID = uuid.NewString()
+	}
+
+	if err := s.beforeAdd(p); err != nil {
+		return 0, err
+	}
+
+	number := reserved
+	if number == 0 {
+		var err error
+		if number, err = s.ids.NextID(); err != nil {
+			return 0, err
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 
-	// верните идентификатор последней добавленной записи
-	return 0, nil
+	if reserved != 0 {
+		if err := s.claimReservation(tx, reserved); err != nil {
+			return 0, err
+		}
+	}
+
+	p.Number = number
+	if p.Number, err = sqliteDialect.insertParcel(ctx, tx, p); err != nil {
+		return 0, err
+	}
+
+	if p.PromoCode != "" {
+		if err := consumePromoCode(tx, p.PromoCode); err != nil {
+			return 0, err
+		}
+	}
+	if err := countPartnerRegistration(tx, p.Tenant, s.now()); err != nil {
+		return 0, err
+	}
+
+	// квитанция попадает в outbox вместе с посылкой и уйдёт, даже если
+	// почтовый сервер сейчас недоступен
+	if p.SenderEmail != "" {
+		n, err := s.receiptNotification(p)
+		if err != nil {
+			return 0, err
+		}
+		if err := s.enqueueOutbox(tx, TopicNotification, n); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return p.Number, s.recordAudit(ctx, p.Number, AuditAdd, nil)
 }
 
+// Get возвращает посылку по номеру.
+//
+// Deprecated: используйте GetContext.
 func (s ParcelStore) Get(number int) (Parcel, error) {
-	// реализуйте чтение строки по заданному number
-	// здесь из таблицы должна вернуться только одна строка
+	p, err := s.GetContext(context.Background(), number)
+
+	return p, v1Error(err)
+}
+
+// GetContext возвращает посылку по номеру
+func (s ParcelStore) GetContext(ctx context.Context, number int, opts ...CallOption) (Parcel, error) {
+	ctx, _, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	row := s.db.QueryRowContext(ctx, parcelSelect+"WHERE number = :number", sql.Named("number", number))
+	p, err := scanParcel(row)
+
+	return p, storeError(OpGet, number, err)
+}
+
+// GetByUUID возвращает посылку по публичному идентификатору.
+//
+// Deprecated: используйте GetByUUIDContext.
+func (s ParcelStore) GetByUUID(id string) (Parcel, error) {
+	p, err := s.GetByUUIDContext(context.Background(), id)
+
+	return p, v1Error(err)
+}
 
-	// заполните объект Parcel данными из таблицы
-	p := Parcel{}
+// GetByUUIDContext возвращает посылку по публичному идентификатору
+func (s ParcelStore) GetByUUIDContext(ctx context.Context, id string, opts ...CallOption) (Parcel, error) {
+	ctx, _, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
 
-	return p, nil
+	row := s.db.QueryRowContext(ctx, parcelSelect+"WHERE uuid = :uuid", sql.Named("uuid", id))
+	p, err := scanParcel(row)
+
+	return p, storeError(OpGetByUUID, 0, err)
 }
 
+// GetByClient возвращает посылки клиента.
+//
+// Deprecated: используйте GetByClientContext.
 func (s ParcelStore) GetByClient(client int) ([]Parcel, error) {
-	// реализуйте чтение строк из таблицы parcel по заданному client
-	// здесь из таблицы может вернуться несколько строк
+	parcels, err := s.GetByClientContext(context.Background(), client)
+
+	return parcels, v1Error(err)
+}
+
+// GetByClientContext возвращает посылки клиента
+func (s ParcelStore) GetByClientContext(ctx context.Context, client int, opts ...CallOption) ([]Parcel, error) {
+	ctx, _, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
 
-	// заполните срез Parcel данными из таблицы
-	var res []Parcel
+	rows, err := s.db.QueryContext(ctx, parcelSelect+"WHERE client = :client", sql.Named("client", client))
+	if err != nil {
+		return nil, storeError(OpGetByClient, 0, err)
+	}
 
-	return res, nil
+	parcels, err := scanParcels(rows)
+	if err != nil {
+		return nil, storeError(OpGetByClient, 0, err)
+	}
+
+	return parcels, nil
 }
 
+// SetStatus устанавливает статус посылки без проверки графа переходов.
+//
+// Deprecated: используйте SetStatusContext.
 func (s ParcelStore) SetStatus(number int, status string) error {
-	// реализуйте обновление статуса в таблице parcel
+	return v1Error(s.SetStatusContext(context.Background(), number, status))
+}
+
+// SetStatusContext устанавливает статус посылки без проверки графа
+// переходов, например при исправлении оператором. Статус должен быть
+// встроенным или собственным статусом арендатора посылки.
+func (s ParcelStore) SetStatusContext(ctx context.Context, number int, status string, opts ...CallOption) error {
+	ctx, o, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	return storeError(OpSetStatus, number, s.setStatus(ctx, number, status, o.at))
+}
+
+func (s ParcelStore) setStatus(ctx context.Context, number int, status string, now time.Time) error {
+	return s.audited(ctx, number, AuditSetStatus, func() error {
+		return s.updateStatus(ctx, number, status, now)
+	})
+}
+
+func (s ParcelStore) updateStatus(ctx context.Context, number int, status string, now time.Time) error {
+	var p Parcel
+	if !parcelStatuses[status] || s.hasStatusHooks() {
+		var err error
+		if p, err = s.GetContext(ctx, number); err != nil {
+			return err
+		}
+	}
+	if !parcelStatuses[status] {
+		ok, err := s.isTenantStatus(p.Tenant, status)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: неизвестный статус %q", ErrInvalidParcel, status)
+		}
+	}
+
+	_, err := s.db.ExecContext(ctx, "UPDATE {parcel} SET status = :status, "+statusTimesSet+" WHERE number = :number",
+		sql.Named("status", status),
+		sql.Named("number", number),
+		sql.Named("sent", ParcelStatusSent),
+		sql.Named("delivered", ParcelStatusDelivered),
+		sql.Named("now", formatTime(now)))
+	if err != nil {
+		return err
+	}
+
+	if p.Status != status {
+		s.afterStatusChange(StatusChange{Number: number, From: p.Status, To: status, At: now})
+	}
 
 	return nil
 }
 
-func (s ParcelStore) SetAddress(number int, address string) error {
-	// реализуйте обновление адреса в таблице parcel
-	// менять адрес можно только если значение статуса registered
+// TransitionStatus меняет статус посылки с from на to по графу переходов.
+//
+// Deprecated: используйте TransitionStatusContext.
+func (s ParcelStore) TransitionStatus(number int, from string, to string) error {
+	return v1Error(s.TransitionStatusContext(context.Background(), number, from, to))
+}
+
+// TransitionStatusContext меняет статус посылки с from на to, если граф
+// переходов арендатора посылки это допускает, иначе возвращает
+// ErrInvalidTransition. Если статус посылки уже не from, например его
+// изменил параллельный запрос, возвращает ErrStatusChanged.
+func (s ParcelStore) TransitionStatusContext(ctx context.Context, number int, from string, to string, opts ...CallOption) error {
+	ctx, o, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	return storeError(OpTransitionStatus, number, s.transitionStatus(ctx, number, from, to, o.at))
+}
+
+// transitionStatusAt — TransitionStatus с временем события at, которое
+// записывается во время отправки или доставки
+func (s ParcelStore) transitionStatusAt(number int, from string, to string, at time.Time) error {
+	return v1Error(s.transitionStatus(context.Background(), number, from, to, at))
+}
+
+func (s ParcelStore) transitionStatus(ctx context.Context, number int, from string, to string, at time.Time) error {
+	return s.audited(ctx, number, AuditTransition, func() error {
+		return s.updateStatusFrom(ctx, number, from, to, at)
+	})
+}
+
+func (s ParcelStore) updateStatusFrom(ctx context.Context, number int, from string, to string, at time.Time) error {
+	p, err := s.GetContext(ctx, number)
+	if err != nil {
+		return err
+	}
+	if p.Status != from {
+		return ErrStatusChanged
+	}
+
+	graph, err := s.StatusGraph(p.Tenant)
+	if err != nil {
+		return err
+	}
+	if !graph.Allows(from, to) {
+		return fmt.Errorf("%w: %s → %s", ErrInvalidTransition, from, to)
+	}
+	if to == ParcelStatusDelivered {
+		if err := s.checkDeliveryConfirmed(p); err != nil {
+			return err
+		}
+	}
+
+	res, err := s.db.ExecContext(ctx, "UPDATE {parcel} SET status = :status, "+statusTimesSet+" WHERE number = :number AND status = :from",
+		sql.Named("status", to),
+		sql.Named("number", number),
+		sql.Named("from", from),
+		sql.Named("sent", ParcelStatusSent),
+		sql.Named("delivered", ParcelStatusDelivered),
+		sql.Named("now", formatTime(at)))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		// статус изменили между чтением посылки и обновлением
+		return ErrStatusChanged
+	}
+
+	s.afterStatusChange(StatusChange{Number: number, From: from, To: to, At: at})
 
 	return nil
 }
 
+// SetAddress меняет адрес посылки в статусе registered. Посылки в другом
+// статусе и отсутствие посылки не считаются ошибкой, смена сверх политики
+// WithAddressChangePolicy — *AddressChangeError.
+//
+// Deprecated: используйте SetAddressContext.
+func (s ParcelStore) SetAddress(number int, address string) error {
+	_, err := s.setAddress(context.Background(), number, address)
+
+	return err
+}
+
+// SetAddressContext меняет адрес посылки в статусе registered
+func (s ParcelStore) SetAddressContext(ctx context.Context, number int, address string, opts ...CallOption) error {
+	ctx, _, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	res, err := s.setAddress(ctx, number, address)
+	if err == nil {
+		err = s.checkRegisteredAffected(number, res)
+	}
+
+	return storeError(OpSetAddress, number, err)
+}
+
+// setAddress меняет адрес и журналирует смену. Попытка, отклонённая
+// AddressChangePolicy, тоже журналируется, см. AuditSetAddressRejected.
+func (s ParcelStore) setAddress(ctx context.Context, number int, address string) (res sql.Result, err error) {
+	err = s.audited(ctx, number, AuditSetAddress, func() error {
+		res, err = s.updateAddress(ctx, number, address)
+		return err
+	})
+	if errors.Is(err, ErrAddressChangeLimited) && s.auditOperator != "" {
+		if rerr := s.auditor(ctx).recordRejectedAddress(ctx, number, address); rerr != nil {
+			err = errors.Join(err, rerr)
+		}
+	}
+
+	return res, err
+}
+
+func (s ParcelStore) updateAddress(ctx context.Context, number int, address string) (sql.Result, error) {
+	if strings.TrimSpace(address) == "" {
+		return nil, fmt.Errorf("%w: пустой адрес", ErrInvalidParcel)
+	}
+	if s.addressChanges.enabled() {
+		return s.setAddressLimited(ctx, number, address)
+	}
+
+	// менять адрес можно только если значение статуса registered
+	return s.db.ExecContext(ctx, "UPDATE {parcel} SET address = :address WHERE number = :number AND status = :status",
+		sql.Named("address", address),
+		sql.Named("number", number),
+		sql.Named("status", ParcelStatusRegistered))
+}
+
+// Delete удаляет посылку в статусе registered. Посылки в другом статусе
+// и отсутствие посылки не считаются ошибкой.
+//
+// Deprecated: используйте DeleteContext.
 func (s ParcelStore) Delete(number int) error {
-	// реализуйте удаление строки из таблицы parcel
-	// удалять строку можно только если значение статуса registered
+	_, err := s.delete(context.Background(), number)
 
-	return nil
+	return err
+}
+
+// DeleteContext удаляет посылку в статусе registered
+func (s ParcelStore) DeleteContext(ctx context.Context, number int, opts ...CallOption) error {
+	ctx, _, cancel := applyCallOptions(ctx, s.now(), opts)
+	defer cancel()
+
+	res, err := s.delete(ctx, number)
+	if err == nil {
+		err = s.checkRegisteredAffected(number, res)
+	}
+
+	return storeError(OpDelete, number, err)
+}
+
+func (s ParcelStore) delete(ctx context.Context, number int) (res sql.Result, err error) {
+	err = s.audited(ctx, number, AuditDelete, func() error {
+		// удалять строку можно только если значение статуса registered
+		res, err = s.db.ExecContext(ctx, "DELETE FROM {parcel} WHERE number = :number AND status = :status",
+			sql.Named("number", number),
+			sql.Named("status", ParcelStatusRegistered))
+		return err
+	})
+
+	return res, err
+}
+
+// checkRegisteredAffected проверяет, что запрос, ограниченный посылками
+// в статусе registered, изменил строки, и иначе выясняет причину
+func (s ParcelStore) checkRegisteredAffected(number int, res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	p, err := s.Get(number)
+	if err != nil {
+		return err
+	}
+	if p.Status != ParcelStatusRegistered {
+		return ErrNotRegistered
+	}
+
+	return sql.ErrNoRows
 }
@@ -3,9 +3,11 @@ package main
 import (
 	"database/sql"
 	"math/rand"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 )
 
@@ -21,67 +23,115 @@ var (
 // getTestParcel возвращает тестовую посылку
 func getTestParcel() Parcel {
 	return Parcel{
-		Client:    1000,
-		Status:    ParcelStatusRegistered,
-		Address:   "test",
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		UUID:    uuid.NewString(),
+		Client:  1000,
+		Status:  ParcelStatusRegistered,
+		Address: "test",
+		// в БД время хранится с точностью до миллисекунды
+		CreatedAt:    time.Now().UTC().Truncate(time.Millisecond),
+		ServiceLevel: ServiceLevelStandard,
+		Currency:     DefaultCurrency,
 	}
 }
 
+// openTestDB открывает временную БД с актуальной схемой,
+// которая удаляется по завершении теста
+func openTestDB(t testing.TB) *sql.DB {
+	t.Helper()
+
+	db, err := OpenDB(filepath.Join(t.TempDir(), "tracker.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	require.NoError(t, Migrate(db))
+
+	return db
+}
+
 // TestAddGetDelete проверяет добавление, получение и удаление посылки
 func TestAddGetDelete(t *testing.T) {
 	// prepare
-	db, err := // настройте подключение к БД
+	db := openTestDB(t)
 	store := NewParcelStore(db)
 	parcel := getTestParcel()
 
 	// add
-	// добавьте новую посылку в БД, убедитесь в отсутствии ошибки и наличии идентификатора
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+	require.NotZero(t, id)
 
 	// get
-	// получите только что добавленную посылку, убедитесь в отсутствии ошибки
-	// проверьте, что значения всех полей в полученном объекте совпадают со значениями полей в переменной parcel
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	parcel.Number = id
+	// время изменения проставляет БД
+	require.False(t, stored.UpdatedAt.Before(parcel.CreatedAt))
+	parcel.UpdatedAt = stored.UpdatedAt
+	require.Equal(t, parcel, stored)
 
 	// delete
-	// удалите добавленную посылку, убедитесь в отсутствии ошибки
-	// проверьте, что посылку больше нельзя получить из БД
+	err = store.Delete(id)
+	require.NoError(t, err)
+
+	_, err = store.Get(id)
+	require.ErrorIs(t, err, sql.ErrNoRows)
 }
 
 // TestSetAddress проверяет обновление адреса
 func TestSetAddress(t *testing.T) {
 	// prepare
-	db, err := // настройте подключение к БД
+	db := openTestDB(t)
+	store := NewParcelStore(db)
 
 	// add
-	// добавьте новую посылку в БД, убедитесь в отсутствии ошибки и наличии идентификатора
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NotZero(t, id)
 
 	// set address
-	// обновите адрес, убедитесь в отсутствии ошибки
 	newAddress := "new test address"
+	err = store.SetAddress(id, newAddress)
+	require.NoError(t, err)
 
 	// check
-	// получите добавленную посылку и убедитесь, что адрес обновился
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, newAddress, stored.Address)
 }
 
 // TestSetStatus проверяет обновление статуса
 func TestSetStatus(t *testing.T) {
 	// prepare
-	db, err := // настройте подключение к БД
+	db := openTestDB(t)
+	store := NewParcelStore(db)
 
 	// add
-	// добавьте новую посылку в БД, убедитесь в отсутствии ошибки и наличии идентификатора
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NotZero(t, id)
 
 	// set status
-	// обновите статус, убедитесь в отсутствии ошибки
+	err = store.SetStatus(id, ParcelStatusSent)
+	require.NoError(t, err)
 
 	// check
-	// получите добавленную посылку и убедитесь, что статус обновился
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, stored.Status)
+	require.WithinDuration(t, time.Now(), stored.SentAt, time.Minute)
+	require.True(t, stored.DeliveredAt.IsZero())
+
+	require.NoError(t, store.TransitionStatus(id, ParcelStatusSent, ParcelStatusDelivered))
+	stored, err = store.Get(id)
+	require.NoError(t, err)
+	require.False(t, stored.DeliveredAt.Before(stored.SentAt))
 }
 
 // TestGetByClient проверяет получение посылок по идентификатору клиента
 func TestGetByClient(t *testing.T) {
 	// prepare
-	db, err := // настройте подключение к БД
+	db := openTestDB(t)
+	store := NewParcelStore(db)
 
 	parcels := []Parcel{
 		getTestParcel(),
@@ -98,7 +148,9 @@ func TestGetByClient(t *testing.T) {
 
 	// add
 	for i := 0; i < len(parcels); i++ {
-		id, err := // добавьте новую посылку в БД, убедитесь в отсутствии ошибки и наличии идентификатора
+		id, err := store.Add(parcels[i])
+		require.NoError(t, err)
+		require.NotZero(t, id)
 
 		// обновляем идентификатор добавленной у посылки
 		parcels[i].Number = id
@@ -108,14 +160,103 @@ func TestGetByClient(t *testing.T) {
 	}
 
 	// get by client
-	storedParcels, err := // получите список посылок по идентификатору клиента, сохранённого в переменной client
-	// убедитесь в отсутствии ошибки
-	// убедитесь, что количество полученных посылок совпадает с количеством добавленных
+	storedParcels, err := store.GetByClient(client)
+	require.NoError(t, err)
+	require.Len(t, storedParcels, len(parcels))
 
 	// check
 	for _, parcel := range storedParcels {
-		// в parcelMap лежат добавленные посылки, ключ - идентификатор посылки, значение - сама посылка
-		// убедитесь, что все посылки из storedParcels есть в parcelMap
-		// убедитесь, что значения полей полученных посылок заполнены верно
+		expected, ok := parcelMap[parcel.Number]
+		require.True(t, ok)
+		require.NotZero(t, parcel.UpdatedAt)
+		expected.UpdatedAt = parcel.UpdatedAt
+		require.Equal(t, expected, parcel)
 	}
 }
+
+// TestGetByUUID проверяет получение посылки по публичному идентификатору
+func TestGetByUUID(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	parcel.UUID = ""
+
+	// add
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	// check
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	_, err = uuid.Parse(stored.UUID)
+	require.NoError(t, err)
+
+	byUUID, err := store.GetByUUID(stored.UUID)
+	require.NoError(t, err)
+	require.Equal(t, stored, byUUID)
+
+	_, err = store.GetByUUID(uuid.NewString())
+	require.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+// TestAddInvalid проверяет, что некорректная посылка не добавляется
+func TestAddInvalid(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+
+	// check
+	for name, modify := range map[string]func(p *Parcel){
+		"client":     func(p *Parcel) { p.Client = 0 },
+		"address":    func(p *Parcel) { p.Address = "  " },
+		"status":     func(p *Parcel) { p.Status = "teleported" },
+		"created_at": func(p *Parcel) { p.CreatedAt = time.Now().Add(time.Hour) },
+		"old":        func(p *Parcel) { p.CreatedAt = time.Now().AddDate(-1, 0, 0) },
+	} {
+		parcel := getTestParcel()
+		modify(&parcel)
+		_, err := store.Add(parcel)
+		require.ErrorIs(t, err, ErrInvalidParcel, name)
+	}
+
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.ErrorIs(t, store.SetStatus(id, "teleported"), ErrInvalidParcel)
+	require.ErrorIs(t, store.SetAddress(id, ""), ErrInvalidParcel)
+}
+
+// TestAddCreatedAt проверяет время регистрации по умолчанию и окно
+// допустимого времени регистрации
+func TestAddCreatedAt(t *testing.T) {
+	// prepare
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	clock := NewManualClock(now)
+	db := openTestDB(t)
+	store := NewParcelStore(db, WithClock(clock))
+
+	// check
+	p := getTestParcel()
+	p.CreatedAt = time.Time{}
+	number, err := store.Add(p)
+	require.NoError(t, err)
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.True(t, now.Equal(stored.CreatedAt))
+
+	// часы клиента немного спешат
+	p = getTestParcel()
+	p.CreatedAt = now.Add(time.Minute)
+	_, err = store.Add(p)
+	require.NoError(t, err)
+
+	p = getTestParcel()
+	p.CreatedAt = now.Add(DefaultMaxCreatedAtAhead + time.Second)
+	_, err = store.Add(p)
+	require.ErrorIs(t, err, ErrInvalidParcel)
+	p.CreatedAt = now.Add(-DefaultMaxCreatedAtAge - time.Second)
+	_, err = store.Add(p)
+	require.ErrorIs(t, err, ErrInvalidParcel)
+
+	archive := NewParcelStore(db, WithClock(clock), WithCreatedAtWindow(0, 0))
+	_, err = archive.Add(p)
+	require.NoError(t, err)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// parcelField связывает столбец таблицы parcel с полем Parcel. Новый
+// столбец добавляется одной строкой в parcelFields, запросы и списки Scan
+// строятся по ней.
+type parcelField struct {
+	column string
+	// dest возвращает приёмник Scan для поля посылки
+	dest func(p *Parcel) any
+	// value возвращает значение столбца для INSERT; nil — столбец
+	// при добавлении не заполняется и получает значение по умолчанию
+	value func(p Parcel) any
+	// insert оборачивает параметр в выражение, по умолчанию параметр как есть
+	insert string
+}
+
+// parcelFields — столбцы посылки в порядке выборки
+var parcelFields = []parcelField{
+	// при нулевом номере его назначает автоинкремент
+	{column: "number", dest: func(p *Parcel) any { return &p.Number }, value: func(p Parcel) any { return p.Number }, insert: "NULLIF(%s, 0)"},
+	{column: "uuid", dest: func(p *Parcel) any { return &p.UUID }, value: func(p Parcel) any { return p.UUID }},
+	{column: "client", dest: func(p *Parcel) any { return &p.Client }, value: func(p Parcel) any { return p.Client }},
+	{column: "status", dest: func(p *Parcel) any { return &p.Status }, value: func(p Parcel) any { return p.Status }},
+	{column: "address", dest: func(p *Parcel) any { return &p.Address }, value: func(p Parcel) any { return p.Address }},
+	{column: "created_at", dest: func(p *Parcel) any { return scanTime(&p.CreatedAt) }, value: func(p Parcel) any { return formatTime(p.CreatedAt) }},
+	{column: "service_level", dest: func(p *Parcel) any { return &p.ServiceLevel }, value: func(p Parcel) any { return p.ServiceLevel }},
+	{column: "cod_amount", dest: func(p *Parcel) any { return &p.CODAmount }, value: func(p Parcel) any { return p.CODAmount }},
+	{column: "cod_collected", dest: func(p *Parcel) any { return &p.CODCollected }},
+	{column: "country", dest: func(p *Parcel) any { return &p.Country }, value: func(p Parcel) any { return p.Country }},
+	{column: "postal_code", dest: func(p *Parcel) any { return &p.PostalCode }, value: func(p Parcel) any { return p.PostalCode }},
+	{column: "zone", dest: func(p *Parcel) any { return &p.Zone }, value: func(p Parcel) any { return p.Zone }},
+	{column: "insured", dest: func(p *Parcel) any { return &p.Insured }, value: func(p Parcel) any { return p.Insured }},
+	{column: "declared_value", dest: func(p *Parcel) any { return &p.DeclaredValue }, value: func(p Parcel) any { return p.DeclaredValue }},
+	{column: "insurance_premium", dest: func(p *Parcel) any { return &p.InsurancePremium }, value: func(p Parcel) any { return p.InsurancePremium }},
+	{column: "sent_at", dest: func(p *Parcel) any { return scanTime(&p.SentAt) }},
+	{column: "delivered_at", dest: func(p *Parcel) any { return scanTime(&p.DeliveredAt) }},
+	{column: "sender_email", dest: func(p *Parcel) any { return &p.SenderEmail }, value: func(p Parcel) any { return p.SenderEmail }},
+	{column: "tenant", dest: func(p *Parcel) any { return &p.Tenant }, value: func(p Parcel) any { return p.Tenant }},
+	{column: "price", dest: func(p *Parcel) any { return &p.Price }, value: func(p Parcel) any { return p.Price }},
+	{column: "locale", dest: func(p *Parcel) any { return &p.Locale }, value: func(p Parcel) any { return p.Locale }},
+	// вне заказа order_id NULL, чтобы не нарушать внешний ключ
+	{column: "order_id", dest: func(p *Parcel) any { return scanZeroInt(&p.OrderID) }, value: func(p Parcel) any { return p.OrderID }, insert: "NULLIF(%s, 0)"},
+	{column: "pickup_point", dest: func(p *Parcel) any { return scanZeroInt(&p.PickupPoint) }, value: func(p Parcel) any { return p.PickupPoint }, insert: "NULLIF(%s, 0)"},
+	// updated_at заполняют триггеры БД
+	{column: "updated_at", dest: func(p *Parcel) any { return scanTime(&p.UpdatedAt) }},
+	{column: "metadata", dest: func(p *Parcel) any { return &p.Metadata }, value: func(p Parcel) any { return p.Metadata }},
+	{column: "recipient_phone", dest: func(p *Parcel) any { return &p.RecipientPhone }, value: func(p Parcel) any { return p.RecipientPhone }},
+	{column: "weight", dest: func(p *Parcel) any { return &p.Weight }, value: func(p Parcel) any { return p.Weight }},
+	{column: "contents", dest: func(p *Parcel) any { return &p.Contents }, value: func(p Parcel) any { return p.Contents }},
+	{column: "promo_code", dest: func(p *Parcel) any { return &p.PromoCode }, value: func(p Parcel) any { return p.PromoCode }},
+	{column: "discount", dest: func(p *Parcel) any { return &p.Discount }, value: func(p Parcel) any { return p.Discount }},
+	{column: "currency", dest: func(p *Parcel) any { return &p.Currency }, value: func(p Parcel) any { return p.Currency }},
+	{column: "requires_refrigeration", dest: func(p *Parcel) any { return &p.RequiresRefrigeration }, value: func(p Parcel) any { return p.RequiresRefrigeration }},
+	{column: "age_verification", dest: func(p *Parcel) any { return &p.AgeVerification }, value: func(p Parcel) any { return p.AgeVerification }},
+}
+
+// parcelSelect — начало запроса посылок, условие дописывает вызывающий
+var parcelSelect = "SELECT " + parcelColumns() + " FROM {parcel} "
+
+// parcelColumns возвращает список столбцов посылки для SELECT
+func parcelColumns() string {
+	columns := make([]string, len(parcelFields))
+	for i, f := range parcelFields {
+		columns[i] = f.column
+	}
+
+	return strings.Join(columns, ", ")
+}
+
+// zeroIntScanner читает целое, которое может быть NULL, NULL читается как 0
+type zeroIntScanner struct {
+	v *int
+}
+
+// scanZeroInt возвращает приёмник Scan для столбца, где NULL означает 0
+func scanZeroInt(v *int) sql.Scanner {
+	return zeroIntScanner{v: v}
+}
+
+func (s zeroIntScanner) Scan(src any) error {
+	var n sql.NullInt64
+	if err := n.Scan(src); err != nil {
+		return err
+	}
+	*s.v = int(n.Int64)
+
+	return nil
+}
+
+// rowScanner — общий интерфейс *sql.Row и *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanParcel читает посылку из строки, выбранной через parcelSelect
+func scanParcel(row rowScanner) (Parcel, error) {
+	p := Parcel{}
+	dest := make([]any, len(parcelFields))
+	for i, f := range parcelFields {
+		dest[i] = f.dest(&p)
+	}
+	err := row.Scan(dest...)
+
+	return p, err
+}
+
+// scanParcels читает все посылки выборки и закрывает rows
+func scanParcels(rows *sql.Rows) ([]Parcel, error) {
+	defer rows.Close()
+
+	var res []Parcel
+	for rows.Next() {
+		p, err := scanParcel(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// sqlDialect — различия SQL движков хранилища при добавлении посылки
+type sqlDialect struct {
+	// param возвращает обозначение параметра для столбца и сам аргумент
+	param func(column string, v any) (string, any)
+	// returning — номер добавленной посылки возвращается через RETURNING,
+	// иначе берётся из LastInsertId
+	returning bool
+}
+
+var (
+	// sqliteDialect — SQLite: именованные параметры и RETURNING. Номер из
+	// RETURNING приходит в ответе на тот же запрос и не зависит от того,
+	// какие ещё вставки выполнены на соединении.
+	sqliteDialect = sqlDialect{param: namedParam, returning: true}
+	// mysqlDialect — MySQL: драйвер не поддерживает именованные параметры,
+	// а RETURNING есть только в MariaDB
+	mysqlDialect = sqlDialect{param: positionalParam}
+)
+
+// parcelInsert строит INSERT посылки в диалекте d, так один построитель
+// обслуживает именованные параметры SQLite и позиционные MySQL
+func parcelInsert(p Parcel, d sqlDialect) (string, []any) {
+	var columns, values []string
+	var args []any
+	for _, f := range parcelFields {
+		if f.value == nil {
+			continue
+		}
+
+		placeholder, arg := d.param(f.column, f.value(p))
+		if f.insert != "" {
+			placeholder = fmt.Sprintf(f.insert, placeholder)
+		}
+		columns = append(columns, f.column)
+		values = append(values, placeholder)
+		args = append(args, arg)
+	}
+
+	query := "INSERT INTO {parcel} (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(values, ", ") + ")"
+	if d.returning {
+		query += " RETURNING number"
+	}
+
+	return query, args
+}
+
+// parcelInserter — соединение или транзакция, через которые добавляется посылка
+type parcelInserter interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// insertParcel добавляет посылку и возвращает её номер. При нулевом
+// p.Number номер назначает БД.
+func (d sqlDialect) insertParcel(ctx context.Context, db parcelInserter, p Parcel) (int, error) {
+	query, args := parcelInsert(p, d)
+	if d.returning {
+		var number int
+		err := db.QueryRowContext(ctx, query, args...).Scan(&number)
+
+		return number, classifyDBError(err)
+	}
+
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	if p.Number != 0 {
+		return p.Number, nil
+	}
+
+	// драйвер MySQL возвращает LAST_INSERT_ID() из ответа сервера на INSERT,
+	// отдельный запрос мог бы попасть на другое соединение пула
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// namedParam — параметр для parcelInsert в виде :column
+func namedParam(column string, v any) (string, any) {
+	return ":" + column, sql.Named(column, v)
+}
+
+// positionalParam — параметр для parcelInsert в виде ?
+func positionalParam(_ string, v any) (string, any) {
+	return "?", v
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestParcelFields проверяет, что каждое поле Parcel отображено на свой столбец
+func TestParcelFields(t *testing.T) {
+	require.Len(t, parcelFields, reflect.TypeOf(Parcel{}).NumField())
+
+	columns := map[string]bool{}
+	for _, f := range parcelFields {
+		require.False(t, columns[f.column], f.column)
+		columns[f.column] = true
+	}
+}
+
+// TestParcelInsert проверяет построение INSERT в диалектах SQLite и MySQL
+func TestParcelInsert(t *testing.T) {
+	parcel := getTestParcel()
+
+	query, args := parcelInsert(parcel, mysqlDialect)
+	require.Contains(t, query, "VALUES (NULLIF(?, 0), ?, ")
+	require.NotContains(t, query, "RETURNING")
+	require.Equal(t, 0, args[0])
+	require.Equal(t, parcel.UUID, args[1])
+
+	query, args = parcelInsert(parcel, sqliteDialect)
+	require.Contains(t, query, "VALUES (NULLIF(:number, 0), :uuid, ")
+	require.True(t, strings.HasSuffix(query, ") RETURNING number"), query)
+	require.Len(t, args, 29)
+}
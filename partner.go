@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// usageMonthLayout — формат месяца в счётчиках использования
+const usageMonthLayout = "2006-01"
+
+var (
+	ErrInvalidPartner = errors.New("некорректные данные партнёра")
+	ErrPartnerExists  = errors.New("партнёр уже зарегистрирован")
+	ErrUnknownAPIKey  = errors.New("неизвестный ключ API")
+	ErrQuotaExceeded  = errors.New("исчерпана месячная квота регистраций партнёра")
+)
+
+// partnerIDRe — допустимый идентификатор партнёра, он же арендатор посылок
+var partnerIDRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Partner — магазин, которому перепродаётся трекер. ID совпадает
+// с арендатором (Parcel.Tenant) посылок партнёра. MonthlyQuota ограничивает
+// число регистраций посылок за календарный месяц в UTC, 0 — без ограничения.
+type Partner struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Email        string    `json:"email"`
+	MonthlyQuota int       `json:"monthly_quota"`
+	CreatedAt    time.Time `json:"created_at"`
+	// Used — регистрации за текущий месяц, заполняется ListPartners
+	Used int `json:"used"`
+	// Sandbox — партнёр опознан по ключу песочницы, заполняется AuthenticatePartner
+	Sandbox bool `json:"-"`
+}
+
+// RegisterPartner регистрирует партнёра и возвращает его ключ API.
+// В БД хранится только хеш ключа, повторно получить ключ нельзя.
+func (s ParcelStore) RegisterPartner(p Partner) (string, error) {
+	switch {
+	case !partnerIDRe.MatchString(p.ID):
+		return "", fmt.Errorf("%w: идентификатор %q", ErrInvalidPartner, p.ID)
+	case p.Name == "":
+		return "", fmt.Errorf("%w: пустое название", ErrInvalidPartner)
+	case p.MonthlyQuota < 0:
+		return "", fmt.Errorf("%w: отрицательная квота", ErrInvalidPartner)
+	}
+
+	key, err := newAPIKey()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.db.Exec("INSERT INTO {partner} (id, name, email, monthly_quota, api_key_hash, created_at) "+
+		"VALUES (:id, :name, :email, :monthly_quota, :api_key_hash, :created_at)",
+		sql.Named("id", p.ID),
+		sql.Named("name", p.Name),
+		sql.Named("email", p.Email),
+		sql.Named("monthly_quota", p.MonthlyQuota),
+		sql.Named("api_key_hash", hashAPIKey(key)),
+		sql.Named("created_at", formatTime(s.now())))
+	if errors.Is(err, ErrDuplicate) {
+		return "", ErrPartnerExists
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// newAPIKey возвращает случайный ключ API
+func newAPIKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// hashAPIKey возвращает хеш ключа API для хранения в БД. Ключ случайный
+// и длинный, поэтому соль и медленный хеш не нужны.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// AuthenticatePartner возвращает партнёра по рабочему ключу API или ключу
+// песочницы, во втором случае с Sandbox: запросы такого партнёра
+// вызывающий выполняет в хранилище песочницы, см. ParcelStore.Sandbox
+func (s ParcelStore) AuthenticatePartner(key string) (Partner, error) {
+	p := Partner{}
+	err := s.db.QueryRow("SELECT id, name, email, monthly_quota, created_at, sandbox_key_hash = :hash FROM {partner} "+
+		"WHERE api_key_hash = :hash OR sandbox_key_hash = :hash",
+		sql.Named("hash", hashAPIKey(key))).
+		Scan(&p.ID, &p.Name, &p.Email, &p.MonthlyQuota, scanTime(&p.CreatedAt), &p.Sandbox)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Partner{}, ErrUnknownAPIKey
+	}
+
+	return p, err
+}
+
+// SetPartnerQuota меняет месячную квоту партнёра, 0 — без ограничения.
+// Регистрации сверх новой квоты в текущем месяце не отменяются.
+func (s ParcelStore) SetPartnerQuota(id string, quota int) error {
+	if quota < 0 {
+		return fmt.Errorf("%w: отрицательная квота", ErrInvalidPartner)
+	}
+
+	res, err := s.db.Exec("UPDATE {partner} SET monthly_quota = :quota WHERE id = :id",
+		sql.Named("quota", quota),
+		sql.Named("id", id))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ListPartners возвращает партнёров с числом регистраций за месяц, которому принадлежит now
+func (s ParcelStore) ListPartners(now time.Time) ([]Partner, error) {
+	rows, err := s.db.Query("SELECT p.id, p.name, p.email, p.monthly_quota, p.created_at, COALESCE(u.registrations, 0) "+
+		"FROM {partner} p LEFT JOIN {partner_usage} u ON u.partner = p.id AND u.month = :month ORDER BY p.id",
+		sql.Named("month", now.UTC().Format(usageMonthLayout)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Partner
+	for rows.Next() {
+		p := Partner{}
+		if err := rows.Scan(&p.ID, &p.Name, &p.Email, &p.MonthlyQuota, scanTime(&p.CreatedAt), &p.Used); err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// PartnerUsage возвращает число регистраций партнёра за месяц, которому принадлежит month
+func (s ParcelStore) PartnerUsage(id string, month time.Time) (int, error) {
+	var used int
+	err := s.db.QueryRow("SELECT registrations FROM {partner_usage} WHERE partner = :partner AND month = :month",
+		sql.Named("partner", id),
+		sql.Named("month", month.UTC().Format(usageMonthLayout))).Scan(&used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+
+	return used, err
+}
+
+// countPartnerRegistration учитывает регистрацию посылки арендатора tenant
+// в транзакции добавления. Посылки арендаторов, не зарегистрированных
+// партнёрами, не считаются. Условие в UPSERT не даёт превысить квоту
+// при одновременной регистрации.
+func countPartnerRegistration(tx storeTx, tenant string, now time.Time) error {
+	if tenant == "" {
+		return nil
+	}
+
+	var quota int
+	err := tx.QueryRow("SELECT monthly_quota FROM {partner} WHERE id = :id", sql.Named("id", tenant)).Scan(&quota)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec("INSERT INTO {partner_usage} (partner, month, registrations) VALUES (:partner, :month, 1) "+
+		"ON CONFLICT (partner, month) DO UPDATE SET registrations = registrations + 1 "+
+		"WHERE :quota = 0 OR registrations < :quota",
+		sql.Named("partner", tenant),
+		sql.Named("month", now.UTC().Format(usageMonthLayout)),
+		sql.Named("quota", quota))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestPartnerQuota проверяет учёт регистраций партнёра и месячную квоту
+func TestPartnerQuota(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	key, err := store.RegisterPartner(Partner{ID: "shop", Name: "Магазин", MonthlyQuota: 2})
+	require.NoError(t, err)
+	_, err = store.RegisterPartner(Partner{ID: "shop", Name: "Магазин"})
+	require.ErrorIs(t, err, ErrPartnerExists)
+	_, err = store.RegisterPartner(Partner{ID: "Shop!", Name: "Магазин"})
+	require.ErrorIs(t, err, ErrInvalidPartner)
+
+	partner, err := store.AuthenticatePartner(key)
+	require.NoError(t, err)
+	require.Equal(t, "shop", partner.ID)
+	_, err = store.AuthenticatePartner("wrong")
+	require.ErrorIs(t, err, ErrUnknownAPIKey)
+
+	parcel := func(tenant string) Parcel {
+		p := getTestParcel()
+		p.Tenant = tenant
+		return p
+	}
+
+	// check
+	for i := 0; i < 2; i++ {
+		_, err := store.Add(parcel("shop"))
+		require.NoError(t, err)
+	}
+	_, err = store.Add(parcel("shop"))
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+	// арендаторы без записи партнёра не ограничены
+	_, err = store.Add(parcel("internal"))
+	require.NoError(t, err)
+
+	used, err := store.PartnerUsage("shop", time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 2, used)
+
+	require.NoError(t, store.SetPartnerQuota("shop", 0))
+	_, err = store.Add(parcel("shop"))
+	require.NoError(t, err)
+
+	partners, err := store.ListPartners(time.Now())
+	require.NoError(t, err)
+	require.Len(t, partners, 1)
+	require.Equal(t, 3, partners[0].Used)
+	require.Zero(t, partners[0].MonthlyQuota)
+}
+
+// TestAdminPartners проверяет регистрацию партнёров через служебные эндпоинты
+func TestAdminPartners(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	handler := NewAdminHandler(store, NewErrorLog(10))
+	post := func(target string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// check
+	rec := post("/admin/partners", url.Values{"id": {"shop"}, "name": {"Магазин"}, "quota": {"10"}})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	_, err := store.AuthenticatePartner(created["api_key"])
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusConflict, post("/admin/partners", url.Values{"id": {"shop"}, "name": {"Магазин"}}).Code)
+	require.Equal(t, http.StatusNoContent, post("/admin/partners/quota", url.Values{"id": {"shop"}, "quota": {"5"}}).Code)
+	require.Equal(t, http.StatusNotFound, post("/admin/partners/quota", url.Values{"id": {"none"}, "quota": {"5"}}).Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/partners", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var partners []Partner
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &partners))
+	require.Len(t, partners, 1)
+	require.Equal(t, 5, partners[0].MonthlyQuota)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPickupStorageDays — сколько дней посылка по умолчанию ждёт
+	// получателя в пункте выдачи, прежде чем вернуться отправителю
+	DefaultPickupStorageDays = 7
+	// pickupInterval — как часто задание возврата проверяет сроки хранения
+	pickupInterval = time.Hour
+	// PickupCodeTTL — срок действия кода получения
+	PickupCodeTTL = DefaultPickupStorageDays * 24 * time.Hour
+	// MaxPickupCodeAttempts — сколько неверных кодов можно ввести,
+	// прежде чем код заблокируется до перевыпуска
+	MaxPickupCodeAttempts = 5
+)
+
+var (
+	ErrInvalidPickupPoint  = errors.New("некорректный пункт выдачи")
+	ErrPickupPointNotFound = errors.New("пункт выдачи не найден")
+	ErrNotPickupParcel     = errors.New("посылка доставляется не в пункт выдачи")
+	ErrNotAwaitingPickup   = errors.New("посылка не ожидает получения в пункте выдачи")
+	ErrInvalidPickupCode   = errors.New("неверный код получения")
+	ErrPickupCodeExpired   = errors.New("срок действия кода получения истёк")
+	ErrPickupCodeLocked    = errors.New("код получения заблокирован после неверных попыток")
+)
+
+// PickupPoint — пункт выдачи или постамат, куда можно доставить посылку
+// вместо адреса получателя
+type PickupPoint struct {
+	ID      int
+	Name    string
+	Address string
+	// Locker — постамат без оператора
+	Locker bool
+}
+
+// AddPickupPoint добавляет пункт выдачи в справочник
+func (s ParcelStore) AddPickupPoint(pp PickupPoint) (int, error) {
+	if strings.TrimSpace(pp.Name) == "" || strings.TrimSpace(pp.Address) == "" {
+		return 0, ErrInvalidPickupPoint
+	}
+
+	res, err := s.db.Exec("INSERT INTO {pickup_point} (name, address, locker) VALUES (:name, :address, :locker)",
+		sql.Named("name", pp.Name),
+		sql.Named("address", pp.Address),
+		sql.Named("locker", pp.Locker))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetPickupPoint возвращает пункт выдачи по идентификатору
+func (s ParcelStore) GetPickupPoint(id int) (PickupPoint, error) {
+	row := s.db.QueryRow("SELECT id, name, address, locker FROM {pickup_point} WHERE id = :id", sql.Named("id", id))
+
+	pp := PickupPoint{}
+	err := row.Scan(&pp.ID, &pp.Name, &pp.Address, &pp.Locker)
+	if errors.Is(err, sql.ErrNoRows) {
+		return pp, fmt.Errorf("%w: %d", ErrPickupPointNotFound, id)
+	}
+
+	return pp, err
+}
+
+// ArriveAtPickup отмечает прибытие отправленной посылки в её пункт выдачи,
+// с этого момента отсчитывается срок хранения. Возвращает одноразовый код
+// получения для передачи получателю, в БД хранится только его хеш.
+// Повторная отметка срок хранения не продлевает, но перевыпускает код
+// и сбрасывает счётчик неверных попыток.
+func (s ParcelStore) ArriveAtPickup(number int) (string, error) {
+	p, err := s.Get(number)
+	if err != nil {
+		return "", err
+	}
+	if p.PickupPoint == 0 {
+		return "", ErrNotPickupParcel
+	}
+	if p.Status != ParcelStatusSent {
+		return "", ErrStatusChanged
+	}
+
+	code, err := newPickupCode()
+	if err != nil {
+		return "", err
+	}
+
+	now := s.now()
+	_, err = s.db.Exec("INSERT INTO {pickup_arrival} (parcel, point, arrived_at, code_hash, code_expires_at, code_attempts) "+
+		"VALUES (:parcel, :point, :arrived_at, :code_hash, :code_expires_at, 0) "+
+		"ON CONFLICT (parcel) DO UPDATE SET code_hash = excluded.code_hash, "+
+		"code_expires_at = excluded.code_expires_at, code_attempts = 0",
+		sql.Named("parcel", number),
+		sql.Named("point", p.PickupPoint),
+		sql.Named("arrived_at", formatTime(now)),
+		sql.Named("code_hash", hashPickupCode(code)),
+		sql.Named("code_expires_at", formatTime(now.Add(PickupCodeTTL))))
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// VerifyPickupCode проверяет код получения и при совпадении переводит
+// посылку в статус delivered. Каждая проверка расходует попытку, после
+// MaxPickupCodeAttempts код блокируется. Использованный код повторно
+// не принимается.
+func (s ParcelStore) VerifyPickupCode(number int, code string) error {
+	var hash string
+	var expiresAt time.Time
+	err := s.db.QueryRow("SELECT code_hash, code_expires_at FROM {pickup_arrival} WHERE parcel = :parcel",
+		sql.Named("parcel", number)).Scan(&hash, scanTime(&expiresAt))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotAwaitingPickup
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case hash == "":
+		return ErrNotAwaitingPickup
+	case s.now().After(expiresAt):
+		return ErrPickupCodeExpired
+	}
+
+	// попытка расходуется в одном запросе с проверкой лимита, поэтому
+	// одновременные проверки не превысят MaxPickupCodeAttempts
+	res, err := s.db.Exec("UPDATE {pickup_arrival} SET code_attempts = code_attempts + 1 "+
+		"WHERE parcel = :parcel AND code_hash = :hash AND code_attempts < :max",
+		sql.Named("parcel", number),
+		sql.Named("hash", hash),
+		sql.Named("max", MaxPickupCodeAttempts))
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrPickupCodeLocked
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(hashPickupCode(code))) != 1 {
+		return ErrInvalidPickupCode
+	}
+
+	// условие на статус в TransitionStatus не даст выдать посылку дважды
+	if err := s.TransitionStatus(number, ParcelStatusSent, ParcelStatusDelivered); err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("UPDATE {pickup_arrival} SET code_hash = '' WHERE parcel = :parcel", sql.Named("parcel", number))
+
+	return err
+}
+
+// newPickupCode возвращает случайный шестизначный код получения
+func newPickupCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// hashPickupCode возвращает хеш кода получения для хранения в БД
+func hashPickupCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// ListAwaitingPickup возвращает посылки, которые прибыли в пункт выдачи
+// и ещё не выданы, в порядке прибытия
+func (s ParcelStore) ListAwaitingPickup(point int) ([]Parcel, error) {
+	rows, err := s.db.Query(parcelSelect+"JOIN {pickup_arrival} a ON a.parcel = number "+
+		"WHERE a.point = :point AND status = :sent ORDER BY a.arrived_at, number",
+		sql.Named("point", point),
+		sql.Named("sent", ParcelStatusSent))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanParcels(rows)
+}
+
+// ReturnExpiredPickups переводит в статус returned посылки, пролежавшие
+// в пункте выдачи больше days дней, и возвращает их количество. Это
+// системное действие, как SetStatus, граф переходов арендатора не проверяется.
+// Каждая посылка возвращается отдельно, поэтому хуки смены статуса и журнал
+// изменений видят каждый возврат.
+func (s ParcelStore) ReturnExpiredPickups(days int) (int, error) {
+	now := s.now()
+	rows, err := s.db.Query("SELECT number FROM {parcel} WHERE status = :sent "+
+		"AND number IN (SELECT parcel FROM {pickup_arrival} WHERE arrived_at < :deadline) ORDER BY number",
+		sql.Named("sent", ParcelStatusSent),
+		sql.Named("deadline", formatTime(now.AddDate(0, 0, -days))))
+	if err != nil {
+		return 0, err
+	}
+
+	var numbers []int
+	for rows.Next() {
+		var number int
+		if err := rows.Scan(&number); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		numbers = append(numbers, number)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	returned := 0
+	for _, number := range numbers {
+		ok, err := s.returnPickup(number, now)
+		if err != nil {
+			return returned, err
+		}
+		if ok {
+			returned++
+		}
+	}
+
+	return returned, nil
+}
+
+// returnPickup переводит посылку number в статус returned, если она всё
+// ещё в статусе sent, и сообщает, изменился ли статус
+func (s ParcelStore) returnPickup(number int, now time.Time) (bool, error) {
+	var ok bool
+	err := s.audited(context.Background(), number, AuditTransition, func() error {
+		res, err := s.db.Exec("UPDATE {parcel} SET status = :status, "+statusTimesSet+" WHERE number = :number AND status = :sent",
+			sql.Named("status", ParcelStatusReturned),
+			sql.Named("number", number),
+			sql.Named("sent", ParcelStatusSent),
+			sql.Named("delivered", ParcelStatusDelivered),
+			sql.Named("now", formatTime(now)))
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if ok = affected > 0; ok {
+			s.afterStatusChange(StatusChange{Number: number, From: ParcelStatusSent, To: ParcelStatusReturned, At: now})
+		}
+
+		return nil
+	})
+
+	return ok, err
+}
+
+// RunPickupExpiry каждые interval возвращает посылки с истёкшим сроком
+// хранения, пока не отменён ctx. Ошибки записываются в errors.
+func (s ParcelStore) RunPickupExpiry(ctx context.Context, days int, interval time.Duration, errors *ErrorLog) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.ReturnExpiredPickups(days); err != nil && ctx.Err() == nil {
+			errors.Record(err)
+		}
+	}
+}
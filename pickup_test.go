@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// addTestPickupParcel добавляет отправленную посылку в пункт выдачи point
+func addTestPickupParcel(t *testing.T, store ParcelStore, point int) int {
+	t.Helper()
+
+	parcel := getTestParcel()
+	parcel.Address = ""
+	parcel.PickupPoint = point
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+
+	return number
+}
+
+// TestAddPickupParcel проверяет добавление посылки с пунктом выдачи вместо адреса
+func TestAddPickupParcel(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	point, err := store.AddPickupPoint(PickupPoint{Name: "Постамат у метро", Address: "Москва, ул. Тверская, д. 1", Locker: true})
+	require.NoError(t, err)
+
+	// add
+	number := addTestPickupParcel(t, store, point)
+
+	// check
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, point, stored.PickupPoint)
+	require.Equal(t, "Москва, ул. Тверская, д. 1", stored.Address)
+
+	parcel := getTestParcel()
+	parcel.PickupPoint = point + 1
+	_, err = store.Add(parcel)
+	require.ErrorIs(t, err, ErrPickupPointNotFound)
+
+	parcel = getTestParcel()
+	parcel.Address = ""
+	_, err = store.Add(parcel)
+	require.ErrorIs(t, err, ErrInvalidParcel)
+}
+
+// TestListAwaitingPickup проверяет список посылок, ожидающих получателя
+func TestListAwaitingPickup(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	point, err := store.AddPickupPoint(PickupPoint{Name: "ПВЗ", Address: "Псков, ул. Советская, д. 2"})
+	require.NoError(t, err)
+
+	arrived := addTestPickupParcel(t, store, point)
+	addTestPickupParcel(t, store, point)
+	collected := addTestPickupParcel(t, store, point)
+
+	// arrive
+	_, err = store.ArriveAtPickup(arrived)
+	require.NoError(t, err)
+	_, err = store.ArriveAtPickup(arrived)
+	require.NoError(t, err)
+	_, err = store.ArriveAtPickup(collected)
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(collected, ParcelStatusDelivered))
+
+	plain, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	_, err = store.ArriveAtPickup(plain)
+	require.ErrorIs(t, err, ErrNotPickupParcel)
+
+	// check
+	parcels, err := store.ListAwaitingPickup(point)
+	require.NoError(t, err)
+	require.Len(t, parcels, 1)
+	require.Equal(t, arrived, parcels[0].Number)
+}
+
+// TestReturnExpiredPickups проверяет возврат посылок с истёкшим сроком хранения
+func TestReturnExpiredPickups(t *testing.T) {
+	// prepare
+	var changes []StatusChange
+	store := NewParcelStore(openTestDB(t), WithHooks(Hooks{OnAfterStatusChange: func(c StatusChange) {
+		if c.To == ParcelStatusReturned {
+			changes = append(changes, c)
+		}
+	}}))
+	point, err := store.AddPickupPoint(PickupPoint{Name: "ПВЗ", Address: "Псков, ул. Советская, д. 2"})
+	require.NoError(t, err)
+
+	expired := addTestPickupParcel(t, store, point)
+	fresh := addTestPickupParcel(t, store, point)
+	_, err = store.ArriveAtPickup(expired)
+	require.NoError(t, err)
+	_, err = store.ArriveAtPickup(fresh)
+	require.NoError(t, err)
+	_, err = store.db.Exec("UPDATE {pickup_arrival} SET arrived_at = :arrived_at WHERE parcel = :parcel",
+		sql.Named("arrived_at", formatTime(time.Now().AddDate(0, 0, -8))),
+		sql.Named("parcel", expired))
+	require.NoError(t, err)
+
+	// return
+	n, err := store.ReturnExpiredPickups(DefaultPickupStorageDays)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// check
+	stored, err := store.Get(expired)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusReturned, stored.Status)
+	require.Len(t, changes, 1)
+	require.Equal(t, expired, changes[0].Number)
+	require.Equal(t, ParcelStatusSent, changes[0].From)
+
+	parcels, err := store.ListAwaitingPickup(point)
+	require.NoError(t, err)
+	require.Len(t, parcels, 1)
+	require.Equal(t, fresh, parcels[0].Number)
+}
+
+// TestVerifyPickupCode проверяет выдачу посылки по одноразовому коду
+func TestVerifyPickupCode(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	point, err := store.AddPickupPoint(PickupPoint{Name: "ПВЗ", Address: "Псков, ул. Советская, д. 2"})
+	require.NoError(t, err)
+	number := addTestPickupParcel(t, store, point)
+
+	require.ErrorIs(t, store.VerifyPickupCode(number, "000000"), ErrNotAwaitingPickup)
+
+	code, err := store.ArriveAtPickup(number)
+	require.NoError(t, err)
+	require.Len(t, code, 6)
+
+	// verify
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	require.ErrorIs(t, store.VerifyPickupCode(number, wrong), ErrInvalidPickupCode)
+	require.NoError(t, store.VerifyPickupCode(number, code))
+
+	// check
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDelivered, stored.Status)
+	require.ErrorIs(t, store.VerifyPickupCode(number, code), ErrNotAwaitingPickup)
+}
+
+// TestVerifyPickupCodeLimits проверяет блокировку кода и истечение его срока
+func TestVerifyPickupCodeLimits(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	point, err := store.AddPickupPoint(PickupPoint{Name: "ПВЗ", Address: "Псков, ул. Советская, д. 2"})
+	require.NoError(t, err)
+	number := addTestPickupParcel(t, store, point)
+	code, err := store.ArriveAtPickup(number)
+	require.NoError(t, err)
+
+	// блокировка после неверных попыток
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < MaxPickupCodeAttempts; i++ {
+		require.ErrorIs(t, store.VerifyPickupCode(number, wrong), ErrInvalidPickupCode)
+	}
+	require.ErrorIs(t, store.VerifyPickupCode(number, code), ErrPickupCodeLocked)
+
+	// перевыпуск снимает блокировку, но код может истечь
+	code, err = store.ArriveAtPickup(number)
+	require.NoError(t, err)
+	_, err = store.db.Exec("UPDATE {pickup_arrival} SET code_expires_at = :expires_at WHERE parcel = :parcel",
+		sql.Named("expires_at", formatTime(time.Now().Add(-time.Minute))),
+		sql.Named("parcel", number))
+	require.NoError(t, err)
+	require.ErrorIs(t, store.VerifyPickupCode(number, code), ErrPickupCodeExpired)
+
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, stored.Status)
+}
+
+// TestVerifyPickupCodeConcurrent проверяет, что одновременные проверки
+// не превышают MaxPickupCodeAttempts
+func TestVerifyPickupCodeConcurrent(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	point, err := store.AddPickupPoint(PickupPoint{Name: "ПВЗ", Address: "Псков, ул. Советская, д. 2"})
+	require.NoError(t, err)
+	number := addTestPickupParcel(t, store, point)
+	code, err := store.ArriveAtPickup(number)
+	require.NoError(t, err)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	// verify
+	var wg sync.WaitGroup
+	results := make(chan error, 4*MaxPickupCodeAttempts)
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- store.VerifyPickupCode(number, wrong)
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	// check
+	invalid := 0
+	for err := range results {
+		if errors.Is(err, ErrInvalidPickupCode) {
+			invalid++
+			continue
+		}
+		require.ErrorIs(t, err, ErrPickupCodeLocked)
+	}
+	require.Equal(t, MaxPickupCodeAttempts, invalid)
+	require.ErrorIs(t, store.VerifyPickupCode(number, code), ErrPickupCodeLocked)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// PoolConfig — настройки пула соединений *sql.DB. Нулевые MaxOpen,
+// MaxLifetime и MaxIdleTime не ограничивают пул, нулевой MaxIdle оставляет
+// умолчание database/sql, отрицательный — не держит простаивающих соединений.
+type PoolConfig struct {
+	MaxOpen     int
+	MaxIdle     int
+	MaxLifetime time.Duration
+	MaxIdleTime time.Duration
+}
+
+var (
+	// DefaultSQLitePool — пул SQLite: соединения локальны и не рвутся,
+	// поэтому не ограничиваются по времени; простаивающих держится больше
+	// умолчания, чтобы параллельные чтения в WAL не открывали БД заново
+	DefaultSQLitePool = PoolConfig{MaxIdle: 8}
+	// DefaultMySQLPool — пул MySQL: соединения сервера ограничены и
+	// закрываются сервером и прокси по простою, поэтому пул ограничен,
+	// а соединения пересоздаются раньше wait_timeout
+	DefaultMySQLPool = PoolConfig{MaxOpen: 32, MaxIdle: 16, MaxLifetime: 5 * time.Minute, MaxIdleTime: time.Minute}
+)
+
+// Apply настраивает пул соединений db
+func (c PoolConfig) Apply(db *sql.DB) {
+	db.SetMaxOpenConns(c.MaxOpen)
+	if c.MaxIdle != 0 {
+		db.SetMaxIdleConns(c.MaxIdle)
+	}
+	db.SetConnMaxLifetime(c.MaxLifetime)
+	db.SetConnMaxIdleTime(c.MaxIdleTime)
+}
+
+// PoolMetrics отдаёт состояние пула соединений БД как метрики Prometheus
+// в текстовом формате. Значения читаются из sql.DB.Stats при каждом запросе.
+type PoolMetrics struct {
+	backend string
+	db      *sql.DB
+}
+
+// NewPoolMetrics возвращает метрики пула db хранилища backend, например sqlite
+func NewPoolMetrics(backend string, db *sql.DB) *PoolMetrics {
+	return &PoolMetrics{backend: backend, db: db}
+}
+
+func (m *PoolMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	st := m.db.Stats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, g := range []struct {
+		name, help string
+		value      int
+	}{
+		{"tracker_db_pool_max_open", "Наибольшее число соединений пула, 0 — без ограничения.", st.MaxOpenConnections},
+		{"tracker_db_pool_open", "Открытые соединения пула.", st.OpenConnections},
+		{"tracker_db_pool_in_use", "Соединения пула, занятые запросами.", st.InUse},
+		{"tracker_db_pool_idle", "Простаивающие соединения пула.", st.Idle},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n", g.name, g.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+		fmt.Fprintf(w, "%s{backend=%q} %d\n", g.name, m.backend, g.value)
+	}
+	for _, c := range []struct {
+		name, help string
+		value      int64
+	}{
+		{"tracker_db_pool_wait_total", "Запросы, ждавшие свободного соединения.", st.WaitCount},
+		{"tracker_db_pool_max_idle_closed_total", "Соединения, закрытые сверх MaxIdle.", st.MaxIdleClosed},
+		{"tracker_db_pool_max_idle_time_closed_total", "Соединения, закрытые по MaxIdleTime.", st.MaxIdleTimeClosed},
+		{"tracker_db_pool_max_lifetime_closed_total", "Соединения, закрытые по MaxLifetime.", st.MaxLifetimeClosed},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+		fmt.Fprintf(w, "%s{backend=%q} %d\n", c.name, m.backend, c.value)
+	}
+	fmt.Fprintln(w, "# HELP tracker_db_pool_wait_seconds_total Суммарное ожидание свободного соединения.")
+	fmt.Fprintln(w, "# TYPE tracker_db_pool_wait_seconds_total counter")
+	fmt.Fprintf(w, "tracker_db_pool_wait_seconds_total{backend=%q} %g\n", m.backend, st.WaitDuration.Seconds())
+}
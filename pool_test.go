@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolConfigApply(t *testing.T) {
+	// prepare
+	db := openTestDB(t)
+	PoolConfig{MaxOpen: 1, MaxIdle: -1, MaxLifetime: time.Minute}.Apply(db)
+
+	// check
+	conn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	st := db.Stats()
+	require.Equal(t, 1, st.MaxOpenConnections)
+	require.Zero(t, st.Idle)
+	require.Positive(t, st.MaxIdleClosed)
+}
+
+func TestPoolMetrics(t *testing.T) {
+	// prepare
+	db := openTestDB(t)
+	PoolConfig{MaxOpen: 1}.Apply(db)
+	conn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+
+	// второй запрос ждёт, пока первый вернёт соединение
+	done := make(chan error)
+	go func() {
+		_, err := db.Exec("SELECT 1")
+		done <- err
+	}()
+	require.Eventually(t, func() bool { return db.Stats().WaitCount == 1 }, time.Second, time.Millisecond)
+	require.NoError(t, conn.Close())
+	require.NoError(t, <-done)
+
+	rec := httptest.NewRecorder()
+	NewPoolMetrics("sqlite", db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/pool", nil))
+
+	// check
+	body := rec.Body.String()
+	require.Contains(t, body, "# TYPE tracker_db_pool_in_use gauge\n")
+	require.Contains(t, body, `tracker_db_pool_max_open{backend="sqlite"} 1`+"\n")
+	require.Contains(t, body, `tracker_db_pool_in_use{backend="sqlite"} 0`+"\n")
+	require.Contains(t, body, `tracker_db_pool_idle{backend="sqlite"} 1`+"\n")
+	require.Contains(t, body, `tracker_db_pool_wait_total{backend="sqlite"} 1`+"\n")
+	require.Contains(t, body, "# TYPE tracker_db_pool_wait_seconds_total counter\n")
+}
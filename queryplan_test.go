@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// plannedQuery — запрос хранилища и его план EXPLAIN QUERY PLAN
+type plannedQuery struct {
+	Query string
+	Plan  string
+}
+
+var (
+	tracedQueryRe = regexp.MustCompile(`query=("(?:[^"\\]|\\.)*")`)
+	tracedPlanRe  = regexp.MustCompile(`plan=("(?:[^"\\]|\\.)*")`)
+	// fullScanRe — полный просмотр таблицы; просмотр по индексу
+	// (SCAN t USING INDEX) и поиск (SEARCH) сюда не попадают
+	fullScanRe = regexp.MustCompile(`^SCAN (\w+)$`)
+)
+
+// captureQueryPlans передаёт в fn копию store с трассировкой запросов и
+// возвращает планы всех выполненных через неё запросов, см. StatementTracer
+func captureQueryPlans(t *testing.T, store ParcelStore, fn func(store ParcelStore)) []plannedQuery {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tracer := NewStatementTracer(log.New(&buf, "", 0))
+	tracer.SetEnabled(true)
+	store.tracer = tracer
+	store.db.tracer = tracer
+	fn(store)
+
+	var res []plannedQuery
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		query, plan := tracedQueryRe.FindStringSubmatch(line), tracedPlanRe.FindStringSubmatch(line)
+		require.NotNil(t, query, "%s", line)
+		require.NotNil(t, plan, "%s", line)
+		q, err := strconv.Unquote(query[1])
+		require.NoError(t, err)
+		p, err := strconv.Unquote(plan[1])
+		require.NoError(t, err)
+		res = append(res, plannedQuery{Query: q, Plan: p})
+	}
+
+	return res
+}
+
+// requireIndexedPlans проверяет, что ни один запрос не просматривает
+// таблицу целиком, кроме таблиц smallTables, которые по назначению малы
+// (справочники и настройки) и индексы которым не нужны
+func requireIndexedPlans(t *testing.T, plans []plannedQuery, smallTables ...string) {
+	t.Helper()
+
+	small := map[string]bool{}
+	for _, table := range smallTables {
+		small[table] = true
+	}
+	for _, p := range plans {
+		for _, table := range fullScans(p.Plan) {
+			require.Truef(t, small[table], "полный просмотр %s в запросе %q: %s", table, p.Query, p.Plan)
+		}
+	}
+}
+
+// fullScans возвращает таблицы, которые план просматривает целиком
+func fullScans(plan string) []string {
+	var tables []string
+	for _, step := range strings.Split(plan, "; ") {
+		if m := fullScanRe.FindStringSubmatch(step); m != nil {
+			tables = append(tables, m[1])
+		}
+	}
+
+	return tables
+}
+
+func TestQueryPlans(t *testing.T) {
+	// prepare
+	plans := captureQueryPlans(t, NewParcelStore(openTestDB(t)), func(store ParcelStore) {
+		number, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+
+		p, err := store.Get(number)
+		require.NoError(t, err)
+		_, err = store.GetByUUID(p.UUID)
+		require.NoError(t, err)
+		_, err = store.GetByClient(p.Client)
+		require.NoError(t, err)
+
+		require.NoError(t, store.SetAddress(number, "new test address"))
+		require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+		require.NoError(t, store.SetStatus(number, ParcelStatusDelivered))
+		other, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		require.NoError(t, store.Delete(other))
+
+		_, err = store.ProjectTracking(trackingViewBatch)
+		require.NoError(t, err)
+		_, err = store.GetTrackingView(context.Background(), p.UUID)
+		require.NoError(t, err)
+		require.NoError(t, store.RefreshDailyStats(context.Background()))
+		_, err = store.DailyStats(context.Background(), p.CreatedAt, p.CreatedAt)
+		require.NoError(t, err)
+	})
+	require.NotEmpty(t, plans)
+	requireIndexedPlans(t, plans)
+}
+
+func TestFullScans(t *testing.T) {
+	for plan, tables := range map[string][]string{
+		"SCAN parcel": {"parcel"},
+		"SEARCH parcel USING INDEX parcel_uuid_uq (uuid=?)":                                     nil,
+		"SCAN parcel USING INDEX parcel_updated_idx":                                            nil,
+		"SEARCH a USING INTEGER PRIMARY KEY (rowid=?); SCAN note; SCAN zone; SCAN CONSTANT ROW": {"note", "zone"},
+	} {
+		require.Equal(t, tables, fullScans(plan), plan)
+	}
+}
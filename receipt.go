@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	// TopicNotification — тема outbox для уведомлений, payload — Notification
+	TopicNotification = "notification"
+)
+
+// Receipt — квитанция о регистрации посылки для отправителя
+type Receipt struct {
+	// TrackingCode — публичный код отслеживания, совпадает с UUID посылки
+	TrackingCode string
+	Address      string
+	ServiceLevel string
+	// StatusName — название статуса на языке клиента
+	StatusName string
+	// ETA — ожидаемый срок доставки по SLA уровня сервиса
+	ETA time.Time
+	// Price — итоговая стоимость в младших единицах Currency:
+	// доставка со скидкой и страховая премия
+	Price    int64
+	Currency string
+	// ManageURL — ссылка с токеном получателя, по которой можно выбрать
+	// окно доставки, оставить указания или переадресовать посылку;
+	// пустая, если ссылки не настроены, см. WithPublicBaseURL
+	ManageURL string
+}
+
+// PriceRub возвращает стоимость в основных единицах валюты для шаблона.
+// Название осталось со времён, когда все суммы были в рублях, на него
+// ссылаются шаблоны арендаторов.
+func (r Receipt) PriceRub() string {
+	return FormatAmount(r.Price, r.Currency)
+}
+
+// defaultReceiptTemplates — шаблоны квитанции по языкам для арендаторов
+// без своего шаблона. Шаблон определяет блоки subject и body.
+var defaultReceiptTemplates = map[string]*template.Template{
+	LocaleRU: template.Must(template.New("receipt").Parse(
+		`{{define "subject"}}Посылка {{.TrackingCode}} зарегистрирована{{end}}` +
+			`{{define "body"}}Статус вашей посылки: {{.StatusName}}.
+
+Код отслеживания: {{.TrackingCode}}
+Адрес доставки: {{.Address}}
+Ожидаемая доставка: до {{.ETA.Format "02.01.2006 15:04"}} UTC
+Стоимость: {{.PriceRub}} {{if eq .Currency "RUB"}}руб.{{else}}{{.Currency}}{{end}}
+{{if .ManageURL}}Управление доставкой: {{.ManageURL}}
+{{end}}{{end}}`)),
+	LocaleEN: template.Must(template.New("receipt").Parse(
+		`{{define "subject"}}Parcel {{.TrackingCode}} registered{{end}}` +
+			`{{define "body"}}Your parcel status: {{.StatusName}}.
+
+Tracking code: {{.TrackingCode}}
+Delivery address: {{.Address}}
+Expected delivery: by {{.ETA.Format "2006-01-02 15:04"}} UTC
+Price: {{.PriceRub}} {{.Currency}}
+{{if .ManageURL}}Manage delivery: {{.ManageURL}}
+{{end}}{{end}}`)),
+}
+
+// NewReceipt составляет квитанцию по добавленной посылке на языке клиента
+func NewReceipt(p Parcel) (Receipt, error) {
+	sla, err := SLA(p.ServiceLevel)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	return Receipt{
+		TrackingCode: p.UUID,
+		Address:      p.Address,
+		ServiceLevel: p.ServiceLevel,
+		StatusName:   StatusName(p.Locale, p.Status),
+		ETA:          p.CreatedAt.Add(sla),
+		Price:        p.Price - p.Discount + p.InsurancePremium,
+		Currency:     p.Currency,
+	}, nil
+}
+
+// receiptNotification формирует письмо с квитанцией по шаблону арендатора
+// посылки или по шаблону по умолчанию на языке клиента
+func (s ParcelStore) receiptNotification(p Parcel) (Notification, error) {
+	r, err := NewReceipt(p)
+	if err != nil {
+		return Notification{}, err
+	}
+	if r.ManageURL, err = s.recipientLink(p.UUID); err != nil {
+		return Notification{}, err
+	}
+
+	tpl, ok := defaultReceiptTemplates[p.Locale]
+	if !ok {
+		tpl = defaultReceiptTemplates[DefaultLocale]
+	}
+	if t, ok := s.receipts[p.Tenant]; ok {
+		tpl = t
+	}
+
+	var subject, body strings.Builder
+	if err := tpl.ExecuteTemplate(&subject, "subject", r); err != nil {
+		return Notification{}, err
+	}
+	if err := tpl.ExecuteTemplate(&body, "body", r); err != nil {
+		return Notification{}, err
+	}
+
+	return Notification{Channel: ChannelEmail, To: p.SenderEmail, Subject: subject.String(), Body: body.String()}, nil
+}
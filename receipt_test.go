@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testNotifier запоминает отправленные уведомления и может вернуть ошибку
+type testNotifier struct {
+	sent []Notification
+	err  error
+}
+
+func (n *testNotifier) Notify(ctx context.Context, msg Notification) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.sent = append(n.sent, msg)
+
+	return nil
+}
+
+// TestReceiptQueued проверяет постановку квитанции в outbox при добавлении посылки
+func TestReceiptQueued(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestInsuredParcel()
+	parcel.SenderEmail = "sender@example.com"
+	parcel.Price = 25_000
+
+	// add
+	_, err := store.Add(parcel)
+	require.NoError(t, err)
+	_, err = store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// check
+	messages, err := store.PendingOutbox(10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, TopicNotification, messages[0].Topic)
+
+	var n Notification
+	require.NoError(t, json.Unmarshal(messages[0].Payload, &n))
+	require.Equal(t, ChannelEmail, n.Channel)
+	require.Equal(t, "sender@example.com", n.To)
+	require.Contains(t, n.Subject, parcel.UUID)
+	require.Contains(t, n.Body, "Статус вашей посылки: Зарегистрирована.")
+	require.Contains(t, n.Body, "Стоимость: 350.00 руб.")
+	require.Contains(t, n.Body, parcel.CreatedAt.Add(5*24*time.Hour).Format("02.01.2006 15:04"))
+}
+
+// TestReceiptLocale проверяет квитанцию на языке клиента
+func TestReceiptLocale(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	parcel.SenderEmail = "sender@example.com"
+	parcel.Locale = LocaleEN
+
+	// add
+	_, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	// check
+	notifier := &testNotifier{}
+	_, err = store.RelayOutbox(context.Background(), notifier, 10)
+	require.NoError(t, err)
+	require.Equal(t, "Parcel "+parcel.UUID+" registered", notifier.sent[0].Subject)
+	require.Contains(t, notifier.sent[0].Body, "Your parcel status: Registered.")
+
+	parcel.UUID = ""
+	parcel.Locale = "de"
+	_, err = store.Add(parcel)
+	require.ErrorIs(t, err, ErrInvalidParcel)
+}
+
+// TestReceiptTenantTemplate проверяет шаблон квитанции арендатора
+func TestReceiptTenantTemplate(t *testing.T) {
+	// prepare
+	tpl := template.Must(template.New("acme").Parse(
+		`{{define "subject"}}ACME: {{.TrackingCode}}{{end}}{{define "body"}}{{.ServiceLevel}}{{end}}`))
+	store := NewParcelStore(openTestDB(t), WithReceiptTemplate("acme", tpl))
+
+	parcel := getTestParcel()
+	parcel.SenderEmail = "sender@example.com"
+	parcel.Tenant = "acme"
+
+	// add
+	_, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	// check
+	notifier := &testNotifier{}
+	sent, err := store.RelayOutbox(context.Background(), notifier, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, sent)
+	require.Equal(t, "ACME: "+parcel.UUID, notifier.sent[0].Subject)
+	require.Equal(t, ServiceLevelStandard, notifier.sent[0].Body)
+}
+
+// TestRelayOutbox проверяет, что неотправленные уведомления остаются в outbox
+func TestRelayOutbox(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	parcel.SenderEmail = "sender@example.com"
+	_, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	// relay
+	failing := &testNotifier{err: errors.New("smtp down")}
+	sent, err := store.RelayOutbox(context.Background(), failing, 10)
+	require.Error(t, err)
+	require.Zero(t, sent)
+
+	notifier := &testNotifier{}
+	sent, err = store.RelayOutbox(context.Background(), notifier, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, sent)
+
+	// check
+	messages, err := store.PendingOutbox(10)
+	require.NoError(t, err)
+	require.Empty(t, messages)
+}
+
+// TestAddInvalidSenderEmail проверяет отказ от некорректного адреса отправителя
+func TestAddInvalidSenderEmail(t *testing.T) {
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	parcel.SenderEmail = "not an email"
+
+	_, err := store.Add(parcel)
+	require.ErrorIs(t, err, ErrInvalidParcel)
+}
+
+// TestRelayOutboxDedupKey проверяет, что повторная отправка несёт тот же ключ
+// дедупликации, в том числе после возврата из недоставленных
+func TestRelayOutboxDedupKey(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	parcel.SenderEmail = "sender@example.com"
+	_, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	messages, err := store.PendingOutbox(10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	key := messages[0].DedupKey
+	require.NotEmpty(t, key)
+
+	// relay
+	for i := 0; i < MaxOutboxAttempts; i++ {
+		_, err = store.RelayOutbox(context.Background(), &testNotifier{err: errors.New("timeout")}, 10)
+		require.Error(t, err)
+	}
+	letters, err := store.ListDeadLetters(10)
+	require.NoError(t, err)
+	require.NoError(t, store.RequeueDeadLetter(letters[0].ID))
+
+	notifier := &testNotifier{}
+	_, err = store.RelayOutbox(context.Background(), notifier, 10)
+	require.NoError(t, err)
+
+	// check
+	require.Equal(t, key, notifier.sent[0].DedupKey)
+	require.Equal(t, "Message-ID: <"+key+"@tracker>\r\n", messageID(key))
+	require.Empty(t, messageID("bad>key"))
+}
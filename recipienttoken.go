@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultRecipientTokenTTL — срок действия токена получателя по умолчанию
+const DefaultRecipientTokenTTL = 14 * 24 * time.Hour
+
+var (
+	ErrRecipientTokensDisabled = errors.New("токены получателя не настроены, см. WithRecipientTokenKey")
+	ErrInvalidRecipientToken   = errors.New("неверный токен получателя")
+	ErrRecipientTokenExpired   = errors.New("срок действия токена получателя истёк")
+)
+
+// maxRecipientActionBody — наибольший размер тела публичного действия
+const maxRecipientActionBody = 4096
+
+// recipientTokens — ключ и срок действия токенов получателя
+type recipientTokens struct {
+	key []byte
+	ttl time.Duration
+	// baseURL — адрес публичной страницы отслеживания для ссылок в
+	// уведомлениях, см. WithPublicBaseURL
+	baseURL string
+}
+
+// WithRecipientTokenKey включает токены получателя: ими получатель без
+// учётной записи подтверждает действия с посылкой в публичном API.
+// Токен подписывается ключом key и действует ttl, 0 — DefaultRecipientTokenTTL.
+func WithRecipientTokenKey(key []byte, ttl time.Duration) StoreOption {
+	if ttl <= 0 {
+		ttl = DefaultRecipientTokenTTL
+	}
+
+	return func(s *ParcelStore) {
+		s.recipientTokens.key = key
+		s.recipientTokens.ttl = ttl
+	}
+}
+
+// WithPublicBaseURL задаёт адрес публичной страницы отслеживания, например
+// https://track.example.com. Уведомления ведут на {base}/track/{code}
+// с токеном получателя, если токены включены, письма подписчикам —
+// с токеном отписки.
+func WithPublicBaseURL(base string) StoreOption {
+	return func(s *ParcelStore) {
+		s.recipientTokens.baseURL = strings.TrimSuffix(base, "/")
+	}
+}
+
+// RecipientToken выдаёт токен получателя посылки с кодом отслеживания
+// code. Токен имеет вид {срок действия в секундах Unix}.{подпись} и не
+// хранится в БД.
+func (s ParcelStore) RecipientToken(code string) (string, error) {
+	if len(s.recipientTokens.key) == 0 {
+		return "", ErrRecipientTokensDisabled
+	}
+
+	expires := strconv.FormatInt(s.now().Add(s.recipientTokens.ttl).Unix(), 10)
+
+	return expires + "." + s.recipientTokens.sign(code, expires), nil
+}
+
+// VerifyRecipientToken проверяет токен получателя посылки с кодом code
+func (s ParcelStore) VerifyRecipientToken(code string, token string) error {
+	if len(s.recipientTokens.key) == 0 {
+		return ErrRecipientTokensDisabled
+	}
+
+	expires, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.recipientTokens.sign(code, expires))) {
+		return ErrInvalidRecipientToken
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidRecipientToken
+	}
+	if !s.now().Before(time.Unix(unix, 0)) {
+		return ErrRecipientTokenExpired
+	}
+
+	return nil
+}
+
+// sign возвращает подпись кода посылки и срока действия токена
+func (t recipientTokens) sign(code string, expires string) string {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(strings.ToLower(code) + "." + expires))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// recipientLink возвращает ссылку для уведомления, по которой получатель
+// посылки с кодом code управляет доставкой. Пустая строка — адрес
+// страницы или ключ токенов не заданы.
+func (s ParcelStore) recipientLink(code string) (string, error) {
+	if s.recipientTokens.baseURL == "" || len(s.recipientTokens.key) == 0 {
+		return "", nil
+	}
+
+	token, err := s.RecipientToken(code)
+	if err != nil {
+		return "", err
+	}
+
+	return s.recipientTokens.baseURL + "/track/" + code + "?token=" + url.QueryEscape(token), nil
+}
+
+// recipientRequest — общая часть тела публичных действий получателя
+type recipientRequest struct {
+	Token string `json:"token"`
+}
+
+// recipientAction возвращает публичный обработчик POST
+// /track/{code}/{action}, как trackAction, но передаёт запрос в do только
+// с токеном получателя в поле token JSON тела. На любой неподходящий
+// токен отвечает 403.
+func recipientAction(store ParcelStore, errLog *ErrorLog, action string,
+	do func(w http.ResponseWriter, r *http.Request, p Parcel, body []byte)) http.Handler {
+	return trackAction(store, errLog, action, func(w http.ResponseWriter, r *http.Request, p Parcel, body []byte) {
+		var req recipientRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "некорректный JSON", http.StatusBadRequest)
+			return
+		}
+		if err := store.VerifyRecipientToken(p.UUID, req.Token); err != nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		do(w, r, p, body)
+	})
+}
+
+// trackAction возвращает публичный обработчик POST /track/{code}/{action}.
+// Он читает JSON тело и передаёт в do посылку и тело; на несуществующий
+// и некорректный код отвечает 404.
+func trackAction(store ParcelStore, errLog *ErrorLog, action string,
+	do func(w http.ResponseWriter, r *http.Request, p Parcel, body []byte)) http.Handler {
+	h := AdminHandler{store: store, errors: errLog}
+
+	return h.postOnly(func(w http.ResponseWriter, r *http.Request) {
+		code := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/track/"), "/"+action)
+		if _, err := uuid.Parse(code); err != nil || strings.Contains(code, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRecipientActionBody))
+		if err != nil {
+			http.Error(w, "слишком большой запрос", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !json.Valid(body) {
+			http.Error(w, "некорректный JSON", http.StatusBadRequest)
+			return
+		}
+
+		p, err := store.GetByUUID(strings.ToLower(code))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		// действия вызываются со страницы отслеживания на другом домене
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		do(w, r, p, body)
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestRecipientToken проверяет выдачу и проверку токена получателя
+func TestRecipientToken(t *testing.T) {
+	// prepare
+	clock := NewManualClock(time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC))
+	store := NewParcelStore(openTestDB(t), WithClock(clock), WithRecipientTokenKey([]byte("секрет"), time.Hour))
+	code := getTestParcel().UUID
+
+	// issue
+	token, err := store.RecipientToken(code)
+	require.NoError(t, err)
+
+	// check
+	require.NoError(t, store.VerifyRecipientToken(code, token))
+	require.ErrorIs(t, store.VerifyRecipientToken(getTestParcel().UUID, token), ErrInvalidRecipientToken)
+	require.ErrorIs(t, store.VerifyRecipientToken(code, "1.abc"), ErrInvalidRecipientToken)
+	require.ErrorIs(t, store.VerifyRecipientToken(code, ""), ErrInvalidRecipientToken)
+
+	// токен, подписанный другим ключом, не принимается
+	other := NewParcelStore(openTestDB(t), WithClock(clock), WithRecipientTokenKey([]byte("другой"), time.Hour))
+	require.ErrorIs(t, other.VerifyRecipientToken(code, token), ErrInvalidRecipientToken)
+
+	clock.Advance(time.Hour)
+	require.ErrorIs(t, store.VerifyRecipientToken(code, token), ErrRecipientTokenExpired)
+
+	// без ключа токены не выдаются
+	_, err = NewParcelStore(openTestDB(t)).RecipientToken(code)
+	require.ErrorIs(t, err, ErrRecipientTokensDisabled)
+}
+
+// TestReceiptManageURL проверяет ссылку с токеном получателя в квитанции
+func TestReceiptManageURL(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithRecipientTokenKey([]byte("секрет"), 0),
+		WithPublicBaseURL("https://track.example.com/"))
+	parcel := getTestParcel()
+	parcel.SenderEmail = "sender@example.com"
+	_, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	// check
+	messages, err := store.PendingOutbox(10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	var n Notification
+	require.NoError(t, json.Unmarshal(messages[0].Payload, &n))
+
+	prefix := "Управление доставкой: https://track.example.com/track/" + parcel.UUID + "?token="
+	require.Contains(t, n.Body, prefix)
+	link := strings.TrimSpace(n.Body[strings.Index(n.Body, prefix)+len("Управление доставкой: "):])
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	require.NoError(t, store.VerifyRecipientToken(parcel.UUID, u.Query().Get("token")))
+}
+
+// TestRecipientActions проверяет действия получателя в публичном API
+func TestRecipientActions(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithRecipientTokenKey([]byte("секрет"), 0))
+	parcel := getTestParcel()
+	parcel.RecipientPhone = "+79990000000"
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+	token, err := store.RecipientToken(parcel.UUID)
+	require.NoError(t, err)
+	handler := NewHTTPHandler(store, NewErrorLog(10))
+
+	post := func(action string, body map[string]any) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/track/"+parcel.UUID+"/"+action, strings.NewReader(string(data))))
+		return rec
+	}
+
+	// окно доставки
+	require.Equal(t, http.StatusForbidden, post("window", map[string]any{"slot": "09-12"}).Code)
+	require.Equal(t, http.StatusBadRequest, post("window", map[string]any{"token": token, "slot": "00-03"}).Code)
+	require.Equal(t, http.StatusNoContent, post("window", map[string]any{"token": token, "slot": "09-12"}).Code)
+	slot, err := store.GetDeliveryWindow(number)
+	require.NoError(t, err)
+	require.Equal(t, "09-12", slot)
+
+	// переадресация с подтверждением кодом из SMS
+	rec := post("redirect", map[string]any{"token": token, "address": "Псков, ул. Новая, д. 1"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created struct {
+		ID     int    `json:"id"`
+		Status string `json:"status"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.Equal(t, RedirectUnverified, created.Status)
+	require.Equal(t, http.StatusConflict, post("redirect", map[string]any{"token": token, "address": "Псков"}).Code)
+
+	require.Equal(t, http.StatusNotFound, post("redirect/verify", map[string]any{"token": token, "id": created.ID + 1, "code": "000000"}).Code)
+	require.Equal(t, http.StatusBadRequest, post("redirect/verify", map[string]any{"token": token, "id": created.ID, "code": "x"}).Code)
+
+	// неизвестное действие
+	require.Equal(t, http.StatusNotFound, post("cancel", map[string]any{"token": token}).Code)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// ConflictCarrierBehind — у перевозчика статус старше нашего
+	ConflictCarrierBehind = "carrier_behind"
+	// ConflictNoPath — граф переходов не ведёт из нашего статуса в статус перевозчика
+	ConflictNoPath = "no_path"
+	// ConflictMissing — наше незавершённое отправление отсутствует в выгрузке
+	ConflictMissing = "missing"
+)
+
+// ExternalStatus — текущий статус отправления в полной выгрузке перевозчика
+type ExternalStatus struct {
+	TrackingNumber string
+	Status         string
+	Time           time.Time
+}
+
+// ReconcileConflict — расхождение, которое сверка не исправила сама
+type ReconcileConflict struct {
+	Parcel         int
+	TrackingNumber string
+	Ours           string
+	Theirs         string
+	Kind           string
+}
+
+// ReconcileResult — итог сверки с выгрузкой перевозчика
+type ReconcileResult struct {
+	// Matched — отправления, статус которых совпал
+	Matched int
+	// Applied — применённые переходы статуса
+	Applied int
+	// Unknown — номера отслеживания из выгрузки, которых у нас нет
+	Unknown   []string
+	Conflicts []ReconcileConflict
+}
+
+// CarrierSnapshot сворачивает события файла перевозчика в выгрузку
+// статусов: по каждому номеру отслеживания остаётся последнее по времени
+// событие, меняющее статус. В отличие от ImportCarrierFile номер
+// отслеживания здесь — номер у перевозчика, а не код посылки.
+func CarrierSnapshot(events []CarrierEvent, l CarrierLayout) []ExternalStatus {
+	latest := make(map[string]ExternalStatus)
+	var order []string
+	for _, e := range events {
+		status := l.Codes[e.Code]
+		if status == "" {
+			continue
+		}
+		cur, ok := latest[e.Tracking]
+		if !ok {
+			order = append(order, e.Tracking)
+		}
+		if !ok || !e.Time.Before(cur.Time) {
+			latest[e.Tracking] = ExternalStatus{TrackingNumber: e.Tracking, Status: status, Time: e.Time}
+		}
+	}
+
+	res := make([]ExternalStatus, 0, len(order))
+	for _, tracking := range order {
+		res = append(res, latest[tracking])
+	}
+
+	return res
+}
+
+// Path возвращает кратчайшую цепочку переходов из from в to без from
+// или nil, если to из from не достижим
+func (g StatusGraph) Path(from string, to string) []string {
+	prev := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, next := range g[cur] {
+			if _, seen := prev[next]; seen {
+				continue
+			}
+			prev[next] = cur
+			if next == to {
+				var path []string
+				for s := to; s != from; s = prev[s] {
+					path = append([]string{s}, path...)
+				}
+				return path
+			}
+			queue = append(queue, next)
+		}
+	}
+
+	return nil
+}
+
+// Reconcile сверяет полную выгрузку статусов перевозчика carrier с нашими
+// отправлениями. Недостающие переходы применяются по графу статусов
+// арендатора посылки с временем из выгрузки, промежуточные статусы
+// получают то же время. Статус перевозчика, отстающий от нашего или
+// недостижимый по графу, а также незавершённые отправления, которых нет
+// в выгрузке, попадают в конфликты и не меняются.
+func (s ParcelStore) Reconcile(carrier string, snapshot []ExternalStatus) (ReconcileResult, error) {
+	shipments, err := s.carrierShipments(carrier)
+	if err != nil {
+		return ReconcileResult{}, err
+	}
+
+	res := ReconcileResult{}
+	seen := make(map[string]bool, len(snapshot))
+	for _, e := range snapshot {
+		seen[e.TrackingNumber] = true
+		number, ok := shipments[e.TrackingNumber]
+		if !ok {
+			res.Unknown = append(res.Unknown, e.TrackingNumber)
+			continue
+		}
+
+		applied, conflict, err := s.reconcileParcel(number, e)
+		if err != nil {
+			return res, fmt.Errorf("посылка № %d: %w", number, err)
+		}
+		switch {
+		case conflict.Kind != "":
+			res.Conflicts = append(res.Conflicts, conflict)
+		case applied == 0:
+			res.Matched++
+		default:
+			res.Applied += applied
+		}
+	}
+
+	for tracking, number := range shipments {
+		if seen[tracking] {
+			continue
+		}
+		p, err := s.Get(number)
+		if err != nil {
+			return res, err
+		}
+		// с доставленными и выбывшими посылками перевозчик больше не работает,
+		// как и в activeShipments
+		if p.Status != ParcelStatusDelivered && !offPathStatuses[p.Status] {
+			res.Conflicts = append(res.Conflicts, ReconcileConflict{
+				Parcel: number, TrackingNumber: tracking, Ours: p.Status, Kind: ConflictMissing,
+			})
+		}
+	}
+	sort.Slice(res.Conflicts, func(i, j int) bool { return res.Conflicts[i].Parcel < res.Conflicts[j].Parcel })
+
+	return res, nil
+}
+
+// reconcileParcel доводит посылку до статуса из выгрузки и возвращает
+// число применённых переходов или конфликт
+func (s ParcelStore) reconcileParcel(number int, e ExternalStatus) (int, ReconcileConflict, error) {
+	p, err := s.Get(number)
+	if err != nil {
+		return 0, ReconcileConflict{}, err
+	}
+	if p.Status == e.Status {
+		return 0, ReconcileConflict{}, nil
+	}
+
+	graph, err := s.StatusGraph(p.Tenant)
+	if err != nil {
+		return 0, ReconcileConflict{}, err
+	}
+
+	path := graph.Path(p.Status, e.Status)
+	if path == nil {
+		conflict := ReconcileConflict{
+			Parcel: number, TrackingNumber: e.TrackingNumber, Ours: p.Status, Theirs: e.Status, Kind: ConflictNoPath,
+		}
+		if graph.Path(e.Status, p.Status) != nil {
+			conflict.Kind = ConflictCarrierBehind
+		}
+		return 0, conflict, nil
+	}
+
+	from := p.Status
+	for i, to := range path {
+		if err := s.transitionStatusAt(number, from, to, e.Time); err != nil {
+			return i, ReconcileConflict{}, err
+		}
+		from = to
+	}
+
+	return len(path), ReconcileConflict{}, nil
+}
+
+// carrierShipments возвращает номера посылок по номерам отслеживания перевозчика
+func (s ParcelStore) carrierShipments(carrier string) (map[string]int, error) {
+	rows, err := s.db.Query("SELECT tracking_number, parcel FROM {carrier_shipment} WHERE carrier = :carrier",
+		sql.Named("carrier", carrier))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string]int)
+	for rows.Next() {
+		var tracking string
+		var number int
+		if err := rows.Scan(&tracking, &number); err != nil {
+			return nil, err
+		}
+		res[tracking] = number
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
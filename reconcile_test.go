@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStatusGraphPath проверяет поиск цепочки переходов
+func TestStatusGraphPath(t *testing.T) {
+	require.Equal(t, []string{ParcelStatusSent, ParcelStatusDelivered},
+		defaultStatusGraph.Path(ParcelStatusRegistered, ParcelStatusDelivered))
+	require.Nil(t, defaultStatusGraph.Path(ParcelStatusDelivered, ParcelStatusSent))
+}
+
+// TestReconcile проверяет сверку с полной выгрузкой статусов перевозчика
+func TestReconcile(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	carrier := &testCarrier{}
+	ctx := context.Background()
+
+	hand := func(statuses ...string) (int, string) {
+		number, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		tracking, err := store.HandToCarrier(ctx, number, carrier)
+		require.NoError(t, err)
+		from := ParcelStatusRegistered
+		for _, to := range statuses {
+			require.NoError(t, store.TransitionStatus(number, from, to))
+			from = to
+		}
+		return number, tracking
+	}
+	behind, behindTN := hand()
+	_, matchedTN := hand(ParcelStatusSent)
+	stale, staleTN := hand(ParcelStatusSent)
+	final, finalTN := hand(ParcelStatusSent, ParcelStatusDelivered)
+	missing, missingTN := hand(ParcelStatusSent)
+	// завершённая посылка, которой нет в выгрузке, конфликтом не считается
+	hand(ParcelStatusSent, ParcelStatusDelivered)
+
+	at := time.Now().UTC().Truncate(time.Millisecond)
+
+	// reconcile
+	res, err := store.Reconcile(carrier.Name(), []ExternalStatus{
+		{TrackingNumber: behindTN, Status: ParcelStatusDelivered, Time: at},
+		{TrackingNumber: matchedTN, Status: ParcelStatusSent, Time: at},
+		{TrackingNumber: staleTN, Status: ParcelStatusRegistered, Time: at},
+		{TrackingNumber: finalTN, Status: ParcelStatusLost, Time: at},
+		{TrackingNumber: "TN-unknown", Status: ParcelStatusSent, Time: at},
+	})
+	require.NoError(t, err)
+
+	// check
+	require.Equal(t, 1, res.Matched)
+	require.Equal(t, 2, res.Applied)
+	require.Equal(t, []string{"TN-unknown"}, res.Unknown)
+	require.Equal(t, []ReconcileConflict{
+		{Parcel: stale, TrackingNumber: staleTN, Ours: ParcelStatusSent, Theirs: ParcelStatusRegistered, Kind: ConflictCarrierBehind},
+		{Parcel: final, TrackingNumber: finalTN, Ours: ParcelStatusDelivered, Theirs: ParcelStatusLost, Kind: ConflictNoPath},
+		{Parcel: missing, TrackingNumber: missingTN, Ours: ParcelStatusSent, Kind: ConflictMissing},
+	}, res.Conflicts)
+
+	p, err := store.Get(behind)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDelivered, p.Status)
+	require.True(t, at.Equal(p.DeliveredAt))
+	require.True(t, at.Equal(p.SentAt))
+}
+
+// TestCarrierSnapshot проверяет выбор последнего события по отправлению
+func TestCarrierSnapshot(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	l := CarrierLayout{Codes: map[string]string{"ACC": ParcelStatusSent, "DLV": ParcelStatusDelivered, "INFO": ""}}
+
+	snapshot := CarrierSnapshot([]CarrierEvent{
+		{Tracking: "A", Code: "DLV", Time: at.Add(time.Hour)},
+		{Tracking: "A", Code: "ACC", Time: at},
+		{Tracking: "A", Code: "INFO", Time: at.Add(2 * time.Hour)},
+		{Tracking: "B", Code: "ACC", Time: at},
+	}, l)
+
+	require.Equal(t, []ExternalStatus{
+		{TrackingNumber: "A", Status: ParcelStatusDelivered, Time: at.Add(time.Hour)},
+		{TrackingNumber: "B", Status: ParcelStatusSent, Time: at},
+	}, snapshot)
+}
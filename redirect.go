@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// RedirectUnverified — запрос ждёт кода подтверждения, отправленного получателю
+	RedirectUnverified = "unverified"
+	RedirectPending    = "pending"
+	RedirectApproved   = "approved"
+	RedirectRejected   = "rejected"
+)
+
+const (
+	// RedirectOTPTTL — срок действия кода подтверждения переадресации
+	RedirectOTPTTL = 15 * time.Minute
+	// MaxRedirectOTPAttempts — число неверных кодов до блокировки запроса
+	MaxRedirectOTPAttempts = 5
+)
+
+var (
+	ErrRedirectNotAllowed  = errors.New("переадресация возможна только для отправленной посылки")
+	ErrRedirectExists      = errors.New("по посылке уже есть запрос на переадресацию")
+	ErrRedirectResolved    = errors.New("запрос на переадресацию уже рассмотрен")
+	ErrRedirectNotVerified = errors.New("запрос на переадресацию не подтверждён получателем")
+	ErrInvalidRedirectOTP  = errors.New("неверный код подтверждения")
+	ErrRedirectOTPExpired  = errors.New("срок действия кода подтверждения истёк")
+	ErrRedirectOTPLocked   = errors.New("код подтверждения заблокирован после неверных попыток")
+)
+
+// redirectOTPText — текст SMS с кодом подтверждения по языкам
+var redirectOTPText = map[string]string{
+	LocaleRU: "Код подтверждения смены адреса посылки: %s",
+	LocaleEN: "Parcel address change confirmation code: %s",
+}
+
+// Redirect — запрос клиента на переадресацию посылки
+type Redirect struct {
+	ID          int
+	Parcel      int
+	Address     string
+	Status      string
+	RequestedAt time.Time
+	ResolvedBy  string
+	// ResolvedAt — время решения оператора, нулевое у ожидающего запроса
+	ResolvedAt time.Time
+}
+
+// RequestRedirect регистрирует запрос на смену адреса отправленной посылки.
+// Адрес меняется только после одобрения оператором, см. ApproveRedirect;
+// до отправки адрес меняется напрямую через SetAddress. Если у посылки
+// указан телефон получателя, ему через outbox уходит SMS с кодом, и до
+// VerifyRedirectOTP запрос оператору не передаётся.
+func (s ParcelStore) RequestRedirect(number int, address string) (int, error) {
+	if strings.TrimSpace(address) == "" {
+		return 0, fmt.Errorf("%w: пустой адрес", ErrInvalidParcel)
+	}
+
+	p, err := s.Get(number)
+	if err != nil {
+		return 0, err
+	}
+	if p.Status != ParcelStatusSent {
+		return 0, ErrRedirectNotAllowed
+	}
+
+	status, code, hash, expiresAt := RedirectPending, "", "", time.Time{}
+	now := s.now()
+	if p.RecipientPhone != "" {
+		// код того же вида, что и код получения в пункте выдачи
+		if code, err = newPickupCode(); err != nil {
+			return 0, err
+		}
+		status, hash, expiresAt = RedirectUnverified, hashPickupCode(code), now.Add(RedirectOTPTTL)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// неподтверждённый запрос с истёкшим или заблокированным кодом
+	// оператор не увидит, он не должен мешать новому
+	_, err = tx.Exec("DELETE FROM {address_redirect} WHERE parcel = :parcel AND status = :unverified "+
+		"AND (otp_expires_at < :now OR otp_attempts >= :max_attempts)",
+		sql.Named("parcel", number),
+		sql.Named("unverified", RedirectUnverified),
+		sql.Named("now", formatTime(now)),
+		sql.Named("max_attempts", MaxRedirectOTPAttempts))
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec("INSERT INTO {address_redirect} (parcel, address, status, requested_at, otp_hash, otp_expires_at) "+
+		"VALUES (:parcel, :address, :status, :requested_at, :otp_hash, :otp_expires_at)",
+		sql.Named("parcel", number),
+		sql.Named("address", address),
+		sql.Named("status", status),
+		sql.Named("requested_at", formatTime(now)),
+		sql.Named("otp_hash", hash),
+		sql.Named("otp_expires_at", formatTime(expiresAt)))
+	if isUniqueViolation(err) {
+		// открытый запрос может быть только один, см. address_redirect_open_uq
+		return 0, ErrRedirectExists
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if code != "" {
+		text, ok := redirectOTPText[p.Locale]
+		if !ok {
+			text = redirectOTPText[DefaultLocale]
+		}
+		n := Notification{Channel: ChannelSMS, To: p.RecipientPhone, Body: fmt.Sprintf(text, code)}
+		if err := s.enqueueOutbox(tx, TopicNotification, n); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// VerifyRedirectOTP проверяет код подтверждения, отправленный получателю,
+// и при совпадении передаёт запрос на рассмотрение оператору. Неверный
+// код увеличивает счётчик попыток, после MaxRedirectOTPAttempts запрос
+// подтвердить нельзя, нужен новый.
+func (s ParcelStore) VerifyRedirectOTP(id int, code string) error {
+	var status, hash string
+	var expiresAt time.Time
+	var attempts int
+	err := s.db.QueryRow("SELECT status, otp_hash, otp_expires_at, otp_attempts FROM {address_redirect} WHERE id = :id",
+		sql.Named("id", id)).Scan(&status, &hash, scanTime(&expiresAt), &attempts)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status != RedirectUnverified:
+		return ErrRedirectResolved
+	case attempts >= MaxRedirectOTPAttempts:
+		return ErrRedirectOTPLocked
+	case s.now().After(expiresAt):
+		return ErrRedirectOTPExpired
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(hashPickupCode(code))) != 1 {
+		_, err := s.db.Exec("UPDATE {address_redirect} SET otp_attempts = otp_attempts + 1 WHERE id = :id",
+			sql.Named("id", id))
+		if err != nil {
+			return err
+		}
+		return ErrInvalidRedirectOTP
+	}
+
+	res, err := s.db.Exec("UPDATE {address_redirect} SET status = :pending, otp_hash = '' WHERE id = :id AND status = :unverified",
+		sql.Named("pending", RedirectPending),
+		sql.Named("id", id),
+		sql.Named("unverified", RedirectUnverified))
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		// код подтвердили параллельно
+		return ErrRedirectResolved
+	}
+
+	return nil
+}
+
+// ApproveRedirect одобряет запрос и в той же транзакции меняет адрес
+// посылки. Если посылка уже не в пути, запрос остаётся ожидающим
+// и возвращается ErrRedirectNotAllowed.
+func (s ParcelStore) ApproveRedirect(id int, operator string) error {
+	if operator == "" {
+		return ErrEmptyOperator
+	}
+
+	// номер посылки нужен журналу изменений до транзакции
+	var number int
+	err := s.db.QueryRow("SELECT parcel FROM {address_redirect} WHERE id = :id", sql.Named("id", id)).Scan(&number)
+	if err != nil {
+		return err
+	}
+
+	return s.audited(context.Background(), number, AuditSetAddress, func() error {
+		return s.approveRedirect(id, operator)
+	})
+}
+
+// approveRedirect в одной транзакции одобряет запрос и меняет адрес посылки
+func (s ParcelStore) approveRedirect(id int, operator string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var number int
+	var address, status string
+	err = tx.QueryRow("SELECT parcel, address, status FROM {address_redirect} WHERE id = :id",
+		sql.Named("id", id)).Scan(&number, &address, &status)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case RedirectPending:
+	case RedirectUnverified:
+		return ErrRedirectNotVerified
+	default:
+		return ErrRedirectResolved
+	}
+
+	res, err := tx.Exec("UPDATE {parcel} SET address = :address WHERE number = :number AND status = :sent",
+		sql.Named("address", address),
+		sql.Named("number", number),
+		sql.Named("sent", ParcelStatusSent))
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrRedirectNotAllowed
+	}
+
+	if err := s.resolveRedirect(tx, id, RedirectApproved, operator); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RejectRedirect отклоняет запрос на переадресацию, адрес посылки не меняется
+func (s ParcelStore) RejectRedirect(id int, operator string) error {
+	if operator == "" {
+		return ErrEmptyOperator
+	}
+
+	return s.resolveRedirect(s.db, id, RedirectRejected, operator)
+}
+
+// resolveRedirect записывает решение по ожидающему запросу
+func (s ParcelStore) resolveRedirect(db execer, id int, status string, operator string) error {
+	res, err := db.Exec("UPDATE {address_redirect} SET status = :status, resolved_by = :operator, resolved_at = :now "+
+		"WHERE id = :id AND status = :pending",
+		sql.Named("status", status),
+		sql.Named("operator", operator),
+		sql.Named("now", formatTime(s.now())),
+		sql.Named("id", id),
+		sql.Named("pending", RedirectPending))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		// запрос рассмотрели параллельно или его нет
+		return ErrRedirectResolved
+	}
+
+	return nil
+}
+
+// GetRedirects возвращает запросы на переадресацию посылки в порядке подачи
+func (s ParcelStore) GetRedirects(number int) ([]Redirect, error) {
+	rows, err := s.db.Query("SELECT id, parcel, address, status, requested_at, resolved_by, resolved_at "+
+		"FROM {address_redirect} WHERE parcel = :parcel ORDER BY id",
+		sql.Named("parcel", number))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Redirect
+	for rows.Next() {
+		r := Redirect{}
+		err := rows.Scan(&r.ID, &r.Parcel, &r.Address, &r.Status, scanTime(&r.RequestedAt), &r.ResolvedBy, scanTime(&r.ResolvedAt))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// NewRedirectRequestHandler возвращает публичный обработчик POST
+// /track/{code}/redirect: JSON {"token", "address"}, где token — токен
+// получателя, см. RecipientToken. Отвечает {"id", "status"}; запрос в
+// статусе unverified подтверждается кодом из SMS через
+// /track/{code}/redirect/verify.
+func NewRedirectRequestHandler(store ParcelStore, errLog *ErrorLog) http.Handler {
+	h := AdminHandler{store: store, errors: errLog}
+
+	return recipientAction(store, errLog, "redirect", func(w http.ResponseWriter, r *http.Request, p Parcel, body []byte) {
+		var req struct {
+			Address string `json:"address"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "некорректный JSON", http.StatusBadRequest)
+			return
+		}
+
+		id, err := store.RequestRedirect(p.Number, req.Address)
+		switch {
+		case errors.Is(err, ErrInvalidParcel):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrRedirectNotAllowed), errors.Is(err, ErrRedirectExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			status := RedirectPending
+			if p.RecipientPhone != "" {
+				status = RedirectUnverified
+			}
+			w.WriteHeader(http.StatusCreated)
+			writeJSON(w, map[string]any{"id": id, "status": status})
+		}
+	})
+}
+
+// NewRedirectVerifyHandler возвращает публичный обработчик POST
+// /track/{code}/redirect/verify: JSON {"token", "id", "code"}, где code —
+// код подтверждения из SMS, см. VerifyRedirectOTP
+func NewRedirectVerifyHandler(store ParcelStore, errLog *ErrorLog) http.Handler {
+	h := AdminHandler{store: store, errors: errLog}
+
+	return recipientAction(store, errLog, "redirect/verify", func(w http.ResponseWriter, r *http.Request, p Parcel, body []byte) {
+		var req struct {
+			ID   int    `json:"id"`
+			Code string `json:"code"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "некорректный JSON", http.StatusBadRequest)
+			return
+		}
+
+		// токен даёт доступ только к запросам своей посылки
+		redirects, err := store.GetRedirects(p.Number)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		found := false
+		for _, rd := range redirects {
+			found = found || rd.ID == req.ID
+		}
+		if !found {
+			http.Error(w, "запрос на переадресацию не найден", http.StatusNotFound)
+			return
+		}
+
+		err = store.VerifyRedirectOTP(req.ID, req.Code)
+		switch {
+		case errors.Is(err, ErrInvalidRedirectOTP):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrRedirectOTPExpired), errors.Is(err, ErrRedirectOTPLocked), errors.Is(err, ErrRedirectResolved):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
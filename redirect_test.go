@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestRedirectWorkflow проверяет подачу, одобрение и отклонение переадресации
+func TestRedirectWorkflow(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// до отправки адрес меняется напрямую
+	_, err = store.RequestRedirect(number, "Тверь, ул. Советская, д. 3")
+	require.ErrorIs(t, err, ErrRedirectNotAllowed)
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+
+	// reject
+	id, err := store.RequestRedirect(number, "Тверь, ул. Советская, д. 3")
+	require.NoError(t, err)
+	_, err = store.RequestRedirect(number, "Тверь, ул. Советская, д. 4")
+	require.ErrorIs(t, err, ErrRedirectExists)
+	require.ErrorIs(t, store.RejectRedirect(id, ""), ErrEmptyOperator)
+	require.NoError(t, store.RejectRedirect(id, "ivanov"))
+	require.ErrorIs(t, store.ApproveRedirect(id, "ivanov"), ErrRedirectResolved)
+
+	// approve
+	id, err = store.RequestRedirect(number, "Тверь, ул. Советская, д. 4")
+	require.NoError(t, err)
+	require.NoError(t, store.ApproveRedirect(id, "petrov"))
+
+	// check
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, "Тверь, ул. Советская, д. 4", stored.Address)
+
+	redirects, err := store.GetRedirects(number)
+	require.NoError(t, err)
+	require.Len(t, redirects, 2)
+	require.Equal(t, RedirectRejected, redirects[0].Status)
+	require.Equal(t, RedirectApproved, redirects[1].Status)
+	require.Equal(t, "petrov", redirects[1].ResolvedBy)
+	require.False(t, redirects[1].ResolvedAt.IsZero())
+}
+
+// TestApproveRedirectDelivered проверяет, что доставленную посылку не переадресовать
+func TestApproveRedirectDelivered(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+	id, err := store.RequestRedirect(number, "Тверь, ул. Советская, д. 3")
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(number, ParcelStatusDelivered))
+
+	// check
+	require.ErrorIs(t, store.ApproveRedirect(id, "ivanov"), ErrRedirectNotAllowed)
+
+	redirects, err := store.GetRedirects(number)
+	require.NoError(t, err)
+	require.Equal(t, RedirectPending, redirects[0].Status)
+
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, "test", stored.Address)
+}
+
+// TestRedirectOTP проверяет подтверждение переадресации кодом из SMS
+func TestRedirectOTP(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	parcel.RecipientPhone = "+79001234567"
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+
+	// request
+	id, err := store.RequestRedirect(number, "Тверь, ул. Советская, д. 3")
+	require.NoError(t, err)
+	require.ErrorIs(t, store.ApproveRedirect(id, "ivanov"), ErrRedirectNotVerified)
+
+	messages, err := store.PendingOutbox(10)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	var n Notification
+	require.NoError(t, json.Unmarshal(messages[0].Payload, &n))
+	require.Equal(t, ChannelSMS, n.Channel)
+	require.Equal(t, parcel.RecipientPhone, n.To)
+	code := n.Body[len(n.Body)-6:]
+
+	// verify
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	require.ErrorIs(t, store.VerifyRedirectOTP(id, wrong), ErrInvalidRedirectOTP)
+	require.NoError(t, store.VerifyRedirectOTP(id, code))
+	require.ErrorIs(t, store.VerifyRedirectOTP(id, code), ErrRedirectResolved)
+	require.NoError(t, store.ApproveRedirect(id, "ivanov"))
+
+	stored, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, "Тверь, ул. Советская, д. 3", stored.Address)
+}
+
+// TestRedirectOTPLocked проверяет блокировку кода после неверных попыток
+func TestRedirectOTPLocked(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	parcel.RecipientPhone = "+79001234567"
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+	id, err := store.RequestRedirect(number, "Тверь, ул. Советская, д. 3")
+	require.NoError(t, err)
+
+	// неверный код из семи цифр не совпадает ни с одним настоящим
+	for i := 0; i < MaxRedirectOTPAttempts; i++ {
+		require.ErrorIs(t, store.VerifyRedirectOTP(id, "1234567"), ErrInvalidRedirectOTP)
+	}
+	require.ErrorIs(t, store.VerifyRedirectOTP(id, "1234567"), ErrRedirectOTPLocked)
+
+	// заблокированный запрос не мешает новому
+	_, err = store.RequestRedirect(number, "Тверь, ул. Советская, д. 4")
+	require.NoError(t, err)
+	redirects, err := store.GetRedirects(number)
+	require.NoError(t, err)
+	require.Len(t, redirects, 1)
+	require.Equal(t, RedirectUnverified, redirects[0].Status)
+}
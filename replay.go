@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// replayBatch — сколько изменений передаётся получателю повтора за раз
+const replayBatch = 100
+
+var (
+	ErrInvalidReplayFilter = errors.New("для повтора нужна посылка или период")
+	ErrNoPublisher         = errors.New("брокер сообщений не настроен, см. WithPublisher")
+)
+
+// ReplayFilter — какие изменения посылок повторить: изменения посылки
+// Parcel и/или записанные в [From, To). Нулевая граница не ограничивает.
+type ReplayFilter struct {
+	Parcel int
+	From   time.Time
+	To     time.Time
+}
+
+// ReplayEvent — событие журнала изменений посылок для повтора. Журнал не
+// хранит прежнее состояние посылки, поэтому Parcel — текущее состояние.
+type ReplayEvent struct {
+	Seq       int           `json:"seq"`
+	Type      string        `json:"type"`
+	Tenant    string        `json:"tenant,omitempty"`
+	Parcel    WebhookParcel `json:"parcel"`
+	ChangedAt time.Time     `json:"changed_at"`
+}
+
+// ReplaySink — получатель повторённых событий. Replay возвращает, сколько
+// событий из events он принял.
+type ReplaySink interface {
+	Replay(ctx context.Context, events []ReplayEvent) (int, error)
+}
+
+// ReplayEvents повторяет в sink события журнала изменений посылок {parcel_change},
+// подходящие под f, в порядке записи и возвращает число принятых событий.
+// Нужен, когда получатель событий потерял данные. Удалённые посылки не
+// повторяются, как и в уведомлениях партнёрам.
+func (s ParcelStore) ReplayEvents(ctx context.Context, f ReplayFilter, sink ReplaySink) (int, error) {
+	if f.Parcel <= 0 && f.From.IsZero() && f.To.IsZero() {
+		return 0, ErrInvalidReplayFilter
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return 0, fmt.Errorf("%w: начало периода не раньше конца", ErrInvalidReplayFilter)
+	}
+
+	conditions := []string{"c.seq > :after", "c.kind <> 'delete'"}
+	var args []any
+	if f.Parcel > 0 {
+		conditions = append(conditions, "c.parcel = :parcel")
+		args = append(args, sql.Named("parcel", f.Parcel))
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "c.changed_at >= :from")
+		args = append(args, sql.Named("from", formatTime(f.From)))
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "c.changed_at < :to")
+		args = append(args, sql.Named("to", formatTime(f.To)))
+	}
+	query := "SELECT c.seq, c.kind, c.courier, c.changed_at, p.tenant, p.number, p.uuid, p.status, " +
+		"COALESCE(p.order_id, 0), p.updated_at FROM {parcel_change} c JOIN {parcel} p ON p.number = c.parcel " +
+		"WHERE " + strings.Join(conditions, " AND ") + " ORDER BY c.seq LIMIT :limit"
+
+	total, after := 0, 0
+	for {
+		rows, err := s.db.QueryContext(ctx, query,
+			append(args, sql.Named("after", after), sql.Named("limit", replayBatch))...)
+		if err != nil {
+			return total, err
+		}
+		var events []ReplayEvent
+		for rows.Next() {
+			var kind string
+			var courier int
+			e := ReplayEvent{}
+			err := rows.Scan(&e.Seq, &kind, &courier, scanTime(&e.ChangedAt), &e.Tenant, &e.Parcel.Number,
+				&e.Parcel.Code, &e.Parcel.Status, &e.Parcel.OrderID, scanTime(&e.Parcel.UpdatedAt))
+			if err != nil {
+				rows.Close()
+				return total, err
+			}
+			e.Type = webhookEventType(kind, courier)
+			events = append(events, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+		if len(events) == 0 {
+			return total, nil
+		}
+
+		n, err := sink.Replay(ctx, events)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		after = events[len(events)-1].Seq
+	}
+}
+
+// WebhookReplaySink ставит повторённые события в очередь адреса уведомлений
+// Endpoint. Адрес принимает только события посылок своего партнёра; они
+// уходят с новыми идентификаторами доставки, см. WebhookDispatcher.
+type WebhookReplaySink struct {
+	Store    ParcelStore
+	Endpoint int
+}
+
+func (w WebhookReplaySink) Replay(ctx context.Context, events []ReplayEvent) (int, error) {
+	ep, err := w.Store.getWebhookEndpoint(w.Endpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := w.Store.db.BeginTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n := 0
+	for _, e := range events {
+		if e.Tenant != ep.Partner {
+			continue
+		}
+		if err := w.Store.insertWebhookEvent(tx, ep.ID, e.Type, e.Parcel); err != nil {
+			return 0, err
+		}
+		n++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Publisher — отправитель сообщений в брокер, например продюсер Kafka.
+// Клиента брокера в поставке нет: его передаёт приложение, встроившее
+// хранилище.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, key string, value []byte) error
+}
+
+// WithPublisher задаёт брокер, в темы которого повторяются события через
+// административный API, см. TopicReplaySink
+func WithPublisher(p Publisher) StoreOption {
+	return func(s *ParcelStore) {
+		s.publisher = p
+	}
+}
+
+// TopicReplaySink публикует повторённые события в тему Topic брокера.
+// Ключ сообщения — код отслеживания, чтобы события одной посылки попадали
+// в один раздел и сохраняли порядок.
+type TopicReplaySink struct {
+	Publisher Publisher
+	Topic     string
+}
+
+func (t TopicReplaySink) Replay(ctx context.Context, events []ReplayEvent) (int, error) {
+	for i, e := range events {
+		value, err := json.Marshal(e)
+		if err != nil {
+			return i, err
+		}
+		if err := t.Publisher.Publish(ctx, t.Topic, e.Parcel.Code, value); err != nil {
+			return i, err
+		}
+	}
+
+	return len(events), nil
+}
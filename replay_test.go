@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testPublisher запоминает опубликованные сообщения
+type testPublisher struct {
+	topics []string
+	keys   []string
+	values [][]byte
+}
+
+func (p *testPublisher) Publish(ctx context.Context, topic string, key string, value []byte) error {
+	p.topics = append(p.topics, topic)
+	p.keys = append(p.keys, key)
+	p.values = append(p.values, value)
+	return nil
+}
+
+// TestReplayToWebhook проверяет повтор событий посылки в адрес уведомлений
+func TestReplayToWebhook(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	parcel.Tenant = "acme"
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+	// события посылки другого партнёра адрес не принимает
+	other, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	rcv, endpoint := addTestWebhook(t, store)
+	sink := WebhookReplaySink{Store: store, Endpoint: endpoint.ID}
+
+	// check
+	_, err = store.ReplayEvents(context.Background(), ReplayFilter{}, sink)
+	require.ErrorIs(t, err, ErrInvalidReplayFilter)
+
+	n, err := store.ReplayEvents(context.Background(), ReplayFilter{Parcel: other}, sink)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// replay
+	n, err = store.ReplayEvents(context.Background(), ReplayFilter{Parcel: number}, sink)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	sent, err := store.DeliverWebhooks(context.Background(), http.DefaultClient, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, sent)
+	require.Equal(t, WebhookParcelCreated, rcv.payloads[0].Type)
+	require.Equal(t, WebhookParcelUpdated, rcv.payloads[1].Type)
+
+	// период после всех изменений пуст
+	n, err = store.ReplayEvents(context.Background(), ReplayFilter{From: time.Now().Add(time.Hour)}, sink)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+// TestReplayToTopic проверяет повтор событий в тему брокера
+func TestReplayToTopic(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+	pub := &testPublisher{}
+
+	// replay
+	n, err := store.ReplayEvents(context.Background(), ReplayFilter{From: time.Now().Add(-time.Hour)},
+		TopicReplaySink{Publisher: pub, Topic: "parcels"})
+
+	// check
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, []string{"parcels"}, pub.topics)
+	require.Equal(t, []string{parcel.UUID}, pub.keys)
+	var e ReplayEvent
+	require.NoError(t, json.Unmarshal(pub.values[0], &e))
+	require.Equal(t, number, e.Parcel.Number)
+	require.Equal(t, WebhookParcelCreated, e.Type)
+}
+
+// TestReplayHandler проверяет повтор событий через административный API
+func TestReplayHandler(t *testing.T) {
+	// prepare
+	pub := &testPublisher{}
+	store := NewParcelStore(openTestDB(t), WithPublisher(pub))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	handler := NewAdminHandler(store, NewErrorLog(10))
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/replay", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	parcel := strconv.Itoa(number)
+
+	// check
+	require.Equal(t, http.StatusBadRequest, post(url.Values{"parcel": {parcel}}).Code)
+	require.Equal(t, http.StatusBadRequest, post(url.Values{"topic": {"parcels"}}).Code)
+	require.Equal(t, http.StatusNotFound, post(url.Values{"parcel": {parcel}, "endpoint": {"42"}}).Code)
+
+	rec := post(url.Values{"parcel": {parcel}, "topic": {"parcels"}})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"replayed":1}`, rec.Body.String())
+	require.Len(t, pub.values, 1)
+}
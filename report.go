@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	ReportWeekly  = "week"
+	ReportMonthly = "month"
+)
+
+var ErrUnknownReportPeriod = errors.New("неизвестный период отчёта")
+
+// SLAReport — отчёт о сроках доставки за период по посылкам,
+// зарегистрированным в [From, To)
+type SLAReport struct {
+	Period string
+	From   time.Time
+	To     time.Time
+	// Zones — строки по зонам доставки, посылки без зоны в строке с пустым именем
+	Zones []ZoneSLA
+	Total ZoneSLA
+}
+
+// ZoneSLA — объёмы, нарушения норматива и перцентили времени доставки
+// от регистрации до вручения; перцентили считаются по доставленным посылкам
+type ZoneSLA struct {
+	Zone      string
+	Parcels   int
+	Delivered int
+	Breached  int
+	P50       time.Duration
+	P90       time.Duration
+	P95       time.Duration
+}
+
+// BreachRate возвращает долю посылок с нарушенным нормативом
+func (z ZoneSLA) BreachRate() float64 {
+	if z.Parcels == 0 {
+		return 0
+	}
+
+	return float64(z.Breached) / float64(z.Parcels)
+}
+
+// ReportPeriod возвращает последний завершённый до now период отчёта в UTC:
+// неделю с понедельника или календарный месяц
+func ReportPeriod(period string, now time.Time) (time.Time, time.Time, error) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch period {
+	case ReportWeekly:
+		// Weekday у воскресенья 0, неделя начинается с понедельника
+		to := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		return to.AddDate(0, 0, -7), to, nil
+	case ReportMonthly:
+		to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return to.AddDate(0, -1, 0), to, nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: %q", ErrUnknownReportPeriod, period)
+	}
+}
+
+// SLAReport строит отчёт о сроках доставки за последний завершённый до now
+// период. Недоставленная посылка считается нарушением, если на конец
+// периода её возраст превышает норматив уровня обслуживания.
+func (s ParcelStore) SLAReport(period string, now time.Time) (SLAReport, error) {
+	from, to, err := ReportPeriod(period, now)
+	if err != nil {
+		return SLAReport{}, err
+	}
+
+	rows, err := s.db.Query(parcelSelect+"WHERE created_at >= :from AND created_at < :to ORDER BY zone, number",
+		sql.Named("from", formatTime(from)),
+		sql.Named("to", formatTime(to)))
+	if err != nil {
+		return SLAReport{}, err
+	}
+	parcels, err := scanParcels(rows)
+	if err != nil {
+		return SLAReport{}, err
+	}
+
+	report := SLAReport{Period: period, From: from, To: to}
+	var all []time.Duration
+	for i := 0; i < len(parcels); {
+		zone := ZoneSLA{Zone: parcels[i].Zone}
+		var durations []time.Duration
+		for ; i < len(parcels) && parcels[i].Zone == zone.Zone; i++ {
+			p := parcels[i]
+			zone.Parcels++
+
+			sla, err := SLA(p.ServiceLevel)
+			if err != nil {
+				return SLAReport{}, err
+			}
+			if p.Status == ParcelStatusDelivered && !p.DeliveredAt.IsZero() {
+				took := p.DeliveredAt.Sub(p.CreatedAt)
+				durations = append(durations, took)
+				zone.Delivered++
+				if took > sla {
+					zone.Breached++
+				}
+			} else if p.Status != ParcelStatusDelivered && to.Sub(p.CreatedAt) > sla {
+				zone.Breached++
+			}
+		}
+
+		zone.P50, zone.P90, zone.P95 = deliveryPercentiles(durations)
+		report.Zones = append(report.Zones, zone)
+		report.Total.Parcels += zone.Parcels
+		report.Total.Delivered += zone.Delivered
+		report.Total.Breached += zone.Breached
+		all = append(all, durations...)
+	}
+	report.Total.P50, report.Total.P90, report.Total.P95 = deliveryPercentiles(all)
+
+	return report, nil
+}
+
+// deliveryPercentiles возвращает 50-й, 90-й и 95-й перцентили методом
+// ближайшего ранга, для пустой выборки нули. Сортирует durations.
+func deliveryPercentiles(durations []time.Duration) (time.Duration, time.Duration, time.Duration) {
+	if len(durations) == 0 {
+		return 0, 0, 0
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	rank := func(q int) time.Duration {
+		// ceil(q/100 * n) - 1 в целых числах
+		return durations[(q*len(durations)+99)/100-1]
+	}
+
+	return rank(50), rank(90), rank(95)
+}
+
+// WriteCSV записывает отчёт в CSV: строка на зону и итоговая строка total,
+// время доставки в часах
+func (r SLAReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"from", "to", "zone", "parcels", "delivered", "breached", "breach_rate",
+		"p50_hours", "p90_hours", "p95_hours"}); err != nil {
+		return err
+	}
+
+	from, to := r.From.Format(dateLayout), r.To.Format(dateLayout)
+	write := func(name string, z ZoneSLA) error {
+		return cw.Write([]string{from, to, name,
+			strconv.Itoa(z.Parcels), strconv.Itoa(z.Delivered), strconv.Itoa(z.Breached),
+			strconv.FormatFloat(z.BreachRate(), 'f', 4, 64),
+			strconv.FormatFloat(z.P50.Hours(), 'f', 1, 64),
+			strconv.FormatFloat(z.P90.Hours(), 'f', 1, 64),
+			strconv.FormatFloat(z.P95.Hours(), 'f', 1, 64)})
+	}
+	for _, z := range r.Zones {
+		if err := write(z.Zone, z); err != nil {
+			return err
+		}
+	}
+	if err := write("total", r.Total); err != nil {
+		return err
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestReportPeriod проверяет границы последних завершённых периодов
+func TestReportPeriod(t *testing.T) {
+	// 2026-10-14 — среда
+	now := time.Date(2026, 10, 14, 15, 0, 0, 0, time.UTC)
+
+	from, to, err := ReportPeriod(ReportWeekly, now)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC), from)
+	require.Equal(t, time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC), to)
+
+	// в воскресенье неделя ещё не завершена
+	from, _, err = ReportPeriod(ReportWeekly, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC), from)
+
+	from, to, err = ReportPeriod(ReportMonthly, now)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC), from)
+	require.Equal(t, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), to)
+
+	_, _, err = ReportPeriod("year", now)
+	require.ErrorIs(t, err, ErrUnknownReportPeriod)
+}
+
+// addTestReportParcels добавляет посылки прошлой недели относительно 2026-10-14:
+// три доставленные в зоне moscow, одна без зоны в пути и одна вне периода
+func addTestReportParcels(t *testing.T, store ParcelStore) {
+	t.Helper()
+
+	created := time.Date(2026, 10, 6, 0, 0, 0, 0, time.UTC)
+	for _, hours := range []int{24, 48, 144} {
+		parcel := getTestParcel()
+		parcel.CreatedAt = created
+		parcel.Zone = "moscow"
+		number, err := store.Add(parcel)
+		require.NoError(t, err)
+		_, err = store.db.Exec("UPDATE {parcel} SET status = :status, delivered_at = :delivered_at WHERE number = :number",
+			sql.Named("status", ParcelStatusDelivered),
+			sql.Named("delivered_at", formatTime(created.Add(time.Duration(hours)*time.Hour))),
+			sql.Named("number", number))
+		require.NoError(t, err)
+	}
+
+	for _, day := range []int{6, 13} {
+		parcel := getTestParcel()
+		parcel.CreatedAt = time.Date(2026, 10, day, 0, 0, 0, 0, time.UTC)
+		_, err := store.Add(parcel)
+		require.NoError(t, err)
+	}
+}
+
+// TestSLAReport проверяет перцентили, нарушения и объёмы по зонам
+func TestSLAReport(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	addTestReportParcels(t, store)
+
+	// report
+	report, err := store.SLAReport(ReportWeekly, time.Date(2026, 10, 14, 15, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	// check
+	require.Equal(t, []ZoneSLA{
+		{Zone: "", Parcels: 1, Breached: 1},
+		{Zone: "moscow", Parcels: 3, Delivered: 3, Breached: 1, P50: 48 * time.Hour, P90: 144 * time.Hour, P95: 144 * time.Hour},
+	}, report.Zones)
+	require.Equal(t, 4, report.Total.Parcels)
+	require.Equal(t, 0.5, report.Total.BreachRate())
+
+	var b strings.Builder
+	require.NoError(t, report.WriteCSV(&b))
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	require.Len(t, lines, 4)
+	require.Equal(t, "2026-10-05,2026-10-12,moscow,3,3,1,0.3333,48.0,144.0,144.0", lines[2])
+	require.Equal(t, "2026-10-05,2026-10-12,total,4,3,2,0.5000,48.0,144.0,144.0", lines[3])
+}
+
+// TestAdminSLAReport проверяет выгрузку отчёта через эндпоинт
+func TestAdminSLAReport(t *testing.T) {
+	handler := NewAdminHandler(NewParcelStore(openTestDB(t)), NewErrorLog(10))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/reports/sla?period=month", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	require.True(t, strings.HasPrefix(rec.Body.String(), "from,to,zone,"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/reports/sla?period=year", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader — заголовок с идентификатором запроса. Входящий
+// идентификатор, например от балансировщика, сохраняется, иначе
+// назначается новый; в ответе заголовок есть всегда.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDRe — допустимый входящий идентификатор. Он попадает в журналы,
+// поэтому длина и символы ограничены.
+var requestIDRe = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// requestIDKey — ключ идентификатора запроса в контексте
+type requestIDKey struct{}
+
+// WithRequestID возвращает контекст с идентификатором запроса id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext возвращает идентификатор запроса из контекста,
+// пустой — запроса нет, например в фоновой задаче
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDMiddleware назначает запросу идентификатор, передаёт его
+// обработчикам через контекст и возвращает клиенту в RequestIDHeader
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDRe.MatchString(id) {
+			id = uuid.NewString()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestRequestIDMiddleware проверяет назначение идентификатора запроса
+// и его попадание в журнал ошибок и журнал изменений
+func TestRequestIDMiddleware(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	errLog := NewErrorLog(10)
+	var number int
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		audited := NewAuditedStorage(store, store, "ivanov").ForRequest(r.Context())
+		var err error
+		if number, err = audited.Add(getTestParcel()); err != nil {
+			t.Error(err)
+		}
+		AdminHandler{store: store, errors: errLog}.fail(w, r, errors.New("сбой"))
+	}))
+
+	// входящий идентификатор сохраняется
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "lb-42")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// check
+	require.Equal(t, "lb-42", rec.Header().Get(RequestIDHeader))
+	require.Equal(t, "lb-42", errLog.Recent()[0].RequestID)
+	entries, err := store.ListAudit(AuditFilter{RequestID: "lb-42"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, number, entries[0].Parcel)
+
+	// недопустимый входящий идентификатор заменяется новым
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	id := rec.Header().Get(RequestIDHeader)
+	require.NotEqual(t, "bad id\n", id)
+	require.Regexp(t, requestIDRe, id)
+	require.Equal(t, id, errLog.Recent()[0].RequestID)
+
+	// вне запроса идентификатора нет
+	require.Empty(t, RequestIDFromContext(context.Background()))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// MaxReserveNumbers — сколько номеров можно зарезервировать за один раз
+const MaxReserveNumbers = 10000
+
+var (
+	ErrInvalidReservation     = errors.New("некорректное резервирование номеров")
+	ErrReservationUnsupported = errors.New("резервирование номеров требует автоинкремента номеров")
+	ErrNotReserved            = errors.New("номер не зарезервирован")
+	ErrReservationClaimed     = errors.New("зарезервированный номер уже занят посылкой")
+)
+
+// ReserveNumbers резервирует n идущих подряд номеров посылок, например
+// для печати этикеток на складе без связи с трекером. Номера берутся из
+// последовательности автоинкремента таблицы посылок, которая сдвигается
+// на n в той же транзакции, поэтому ни параллельное резервирование, ни
+// обычное добавление их не получат. Посылка занимает номер через
+// ClaimReserved. С генераторами номеров, кроме AutoIncrement, не работает.
+func (s ParcelStore) ReserveNumbers(n int) ([]int, error) {
+	if n <= 0 || n > MaxReserveNumbers {
+		return nil, fmt.Errorf("%w: число номеров должно быть от 1 до %d", ErrInvalidReservation, MaxReserveNumbers)
+	}
+	if _, ok := s.ids.(AutoIncrement); !ok {
+		return nil, ErrReservationUnsupported
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// строка последовательности появляется при первой вставке в таблицу
+	table := sql.Named("table", s.naming.prefix+"parcel")
+	_, err = tx.Exec(`INSERT INTO {schema}sqlite_sequence (name, seq)
+SELECT :table, 0 WHERE NOT EXISTS (SELECT 1 FROM {schema}sqlite_sequence WHERE name = :table)`, table)
+	if err != nil {
+		return nil, err
+	}
+
+	// номера, вставленные явно, могли обогнать последовательность
+	var last int
+	err = tx.QueryRow(`UPDATE {schema}sqlite_sequence
+SET seq = MAX(seq, (SELECT COALESCE(MAX(number), 0) FROM {parcel})) + :n
+WHERE name = :table RETURNING seq`, table, sql.Named("n", n)).Scan(&last)
+	if err != nil {
+		return nil, err
+	}
+
+	first := last - n + 1
+	_, err = tx.Exec(`WITH RECURSIVE seq(number) AS (SELECT :first UNION ALL SELECT number + 1 FROM seq WHERE number < :last)
+INSERT INTO {number_reservation} (number, reserved_at) SELECT number, :now FROM seq`,
+		sql.Named("first", first),
+		sql.Named("last", last),
+		sql.Named("now", formatTime(s.now())))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	numbers := make([]int, n)
+	for i := range numbers {
+		numbers[i] = first + i
+	}
+
+	return numbers, nil
+}
+
+// ClaimReserved добавляет посылку p под зарезервированным номером number.
+// Номер занимается один раз: повторная попытка, в том числе параллельная,
+// возвращает ErrReservationClaimed, незарезервированный номер —
+// ErrNotReserved.
+func (s ParcelStore) ClaimReserved(number int, p Parcel) error {
+	if number <= 0 {
+		return ErrNotReserved
+	}
+
+	_, err := s.add(context.Background(), p, number)
+
+	return err
+}
+
+// claimReservation отмечает зарезервированный номер занятым в транзакции добавления
+func (s ParcelStore) claimReservation(tx storeTx, number int) error {
+	res, err := tx.Exec("UPDATE {number_reservation} SET claimed_at = :now WHERE number = :number AND claimed_at = ''",
+		sql.Named("now", formatTime(s.now())),
+		sql.Named("number", number))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	var claimedAt string
+	err = tx.QueryRow("SELECT claimed_at FROM {number_reservation} WHERE number = :number",
+		sql.Named("number", number)).Scan(&claimedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotReserved
+	}
+	if err != nil {
+		return err
+	}
+
+	return ErrReservationClaimed
+}
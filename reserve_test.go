@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestReserveNumbers проверяет резервирование номеров и занятие их посылками
+func TestReserveNumbers(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	before, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// check
+	numbers, err := store.ReserveNumbers(3)
+	require.NoError(t, err)
+	require.Equal(t, []int{before + 1, before + 2, before + 3}, numbers)
+
+	// обычное добавление идёт после блока
+	after, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.Equal(t, before+4, after)
+
+	require.NoError(t, store.ClaimReserved(numbers[1], getTestParcel()))
+	p, err := store.Get(numbers[1])
+	require.NoError(t, err)
+	require.Equal(t, numbers[1], p.Number)
+
+	require.ErrorIs(t, store.ClaimReserved(numbers[1], getTestParcel()), ErrReservationClaimed)
+	require.ErrorIs(t, store.ClaimReserved(after, getTestParcel()), ErrNotReserved)
+	require.ErrorIs(t, store.ClaimReserved(after+100, getTestParcel()), ErrNotReserved)
+
+	_, err = store.ReserveNumbers(0)
+	require.ErrorIs(t, err, ErrInvalidReservation)
+	_, err = store.ReserveNumbers(MaxReserveNumbers + 1)
+	require.ErrorIs(t, err, ErrInvalidReservation)
+
+	g, err := NewSnowflake(1)
+	require.NoError(t, err)
+	_, err = NewParcelStore(openTestDB(t), WithIDGenerator(g)).ReserveNumbers(1)
+	require.ErrorIs(t, err, ErrReservationUnsupported)
+}
+
+// TestReserveNumbersConcurrent проверяет, что параллельные резервирования
+// получают непересекающиеся блоки подряд идущих номеров
+func TestReserveNumbersConcurrent(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	const workers, n = 8, 25
+
+	var wg sync.WaitGroup
+	blocks := make([][]int, workers)
+	errs := make([]error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			blocks[i], errs[i] = store.ReserveNumbers(n)
+		}(i)
+	}
+	wg.Wait()
+
+	// check
+	seen := make(map[int]bool)
+	for i, block := range blocks {
+		require.NoError(t, errs[i])
+		require.Len(t, block, n)
+		for j, number := range block {
+			require.Equal(t, block[0]+j, number)
+			require.False(t, seen[number], "номер %d выдан дважды", number)
+			seen[number] = true
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Политики разрешения конфликтов операций приложения курьера, см. ApplyOperations
+const (
+	// ConflictStatusPrecedence — статус посылки важнее операции: операция,
+	// противоречащая графу переходов или устаревшая, — конфликт
+	ConflictStatusPrecedence = "status_precedence"
+	// ConflictLastWriteWins — побеждает более позднее время: устаревшая
+	// операция пропускается, свежая применяется и против графа переходов
+	ConflictLastWriteWins = "last_write_wins"
+	// ConflictManualReview — конфликтующая операция откладывается
+	// в очередь разбора, см. ListOperationReviews
+	ConflictManualReview = "manual_review"
+)
+
+// Решения по отложенной операции, см. ResolveOperationReview
+const (
+	// ReviewApply — применить операцию, статус посылки ставится без
+	// проверки графа переходов, время статуса — время операции
+	ReviewApply = "apply"
+	// ReviewDiscard — отбросить операцию
+	ReviewDiscard = "discard"
+)
+
+var (
+	ErrReviewNotFound        = errors.New("отложенная операция не найдена")
+	ErrReviewResolved        = errors.New("отложенная операция уже разобрана")
+	ErrInvalidResolution     = errors.New("неизвестное решение по операции")
+	ErrUnknownConflictPolicy = errors.New("неизвестная политика конфликтов")
+)
+
+// conflictPolicies — допустимые политики конфликтов
+var conflictPolicies = map[string]bool{
+	ConflictStatusPrecedence: true,
+	ConflictLastWriteWins:    true,
+	ConflictManualReview:     true,
+}
+
+// ParseConflictPolicy проверяет имя политики конфликтов; пустое имя —
+// ConflictStatusPrecedence
+func ParseConflictPolicy(name string) (string, error) {
+	if name == "" {
+		return ConflictStatusPrecedence, nil
+	}
+	if !conflictPolicies[name] {
+		return "", fmt.Errorf("%w %q", ErrUnknownConflictPolicy, name)
+	}
+
+	return name, nil
+}
+
+// WithConflictPolicy задаёт политику разрешения конфликтов операций
+// приложения курьера, по умолчанию ConflictStatusPrecedence. Неизвестная
+// политика — паника, имя из настроек проверяется ParseConflictPolicy.
+func WithConflictPolicy(policy string) StoreOption {
+	policy, err := ParseConflictPolicy(policy)
+	if err != nil {
+		panic(err)
+	}
+
+	return func(s *ParcelStore) {
+		s.conflictPolicy = policy
+	}
+}
+
+// OperationReview — конфликтующая операция, отложенная на ручной разбор
+type OperationReview struct {
+	ID        int       `json:"id"`
+	Operation Operation `json:"operation"`
+	Parcel    int       `json:"parcel"`
+	// Status — статус посылки в момент конфликта
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// conflict возвращает итог конфликтующей операции: при политике
+// ConflictManualReview операция откладывается в очередь разбора
+func (s ParcelStore) conflict(op Operation, p Parcel, reason string) (OperationOutcome, error) {
+	if s.conflictPolicy != ConflictManualReview {
+		return OperationOutcome{Result: OutcomeConflict, Status: p.Status, Reason: reason}, nil
+	}
+
+	_, err := s.db.Exec("INSERT INTO {operation_review} (operation_id, kind, code, courier, at, parcel, status, reason, created_at) "+
+		"VALUES (:operation_id, :kind, :code, :courier, :at, :parcel, :status, :reason, :created_at)",
+		sql.Named("operation_id", op.ID),
+		sql.Named("kind", op.Kind),
+		sql.Named("code", op.Code),
+		sql.Named("courier", op.Courier),
+		sql.Named("at", formatTime(op.At)),
+		sql.Named("parcel", p.Number),
+		sql.Named("status", p.Status),
+		sql.Named("reason", reason),
+		sql.Named("created_at", formatTime(s.now())))
+	if err != nil {
+		return OperationOutcome{}, err
+	}
+
+	return OperationOutcome{Result: OutcomeParked, Status: p.Status, Reason: reason}, nil
+}
+
+// forceOperationStatus ставит посылке статус операции без проверки графа
+// переходов, время статуса — время операции. Вручение, как и по графу,
+// требует подтверждения доставки, см. checkDeliveryConfirmed.
+func (s ParcelStore) forceOperationStatus(number int, status string, at time.Time) error {
+	if status == ParcelStatusDelivered {
+		p, err := s.Get(number)
+		if err != nil {
+			return err
+		}
+		if err := s.checkDeliveryConfirmed(p); err != nil {
+			return err
+		}
+	}
+
+	return v1Error(s.SetStatusContext(context.Background(), number, status, WithEventTime(at)))
+}
+
+// ListOperationReviews возвращает до limit неразобранных отложенных
+// операций в порядке откладывания
+func (s ParcelStore) ListOperationReviews(limit int) ([]OperationReview, error) {
+	rows, err := s.db.Query("SELECT id, operation_id, kind, code, courier, at, parcel, status, reason, created_at "+
+		"FROM {operation_review} WHERE resolution = '' ORDER BY id LIMIT :limit",
+		sql.Named("limit", limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []OperationReview
+	for rows.Next() {
+		r := OperationReview{}
+		err := rows.Scan(&r.ID, &r.Operation.ID, &r.Operation.Kind, &r.Operation.Code, &r.Operation.Courier,
+			scanTime(&r.Operation.At), &r.Parcel, &r.Status, &r.Reason, scanTime(&r.CreatedAt))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ResolveOperationReview разбирает отложенную операцию id решением
+// resolution: ReviewApply ставит посылке статус операции, ReviewDiscard
+// только закрывает разбор. Повторный разбор — ErrReviewResolved.
+func (s ParcelStore) ResolveOperationReview(id int, resolution string) error {
+	if resolution != ReviewApply && resolution != ReviewDiscard {
+		return fmt.Errorf("%w %q", ErrInvalidResolution, resolution)
+	}
+
+	var (
+		r    OperationReview
+		done string
+	)
+	err := s.db.QueryRow("SELECT kind, at, parcel, resolution FROM {operation_review} WHERE id = :id",
+		sql.Named("id", id)).Scan(&r.Operation.Kind, scanTime(&r.Operation.At), &r.Parcel, &done)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrReviewNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if done != "" {
+		return ErrReviewResolved
+	}
+
+	// разбор закрывается условно: из двух одновременных решений
+	// применяется только первое
+	res, err := s.db.Exec("UPDATE {operation_review} SET resolution = :resolution, resolved_at = :resolved_at "+
+		"WHERE id = :id AND resolution = ''",
+		sql.Named("resolution", resolution),
+		sql.Named("resolved_at", formatTime(s.now())),
+		sql.Named("id", id))
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrReviewResolved
+	}
+
+	if resolution == ReviewDiscard {
+		return nil
+	}
+
+	if err := s.forceOperationStatus(r.Parcel, operationStatus[r.Operation.Kind], r.Operation.At); err != nil {
+		// операция не применена — разбор снова открыт
+		_, _ = s.db.Exec("UPDATE {operation_review} SET resolution = '', resolved_at = '' WHERE id = :id", sql.Named("id", id))
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestConflictLastWriteWins проверяет политику «последняя запись побеждает»
+func TestConflictLastWriteWins(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithConflictPolicy(ConflictLastWriteWins))
+	cancelled := getTestParcel()
+	number, err := store.Add(cancelled)
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusCancelled))
+	stale := getTestParcel()
+	staleNumber, err := store.Add(stale)
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatus(staleNumber, ParcelStatusRegistered, ParcelStatusSent))
+
+	at := time.Now().Add(time.Minute).UTC().Truncate(time.Millisecond)
+
+	// check
+	outcomes, err := store.ApplyOperations([]Operation{
+		{ID: "cancelled", Kind: OperationDeliver, Code: cancelled.UUID, Courier: 1, At: at},
+		{ID: "stale", Kind: OperationDeliver, Code: stale.UUID, Courier: 1, At: time.Now().Add(-time.Hour)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"cancelled applied", "stale skipped"}, outcomeResults(outcomes))
+
+	p, err := store.Get(number)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDelivered, p.Status)
+	require.True(t, at.Equal(p.DeliveredAt))
+	p, err = store.Get(staleNumber)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, p.Status)
+}
+
+// TestConflictManualReview проверяет откладывание конфликтов и их разбор
+func TestConflictManualReview(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithConflictPolicy(ConflictManualReview))
+	first := getTestParcel()
+	n1, err := store.Add(first)
+	require.NoError(t, err)
+	second := getTestParcel()
+	n2, err := store.Add(second)
+	require.NoError(t, err)
+	for _, number := range []int{n1, n2} {
+		require.NoError(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusCancelled))
+	}
+
+	at := time.Now().Add(time.Minute).UTC().Truncate(time.Millisecond)
+	outcomes, err := store.ApplyOperations([]Operation{
+		{ID: "first", Kind: OperationDeliver, Code: first.UUID, Courier: 1, At: at},
+		{ID: "second", Kind: OperationScan, Code: second.UUID, Courier: 1, At: at},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"first parked", "second parked"}, outcomeResults(outcomes))
+
+	// check
+	reviews, err := store.ListOperationReviews(10)
+	require.NoError(t, err)
+	require.Len(t, reviews, 2)
+	require.Equal(t, "first", reviews[0].Operation.ID)
+	require.Equal(t, n1, reviews[0].Parcel)
+	require.Equal(t, ParcelStatusCancelled, reviews[0].Status)
+	require.True(t, at.Equal(reviews[0].Operation.At))
+
+	require.NoError(t, store.ResolveOperationReview(reviews[0].ID, ReviewApply))
+	p, err := store.Get(n1)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDelivered, p.Status)
+	require.True(t, at.Equal(p.DeliveredAt))
+	require.ErrorIs(t, store.ResolveOperationReview(reviews[0].ID, ReviewDiscard), ErrReviewResolved)
+
+	require.NoError(t, store.ResolveOperationReview(reviews[1].ID, ReviewDiscard))
+	p, err = store.Get(n2)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusCancelled, p.Status)
+
+	reviews, err = store.ListOperationReviews(10)
+	require.NoError(t, err)
+	require.Empty(t, reviews)
+
+	require.ErrorIs(t, store.ResolveOperationReview(1000, ReviewApply), ErrReviewNotFound)
+	require.ErrorIs(t, store.ResolveOperationReview(1, "maybe"), ErrInvalidResolution)
+	require.Panics(t, func() { WithConflictPolicy("coin_flip") })
+}
+
+// TestOperationsDeliveryConfirmation проверяет, что вручение без подписи
+// или проверки документа — конфликт операции при любой политике, а не
+// сбой всей очереди
+func TestOperationsDeliveryConfirmation(t *testing.T) {
+	at := time.Now().Add(time.Minute).UTC().Truncate(time.Millisecond)
+
+	for _, policy := range []string{ConflictStatusPrecedence, ConflictManualReview, ConflictLastWriteWins} {
+		t.Run(policy, func(t *testing.T) {
+			// prepare
+			store := NewParcelStore(openTestDB(t), WithSignatureRequired(), WithConflictPolicy(policy))
+			scanned := getTestParcel()
+			scannedNumber, err := store.Add(scanned)
+			require.NoError(t, err)
+			delivered := getTestParcel()
+			deliveredNumber, err := store.Add(delivered)
+			require.NoError(t, err)
+			require.NoError(t, store.TransitionStatus(deliveredNumber, ParcelStatusRegistered, ParcelStatusSent))
+			// отменённую посылку «последняя запись» вручила бы в обход графа
+			cancelled := getTestParcel()
+			cancelled.AgeVerification = true
+			cancelledNumber, err := store.Add(cancelled)
+			require.NoError(t, err)
+			require.NoError(t, store.TransitionStatus(cancelledNumber, ParcelStatusRegistered, ParcelStatusCancelled))
+
+			// check
+			outcomes, err := store.ApplyOperations([]Operation{
+				{ID: "a", Kind: OperationScan, Code: scanned.UUID, Courier: 1, At: at},
+				{ID: "b", Kind: OperationDeliver, Code: delivered.UUID, Courier: 1, At: at},
+				{ID: "c", Kind: OperationDeliver, Code: cancelled.UUID, Courier: 1, At: at},
+			})
+			require.NoError(t, err)
+			result := OutcomeConflict
+			if policy == ConflictManualReview {
+				result = OutcomeParked
+			}
+			require.Equal(t, []string{"a " + OutcomeApplied, "b " + result, "c " + result}, outcomeResults(outcomes))
+			require.Equal(t, ErrDeliveryNotConfirmed.Error(), outcomes[1].Reason)
+			if policy == ConflictLastWriteWins {
+				require.Equal(t, ErrIDNotChecked.Error(), outcomes[2].Reason)
+			}
+
+			p, err := store.Get(scannedNumber)
+			require.NoError(t, err)
+			require.Equal(t, ParcelStatusSent, p.Status)
+			p, err = store.Get(deliveredNumber)
+			require.NoError(t, err)
+			require.Equal(t, ParcelStatusSent, p.Status)
+			p, err = store.Get(cancelledNumber)
+			require.NoError(t, err)
+			require.Equal(t, ParcelStatusCancelled, p.Status)
+
+			if policy != ConflictManualReview {
+				return
+			}
+			// разбор не вручает посылку без подтверждения и остаётся открытым
+			reviews, err := store.ListOperationReviews(10)
+			require.NoError(t, err)
+			require.Len(t, reviews, 2)
+			require.ErrorIs(t, store.ResolveOperationReview(reviews[0].ID, ReviewApply), ErrDeliveryNotConfirmed)
+			require.ErrorIs(t, store.ResolveOperationReview(reviews[1].ID, ReviewApply), ErrIDNotChecked)
+			reviews, err = store.ListOperationReviews(10)
+			require.NoError(t, err)
+			require.Len(t, reviews, 2)
+		})
+	}
+}
+
+// TestOperationReviewsHandler проверяет разбор отложенных операций через админку
+func TestOperationReviewsHandler(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithConflictPolicy(ConflictManualReview))
+	p := getTestParcel()
+	number, err := store.Add(p)
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusCancelled))
+	_, err = store.ApplyOperations([]Operation{
+		{ID: "op", Kind: OperationScan, Code: p.UUID, Courier: 1, At: time.Now().Add(time.Minute)},
+	})
+	require.NoError(t, err)
+	reviews, err := store.ListOperationReviews(10)
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+	handler := NewAdminHandler(store, NewErrorLog(10))
+
+	resolve := func(id int, action string) int {
+		form := url.Values{"id": {strconv.Itoa(id)}, "action": {action}}
+		req := httptest.NewRequest(http.MethodPost, "/admin/operation-reviews/resolve", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// check
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/operation-reviews", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"id":"op"`)
+
+	require.Equal(t, http.StatusBadRequest, resolve(reviews[0].ID, "maybe"))
+	require.Equal(t, http.StatusNotFound, resolve(1000, ReviewDiscard))
+	require.Equal(t, http.StatusNoContent, resolve(reviews[0].ID, ReviewDiscard))
+	require.Equal(t, http.StatusConflict, resolve(reviews[0].ID, ReviewDiscard))
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// dateLayout — формат дня доставки в БД и в запросах
+const dateLayout = "2006-01-02"
+
+// earthRadiusKm — средний радиус Земли для расчёта расстояний
+const earthRadiusKm = 6371.0
+
+var ErrInvalidAssignment = errors.New("некорректное назначение курьера")
+
+// Assignment — назначение посылки курьеру на день доставки с координатами
+// точки доставки
+type Assignment struct {
+	Parcel    int
+	Courier   int
+	Date      time.Time
+	Latitude  float64
+	Longitude float64
+}
+
+// RouteStop — точка маршрута курьера
+type RouteStop struct {
+	Number    int     `json:"-"`
+	UUID      string  `json:"uuid"`
+	Address   string  `json:"address"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+	// Window — окно доставки, выбранное получателем, см. DeliverySlots
+	Window string `json:"window,omitempty"`
+	// Instructions — согласие получателя оставить посылку соседу или в
+	// безопасном месте, см. SetDeliveryInstructions
+	Instructions *DeliveryInstructions `json:"instructions,omitempty"`
+	// DistanceKm — расстояние от предыдущей точки, у первой точки 0
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// Route — маршрут курьера на день
+type Route struct {
+	Courier int         `json:"courier"`
+	Date    string      `json:"date"`
+	Stops   []RouteStop `json:"stops"`
+	TotalKm float64     `json:"total_km"`
+}
+
+// Validate проверяет назначение перед сохранением
+func (a Assignment) Validate() error {
+	switch {
+	case a.Courier <= 0:
+		return fmt.Errorf("%w: не указан курьер", ErrInvalidAssignment)
+	case a.Date.IsZero():
+		return fmt.Errorf("%w: не указан день доставки", ErrInvalidAssignment)
+	case a.Latitude < -90 || a.Latitude > 90 || a.Longitude < -180 || a.Longitude > 180:
+		return fmt.Errorf("%w: координаты %f, %f", ErrInvalidAssignment, a.Latitude, a.Longitude)
+	}
+
+	return nil
+}
+
+// AssignCourier назначает посылку курьеру на день доставки. Повторное
+// назначение заменяет предыдущее.
+func (s ParcelStore) AssignCourier(a Assignment) error {
+	if err := a.Validate(); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec("INSERT INTO {delivery_assignment} (parcel, courier, day, latitude, longitude, assigned_at) "+
+		"VALUES (:parcel, :courier, :day, :latitude, :longitude, :assigned_at) "+
+		"ON CONFLICT (parcel) DO UPDATE SET courier = excluded.courier, day = excluded.day, "+
+		"latitude = excluded.latitude, longitude = excluded.longitude, assigned_at = excluded.assigned_at",
+		sql.Named("parcel", a.Parcel),
+		sql.Named("courier", a.Courier),
+		sql.Named("day", a.Date.UTC().Format(dateLayout)),
+		sql.Named("latitude", a.Latitude),
+		sql.Named("longitude", a.Longitude),
+		sql.Named("assigned_at", formatTime(s.now())))
+
+	return err
+}
+
+// ListDeliveryRoute возвращает недоставленные посылки курьера на день date
+// (UTC) в порядке объезда. Точки объезжаются окно за окном доставки,
+// точки без окна — вместе с самым ранним окном. Внутри окна порядок
+// строится жадно: первой идёт посылка, назначенная первой, или ближайшая
+// к концу предыдущего окна, дальше каждый раз выбирается ближайшая
+// из оставшихся точек.
+func (s ParcelStore) ListDeliveryRoute(courier int, date time.Time) (Route, error) {
+	day := date.UTC().Format(dateLayout)
+	rows, err := s.db.Query("SELECT p.number, p.uuid, p.address, a.latitude, a.longitude, COALESCE(w.slot, ''), "+
+		"COALESCE(i.kind, ''), COALESCE(i.note, ''), i.set_at "+
+		"FROM {delivery_assignment} a JOIN {parcel} p ON p.number = a.parcel "+
+		"LEFT JOIN {delivery_window} w ON w.parcel = a.parcel "+
+		"LEFT JOIN {delivery_instructions} i ON i.parcel = a.parcel "+
+		"WHERE a.courier = :courier AND a.day = :day AND p.status NOT IN (:delivered, :lost, :damaged, :returned, :cancelled) "+
+		"ORDER BY a.assigned_at, a.parcel",
+		sql.Named("courier", courier),
+		sql.Named("day", day),
+		sql.Named("delivered", ParcelStatusDelivered),
+		sql.Named("lost", ParcelStatusLost),
+		sql.Named("damaged", ParcelStatusDamaged),
+		sql.Named("returned", ParcelStatusReturned),
+		sql.Named("cancelled", ParcelStatusCancelled))
+	if err != nil {
+		return Route{}, err
+	}
+	defer rows.Close()
+
+	var stops []RouteStop
+	for rows.Next() {
+		st := RouteStop{}
+		var i DeliveryInstructions
+		if err := rows.Scan(&st.Number, &st.UUID, &st.Address, &st.Latitude, &st.Longitude, &st.Window,
+			&i.Kind, &i.Note, scanTime(&i.SetAt)); err != nil {
+			return Route{}, err
+		}
+		if i.Kind != "" {
+			st.Instructions = &i
+		}
+		stops = append(stops, st)
+	}
+
+	if err := rows.Err(); err != nil {
+		return Route{}, err
+	}
+
+	route := Route{Courier: courier, Date: day, Stops: windowOrder(stops)}
+	for _, st := range route.Stops {
+		route.TotalKm += st.DistanceKm
+	}
+
+	return route, nil
+}
+
+// windowOrder упорядочивает точки по окнам доставки, внутри окна — методом
+// ближайшего соседа от последней точки предыдущего окна
+func windowOrder(stops []RouteStop) []RouteStop {
+	groups := make([][]RouteStop, len(DeliverySlots))
+	for _, st := range stops {
+		i := deliverySlotIndex(st.Window)
+		if i < 0 {
+			i = 0
+		}
+		groups[i] = append(groups[i], st)
+	}
+
+	res := make([]RouteStop, 0, len(stops))
+	for _, group := range groups {
+		res = nearestNeighbor(res, group)
+	}
+
+	return res
+}
+
+// nearestNeighbor дописывает к маршруту res точки stops жадным методом
+// ближайшего соседа и проставляет расстояния между соседними точками.
+// Для пустого маршрута первой идёт первая из stops.
+func nearestNeighbor(res []RouteStop, stops []RouteStop) []RouteStop {
+	left := append([]RouteStop(nil), stops...)
+	for len(left) > 0 {
+		next := 0
+		if len(res) > 0 {
+			prev := res[len(res)-1]
+			best := math.Inf(1)
+			for i, st := range left {
+				if d := distanceKm(prev, st); d < best {
+					best, next = d, i
+				}
+			}
+			left[next].DistanceKm = best
+		}
+		res = append(res, left[next])
+		left = append(left[:next], left[next+1:]...)
+	}
+
+	return res
+}
+
+// distanceKm возвращает расстояние между точками по формуле гаверсинусов
+func distanceKm(a RouteStop, b RouteStop) float64 {
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// gpx — корневой элемент файла GPX 1.1 с маршрутом
+type gpx struct {
+	XMLName xml.Name `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Route   gpxRoute `xml:"rte"`
+}
+
+type gpxRoute struct {
+	Name   string     `xml:"name"`
+	Points []gpxPoint `xml:"rtept"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Name string  `xml:"name"`
+	Desc string  `xml:"desc"`
+}
+
+// GPX возвращает маршрут в формате GPX 1.1 для навигатора курьера
+func (r Route) GPX() ([]byte, error) {
+	doc := gpx{
+		Version: "1.1",
+		Creator: "go-db-sql-final",
+		Route:   gpxRoute{Name: fmt.Sprintf("courier %d %s", r.Courier, r.Date)},
+	}
+	for _, st := range r.Stops {
+		doc.Route.Points = append(doc.Route.Points, gpxPoint{Lat: st.Latitude, Lon: st.Longitude, Name: st.UUID, Desc: st.Address})
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), data...), nil
+}
+
+// NewRouteHandler возвращает обработчик GET /courier/route для приложения
+// курьера. Параметры: courier, date (ГГГГ-ММ-ДД, по умолчанию сегодня
+// по UTC) и format — json по умолчанию или gpx.
+func NewRouteHandler(store ParcelStore, errLog *ErrorLog) http.Handler {
+	h := AdminHandler{store: store, errors: errLog}
+
+	return h.getOnly(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		courier, err := strconv.Atoi(q.Get("courier"))
+		if err != nil || courier <= 0 {
+			http.Error(w, "courier должен быть положительным числом", http.StatusBadRequest)
+			return
+		}
+
+		date := store.now().UTC()
+		if v := q.Get("date"); v != "" {
+			date, err = time.Parse(dateLayout, v)
+			if err != nil {
+				http.Error(w, "date должен быть в формате ГГГГ-ММ-ДД", http.StatusBadRequest)
+				return
+			}
+		}
+
+		route, err := store.ListDeliveryRoute(courier, date)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		switch q.Get("format") {
+		case "", "json":
+			if route.Stops == nil {
+				route.Stops = []RouteStop{}
+			}
+			writeJSONConditional(w, r, route)
+		case "gpx":
+			data, err := route.GPX()
+			if err != nil {
+				h.fail(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/gpx+xml")
+			w.Write(data)
+		default:
+			http.Error(w, "format должен быть json или gpx", http.StatusBadRequest)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// addTestRoute назначает курьеру 7 посылки в точках на одной широте
+// и возвращает их номера в порядке назначения
+func addTestRoute(t *testing.T, store ParcelStore, day time.Time) []int {
+	t.Helper()
+
+	var numbers []int
+	for _, lon := range []float64{30.0, 30.3, 30.1, 30.2} {
+		number, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+		require.NoError(t, store.AssignCourier(Assignment{Parcel: number, Courier: 7, Date: day, Latitude: 59.9, Longitude: lon}))
+		numbers = append(numbers, number)
+	}
+
+	return numbers
+}
+
+// TestListDeliveryRoute проверяет порядок объезда по ближайшему соседу
+func TestListDeliveryRoute(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	day := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	numbers := addTestRoute(t, store, day)
+
+	// доставленная посылка и посылка на другой день в маршрут не попадают
+	require.NoError(t, store.SetStatus(numbers[3], ParcelStatusDelivered))
+	other, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.AssignCourier(Assignment{Parcel: other, Courier: 7, Date: day.AddDate(0, 0, 1), Latitude: 59.9, Longitude: 30}))
+
+	// check
+	route, err := store.ListDeliveryRoute(7, day.Add(15*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, "2026-10-14", route.Date)
+	require.Len(t, route.Stops, 3)
+	require.Equal(t, []int{numbers[0], numbers[2], numbers[1]}, []int{route.Stops[0].Number, route.Stops[1].Number, route.Stops[2].Number})
+	require.Zero(t, route.Stops[0].DistanceKm)
+	// 0,3° долготы на широте 59,9° — около 16,8 км
+	require.InDelta(t, 16.8, route.TotalKm, 0.2)
+}
+
+// TestDeliveryWindowRoute проверяет объезд точек по окнам доставки
+func TestDeliveryWindowRoute(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	day := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	numbers := addTestRoute(t, store, day)
+	require.NoError(t, store.SetDeliveryWindow(numbers[0], "15-18"))
+	require.NoError(t, store.SetDeliveryWindow(numbers[1], "09-12"))
+	require.NoError(t, store.SetDeliveryWindow(numbers[3], "18-21"))
+	require.NoError(t, store.SetDeliveryWindow(numbers[3], "12-15"))
+
+	// check
+	route, err := store.ListDeliveryRoute(7, day)
+	require.NoError(t, err)
+	require.Len(t, route.Stops, 4)
+	var order []int
+	var windows []string
+	for _, st := range route.Stops {
+		order = append(order, st.Number)
+		windows = append(windows, st.Window)
+	}
+	// точка без окна объезжается вместе с утренним окном
+	require.Equal(t, []int{numbers[1], numbers[2], numbers[3], numbers[0]}, order)
+	require.Equal(t, []string{"09-12", "", "12-15", "15-18"}, windows)
+
+	slot, err := store.GetDeliveryWindow(numbers[3])
+	require.NoError(t, err)
+	require.Equal(t, "12-15", slot)
+	require.NoError(t, store.SetDeliveryWindow(numbers[3], ""))
+	slot, err = store.GetDeliveryWindow(numbers[3])
+	require.NoError(t, err)
+	require.Empty(t, slot)
+
+	require.ErrorIs(t, store.SetDeliveryWindow(numbers[0], "07-09"), ErrInvalidDeliveryWindow)
+	require.NoError(t, store.SetStatus(numbers[0], ParcelStatusDelivered))
+	require.ErrorIs(t, store.SetDeliveryWindow(numbers[0], "12-15"), ErrDeliveryWindowClosed)
+}
+
+// TestAssignCourierInvalid проверяет отказ от некорректного назначения
+func TestAssignCourierInvalid(t *testing.T) {
+	store := NewParcelStore(openTestDB(t))
+	day := time.Now()
+
+	for _, a := range []Assignment{
+		{Parcel: 1, Date: day},
+		{Parcel: 1, Courier: 7},
+		{Parcel: 1, Courier: 7, Date: day, Latitude: 91},
+	} {
+		require.ErrorIs(t, store.AssignCourier(a), ErrInvalidAssignment)
+	}
+}
+
+// TestRouteEndpoint проверяет выгрузку маршрута в JSON и GPX
+func TestRouteEndpoint(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	addTestRoute(t, store, time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC))
+	handler := NewHTTPHandler(store, NewErrorLog(10))
+
+	// json
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/courier/route?courier=7&date=2026-10-14", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var route Route
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &route))
+	require.Len(t, route.Stops, 4)
+
+	// gpx
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/courier/route?courier=7&date=2026-10-14&format=gpx", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/gpx+xml", rec.Header().Get("Content-Type"))
+	var doc gpx
+	require.NoError(t, xml.Unmarshal(rec.Body.Bytes(), &doc))
+	require.Len(t, doc.Route.Points, 4)
+	require.Equal(t, route.Stops[1].UUID, doc.Route.Points[1].Name)
+
+	// ошибки параметров
+	for _, target := range []string{"/courier/route", "/courier/route?courier=7&date=14.10.2026", "/courier/route?courier=7&format=kml"} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+}
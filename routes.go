@@ -0,0 +1,24 @@
+package main
+
+import "net/http"
+
+// NewHTTPHandler собирает все HTTP-эндпоинты трекера в один обработчик.
+// /track/ публичный, /admin/ предназначен только для внутренней сети.
+// Каждому запросу назначается идентификатор, см. RequestIDMiddleware;
+// изменения через /admin/ и /courier/ журналируются от имени оператора
+// из OperatorHeader, через /track/ — от имени OperatorRecipient.
+func NewHTTPHandler(store ParcelStore, errors *ErrorLog) http.Handler {
+	health := NewHealthHandler(store)
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", health)
+	mux.Handle("/readyz", health)
+	mux.Handle("/admin/", OperatorMiddleware(NewAdminHandler(store, errors)))
+	mux.Handle("/track/", recipientOperator(NewTrackHandler(store, errors)))
+	mux.Handle("/courier/route", NewRouteHandler(store, errors))
+	mux.Handle("/courier/changes", NewChangesHandler(store, errors))
+	mux.Handle("/courier/operations", OperatorMiddleware(NewOperationsHandler(store, errors)))
+	mux.Handle("/courier/deliveries", OperatorMiddleware(NewDeliveryConfirmationHandler(store, errors)))
+
+	return RequestIDMiddleware(mux)
+}
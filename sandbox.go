@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// SandboxPrefix — префикс таблиц песочницы перед префиксом хранилища
+const SandboxPrefix = "sandbox_"
+
+var (
+	ErrNotSandbox          = errors.New("операция доступна только в песочнице")
+	ErrNoSandboxTransition = errors.New("из текущего статуса нет пути в запрошенный")
+)
+
+// Sandbox возвращает хранилище песочницы партнёров на той же БД: его
+// таблицы получают префикс SandboxPrefix, поэтому тестовые посылки не
+// смешиваются с рабочими и не расходуют квоты партнёров. Таблицы
+// песочницы создаются её собственным Migrate. Номера посылок в песочнице
+// назначает автоинкремент её таблицы, хуки встраивающего приложения,
+// кэш отслеживания и публикация событий не используются. Остальные
+// настройки, например часы и политики, те же, что у рабочего хранилища.
+func (s ParcelStore) Sandbox() ParcelStore {
+	if s.sandbox {
+		return s
+	}
+
+	sb := s
+	sb.ids = AutoIncrement{}
+	sb.naming = naming{schema: s.naming.schema, prefix: SandboxPrefix + s.naming.prefix}
+	sb.flags = &flagCache{ttl: s.flags.ttl}
+	sb.sandbox = true
+	sb.hooks = nil
+	sb.cache = nil
+	sb.publisher = nil
+	sb.trackingView = false
+	sb.db = storeDB{db: s.db.db, names: sb.naming.replacer(), shedder: s.shedder, tracer: s.tracer}
+
+	return sb
+}
+
+// IsSandbox сообщает, что хранилище — песочница партнёров
+func (s ParcelStore) IsSandbox() bool {
+	return s.sandbox
+}
+
+// IssueSandboxKey выдаёт партнёру ключ API песочницы взамен прежнего.
+// В БД хранится только хеш ключа.
+func (s ParcelStore) IssueSandboxKey(id string) (string, error) {
+	key, err := newAPIKey()
+	if err != nil {
+		return "", err
+	}
+
+	res, err := s.db.Exec("UPDATE {partner} SET sandbox_key_hash = :hash WHERE id = :id",
+		sql.Named("hash", hashAPIKey(key)),
+		sql.Named("id", id))
+	if err != nil {
+		return "", err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if affected == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	return key, nil
+}
+
+// AdvanceSandbox по запросу продвигает посылку песочницы в статус status
+// по графу статусов её арендатора, проходя промежуточные статусы.
+// Пустой status — следующий статус основного пути доставки. Возвращает
+// новый статус посылки.
+func (s ParcelStore) AdvanceSandbox(number int, status string) (string, error) {
+	if !s.sandbox {
+		return "", ErrNotSandbox
+	}
+
+	p, err := s.Get(number)
+	if err != nil {
+		return "", err
+	}
+	graph, err := s.StatusGraph(p.Tenant)
+	if err != nil {
+		return "", err
+	}
+
+	if status == "" {
+		next, ok := graph.Next(p.Status)
+		if !ok {
+			return "", fmt.Errorf("%w: статус %s конечный", ErrNoSandboxTransition, p.Status)
+		}
+		status = next
+	}
+	path := graph.Path(p.Status, status)
+	if path == nil {
+		return "", fmt.Errorf("%w: %s → %s", ErrNoSandboxTransition, p.Status, status)
+	}
+
+	from := p.Status
+	for _, to := range path {
+		if err := s.transitionStatusAt(number, from, to, s.now()); err != nil {
+			return from, err
+		}
+		from = to
+	}
+
+	return from, nil
+}
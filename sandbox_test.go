@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestSandbox проверяет, что запросы по ключу песочницы пишутся
+// в отдельные таблицы и посылки песочницы можно продвигать по запросу
+func TestSandbox(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	require.NoError(t, store.Sandbox().Migrate())
+	key, err := store.RegisterPartner(Partner{ID: "shop", Name: "Магазин", MonthlyQuota: 1})
+	require.NoError(t, err)
+	sandboxKey, err := store.IssueSandboxKey("shop")
+	require.NoError(t, err)
+	_, err = store.IssueSandboxKey("none")
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// check
+	partner, err := store.AuthenticatePartner(key)
+	require.NoError(t, err)
+	require.False(t, partner.Sandbox)
+	require.False(t, store.IsSandbox())
+
+	partner, err = store.AuthenticatePartner(sandboxKey)
+	require.NoError(t, err)
+	require.True(t, partner.Sandbox)
+	prod, sandbox := store, store.Sandbox()
+	require.True(t, sandbox.IsSandbox())
+
+	// песочница не расходует квоту и не видна в рабочих таблицах
+	p := getTestParcel()
+	p.Tenant = partner.ID
+	number, err := sandbox.Add(p)
+	require.NoError(t, err)
+	p = getTestParcel()
+	p.Tenant = partner.ID
+	_, err = sandbox.Add(p)
+	require.NoError(t, err)
+	_, err = prod.Get(number)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	status, err := sandbox.AdvanceSandbox(number, "")
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, status)
+	status, err = sandbox.AdvanceSandbox(number, ParcelStatusDamaged)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDamaged, status)
+	_, err = sandbox.AdvanceSandbox(number, "")
+	require.ErrorIs(t, err, ErrNoSandboxTransition)
+
+	_, err = prod.AdvanceSandbox(number, "")
+	require.ErrorIs(t, err, ErrNotSandbox)
+}
+
+// TestSandboxSettings проверяет, что песочница наследует настройки
+// рабочего хранилища
+func TestSandboxSettings(t *testing.T) {
+	// prepare
+	clock := NewManualClock(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC))
+	store := NewParcelStore(openTestDB(t), WithClock(clock), WithCreatedAtWindow(0, 0))
+	sandbox := store.Sandbox()
+	require.NoError(t, sandbox.Migrate())
+	p := getTestParcel()
+	p.CreatedAt = time.Time{}
+	p.RequiresRefrigeration = true
+
+	// check
+	number, err := sandbox.Add(p)
+	require.NoError(t, err)
+	got, err := sandbox.Get(number)
+	require.NoError(t, err)
+	require.True(t, clock.Now().Equal(got.CreatedAt))
+
+	r, err := sandbox.AddTemperatureReading(number, 4, time.Time{})
+	require.NoError(t, err)
+	require.False(t, r.Breach)
+	r, err = sandbox.AddTemperatureReading(number, 20, time.Time{})
+	require.NoError(t, err)
+	require.True(t, r.Breach)
+}
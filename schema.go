@@ -0,0 +1,874 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// migrations содержит последовательные изменения схемы БД.
+// Номер последней применённой миграции хранится в таблице {schema_version},
+// поэтому новые изменения добавляются только в конец списка.
+var migrations = []string{
+	// 1: исходная таблица посылок
+	`CREATE TABLE IF NOT EXISTS {parcel}
+(
+    number     integer
+        constraint parcel_pk
+            primary key autoincrement,
+    client     integer      not null,
+    status     VARCHAR(128) not null,
+    address    VARCHAR(512) not null,
+    created_at text         not null
+)`,
+	// 2: уровень обслуживания
+	`ALTER TABLE {parcel} ADD COLUMN service_level VARCHAR(32) not null DEFAULT 'standard'`,
+	// 3: наложенный платёж
+	`ALTER TABLE {parcel} ADD COLUMN cod_amount integer not null DEFAULT 0;
+ALTER TABLE {parcel} ADD COLUMN cod_collected integer not null DEFAULT 0;
+ALTER TABLE {parcel} ADD COLUMN cod_collected_amount integer not null DEFAULT 0;
+ALTER TABLE {parcel} ADD COLUMN cod_collected_by VARCHAR(128) not null DEFAULT '';
+ALTER TABLE {parcel} ADD COLUMN cod_collected_at text not null DEFAULT ''`,
+	// 4: таможенная декларация
+	`CREATE TABLE {customs_item}
+(
+    id          integer
+        constraint customs_item_pk
+            primary key autoincrement,
+    parcel      integer      not null
+        references {prefix}parcel (number) on delete cascade,
+    description VARCHAR(512) not null,
+    quantity    integer      not null,
+    hs_code     VARCHAR(16)  not null,
+    value       integer      not null
+);
+CREATE INDEX {schema}{prefix}customs_item_parcel_idx ON {prefix}customs_item (parcel)`,
+	// 5: зоны доставки
+	`ALTER TABLE {parcel} ADD COLUMN country VARCHAR(2) not null DEFAULT '';
+ALTER TABLE {parcel} ADD COLUMN postal_code VARCHAR(16) not null DEFAULT '';
+ALTER TABLE {parcel} ADD COLUMN zone VARCHAR(64) not null DEFAULT '';
+CREATE INDEX {schema}{prefix}parcel_zone_idx ON {prefix}parcel (zone);
+CREATE TABLE {zone}
+(
+    id            integer
+        constraint zone_pk
+            primary key autoincrement,
+    country       VARCHAR(2)  not null,
+    postal_prefix VARCHAR(16) not null,
+    name          VARCHAR(64) not null,
+    constraint zone_country_prefix_uq unique (country, postal_prefix)
+)`,
+	// 6: страхование
+	`ALTER TABLE {parcel} ADD COLUMN insured integer not null DEFAULT 0;
+ALTER TABLE {parcel} ADD COLUMN declared_value integer not null DEFAULT 0;
+ALTER TABLE {parcel} ADD COLUMN insurance_premium integer not null DEFAULT 0;
+CREATE TABLE {insurance_claim}
+(
+    id          integer
+        constraint insurance_claim_pk
+            primary key autoincrement,
+    parcel      integer      not null
+        references {prefix}parcel (number) on delete cascade,
+    reason      VARCHAR(128) not null,
+    amount      integer      not null,
+    description text         not null,
+    status      VARCHAR(32)  not null,
+    payout      integer      not null DEFAULT 0,
+    created_at  text         not null,
+    resolved_at text         not null DEFAULT ''
+);
+CREATE INDEX {schema}{prefix}insurance_claim_parcel_idx ON {prefix}insurance_claim (parcel)`,
+	// 7: вложения и акты о повреждении
+	`CREATE TABLE {attachment}
+(
+    id           integer
+        constraint attachment_pk
+            primary key autoincrement,
+    parcel       integer      not null
+        references {prefix}parcel (number) on delete cascade,
+    kind         VARCHAR(64)  not null,
+    name         VARCHAR(256) not null,
+    content_type VARCHAR(128) not null,
+    data         blob         not null,
+    created_at   text         not null
+);
+CREATE INDEX {schema}{prefix}attachment_parcel_idx ON {prefix}attachment (parcel, kind);
+CREATE TABLE {damage_report}
+(
+    parcel      integer not null
+        constraint damage_report_pk
+            primary key
+        references {prefix}parcel (number) on delete cascade,
+    description text    not null,
+    reported_at text    not null
+)`,
+	// 8: публичный идентификатор, уже добавленным посылкам назначается UUID v4
+	`ALTER TABLE {parcel} ADD COLUMN uuid VARCHAR(36) not null DEFAULT '';
+UPDATE {parcel} SET uuid = lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' ||
+    substr(lower(hex(randomblob(2))), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+    substr(lower(hex(randomblob(2))), 2) || '-' || lower(hex(randomblob(6)));
+CREATE UNIQUE INDEX {schema}{prefix}parcel_uuid_uq ON {prefix}parcel (uuid)`,
+	// 9: не больше одного открытого страхового требования по посылке
+	`CREATE UNIQUE INDEX {schema}{prefix}insurance_claim_open_uq ON {prefix}insurance_claim (parcel) WHERE status = 'open'`,
+	// 10: время хранится в UTC с миллисекундами фиксированной ширины (timeLayout),
+	// время отправки и доставки посылки
+	`UPDATE {parcel} SET created_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', created_at), created_at);
+UPDATE {parcel} SET cod_collected_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', cod_collected_at), cod_collected_at)
+    WHERE cod_collected_at != '';
+UPDATE {insurance_claim} SET created_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', created_at), created_at);
+UPDATE {insurance_claim} SET resolved_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', resolved_at), resolved_at)
+    WHERE resolved_at != '';
+UPDATE {attachment} SET created_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', created_at), created_at);
+UPDATE {damage_report} SET reported_at = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ', reported_at), reported_at);
+ALTER TABLE {parcel} ADD COLUMN sent_at text not null DEFAULT '';
+ALTER TABLE {parcel} ADD COLUMN delivered_at text not null DEFAULT ''`,
+	// 11: адрес отправителя, арендатор, стоимость и outbox уведомлений
+	`ALTER TABLE {parcel} ADD COLUMN sender_email VARCHAR(254) not null DEFAULT '';
+ALTER TABLE {parcel} ADD COLUMN tenant VARCHAR(64) not null DEFAULT '';
+ALTER TABLE {parcel} ADD COLUMN price integer not null DEFAULT 0;
+CREATE TABLE {outbox}
+(
+    id integer not null primary key autoincrement,
+    topic VARCHAR(64) not null,
+    payload text not null,
+    created_at text not null,
+    sent_at text not null DEFAULT ''
+);
+CREATE INDEX {schema}{prefix}outbox_pending_idx ON {prefix}outbox (sent_at, id)`,
+	// 12: язык уведомлений клиента
+	`ALTER TABLE {parcel} ADD COLUMN locale VARCHAR(8) not null DEFAULT ''`,
+	// 13: собственные статусы арендаторов и их графы переходов
+	`CREATE TABLE {tenant_status}
+(
+    tenant VARCHAR(64) not null,
+    code VARCHAR(64) not null,
+    name VARCHAR(128) not null,
+    primary key (tenant, code)
+);
+CREATE TABLE {status_transition}
+(
+    id integer not null primary key autoincrement,
+    tenant VARCHAR(64) not null,
+    from_status VARCHAR(64) not null,
+    to_status VARCHAR(64) not null,
+    unique (tenant, from_status, to_status)
+)`,
+	// 14: заметки поддержки к посылкам
+	`CREATE TABLE {note}
+(
+    id integer not null primary key autoincrement,
+    parcel integer not null
+        references {prefix}parcel (number) on delete cascade,
+    author VARCHAR(128) not null,
+    text text not null,
+    visibility VARCHAR(16) not null,
+    created_at text not null
+);
+CREATE INDEX {schema}{prefix}note_parcel_idx ON {prefix}note (parcel)`,
+	// 15: заказы, объединяющие посылки; у посылки вне заказа order_id NULL
+	`CREATE TABLE {customer_order}
+(
+    id integer not null primary key autoincrement,
+    client integer not null,
+    created_at text not null
+);
+ALTER TABLE {parcel} ADD COLUMN order_id integer
+    references {prefix}customer_order (id);
+CREATE INDEX {schema}{prefix}parcel_order_idx ON {prefix}parcel (order_id)`,
+	// 16: назначение посылок курьерам с координатами точки доставки
+	`CREATE TABLE {delivery_assignment}
+(
+    parcel integer not null primary key
+        references {prefix}parcel (number) on delete cascade,
+    courier integer not null,
+    day text not null,
+    latitude real not null,
+    longitude real not null,
+    assigned_at text not null
+);
+CREATE INDEX {schema}{prefix}delivery_assignment_courier_idx ON {prefix}delivery_assignment (courier, day)`,
+	// 17: пункты выдачи и постаматы как место назначения посылки
+	// и прибытие посылок в них
+	`CREATE TABLE {pickup_point}
+(
+    id integer not null primary key autoincrement,
+    name VARCHAR(256) not null,
+    address VARCHAR(512) not null,
+    locker integer not null default 0
+);
+ALTER TABLE {parcel} ADD COLUMN pickup_point integer
+    references {prefix}pickup_point (id);
+CREATE INDEX {schema}{prefix}parcel_pickup_point_idx ON {prefix}parcel (pickup_point);
+CREATE TABLE {pickup_arrival}
+(
+    parcel integer not null primary key
+        references {prefix}parcel (number) on delete cascade,
+    point integer not null
+        references {prefix}pickup_point (id),
+    arrived_at text not null
+);
+CREATE INDEX {schema}{prefix}pickup_arrival_arrived_idx ON {prefix}pickup_arrival (arrived_at)`,
+	// 18: одноразовый код получения посылки в пункте выдачи
+	`ALTER TABLE {pickup_arrival} ADD COLUMN code_hash VARCHAR(64) not null default '';
+ALTER TABLE {pickup_arrival} ADD COLUMN code_expires_at text not null default '';
+ALTER TABLE {pickup_arrival} ADD COLUMN code_attempts integer not null default 0`,
+	// 19: нарушения согласованности данных посылок, найденные проверкой
+	`CREATE TABLE {anomaly}
+(
+    id integer not null primary key autoincrement,
+    parcel integer not null
+        references {prefix}parcel (number) on delete cascade,
+    kind VARCHAR(64) not null,
+    detail text not null,
+    found_at text not null,
+    acknowledged_by VARCHAR(128) not null default '',
+    acknowledged_at text not null default '',
+    unique (parcel, kind)
+)`,
+	// 20: счётчик попыток outbox и недоставленные сообщения
+	`ALTER TABLE {outbox} ADD COLUMN attempts integer not null DEFAULT 0;
+ALTER TABLE {outbox} ADD COLUMN last_error text not null DEFAULT '';
+CREATE TABLE {dead_letter}
+(
+    id integer not null primary key autoincrement,
+    topic VARCHAR(64) not null,
+    payload text not null,
+    reason text not null,
+    attempts integer not null,
+    created_at text not null,
+    failed_at text not null
+)`,
+	// 21: ключ дедупликации сообщений outbox для получателей; уже
+	// записанным сообщениям назначается случайный ключ
+	`ALTER TABLE {outbox} ADD COLUMN dedup_key VARCHAR(36) not null DEFAULT '';
+UPDATE {outbox} SET dedup_key = lower(hex(randomblob(16)));
+CREATE UNIQUE INDEX {schema}{prefix}outbox_dedup_uq ON {prefix}outbox (dedup_key);
+ALTER TABLE {dead_letter} ADD COLUMN dedup_key VARCHAR(36) not null DEFAULT '';
+UPDATE {dead_letter} SET dedup_key = lower(hex(randomblob(16)))`,
+	// 22: отправления у внешних перевозчиков
+	`CREATE TABLE {carrier_shipment}
+(
+    parcel integer not null primary key
+        references {prefix}parcel (number) on delete cascade,
+    carrier VARCHAR(64) not null,
+    tracking_number VARCHAR(128) not null,
+    created_at text not null,
+    synced_at text not null DEFAULT '',
+    unique (carrier, tracking_number)
+)`,
+	// 23: запросы клиентов на переадресацию отправленных посылок,
+	// не больше одного ожидающего решения по посылке
+	`CREATE TABLE {address_redirect}
+(
+    id integer not null primary key autoincrement,
+    parcel integer not null
+        references {prefix}parcel (number) on delete cascade,
+    address VARCHAR(512) not null,
+    status VARCHAR(16) not null,
+    requested_at text not null,
+    resolved_by VARCHAR(128) not null DEFAULT '',
+    resolved_at text not null DEFAULT ''
+);
+CREATE UNIQUE INDEX {schema}{prefix}address_redirect_pending_uq ON {prefix}address_redirect (parcel) WHERE status = 'pending'`,
+	// 24: журнал изменений посылок операторами; без внешнего ключа,
+	// чтобы записи об удалённых посылках сохранялись
+	`CREATE TABLE {audit_log}
+(
+    id integer not null primary key autoincrement,
+    parcel integer not null,
+    operator VARCHAR(128) not null,
+    action VARCHAR(32) not null,
+    before text not null,
+    after text not null,
+    at text not null
+);
+CREATE INDEX {schema}{prefix}audit_log_parcel_idx ON {prefix}audit_log (parcel, at);
+CREATE INDEX {schema}{prefix}audit_log_operator_idx ON {prefix}audit_log (operator, at)`,
+	// 25: флаги функциональности, общие (tenant = '') и арендаторов
+	`CREATE TABLE {feature_flag}
+(
+    name VARCHAR(64) not null,
+    tenant VARCHAR(64) not null DEFAULT '',
+    enabled integer not null,
+    updated_at text not null,
+    primary key (name, tenant)
+)`,
+	// 26: ответы на запросы с Idempotency-Key для повторов клиентов
+	`CREATE TABLE {idempotency_key}
+(
+    key VARCHAR(255) not null primary key,
+    request_hash VARCHAR(64) not null,
+    status integer not null DEFAULT 0,
+    content_type VARCHAR(255) not null DEFAULT '',
+    body blob not null DEFAULT '',
+    created_at text not null
+);
+CREATE INDEX {schema}{prefix}idempotency_key_created_idx ON {prefix}idempotency_key (created_at)`,
+	// 27: время последнего изменения посылки. Его ведут триггеры, чтобы
+	// ни один UPDATE посылки, в том числе из будущего кода, не забыл его
+	// обновить; в теле триггера схема не указывается, SQLite берёт схему
+	// самого триггера
+	`ALTER TABLE {parcel} ADD COLUMN updated_at text not null DEFAULT '';
+UPDATE {parcel} SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now');
+CREATE INDEX {schema}{prefix}parcel_updated_idx ON {prefix}parcel (updated_at, number);
+CREATE TRIGGER {schema}{prefix}parcel_insert_touch AFTER INSERT ON {prefix}parcel FOR EACH ROW
+BEGIN
+    UPDATE {prefix}parcel SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE number = NEW.number;
+END;
+CREATE TRIGGER {schema}{prefix}parcel_update_touch AFTER UPDATE ON {prefix}parcel FOR EACH ROW
+    WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE {prefix}parcel SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE number = NEW.number;
+END`,
+	// 28: метаданные интеграторов в JSON
+	`ALTER TABLE {parcel} ADD COLUMN metadata text not null DEFAULT '{}'`,
+	// 29: идентификатор HTTP-запроса, в котором сделано изменение
+	`ALTER TABLE {audit_log} ADD COLUMN request_id VARCHAR(64) not null DEFAULT '';
+CREATE INDEX {schema}{prefix}audit_log_request_idx ON {prefix}audit_log (request_id)`,
+	// 30: вызовы курьера за посылками клиента; посылка входит не больше
+	// чем в один вызов
+	`CREATE TABLE {courier_pickup}
+(
+    id integer not null primary key autoincrement,
+    client integer not null,
+    address VARCHAR(512) not null,
+    window_start text not null,
+    window_end text not null,
+    status VARCHAR(16) not null,
+    requested_at text not null,
+    confirmed_by VARCHAR(128) not null DEFAULT '',
+    confirmed_at text not null DEFAULT ''
+);
+CREATE INDEX {schema}{prefix}courier_pickup_window_idx ON {prefix}courier_pickup (window_start);
+CREATE TABLE {courier_pickup_parcel}
+(
+    parcel integer not null primary key
+        references {prefix}parcel (number) on delete cascade,
+    pickup integer not null
+        references {prefix}courier_pickup (id) on delete cascade,
+    collected_at text not null DEFAULT ''
+);
+CREATE INDEX {schema}{prefix}courier_pickup_parcel_pickup_idx ON {prefix}courier_pickup_parcel (pickup)`,
+	// 31: окна доставки, выбранные получателями
+	`CREATE TABLE {delivery_window}
+(
+    parcel integer not null primary key
+        references {prefix}parcel (number) on delete cascade,
+    slot VARCHAR(16) not null,
+    set_at text not null
+)`,
+	// 32: телефон получателя и подтверждение переадресации кодом по SMS;
+	// неподтверждённый запрос тоже считается открытым
+	`ALTER TABLE {parcel} ADD COLUMN recipient_phone VARCHAR(16) not null DEFAULT '';
+ALTER TABLE {address_redirect} ADD COLUMN otp_hash VARCHAR(64) not null DEFAULT '';
+ALTER TABLE {address_redirect} ADD COLUMN otp_expires_at text not null DEFAULT '';
+ALTER TABLE {address_redirect} ADD COLUMN otp_attempts integer not null DEFAULT 0;
+DROP INDEX {schema}{prefix}address_redirect_pending_uq;
+CREATE UNIQUE INDEX {schema}{prefix}address_redirect_open_uq ON {prefix}address_redirect (parcel) WHERE status IN ('unverified', 'pending')`,
+	// 33: заявленный вес посылки в граммах и опись вложения
+	`ALTER TABLE {parcel} ADD COLUMN weight integer not null DEFAULT 0;
+CREATE TABLE {parcel_item}
+(
+    id integer not null primary key autoincrement,
+    parcel integer not null
+        references {prefix}parcel (number) on delete cascade,
+    name VARCHAR(256) not null,
+    quantity integer not null,
+    unit_weight integer not null
+);
+CREATE INDEX {schema}{prefix}parcel_item_parcel_idx ON {prefix}parcel_item (parcel)`,
+	// 34: категории вложения посылки и запреты их пересылки по зонам доставки
+	`ALTER TABLE {parcel} ADD COLUMN contents VARCHAR(64) not null DEFAULT '';
+CREATE TABLE {content_rule}
+(
+    id integer not null primary key autoincrement,
+    zone VARCHAR(64) not null,
+    category VARCHAR(16) not null
+);
+CREATE UNIQUE INDEX {schema}{prefix}content_rule_uq ON {prefix}content_rule (zone, category)`,
+	// 35: ежемесячные счета клиентам и их строки
+	`CREATE TABLE {invoice}
+(
+    id integer not null primary key autoincrement,
+    client integer not null,
+    period_from text not null,
+    period_to text not null,
+    total integer not null,
+    created_at text not null,
+    paid_at text not null DEFAULT ''
+);
+CREATE UNIQUE INDEX {schema}{prefix}invoice_period_uq ON {prefix}invoice (client, period_from);
+CREATE TABLE {invoice_line}
+(
+    id integer not null primary key autoincrement,
+    invoice integer not null
+        references {prefix}invoice (id) on delete cascade,
+    parcel integer not null,
+    kind VARCHAR(16) not null,
+    amount integer not null
+);
+CREATE INDEX {schema}{prefix}invoice_line_invoice_idx ON {prefix}invoice_line (invoice)`,
+	// 36: промокоды, скидки за объём и скидка посылки
+	`ALTER TABLE {parcel} ADD COLUMN promo_code VARCHAR(32) not null DEFAULT '';
+ALTER TABLE {parcel} ADD COLUMN discount integer not null DEFAULT 0;
+CREATE TABLE {promo_code}
+(
+    code VARCHAR(32) not null primary key,
+    percent integer not null,
+    amount integer not null,
+    valid_until text not null,
+    max_uses integer not null,
+    uses integer not null DEFAULT 0
+);
+CREATE TABLE {volume_discount}
+(
+    id integer not null primary key autoincrement,
+    min_parcels integer not null,
+    percent integer not null
+)`,
+	// 37: валюта сумм посылки; счета и фиксированные промокоды — в одной валюте
+	`ALTER TABLE {parcel} ADD COLUMN currency VARCHAR(3) not null DEFAULT 'RUB';
+ALTER TABLE {invoice} ADD COLUMN currency VARCHAR(3) not null DEFAULT 'RUB';
+DROP INDEX {schema}{prefix}invoice_period_uq;
+CREATE UNIQUE INDEX {schema}{prefix}invoice_period_uq ON {prefix}invoice (client, period_from, currency);
+ALTER TABLE {promo_code} ADD COLUMN currency VARCHAR(3) not null DEFAULT 'RUB'`,
+	// 38: партнёры, перепродающие трекер, и их месячные счётчики регистраций
+	`CREATE TABLE {partner}
+(
+    id VARCHAR(64) not null primary key,
+    name VARCHAR(256) not null,
+    email VARCHAR(256) not null,
+    monthly_quota integer not null,
+    api_key_hash VARCHAR(64) not null,
+    created_at text not null
+);
+CREATE UNIQUE INDEX {schema}{prefix}partner_api_key_uq ON {prefix}partner (api_key_hash);
+CREATE TABLE {partner_usage}
+(
+    partner VARCHAR(64) not null
+        references {prefix}partner (id) on delete cascade,
+    month VARCHAR(7) not null,
+    registrations integer not null,
+    primary key (partner, month)
+)`,
+	// 39: ключ API песочницы партнёра
+	`ALTER TABLE {partner} ADD COLUMN sandbox_key_hash VARCHAR(64) not null DEFAULT '';
+CREATE INDEX {schema}{prefix}partner_sandbox_key_idx ON {prefix}partner (sandbox_key_hash)`,
+	// 40: номера посылок, зарезервированные для печати этикеток заранее
+	`CREATE TABLE {number_reservation}
+(
+    number      integer not null
+        constraint number_reservation_pk
+            primary key,
+    reserved_at text    not null,
+    claimed_at  text    not null DEFAULT ''
+)`,
+	// 41: журнал изменений посылок для синхронизации приложения курьера.
+	// Его ведут триггеры, как и updated_at; courier — курьер, за которым
+	// посылка числилась в момент изменения, 0 — не назначена. Смена
+	// курьера — удаление у прежнего и создание у нового.
+	`CREATE TABLE {parcel_change}
+(
+    seq        integer
+        constraint parcel_change_pk
+            primary key autoincrement,
+    parcel     integer     not null,
+    uuid       VARCHAR(36) not null,
+    courier    integer     not null DEFAULT 0,
+    kind       VARCHAR(16) not null,
+    changed_at text        not null
+);
+CREATE INDEX {schema}{prefix}parcel_change_courier_idx ON {prefix}parcel_change (courier, seq);
+CREATE TRIGGER {schema}{prefix}parcel_change_insert AFTER INSERT ON {prefix}parcel FOR EACH ROW
+BEGIN
+    INSERT INTO {prefix}parcel_change (parcel, uuid, kind, changed_at)
+    VALUES (NEW.number, NEW.uuid, 'create', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+CREATE TRIGGER {schema}{prefix}parcel_change_update AFTER UPDATE ON {prefix}parcel FOR EACH ROW
+    WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    INSERT INTO {prefix}parcel_change (parcel, uuid, courier, kind, changed_at)
+    VALUES (NEW.number, NEW.uuid, COALESCE((SELECT courier FROM {prefix}delivery_assignment WHERE parcel = NEW.number), 0),
+            'update', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+CREATE TRIGGER {schema}{prefix}parcel_change_delete BEFORE DELETE ON {prefix}parcel FOR EACH ROW
+BEGIN
+    INSERT INTO {prefix}parcel_change (parcel, uuid, courier, kind, changed_at)
+    VALUES (OLD.number, OLD.uuid, COALESCE((SELECT courier FROM {prefix}delivery_assignment WHERE parcel = OLD.number), 0),
+            'delete', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END;
+CREATE TRIGGER {schema}{prefix}parcel_change_assign AFTER INSERT ON {prefix}delivery_assignment FOR EACH ROW
+BEGIN
+    INSERT INTO {prefix}parcel_change (parcel, uuid, courier, kind, changed_at)
+    SELECT number, uuid, NEW.courier, 'create', strftime('%Y-%m-%dT%H:%M:%fZ', 'now') FROM {prefix}parcel WHERE number = NEW.parcel;
+END;
+CREATE TRIGGER {schema}{prefix}parcel_change_reassign AFTER UPDATE ON {prefix}delivery_assignment FOR EACH ROW
+BEGIN
+    INSERT INTO {prefix}parcel_change (parcel, uuid, courier, kind, changed_at)
+    SELECT number, uuid, OLD.courier, 'delete', strftime('%Y-%m-%dT%H:%M:%fZ', 'now') FROM {prefix}parcel
+    WHERE number = OLD.parcel AND NEW.courier <> OLD.courier;
+    INSERT INTO {prefix}parcel_change (parcel, uuid, courier, kind, changed_at)
+    SELECT number, uuid, NEW.courier, CASE WHEN NEW.courier <> OLD.courier THEN 'create' ELSE 'update' END,
+           strftime('%Y-%m-%dT%H:%M:%fZ', 'now') FROM {prefix}parcel WHERE number = NEW.parcel;
+END`,
+	// 42: очередь ручного разбора конфликтующих операций приложения курьера;
+	// resolution пусто, пока операцию не разобрали
+	`CREATE TABLE {operation_review}
+(
+    id           integer
+        constraint operation_review_pk
+            primary key autoincrement,
+    operation_id VARCHAR(128) not null,
+    kind         VARCHAR(16)  not null,
+    code         VARCHAR(36)  not null,
+    courier      integer      not null,
+    at           text         not null,
+    parcel       integer      not null
+        references {prefix}parcel (number) on delete cascade,
+    status       VARCHAR(32)  not null,
+    reason       text         not null,
+    created_at   text         not null,
+    resolved_at  text         not null DEFAULT '',
+    resolution   VARCHAR(16)  not null DEFAULT ''
+);
+CREATE INDEX {schema}{prefix}operation_review_open_idx ON {prefix}operation_review (resolution, id)`,
+	// 43: если изменение пришлось на ту же миллисекунду, что и прошлое,
+	// триггер updated_at не меняет значение и журнал получал второе
+	// изменение от его UPDATE. 'now' одинаково на весь запрос, поэтому
+	// такое изменение совпадает с последней записью журнала и пропускается.
+	`DROP TRIGGER {schema}{prefix}parcel_change_update;
+CREATE TRIGGER {schema}{prefix}parcel_change_update AFTER UPDATE ON {prefix}parcel FOR EACH ROW
+    WHEN NEW.updated_at = OLD.updated_at AND NOT EXISTS (
+        SELECT 1 FROM {prefix}parcel_change
+        WHERE seq = (SELECT MAX(seq) FROM {prefix}parcel_change) AND parcel = NEW.number
+          AND kind = 'update' AND changed_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+BEGIN
+    INSERT INTO {prefix}parcel_change (parcel, uuid, courier, kind, changed_at)
+    VALUES (NEW.number, NEW.uuid, COALESCE((SELECT courier FROM {prefix}delivery_assignment WHERE parcel = NEW.number), 0),
+            'update', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END`,
+	// 44: изменения полей посылки в записи журнала; пусто — запись сделана
+	// до миграции, изменения вычисляются при чтении
+	`ALTER TABLE {audit_log} ADD COLUMN changes text not null DEFAULT ''`,
+	// 45: смены адреса посылок до отправки для AddressChangePolicy
+	`CREATE TABLE {address_change}
+(
+    id integer not null primary key autoincrement,
+    parcel integer not null
+        references {prefix}parcel (number) on delete cascade,
+    address text not null,
+    changed_at text not null
+);
+CREATE INDEX {schema}{prefix}address_change_parcel_idx ON {prefix}address_change (parcel, changed_at)`,
+	// 46: типы транспорта с вместимостью и планы загрузки маршрутов;
+	// load_no 0 — посылка не поместилась ни в один рейс
+	`CREATE TABLE {vehicle}
+(
+    id integer not null primary key autoincrement,
+    name VARCHAR(128) not null,
+    max_weight integer not null DEFAULT 0,
+    max_parcels integer not null DEFAULT 0
+);
+CREATE TABLE {load_plan}
+(
+    id integer not null primary key autoincrement,
+    courier integer not null,
+    day VARCHAR(10) not null,
+    created_at text not null
+);
+CREATE INDEX {schema}{prefix}load_plan_courier_idx ON {prefix}load_plan (courier, day);
+CREATE TABLE {load_plan_parcel}
+(
+    plan integer not null
+        references {prefix}load_plan (id) on delete cascade,
+    load_no integer not null,
+    vehicle integer not null,
+    parcel integer not null
+        references {prefix}parcel (number) on delete cascade,
+    primary key (plan, parcel)
+)`,
+	// 47: охлаждаемые посылки и показания температуры холодовой цепи
+	`ALTER TABLE {parcel} ADD COLUMN requires_refrigeration integer not null DEFAULT 0;
+CREATE TABLE {temperature_reading}
+(
+    id integer not null primary key autoincrement,
+    parcel integer not null
+        references {prefix}parcel (number) on delete cascade,
+    celsius real not null,
+    recorded_at text not null,
+    breach integer not null DEFAULT 0
+);
+CREATE INDEX {schema}{prefix}temperature_reading_parcel_idx ON {prefix}temperature_reading (parcel, recorded_at)`,
+	// 48: подтверждения доставки с подписью получателя, сама подпись — вложение
+	`CREATE TABLE {delivery_confirmation}
+(
+    parcel integer not null primary key
+        references {prefix}parcel (number) on delete cascade,
+    signer_name VARCHAR(128) not null,
+    signature integer not null
+        references {prefix}attachment (id) on delete cascade,
+    confirmed_at text not null
+)`,
+	// 49: посылки с проверкой возраста получателя и отметка о проверке документа
+	`ALTER TABLE {parcel} ADD COLUMN age_verification integer not null DEFAULT 0;
+ALTER TABLE {delivery_confirmation} ADD COLUMN id_checked integer not null DEFAULT 0`,
+	// 50: указания получателей по доставке: соседу или в безопасное место
+	`CREATE TABLE {delivery_instructions}
+(
+    parcel integer not null primary key
+        references {prefix}parcel (number) on delete cascade,
+    kind VARCHAR(16) not null,
+    note VARCHAR(256) not null,
+    set_at text not null
+)`,
+	// 51: подписки email на смены статуса посылки; notified_status —
+	// статус, о котором подписчику уже сообщили
+	`CREATE TABLE {tracking_subscription}
+(
+    id integer not null primary key autoincrement,
+    parcel integer not null
+        references {prefix}parcel (number) on delete cascade,
+    email VARCHAR(254) not null,
+    locale VARCHAR(8) not null,
+    token VARCHAR(48) not null,
+    notified_status VARCHAR(32) not null,
+    created_at text not null
+);
+CREATE UNIQUE INDEX {schema}{prefix}tracking_subscription_email_uq ON {prefix}tracking_subscription (parcel, email)`,
+	// 52: адреса уведомлений партнёров и события для них; cursor — последнее
+	// изменение {parcel_change}, по которому собраны события адреса
+	`CREATE TABLE {webhook_endpoint}
+(
+    id integer not null primary key autoincrement,
+    partner VARCHAR(64) not null
+        references {prefix}partner (id) on delete cascade,
+    url text not null,
+    secret VARCHAR(48) not null,
+    cursor integer not null,
+    created_at text not null
+);
+CREATE TABLE {webhook_event}
+(
+    id integer not null primary key autoincrement,
+    endpoint integer not null
+        references {prefix}webhook_endpoint (id) on delete cascade,
+    type VARCHAR(32) not null,
+    data text not null,
+    created_at text not null,
+    attempts integer not null DEFAULT 0,
+    delivered_at text not null DEFAULT '',
+    last_error text not null DEFAULT ''
+);
+CREATE INDEX {schema}{prefix}webhook_event_pending_idx ON {prefix}webhook_event (delivered_at, id)`,
+	// 53: версия схемы тела уведомлений адреса; прежние адреса остаются на v1
+	`ALTER TABLE {webhook_endpoint} ADD COLUMN version integer not null DEFAULT 1`,
+	// 54: одновременные отправки адресу уведомлений
+	`ALTER TABLE {webhook_endpoint} ADD COLUMN concurrency integer not null DEFAULT 1`,
+	// 55: ограничение уведомлений в секунду адресу, 0 — без ограничения
+	`ALTER TABLE {webhook_endpoint} ADD COLUMN rate_limit real not null DEFAULT 0`,
+	// 56: проекция публичного отслеживания и позиции проекций в журналах;
+	// notes — открытые клиенту заметки в JSON
+	`CREATE TABLE {tracking_view}
+(
+    parcel integer not null primary key
+        references {prefix}parcel (number) on delete cascade,
+    uuid VARCHAR(36) not null,
+    status VARCHAR(32) not null,
+    country VARCHAR(2) not null DEFAULT '',
+    city text not null DEFAULT '',
+    created_at text not null,
+    sent_at text not null DEFAULT '',
+    delivered_at text not null DEFAULT '',
+    eta text not null DEFAULT '',
+    courier integer not null DEFAULT 0,
+    notes text not null DEFAULT '[]',
+    updated_at text not null DEFAULT ''
+);
+CREATE UNIQUE INDEX {schema}{prefix}tracking_view_uuid_uq ON {prefix}tracking_view (uuid);
+CREATE TABLE {projection_cursor}
+(
+    name VARCHAR(32) not null primary key,
+    position integer not null
+)`,
+	// 57: сводка по суткам; delivery_seconds — сумма времени доставки
+	// посылок, доставленных в эти сутки, чтобы среднее за период считалось
+	// по суммам. Индексы по временам событий нужны для пересчёта суток.
+	`CREATE TABLE {daily_stats}
+(
+    day VARCHAR(10) not null primary key,
+    registered integer not null,
+    sent integer not null,
+    delivered integer not null,
+    delivery_seconds real not null,
+    computed_at text not null
+);
+CREATE INDEX {schema}{prefix}parcel_created_idx ON {prefix}parcel (created_at);
+CREATE INDEX {schema}{prefix}parcel_sent_idx ON {prefix}parcel (sent_at);
+CREATE INDEX {schema}{prefix}parcel_delivered_idx ON {prefix}parcel (delivered_at)`,
+	// 58: индексы, найденные проверкой планов запросов: посылки клиента и
+	// ссылки на посылку, по которым удаление посылки ищет каскадные строки
+	`CREATE INDEX {schema}{prefix}parcel_client_idx ON {prefix}parcel (client);
+CREATE INDEX {schema}{prefix}operation_review_parcel_idx ON {prefix}operation_review (parcel);
+CREATE INDEX {schema}{prefix}address_redirect_parcel_idx ON {prefix}address_redirect (parcel);
+CREATE INDEX {schema}{prefix}load_plan_parcel_parcel_idx ON {prefix}load_plan_parcel (parcel)`,
+	// 59: взвешивания посылок на складе; declared — заявленный вес на
+	// момент взвешивания, price_before и price_after — стоимость доставки
+	// до и после пересчёта по весу
+	`CREATE TABLE {weight_measurement}
+(
+    id integer not null primary key autoincrement,
+    parcel integer not null
+        references {prefix}parcel (number) on delete cascade,
+    declared integer not null,
+    measured integer not null,
+    operator VARCHAR(128) not null,
+    price_before integer not null,
+    price_after integer not null,
+    repriced integer not null DEFAULT 0,
+    measured_at text not null
+);
+CREATE INDEX {schema}{prefix}weight_measurement_parcel_idx ON {prefix}weight_measurement (parcel);
+CREATE INDEX {schema}{prefix}weight_measurement_measured_idx ON {prefix}weight_measurement (measured_at)`,
+	// 60: отправки — партии посылок, передаваемые перевозчику по одному
+	// манифесту
+	`CREATE TABLE {shipment}
+(
+    id integer not null primary key autoincrement,
+    carrier VARCHAR(64) not null,
+    created_at text not null
+);
+CREATE TABLE {shipment_parcel}
+(
+    shipment integer not null
+        references {prefix}shipment (id) on delete cascade,
+    parcel integer not null
+        references {prefix}parcel (number) on delete cascade,
+    primary key (shipment, parcel)
+);
+CREATE INDEX {schema}{prefix}shipment_parcel_parcel_idx ON {prefix}shipment_parcel (parcel)`,
+	// 61: вместо пропуска изменений журнала в ту же миллисекунду из 43,
+	// который терял второе настоящее изменение, триггер updated_at не
+	// обновляет посылку, если updated_at уже равно текущему времени. Тогда
+	// вложенный UPDATE не попадает в журнал, а каждое изменение попадает.
+	`DROP TRIGGER {schema}{prefix}parcel_update_touch;
+CREATE TRIGGER {schema}{prefix}parcel_update_touch AFTER UPDATE ON {prefix}parcel FOR EACH ROW
+    WHEN NEW.updated_at = OLD.updated_at AND OLD.updated_at <> strftime('%Y-%m-%dT%H:%M:%fZ', 'now')
+BEGIN
+    UPDATE {prefix}parcel SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE number = NEW.number;
+END;
+DROP TRIGGER {schema}{prefix}parcel_change_update;
+CREATE TRIGGER {schema}{prefix}parcel_change_update AFTER UPDATE ON {prefix}parcel FOR EACH ROW
+    WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    INSERT INTO {prefix}parcel_change (parcel, uuid, courier, kind, changed_at)
+    VALUES (NEW.number, NEW.uuid, COALESCE((SELECT courier FROM {prefix}delivery_assignment WHERE parcel = NEW.number), 0),
+            'update', strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));
+END`,
+}
+
+// OpenDB открывает БД SQLite по пути path. Настройки SQLite действуют
+// на отдельное соединение, поэтому задаются через параметры DSN:
+//   - внешние ключи включены;
+//   - WAL позволяет читать параллельно с записью;
+//   - при занятой БД запрос ждёт busy_timeout, а не сразу возвращает SQLITE_BUSY;
+//   - транзакции начинаются с BEGIN IMMEDIATE, иначе две транзакции, начавшие
+//     с чтения, не могут перейти к записи и одна из них получает SQLITE_BUSY
+//     без ожидания.
+//
+// Пул настраивается по DefaultSQLitePool, другие настройки задаются
+// PoolConfig.Apply.
+func OpenDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)"+
+		"&_pragma=busy_timeout(5000)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	DefaultSQLitePool.Apply(db)
+
+	return db, nil
+}
+
+// Migrate применяет к БД все ещё не применённые миграции. Опции задают
+// размещение таблиц так же, как у NewParcelStore.
+func Migrate(db *sql.DB, opts ...StoreOption) error {
+	return NewParcelStore(db, opts...).Migrate()
+}
+
+// Migrate применяет миграции к таблицам хранилища. Версия схемы хранится
+// в таблице {schema_version}, у каждого префикса своя.
+func (s ParcelStore) Migrate() error {
+	version, err := s.initSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if err := s.applyMigration(i+1, migrations[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// initSchemaVersion создаёт таблицу версии схемы, если её ещё нет,
+// и возвращает текущую версию
+func (s ParcelStore) initSchemaVersion() (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS {schema_version} (version integer not null)"); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err = tx.QueryRow("SELECT version FROM {schema_version}").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		// раньше версия хранилась в PRAGMA user_version; так размечены
+		// только таблицы без префикса
+		if s.naming.prefix == "" {
+			if err := tx.QueryRow("PRAGMA {schema}user_version").Scan(&version); err != nil {
+				return 0, err
+			}
+		}
+		_, err = tx.Exec("INSERT INTO {schema_version} (version) VALUES (:version)", sql.Named("version", version))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return version, tx.Commit()
+}
+
+// schemaVersion возвращает версию схемы таблиц хранилища
+func (s ParcelStore) schemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := s.db.QueryRowContext(ctx, "SELECT version FROM {schema_version}").Scan(&version)
+
+	return version, err
+}
+
+// applyMigration выполняет одну миграцию и обновляет версию схемы в одной транзакции
+func (s ParcelStore) applyMigration(version int, query string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(query); err != nil {
+		return fmt.Errorf("миграция %d: %w", version, err)
+	}
+
+	if _, err := tx.Exec("UPDATE {schema_version} SET version = :version", sql.Named("version", version)); err != nil {
+		return fmt.Errorf("миграция %d: %w", version, err)
+	}
+
+	return tx.Commit()
+}
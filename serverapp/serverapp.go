@@ -0,0 +1,199 @@
+// Package serverapp управляет жизненным циклом сервиса трекера:
+// запускает HTTP-серверы, ждёт SIGINT/SIGTERM и останавливает компоненты
+// в правильном порядке — сначала перестаём принимать запросы и дожидаемся
+// выполняющихся, затем останавливаем фоновые задачи, сбрасываем буферы
+// публикации событий и только после этого закрываем БД.
+package serverapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownTimeout — время на остановку всех компонентов по умолчанию
+const DefaultShutdownTimeout = 30 * time.Second
+
+// GracefulStopper — сервер, умеющий дождаться выполняющихся запросов
+// перед остановкой, например *grpc.Server
+type GracefulStopper interface {
+	GracefulStop()
+}
+
+// phase — этап остановки, этапы выполняются по возрастанию
+type phase int
+
+const (
+	phaseServers phase = iota
+	phaseJobs
+	phaseFlush
+	phaseClose
+	phaseCount
+)
+
+// hook — именованное действие этапа остановки
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// httpServer — HTTP-сервер и слушающий сокет, если он создан заранее
+type httpServer struct {
+	srv *http.Server
+	ln  net.Listener
+}
+
+// App — приложение с упорядоченной остановкой компонентов
+type App struct {
+	// ShutdownTimeout ограничивает суммарное время остановки
+	ShutdownTimeout time.Duration
+	// Logf выводит сообщения о ходе запуска и остановки
+	Logf func(format string, args ...any)
+
+	servers []httpServer
+	hooks   [phaseCount][]hook
+}
+
+// New создаёт приложение с таймаутом остановки по умолчанию
+func New() *App {
+	return &App{
+		ShutdownTimeout: DefaultShutdownTimeout,
+		Logf:            log.Printf,
+	}
+}
+
+// AddHTTPServer регистрирует HTTP-сервер. Если ln равен nil, сокет
+// открывается по srv.Addr при запуске.
+func (a *App) AddHTTPServer(srv *http.Server, ln net.Listener) {
+	a.servers = append(a.servers, httpServer{srv: srv, ln: ln})
+}
+
+// AddGRPCServer регистрирует остановку gRPC-сервера. Запускать его
+// вызывающий должен сам, здесь выполняется только GracefulStop.
+func (a *App) AddGRPCServer(name string, srv GracefulStopper) {
+	a.addHook(phaseServers, name, func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// OnStopJobs регистрирует остановку фоновых задач. Вызывается после того,
+// как серверы перестали принимать запросы.
+func (a *App) OnStopJobs(name string, fn func(ctx context.Context) error) {
+	a.addHook(phaseJobs, name, fn)
+}
+
+// OnFlush регистрирует сброс буферов, например публикацию накопленных
+// событий outbox. Вызывается после остановки фоновых задач.
+func (a *App) OnFlush(name string, fn func(ctx context.Context) error) {
+	a.addHook(phaseFlush, name, fn)
+}
+
+// OnClose регистрирует закрытие ресурса, например БД. Закрытие выполняется
+// последним, в порядке, обратном регистрации.
+func (a *App) OnClose(name string, c io.Closer) {
+	a.addHook(phaseClose, name, func(context.Context) error {
+		return c.Close()
+	})
+}
+
+func (a *App) addHook(p phase, name string, fn func(ctx context.Context) error) {
+	a.hooks[p] = append(a.hooks[p], hook{name: name, fn: fn})
+}
+
+// Run запускает HTTP-серверы и блокируется до SIGINT/SIGTERM, отмены ctx
+// или аварийного завершения одного из серверов, после чего останавливает
+// все компоненты. Возвращает ошибки запуска и остановки.
+func (a *App) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var runErr error
+	serveErrs := make(chan error, len(a.servers))
+	var wg sync.WaitGroup
+	for _, s := range a.servers {
+		ln := s.ln
+		if ln == nil {
+			var err error
+			ln, err = net.Listen("tcp", s.srv.Addr)
+			if err != nil {
+				runErr = fmt.Errorf("http %s: %w", s.srv.Addr, err)
+				break
+			}
+		}
+
+		a.Logf("HTTP-сервер слушает %s", ln.Addr())
+		wg.Add(1)
+		go func(srv *http.Server, ln net.Listener) {
+			defer wg.Done()
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serveErrs <- fmt.Errorf("http %s: %w", ln.Addr(), err)
+			}
+		}(s.srv, ln)
+	}
+
+	if runErr == nil {
+		select {
+		case <-ctx.Done():
+			a.Logf("получен сигнал остановки")
+		case runErr = <-serveErrs:
+			a.Logf("сервер завершился с ошибкой: %v", runErr)
+		}
+	}
+
+	err := a.shutdown()
+	wg.Wait()
+
+	return errors.Join(runErr, err)
+}
+
+// shutdown выполняет этапы остановки по порядку в пределах ShutdownTimeout.
+// Ошибка одного компонента не прерывает остановку остальных.
+func (a *App) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	for _, s := range a.servers {
+		if err := s.srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("http %s: %w", s.srv.Addr, err))
+		}
+	}
+
+	for p := phaseServers; p < phaseCount; p++ {
+		hooks := a.hooks[p]
+		for i := range hooks {
+			h := hooks[i]
+			if p == phaseClose {
+				// ресурсы закрываются в порядке, обратном открытию
+				h = hooks[len(hooks)-1-i]
+			}
+			if err := h.fn(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+			}
+		}
+	}
+
+	a.Logf("остановка завершена")
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,100 @@
+package serverapp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// closerFunc позволяет использовать функцию как io.Closer
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// recorder запоминает порядок вызовов при остановке
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) add(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, name)
+}
+
+// newTestApp возвращает приложение без вывода сообщений
+func newTestApp() *App {
+	app := New()
+	app.Logf = func(string, ...any) {}
+
+	return app
+}
+
+// TestShutdownOrder проверяет порядок этапов остановки и дожидание выполняющегося запроса
+func TestShutdownOrder(t *testing.T) {
+	// prepare
+	rec := &recorder{}
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		rec.add("request")
+		io.WriteString(w, "ok")
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	app := newTestApp()
+	app.AddHTTPServer(srv, ln)
+	app.OnStopJobs("jobs", func(context.Context) error { rec.add("jobs"); return nil })
+	app.OnFlush("outbox", func(context.Context) error { rec.add("outbox"); return errors.New("flush failed") })
+	app.OnClose("cache", closerFunc(func() error { rec.add("cache"); return nil }))
+	app.OnClose("db", closerFunc(func() error { rec.add("db"); return nil }))
+
+	// run
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- app.Run(ctx) }()
+
+	respErr := make(chan error)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+		respErr <- err
+	}()
+	<-started
+	cancel()
+
+	// check
+	err = <-done
+	require.ErrorContains(t, err, "outbox: flush failed")
+	require.NoError(t, <-respErr)
+	require.Equal(t, []string{"request", "jobs", "outbox", "db", "cache"}, rec.calls)
+}
+
+// TestRunListenError проверяет, что ошибка открытия сокета не мешает закрыть ресурсы
+func TestRunListenError(t *testing.T) {
+	// prepare
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	closed := false
+	app := newTestApp()
+	app.AddHTTPServer(&http.Server{Addr: ln.Addr().String()}, nil)
+	app.OnClose("db", closerFunc(func() error { closed = true; return nil }))
+
+	// check
+	require.Error(t, app.Run(context.Background()))
+	require.True(t, closed)
+}
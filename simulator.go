@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSimSpeedup — ускорение времени симуляции: час сценария за секунду
+	DefaultSimSpeedup = 3600
+	// simInterval — как часто симулятор применяет наступившие шаги
+	simInterval = time.Second
+)
+
+// SimStep — шаг сценария: переход в Status через After после предыдущего шага
+type SimStep struct {
+	Status string
+	After  time.Duration
+}
+
+// SimScenario — последовательность статусов посылки. Weight — относительная
+// частота сценария среди посылок симуляции.
+type SimScenario struct {
+	Name   string
+	Weight int
+	Steps  []SimStep
+}
+
+// DefaultSimScenarios — сценарии по умолчанию: большинство посылок
+// доставляется, часть теряется, повреждается, возвращается или отменяется
+var DefaultSimScenarios = []SimScenario{
+	{Name: "delivered", Weight: 90, Steps: []SimStep{
+		{ParcelStatusSent, 6 * time.Hour}, {ParcelStatusDelivered, 48 * time.Hour}}},
+	{Name: "lost", Weight: 3, Steps: []SimStep{
+		{ParcelStatusSent, 6 * time.Hour}, {ParcelStatusLost, 120 * time.Hour}}},
+	{Name: "damaged", Weight: 2, Steps: []SimStep{
+		{ParcelStatusSent, 6 * time.Hour}, {ParcelStatusDelivered, 48 * time.Hour}, {ParcelStatusDamaged, 2 * time.Hour}}},
+	{Name: "returned", Weight: 3, Steps: []SimStep{
+		{ParcelStatusSent, 6 * time.Hour}, {ParcelStatusReturned, 96 * time.Hour}}},
+	{Name: "cancelled", Weight: 2, Steps: []SimStep{
+		{ParcelStatusCancelled, 2 * time.Hour}}},
+}
+
+// simAddresses — адреса посылок симуляции
+var simAddresses = []string{
+	"Псков, ул. Пушкина, д. 5",
+	"Саратов, ул. Козлова, д. 25",
+	"Казань, ул. Баумана, д. 12",
+	"Новосибирск, Красный пр-т, д. 1",
+	"Екатеринбург, ул. Малышева, д. 30",
+}
+
+// Simulator продвигает посылки по сценариям статусов в ускоренном времени
+// для демонстрационных стендов и нагрузочных проверок уведомлений. Задержки
+// шагов делятся на Speedup, события получают реальное время перехода.
+// План симуляции хранится в памяти и после перезапуска не восстанавливается.
+// Безопасен для нескольких горутин.
+type Simulator struct {
+	store     ParcelStore
+	speedup   int
+	scenarios []SimScenario
+	// SenderEmail — адрес отправителя посылок Seed, непустой включает
+	// квитанции о регистрации и тем самым нагружает outbox
+	SenderEmail string
+
+	mu    sync.Mutex
+	rnd   *rand.Rand
+	plans map[int]*simPlan
+}
+
+// simPlan — оставшиеся шаги сценария посылки
+type simPlan struct {
+	status string
+	steps  []SimStep
+	due    time.Time
+}
+
+// NewSimulator возвращает симулятор посылок store со сценариями scenarios
+// и ускорением speedup; nil и 0 — значения по умолчанию. seed задаёт
+// генератор случайных чисел, чтобы прогон можно было повторить.
+func NewSimulator(store ParcelStore, scenarios []SimScenario, speedup int, seed int64) *Simulator {
+	if scenarios == nil {
+		scenarios = DefaultSimScenarios
+	}
+	if speedup <= 0 {
+		speedup = DefaultSimSpeedup
+	}
+
+	return &Simulator{
+		store:     store,
+		speedup:   speedup,
+		scenarios: scenarios,
+		rnd:       rand.New(rand.NewSource(seed)),
+		plans:     make(map[int]*simPlan),
+	}
+}
+
+// Seed регистрирует n посылок со случайными клиентами и адресами и ставит
+// их в симуляцию, отсчитывая шаги от now. Возвращает номера посылок.
+func (s *Simulator) Seed(n int, now time.Time) ([]int, error) {
+	numbers := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		s.mu.Lock()
+		p := Parcel{
+			Client:      1 + s.rnd.Intn(100),
+			Status:      ParcelStatusRegistered,
+			Address:     simAddresses[s.rnd.Intn(len(simAddresses))],
+			CreatedAt:   now.UTC(),
+			SenderEmail: s.SenderEmail,
+		}
+		s.mu.Unlock()
+
+		number, err := s.store.Add(p)
+		if err != nil {
+			return numbers, fmt.Errorf("симуляция: %w", err)
+		}
+		numbers = append(numbers, number)
+		if err := s.Track(number, now); err != nil {
+			return numbers, err
+		}
+	}
+
+	return numbers, nil
+}
+
+// Track ставит в симуляцию уже зарегистрированную посылку number
+// со случайно выбранным сценарием, шаги отсчитываются от now
+func (s *Simulator) Track(number int, now time.Time) error {
+	p, err := s.store.Get(number)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sc := s.pickScenario()
+	if len(sc.Steps) == 0 {
+		return nil
+	}
+	s.plans[number] = &simPlan{status: p.Status, steps: sc.Steps, due: now.Add(s.scale(sc.Steps[0].After))}
+
+	return nil
+}
+
+// pickScenario выбирает сценарий с учётом весов; вызывается под s.mu
+func (s *Simulator) pickScenario() SimScenario {
+	total := 0
+	for _, sc := range s.scenarios {
+		total += sc.Weight
+	}
+	if total <= 0 {
+		return SimScenario{}
+	}
+
+	n := s.rnd.Intn(total)
+	for _, sc := range s.scenarios {
+		if n < sc.Weight {
+			return sc
+		}
+		n -= sc.Weight
+	}
+
+	return SimScenario{}
+}
+
+// scale переводит задержку сценария в реальное время
+func (s *Simulator) scale(d time.Duration) time.Duration {
+	return d / time.Duration(s.speedup)
+}
+
+// Pending возвращает число посылок, сценарий которых ещё не закончен
+func (s *Simulator) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.plans)
+}
+
+// Step применяет все шаги, наступившие к now, и возвращает их число.
+// Посылки, статус которых за это время изменили не симулятором или
+// которые удалены, выходят из симуляции.
+func (s *Simulator) Step(now time.Time) (int, error) {
+	s.mu.Lock()
+	var due []int
+	for number, plan := range s.plans {
+		if !plan.due.After(now) {
+			due = append(due, number)
+		}
+	}
+	s.mu.Unlock()
+	sort.Ints(due)
+
+	applied := 0
+	for _, number := range due {
+		for {
+			s.mu.Lock()
+			plan := s.plans[number]
+			s.mu.Unlock()
+			if plan == nil || plan.due.After(now) {
+				break
+			}
+
+			step := plan.steps[0]
+			err := s.store.transitionStatusAt(number, plan.status, step.Status, now)
+
+			s.mu.Lock()
+			switch {
+			case errors.Is(err, ErrStatusChanged), errors.Is(err, sql.ErrNoRows):
+				delete(s.plans, number)
+				err = nil
+			case errors.Is(err, ErrInvalidTransition):
+				// граф арендатора не допускает сценарий, повторять бесполезно
+				delete(s.plans, number)
+			case err == nil:
+				applied++
+				plan.status, plan.steps = step.Status, plan.steps[1:]
+				if len(plan.steps) == 0 {
+					delete(s.plans, number)
+				} else {
+					plan.due = plan.due.Add(s.scale(plan.steps[0].After))
+				}
+			}
+			s.mu.Unlock()
+			if err != nil {
+				return applied, fmt.Errorf("симуляция посылки № %d: %w", number, err)
+			}
+		}
+	}
+
+	return applied, nil
+}
+
+// Run применяет наступившие шаги каждые interval, пока не отменён ctx.
+// Ошибки записываются в errors.
+func (s *Simulator) Run(ctx context.Context, interval time.Duration, errors *ErrorLog) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.Step(s.store.now()); err != nil && ctx.Err() == nil {
+			errors.Record(err)
+		}
+	}
+}
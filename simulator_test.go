@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestSimulator проверяет продвижение посылок по сценарию в ускоренном времени
+func TestSimulator(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	scenarios := []SimScenario{{Name: "delivered", Weight: 1, Steps: []SimStep{
+		{ParcelStatusSent, time.Hour}, {ParcelStatusDelivered, 2 * time.Hour},
+	}}}
+	// час сценария за минуту
+	sim := NewSimulator(store, scenarios, 60, 1)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	numbers, err := sim.Seed(3, now)
+	require.NoError(t, err)
+	require.Len(t, numbers, 3)
+	require.Equal(t, 3, sim.Pending())
+
+	// статус, изменённый не симулятором, выводит посылку из симуляции
+	require.NoError(t, store.TransitionStatus(numbers[2], ParcelStatusRegistered, ParcelStatusCancelled))
+
+	// check
+	applied, err := sim.Step(now.Add(30 * time.Second))
+	require.NoError(t, err)
+	require.Zero(t, applied)
+
+	applied, err = sim.Step(now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 2, applied)
+	require.Equal(t, 2, sim.Pending())
+
+	// пропущенные шаги применяются за один проход
+	applied, err = sim.Step(now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 2, applied)
+	require.Zero(t, sim.Pending())
+
+	for _, number := range numbers[:2] {
+		p, err := store.Get(number)
+		require.NoError(t, err)
+		require.Equal(t, ParcelStatusDelivered, p.Status)
+		require.Equal(t, now.Add(time.Minute), p.SentAt)
+		require.Equal(t, now.Add(time.Hour), p.DeliveredAt)
+	}
+}
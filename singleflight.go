@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup объединяет одинаковые параллельные вызовы: пока вызов с
+// ключом выполняется, остальные вызовы с тем же ключом ждут его результат
+// вместо повторного обращения к БД. Нулевое значение готово к работе.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall — выполняющийся или завершённый вызов
+type flightCall struct {
+	done chan struct{}
+	val  any
+	err  error
+}
+
+// do выполняет fn для key или ждёт уже начатый вызов с тем же key.
+// fn получает ctx без отмены: отказ одного ожидающего не должен ломать
+// чтение остальным, а каждый ожидающий сам перестаёт ждать при отмене
+// своего ctx. shared сообщает, что результат получен чужим вызовом.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (any, error)) (val any, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	c, ok := g.calls[key]
+	if !ok {
+		c = &flightCall{done: make(chan struct{})}
+		g.calls[key] = c
+		go g.run(context.WithoutCancel(ctx), key, c, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, ok, c.err
+	case <-ctx.Done():
+		return nil, ok, ctx.Err()
+	}
+}
+
+// run выполняет вызов и убирает его из группы, чтобы следующий
+// вызов с тем же ключом снова обратился к БД
+func (g *flightGroup) run(ctx context.Context, key string, c *flightCall, fn func(ctx context.Context) (any, error)) {
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+
+	c.val, c.err = fn(ctx)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ServiceLevelStandard  = "standard"
+	ServiceLevelExpress   = "express"
+	ServiceLevelOvernight = "overnight"
+)
+
+// ExpressRegisteredLimit — сколько экспресс-посылка может находиться
+// в статусе registered, прежде чем это считается нарушением
+const ExpressRegisteredLimit = 4 * time.Hour
+
+// serviceLevelSLA — нормативный срок доставки для каждого уровня обслуживания,
+// отсчитывается от момента регистрации посылки
+var serviceLevelSLA = map[string]time.Duration{
+	ServiceLevelStandard:  5 * 24 * time.Hour,
+	ServiceLevelExpress:   2 * 24 * time.Hour,
+	ServiceLevelOvernight: 24 * time.Hour,
+}
+
+var (
+	ErrUnknownServiceLevel      = errors.New("неизвестный уровень обслуживания")
+	ErrSLABreached              = errors.New("нарушен срок доставки")
+	ErrExpressRegisteredTooLong = errors.New("экспресс-посылка слишком долго не отправлена")
+)
+
+// SLA возвращает нормативный срок доставки для уровня обслуживания
+func SLA(level string) (time.Duration, error) {
+	sla, ok := serviceLevelSLA[level]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownServiceLevel, level)
+	}
+
+	return sla, nil
+}
+
+// CheckSLA проверяет, что на момент now посылка укладывается в нормативы
+// своего уровня обслуживания. Доставленные посылки нормативы не нарушают.
+func (p Parcel) CheckSLA(now time.Time) error {
+	if p.Status == ParcelStatusDelivered {
+		return nil
+	}
+
+	sla, err := SLA(p.ServiceLevel)
+	if err != nil {
+		return err
+	}
+
+	age := now.Sub(p.CreatedAt)
+	if p.ServiceLevel == ServiceLevelExpress && p.Status == ParcelStatusRegistered && age > ExpressRegisteredLimit {
+		return fmt.Errorf("посылка № %d: %w", p.Number, ErrExpressRegisteredTooLong)
+	}
+	if age > sla {
+		return fmt.Errorf("посылка № %d: %w", p.Number, ErrSLABreached)
+	}
+
+	return nil
+}
+
+// ListBreached возвращает недоставленные посылки заданного уровня обслуживания,
+// нарушившие норматив: общий срок доставки, а для экспресс-посылок
+// ещё и допустимое время в статусе registered
+func (s ParcelStore) ListBreached(level string) ([]Parcel, error) {
+	sla, err := SLA(level)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now().UTC()
+	deadline := now.Add(-sla)
+	registeredDeadline := deadline
+	if level == ServiceLevelExpress {
+		registeredDeadline = now.Add(-ExpressRegisteredLimit)
+	}
+
+	rows, err := s.db.Query(parcelSelect+"WHERE service_level = :level AND status != :delivered "+
+		"AND (created_at < :deadline OR (status = :registered AND created_at < :registered_deadline))",
+		sql.Named("level", level),
+		sql.Named("delivered", ParcelStatusDelivered),
+		sql.Named("registered", ParcelStatusRegistered),
+		sql.Named("deadline", formatTime(deadline)),
+		sql.Named("registered_deadline", formatTime(registeredDeadline)))
+	if err != nil {
+		return nil, err
+	}
+	return scanParcels(rows)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestAddUnknownServiceLevel проверяет, что посылку с неизвестным уровнем обслуживания нельзя добавить
+func TestAddUnknownServiceLevel(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	parcel.ServiceLevel = "teleport"
+
+	// add
+	_, err := store.Add(parcel)
+	require.ErrorIs(t, err, ErrUnknownServiceLevel)
+}
+
+// TestCheckSLA проверяет нормативы уровней обслуживания
+func TestCheckSLA(t *testing.T) {
+	now := time.Now().UTC()
+	parcel := getTestParcel()
+	parcel.ServiceLevel = ServiceLevelExpress
+
+	// свежая экспресс-посылка укладывается в норматив
+	parcel.CreatedAt = now.Add(-time.Hour)
+	require.NoError(t, parcel.CheckSLA(now))
+
+	// экспресс-посылка слишком долго в статусе registered
+	parcel.CreatedAt = now.Add(-ExpressRegisteredLimit - time.Hour)
+	require.ErrorIs(t, parcel.CheckSLA(now), ErrExpressRegisteredTooLong)
+
+	// отправленная посылка нарушает только общий срок
+	parcel.Status = ParcelStatusSent
+	require.NoError(t, parcel.CheckSLA(now))
+	parcel.CreatedAt = now.Add(-serviceLevelSLA[ServiceLevelExpress] - time.Hour)
+	require.ErrorIs(t, parcel.CheckSLA(now), ErrSLABreached)
+
+	// доставленная посылка норматив не нарушает
+	parcel.Status = ParcelStatusDelivered
+	require.NoError(t, parcel.CheckSLA(now))
+}
+
+// TestListBreached проверяет выборку посылок, нарушивших норматив
+func TestListBreached(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	now := time.Now().UTC()
+
+	fresh := getTestParcel()
+	fresh.ServiceLevel = ServiceLevelExpress
+
+	stuck := getTestParcel()
+	stuck.ServiceLevel = ServiceLevelExpress
+	stuck.CreatedAt = now.Add(-ExpressRegisteredLimit - time.Hour)
+
+	late := getTestParcel()
+	late.ServiceLevel = ServiceLevelStandard
+	late.Status = ParcelStatusSent
+	late.CreatedAt = now.Add(-serviceLevelSLA[ServiceLevelStandard] - time.Hour)
+
+	delivered := late
+	delivered.UUID = ""
+	delivered.Status = ParcelStatusDelivered
+
+	// add
+	ids := map[string]int{}
+	for name, p := range map[string]Parcel{"fresh": fresh, "stuck": stuck, "late": late, "delivered": delivered} {
+		id, err := store.Add(p)
+		require.NoError(t, err)
+		ids[name] = id
+	}
+
+	// check
+	breached, err := store.ListBreached(ServiceLevelExpress)
+	require.NoError(t, err)
+	require.Len(t, breached, 1)
+	require.Equal(t, ids["stuck"], breached[0].Number)
+
+	breached, err = store.ListBreached(ServiceLevelStandard)
+	require.NoError(t, err)
+	require.Len(t, breached, 1)
+	require.Equal(t, ids["late"], breached[0].Number)
+
+	_, err = store.ListBreached("teleport")
+	require.ErrorIs(t, err, ErrUnknownServiceLevel)
+}
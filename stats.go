@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+// Stats — сводка по количеству посылок в разрезе статусов
+type Stats struct {
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"by_status"`
+}
+
+// DailyVolume — количество посылок, зарегистрированных за сутки
+type DailyVolume struct {
+	Day        string `json:"day"`
+	Registered int    `json:"registered"`
+}
+
+// Stats возвращает количество посылок в разрезе статусов
+func (s ParcelStore) Stats() (Stats, error) {
+	rows, err := s.db.Query("SELECT status, COUNT(*) FROM {parcel} GROUP BY status")
+	if err != nil {
+		return Stats{}, err
+	}
+	defer rows.Close()
+
+	res := Stats{ByStatus: map[string]int{}}
+	for rows.Next() {
+		var status string
+		var count int
+		err := rows.Scan(&status, &count)
+		if err != nil {
+			return Stats{}, err
+		}
+		res.ByStatus[status] = count
+		res.Total += count
+	}
+
+	if err := rows.Err(); err != nil {
+		return Stats{}, err
+	}
+
+	return res, nil
+}
+
+// DailyVolumes возвращает количество зарегистрированных посылок по суткам (UTC)
+// за последние days дней, включая текущие. Дни без посылок не возвращаются.
+func (s ParcelStore) DailyVolumes(days int) ([]DailyVolume, error) {
+	from := s.now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+
+	rows, err := s.db.Query("SELECT substr(created_at, 1, 10) AS day, COUNT(*) FROM {parcel} "+
+		"WHERE created_at >= :from GROUP BY day ORDER BY day",
+		sql.Named("from", formatTime(from)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []DailyVolume
+	for rows.Next() {
+		v := DailyVolume{}
+		err := rows.Scan(&v.Day, &v.Registered)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ListOverdue возвращает посылки всех уровней обслуживания, нарушившие норматив,
+// упорядоченные по номеру
+func (s ParcelStore) ListOverdue() ([]Parcel, error) {
+	var res []Parcel
+	for level := range serviceLevelSLA {
+		breached, err := s.ListBreached(level)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, breached...)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Number < res[j].Number })
+
+	return res, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStats проверяет подсчёт посылок по статусам
+func TestStats(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+
+	// add
+	for _, status := range []string{ParcelStatusRegistered, ParcelStatusRegistered, ParcelStatusSent} {
+		parcel := getTestParcel()
+		parcel.Status = status
+		_, err := store.Add(parcel)
+		require.NoError(t, err)
+	}
+
+	// check
+	stats, err := store.Stats()
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Total)
+	require.Equal(t, map[string]int{ParcelStatusRegistered: 2, ParcelStatusSent: 1}, stats.ByStatus)
+}
+
+// TestDailyVolumes проверяет подсчёт регистраций по суткам
+func TestDailyVolumes(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	now := time.Now().UTC()
+
+	// add
+	for _, createdAt := range []time.Time{now, now, now.AddDate(0, 0, -1), now.AddDate(0, 0, -10)} {
+		parcel := getTestParcel()
+		parcel.CreatedAt = createdAt
+		_, err := store.Add(parcel)
+		require.NoError(t, err)
+	}
+
+	// check
+	volumes, err := store.DailyVolumes(2)
+	require.NoError(t, err)
+	require.Equal(t, []DailyVolume{
+		{Day: now.AddDate(0, 0, -1).Format(time.DateOnly), Registered: 1},
+		{Day: now.Format(time.DateOnly), Registered: 2},
+	}, volumes)
+}
+
+// TestListOverdue проверяет выборку просроченных посылок всех уровней
+func TestListOverdue(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	old := time.Now().UTC().Add(-30 * 24 * time.Hour)
+
+	// add
+	var ids []int
+	for _, level := range []string{ServiceLevelStandard, ServiceLevelOvernight} {
+		parcel := getTestParcel()
+		parcel.ServiceLevel = level
+		parcel.CreatedAt = old
+		id, err := store.Add(parcel)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	_, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// check
+	overdue, err := store.ListOverdue()
+	require.NoError(t, err)
+	require.Len(t, overdue, 2)
+	require.Equal(t, ids[0], overdue[0].Number)
+	require.Equal(t, ids[1], overdue[1].Number)
+}
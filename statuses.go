@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	ErrInvalidStatus     = errors.New("некорректный статус арендатора")
+	ErrInvalidTransition = errors.New("недопустимый переход статуса")
+)
+
+// statusCodeRe — допустимый код собственного статуса арендатора
+var statusCodeRe = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// TenantStatus — собственный промежуточный статус арендатора, например at_customs
+type TenantStatus struct {
+	Tenant string
+	Code   string
+	// Name — отображаемое название статуса
+	Name string
+}
+
+// StatusGraph — допустимые переходы: для статуса список статусов, в которые
+// из него можно перейти, в порядке настройки. Первым идёт основной путь
+// доставки, утеря, повреждение, возврат и отмена в него не входят.
+type StatusGraph map[string][]string
+
+// offPathStatuses — статусы вне основного пути доставки
+var offPathStatuses = map[string]bool{
+	ParcelStatusLost:      true,
+	ParcelStatusDamaged:   true,
+	ParcelStatusReturned:  true,
+	ParcelStatusCancelled: true,
+}
+
+// defaultStatusGraph — переходы для арендаторов без собственного графа
+var defaultStatusGraph = StatusGraph{
+	ParcelStatusRegistered: {ParcelStatusSent, ParcelStatusCancelled},
+	ParcelStatusSent:       {ParcelStatusDelivered, ParcelStatusLost, ParcelStatusDamaged, ParcelStatusReturned},
+	ParcelStatusDelivered:  {ParcelStatusDamaged},
+}
+
+// Allows проверяет, что переход из from в to допустим
+func (g StatusGraph) Allows(from string, to string) bool {
+	for _, next := range g[from] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Next возвращает следующий статус основного пути доставки
+func (g StatusGraph) Next(from string) (string, bool) {
+	for _, next := range g[from] {
+		if !offPathStatuses[next] {
+			return next, true
+		}
+	}
+
+	return "", false
+}
+
+// Validate проверяет статус арендатора перед сохранением
+func (st TenantStatus) Validate() error {
+	switch {
+	case st.Tenant == "":
+		return fmt.Errorf("%w: не указан арендатор", ErrInvalidStatus)
+	case !statusCodeRe.MatchString(st.Code):
+		return fmt.Errorf("%w: код %q", ErrInvalidStatus, st.Code)
+	case parcelStatuses[st.Code]:
+		return fmt.Errorf("%w: код %q совпадает со встроенным статусом", ErrInvalidStatus, st.Code)
+	case st.Name == "":
+		return fmt.Errorf("%w: пустое название", ErrInvalidStatus)
+	}
+
+	return nil
+}
+
+// AddTenantStatus добавляет собственный статус арендатора или меняет его название
+func (s ParcelStore) AddTenantStatus(st TenantStatus) error {
+	if err := st.Validate(); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec("INSERT INTO {tenant_status} (tenant, code, name) VALUES (:tenant, :code, :name) "+
+		"ON CONFLICT (tenant, code) DO UPDATE SET name = excluded.name",
+		sql.Named("tenant", st.Tenant),
+		sql.Named("code", st.Code),
+		sql.Named("name", st.Name))
+
+	return err
+}
+
+// TenantStatuses возвращает собственные статусы арендатора
+func (s ParcelStore) TenantStatuses(tenant string) ([]TenantStatus, error) {
+	rows, err := s.db.Query("SELECT tenant, code, name FROM {tenant_status} WHERE tenant = :tenant ORDER BY code",
+		sql.Named("tenant", tenant))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []TenantStatus
+	for rows.Next() {
+		st := TenantStatus{}
+		if err := rows.Scan(&st.Tenant, &st.Code, &st.Name); err != nil {
+			return nil, err
+		}
+		res = append(res, st)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// AddStatusTransition разрешает арендатору переход из from в to. Оба статуса
+// должны быть встроенными или собственными статусами арендатора. Как только
+// у арендатора есть хотя бы один переход, граф по умолчанию для него
+// не действует, поэтому настраивать нужно весь путь посылки.
+func (s ParcelStore) AddStatusTransition(tenant string, from string, to string) error {
+	if tenant == "" {
+		return fmt.Errorf("%w: не указан арендатор", ErrInvalidStatus)
+	}
+	for _, status := range []string{from, to} {
+		ok, err := s.isTenantStatus(tenant, status)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: неизвестный статус %q", ErrInvalidStatus, status)
+		}
+	}
+
+	_, err := s.db.Exec("INSERT INTO {status_transition} (tenant, from_status, to_status) "+
+		"VALUES (:tenant, :from, :to) ON CONFLICT DO NOTHING",
+		sql.Named("tenant", tenant),
+		sql.Named("from", from),
+		sql.Named("to", to))
+
+	return err
+}
+
+// StatusGraph возвращает граф переходов арендатора, а если он не настроен —
+// граф по умолчанию
+func (s ParcelStore) StatusGraph(tenant string) (StatusGraph, error) {
+	if tenant == "" {
+		return defaultStatusGraph, nil
+	}
+
+	rows, err := s.db.Query("SELECT from_status, to_status FROM {status_transition} WHERE tenant = :tenant ORDER BY id",
+		sql.Named("tenant", tenant))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	graph := StatusGraph{}
+	for rows.Next() {
+		var from, to string
+		if err := rows.Scan(&from, &to); err != nil {
+			return nil, err
+		}
+		graph[from] = append(graph[from], to)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(graph) == 0 {
+		return defaultStatusGraph, nil
+	}
+
+	return graph, nil
+}
+
+// isTenantStatus проверяет, что статус встроенный или определён арендатором
+func (s ParcelStore) isTenantStatus(tenant string, status string) (bool, error) {
+	if parcelStatuses[status] {
+		return true, nil
+	}
+	if tenant == "" {
+		return false, nil
+	}
+
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM {tenant_status} WHERE tenant = :tenant AND code = :code",
+		sql.Named("tenant", tenant),
+		sql.Named("code", status)).Scan(&count)
+
+	return count > 0, err
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/require"
+)
+
+// addTestTenantGraph настраивает арендатору acme путь через таможню
+func addTestTenantGraph(t *testing.T, store ParcelStore) {
+	t.Helper()
+
+	require.NoError(t, store.AddTenantStatus(TenantStatus{Tenant: "acme", Code: "at_customs", Name: "На таможне"}))
+	for _, edge := range [][2]string{
+		{ParcelStatusRegistered, ParcelStatusSent},
+		{ParcelStatusSent, "at_customs"},
+		{ParcelStatusSent, ParcelStatusLost},
+		{"at_customs", ParcelStatusDelivered},
+	} {
+		require.NoError(t, store.AddStatusTransition("acme", edge[0], edge[1]))
+	}
+}
+
+// TestTenantStatusGraph проверяет переходы по графу арендатора
+func TestTenantStatusGraph(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	addTestTenantGraph(t, store)
+
+	parcel := getTestParcel()
+	parcel.Tenant = "acme"
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	// check
+	require.NoError(t, store.TransitionStatus(id, ParcelStatusRegistered, ParcelStatusSent))
+	require.ErrorIs(t, store.TransitionStatus(id, ParcelStatusSent, ParcelStatusDelivered), ErrInvalidTransition)
+	require.NoError(t, store.TransitionStatus(id, ParcelStatusSent, "at_customs"))
+	require.NoError(t, store.TransitionStatus(id, "at_customs", ParcelStatusDelivered))
+
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusDelivered, stored.Status)
+
+	graph, err := store.StatusGraph("acme")
+	require.NoError(t, err)
+	next, ok := graph.Next(ParcelStatusSent)
+	require.True(t, ok)
+	require.Equal(t, "at_customs", next)
+	_, ok = graph.Next(ParcelStatusDelivered)
+	require.False(t, ok)
+}
+
+// TestDefaultStatusGraph проверяет граф по умолчанию для арендатора без настроек
+func TestDefaultStatusGraph(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	addTestTenantGraph(t, store)
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// check
+	require.ErrorIs(t, store.TransitionStatus(id, ParcelStatusRegistered, ParcelStatusDelivered), ErrInvalidTransition)
+	require.NoError(t, store.TransitionStatus(id, ParcelStatusRegistered, ParcelStatusSent))
+
+	// статус другого арендатора недоступен
+	require.ErrorIs(t, store.SetStatus(id, "at_customs"), ErrInvalidParcel)
+
+	graph, err := store.StatusGraph("other")
+	require.NoError(t, err)
+	require.Equal(t, defaultStatusGraph, graph)
+}
+
+// TestTenantStatusValidate проверяет проверку собственных статусов и переходов
+func TestTenantStatusValidate(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+
+	// check
+	for _, st := range []TenantStatus{
+		{Tenant: "", Code: "at_customs", Name: "x"},
+		{Tenant: "acme", Code: "At Customs", Name: "x"},
+		{Tenant: "acme", Code: ParcelStatusSent, Name: "x"},
+		{Tenant: "acme", Code: "at_customs", Name: ""},
+	} {
+		require.ErrorIs(t, store.AddTenantStatus(st), ErrInvalidStatus, st.Code)
+	}
+
+	require.ErrorIs(t, store.AddStatusTransition("acme", ParcelStatusSent, "at_customs"), ErrInvalidStatus)
+	require.NoError(t, store.AddTenantStatus(TenantStatus{Tenant: "acme", Code: "at_customs", Name: "x"}))
+	require.NoError(t, store.AddStatusTransition("acme", ParcelStatusSent, "at_customs"))
+	require.NoError(t, store.AddStatusTransition("acme", ParcelStatusSent, "at_customs"))
+
+	statuses, err := store.TenantStatuses("acme")
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+}
+
+// TestStatusMachineProperties проверяет инварианты статусов на случайных
+// последовательностях операций: посылку, покинувшую статус registered,
+// нельзя удалить, а история её статусов — путь по графу переходов
+func TestStatusMachineProperties(t *testing.T) {
+	// prepare
+	var changes []StatusChange
+	store := NewParcelStore(openTestDB(t), WithHooks(Hooks{
+		OnAfterStatusChange: func(c StatusChange) { changes = append(changes, c) },
+	}))
+	statuses := make([]string, 0, len(parcelStatuses))
+	for status := range parcelStatuses {
+		statuses = append(statuses, status)
+	}
+
+	// ops — пары байтов: операция и целевой статус
+	property := func(ops []byte) bool {
+		changes = nil
+		number, err := store.Add(getTestParcel())
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+
+		status, deleted := ParcelStatusRegistered, false
+		for i := 0; i+1 < len(ops); i += 2 {
+			to := statuses[int(ops[i+1])%len(statuses)]
+			switch ops[i] % 4 {
+			case 0:
+				err = store.TransitionStatus(number, status, to)
+				switch {
+				case deleted:
+					err = expectErr(err, sql.ErrNoRows)
+				case defaultStatusGraph.Allows(status, to):
+					status = to
+				default:
+					err = expectErr(err, ErrInvalidTransition)
+				}
+			case 1:
+				err = store.ReportDamage(number, "вмятина", nil)
+				switch {
+				case deleted:
+					err = expectErr(err, sql.ErrNoRows)
+				case status == ParcelStatusSent || status == ParcelStatusDelivered:
+					status = ParcelStatusDamaged
+				default:
+					err = expectErr(err, ErrNotDamageable)
+				}
+			case 2:
+				err = store.SetAddress(number, "Псков")
+			case 3:
+				err = store.Delete(number)
+				deleted = deleted || status == ParcelStatusRegistered
+			}
+			if err != nil {
+				t.Logf("операция %d: %v", i/2, err)
+				return false
+			}
+
+			p, err := store.Get(number)
+			if deleted != errors.Is(err, sql.ErrNoRows) || (!deleted && p.Status != status) {
+				t.Logf("операция %d: ожидался статус %q, удалена %v; получено %q, %v", i/2, status, deleted, p.Status, err)
+				return false
+			}
+		}
+
+		from := ParcelStatusRegistered
+		for _, c := range changes {
+			if c.Number != number || c.From != from || !defaultStatusGraph.Allows(c.From, c.To) {
+				t.Logf("переход %+v не продолжает путь из %q", c, from)
+				return false
+			}
+			from = c.To
+		}
+
+		return deleted || from == status
+	}
+
+	// check
+	require.NoError(t, quick.Check(property, &quick.Config{MaxCount: 200}))
+}
+
+// expectErr возвращает nil, если err — ожидаемая ошибка target, иначе описание расхождения
+func expectErr(err error, target error) error {
+	if errors.Is(err, target) {
+		return nil
+	}
+
+	return fmt.Errorf("ожидалась ошибка %v, получено %v", target, err)
+}
@@ -0,0 +1,23 @@
+package main
+
+// ParcelStorage — базовые операции хранения посылок, которые реализует
+// каждый движок БД. Зоны, таможня, страхование и вложения пока есть только
+// в ParcelStore на SQLite.
+//
+// Deprecated: используйте ParcelStorageV2; методы первой версии остаются
+// на время перехода.
+type ParcelStorage interface {
+	Add(p Parcel) (int, error)
+	Get(number int) (Parcel, error)
+	GetByUUID(id string) (Parcel, error)
+	GetByClient(client int) ([]Parcel, error)
+	SetStatus(number int, status string) error
+	TransitionStatus(number int, from string, to string) error
+	SetAddress(number int, address string) error
+	Delete(number int) error
+}
+
+var (
+	_ ParcelStorage = ParcelStore{}
+	_ ParcelStorage = MySQLParcelStore{}
+)
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// storageBackend создаёт пустое хранилище одного движка БД
+type storageBackend struct {
+	name string
+	open func(t *testing.T) ParcelStorage
+}
+
+// storageBackends возвращает движки для общих тестов ParcelStorage.
+// SQLite проверяется всегда, MySQL — если задан TRACKER_MYSQL_DSN.
+func storageBackends() []storageBackend {
+	return []storageBackend{
+		{name: "sqlite", open: func(t *testing.T) ParcelStorage {
+			return NewParcelStore(openTestDB(t))
+		}},
+		{name: "mysql", open: openTestMySQL},
+	}
+}
+
+// mysqlRequired делает MySQL обязательным: без TRACKER_MYSQL_DSN тесты
+// падают, а не пропускаются. Включается тегом integration.
+var mysqlRequired bool
+
+// openTestMySQL подключается к MySQL из TRACKER_MYSQL_DSN и очищает таблицу посылок
+func openTestMySQL(t *testing.T) ParcelStorage {
+	t.Helper()
+
+	dsn := os.Getenv("TRACKER_MYSQL_DSN")
+	if dsn == "" {
+		if mysqlRequired {
+			t.Fatal("TRACKER_MYSQL_DSN не задан, см. docker-compose.yml")
+		}
+		t.Skip("TRACKER_MYSQL_DSN не задан")
+	}
+
+	db, err := OpenMySQL(dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	store := NewMySQLParcelStore(db)
+	require.NoError(t, store.Migrate())
+	_, err = db.Exec("DELETE FROM parcel")
+	require.NoError(t, err)
+
+	return store
+}
+
+// TestStorageAddGetDelete проверяет добавление, получение и удаление посылки на всех движках
+func TestStorageAddGetDelete(t *testing.T) {
+	for _, backend := range storageBackends() {
+		t.Run(backend.name, func(t *testing.T) {
+			// prepare
+			store := backend.open(t)
+			parcel := getTestParcel()
+
+			// add
+			id, err := store.Add(parcel)
+			require.NoError(t, err)
+			require.NotZero(t, id)
+
+			// get
+			stored, err := store.Get(id)
+			require.NoError(t, err)
+			parcel.Number = id
+			require.NotZero(t, stored.UpdatedAt)
+			parcel.UpdatedAt = stored.UpdatedAt
+			require.Equal(t, parcel, stored)
+
+			stored, err = store.GetByUUID(parcel.UUID)
+			require.NoError(t, err)
+			require.Equal(t, id, stored.Number)
+
+			// delete
+			require.NoError(t, store.Delete(id))
+			_, err = store.Get(id)
+			require.ErrorIs(t, err, sql.ErrNoRows)
+		})
+	}
+}
+
+// TestStorageDefaults проверяет значения по умолчанию необязательных
+// полей посылки на всех движках
+func TestStorageDefaults(t *testing.T) {
+	for _, backend := range storageBackends() {
+		t.Run(backend.name, func(t *testing.T) {
+			// prepare
+			store := backend.open(t)
+			parcel := getTestParcel()
+			parcel.ServiceLevel = ""
+			parcel.Currency = ""
+
+			// check
+			id, err := store.Add(parcel)
+			require.NoError(t, err)
+			stored, err := store.Get(id)
+			require.NoError(t, err)
+			require.Equal(t, ServiceLevelStandard, stored.ServiceLevel)
+			require.Equal(t, DefaultCurrency, stored.Currency)
+		})
+	}
+}
+
+// TestStorageStatus проверяет смену статуса и адреса на всех движках
+func TestStorageStatus(t *testing.T) {
+	for _, backend := range storageBackends() {
+		t.Run(backend.name, func(t *testing.T) {
+			// prepare
+			store := backend.open(t)
+			id, err := store.Add(getTestParcel())
+			require.NoError(t, err)
+
+			// set address
+			require.NoError(t, store.SetAddress(id, "new test address"))
+
+			// set status
+			require.NoError(t, store.TransitionStatus(id, ParcelStatusRegistered, ParcelStatusSent))
+			require.ErrorIs(t, store.TransitionStatus(id, ParcelStatusRegistered, ParcelStatusSent), ErrStatusChanged)
+
+			// адрес отправленной посылки не меняется
+			require.NoError(t, store.SetAddress(id, "other address"))
+
+			// check
+			stored, err := store.Get(id)
+			require.NoError(t, err)
+			require.Equal(t, "new test address", stored.Address)
+			require.Equal(t, ParcelStatusSent, stored.Status)
+			require.False(t, stored.SentAt.IsZero())
+
+			require.NoError(t, store.SetStatus(id, ParcelStatusDelivered))
+			stored, err = store.Get(id)
+			require.NoError(t, err)
+			require.False(t, stored.DeliveredAt.IsZero())
+		})
+	}
+}
+
+// TestStorageGetByClient проверяет получение посылок клиента на всех движках
+func TestStorageGetByClient(t *testing.T) {
+	for _, backend := range storageBackends() {
+		t.Run(backend.name, func(t *testing.T) {
+			// prepare
+			store := backend.open(t)
+			numbers := map[int]bool{}
+			for i := 0; i < 3; i++ {
+				id, err := store.Add(getTestParcel())
+				require.NoError(t, err)
+				numbers[id] = true
+			}
+
+			// check
+			parcels, err := store.GetByClient(getTestParcel().Client)
+			require.NoError(t, err)
+			require.Len(t, parcels, len(numbers))
+			for _, p := range parcels {
+				require.True(t, numbers[p.Number])
+			}
+		})
+	}
+}
+
+// TestStorageConcurrentAdd проверяет, что при параллельных добавлениях
+// каждое возвращает номер своей посылки, а не соседней
+func TestStorageConcurrentAdd(t *testing.T) {
+	const workers, perWorker = 8, 10
+
+	for _, backend := range storageBackends() {
+		t.Run(backend.name, func(t *testing.T) {
+			// prepare
+			store := backend.open(t)
+			type added struct {
+				number int
+				uuid   string
+				err    error
+			}
+			results := make(chan added, workers*perWorker)
+
+			// add
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < perWorker; i++ {
+						p := getTestParcel()
+						number, err := store.Add(p)
+						results <- added{number: number, uuid: p.UUID, err: err}
+					}
+				}()
+			}
+			wg.Wait()
+			close(results)
+
+			// check
+			numbers := map[int]bool{}
+			for r := range results {
+				require.NoError(t, r.err)
+				require.False(t, numbers[r.number], r.number)
+				numbers[r.number] = true
+
+				stored, err := store.Get(r.number)
+				require.NoError(t, err)
+				require.Equal(t, r.uuid, stored.UUID)
+			}
+			require.Len(t, numbers, workers*perWorker)
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StorageDecorator оборачивает ParcelStorageV2 дополнительным поведением
+type StorageDecorator func(ParcelStorageV2) ParcelStorageV2
+
+// Chain оборачивает base декораторами. Первый декоратор — внешний: в
+// Chain(base, WithLogging(l), WithRetry(3, d)) журнал видит вызов целиком
+// вместе с повторами, а повторяются только вызовы base.
+func Chain(base ParcelStorageV2, decorators ...StorageDecorator) ParcelStorageV2 {
+	s := base
+	for i := len(decorators) - 1; i >= 0; i-- {
+		s = decorators[i](s)
+	}
+
+	return s
+}
+
+// WithCache читает посылки через cache, см. CachedStorage
+func WithCache(cache *ParcelCache, opts ...CacheOption) StorageDecorator {
+	return func(s ParcelStorageV2) ParcelStorageV2 {
+		return NewCachedStorage(s, cache, opts...)
+	}
+}
+
+// WithLogging пишет в logger каждую операцию хранилища с её временем и ошибкой
+func WithLogging(logger *log.Logger) StorageDecorator {
+	return func(s ParcelStorageV2) ParcelStorageV2 {
+		return wrappedStorage{ParcelStorageV2: s, wrap: func(ctx context.Context, op string, call func(context.Context) error) error {
+			start := time.Now()
+			err := call(ctx)
+			msg := fmt.Sprintf("storage %s %s", op, time.Since(start))
+			if id := RequestIDFromContext(ctx); id != "" {
+				msg += " request_id=" + id
+			}
+			if err != nil {
+				msg += ": " + err.Error()
+			}
+			logger.Print(msg)
+			return err
+		}}
+	}
+}
+
+// WithMetrics учитывает операции хранилища в metrics
+func WithMetrics(metrics *StorageCallMetrics) StorageDecorator {
+	return func(s ParcelStorageV2) ParcelStorageV2 {
+		return wrappedStorage{ParcelStorageV2: s, wrap: func(ctx context.Context, op string, call func(context.Context) error) error {
+			start := time.Now()
+			err := call(ctx)
+			metrics.record(op, time.Since(start), err)
+			return err
+		}}
+	}
+}
+
+// WithRetry повторяет операцию, завершившуюся временной ошибкой БД
+// (занятая БД, взаимная блокировка), всего до attempts попыток с паузой
+// backoff, удваивающейся после каждой. Такая ошибка означает, что
+// транзакция операции откатилась, поэтому повтор ничего не задвоит.
+func WithRetry(attempts int, backoff time.Duration) StorageDecorator {
+	return func(s ParcelStorageV2) ParcelStorageV2 {
+		return wrappedStorage{ParcelStorageV2: s, wrap: func(ctx context.Context, op string, call func(context.Context) error) error {
+			delay := backoff
+			for attempt := 1; ; attempt++ {
+				err := call(ctx)
+				if err == nil || attempt >= attempts || !isTransientDBError(err) {
+					return err
+				}
+
+				t := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					return errors.Join(err, ctx.Err())
+				case <-t.C:
+				}
+				delay *= 2
+			}
+		}}
+	}
+}
+
+// wrappedStorage вызывает каждую операцию ParcelStorageV2 через wrap.
+// Результат операции call сохраняет сам, wrap видит только ошибку.
+type wrappedStorage struct {
+	ParcelStorageV2
+	wrap func(ctx context.Context, op string, call func(ctx context.Context) error) error
+}
+
+var _ ParcelStorageV2 = wrappedStorage{}
+
+func (s wrappedStorage) AddContext(ctx context.Context, p Parcel, opts ...CallOption) (int, error) {
+	var number int
+	err := s.wrap(ctx, OpAdd, func(ctx context.Context) (err error) {
+		number, err = s.ParcelStorageV2.AddContext(ctx, p, opts...)
+		return err
+	})
+
+	return number, err
+}
+
+func (s wrappedStorage) GetContext(ctx context.Context, number int, opts ...CallOption) (Parcel, error) {
+	var p Parcel
+	err := s.wrap(ctx, OpGet, func(ctx context.Context) (err error) {
+		p, err = s.ParcelStorageV2.GetContext(ctx, number, opts...)
+		return err
+	})
+
+	return p, err
+}
+
+func (s wrappedStorage) GetByUUIDContext(ctx context.Context, id string, opts ...CallOption) (Parcel, error) {
+	var p Parcel
+	err := s.wrap(ctx, OpGetByUUID, func(ctx context.Context) (err error) {
+		p, err = s.ParcelStorageV2.GetByUUIDContext(ctx, id, opts...)
+		return err
+	})
+
+	return p, err
+}
+
+func (s wrappedStorage) GetByClientContext(ctx context.Context, client int, opts ...CallOption) ([]Parcel, error) {
+	var parcels []Parcel
+	err := s.wrap(ctx, OpGetByClient, func(ctx context.Context) (err error) {
+		parcels, err = s.ParcelStorageV2.GetByClientContext(ctx, client, opts...)
+		return err
+	})
+
+	return parcels, err
+}
+
+func (s wrappedStorage) SetStatusContext(ctx context.Context, number int, status string, opts ...CallOption) error {
+	return s.wrap(ctx, OpSetStatus, func(ctx context.Context) error {
+		return s.ParcelStorageV2.SetStatusContext(ctx, number, status, opts...)
+	})
+}
+
+func (s wrappedStorage) TransitionStatusContext(ctx context.Context, number int, from string, to string, opts ...CallOption) error {
+	return s.wrap(ctx, OpTransitionStatus, func(ctx context.Context) error {
+		return s.ParcelStorageV2.TransitionStatusContext(ctx, number, from, to, opts...)
+	})
+}
+
+func (s wrappedStorage) SetAddressContext(ctx context.Context, number int, address string, opts ...CallOption) error {
+	return s.wrap(ctx, OpSetAddress, func(ctx context.Context) error {
+		return s.ParcelStorageV2.SetAddressContext(ctx, number, address, opts...)
+	})
+}
+
+func (s wrappedStorage) DeleteContext(ctx context.Context, number int, opts ...CallOption) error {
+	return s.wrap(ctx, OpDelete, func(ctx context.Context) error {
+		return s.ParcelStorageV2.DeleteContext(ctx, number, opts...)
+	})
+}
+
+// StorageCallMetrics считает операции хранилища, прошедшие через
+// WithMetrics, и отдаёт их как метрики Prometheus в текстовом формате
+type StorageCallMetrics struct {
+	mu  sync.Mutex
+	ops map[string]*storageCallStats
+}
+
+// storageCallStats — счётчики одной операции
+type storageCallStats struct {
+	ok      int
+	failed  int
+	seconds float64
+}
+
+// NewStorageCallMetrics возвращает пустые счётчики операций хранилища
+func NewStorageCallMetrics() *StorageCallMetrics {
+	return &StorageCallMetrics{ops: map[string]*storageCallStats{}}
+}
+
+// record учитывает вызов операции op. Посылки, которой нет, — обычный
+// ответ хранилища, а не сбой, поэтому такие вызовы считаются успешными.
+func (m *StorageCallMetrics) record(op string, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, ok := m.ops[op]
+	if !ok {
+		st = &storageCallStats{}
+		m.ops[op] = st
+	}
+	if err != nil && !errors.Is(err, ErrParcelNotFound) {
+		st.failed++
+	} else {
+		st.ok++
+	}
+	st.seconds += elapsed.Seconds()
+}
+
+func (m *StorageCallMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	ops := make([]string, 0, len(m.ops))
+	stats := make(map[string]storageCallStats, len(m.ops))
+	for op, st := range m.ops {
+		ops = append(ops, op)
+		stats[op] = *st
+	}
+	m.mu.Unlock()
+	sort.Strings(ops)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintln(w, "# HELP tracker_storage_calls_total Операции хранилища посылок.")
+	fmt.Fprintln(w, "# TYPE tracker_storage_calls_total counter")
+	for _, op := range ops {
+		fmt.Fprintf(w, "tracker_storage_calls_total{op=%q,result=\"ok\"} %d\n", op, stats[op].ok)
+		fmt.Fprintf(w, "tracker_storage_calls_total{op=%q,result=\"error\"} %d\n", op, stats[op].failed)
+	}
+	fmt.Fprintln(w, "# HELP tracker_storage_call_seconds_total Суммарное время операций хранилища посылок.")
+	fmt.Fprintln(w, "# TYPE tracker_storage_call_seconds_total counter")
+	for _, op := range ops {
+		fmt.Fprintf(w, "tracker_storage_call_seconds_total{op=%q} %g\n", op, stats[op].seconds)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyStorage возвращает ошибку на первые failures вызовов SetStatusContext
+type flakyStorage struct {
+	ParcelStorageV2
+	err      error
+	failures int
+	calls    int
+}
+
+func (s *flakyStorage) SetStatusContext(ctx context.Context, number int, status string, opts ...CallOption) error {
+	s.calls++
+	if s.calls <= s.failures {
+		return &StoreError{Op: OpSetStatus, Number: number, Err: s.err}
+	}
+
+	return s.ParcelStorageV2.SetStatusContext(ctx, number, status, opts...)
+}
+
+// TestChain проверяет порядок декораторов: первый — внешний
+func TestChain(t *testing.T) {
+	// prepare
+	var order []string
+	trace := func(name string) StorageDecorator {
+		return func(s ParcelStorageV2) ParcelStorageV2 {
+			return wrappedStorage{ParcelStorageV2: s, wrap: func(ctx context.Context, op string, call func(context.Context) error) error {
+				order = append(order, name)
+				return call(ctx)
+			}}
+		}
+	}
+	store := Chain(NewParcelStore(openTestDB(t)), trace("outer"), trace("inner"))
+
+	// check
+	_, err := store.AddContext(context.Background(), getTestParcel())
+	require.NoError(t, err)
+	require.Equal(t, []string{"outer", "inner"}, order)
+}
+
+// TestChainDecorators проверяет журнал, метрики, повторы и кэш в одной цепочке
+func TestChainDecorators(t *testing.T) {
+	// prepare
+	ctx := WithRequestID(context.Background(), "req-7")
+	base := NewParcelStore(openTestDB(t))
+	number, err := base.Add(getTestParcel())
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	metrics := NewStorageCallMetrics()
+	cache := NewParcelCache(time.Minute, 0)
+	flaky := &flakyStorage{ParcelStorageV2: base, err: &mysql.MySQLError{Number: 1213}, failures: 2}
+	store := Chain(flaky,
+		WithLogging(log.New(&buf, "", 0)),
+		WithMetrics(metrics),
+		WithCache(cache),
+		WithRetry(3, time.Millisecond))
+
+	// check
+	require.NoError(t, store.SetStatusContext(ctx, number, ParcelStatusSent))
+	require.Equal(t, 3, flaky.calls)
+	require.Contains(t, buf.String(), "storage set_status ")
+	require.Contains(t, buf.String(), "request_id=req-7")
+
+	_, err = store.GetContext(ctx, number)
+	require.NoError(t, err)
+	_, err = store.GetContext(ctx, number)
+	require.NoError(t, err)
+	require.Equal(t, 1, cache.Len())
+
+	_, err = store.GetContext(ctx, number+1)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/storage", nil))
+	require.Contains(t, rec.Body.String(), `tracker_storage_calls_total{op="get",result="ok"} 3`+"\n")
+	require.Contains(t, rec.Body.String(), `tracker_storage_calls_total{op="set_status",result="ok"} 1`+"\n")
+	require.Contains(t, rec.Body.String(), `tracker_storage_calls_total{op="set_status",result="error"} 0`+"\n")
+}
+
+// TestWithRetry проверяет, что повторяются только временные ошибки БД
+// и не больше заданного числа попыток
+func TestWithRetry(t *testing.T) {
+	// prepare
+	base := NewParcelStore(openTestDB(t))
+	number, err := base.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// check
+	flaky := &flakyStorage{ParcelStorageV2: base, err: &mysql.MySQLError{Number: 1205}, failures: 5}
+	err = Chain(flaky, WithRetry(3, time.Millisecond)).SetStatusContext(context.Background(), number, ParcelStatusSent)
+	require.Error(t, err)
+	require.Equal(t, 3, flaky.calls)
+
+	flaky = &flakyStorage{ParcelStorageV2: base, err: &mysql.MySQLError{Number: 1062}, failures: 1}
+	err = Chain(flaky, WithRetry(3, time.Millisecond)).SetStatusContext(context.Background(), number, ParcelStatusSent)
+	require.Error(t, err)
+	require.Equal(t, 1, flaky.calls)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	flaky = &flakyStorage{ParcelStorageV2: base, err: &mysql.MySQLError{Number: 1213}, failures: 1}
+	err = Chain(flaky, WithRetry(3, time.Hour)).SetStatusContext(ctx, number, ParcelStatusSent)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, flaky.calls)
+}
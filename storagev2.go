@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Операции ParcelStorageV2 в StoreError.Op
+const (
+	OpAdd              = "add"
+	OpGet              = "get"
+	OpGetByUUID        = "get_by_uuid"
+	OpGetByClient      = "get_by_client"
+	OpSetStatus        = "set_status"
+	OpTransitionStatus = "transition_status"
+	OpSetAddress       = "set_address"
+	OpDelete           = "delete"
+)
+
+// ErrParcelNotFound — посылки с таким номером нет. Оборачивает
+// sql.ErrNoRows, поэтому проверки errors.Is(err, sql.ErrNoRows)
+// продолжают работать.
+var ErrParcelNotFound = fmt.Errorf("посылка не найдена: %w", sql.ErrNoRows)
+
+// StoreError — ошибка операции ParcelStorageV2. Причину проверяют через
+// errors.Is, например с ErrParcelNotFound или ErrStatusChanged.
+type StoreError struct {
+	Op string
+	// Number — номер посылки, 0 — операция не над одной посылкой
+	Number int
+	Err    error
+}
+
+func (e *StoreError) Error() string {
+	if e.Number == 0 {
+		return fmt.Sprintf("%s: %v", e.Op, e.Err)
+	}
+
+	return fmt.Sprintf("посылка № %d: %s: %v", e.Number, e.Op, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// storeError оборачивает ошибку операции над посылкой number в StoreError;
+// sql.ErrNoRows означает, что посылки нет
+func storeError(op string, number int, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) && !errors.Is(err, ErrParcelNotFound) {
+		err = ErrParcelNotFound
+	}
+
+	return &StoreError{Op: op, Number: number, Err: err}
+}
+
+// v1Error возвращает ошибку v2 в том виде, в каком её возвращала первая
+// версия интерфейса: без StoreError и с sql.ErrNoRows вместо ErrParcelNotFound
+func v1Error(err error) error {
+	var se *StoreError
+	if !errors.As(err, &se) {
+		return err
+	}
+	if se.Err == ErrParcelNotFound {
+		return sql.ErrNoRows
+	}
+
+	return se.Err
+}
+
+// ParcelStorageV2 — вторая версия ParcelStorage: операции принимают
+// context.Context и опции вызова, ошибки оборачиваются в StoreError.
+// В отличие от первой версии SetAddressContext и DeleteContext сообщают,
+// что посылки нет (ErrParcelNotFound) или она уже не в статусе
+// registered (ErrNotRegistered), а не завершаются молча.
+//
+// Методы первой версии остаются обёртками над второй на время перехода
+// и помечены устаревшими.
+type ParcelStorageV2 interface {
+	AddContext(ctx context.Context, p Parcel, opts ...CallOption) (int, error)
+	GetContext(ctx context.Context, number int, opts ...CallOption) (Parcel, error)
+	GetByUUIDContext(ctx context.Context, id string, opts ...CallOption) (Parcel, error)
+	GetByClientContext(ctx context.Context, client int, opts ...CallOption) ([]Parcel, error)
+	SetStatusContext(ctx context.Context, number int, status string, opts ...CallOption) error
+	TransitionStatusContext(ctx context.Context, number int, from string, to string, opts ...CallOption) error
+	SetAddressContext(ctx context.Context, number int, address string, opts ...CallOption) error
+	DeleteContext(ctx context.Context, number int, opts ...CallOption) error
+}
+
+var (
+	_ ParcelStorageV2 = ParcelStore{}
+	_ ParcelStorageV2 = MySQLParcelStore{}
+)
+
+// callOptions — настройки одного вызова ParcelStorageV2
+type callOptions struct {
+	timeout time.Duration
+	// at — время события смены статуса, нулевое — текущее
+	at time.Time
+}
+
+// CallOption настраивает один вызов ParcelStorageV2
+type CallOption func(*callOptions)
+
+// WithTimeout ограничивает время вызова поверх срока ctx
+func WithTimeout(d time.Duration) CallOption {
+	return func(o *callOptions) {
+		o.timeout = d
+	}
+}
+
+// WithEventTime задаёт время смены статуса, которое записывается во время
+// отправки или доставки, например время события у перевозчика. Остальные
+// операции его не учитывают.
+func WithEventTime(at time.Time) CallOption {
+	return func(o *callOptions) {
+		o.at = at
+	}
+}
+
+// applyCallOptions собирает опции вызова и возвращает ctx с их сроком.
+// Время события по умолчанию — now. cancel нужно вызвать по завершении операции.
+func applyCallOptions(ctx context.Context, now time.Time, opts []CallOption) (context.Context, callOptions, context.CancelFunc) {
+	o := callOptions{at: now}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.timeout <= 0 {
+		return ctx, o, func() {}
+	}
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+
+	return ctx, o, cancel
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStorageV2Errors проверяет типизированные ошибки второй версии
+// и прежние ошибки обёрток первой
+func TestStorageV2Errors(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	ctx := context.Background()
+
+	// check
+	_, err := store.GetContext(ctx, 404)
+	require.ErrorIs(t, err, ErrParcelNotFound)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+	var se *StoreError
+	require.True(t, errors.As(err, &se))
+	require.Equal(t, OpGet, se.Op)
+	require.Equal(t, 404, se.Number)
+
+	// первая версия возвращает sql.ErrNoRows как раньше
+	_, err = store.Get(404)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	// в отличие от первой версии отсутствие посылки и неподходящий статус — ошибки
+	require.ErrorIs(t, store.DeleteContext(ctx, 404), ErrParcelNotFound)
+	require.NoError(t, store.Delete(404))
+
+	number, err := store.AddContext(ctx, getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.TransitionStatusContext(ctx, number, ParcelStatusRegistered, ParcelStatusSent))
+	require.ErrorIs(t, store.SetAddressContext(ctx, number, "new"), ErrNotRegistered)
+	require.ErrorIs(t, store.DeleteContext(ctx, number), ErrNotRegistered)
+	require.NoError(t, store.SetAddress(number, "new"))
+
+	err = store.TransitionStatusContext(ctx, number, ParcelStatusRegistered, ParcelStatusSent)
+	require.ErrorIs(t, err, ErrStatusChanged)
+	require.Equal(t, ErrStatusChanged, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+}
+
+// TestStorageV2Options проверяет отмену ctx и опции вызова
+func TestStorageV2Options(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	ctx := context.Background()
+	number, err := store.AddContext(ctx, getTestParcel())
+	require.NoError(t, err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	// check
+	_, err = store.AddContext(cancelled, getTestParcel())
+	require.ErrorIs(t, err, context.Canceled)
+	_, err = store.GetContext(cancelled, number)
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = store.GetContext(ctx, number, WithTimeout(time.Second))
+	require.NoError(t, err)
+
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, store.TransitionStatusContext(ctx, number, ParcelStatusRegistered, ParcelStatusSent, WithEventTime(at)))
+	p, err := store.GetContext(ctx, number)
+	require.NoError(t, err)
+	require.True(t, at.Equal(p.SentAt))
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// storageMetricsInterval — как часто собираются размеры таблиц и файлов БД
+const storageMetricsInterval = 5 * time.Minute
+
+// TableStat — число строк таблицы хранилища
+type TableStat struct {
+	Table string `json:"table"`
+	Rows  int64  `json:"rows"`
+}
+
+// StorageStats — размеры хранилища для планирования ёмкости
+type StorageStats struct {
+	Tables []TableStat `json:"tables"`
+	// DBSize — размер файла БД в байтах по числу страниц
+	DBSize int64 `json:"db_size"`
+	// WALSize — размер журнала WAL в байтах, 0 — журнала нет
+	WALSize     int64     `json:"wal_size"`
+	CollectedAt time.Time `json:"collected_at"`
+}
+
+// StorageStats считает строки всех таблиц хранилища и размеры файлов БД.
+// COUNT(*) проходит таблицу целиком, поэтому вызывается редко, а не
+// на каждый запрос метрик.
+func (s ParcelStore) StorageStats(ctx context.Context) (StorageStats, error) {
+	res := StorageStats{CollectedAt: s.now().UTC()}
+	for _, table := range storeTables {
+		var rows int64
+		err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM {"+table+"}").Scan(&rows)
+		if err != nil {
+			return StorageStats{}, fmt.Errorf("таблица %s: %w", table, err)
+		}
+		res.Tables = append(res.Tables, TableStat{Table: table, Rows: rows})
+	}
+
+	pages, pageSize, err := s.pageCount(ctx)
+	if err != nil {
+		return StorageStats{}, err
+	}
+	res.DBSize = pages * pageSize
+
+	path, err := s.databaseFile(ctx)
+	if err != nil {
+		return StorageStats{}, err
+	}
+	if path != "" {
+		info, err := os.Stat(path + "-wal")
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return StorageStats{}, err
+		default:
+			res.WALSize = info.Size()
+		}
+	}
+
+	return res, nil
+}
+
+// databaseFile возвращает путь к файлу схемы хранилища, пустой для БД в памяти
+func (s ParcelStore) databaseFile(ctx context.Context) (string, error) {
+	rows, err := s.db.QueryContext(ctx, "PRAGMA database_list")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var path string
+	for rows.Next() {
+		var seq int
+		var name, file string
+		if err := rows.Scan(&seq, &name, &file); err != nil {
+			return "", err
+		}
+		if name == s.naming.schemaName() {
+			path = file
+		}
+	}
+
+	return path, rows.Err()
+}
+
+// StorageMetrics периодически собирает StorageStats и отдаёт последние
+// значения как метрики Prometheus в текстовом формате
+type StorageMetrics struct {
+	store ParcelStore
+
+	mu   sync.Mutex
+	last StorageStats
+	// maintenance — итог последнего обслуживания БД, reclaimed — сколько
+	// байт оно вернуло за всё время, см. RecordMaintenance
+	maintenance MaintenanceResult
+	reclaimed   int64
+}
+
+// NewStorageMetrics возвращает сборщик размеров хранилища store
+func NewStorageMetrics(store ParcelStore) *StorageMetrics {
+	return &StorageMetrics{store: store}
+}
+
+// Collect собирает размеры хранилища и запоминает их
+func (m *StorageMetrics) Collect(ctx context.Context) error {
+	stats, err := m.store.StorageStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.last = stats
+	m.mu.Unlock()
+
+	return nil
+}
+
+// RecordMaintenance запоминает итог обслуживания БД, см. ParcelStore.Maintain
+func (m *StorageMetrics) RecordMaintenance(res MaintenanceResult) {
+	m.mu.Lock()
+	m.maintenance = res
+	m.reclaimed += res.ReclaimedBytes
+	m.mu.Unlock()
+}
+
+// Run собирает размеры хранилища сразу и затем каждые interval, пока не
+// отменён ctx. Ошибки записываются в errors.
+func (m *StorageMetrics) Run(ctx context.Context, interval time.Duration, errors *ErrorLog) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Collect(ctx); err != nil && ctx.Err() == nil {
+			errors.Record(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ServeHTTP отдаёт последние собранные размеры. До первого сбора
+// метрик нет, ответ пустой.
+func (m *StorageMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	stats, maintenance, reclaimed := m.last, m.maintenance, m.reclaimed
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if !maintenance.RanAt.IsZero() {
+		fmt.Fprintln(w, "# HELP tracker_maintenance_reclaimed_bytes_total Место, возвращённое обслуживанием БД.")
+		fmt.Fprintln(w, "# TYPE tracker_maintenance_reclaimed_bytes_total counter")
+		fmt.Fprintf(w, "tracker_maintenance_reclaimed_bytes_total %d\n", reclaimed)
+		fmt.Fprintln(w, "# HELP tracker_maintenance_checkpointed_pages Страницы WAL, перенесённые последним обслуживанием.")
+		fmt.Fprintln(w, "# TYPE tracker_maintenance_checkpointed_pages gauge")
+		fmt.Fprintf(w, "tracker_maintenance_checkpointed_pages %d\n", maintenance.CheckpointedPages)
+		fmt.Fprintln(w, "# HELP tracker_maintenance_duration_seconds Длительность последнего обслуживания БД.")
+		fmt.Fprintln(w, "# TYPE tracker_maintenance_duration_seconds gauge")
+		fmt.Fprintf(w, "tracker_maintenance_duration_seconds %g\n", maintenance.Duration.Seconds())
+		fmt.Fprintln(w, "# HELP tracker_maintenance_timestamp_seconds Время последнего обслуживания БД.")
+		fmt.Fprintln(w, "# TYPE tracker_maintenance_timestamp_seconds gauge")
+		fmt.Fprintf(w, "tracker_maintenance_timestamp_seconds %d\n", maintenance.RanAt.Unix())
+	}
+	if stats.CollectedAt.IsZero() {
+		return
+	}
+
+	fmt.Fprintln(w, "# HELP tracker_table_rows Число строк таблицы хранилища.")
+	fmt.Fprintln(w, "# TYPE tracker_table_rows gauge")
+	for _, t := range stats.Tables {
+		fmt.Fprintf(w, "tracker_table_rows{table=%q} %d\n", t.Table, t.Rows)
+	}
+	fmt.Fprintln(w, "# HELP tracker_db_size_bytes Размер файла БД.")
+	fmt.Fprintln(w, "# TYPE tracker_db_size_bytes gauge")
+	fmt.Fprintf(w, "tracker_db_size_bytes %d\n", stats.DBSize)
+	fmt.Fprintln(w, "# HELP tracker_wal_size_bytes Размер журнала WAL.")
+	fmt.Fprintln(w, "# TYPE tracker_wal_size_bytes gauge")
+	fmt.Fprintf(w, "tracker_wal_size_bytes %d\n", stats.WALSize)
+	fmt.Fprintln(w, "# HELP tracker_storage_stats_timestamp_seconds Время последнего сбора размеров.")
+	fmt.Fprintln(w, "# TYPE tracker_storage_stats_timestamp_seconds gauge")
+	fmt.Fprintf(w, "tracker_storage_stats_timestamp_seconds %d\n", stats.CollectedAt.Unix())
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestStorageStats проверяет подсчёт строк таблиц и размеров файлов БД
+func TestStorageStats(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	for i := 0; i < 3; i++ {
+		_, err := store.Add(getTestParcel())
+		require.NoError(t, err)
+	}
+
+	// collect
+	stats, err := store.StorageStats(context.Background())
+	require.NoError(t, err)
+
+	// check
+	require.Len(t, stats.Tables, len(storeTables))
+	require.Contains(t, stats.Tables, TableStat{Table: "parcel", Rows: 3})
+	require.Positive(t, stats.DBSize)
+	require.Positive(t, stats.WALSize)
+}
+
+// TestStorageMetricsHandler проверяет выдачу метрик Prometheus
+func TestStorageMetricsHandler(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	_, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	metrics := NewStorageMetrics(store)
+
+	// до первого сбора метрик нет
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Empty(t, rec.Body.String())
+
+	// collect
+	require.NoError(t, metrics.Collect(context.Background()))
+	rec = httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	// check
+	require.Contains(t, rec.Body.String(), "# TYPE tracker_table_rows gauge\n")
+	require.Contains(t, rec.Body.String(), `tracker_table_rows{table="parcel"} 1`+"\n")
+	require.Contains(t, rec.Body.String(), "tracker_db_size_bytes ")
+	require.Contains(t, rec.Body.String(), "tracker_wal_size_bytes ")
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"time"
+)
+
+const (
+	// MaxTrackingSubscriptions — сколько адресов можно подписать на одну посылку
+	MaxTrackingSubscriptions = 10
+	// subscriptionInterval — как часто подписчикам рассылаются смены статуса
+	subscriptionInterval = time.Minute
+	// subscriptionBatch — сколько уведомлений подписчикам ставится в outbox за проход
+	subscriptionBatch = 100
+)
+
+var (
+	ErrInvalidSubscription  = errors.New("некорректный адрес подписки")
+	ErrTooManySubscriptions = errors.New("на посылку подписано слишком много адресов")
+	ErrSubscriptionNotFound = errors.New("подписка не найдена")
+)
+
+// subscriptionText — тема и текст письма подписчику по языкам: код
+// отслеживания, название статуса и строка отписки
+var subscriptionText = map[string][2]string{
+	LocaleRU: {"Посылка %s: %s", "Новый статус посылки %s: %s.\n\n%s\n"},
+	LocaleEN: {"Parcel %s: %s", "New status of parcel %s: %s.\n\n%s\n"},
+}
+
+// unsubscribeText — строка отписки по языкам: со ссылкой и с одним токеном,
+// если адрес публичной страницы не задан
+var unsubscribeText = map[string][2]string{
+	LocaleRU: {"Отписаться: %s", "Токен для отписки: %s"},
+	LocaleEN: {"Unsubscribe: %s", "Unsubscribe token: %s"},
+}
+
+// TrackingSubscription — подписка адреса email на смены статуса посылки
+type TrackingSubscription struct {
+	ID     int    `json:"id"`
+	Parcel int    `json:"-"`
+	Email  string `json:"email"`
+	Locale string `json:"locale"`
+	// Token — токен отписки; хранится открытым, т.к. нужен для ссылки
+	// в каждом письме, и даёт только право отписать этот адрес
+	Token     string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Subscribe подписывает адрес email на смены статуса посылки. Письма
+// приходят о статусах после подписки. Повторная подписка того же адреса
+// выдаёт новый токен отписки.
+func (s ParcelStore) Subscribe(number int, email string, locale string) (TrackingSubscription, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return TrackingSubscription{}, fmt.Errorf("%w: %v", ErrInvalidSubscription, err)
+	}
+	if !IsSupportedLocale(locale) {
+		locale = DefaultLocale
+	}
+	token, err := newAPIKey()
+	if err != nil {
+		return TrackingSubscription{}, err
+	}
+	sub := TrackingSubscription{Parcel: number, Email: addr.Address, Locale: locale, Token: token, CreatedAt: s.now().UTC()}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return TrackingSubscription{}, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var count int
+	err = tx.QueryRow("SELECT p.status, (SELECT COUNT(*) FROM {tracking_subscription} WHERE parcel = p.number AND email <> :email) "+
+		"FROM {parcel} p WHERE p.number = :parcel",
+		sql.Named("parcel", number),
+		sql.Named("email", sub.Email)).Scan(&status, &count)
+	if err != nil {
+		return TrackingSubscription{}, err
+	}
+	if count >= MaxTrackingSubscriptions {
+		return TrackingSubscription{}, ErrTooManySubscriptions
+	}
+
+	err = tx.QueryRow("INSERT INTO {tracking_subscription} (parcel, email, locale, token, notified_status, created_at) "+
+		"VALUES (:parcel, :email, :locale, :token, :status, :created_at) "+
+		"ON CONFLICT (parcel, email) DO UPDATE SET locale = excluded.locale, token = excluded.token RETURNING id",
+		sql.Named("parcel", number),
+		sql.Named("email", sub.Email),
+		sql.Named("locale", locale),
+		sql.Named("token", token),
+		sql.Named("status", status),
+		sql.Named("created_at", formatTime(sub.CreatedAt))).Scan(&sub.ID)
+	if err != nil {
+		return TrackingSubscription{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return TrackingSubscription{}, err
+	}
+
+	return sub, nil
+}
+
+// Unsubscribe удаляет подписку на посылку по токену отписки
+func (s ParcelStore) Unsubscribe(number int, token string) error {
+	res, err := s.db.Exec("DELETE FROM {tracking_subscription} WHERE parcel = :parcel AND token = :token",
+		sql.Named("parcel", number),
+		sql.Named("token", token))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrSubscriptionNotFound
+	}
+
+	return nil
+}
+
+// ListSubscriptions возвращает подписки на посылку в порядке подписки
+func (s ParcelStore) ListSubscriptions(number int) ([]TrackingSubscription, error) {
+	rows, err := s.db.Query("SELECT id, parcel, email, locale, token, created_at FROM {tracking_subscription} "+
+		"WHERE parcel = :parcel ORDER BY id",
+		sql.Named("parcel", number))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []TrackingSubscription
+	for rows.Next() {
+		sub := TrackingSubscription{}
+		if err := rows.Scan(&sub.ID, &sub.Parcel, &sub.Email, &sub.Locale, &sub.Token, scanTime(&sub.CreatedAt)); err != nil {
+			return nil, err
+		}
+		res = append(res, sub)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// NotifySubscribers ставит в outbox письма до limit подписчикам, статус
+// посылки которых изменился с прошлого письма, и возвращает их число.
+// Подписчик получает только последний статус, даже если посылка сменила
+// несколько статусов между проходами.
+func (s ParcelStore) NotifySubscribers(limit int) (int, error) {
+	type pending struct {
+		sub    TrackingSubscription
+		code   string
+		status string
+	}
+
+	rows, err := s.db.Query("SELECT s.id, s.email, s.locale, s.token, p.uuid, p.status "+
+		"FROM {tracking_subscription} s JOIN {parcel} p ON p.number = s.parcel "+
+		"WHERE s.notified_status <> p.status ORDER BY s.id LIMIT :limit",
+		sql.Named("limit", limit))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var batch []pending
+	for rows.Next() {
+		n := pending{}
+		if err := rows.Scan(&n.sub.ID, &n.sub.Email, &n.sub.Locale, &n.sub.Token, &n.code, &n.status); err != nil {
+			return 0, err
+		}
+		batch = append(batch, n)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, n := range batch {
+		if err := s.enqueueOutbox(tx, TopicNotification, s.subscriptionNotification(n.sub, n.code, n.status)); err != nil {
+			return 0, err
+		}
+		_, err := tx.Exec("UPDATE {tracking_subscription} SET notified_status = :status WHERE id = :id",
+			sql.Named("status", n.status),
+			sql.Named("id", n.sub.ID))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(batch), nil
+}
+
+// subscriptionNotification формирует письмо подписчику о статусе status
+// посылки с кодом code
+func (s ParcelStore) subscriptionNotification(sub TrackingSubscription, code string, status string) Notification {
+	text, ok := subscriptionText[sub.Locale]
+	if !ok {
+		text = subscriptionText[DefaultLocale]
+	}
+	unsubscribe, ok := unsubscribeText[sub.Locale]
+	if !ok {
+		unsubscribe = unsubscribeText[DefaultLocale]
+	}
+
+	name := StatusName(sub.Locale, status)
+	line := fmt.Sprintf(unsubscribe[1], sub.Token)
+	if base := s.recipientTokens.baseURL; base != "" {
+		line = fmt.Sprintf(unsubscribe[0], base+"/track/"+code+"?unsubscribe="+url.QueryEscape(sub.Token))
+	}
+
+	return Notification{
+		Channel: ChannelEmail,
+		To:      sub.Email,
+		Subject: fmt.Sprintf(text[0], code, name),
+		Body:    fmt.Sprintf(text[1], code, name, line),
+	}
+}
+
+// RunSubscriptionNotifier каждые interval рассылает подписчикам смены
+// статуса посылок, пока не отменён ctx. Ошибки записываются в errors.
+func (s ParcelStore) RunSubscriptionNotifier(ctx context.Context, interval time.Duration, errors *ErrorLog) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.NotifySubscribers(subscriptionBatch); err != nil && ctx.Err() == nil {
+			errors.Record(err)
+		}
+	}
+}
+
+// NewSubscribeHandler возвращает публичный обработчик POST
+// /track/{code}/subscribe: JSON {"email"}. Подписаться может любой, кто
+// знает код отслеживания; язык писем — язык запроса.
+func NewSubscribeHandler(store ParcelStore, errLog *ErrorLog) http.Handler {
+	h := AdminHandler{store: store, errors: errLog}
+
+	return trackAction(store, errLog, "subscribe", func(w http.ResponseWriter, r *http.Request, p Parcel, body []byte) {
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "некорректный JSON", http.StatusBadRequest)
+			return
+		}
+
+		_, err := store.Subscribe(p.Number, req.Email, requestLocale(r))
+		switch {
+		case errors.Is(err, ErrInvalidSubscription):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrTooManySubscriptions):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
+
+// NewUnsubscribeHandler возвращает публичный обработчик POST
+// /track/{code}/unsubscribe: JSON {"token"}, где token — токен отписки
+// из письма подписчику
+func NewUnsubscribeHandler(store ParcelStore, errLog *ErrorLog) http.Handler {
+	h := AdminHandler{store: store, errors: errLog}
+
+	return trackAction(store, errLog, "unsubscribe", func(w http.ResponseWriter, r *http.Request, p Parcel, body []byte) {
+		var req struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "некорректный JSON", http.StatusBadRequest)
+			return
+		}
+
+		err := store.Unsubscribe(p.Number, req.Token)
+		switch {
+		case errors.Is(err, ErrSubscriptionNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			h.fail(w, r, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
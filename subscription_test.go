@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// pendingNotifications возвращает уведомления, ожидающие отправки в outbox
+func pendingNotifications(t *testing.T, store ParcelStore) []Notification {
+	t.Helper()
+
+	messages, err := store.PendingOutbox(100)
+	require.NoError(t, err)
+
+	var res []Notification
+	for _, m := range messages {
+		var n Notification
+		require.NoError(t, json.Unmarshal(m.Payload, &n))
+		res = append(res, n)
+	}
+
+	return res
+}
+
+// TestTrackingSubscription проверяет рассылку смен статуса подписчикам
+func TestTrackingSubscription(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithPublicBaseURL("https://track.example.com"))
+	parcel := getTestParcel()
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	// subscribe
+	_, err = store.Subscribe(number, "не адрес", LocaleRU)
+	require.ErrorIs(t, err, ErrInvalidSubscription)
+	ru, err := store.Subscribe(number, "Иван <ivan@example.com>", LocaleRU)
+	require.NoError(t, err)
+	require.Equal(t, "ivan@example.com", ru.Email)
+	en, err := store.Subscribe(number, "john@example.com", "de")
+	require.NoError(t, err)
+	require.Equal(t, DefaultLocale, en.Locale)
+
+	// без смены статуса писем нет
+	sent, err := store.NotifySubscribers(10)
+	require.NoError(t, err)
+	require.Zero(t, sent)
+
+	// notify
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+	sent, err = store.NotifySubscribers(10)
+	require.NoError(t, err)
+	require.Equal(t, 2, sent)
+
+	// check
+	notifications := pendingNotifications(t, store)
+	require.Len(t, notifications, 2)
+	require.Equal(t, "ivan@example.com", notifications[0].To)
+	require.Equal(t, "Посылка "+parcel.UUID+": Отправлена", notifications[0].Subject)
+	require.Contains(t, notifications[0].Body, "https://track.example.com/track/"+parcel.UUID+"?unsubscribe="+ru.Token)
+
+	// о том же статусе повторно не пишут
+	sent, err = store.NotifySubscribers(10)
+	require.NoError(t, err)
+	require.Zero(t, sent)
+
+	// unsubscribe
+	require.ErrorIs(t, store.Unsubscribe(number, "чужой"), ErrSubscriptionNotFound)
+	require.NoError(t, store.Unsubscribe(number, ru.Token))
+	subs, err := store.ListSubscriptions(number)
+	require.NoError(t, err)
+	require.Len(t, subs, 1)
+
+	require.NoError(t, store.SetStatus(number, ParcelStatusDelivered))
+	sent, err = store.NotifySubscribers(10)
+	require.NoError(t, err)
+	require.Equal(t, 1, sent)
+}
+
+// TestTrackingSubscriptionLimit проверяет ограничение числа подписчиков
+func TestTrackingSubscriptionLimit(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	var first TrackingSubscription
+	for i := 0; i < MaxTrackingSubscriptions; i++ {
+		sub, err := store.Subscribe(number, "user"+string(rune('a'+i))+"@example.com", LocaleRU)
+		require.NoError(t, err)
+		if i == 0 {
+			first = sub
+		}
+	}
+
+	// check
+	_, err = store.Subscribe(number, "extra@example.com", LocaleRU)
+	require.ErrorIs(t, err, ErrTooManySubscriptions)
+
+	// повторная подписка не добавляет адрес, а меняет токен отписки
+	again, err := store.Subscribe(number, "usera@example.com", LocaleEN)
+	require.NoError(t, err)
+	require.Equal(t, first.ID, again.ID)
+	require.ErrorIs(t, store.Unsubscribe(number, first.Token), ErrSubscriptionNotFound)
+	require.NoError(t, store.Unsubscribe(number, again.Token))
+}
+
+// TestSubscribeEndpoint проверяет подписку и отписку через публичное отслеживание
+func TestSubscribeEndpoint(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+	handler := NewHTTPHandler(store, NewErrorLog(10))
+
+	post := func(action string, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/track/"+parcel.UUID+"/"+action, strings.NewReader(body))
+		req.Header.Set("Accept-Language", "en")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// check
+	require.Equal(t, http.StatusBadRequest, post("subscribe", `{"email": "нет"}`))
+	require.Equal(t, http.StatusNoContent, post("subscribe", `{"email": "john@example.com"}`))
+
+	subs, err := store.ListSubscriptions(number)
+	require.NoError(t, err)
+	require.Len(t, subs, 1)
+	require.Equal(t, LocaleEN, subs[0].Locale)
+
+	require.Equal(t, http.StatusNotFound, post("unsubscribe", `{"token": "чужой"}`))
+	require.Equal(t, http.StatusNoContent, post("unsubscribe", `{"token": "`+subs[0].Token+`"}`))
+}
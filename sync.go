@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Виды изменений в ленте ListChanges
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+const (
+	// DefaultChangesLimit — сколько изменений ListChanges отдаёт за раз по умолчанию
+	DefaultChangesLimit = 100
+	// MaxChangesLimit — наибольший размер порции ListChanges
+	MaxChangesLimit = 1000
+)
+
+var ErrInvalidChangeFilter = errors.New("некорректный запрос ленты изменений")
+
+// ChangeCursor — позиция в ленте изменений. Клиент хранит позицию
+// последнего полученного изменения и передаёт её в следующий запрос;
+// нулевая позиция — начало ленты.
+type ChangeCursor int64
+
+// Change — изменение посылки в ленте. Для создания и изменения Parcel —
+// текущее состояние посылки, nil — посылку уже удалили, и её удаление
+// придёт дальше в ленте.
+type Change struct {
+	Cursor ChangeCursor `json:"cursor"`
+	Kind   string       `json:"kind"`
+	Number int          `json:"-"`
+	UUID   string       `json:"uuid"`
+	At     time.Time    `json:"at"`
+	Parcel *Parcel      `json:"parcel,omitempty"`
+}
+
+// ChangeFilter — условия выборки ленты изменений
+type ChangeFilter struct {
+	// Courier — только посылки, назначенные курьеру; 0 — все посылки.
+	// Для курьера создание — назначение ему посылки, удаление — удаление
+	// посылки или её передача другому курьеру.
+	Courier int
+	// Limit — размер порции, 0 — DefaultChangesLimit
+	Limit int
+}
+
+// ChangeBatch — порция ленты изменений
+type ChangeBatch struct {
+	Changes []Change `json:"changes"`
+	// Next — позиция для следующего запроса; если изменений нет, равна переданной
+	Next ChangeCursor `json:"next"`
+	// More — за порцией есть ещё изменения
+	More bool `json:"more"`
+}
+
+// ListChanges возвращает изменения посылок после позиции since в порядке
+// их записи. Лента возобновляемая: порции с позиции Next продолжают её
+// без пропусков и повторов, поэтому приложение курьера, работающее без
+// связи, досинхронизирует только то, что изменилось с прошлого раза.
+// Журнал ведут триггеры БД, так что в ленту попадают изменения из любого
+// кода и процесса.
+func (s ParcelStore) ListChanges(since ChangeCursor, f ChangeFilter) (ChangeBatch, error) {
+	if since < 0 || f.Courier < 0 || f.Limit < 0 || f.Limit > MaxChangesLimit {
+		return ChangeBatch{}, ErrInvalidChangeFilter
+	}
+	if f.Limit == 0 {
+		f.Limit = DefaultChangesLimit
+	}
+
+	where := "seq > :since"
+	args := []any{sql.Named("since", since)}
+	if f.Courier != 0 {
+		where += " AND courier = :courier"
+		args = append(args, sql.Named("courier", f.Courier))
+	}
+
+	// лишнее изменение показывает, что за порцией есть ещё
+	rows, err := s.db.Query("SELECT seq, parcel, uuid, kind, changed_at FROM {parcel_change} WHERE "+where+
+		" ORDER BY seq LIMIT :limit", append(args, sql.Named("limit", f.Limit+1))...)
+	if err != nil {
+		return ChangeBatch{}, err
+	}
+	defer rows.Close()
+
+	batch := ChangeBatch{Changes: []Change{}, Next: since}
+	for rows.Next() {
+		var c Change
+		if err := rows.Scan(&c.Cursor, &c.Number, &c.UUID, &c.Kind, scanTime(&c.At)); err != nil {
+			return ChangeBatch{}, err
+		}
+		batch.Changes = append(batch.Changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return ChangeBatch{}, err
+	}
+	rows.Close()
+
+	if len(batch.Changes) > f.Limit {
+		batch.Changes = batch.Changes[:f.Limit]
+		batch.More = true
+	}
+	if len(batch.Changes) == 0 {
+		return batch, nil
+	}
+	batch.Next = batch.Changes[len(batch.Changes)-1].Cursor
+
+	// текущие состояния посылок порции одним запросом
+	rows, err = s.db.Query(parcelSelect+
+		"WHERE number IN (SELECT parcel FROM {parcel_change} WHERE "+where+" AND seq <= :next AND kind <> :delete)",
+		append(args, sql.Named("next", batch.Next), sql.Named("delete", ChangeDelete))...)
+	if err != nil {
+		return ChangeBatch{}, err
+	}
+	parcels, err := scanParcels(rows)
+	if err != nil {
+		return ChangeBatch{}, err
+	}
+	byNumber := make(map[int]*Parcel, len(parcels))
+	for i := range parcels {
+		byNumber[parcels[i].Number] = &parcels[i]
+	}
+	for i, c := range batch.Changes {
+		if c.Kind != ChangeDelete {
+			batch.Changes[i].Parcel = byNumber[c.Number]
+		}
+	}
+
+	return batch, nil
+}
+
+// NewChangesHandler возвращает обработчик GET /courier/changes для
+// синхронизации приложения курьера. Параметры: courier, since — позиция
+// из next прошлого ответа, по умолчанию начало ленты, и limit.
+func NewChangesHandler(store ParcelStore, errLog *ErrorLog) http.Handler {
+	h := AdminHandler{store: store, errors: errLog}
+
+	return h.getOnly(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		courier, err := strconv.Atoi(q.Get("courier"))
+		if err != nil || courier <= 0 {
+			http.Error(w, "courier должен быть положительным числом", http.StatusBadRequest)
+			return
+		}
+
+		var since int64
+		if v := q.Get("since"); v != "" {
+			if since, err = strconv.ParseInt(v, 10, 64); err != nil {
+				http.Error(w, "since должен быть числом", http.StatusBadRequest)
+				return
+			}
+		}
+		var limit int
+		if v := q.Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "limit должен быть числом", http.StatusBadRequest)
+				return
+			}
+		}
+
+		batch, err := store.ListChanges(ChangeCursor(since), ChangeFilter{Courier: courier, Limit: limit})
+		if errors.Is(err, ErrInvalidChangeFilter) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		writeJSON(w, batch)
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// changeKinds возвращает виды изменений порции по кодам посылок
+func changeKinds(batch ChangeBatch) []string {
+	var res []string
+	for _, c := range batch.Changes {
+		res = append(res, c.Kind+" "+c.UUID)
+	}
+
+	return res
+}
+
+// TestListChanges проверяет ленту изменений и её возобновление с позиции
+func TestListChanges(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	first := getTestParcel()
+	second := getTestParcel()
+	n1, err := store.Add(first)
+	require.NoError(t, err)
+	n2, err := store.Add(second)
+	require.NoError(t, err)
+	require.NoError(t, store.SetAddress(n1, "new"))
+	require.NoError(t, store.Delete(n2))
+
+	// check
+	batch, err := store.ListChanges(0, ChangeFilter{Limit: 3})
+	require.NoError(t, err)
+	require.Equal(t, []string{"create " + first.UUID, "create " + second.UUID, "update " + first.UUID}, changeKinds(batch))
+	require.True(t, batch.More)
+	require.Equal(t, "new", batch.Changes[0].Parcel.Address)
+	// вторую посылку уже удалили
+	require.Nil(t, batch.Changes[1].Parcel)
+
+	rest, err := store.ListChanges(batch.Next, ChangeFilter{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"delete " + second.UUID}, changeKinds(rest))
+	require.False(t, rest.More)
+
+	empty, err := store.ListChanges(rest.Next, ChangeFilter{})
+	require.NoError(t, err)
+	require.Empty(t, empty.Changes)
+	require.Equal(t, rest.Next, empty.Next)
+
+	_, err = store.ListChanges(-1, ChangeFilter{})
+	require.ErrorIs(t, err, ErrInvalidChangeFilter)
+}
+
+// TestListChangesSameMillisecond проверяет, что в ленту попадает каждое
+// изменение посылки, даже сделанное в ту же миллисекунду, что и прошлое,
+// и только один раз
+func TestListChangesSameMillisecond(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	p := getTestParcel()
+	number, err := store.Add(p)
+	require.NoError(t, err)
+
+	// изменения идут подряд, многие приходятся на одну миллисекунду
+	const updates = 200
+	for i := 0; i < updates; i++ {
+		require.NoError(t, store.SetAddress(number, strconv.Itoa(i)))
+	}
+
+	// check
+	batch, err := store.ListChanges(0, ChangeFilter{Limit: MaxChangesLimit})
+	require.NoError(t, err)
+	require.Equal(t, updates+1, len(batch.Changes))
+	require.Equal(t, "create "+p.UUID, changeKinds(batch)[0])
+}
+
+// TestListChangesCourier проверяет ленту курьера: назначение, изменение
+// и передачу посылки другому курьеру
+func TestListChangesCourier(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	p := getTestParcel()
+	number, err := store.Add(p)
+	require.NoError(t, err)
+	a := Assignment{Parcel: number, Courier: 1, Date: time.Now(), Latitude: 55.75, Longitude: 37.62}
+	require.NoError(t, store.AssignCourier(a))
+	require.NoError(t, store.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+	a.Courier = 2
+	require.NoError(t, store.AssignCourier(a))
+
+	// check
+	batch, err := store.ListChanges(0, ChangeFilter{Courier: 1})
+	require.NoError(t, err)
+	require.Equal(t, []string{"create " + p.UUID, "update " + p.UUID, "delete " + p.UUID}, changeKinds(batch))
+	require.Equal(t, ParcelStatusSent, batch.Changes[1].Parcel.Status)
+
+	batch, err = store.ListChanges(0, ChangeFilter{Courier: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"create " + p.UUID}, changeKinds(batch))
+
+	h := NewChangesHandler(store, NewErrorLog(10))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/courier/changes?courier=2", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"kind":"create"`)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/courier/changes", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	TimelineStatus     = "status"
+	TimelineScan       = "scan"
+	TimelineNote       = "note"
+	TimelineAttachment = "attachment"
+)
+
+const (
+	// ScanPickupArrival — посылка поступила в пункт выдачи
+	ScanPickupArrival = "pickup_arrival"
+	// ScanCarrierHandover — посылка передана внешнему перевозчику
+	ScanCarrierHandover = "carrier_handover"
+	// ScanDamageReport — зафиксировано повреждение
+	ScanDamageReport = "damage_report"
+)
+
+// TimelineEntry — событие в истории посылки. Смысл Code зависит от Kind:
+// статус, вид сканирования, видимость заметки или назначение вложения.
+type TimelineEntry struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	Code string    `json:"code"`
+	Text string    `json:"text,omitempty"`
+	// Ref — идентификатор заметки или вложения
+	Ref int `json:"ref,omitempty"`
+}
+
+// GetTimeline собирает историю посылки из смен статуса, сканирований,
+// заметок и вложений в хронологическом порядке. Регистрация, отправка и
+// доставка берутся из времени посылки, остальные смены статуса — из журнала
+// изменений, поэтому видны только изменения, записанные в журнал, см.
+// WithAuditLog.
+// Заметки возвращаются любой видимости, фильтрует вызывающий.
+func (s ParcelStore) GetTimeline(number int) ([]TimelineEntry, error) {
+	p, err := s.Get(number)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []TimelineEntry
+	for _, e := range []TimelineEntry{
+		{Code: ParcelStatusRegistered, Time: p.CreatedAt},
+		{Code: ParcelStatusSent, Time: p.SentAt},
+		{Code: ParcelStatusDelivered, Time: p.DeliveredAt},
+	} {
+		if e.Time.IsZero() {
+			continue
+		}
+		e.Kind = TimelineStatus
+		res = append(res, e)
+	}
+
+	changes, err := s.auditStatusChanges(number)
+	if err != nil {
+		return nil, err
+	}
+	res = append(res, changes...)
+
+	scans, err := s.scanEvents(number)
+	if err != nil {
+		return nil, err
+	}
+	res = append(res, scans...)
+
+	notes, err := s.ListNotes(number, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range notes {
+		res = append(res, TimelineEntry{Time: n.CreatedAt, Kind: TimelineNote, Code: n.Visibility, Text: n.Text, Ref: n.ID})
+	}
+
+	attachments, err := s.ListAttachments(number, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attachments {
+		res = append(res, TimelineEntry{Time: a.CreatedAt, Kind: TimelineAttachment, Code: a.Kind, Text: a.Name, Ref: a.ID})
+	}
+
+	// события одного момента остаются в порядке источников
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Time.Before(res[j].Time)
+	})
+
+	return res, nil
+}
+
+// auditStatusChanges возвращает из журнала изменений смены статуса,
+// которых нет среди времени посылки: утерю, повреждение, возврат, отмену
+func (s ParcelStore) auditStatusChanges(number int) ([]TimelineEntry, error) {
+	entries, err := s.ListAudit(AuditFilter{Parcel: number})
+	if err != nil {
+		return nil, err
+	}
+
+	var res []TimelineEntry
+	for _, e := range entries {
+		if e.Action != AuditSetStatus && e.Action != AuditTransition {
+			continue
+		}
+
+		var before, after Parcel
+		if err := json.Unmarshal(e.Before, &before); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(e.After, &after); err != nil {
+			return nil, err
+		}
+		if before.Status == after.Status || !offPathStatuses[after.Status] {
+			continue
+		}
+
+		res = append(res, TimelineEntry{Time: e.At, Kind: TimelineStatus, Code: after.Status})
+	}
+
+	return res, nil
+}
+
+// scanEvents возвращает события обработки посылки: поступление в пункт
+// выдачи, передачу перевозчику и фиксацию повреждения
+func (s ParcelStore) scanEvents(number int) ([]TimelineEntry, error) {
+	var res []TimelineEntry
+	for _, q := range []struct {
+		code  string
+		query string
+	}{
+		{ScanPickupArrival, "SELECT arrived_at, '' FROM {pickup_arrival} WHERE parcel = :parcel"},
+		{ScanCarrierHandover, "SELECT created_at, carrier FROM {carrier_shipment} WHERE parcel = :parcel"},
+		{ScanDamageReport, "SELECT reported_at, description FROM {damage_report} WHERE parcel = :parcel"},
+	} {
+		e := TimelineEntry{Kind: TimelineScan, Code: q.code}
+		err := s.db.QueryRow(q.query, sql.Named("parcel", number)).Scan(scanTime(&e.Time), &e.Text)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+
+	return res, nil
+}
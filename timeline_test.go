@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestGetTimeline проверяет сборку истории посылки из разных источников
+func TestGetTimeline(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	audited := NewAuditedStorage(store, store, "operator")
+
+	number, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	// время хранится с точностью до миллисекунды, шаги разносим во времени
+	step := func() { time.Sleep(5 * time.Millisecond) }
+
+	step()
+	require.NoError(t, audited.TransitionStatus(number, ParcelStatusRegistered, ParcelStatusSent))
+	step()
+	require.NoError(t, store.ReportDamage(number, "вмятина", []Attachment{getTestPhoto()}))
+	step()
+	_, err = store.AddNote(Note{Parcel: number, Author: "support", Text: "клиент позвонил", Visibility: NoteCustomer})
+	require.NoError(t, err)
+	step()
+	require.NoError(t, audited.SetStatus(number, ParcelStatusLost))
+
+	// check
+	timeline, err := store.GetTimeline(number)
+	require.NoError(t, err)
+
+	var got [][2]string
+	for i, e := range timeline {
+		got = append(got, [2]string{e.Kind, e.Code})
+		if i > 0 {
+			require.False(t, e.Time.Before(timeline[i-1].Time))
+		}
+	}
+	require.Equal(t, [][2]string{
+		{TimelineStatus, ParcelStatusRegistered},
+		{TimelineStatus, ParcelStatusSent},
+		{TimelineScan, ScanDamageReport},
+		{TimelineAttachment, AttachmentKindDamagePhoto},
+		{TimelineNote, NoteCustomer},
+		{TimelineStatus, ParcelStatusLost},
+	}, got)
+	require.Equal(t, "вмятина", timeline[2].Text)
+	require.Equal(t, "клиент позвонил", timeline[4].Text)
+}
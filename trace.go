@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// traceRedacted — значение параметра с персональными данными в трассировке
+const traceRedacted = "[скрыто]"
+
+var ErrNoStatementTracer = errors.New("трассировка запросов не настроена, см. WithStatementTracer")
+
+// tracePIIArgs — именованные параметры запросов с персональными данными
+// и секретами; их значения в трассировку не попадают
+var tracePIIArgs = map[string]bool{
+	"address":         true,
+	"api_key_hash":    true,
+	"body":            true,
+	"changes":         true,
+	"code_hash":       true,
+	"contents":        true,
+	"data":            true,
+	"email":           true,
+	"latitude":        true,
+	"longitude":       true,
+	"metadata":        true,
+	"name":            true,
+	"note":            true,
+	"notes":           true,
+	"otp_hash":        true,
+	"payload":         true,
+	"postal_code":     true,
+	"recipient_phone": true,
+	"secret":          true,
+	"sender_email":    true,
+	"signature":       true,
+	"signer_name":     true,
+	"text":            true,
+	"token":           true,
+}
+
+// StatementTracer в отладочном режиме пишет в журнал каждый запрос
+// хранилища: текст, параметры без персональных данных, план EXPLAIN QUERY
+// PLAN, время выполнения и идентификатор HTTP-запроса, если он есть.
+// Режим включается и выключается на ходу, см. SetEnabled; выключенная
+// трассировка стоит одной атомарной проверки на запрос.
+type StatementTracer struct {
+	enabled atomic.Bool
+	logger  *log.Logger
+}
+
+// NewStatementTracer возвращает выключенную трассировку в журнал logger
+func NewStatementTracer(logger *log.Logger) *StatementTracer {
+	return &StatementTracer{logger: logger}
+}
+
+// WithStatementTracer подключает к хранилищу трассировку запросов t
+func WithStatementTracer(t *StatementTracer) StoreOption {
+	return func(s *ParcelStore) {
+		s.tracer = t
+	}
+}
+
+// SetEnabled включает или выключает трассировку
+func (t *StatementTracer) SetEnabled(enabled bool) {
+	t.enabled.Store(enabled)
+}
+
+// Enabled сообщает, включена ли трассировка; nil — трассировки нет
+func (t *StatementTracer) Enabled() bool {
+	return t != nil && t.enabled.Load()
+}
+
+// statementTrace — трассировка одного запроса между началом и окончанием
+type statementTrace struct {
+	tracer *StatementTracer
+	ctx    context.Context
+	query  string
+	args   []any
+	plan   string
+	start  time.Time
+}
+
+// begin начинает трассировку запроса query, если она включена; план
+// запрашивается через explain до выполнения запроса, чтобы не попасть
+// в его время. nil — трассировка выключена.
+func (t *StatementTracer) begin(ctx context.Context, explain func(query string, args ...any) (*sql.Rows, error),
+	query string, args []any) *statementTrace {
+	if !t.Enabled() {
+		return nil
+	}
+
+	return &statementTrace{
+		tracer: t,
+		ctx:    ctx,
+		query:  query,
+		args:   args,
+		plan:   queryPlan(explain, query, args),
+		start:  time.Now(),
+	}
+}
+
+// finish пишет трассировку запроса, завершившегося с ошибкой err
+func (st *statementTrace) finish(err error) {
+	if st == nil {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "sql %s", time.Since(st.start))
+	if id := RequestIDFromContext(st.ctx); id != "" {
+		fmt.Fprintf(&b, " request_id=%s", id)
+	}
+	fmt.Fprintf(&b, " query=%q args=[%s] plan=%q", strings.Join(strings.Fields(st.query), " "), redactArgs(st.args), st.plan)
+	if err != nil {
+		fmt.Fprintf(&b, " error=%q", err.Error())
+	}
+	st.tracer.logger.Print(b.String())
+}
+
+// queryPlan возвращает строки EXPLAIN QUERY PLAN запроса через "; ".
+// План нужен только для чтения человеком, поэтому ошибка, например у
+// DDL, записывается вместо плана.
+func queryPlan(explain func(query string, args ...any) (*sql.Rows, error), query string, args []any) string {
+	rows, err := explain("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return "нет плана: " + err.Error()
+	}
+	defer rows.Close()
+
+	var steps []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return "нет плана: " + err.Error()
+		}
+		steps = append(steps, detail)
+	}
+	if err := rows.Err(); err != nil {
+		return "нет плана: " + err.Error()
+	}
+
+	return strings.Join(steps, "; ")
+}
+
+// redactArgs перечисляет параметры запроса, скрывая значения параметров
+// из tracePIIArgs. У позиционных параметров имени нет, поэтому строки
+// среди них скрываются всегда.
+func redactArgs(args []any) string {
+	parts := make([]string, 0, len(args))
+	for _, a := range args {
+		named, ok := a.(sql.NamedArg)
+		if !ok {
+			parts = append(parts, redactValue(a, true))
+			continue
+		}
+		parts = append(parts, named.Name+"="+redactValue(named.Value, tracePIIArgs[named.Name]))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// redactValue возвращает значение параметра для трассировки
+func redactValue(v any, pii bool) string {
+	switch v := v.(type) {
+	case []byte:
+		return fmt.Sprintf("[%d байт]", len(v))
+	case string:
+		if pii && v != "" {
+			return traceRedacted
+		}
+		return fmt.Sprintf("%q", v)
+	case nil:
+		return "NULL"
+	default:
+		if pii {
+			return traceRedacted
+		}
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatementTracer(t *testing.T) {
+	// prepare
+	var buf bytes.Buffer
+	tracer := NewStatementTracer(log.New(&buf, "", 0))
+	store := NewParcelStore(openTestDB(t), WithStatementTracer(tracer))
+	parcel := getTestParcel()
+	parcel.Address = "Москва, ул. Тверская, 1"
+
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+	require.Empty(t, buf.String())
+
+	tracer.SetEnabled(true)
+	ctx := WithRequestID(context.Background(), "req-42")
+	_, err = store.GetContext(ctx, number)
+	require.NoError(t, err)
+	parcel.UUID = getTestParcel().UUID
+	_, err = store.Add(parcel)
+	require.NoError(t, err)
+
+	// check
+	out := buf.String()
+	require.Contains(t, out, "request_id=req-42")
+	require.Contains(t, out, "number="+strconv.Itoa(number))
+	require.Contains(t, out, "SEARCH")
+	require.Contains(t, out, "address="+traceRedacted)
+	require.NotContains(t, out, "Тверская")
+
+	buf.Reset()
+	tracer.SetEnabled(false)
+	_, err = store.Get(number)
+	require.NoError(t, err)
+	require.Empty(t, buf.String())
+}
+
+func TestRedactArgs(t *testing.T) {
+	require.Equal(t, `client=1000 recipient_phone=[скрыто] status="sent" [скрыто] [3 байт] NULL`,
+		redactArgs([]any{
+			sql.Named("client", 1000),
+			sql.Named("recipient_phone", "+79990001122"),
+			sql.Named("status", "sent"),
+			"позиционный",
+			[]byte("abc"),
+			nil,
+		}))
+}
+
+func TestAdminStatementTracing(t *testing.T) {
+	// prepare
+	tracer := NewStatementTracer(log.New(&bytes.Buffer{}, "", 0))
+	handler := NewAdminHandler(NewParcelStore(openTestDB(t), WithStatementTracer(tracer)), NewErrorLog(10))
+	post := func(enabled string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/debug/sql",
+			strings.NewReader(url.Values{"enabled": {enabled}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// check
+	require.Equal(t, http.StatusBadRequest, post("maybe").Code)
+
+	rec := post("true")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"enabled":true}`, rec.Body.String())
+	require.True(t, tracer.Enabled())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/debug/sql", nil))
+	require.JSONEq(t, `{"enabled":true}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	NewAdminHandler(NewParcelStore(openTestDB(t)), NewErrorLog(10)).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/debug/sql", nil))
+	require.Equal(t, http.StatusConflict, rec.Code)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrackingEvent — этап пути посылки в публичном отслеживании
+type TrackingEvent struct {
+	Status     string    `json:"status"`
+	StatusName string    `json:"status_name"`
+	Time       time.Time `json:"time"`
+}
+
+// TrackingInfo — сведения о посылке, которые можно показать любому,
+// кто знает код отслеживания. Полного адреса и клиента здесь нет.
+type TrackingInfo struct {
+	Code       string `json:"code"`
+	Status     string `json:"status"`
+	StatusName string `json:"status_name"`
+	Country    string `json:"country,omitempty"`
+	City       string `json:"city,omitempty"`
+	// ETA — ожидаемый срок доставки, пока посылка в пути
+	ETA      *time.Time      `json:"eta,omitempty"`
+	Timeline []TrackingEvent `json:"timeline"`
+	Notes    []TrackingNote  `json:"notes,omitempty"`
+}
+
+// TrackingNote — заметка поддержки, открытая клиенту; автор не раскрывается
+type TrackingNote struct {
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+// NewTrackingInfo оставляет от посылки публичные сведения с названиями
+// статусов на языке locale, см. TrackingView
+func NewTrackingInfo(p Parcel, locale string) TrackingInfo {
+	return NewTrackingView(p, nil).Info(locale)
+}
+
+// requestLocale выбирает язык ответа: параметр lang важнее Accept-Language
+func requestLocale(r *http.Request) string {
+	if lang := r.URL.Query().Get("lang"); IsSupportedLocale(lang) {
+		return lang
+	}
+
+	return NegotiateLocale(r.Header.Get("Accept-Language"))
+}
+
+// NewTrackHandler возвращает публичный обработчик GET /track/{code}.
+// Он не требует аутентификации, поэтому отдаёт только TrackingInfo и
+// одинаково отвечает 404 на несуществующий и некорректный код. Посылки
+// читаются через кэш, если он подключён, см. WithParcelCache. Действия
+// /track/{code}/{action} передаются обработчикам trackActions.
+func NewTrackHandler(store ParcelStore, errLog *ErrorLog) http.Handler {
+	h := AdminHandler{store: store, errors: errLog}
+	var decorators []StorageDecorator
+	if store.cache != nil {
+		decorators = append(decorators, WithCache(store.cache))
+	}
+	parcels := Chain(store, decorators...)
+	actions := trackActions(store, errLog)
+
+	track := h.getOnly(func(w http.ResponseWriter, r *http.Request) {
+		code := strings.TrimPrefix(r.URL.Path, "/track/")
+		if _, err := uuid.Parse(code); err != nil || strings.Contains(code, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		info, err := trackingInfo(r.Context(), store, parcels, strings.ToLower(code), requestLocale(r))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		// виджет отслеживания встраивается на сайт с другого домена
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Language", requestLocale(r))
+		w.Header().Set("Vary", "Accept-Language")
+		// виджет опрашивает страницу, неизменившийся ответ не передаётся повторно
+		writeJSONConditional(w, r, info)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/track/"), "/")
+		if action == "" {
+			track(w, r)
+			return
+		}
+		if next, ok := actions[action]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	})
+}
+
+// trackingInfo возвращает публичные сведения о посылке с кодом code: из
+// проекции, если она включена и посылка в ней есть, иначе из parcels и заметок
+func trackingInfo(ctx context.Context, store ParcelStore, parcels ParcelStorageV2, code string, locale string) (TrackingInfo, error) {
+	if store.trackingView {
+		v, err := store.GetTrackingView(ctx, code)
+		if err == nil {
+			return v.Info(locale), nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return TrackingInfo{}, err
+		}
+	}
+
+	p, err := parcels.GetByUUIDContext(ctx, code)
+	if err != nil {
+		return TrackingInfo{}, err
+	}
+	notes, err := store.ListNotes(p.Number, NoteCustomer)
+	if err != nil {
+		return TrackingInfo{}, err
+	}
+
+	info := NewTrackingInfo(p, locale)
+	for _, n := range notes {
+		info.Notes = append(info.Notes, TrackingNote{Text: n.Text, Time: n.CreatedAt})
+	}
+
+	return info, nil
+}
+
+// trackActions возвращает обработчики публичных действий по имени
+// действия в пути /track/{code}/{action}. Подписаться может любой, кто
+// знает код, остальные действия требуют токена получателя.
+func trackActions(store ParcelStore, errLog *ErrorLog) map[string]http.Handler {
+	return map[string]http.Handler{
+		"subscribe":       NewSubscribeHandler(store, errLog),
+		"unsubscribe":     NewUnsubscribeHandler(store, errLog),
+		"instructions":    NewDeliveryInstructionsHandler(store, errLog),
+		"window":          NewDeliveryWindowHandler(store, errLog),
+		"redirect":        NewRedirectRequestHandler(store, errLog),
+		"redirect/verify": NewRedirectVerifyHandler(store, errLog),
+	}
+}
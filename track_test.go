@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTrackEndpoint проверяет публичное отслеживание посылки
+func TestTrackEndpoint(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	parcel.Address = "Псков, ул. Колотушкина, д. 5"
+	parcel.Country = "RU"
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(id, ParcelStatusSent))
+	_, err = store.AddNote(Note{Parcel: id, Author: "support", Text: "звонок клиента", Visibility: NoteInternal})
+	require.NoError(t, err)
+	_, err = store.AddNote(Note{Parcel: id, Author: "support", Text: "доставка завтра", Visibility: NoteCustomer})
+	require.NoError(t, err)
+	handler := NewHTTPHandler(store, NewErrorLog(10))
+
+	// request
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/track/"+parcel.UUID, nil))
+
+	// check
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotContains(t, rec.Body.String(), "Колотушкина")
+	require.NotContains(t, rec.Body.String(), "client")
+	require.NotContains(t, rec.Body.String(), "звонок клиента")
+	require.NotContains(t, rec.Body.String(), "support")
+
+	var info TrackingInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	require.Equal(t, parcel.UUID, info.Code)
+	require.Equal(t, ParcelStatusSent, info.Status)
+	require.Equal(t, "Отправлена", info.StatusName)
+	require.Equal(t, "Псков", info.City)
+	require.Len(t, info.Timeline, 2)
+	require.Equal(t, ParcelStatusRegistered, info.Timeline[0].Status)
+	require.True(t, parcel.CreatedAt.Equal(info.Timeline[0].Time))
+	require.Len(t, info.Notes, 1)
+	require.Equal(t, "доставка завтра", info.Notes[0].Text)
+}
+
+// TestTrackLocale проверяет выбор языка публичного отслеживания
+func TestTrackLocale(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	_, err := store.Add(parcel)
+	require.NoError(t, err)
+	handler := NewHTTPHandler(store, NewErrorLog(10))
+
+	// check
+	req := httptest.NewRequest(http.MethodGet, "/track/"+parcel.UUID, nil)
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, LocaleEN, rec.Header().Get("Content-Language"))
+
+	var info TrackingInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	require.Equal(t, "Registered", info.StatusName)
+	require.Equal(t, "Registered", info.Timeline[0].StatusName)
+
+	// параметр lang важнее заголовка
+	req = httptest.NewRequest(http.MethodGet, "/track/"+parcel.UUID+"?lang=ru", nil)
+	req.Header.Set("Accept-Language", "en")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	require.Equal(t, "Зарегистрирована", info.StatusName)
+}
+
+// TestTrackNotFound проверяет ответ на неизвестный и некорректный код
+func TestTrackNotFound(t *testing.T) {
+	// prepare
+	handler := NewHTTPHandler(NewParcelStore(openTestDB(t)), NewErrorLog(10))
+
+	// check
+	for _, target := range []string{"/track/" + uuid.NewString(), "/track/1", "/track/"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, http.StatusNotFound, rec.Code, target)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/track/"+uuid.NewString(), nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// TestTrackNotModified проверяет ответ 304 на неизменившуюся посылку
+func TestTrackNotModified(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	parcel := getTestParcel()
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+	handler := NewHTTPHandler(store, NewErrorLog(10))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/track/"+parcel.UUID, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	require.True(t, strings.HasPrefix(etag, `W/"`), etag)
+
+	// request
+	req := httptest.NewRequest(http.MethodGet, "/track/"+parcel.UUID, nil)
+	req.Header.Set("If-None-Match", `"other", `+etag)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// check
+	require.Equal(t, http.StatusNotModified, rec.Code)
+	require.Empty(t, rec.Body.String())
+
+	// после смены статуса ответ другой
+	require.NoError(t, store.SetStatus(id, ParcelStatusSent))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotEqual(t, etag, rec.Header().Get("ETag"))
+}
+
+// FuzzTrackHandler проверяет, что публичное отслеживание отвечает на любой
+// код только 200 или 404 и не отдаёт чужую посылку
+func FuzzTrackHandler(f *testing.F) {
+	store := NewParcelStore(openTestDB(f))
+	parcel := getTestParcel()
+	_, err := store.Add(parcel)
+	require.NoError(f, err)
+	errLog := NewErrorLog(10)
+	handler := NewTrackHandler(store, errLog)
+
+	f.Add(parcel.UUID)
+	f.Add(strings.ToUpper(parcel.UUID))
+	f.Add("urn:uuid:" + parcel.UUID)
+	f.Add(parcel.UUID + "/notes")
+	f.Add("' OR 1=1 --")
+
+	f.Fuzz(func(t *testing.T, code string) {
+		req := httptest.NewRequest(http.MethodGet, "/track/", nil)
+		req.URL.Path += code
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.Contains(t, []int{http.StatusOK, http.StatusNotFound}, rec.Code)
+		if rec.Code == http.StatusOK {
+			require.True(t, strings.EqualFold(parcel.UUID, code), code)
+		}
+		require.Empty(t, errLog.Recent())
+	})
+}
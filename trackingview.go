@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// trackingViewInterval — как часто проекция отслеживания догоняет журнал изменений
+	trackingViewInterval = 5 * time.Second
+	// trackingViewBatch — сколько изменений проекция применяет за проход
+	trackingViewBatch = 500
+)
+
+// Позиции проекции отслеживания в {projection_cursor}
+const (
+	trackingChangesCursor = "tracking_view"
+	trackingNotesCursor   = "tracking_view_notes"
+)
+
+// TrackingView — строка проекции {tracking_view}: всё, что нужно
+// публичному отслеживанию, без соединений таблиц. Названия статусов
+// зависят от языка запроса и получаются при чтении, см. Info.
+type TrackingView struct {
+	Number      int
+	Code        string
+	Status      string
+	Country     string
+	City        string
+	CreatedAt   time.Time
+	SentAt      time.Time
+	DeliveredAt time.Time
+	// ETA — ожидаемый срок доставки по SLA, нулевой для завершённой посылки
+	ETA time.Time
+	// Courier — курьер, которому назначена посылка; наружу не отдаётся
+	Courier   int
+	Notes     []TrackingNote
+	UpdatedAt time.Time
+}
+
+// NewTrackingView составляет строку проекции по посылке и открытым клиенту
+// заметкам. Город берётся из начала адреса до первой запятой, адрес
+// записывается от города к дому.
+func NewTrackingView(p Parcel, notes []TrackingNote) TrackingView {
+	city, _, _ := strings.Cut(p.Address, ",")
+
+	v := TrackingView{
+		Number:      p.Number,
+		Code:        p.UUID,
+		Status:      p.Status,
+		Country:     p.Country,
+		City:        strings.TrimSpace(city),
+		CreatedAt:   p.CreatedAt,
+		SentAt:      p.SentAt,
+		DeliveredAt: p.DeliveredAt,
+		Notes:       notes,
+		UpdatedAt:   p.UpdatedAt,
+	}
+	if sla, err := SLA(p.ServiceLevel); err == nil && p.Status != ParcelStatusDelivered && !offPathStatuses[p.Status] {
+		v.ETA = p.CreatedAt.Add(sla)
+	}
+
+	return v
+}
+
+// Info возвращает публичные сведения с названиями статусов на языке locale
+func (v TrackingView) Info(locale string) TrackingInfo {
+	info := TrackingInfo{
+		Code:       v.Code,
+		Status:     v.Status,
+		StatusName: StatusName(locale, v.Status),
+		Country:    v.Country,
+		City:       v.City,
+		Notes:      v.Notes,
+	}
+	if !v.ETA.IsZero() {
+		eta := v.ETA
+		info.ETA = &eta
+	}
+	for _, e := range []TrackingEvent{
+		{Status: ParcelStatusRegistered, Time: v.CreatedAt},
+		{Status: ParcelStatusSent, Time: v.SentAt},
+		{Status: ParcelStatusDelivered, Time: v.DeliveredAt},
+	} {
+		if e.Time.IsZero() {
+			continue
+		}
+		e.StatusName = StatusName(locale, e.Status)
+		info.Timeline = append(info.Timeline, e)
+	}
+
+	return info
+}
+
+// WithTrackingView переключает публичное отслеживание на проекцию
+// {tracking_view}: ответ читается одной строкой. Проекцию обновляет
+// RunTrackingProjection, поэтому ответ отстаёт от посылки на интервал
+// проекции; посылки, которых в проекции ещё нет, читаются как без неё.
+func WithTrackingView() StoreOption {
+	return func(s *ParcelStore) {
+		s.trackingView = true
+	}
+}
+
+// GetTrackingView возвращает строку проекции по коду отслеживания
+func (s ParcelStore) GetTrackingView(ctx context.Context, code string) (TrackingView, error) {
+	v := TrackingView{Code: code}
+	var notes string
+	err := s.db.QueryRowContext(ctx, "SELECT parcel, status, country, city, created_at, sent_at, delivered_at, eta, "+
+		"courier, notes, updated_at FROM {tracking_view} WHERE uuid = :uuid",
+		sql.Named("uuid", code)).Scan(&v.Number, &v.Status, &v.Country, &v.City, scanTime(&v.CreatedAt),
+		scanTime(&v.SentAt), scanTime(&v.DeliveredAt), scanTime(&v.ETA), &v.Courier, &notes, scanTime(&v.UpdatedAt))
+	if err != nil {
+		return TrackingView{}, err
+	}
+	if err := json.Unmarshal([]byte(notes), &v.Notes); err != nil {
+		return TrackingView{}, err
+	}
+
+	return v, nil
+}
+
+// ProjectTracking применяет к проекции {tracking_view} до limit изменений
+// посылок из {parcel_change} и открытых клиенту заметок и возвращает число
+// обновлённых посылок. Строка посылки каждый раз собирается заново из
+// текущего состояния, поэтому повтор изменения ничего не портит.
+func (s ParcelStore) ProjectTracking(limit int) (int, error) {
+	changes, err := s.projectionCursor(trackingChangesCursor)
+	if err != nil {
+		return 0, err
+	}
+	notes, err := s.projectionCursor(trackingNotesCursor)
+	if err != nil {
+		return 0, err
+	}
+
+	parcels := map[int]bool{}
+	var order []int
+	collect := func(query string, cursor *int) error {
+		rows, err := s.db.Query(query, sql.Named("cursor", *cursor), sql.Named("limit", limit))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var number int
+			if err := rows.Scan(cursor, &number); err != nil {
+				return err
+			}
+			if !parcels[number] {
+				parcels[number] = true
+				order = append(order, number)
+			}
+		}
+
+		return rows.Err()
+	}
+	if err := collect("SELECT seq, parcel FROM {parcel_change} WHERE seq > :cursor ORDER BY seq LIMIT :limit", &changes); err != nil {
+		return 0, err
+	}
+	err = collect("SELECT id, parcel FROM {note} WHERE id > :cursor AND visibility = '"+NoteCustomer+"' ORDER BY id LIMIT :limit", &notes)
+	if err != nil {
+		return 0, err
+	}
+
+	views := make([]*TrackingView, 0, len(order))
+	for _, number := range order {
+		v, err := s.buildTrackingView(number)
+		if err != nil {
+			return 0, err
+		}
+		views = append(views, v)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for i, v := range views {
+		if v == nil {
+			_, err := tx.Exec("DELETE FROM {tracking_view} WHERE parcel = :parcel", sql.Named("parcel", order[i]))
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := saveTrackingView(tx, *v); err != nil {
+			return 0, err
+		}
+	}
+	for name, position := range map[string]int{trackingChangesCursor: changes, trackingNotesCursor: notes} {
+		_, err := tx.Exec("INSERT INTO {projection_cursor} (name, position) VALUES (:name, :position) "+
+			"ON CONFLICT (name) DO UPDATE SET position = excluded.position",
+			sql.Named("name", name),
+			sql.Named("position", position))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(views), nil
+}
+
+// projectionCursor возвращает позицию проекции name, 0 — проекция не начата
+func (s ParcelStore) projectionCursor(name string) (int, error) {
+	var position int
+	err := s.db.QueryRow("SELECT position FROM {projection_cursor} WHERE name = :name",
+		sql.Named("name", name)).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+
+	return position, err
+}
+
+// buildTrackingView собирает строку проекции посылки number, nil —
+// посылка удалена
+func (s ParcelStore) buildTrackingView(number int) (*TrackingView, error) {
+	p, err := s.Get(number)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := s.ListNotes(number, NoteCustomer)
+	if err != nil {
+		return nil, err
+	}
+	public := []TrackingNote{}
+	for _, n := range notes {
+		public = append(public, TrackingNote{Text: n.Text, Time: n.CreatedAt})
+	}
+
+	v := NewTrackingView(p, public)
+	err = s.db.QueryRow("SELECT COALESCE((SELECT courier FROM {delivery_assignment} WHERE parcel = :parcel), 0)",
+		sql.Named("parcel", number)).Scan(&v.Courier)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// saveTrackingView записывает строку проекции
+func saveTrackingView(db execer, v TrackingView) error {
+	notes, err := json.Marshal(v.Notes)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO {tracking_view} (parcel, uuid, status, country, city, created_at, sent_at, "+
+		"delivered_at, eta, courier, notes, updated_at) VALUES (:parcel, :uuid, :status, :country, :city, "+
+		":created_at, :sent_at, :delivered_at, :eta, :courier, :notes, :updated_at) "+
+		"ON CONFLICT (parcel) DO UPDATE SET status = excluded.status, country = excluded.country, "+
+		"city = excluded.city, sent_at = excluded.sent_at, delivered_at = excluded.delivered_at, eta = excluded.eta, "+
+		"courier = excluded.courier, notes = excluded.notes, updated_at = excluded.updated_at",
+		sql.Named("parcel", v.Number),
+		sql.Named("uuid", v.Code),
+		sql.Named("status", v.Status),
+		sql.Named("country", v.Country),
+		sql.Named("city", v.City),
+		sql.Named("created_at", formatTime(v.CreatedAt)),
+		sql.Named("sent_at", formatTime(v.SentAt)),
+		sql.Named("delivered_at", formatTime(v.DeliveredAt)),
+		sql.Named("eta", formatTime(v.ETA)),
+		sql.Named("courier", v.Courier),
+		sql.Named("notes", string(notes)),
+		sql.Named("updated_at", formatTime(v.UpdatedAt)))
+
+	return err
+}
+
+// RunTrackingProjection каждые interval обновляет проекцию отслеживания,
+// пока не отменён ctx. Ошибки записываются в errors.
+func (s ParcelStore) RunTrackingProjection(ctx context.Context, interval time.Duration, errors *ErrorLog) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// за проход применяется порция, остаток журнала — сразу следующими
+		for {
+			n, err := s.ProjectTracking(trackingViewBatch)
+			if err != nil && ctx.Err() == nil {
+				errors.Record(err)
+			}
+			if err != nil || n == 0 || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestProjectTracking проверяет обновление проекции отслеживания по
+// журналу изменений и заметкам
+func TestProjectTracking(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithTrackingView())
+	parcel := getTestParcel()
+	parcel.Address = "Псков, ул. Колотушкина, д. 5"
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+	deleted, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// project
+	n, err := store.ProjectTracking(100)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	// check
+	v, err := store.GetTrackingView(context.Background(), parcel.UUID)
+	require.NoError(t, err)
+	require.Equal(t, number, v.Number)
+	require.Equal(t, ParcelStatusRegistered, v.Status)
+	require.Equal(t, "Псков", v.City)
+	require.False(t, v.ETA.IsZero())
+	require.Empty(t, v.Notes)
+
+	// изменения после прохода применяются следующим
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+	_, err = store.AddNote(Note{Parcel: number, Author: "support", Text: "доставка завтра", Visibility: NoteCustomer})
+	require.NoError(t, err)
+	require.NoError(t, store.Delete(deleted))
+
+	n, err = store.ProjectTracking(100)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	n, err = store.ProjectTracking(100)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	v, err = store.GetTrackingView(context.Background(), parcel.UUID)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, v.Status)
+	require.Len(t, v.Notes, 1)
+	require.Equal(t, "доставка завтра", v.Notes[0].Text)
+
+	var count int
+	require.NoError(t, store.db.QueryRow("SELECT COUNT(*) FROM {tracking_view}").Scan(&count))
+	require.Equal(t, 1, count)
+}
+
+// TestTrackEndpointFromView проверяет, что отслеживание с проекцией отвечает
+// из неё и читает посылку напрямую, пока её нет в проекции
+func TestTrackEndpointFromView(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t), WithTrackingView())
+	parcel := getTestParcel()
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+	handler := NewHTTPHandler(store, NewErrorLog(10))
+
+	track := func() TrackingInfo {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/track/"+parcel.UUID, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var info TrackingInfo
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+		return info
+	}
+
+	// check
+	direct := track()
+	require.Equal(t, ParcelStatusRegistered, direct.Status)
+	require.NotNil(t, direct.ETA)
+
+	_, err = store.ProjectTracking(100)
+	require.NoError(t, err)
+	require.Equal(t, direct, track())
+
+	// проекция отстаёт от посылки до следующего прохода
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+	require.Equal(t, ParcelStatusRegistered, track().Status)
+	_, err = store.ProjectTracking(100)
+	require.NoError(t, err)
+	require.Equal(t, ParcelStatusSent, track().Status)
+}
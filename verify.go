@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrSchemaMismatch = errors.New("схема таблицы посылок не совпадает с ожидаемой")
+
+// parcelSchema — ожидаемые столбцы таблицы parcel и их типы после всех миграций
+var parcelSchema = map[string]string{
+	"number":               "INTEGER",
+	"client":               "INTEGER",
+	"status":               "VARCHAR(128)",
+	"address":              "VARCHAR(512)",
+	"created_at":           "TEXT",
+	"service_level":        "VARCHAR(32)",
+	"cod_amount":           "INTEGER",
+	"cod_collected":        "INTEGER",
+	"cod_collected_amount": "INTEGER",
+	"cod_collected_by":     "VARCHAR(128)",
+	"cod_collected_at":     "TEXT",
+	"country":              "VARCHAR(2)",
+	"postal_code":          "VARCHAR(16)",
+	"zone":                 "VARCHAR(64)",
+	"insured":              "INTEGER",
+	"declared_value":       "INTEGER",
+	"insurance_premium":    "INTEGER",
+	"uuid":                 "VARCHAR(36)",
+	"sent_at":              "TEXT",
+	"delivered_at":         "TEXT",
+	"sender_email":         "VARCHAR(254)",
+	"tenant":               "VARCHAR(64)",
+	"price":                "INTEGER",
+	"locale":               "VARCHAR(8)",
+	"order_id":             "INTEGER",
+	"pickup_point":         "INTEGER",
+	"updated_at":           "TEXT",
+	"metadata":             "TEXT",
+	"recipient_phone":      "VARCHAR(16)",
+	"weight":               "INTEGER",
+	"contents":             "VARCHAR(64)",
+	"promo_code":           "VARCHAR(32)",
+	"discount":             "INTEGER",
+	"currency":             "VARCHAR(3)",
+}
+
+// parcelIndexes — ожидаемые индексы таблицы parcel без префикса
+// и признак уникальности
+var parcelIndexes = map[string]bool{
+	"parcel_uuid_uq":          true,
+	"parcel_client_idx":       false,
+	"parcel_zone_idx":         false,
+	"parcel_order_idx":        false,
+	"parcel_pickup_point_idx": false,
+	"parcel_updated_idx":      false,
+}
+
+// VerifySchema сверяет таблицу посылок с ожидаемой схемой: столбцы, их типы
+// и индексы. Вызывается при запуске, чтобы устаревшая БД обнаружилась сразу,
+// а не на первом запросе. Все расхождения перечисляются в одной ошибке
+// ErrSchemaMismatch; лишние столбцы и индексы расхождением не считаются.
+func (s ParcelStore) VerifySchema(ctx context.Context) error {
+	columns, err := s.tableColumns(ctx)
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("%w: таблица не найдена", ErrSchemaMismatch)
+	}
+
+	indexes, err := s.tableIndexes(ctx)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, name := range sortedKeys(parcelSchema) {
+		want := parcelSchema[name]
+		got, ok := columns[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("нет столбца %s", name))
+		case !strings.EqualFold(got, want):
+			problems = append(problems, fmt.Sprintf("столбец %s имеет тип %s вместо %s", name, got, want))
+		}
+	}
+	for _, name := range sortedKeys(parcelIndexes) {
+		unique, ok := indexes[s.naming.prefix+name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("нет индекса %s", s.naming.prefix+name))
+		case unique != parcelIndexes[name]:
+			problems = append(problems, fmt.Sprintf("индекс %s должен быть уникальным: %t", s.naming.prefix+name, parcelIndexes[name]))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrSchemaMismatch, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// tableColumns возвращает типы столбцов таблицы посылок по имени
+func (s ParcelStore) tableColumns(ctx context.Context) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT name, type FROM pragma_table_info('{prefix}parcel', :schema)",
+		sql.Named("schema", s.naming.schemaName()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := map[string]string{}
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, err
+		}
+		columns[name] = typ
+	}
+
+	return columns, rows.Err()
+}
+
+// tableIndexes возвращает индексы таблицы посылок и признак уникальности
+func (s ParcelStore) tableIndexes(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT name, \"unique\" FROM pragma_index_list('{prefix}parcel', :schema)",
+		sql.Named("schema", s.naming.schemaName()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var unique bool
+		if err := rows.Scan(&name, &unique); err != nil {
+			return nil, err
+		}
+		indexes[name] = unique
+	}
+
+	return indexes, rows.Err()
+}
+
+// sortedKeys возвращает ключи в алфавитном порядке, чтобы текст ошибки
+// не зависел от порядка обхода map
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestVerifySchema проверяет сверку схемы актуальной и устаревшей БД
+func TestVerifySchema(t *testing.T) {
+	// prepare
+	db := openTestDB(t)
+	store := NewParcelStore(db)
+
+	// check
+	require.NoError(t, store.VerifySchema(context.Background()))
+
+	_, err := db.Exec("DROP INDEX parcel_zone_idx")
+	require.NoError(t, err)
+	// столбец с индексом не удалить, индекс удаляется вместе с ним
+	_, err = db.Exec("DROP INDEX parcel_delivered_idx")
+	require.NoError(t, err)
+	_, err = db.Exec("ALTER TABLE parcel DROP COLUMN delivered_at")
+	require.NoError(t, err)
+
+	err = store.VerifySchema(context.Background())
+	require.ErrorIs(t, err, ErrSchemaMismatch)
+	require.ErrorContains(t, err, "нет столбца delivered_at")
+	require.ErrorContains(t, err, "нет индекса parcel_zone_idx")
+}
+
+// TestVerifySchemaPrefix проверяет сверку схемы таблиц с префиксом
+func TestVerifySchemaPrefix(t *testing.T) {
+	// prepare
+	db := openTestDB(t)
+	store := NewParcelStore(db, WithTablePrefix("staging_"))
+
+	// check
+	err := store.VerifySchema(context.Background())
+	require.ErrorIs(t, err, ErrSchemaMismatch)
+	require.ErrorContains(t, err, "таблица не найдена")
+
+	require.NoError(t, store.Migrate())
+	require.NoError(t, store.VerifySchema(context.Background()))
+}
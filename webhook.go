@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/webhooks"
+)
+
+const (
+	// webhookInterval — период сбора и отправки уведомлений партнёрам
+	webhookInterval = 10 * time.Second
+	// webhookBatch — сколько событий собирается и отправляется адресу за проход
+	webhookBatch = 100
+	// webhookTimeout — сколько ждать ответа адреса уведомлений
+	webhookTimeout = 10 * time.Second
+	// MaxWebhookAttempts — после стольких неудачных попыток событие больше
+	// не отправляется
+	MaxWebhookAttempts = 5
+	// MaxWebhookConcurrency — наибольшее число одновременных отправок адресу
+	MaxWebhookConcurrency = 16
+)
+
+// Типы событий уведомлений партнёрам
+const (
+	WebhookParcelCreated = "parcel.created"
+	WebhookParcelUpdated = "parcel.updated"
+)
+
+var ErrInvalidWebhookEndpoint = errors.New("некорректный адрес уведомлений")
+
+// WebhookEndpoint — адрес, на который партнёр получает уведомления
+// об изменениях своих посылок. Secret — ключ подписи уведомлений, см.
+// пакет webhooks; хранится открытым, т.к. нужен для подписи.
+type WebhookEndpoint struct {
+	ID      int    `json:"id"`
+	Partner string `json:"partner"`
+	URL     string `json:"url"`
+	// Version — версия схемы тела уведомлений, см. webhookVersions
+	Version int `json:"version"`
+	// Concurrency — сколько уведомлений адресу отправляется одновременно;
+	// при 1 события уходят строго по порядку
+	Concurrency int `json:"concurrency"`
+	// RateLimit — сколько уведомлений в секунду отправляется адресу, 0 — без ограничения
+	RateLimit float64   `json:"rate_limit"`
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookParcel — посылка в уведомлении партнёру
+type WebhookParcel struct {
+	Number    int       `json:"number"`
+	Code      string    `json:"code"`
+	Status    string    `json:"status"`
+	OrderID   int       `json:"order_id,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookEvent — событие для адреса уведомлений. Data хранится во
+// внутреннем виде, тело запроса собирается из события при отправке по
+// версии схемы адреса, см. webhookVersions.
+type WebhookEvent struct {
+	ID        int             `json:"id"`
+	Endpoint  int             `json:"endpoint"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+	Attempts  int             `json:"attempts"`
+	// DeliveredAt — время успешной отправки, нулевое у неотправленного события
+	DeliveredAt time.Time `json:"delivered_at"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// AddWebhookEndpoint добавляет партнёру адрес уведомлений со схемой тела
+// version, 0 — WebhookVersionLatest, и возвращает его с новым секретом.
+// Уведомления приходят об изменениях после добавления.
+func (s ParcelStore) AddWebhookEndpoint(partner string, rawURL string, version int) (WebhookEndpoint, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return WebhookEndpoint{}, fmt.Errorf("%w: %q", ErrInvalidWebhookEndpoint, rawURL)
+	}
+	if version == 0 {
+		version = WebhookVersionLatest
+	}
+	if _, ok := webhookVersions[version]; !ok {
+		return WebhookEndpoint{}, fmt.Errorf("%w: неизвестная версия схемы %d", ErrInvalidWebhookEndpoint, version)
+	}
+
+	secret, err := newAPIKey()
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	e := WebhookEndpoint{Partner: partner, URL: u.String(), Version: version, Concurrency: 1, Secret: secret, CreatedAt: s.now().UTC()}
+
+	res, err := s.db.Exec("INSERT INTO {webhook_endpoint} (partner, url, version, secret, cursor, created_at) "+
+		"VALUES (:partner, :url, :version, :secret, (SELECT COALESCE(MAX(seq), 0) FROM {parcel_change}), :created_at)",
+		sql.Named("partner", partner),
+		sql.Named("url", e.URL),
+		sql.Named("version", version),
+		sql.Named("secret", secret),
+		sql.Named("created_at", formatTime(e.CreatedAt)))
+	if errors.Is(err, ErrForeignKeyViolation) {
+		return WebhookEndpoint{}, sql.ErrNoRows
+	}
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	e.ID = int(id)
+
+	return e, nil
+}
+
+// ListWebhookEndpoints возвращает адреса уведомлений партнёра без секретов
+func (s ParcelStore) ListWebhookEndpoints(partner string) ([]WebhookEndpoint, error) {
+	rows, err := s.db.Query("SELECT id, partner, url, version, concurrency, rate_limit, created_at "+
+		"FROM {webhook_endpoint} WHERE partner = :partner ORDER BY id",
+		sql.Named("partner", partner))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []WebhookEndpoint
+	for rows.Next() {
+		e := WebhookEndpoint{}
+		if err := rows.Scan(&e.ID, &e.Partner, &e.URL, &e.Version, &e.Concurrency, &e.RateLimit, scanTime(&e.CreatedAt)); err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// SetWebhookLimits задаёт адресу уведомлений id число одновременных
+// отправок concurrency и ограничение rate уведомлений в секунду, 0 — без
+// ограничения. Медленный адрес с малыми пределами не задерживает
+// уведомления другим адресам, см. WebhookDispatcher.
+func (s ParcelStore) SetWebhookLimits(id int, concurrency int, rate float64) error {
+	if concurrency < 1 || concurrency > MaxWebhookConcurrency || rate < 0 {
+		return fmt.Errorf("%w: одновременных отправок от 1 до %d, ограничение не меньше 0",
+			ErrInvalidWebhookEndpoint, MaxWebhookConcurrency)
+	}
+
+	res, err := s.db.Exec("UPDATE {webhook_endpoint} SET concurrency = :concurrency, rate_limit = :rate WHERE id = :id",
+		sql.Named("concurrency", concurrency),
+		sql.Named("rate", rate),
+		sql.Named("id", id))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteWebhookEndpoint удаляет адрес уведомлений вместе с его событиями
+func (s ParcelStore) DeleteWebhookEndpoint(id int) error {
+	res, err := s.db.Exec("DELETE FROM {webhook_endpoint} WHERE id = :id", sql.Named("id", id))
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CollectWebhookEvents записывает события по журналу изменений посылок
+// {parcel_change} для всех адресов уведомлений, до limit изменений на
+// адрес, и возвращает число событий. В события попадают создание и
+// изменение посылок арендатора-партнёра; назначение курьера создаёт
+// изменение, удалённые посылки не сообщаются.
+func (s ParcelStore) CollectWebhookEvents(limit int) (int, error) {
+	rows, err := s.db.Query("SELECT id, partner, cursor FROM {webhook_endpoint} ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+	type endpoint struct {
+		id      int
+		partner string
+		cursor  int
+	}
+	var endpoints []endpoint
+	for rows.Next() {
+		e := endpoint{}
+		if err := rows.Scan(&e.id, &e.partner, &e.cursor); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, e := range endpoints {
+		n, err := s.collectWebhookEvents(e.id, e.partner, e.cursor, limit)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+
+	return total, nil
+}
+
+// collectWebhookEvents записывает события одного адреса после изменения
+// cursor и сдвигает курсор адреса в той же транзакции
+func (s ParcelStore) collectWebhookEvents(endpoint int, partner string, cursor int, limit int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT c.seq, c.kind, c.courier, p.number, p.uuid, p.status, COALESCE(p.order_id, 0), p.updated_at "+
+		"FROM {parcel_change} c JOIN {parcel} p ON p.number = c.parcel "+
+		"WHERE c.seq > :cursor AND p.tenant = :partner AND c.kind <> 'delete' ORDER BY c.seq LIMIT :limit",
+		sql.Named("cursor", cursor),
+		sql.Named("partner", partner),
+		sql.Named("limit", limit))
+	if err != nil {
+		return 0, err
+	}
+	type change struct {
+		typ    string
+		parcel WebhookParcel
+	}
+	var changes []change
+	for rows.Next() {
+		var kind string
+		var courier int
+		c := change{}
+		err := rows.Scan(&cursor, &kind, &courier, &c.parcel.Number, &c.parcel.Code, &c.parcel.Status,
+			&c.parcel.OrderID, scanTime(&c.parcel.UpdatedAt))
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		c.typ = webhookEventType(kind, courier)
+		changes = append(changes, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(changes) == 0 {
+		return 0, nil
+	}
+
+	for _, c := range changes {
+		if err := s.insertWebhookEvent(tx, endpoint, c.typ, c.parcel); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE {webhook_endpoint} SET cursor = :cursor WHERE id = :id",
+		sql.Named("cursor", cursor),
+		sql.Named("id", endpoint))
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(changes), nil
+}
+
+// webhookEventType возвращает тип события по записи журнала изменений
+// посылок вида kind с курьером courier
+func webhookEventType(kind string, courier int) string {
+	// создание с курьером — назначение курьера, а не новая посылка
+	if kind == "create" && courier == 0 {
+		return WebhookParcelCreated
+	}
+
+	return WebhookParcelUpdated
+}
+
+// insertWebhookEvent записывает событие typ о посылке p для адреса endpoint
+func (s ParcelStore) insertWebhookEvent(db execer, endpoint int, typ string, p WebhookParcel) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO {webhook_event} (endpoint, type, data, created_at) "+
+		"VALUES (:endpoint, :type, :data, :created_at)",
+		sql.Named("endpoint", endpoint),
+		sql.Named("type", typ),
+		sql.Named("data", string(data)),
+		sql.Named("created_at", formatTime(s.now())))
+
+	return err
+}
+
+// ListWebhookEvents возвращает до limit последних событий адреса уведомлений
+func (s ParcelStore) ListWebhookEvents(endpoint int, limit int) ([]WebhookEvent, error) {
+	rows, err := s.db.Query("SELECT id, endpoint, type, data, created_at, attempts, delivered_at, last_error "+
+		"FROM {webhook_event} WHERE endpoint = :endpoint ORDER BY id DESC LIMIT :limit",
+		sql.Named("endpoint", endpoint),
+		sql.Named("limit", limit))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanWebhookEvents(rows)
+}
+
+// scanWebhookEvents читает события из rows и закрывает их
+func scanWebhookEvents(rows *sql.Rows) ([]WebhookEvent, error) {
+	defer rows.Close()
+
+	var res []WebhookEvent
+	for rows.Next() {
+		e := WebhookEvent{}
+		var data string
+		err := rows.Scan(&e.ID, &e.Endpoint, &e.Type, &data, scanTime(&e.CreatedAt), &e.Attempts,
+			scanTime(&e.DeliveredAt), &e.LastError)
+		if err != nil {
+			return nil, err
+		}
+		e.Data = json.RawMessage(data)
+		res = append(res, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// getWebhookEndpoint возвращает адрес уведомлений с секретом
+func (s ParcelStore) getWebhookEndpoint(id int) (WebhookEndpoint, error) {
+	e := WebhookEndpoint{ID: id}
+	err := s.db.QueryRow("SELECT partner, url, version, concurrency, rate_limit, secret, created_at FROM {webhook_endpoint} WHERE id = :id",
+		sql.Named("id", id)).Scan(&e.Partner, &e.URL, &e.Version, &e.Concurrency, &e.RateLimit, &e.Secret, scanTime(&e.CreatedAt))
+
+	return e, err
+}
+
+// sendWebhook отправляет событие e на адрес ep
+func (s ParcelStore) sendWebhook(ctx context.Context, client *http.Client, ep WebhookEndpoint, e WebhookEvent) error {
+	body, err := webhookBody(ep.Version, e)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhooks.HeaderVersion, strconv.Itoa(ep.Version))
+	webhooks.SetHeaders(req.Header, []byte(ep.Secret), s.now(), strconv.Itoa(e.ID), body)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("ответ %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// markWebhook отмечает событие отправленным или, при ошибке sendErr,
+// учитывает неудачную попытку
+func (s ParcelStore) markWebhook(id int, sendErr error) error {
+	if sendErr == nil {
+		_, err := s.db.Exec("UPDATE {webhook_event} SET delivered_at = :now WHERE id = :id",
+			sql.Named("now", formatTime(s.now())),
+			sql.Named("id", id))
+		return err
+	}
+
+	_, err := s.db.Exec("UPDATE {webhook_event} SET attempts = attempts + 1, last_error = :error WHERE id = :id",
+		sql.Named("error", sendErr.Error()),
+		sql.Named("id", id))
+
+	return err
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Yandex-Practicum/go-db-sql-final/webhooks"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookReceiver — тестовый адрес уведомлений партнёра
+type webhookReceiver struct {
+	mu       sync.Mutex
+	secret   []byte
+	status   int
+	payloads []webhookPayloadV1
+	headers  []http.Header
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	body, err := webhooks.VerifyRequest(rcv.secret, r, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if rcv.status != 0 {
+		w.WriteHeader(rcv.status)
+		return
+	}
+
+	var p webhookPayloadV1
+	if err := json.Unmarshal(body, &p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rcv.payloads = append(rcv.payloads, p)
+	rcv.headers = append(rcv.headers, r.Header.Clone())
+}
+
+// addTestWebhook регистрирует партнёра acme с адресом уведомлений на
+// тестовом сервере и возвращает получателя уведомлений
+func addTestWebhook(t *testing.T, store ParcelStore) (*webhookReceiver, WebhookEndpoint) {
+	t.Helper()
+
+	_, err := store.RegisterPartner(Partner{ID: "acme", Name: "Acme"})
+	require.NoError(t, err)
+
+	rcv := &webhookReceiver{}
+	srv := httptest.NewServer(rcv)
+	t.Cleanup(srv.Close)
+
+	e, err := store.AddWebhookEndpoint("acme", srv.URL+"/hooks", WebhookVersion1)
+	require.NoError(t, err)
+	rcv.secret = []byte(e.Secret)
+
+	return rcv, e
+}
+
+// TestWebhooks проверяет сбор событий и отправку подписанных уведомлений
+func TestWebhooks(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	// посылки до добавления адреса не сообщаются
+	before := getTestParcel()
+	before.Tenant = "acme"
+	_, err := store.Add(before)
+	require.NoError(t, err)
+	rcv, endpoint := addTestWebhook(t, store)
+
+	parcel := getTestParcel()
+	parcel.Tenant = "acme"
+	number, err := store.Add(parcel)
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(number, ParcelStatusSent))
+	_, err = store.Add(getTestParcel())
+	require.NoError(t, err)
+
+	// collect
+	collected, err := store.CollectWebhookEvents(10)
+	require.NoError(t, err)
+	require.Equal(t, 2, collected)
+	collected, err = store.CollectWebhookEvents(10)
+	require.NoError(t, err)
+	require.Zero(t, collected)
+
+	// deliver
+	sent, err := store.DeliverWebhooks(context.Background(), http.DefaultClient, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, sent)
+
+	// check
+	require.Len(t, rcv.payloads, 2)
+	require.Equal(t, WebhookParcelCreated, rcv.payloads[0].Type)
+	require.Equal(t, WebhookParcelUpdated, rcv.payloads[1].Type)
+	var data WebhookParcel
+	require.NoError(t, json.Unmarshal(rcv.payloads[1].Data, &data))
+	require.Equal(t, parcel.UUID, data.Code)
+	require.Equal(t, ParcelStatusSent, data.Status)
+	require.NotEmpty(t, rcv.headers[0].Get(webhooks.HeaderDelivery))
+	require.Equal(t, "1", rcv.headers[0].Get(webhooks.HeaderVersion))
+
+	events, err := store.ListWebhookEvents(endpoint.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.False(t, events[0].DeliveredAt.IsZero())
+
+	sent, err = store.DeliverWebhooks(context.Background(), http.DefaultClient, 10)
+	require.NoError(t, err)
+	require.Zero(t, sent)
+}
+
+// TestWebhookFailure проверяет учёт неудачных отправок
+func TestWebhookFailure(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	rcv, endpoint := addTestWebhook(t, store)
+	rcv.status = http.StatusServiceUnavailable
+	for i := 0; i < 2; i++ {
+		p := getTestParcel()
+		p.Tenant = "acme"
+		_, err := store.Add(p)
+		require.NoError(t, err)
+	}
+	_, err := store.CollectWebhookEvents(10)
+	require.NoError(t, err)
+
+	// после неудачи остальные события адреса не отправляются
+	sent, err := store.DeliverWebhooks(context.Background(), http.DefaultClient, 10)
+	require.Error(t, err)
+	require.Zero(t, sent)
+
+	events, err := store.ListWebhookEvents(endpoint.ID, 10)
+	require.NoError(t, err)
+	require.Equal(t, 0, events[0].Attempts)
+	require.Equal(t, 1, events[1].Attempts)
+	require.Contains(t, events[1].LastError, "503")
+
+	// событие с исчерпанными попытками больше не отправляется
+	for i := 1; i < MaxWebhookAttempts; i++ {
+		_, err = store.DeliverWebhooks(context.Background(), http.DefaultClient, 10)
+		require.Error(t, err)
+	}
+	rcv.status = 0
+	sent, err = store.DeliverWebhooks(context.Background(), http.DefaultClient, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, sent)
+}
+
+// TestWebhookEndpoints проверяет управление адресами уведомлений
+func TestWebhookEndpoints(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	_, err := store.RegisterPartner(Partner{ID: "acme", Name: "Acme"})
+	require.NoError(t, err)
+
+	// check
+	_, err = store.AddWebhookEndpoint("acme", "ftp://example.com", 0)
+	require.ErrorIs(t, err, ErrInvalidWebhookEndpoint)
+	_, err = store.AddWebhookEndpoint("globex", "https://example.com/hooks", 0)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = store.AddWebhookEndpoint("acme", "https://example.com/hooks", 99)
+	require.ErrorIs(t, err, ErrInvalidWebhookEndpoint)
+
+	e, err := store.AddWebhookEndpoint("acme", "https://example.com/hooks", 0)
+	require.NoError(t, err)
+	require.NotEmpty(t, e.Secret)
+	require.Equal(t, WebhookVersionLatest, e.Version)
+
+	endpoints, err := store.ListWebhookEndpoints("acme")
+	require.NoError(t, err)
+	require.Len(t, endpoints, 1)
+	require.Empty(t, endpoints[0].Secret)
+	require.Equal(t, WebhookVersionLatest, endpoints[0].Version)
+
+	require.NoError(t, store.DeleteWebhookEndpoint(e.ID))
+	require.ErrorIs(t, store.DeleteWebhookEndpoint(e.ID), sql.ErrNoRows)
+}
+
+// TestWebhookVersions проверяет тело уведомления по версиям схемы
+func TestWebhookVersions(t *testing.T) {
+	// prepare
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	data, err := json.Marshal(WebhookParcel{Number: 7, Code: "c0de", Status: ParcelStatusSent, OrderID: 42, UpdatedAt: created})
+	require.NoError(t, err)
+	e := WebhookEvent{ID: 3, Type: WebhookParcelUpdated, Data: data, CreatedAt: created}
+
+	// v1
+	body, err := webhookBody(WebhookVersion1, e)
+	require.NoError(t, err)
+	var v1 webhookPayloadV1
+	require.NoError(t, json.Unmarshal(body, &v1))
+	require.Equal(t, 3, v1.ID)
+	require.JSONEq(t, string(data), string(v1.Data))
+
+	// v2
+	body, err = webhookBody(WebhookVersion2, e)
+	require.NoError(t, err)
+	var v2 webhookPayloadV2
+	require.NoError(t, json.Unmarshal(body, &v2))
+	require.Equal(t, "evt_3", v2.ID)
+	require.Equal(t, WebhookVersion2, v2.APIVersion)
+	require.Equal(t, WebhookParcelUpdated, v2.Event)
+	require.Equal(t, "c0de", v2.Parcel.Code)
+	require.Equal(t, ParcelStatusSent, v2.Parcel.Status.Code)
+	require.Equal(t, StatusName(LocaleEN, ParcelStatusSent), v2.Parcel.Status.Name)
+	require.Equal(t, "42", v2.Parcel.OrderID)
+
+	_, err = webhookBody(99, e)
+	require.Error(t, err)
+}
+
+// TestWebhookDispatcherLimits проверяет, что медленный адрес не задерживает
+// другие, одновременные отправки адресу и ограничение в секунду
+func TestWebhookDispatcherLimits(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	for _, id := range []string{"acme", "globex"} {
+		_, err := store.RegisterPartner(Partner{ID: id, Name: id})
+		require.NoError(t, err)
+	}
+
+	// адрес acme отвечает, только когда получены два его уведомления и
+	// уведомление globex
+	var arrived sync.WaitGroup
+	arrived.Add(3)
+	done := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(done)
+	}()
+	barrier := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		arrived.Done()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}
+	slow := httptest.NewServer(http.HandlerFunc(barrier))
+	t.Cleanup(slow.Close)
+	fast := httptest.NewServer(http.HandlerFunc(barrier))
+	t.Cleanup(fast.Close)
+
+	acme, err := store.AddWebhookEndpoint("acme", slow.URL, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.SetWebhookLimits(acme.ID, 2, 0))
+	globex, err := store.AddWebhookEndpoint("globex", fast.URL, 0)
+	require.NoError(t, err)
+	require.ErrorIs(t, store.SetWebhookLimits(globex.ID, 0, 0), ErrInvalidWebhookEndpoint)
+	require.ErrorIs(t, store.SetWebhookLimits(globex.ID+1, 1, 0), sql.ErrNoRows)
+
+	for _, tenant := range []string{"acme", "acme", "globex"} {
+		p := getTestParcel()
+		p.Tenant = tenant
+		_, err := store.Add(p)
+		require.NoError(t, err)
+	}
+	_, err = store.CollectWebhookEvents(10)
+	require.NoError(t, err)
+
+	// deliver
+	dispatcher := NewWebhookDispatcher(store, http.DefaultClient)
+	sent, err := dispatcher.Deliver(context.Background(), 10)
+
+	// check
+	require.NoError(t, err)
+	require.Equal(t, 3, sent)
+
+	rec := httptest.NewRecorder()
+	dispatcher.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/webhooks", nil))
+	require.Contains(t, rec.Body.String(), `tracker_webhook_deliveries_total{endpoint="1",partner="acme",result="ok"} 2`)
+	require.Contains(t, rec.Body.String(), `tracker_webhook_in_flight{endpoint="2",partner="globex"} 0`)
+
+	// ограничение в секунду растягивает отправки
+	require.NoError(t, store.SetWebhookLimits(globex.ID, 1, 20))
+	for i := 0; i < 3; i++ {
+		p := getTestParcel()
+		p.Tenant = "globex"
+		_, err := store.Add(p)
+		require.NoError(t, err)
+	}
+	_, err = store.CollectWebhookEvents(10)
+	require.NoError(t, err)
+
+	start := time.Now()
+	sent, err = dispatcher.Deliver(context.Background(), 10)
+	require.NoError(t, err)
+	require.Equal(t, 3, sent)
+	require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WebhookDispatcher отправляет уведомления партнёрам. События группируются
+// по адресам, и каждый адрес обслуживается отдельно в пределах своих
+// одновременных отправок и ограничения в секунду, см. SetWebhookLimits,
+// поэтому медленный адрес не задерживает остальные. Диспетчер хранит
+// состояние ограничений между проходами и отдаёт метрики отправок как
+// метрики Prometheus в текстовом формате.
+type WebhookDispatcher struct {
+	store  ParcelStore
+	client *http.Client
+
+	mu       sync.Mutex
+	limiters map[int]*webhookLimiter
+	stats    map[int]*webhookStats
+}
+
+// webhookStats — метрики отправок одному адресу
+type webhookStats struct {
+	partner   string
+	delivered int64
+	failed    int64
+	// seconds — суммарное время отправок
+	seconds float64
+	// throttled — суммарное ожидание из-за ограничения в секунду
+	throttled float64
+	inFlight  int
+}
+
+// NewWebhookDispatcher возвращает диспетчер уведомлений store, который
+// отправляет их через client
+func NewWebhookDispatcher(store ParcelStore, client *http.Client) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		store:    store,
+		client:   client,
+		limiters: map[int]*webhookLimiter{},
+		stats:    map[int]*webhookStats{},
+	}
+}
+
+// DeliverWebhooks отправляет через client до limit неотправленных событий
+// каждого адреса и возвращает число отправленных, см. WebhookDispatcher.
+// Ограничения в секунду действуют только в пределах вызова.
+func (s ParcelStore) DeliverWebhooks(ctx context.Context, client *http.Client, limit int) (int, error) {
+	return NewWebhookDispatcher(s, client).Deliver(ctx, limit)
+}
+
+// Deliver отправляет до limit неотправленных событий каждого адреса и
+// возвращает число отправленных. Адреса обслуживаются параллельно. При
+// одной отправке за раз события адреса уходят по порядку; после неудачи
+// остальные события адреса ждут следующего вызова. Запрос подписывается
+// секретом адреса, см. пакет webhooks; идентификатор доставки — ID события.
+// Возвращает ошибки неудачных отправок.
+func (d *WebhookDispatcher) Deliver(ctx context.Context, limit int) (int, error) {
+	rows, err := d.store.db.QueryContext(ctx, "SELECT DISTINCT endpoint FROM {webhook_event} "+
+		"WHERE delivered_at = '' AND attempts < :max_attempts ORDER BY endpoint",
+		sql.Named("max_attempts", MaxWebhookAttempts))
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	endpoints := make([]WebhookEndpoint, 0, len(ids))
+	for _, id := range ids {
+		ep, err := d.store.getWebhookEndpoint(id)
+		if err != nil {
+			return 0, err
+		}
+		endpoints = append(endpoints, ep)
+	}
+
+	sent := make([]int, len(endpoints))
+	errs := make([]error, len(endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func(i int, ep WebhookEndpoint) {
+			defer wg.Done()
+			sent[i], errs[i] = d.deliverEndpoint(ctx, ep, limit)
+		}(i, ep)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, n := range sent {
+		total += n
+	}
+	if ctx.Err() != nil {
+		return total, ctx.Err()
+	}
+
+	return total, errors.Join(errs...)
+}
+
+// deliverEndpoint отправляет до limit неотправленных событий адреса ep
+func (d *WebhookDispatcher) deliverEndpoint(ctx context.Context, ep WebhookEndpoint, limit int) (int, error) {
+	rows, err := d.store.db.QueryContext(ctx, "SELECT id, endpoint, type, data, created_at, attempts, delivered_at, last_error "+
+		"FROM {webhook_event} WHERE endpoint = :endpoint AND delivered_at = '' AND attempts < :max_attempts "+
+		"ORDER BY id LIMIT :limit",
+		sql.Named("endpoint", ep.ID),
+		sql.Named("max_attempts", MaxWebhookAttempts),
+		sql.Named("limit", limit))
+	if err != nil {
+		return 0, err
+	}
+	events, err := scanWebhookEvents(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	limiter := d.limiter(ep)
+	slots := make(chan struct{}, max(ep.Concurrency, 1))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := false
+	sent := 0
+	var errs []error
+	for _, e := range events {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		mu.Lock()
+		stop := failed
+		mu.Unlock()
+		if stop || ctx.Err() != nil {
+			break
+		}
+
+		waited, err := limiter.wait(ctx, ep.RateLimit)
+		d.record(ep, func(st *webhookStats) { st.throttled += waited.Seconds() })
+		if err != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(e WebhookEvent) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			err := d.send(ctx, ep, e)
+			if err != nil && ctx.Err() != nil {
+				return
+			}
+			markErr := d.store.markWebhook(e.ID, err)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed = true
+				errs = append(errs, fmt.Errorf("уведомление %d на %s: %w", e.ID, ep.URL, err))
+			} else if markErr == nil {
+				sent++
+			}
+			if markErr != nil {
+				errs = append(errs, markErr)
+			}
+		}(e)
+	}
+	wg.Wait()
+
+	return sent, errors.Join(errs...)
+}
+
+// send отправляет событие e на адрес ep и учитывает отправку в метриках
+func (d *WebhookDispatcher) send(ctx context.Context, ep WebhookEndpoint, e WebhookEvent) error {
+	d.record(ep, func(st *webhookStats) { st.inFlight++ })
+	start := time.Now()
+	err := d.store.sendWebhook(ctx, d.client, ep, e)
+	elapsed := time.Since(start)
+
+	d.record(ep, func(st *webhookStats) {
+		st.inFlight--
+		st.seconds += elapsed.Seconds()
+		if err != nil {
+			st.failed++
+		} else {
+			st.delivered++
+		}
+	})
+
+	return err
+}
+
+// limiter возвращает ограничитель отправок адресу ep
+func (d *WebhookDispatcher) limiter(ep WebhookEndpoint) *webhookLimiter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, ok := d.limiters[ep.ID]
+	if !ok {
+		l = &webhookLimiter{}
+		d.limiters[ep.ID] = l
+	}
+
+	return l
+}
+
+// record меняет метрики адреса ep под блокировкой
+func (d *WebhookDispatcher) record(ep WebhookEndpoint, update func(st *webhookStats)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	st, ok := d.stats[ep.ID]
+	if !ok {
+		st = &webhookStats{partner: ep.Partner}
+		d.stats[ep.ID] = st
+	}
+	update(st)
+}
+
+// Run каждые interval собирает события и отправляет их партнёрам, пока
+// не отменён ctx. Ошибки записываются в errors.
+func (d *WebhookDispatcher) Run(ctx context.Context, interval time.Duration, errors *ErrorLog) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := d.store.CollectWebhookEvents(webhookBatch); err != nil && ctx.Err() == nil {
+			errors.Record(err)
+		}
+		if _, err := d.Deliver(ctx, webhookBatch); err != nil && ctx.Err() == nil {
+			errors.Record(err)
+		}
+	}
+}
+
+// ServeHTTP отдаёт метрики отправок по адресам уведомлений
+func (d *WebhookDispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	type row struct {
+		id int
+		webhookStats
+	}
+	d.mu.Lock()
+	rows := make([]row, 0, len(d.stats))
+	for id, st := range d.stats {
+		rows = append(rows, row{id: id, webhookStats: *st})
+	}
+	d.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].id < rows[j].id })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(w, "# HELP tracker_webhook_deliveries_total Отправки уведомлений партнёрам.")
+	fmt.Fprintln(w, "# TYPE tracker_webhook_deliveries_total counter")
+	for _, r := range rows {
+		fmt.Fprintf(w, "tracker_webhook_deliveries_total{endpoint=\"%d\",partner=%q,result=\"ok\"} %d\n", r.id, r.partner, r.delivered)
+		fmt.Fprintf(w, "tracker_webhook_deliveries_total{endpoint=\"%d\",partner=%q,result=\"error\"} %d\n", r.id, r.partner, r.failed)
+	}
+	fmt.Fprintln(w, "# HELP tracker_webhook_delivery_seconds_total Суммарное время отправок уведомлений.")
+	fmt.Fprintln(w, "# TYPE tracker_webhook_delivery_seconds_total counter")
+	for _, r := range rows {
+		fmt.Fprintf(w, "tracker_webhook_delivery_seconds_total{endpoint=\"%d\",partner=%q} %g\n", r.id, r.partner, r.seconds)
+	}
+	fmt.Fprintln(w, "# HELP tracker_webhook_throttled_seconds_total Ожидание из-за ограничения отправок в секунду.")
+	fmt.Fprintln(w, "# TYPE tracker_webhook_throttled_seconds_total counter")
+	for _, r := range rows {
+		fmt.Fprintf(w, "tracker_webhook_throttled_seconds_total{endpoint=\"%d\",partner=%q} %g\n", r.id, r.partner, r.throttled)
+	}
+	fmt.Fprintln(w, "# HELP tracker_webhook_in_flight Уведомления, отправляемые сейчас.")
+	fmt.Fprintln(w, "# TYPE tracker_webhook_in_flight gauge")
+	for _, r := range rows {
+		fmt.Fprintf(w, "tracker_webhook_in_flight{endpoint=\"%d\",partner=%q} %d\n", r.id, r.partner, r.inFlight)
+	}
+}
+
+// webhookLimiter равномерно распределяет отправки адресу во времени
+type webhookLimiter struct {
+	mu sync.Mutex
+	// next — время, раньше которого следующая отправка не начинается
+	next time.Time
+}
+
+// wait ждёт своей очереди на отправку при ограничении rate в секунду,
+// 0 — без ограничения, и возвращает время ожидания
+func (l *webhookLimiter) wait(ctx context.Context, rate float64) (time.Duration, error) {
+	if rate <= 0 {
+		return 0, nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(time.Duration(float64(time.Second) / rate))
+	l.mu.Unlock()
+
+	delay := at.Sub(now)
+	if delay <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return delay, ctx.Err()
+	case <-timer.C:
+		return delay, nil
+	}
+}
@@ -0,0 +1,123 @@
+// Package webhooks подписывает уведомления, которые трекер отправляет
+// партнёрам, и проверяет подпись на стороне партнёра. Партнёру достаточно
+// импортировать этот пакет и вызвать Verify или VerifyRequest с секретом
+// своего адреса уведомлений.
+//
+// Подпись — HMAC-SHA256 от строки {timestamp}.{тело} в hex с префиксом
+// версии схемы v1=, где timestamp — время отправки в секундах Unix из
+// заголовка HeaderTimestamp. Уведомление старше допуска отклоняется, так
+// перехваченный запрос нельзя повторить позже. Повтор в пределах допуска
+// отсекается по идентификатору доставки из HeaderDelivery: трекер при
+// повторной отправке передаёт тот же идентификатор.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Заголовки уведомления
+const (
+	HeaderSignature = "X-Tracker-Signature"
+	HeaderTimestamp = "X-Tracker-Timestamp"
+	// HeaderDelivery — идентификатор уведомления, одинаковый при повторах
+	HeaderDelivery = "X-Tracker-Delivery"
+	// HeaderVersion — версия схемы тела, выбранная при добавлении адреса
+	HeaderVersion = "X-Tracker-Version"
+)
+
+// DefaultTolerance — допустимое расхождение времени отправки и проверки
+const DefaultTolerance = 5 * time.Minute
+
+// signaturePrefix — версия схемы подписи
+const signaturePrefix = "v1="
+
+// maxBody — наибольший размер тела, которое читает VerifyRequest
+const maxBody = 1 << 20
+
+var (
+	ErrNoSignature      = errors.New("уведомление без подписи или времени отправки")
+	ErrInvalidSignature = errors.New("неверная подпись уведомления")
+	ErrStaleTimestamp   = errors.New("время отправки уведомления вне допуска")
+)
+
+// Sign возвращает подпись тела body, отправленного в момент ts, для
+// заголовка HeaderSignature
+func Sign(secret []byte, ts time.Time, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(mac(secret, strconv.FormatInt(ts.Unix(), 10), body))
+}
+
+// SetHeaders подписывает тело body и записывает подпись, время отправки
+// ts и идентификатор доставки delivery в заголовки h
+func SetHeaders(h http.Header, secret []byte, ts time.Time, delivery string, body []byte) {
+	h.Set(HeaderTimestamp, strconv.FormatInt(ts.Unix(), 10))
+	h.Set(HeaderSignature, Sign(secret, ts, body))
+	h.Set(HeaderDelivery, delivery)
+}
+
+// Verify проверяет подпись тела body по заголовкам h. Время отправки
+// должно отличаться от now не больше чем на tolerance, 0 — DefaultTolerance.
+// Заголовок подписи может содержать несколько подписей через запятую,
+// например при смене секрета; достаточно одной подходящей.
+func Verify(secret []byte, h http.Header, body []byte, now time.Time, tolerance time.Duration) error {
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+
+	ts, header := h.Get(HeaderTimestamp), h.Get(HeaderSignature)
+	if ts == "" || header == "" {
+		return ErrNoSignature
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrNoSignature
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return ErrStaleTimestamp
+	}
+
+	expected := mac(secret, ts, body)
+	for _, sig := range strings.Split(header, ",") {
+		sig, ok := strings.CutPrefix(strings.TrimSpace(sig), signaturePrefix)
+		if !ok {
+			continue
+		}
+		got, err := hex.DecodeString(sig)
+		if err == nil && hmac.Equal(got, expected) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+// VerifyRequest читает тело запроса уведомления и проверяет его подпись
+// на текущее время. Возвращает тело, если подпись верна.
+func VerifyRequest(secret []byte, r *http.Request, tolerance time.Duration) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody))
+	if err != nil {
+		return nil, err
+	}
+	if err := Verify(secret, r.Header, body, time.Now(), tolerance); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// mac возвращает HMAC-SHA256 от {ts}.{body}
+func mac(secret []byte, ts string, body []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(ts))
+	m.Write([]byte("."))
+	m.Write(body)
+
+	return m.Sum(nil)
+}
@@ -0,0 +1,48 @@
+package webhooks
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestVerify проверяет подпись уведомления и допуск по времени
+func TestVerify(t *testing.T) {
+	// prepare
+	secret := []byte("secret")
+	body := []byte(`{"type": "parcel.updated"}`)
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	h := http.Header{}
+	SetHeaders(h, secret, now, "42", body)
+
+	// check
+	require.Equal(t, "42", h.Get(HeaderDelivery))
+	require.NoError(t, Verify(secret, h, body, now.Add(time.Minute), 0))
+	require.ErrorIs(t, Verify([]byte("other"), h, body, now, 0), ErrInvalidSignature)
+	require.ErrorIs(t, Verify(secret, h, []byte(`{}`), now, 0), ErrInvalidSignature)
+	require.ErrorIs(t, Verify(secret, h, body, now.Add(DefaultTolerance+time.Second), 0), ErrStaleTimestamp)
+	require.ErrorIs(t, Verify(secret, h, body, now.Add(-time.Hour), time.Minute), ErrStaleTimestamp)
+	require.ErrorIs(t, Verify(secret, http.Header{}, body, now, 0), ErrNoSignature)
+
+	// при смене секрета подходит любая из подписей
+	h.Set(HeaderSignature, Sign([]byte("old"), now, body)+", "+Sign(secret, now, body))
+	require.NoError(t, Verify(secret, h, body, now, 0))
+}
+
+// TestVerifyRequest проверяет разбор запроса уведомления
+func TestVerifyRequest(t *testing.T) {
+	// prepare
+	secret := []byte("secret")
+	body := `{"type": "parcel.updated"}`
+	r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	SetHeaders(r.Header, secret, time.Now(), "1", []byte(body))
+
+	// check
+	got, err := VerifyRequest(secret, r, 0)
+	require.NoError(t, err)
+	require.Equal(t, body, string(got))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Версии схемы тела уведомлений партнёрам. Новая версия добавляется
+// в webhookVersions, прежние не меняются: адрес получает ту схему,
+// которую выбрал при добавлении.
+const (
+	WebhookVersion1      = 1
+	WebhookVersion2      = 2
+	WebhookVersionLatest = WebhookVersion2
+)
+
+// webhookVersions — преобразования события во внутреннем виде в тело
+// уведомления по версиям схемы
+var webhookVersions = map[int]func(e WebhookEvent) (any, error){
+	WebhookVersion1: webhookV1,
+	WebhookVersion2: webhookV2,
+}
+
+// webhookBody возвращает тело уведомления о событии e по схеме version
+func webhookBody(version int, e WebhookEvent) ([]byte, error) {
+	transform, ok := webhookVersions[version]
+	if !ok {
+		return nil, fmt.Errorf("неизвестная версия схемы уведомлений %d", version)
+	}
+
+	payload, err := transform(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(payload)
+}
+
+// webhookPayloadV1 — тело уведомления v1: посылка в data как есть
+type webhookPayloadV1 struct {
+	ID        int             `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func webhookV1(e WebhookEvent) (any, error) {
+	return webhookPayloadV1{ID: e.ID, Type: e.Type, CreatedAt: e.CreatedAt, Data: e.Data}, nil
+}
+
+// webhookPayloadV2 — тело уведомления v2: строковый идентификатор
+// события, версия в теле и статус посылки с названием
+type webhookPayloadV2 struct {
+	ID         string          `json:"id"`
+	APIVersion int             `json:"api_version"`
+	Event      string          `json:"event"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Parcel     webhookParcelV2 `json:"parcel"`
+}
+
+type webhookParcelV2 struct {
+	Code      string          `json:"code"`
+	Number    int             `json:"number"`
+	Status    webhookStatusV2 `json:"status"`
+	OrderID   string          `json:"order_id,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+type webhookStatusV2 struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+func webhookV2(e WebhookEvent) (any, error) {
+	var p WebhookParcel
+	if err := json.Unmarshal(e.Data, &p); err != nil {
+		return nil, err
+	}
+
+	v2 := webhookPayloadV2{
+		ID:         "evt_" + strconv.Itoa(e.ID),
+		APIVersion: WebhookVersion2,
+		Event:      e.Type,
+		OccurredAt: e.CreatedAt,
+		Parcel: webhookParcelV2{
+			Code:      p.Code,
+			Number:    p.Number,
+			Status:    webhookStatusV2{Code: p.Status, Name: StatusName(LocaleEN, p.Status)},
+			UpdatedAt: p.UpdatedAt,
+		},
+	}
+	if p.OrderID != 0 {
+		v2.Parcel.OrderID = strconv.Itoa(p.OrderID)
+	}
+
+	return v2, nil
+}
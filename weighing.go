@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultReweighThreshold — на какую долю заявленного вес посылки при
+// взвешивании на складе может превысить заявленный без пересчёта стоимости
+const DefaultReweighThreshold = 0.1
+
+var ErrInvalidMeasurement = errors.New("некорректный результат взвешивания")
+
+// WithReweighThreshold задаёт долю превышения заявленного веса, после
+// которой стоимость доставки пересчитывается, см. RecordMeasuredWeight.
+// Паникует на отрицательной доле.
+func WithReweighThreshold(threshold float64) StoreOption {
+	if threshold < 0 {
+		panic(fmt.Sprintf("%v: доля превышения %v", ErrInvalidMeasurement, threshold))
+	}
+
+	return func(s *ParcelStore) {
+		s.reweighThreshold = threshold
+	}
+}
+
+// WeightMeasurement — взвешивание посылки на складе
+type WeightMeasurement struct {
+	ID     int `json:"id"`
+	Parcel int `json:"parcel"`
+	// DeclaredGrams — заявленный отправителем вес на момент взвешивания,
+	// 0 — вес не заявлен
+	DeclaredGrams int64  `json:"declared_grams"`
+	MeasuredGrams int64  `json:"measured_grams"`
+	Operator      string `json:"operator"`
+	PriceBefore   int64  `json:"price_before"`
+	PriceAfter    int64  `json:"price_after"`
+	// Repriced — стоимость доставки пересчитана по измеренному весу
+	Repriced   bool      `json:"repriced"`
+	MeasuredAt time.Time `json:"measured_at"`
+}
+
+// exceeds сообщает, что измеренный вес больше заявленного больше чем на
+// долю threshold. Без заявленного веса сравнивать не с чем.
+func (m WeightMeasurement) exceeds(threshold float64) bool {
+	return m.DeclaredGrams > 0 && float64(m.MeasuredGrams) > float64(m.DeclaredGrams)*(1+threshold)
+}
+
+// RecordMeasuredWeight записывает вес посылки, измеренный оператором
+// склада. Если измеренный вес превышает заявленный больше чем на долю
+// WithReweighThreshold, стоимость доставки пересчитывается пропорционально
+// весу с округлением вверх от стоимости до первого взвешивания посылки,
+// иначе возвращается к ней: каждое взвешивание задаёт стоимость заново,
+// а не умножает уже пересчитанную. Скидка посылки не меняется. Заявленный
+// вес посылки остаётся прежним: расхождение видно в WeightDiscrepancies.
+// Взвешивают посылку до доставки, в статусе registered или sent.
+func (s ParcelStore) RecordMeasuredWeight(number int, grams int64, operator string) (WeightMeasurement, error) {
+	switch {
+	case grams <= 0:
+		return WeightMeasurement{}, fmt.Errorf("%w: вес должен быть положительным", ErrInvalidMeasurement)
+	case strings.TrimSpace(operator) == "":
+		return WeightMeasurement{}, fmt.Errorf("%w: не указан оператор", ErrInvalidMeasurement)
+	}
+
+	var m WeightMeasurement
+	err := s.audited(context.Background(), number, AuditReprice, func() (err error) {
+		m, err = s.measureWeight(number, grams, operator)
+		return err
+	})
+
+	return m, err
+}
+
+// measureWeight в одной транзакции пересчитывает стоимость и сохраняет взвешивание
+func (s ParcelStore) measureWeight(number int, grams int64, operator string) (WeightMeasurement, error) {
+	// транзакция начинается с BEGIN IMMEDIATE, поэтому вес и стоимость
+	// не изменятся между чтением и пересчётом
+	tx, err := s.db.Begin()
+	if err != nil {
+		return WeightMeasurement{}, err
+	}
+	defer tx.Rollback()
+
+	m := WeightMeasurement{Parcel: number, MeasuredGrams: grams, Operator: operator, MeasuredAt: s.now().UTC()}
+	var status string
+	err = tx.QueryRow("SELECT status, weight, price FROM {parcel} WHERE number = :number",
+		sql.Named("number", number)).Scan(&status, &m.DeclaredGrams, &m.PriceBefore)
+	if err != nil {
+		return WeightMeasurement{}, err
+	}
+	if status != ParcelStatusRegistered && status != ParcelStatusSent {
+		return WeightMeasurement{}, fmt.Errorf("%w: посылка в статусе %s", ErrInvalidMeasurement, status)
+	}
+
+	// стоимость пересчитывается от стоимости до первого взвешивания, иначе
+	// каждое повторное взвешивание умножало бы уже пересчитанную
+	base := m.PriceBefore
+	err = tx.QueryRow("SELECT price_before FROM {weight_measurement} WHERE parcel = :parcel ORDER BY id LIMIT 1",
+		sql.Named("parcel", number)).Scan(&base)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return WeightMeasurement{}, err
+	}
+
+	m.PriceAfter = base
+	if base > 0 && m.exceeds(s.reweighThreshold) {
+		m.PriceAfter = (base*grams + m.DeclaredGrams - 1) / m.DeclaredGrams
+	}
+	if m.PriceAfter != m.PriceBefore {
+		m.Repriced = true
+		_, err := tx.Exec("UPDATE {parcel} SET price = :price WHERE number = :number",
+			sql.Named("price", m.PriceAfter),
+			sql.Named("number", number))
+		if err != nil {
+			return WeightMeasurement{}, err
+		}
+	}
+
+	res, err := tx.Exec("INSERT INTO {weight_measurement} (parcel, declared, measured, operator, price_before, "+
+		"price_after, repriced, measured_at) VALUES (:parcel, :declared, :measured, :operator, :price_before, "+
+		":price_after, :repriced, :measured_at)",
+		sql.Named("parcel", number),
+		sql.Named("declared", m.DeclaredGrams),
+		sql.Named("measured", grams),
+		sql.Named("operator", operator),
+		sql.Named("price_before", m.PriceBefore),
+		sql.Named("price_after", m.PriceAfter),
+		sql.Named("repriced", m.Repriced),
+		sql.Named("measured_at", formatTime(m.MeasuredAt)))
+	if err != nil {
+		return WeightMeasurement{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return WeightMeasurement{}, err
+	}
+	m.ID = int(id)
+
+	if err := tx.Commit(); err != nil {
+		return WeightMeasurement{}, err
+	}
+
+	return m, nil
+}
+
+// WeightDiscrepancyReport — взвешивания за период [From, To), при которых
+// измеренный вес отличается от заявленного больше допустимого в любую
+// сторону или вес не был заявлен
+type WeightDiscrepancyReport struct {
+	From         time.Time
+	To           time.Time
+	Measurements []WeightMeasurement
+}
+
+// WeightDiscrepancies строит отчёт о расхождениях веса за период [from, to)
+// с долей расхождения WithReweighThreshold
+func (s ParcelStore) WeightDiscrepancies(from time.Time, to time.Time) (WeightDiscrepancyReport, error) {
+	report := WeightDiscrepancyReport{From: from.UTC(), To: to.UTC()}
+	rows, err := s.db.Query("SELECT id, parcel, declared, measured, operator, price_before, price_after, repriced, "+
+		"measured_at FROM {weight_measurement} WHERE measured_at >= :from AND measured_at < :to "+
+		"AND (declared = 0 OR ABS(measured - declared) > declared * :threshold) ORDER BY measured_at, id",
+		sql.Named("from", formatTime(report.From)),
+		sql.Named("to", formatTime(report.To)),
+		sql.Named("threshold", s.reweighThreshold))
+	if err != nil {
+		return WeightDiscrepancyReport{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m := WeightMeasurement{}
+		err := rows.Scan(&m.ID, &m.Parcel, &m.DeclaredGrams, &m.MeasuredGrams, &m.Operator, &m.PriceBefore,
+			&m.PriceAfter, &m.Repriced, scanTime(&m.MeasuredAt))
+		if err != nil {
+			return WeightDiscrepancyReport{}, err
+		}
+		report.Measurements = append(report.Measurements, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return WeightDiscrepancyReport{}, err
+	}
+
+	return report, nil
+}
+
+// WriteCSV записывает отчёт в CSV: строка на взвешивание, вес в граммах,
+// расхождение — доля заявленного веса, пустое без заявленного веса
+func (r WeightDiscrepancyReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"measured_at", "parcel", "operator", "declared_grams", "measured_grams",
+		"discrepancy", "price_before", "price_after", "repriced"}); err != nil {
+		return err
+	}
+
+	for _, m := range r.Measurements {
+		discrepancy := ""
+		if m.DeclaredGrams > 0 {
+			discrepancy = strconv.FormatFloat(float64(m.MeasuredGrams-m.DeclaredGrams)/float64(m.DeclaredGrams), 'f', 4, 64)
+		}
+		err := cw.Write([]string{m.MeasuredAt.Format(time.RFC3339), strconv.Itoa(m.Parcel), m.Operator,
+			strconv.FormatInt(m.DeclaredGrams, 10), strconv.FormatInt(m.MeasuredGrams, 10), discrepancy,
+			strconv.FormatInt(m.PriceBefore, 10), strconv.FormatInt(m.PriceAfter, 10), strconv.FormatBool(m.Repriced)})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestRecordMeasuredWeight проверяет пересчёт стоимости при превышении
+// заявленного веса и отчёт о расхождениях
+func TestRecordMeasuredWeight(t *testing.T) {
+	// prepare
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	store := NewParcelStore(openTestDB(t), WithClock(clock), WithCreatedAtWindow(0, 0))
+	add := func(weight int64, price int64) int {
+		p := getTestParcel()
+		p.CreatedAt = time.Time{}
+		p.Weight = weight
+		p.Price = price
+		number, err := store.Add(p)
+		require.NoError(t, err)
+		return number
+	}
+	heavy, light, undeclared := add(1000, 300), add(1000, 300), add(0, 300)
+
+	// check
+	_, err := store.RecordMeasuredWeight(heavy, 0, "ivanov")
+	require.ErrorIs(t, err, ErrInvalidMeasurement)
+	_, err = store.RecordMeasuredWeight(heavy, 1000, " ")
+	require.ErrorIs(t, err, ErrInvalidMeasurement)
+
+	// в пределах допуска стоимость не меняется
+	m, err := store.RecordMeasuredWeight(light, 1100, "ivanov")
+	require.NoError(t, err)
+	require.False(t, m.Repriced)
+	require.Equal(t, int64(300), m.PriceAfter)
+
+	m, err = store.RecordMeasuredWeight(heavy, 1501, "ivanov")
+	require.NoError(t, err)
+	require.True(t, m.Repriced)
+	require.Equal(t, int64(1000), m.DeclaredGrams)
+	require.Equal(t, int64(300), m.PriceBefore)
+	require.Equal(t, int64(451), m.PriceAfter)
+	require.True(t, start.Equal(m.MeasuredAt))
+	got, err := store.Get(heavy)
+	require.NoError(t, err)
+	require.Equal(t, int64(451), got.Price)
+	require.Equal(t, int64(1000), got.Weight)
+
+	// повторное взвешивание не умножает уже пересчитанную стоимость,
+	// а в пределах допуска возвращает исходную
+	again := add(1000, 300)
+	for _, want := range []struct {
+		grams    int64
+		price    int64
+		repriced bool
+	}{{2000, 600, true}, {2000, 600, false}, {3000, 900, true}, {1050, 300, true}} {
+		m, err := store.RecordMeasuredWeight(again, want.grams, "ivanov")
+		require.NoError(t, err)
+		require.Equal(t, want.price, m.PriceAfter, want.grams)
+		require.Equal(t, want.repriced, m.Repriced, want.grams)
+	}
+	got, err = store.Get(again)
+	require.NoError(t, err)
+	require.Equal(t, int64(300), got.Price)
+
+	_, err = store.RecordMeasuredWeight(undeclared, 700, "petrov")
+	require.NoError(t, err)
+
+	require.NoError(t, store.SetStatus(light, ParcelStatusDelivered))
+	_, err = store.RecordMeasuredWeight(light, 1000, "ivanov")
+	require.ErrorIs(t, err, ErrInvalidMeasurement)
+
+	report, err := store.WeightDiscrepancies(start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, report.Measurements, 5)
+	require.Equal(t, heavy, report.Measurements[0].Parcel)
+	require.Equal(t, undeclared, report.Measurements[4].Parcel)
+
+	var buf bytes.Buffer
+	require.NoError(t, report.WriteCSV(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 6)
+	require.Equal(t, "measured_at,parcel,operator,declared_grams,measured_grams,discrepancy,price_before,price_after,repriced", lines[0])
+	require.Equal(t, "2024-03-01T09:00:00Z,"+strconv.Itoa(heavy)+",ivanov,1000,1501,0.5010,300,451,true", lines[1])
+	require.Equal(t, "2024-03-01T09:00:00Z,"+strconv.Itoa(undeclared)+",petrov,0,700,,300,300,false", lines[5])
+}
+
+func TestWeightHandlers(t *testing.T) {
+	// prepare
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(day.Add(time.Hour))
+	store := NewParcelStore(openTestDB(t), WithClock(clock), WithCreatedAtWindow(0, 0), WithReweighThreshold(0))
+	p := getTestParcel()
+	p.CreatedAt = time.Time{}
+	p.Weight = 500
+	p.Price = 100
+	number, err := store.Add(p)
+	require.NoError(t, err)
+	handler := NewAdminHandler(store, NewErrorLog(10))
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/weight", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	parcel := strconv.Itoa(number)
+
+	// check
+	require.Equal(t, http.StatusBadRequest, post(url.Values{"parcel": {parcel}, "grams": {"тяжёлая"}, "operator": {"ivanov"}}).Code)
+	require.Equal(t, http.StatusBadRequest, post(url.Values{"parcel": {parcel}, "grams": {"600"}}).Code)
+	require.Equal(t, http.StatusNotFound, post(url.Values{"parcel": {"100000"}, "grams": {"600"}, "operator": {"ivanov"}}).Code)
+	rec := post(url.Values{"parcel": {parcel}, "grams": {"600"}, "operator": {"ivanov"}})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"price_after":120`)
+	require.Contains(t, rec.Body.String(), `"repriced":true`)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/reports/weight?from=вчера", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// по умолчанию отчёт за прошлые сутки
+	clock.Set(day.AddDate(0, 0, 1).Add(time.Hour))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/reports/weight", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	require.Contains(t, rec.Body.String(), ","+parcel+",ivanov,500,600,0.2000,100,120,true")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrZoneNotFound = errors.New("зона доставки не найдена")
+	ErrInvalidZone  = errors.New("некорректная зона доставки")
+)
+
+// Zone сопоставляет страну и префикс почтового индекса зоне доставки.
+// Пустой префикс задаёт зону по умолчанию для всей страны.
+type Zone struct {
+	ID           int
+	Country      string
+	PostalPrefix string
+	Name         string
+}
+
+// ZoneStats — количество посылок зоны доставки в разрезе статусов
+type ZoneStats struct {
+	Zone       string
+	Total      int
+	Registered int
+	Sent       int
+	Delivered  int
+}
+
+// AddZone добавляет правило сопоставления зоны доставки
+func (s ParcelStore) AddZone(z Zone) (int, error) {
+	z.Country = strings.ToUpper(z.Country)
+	if len(z.Country) != 2 || z.Name == "" {
+		return 0, ErrInvalidZone
+	}
+
+	res, err := s.db.Exec("INSERT INTO {zone} (country, postal_prefix, name) VALUES (:country, :postal_prefix, :name)",
+		sql.Named("country", z.Country),
+		sql.Named("postal_prefix", z.PostalPrefix),
+		sql.Named("name", z.Name))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// ListZones возвращает все правила сопоставления зон доставки
+func (s ParcelStore) ListZones() ([]Zone, error) {
+	rows, err := s.db.Query("SELECT id, country, postal_prefix, name FROM {zone} ORDER BY country, postal_prefix")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Zone
+	for rows.Next() {
+		z := Zone{}
+		err := rows.Scan(&z.ID, &z.Country, &z.PostalPrefix, &z.Name)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, z)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// DeleteZone удаляет правило сопоставления зоны доставки.
+// Зоны уже добавленных посылок при этом не меняются.
+func (s ParcelStore) DeleteZone(id int) error {
+	_, err := s.db.Exec("DELETE FROM {zone} WHERE id = :id", sql.Named("id", id))
+
+	return err
+}
+
+// ResolveZone определяет зону доставки по стране и почтовому индексу.
+// Выбирается правило с самым длинным совпавшим префиксом индекса.
+func (s ParcelStore) ResolveZone(country string, postalCode string) (string, error) {
+	row := s.db.QueryRow("SELECT name FROM {zone} "+
+		"WHERE country = :country AND substr(:postal_code, 1, length(postal_prefix)) = postal_prefix "+
+		"ORDER BY length(postal_prefix) DESC LIMIT 1",
+		sql.Named("country", strings.ToUpper(country)),
+		sql.Named("postal_code", postalCode))
+
+	var zone string
+	err := row.Scan(&zone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrZoneNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return zone, nil
+}
+
+// GroupByZone возвращает количество посылок по зонам доставки.
+// Посылки без определённой зоны попадают в группу с пустым именем.
+func (s ParcelStore) GroupByZone() ([]ZoneStats, error) {
+	rows, err := s.db.Query("SELECT zone, COUNT(*), "+
+		"SUM(CASE WHEN status = :registered THEN 1 ELSE 0 END), "+
+		"SUM(CASE WHEN status = :sent THEN 1 ELSE 0 END), "+
+		"SUM(CASE WHEN status = :delivered THEN 1 ELSE 0 END) "+
+		"FROM {parcel} GROUP BY zone ORDER BY zone",
+		sql.Named("registered", ParcelStatusRegistered),
+		sql.Named("sent", ParcelStatusSent),
+		sql.Named("delivered", ParcelStatusDelivered))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []ZoneStats
+	for rows.Next() {
+		z := ZoneStats{}
+		err := rows.Scan(&z.Zone, &z.Total, &z.Registered, &z.Sent, &z.Delivered)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, z)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// addTestZones добавляет тестовые правила зон доставки
+func addTestZones(t *testing.T, store ParcelStore) {
+	t.Helper()
+
+	for _, z := range []Zone{
+		{Country: "RU", PostalPrefix: "", Name: "russia"},
+		{Country: "RU", PostalPrefix: "1", Name: "moscow-region"},
+		{Country: "RU", PostalPrefix: "10", Name: "moscow"},
+		{Country: "KZ", PostalPrefix: "", Name: "cis"},
+	} {
+		_, err := store.AddZone(z)
+		require.NoError(t, err)
+	}
+}
+
+// TestResolveZone проверяет выбор зоны по самому длинному префиксу индекса
+func TestResolveZone(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	addTestZones(t, store)
+
+	// check
+	for _, c := range []struct {
+		country    string
+		postalCode string
+		zone       string
+	}{
+		{"RU", "101000", "moscow"},
+		{"ru", "141400", "moscow-region"},
+		{"RU", "180000", "moscow-region"},
+		{"RU", "620000", "russia"},
+		{"KZ", "050000", "cis"},
+	} {
+		zone, err := store.ResolveZone(c.country, c.postalCode)
+		require.NoError(t, err)
+		require.Equal(t, c.zone, zone, c.postalCode)
+	}
+
+	_, err := store.ResolveZone("DE", "10115")
+	require.ErrorIs(t, err, ErrZoneNotFound)
+}
+
+// TestAddStampsZone проверяет определение зоны при добавлении посылки
+func TestAddStampsZone(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	addTestZones(t, store)
+
+	parcel := getTestParcel()
+	parcel.Country = "ru"
+	parcel.PostalCode = "101000"
+
+	// add
+	id, err := store.Add(parcel)
+	require.NoError(t, err)
+
+	// check
+	stored, err := store.Get(id)
+	require.NoError(t, err)
+	require.Equal(t, "RU", stored.Country)
+	require.Equal(t, "moscow", stored.Zone)
+
+	// посылка в страну без зоны добавляется без зоны
+	parcel.UUID = ""
+	parcel.Country = "DE"
+	id, err = store.Add(parcel)
+	require.NoError(t, err)
+	stored, err = store.Get(id)
+	require.NoError(t, err)
+	require.Empty(t, stored.Zone)
+}
+
+// TestGroupByZone проверяет группировку посылок по зонам
+func TestGroupByZone(t *testing.T) {
+	// prepare
+	store := NewParcelStore(openTestDB(t))
+	addTestZones(t, store)
+
+	// add
+	for _, postalCode := range []string{"101000", "101001", "620000"} {
+		parcel := getTestParcel()
+		parcel.Country = "RU"
+		parcel.PostalCode = postalCode
+		_, err := store.Add(parcel)
+		require.NoError(t, err)
+	}
+	id, err := store.Add(getTestParcel())
+	require.NoError(t, err)
+	require.NoError(t, store.SetStatus(id, ParcelStatusSent))
+
+	// check
+	stats, err := store.GroupByZone()
+	require.NoError(t, err)
+	require.Equal(t, []ZoneStats{
+		{Zone: "", Total: 1, Sent: 1},
+		{Zone: "moscow", Total: 2, Registered: 2},
+		{Zone: "russia", Total: 1, Registered: 1},
+	}, stats)
+}